@@ -0,0 +1,35 @@
+// Command encrypt-serials encrypts gun serial numbers stored as plaintext and
+// fills in their blind indexes. After rotating keys, run it again to
+// re-encrypt serial numbers sealed with an older key, before removing that key
+// from FIELD_ENCRYPTION_KEYS.
+//
+//	go run ./cmd/encrypt-serials
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/fieldcrypt"
+	"github.com/hail2skins/armory/internal/models"
+)
+
+func main() {
+	batchSize := flag.Int("batch", 500, "number of guns to read at a time")
+	flag.Parse()
+
+	keyring, err := fieldcrypt.Default()
+	if err != nil {
+		log.Fatalf("Failed to load field encryption keys: %v", err)
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	updated, err := models.EncryptGunSerials(db.GetDB(), *batchSize)
+	if err != nil {
+		log.Fatalf("Failed after updating %d serial numbers: %v", updated, err)
+	}
+	log.Printf("Updated %d serial numbers, all are now encrypted with key %q", updated, keyring.Primary())
+}
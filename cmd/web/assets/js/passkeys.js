@@ -0,0 +1,82 @@
+// Passkey registration and sign in. The server sends and expects binary values
+// as unpadded base64url strings.
+(function () {
+  function toBuffer(value) {
+    var base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+    var binary = atob(base64 + "===".slice((base64.length + 3) % 4));
+    var bytes = new Uint8Array(binary.length);
+    for (var i = 0; i < binary.length; i++) {
+      bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes.buffer;
+  }
+
+  function toBase64URL(buffer) {
+    var bytes = new Uint8Array(buffer);
+    var binary = "";
+    for (var i = 0; i < bytes.length; i++) {
+      binary += String.fromCharCode(bytes[i]);
+    }
+    return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+  }
+
+  function post(url, csrfToken, body) {
+    return fetch(url, {
+      method: "POST",
+      credentials: "same-origin",
+      headers: { "Content-Type": "application/json", "X-CSRF-Token": csrfToken },
+      body: body ? JSON.stringify(body) : null,
+    }).then(function (response) {
+      return response.json().then(function (data) {
+        if (!response.ok) {
+          throw new Error(data.error || "Something went wrong, please try again");
+        }
+        return data;
+      });
+    });
+  }
+
+  function supported() {
+    return !!(window.PublicKeyCredential && navigator.credentials);
+  }
+
+  // register creates a passkey for the signed in user
+  function register(csrfToken, name) {
+    return post("/owner/profile/passkeys/options", csrfToken).then(function (options) {
+      options.challenge = toBuffer(options.challenge);
+      options.user.id = toBuffer(options.user.id);
+      options.excludeCredentials.forEach(function (credential) {
+        credential.id = toBuffer(credential.id);
+      });
+      return navigator.credentials.create({ publicKey: options });
+    }).then(function (credential) {
+      return post("/owner/profile/passkeys", csrfToken, {
+        name: name,
+        id: credential.id,
+        clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+        attestationObject: toBase64URL(credential.response.attestationObject),
+      });
+    });
+  }
+
+  // signIn signs in with any passkey the user has for the site
+  function signIn(csrfToken) {
+    return post("/login/passkey/options", csrfToken).then(function (options) {
+      options.challenge = toBuffer(options.challenge);
+      options.allowCredentials.forEach(function (credential) {
+        credential.id = toBuffer(credential.id);
+      });
+      return navigator.credentials.get({ publicKey: options });
+    }).then(function (credential) {
+      return post("/login/passkey", csrfToken, {
+        id: credential.id,
+        clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+        authenticatorData: toBase64URL(credential.response.authenticatorData),
+        signature: toBase64URL(credential.response.signature),
+        userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : "",
+      });
+    });
+  }
+
+  window.Passkeys = { supported: supported, register: register, signIn: signIn };
+})();
@@ -81,6 +81,10 @@ type AdminData struct {
 	// For brands
 	Brands []models.Brand
 	Brand  *models.Brand
+
+	// For public ranges
+	Ranges []models.Range
+	Range  *models.Range
 }
 
 // ErrorEntry represents a simplified error record for views
@@ -223,6 +227,18 @@ func (a *AdminData) WithBrand(brand *models.Brand) *AdminData {
 	return a
 }
 
+// WithRanges returns a copy of the AdminData with ranges
+func (a *AdminData) WithRanges(ranges []models.Range) *AdminData {
+	a.Ranges = ranges
+	return a
+}
+
+// WithRange returns a copy of the AdminData with a range
+func (a *AdminData) WithRange(r *models.Range) *AdminData {
+	a.Range = r
+	return a
+}
+
 // WithRoles returns a copy of the AdminData with user roles
 func (a *AdminData) WithRoles(roles []string) *AdminData {
 	// Call the parent WithRoles
@@ -14,6 +14,7 @@ type AuthData struct {
 	CurrentPath     string      // Current route path for navigation highlighting
 	ActivePromotion interface{} // Active promotion data
 	CSRFToken       string      // CSRF token for form protection
+	OIDCProvider    string      // Name of the OpenID Connect provider users can sign in with, if there is one
 
 	// SEO-related fields
 	MetaDescription string                 // Page-specific meta description
@@ -6,6 +6,8 @@ import (
 
 	"github.com/hail2skins/armory/internal/database"
 	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/csvimport"
+	"github.com/hail2skins/armory/internal/services/entitlements"
 )
 
 // UserViewModel represents user data for display in views
@@ -35,6 +37,60 @@ type OwnerData struct {
 	TotalAmmoPaid     float64
 	TotalAmmoExpended int64
 
+	// For range days
+	RangeDays  []models.RangeDay
+	RangeDay   *models.RangeDay
+	FilterDate string
+
+	// For ranges
+	Ranges       []models.Range
+	Range        *models.Range
+	RangeHistory []models.RangeHistory
+
+	// For CSV imports
+	Import *csvimport.Preview
+
+	// How the owner's plan limits stand, shown on the dashboard
+	Allowances []entitlements.Allowance
+
+	// Warns the owner their subscription payment failed, empty when it hasn't
+	PaymentNotice string
+
+	// For two-factor authentication
+	TwoFactor *TwoFactorSetup
+
+	// For passkeys
+	Passkeys []models.Passkey
+
+	// For the owner's signed in sessions
+	Sessions         []models.Session
+	CurrentSessionID uint
+
+	// For accounts linked through an OpenID Connect provider
+	OIDCProvider   string // What the provider is called, or empty when signing in with one isn't set up
+	OIDCIdentities []models.OIDCIdentity
+
+	// For personal access tokens
+	APITokens   []models.APIToken
+	NewAPIToken string // Only shown straight after it's created
+
+	// For data exports
+	DataExport            *models.DataExport
+	DataExportDownloadURL string // Only set while the export can be downloaded
+
+	// For photo attachments
+	Attachments  []models.Attachment
+	StorageUsed  int64
+	StorageQuota int64
+
+	// For gun maintenance
+	RoundCount         int
+	GunRoundCounts     map[uint]int
+	MaintenanceRecords []models.MaintenanceRecord
+	ServiceIntervals   []models.ServiceInterval
+	ServiceStatuses    []models.ServiceStatus
+	DueServices        []models.ServiceStatus
+
 	// For gun form
 	WeaponTypes   []models.WeaponType
 	Calibers      []models.Caliber
@@ -52,6 +108,7 @@ type OwnerData struct {
 	HasStripeManagedSubscription bool
 	SubscriptionTier             string
 	SubscriptionEndsAt           string
+	PlanChanges                  []models.Plan // Plans the Stripe subscription can move to
 
 	// For payment history
 	Payments []models.Payment
@@ -83,6 +140,15 @@ type OwnerData struct {
 	Note string
 }
 
+// TwoFactorSetup holds what the owner's two-factor authentication page shows
+type TwoFactorSetup struct {
+	Enabled         bool
+	Secret          string   // The new secret being set up, grouped for typing in
+	ProvisioningURI string   // The otpauth:// URI for the new secret
+	RecoveryCodes   []string // Only shown straight after they're generated
+	RemainingCodes  int64
+}
+
 // NewOwnerData creates a new OwnerData with default values
 func NewOwnerData() *OwnerData {
 	return &OwnerData{
@@ -145,6 +211,149 @@ func (o *OwnerData) WithAmmo(ammo []models.Ammo) *OwnerData {
 	return o
 }
 
+// WithRangeDays returns a copy of the OwnerData with range days
+func (o *OwnerData) WithRangeDays(rangeDays []models.RangeDay) *OwnerData {
+	o.RangeDays = rangeDays
+	return o
+}
+
+// WithRangeDay returns a copy of the OwnerData with a range day
+func (o *OwnerData) WithRangeDay(rangeDay *models.RangeDay) *OwnerData {
+	o.RangeDay = rangeDay
+	return o
+}
+
+// WithRanges returns a copy of the OwnerData with ranges
+func (o *OwnerData) WithRanges(ranges []models.Range) *OwnerData {
+	o.Ranges = ranges
+	return o
+}
+
+// WithRange returns a copy of the OwnerData with a range
+func (o *OwnerData) WithRange(r *models.Range) *OwnerData {
+	o.Range = r
+	return o
+}
+
+// WithRangeHistory returns a copy of the OwnerData with the owner's range history
+func (o *OwnerData) WithRangeHistory(history []models.RangeHistory) *OwnerData {
+	o.RangeHistory = history
+	return o
+}
+
+// WithImport returns a copy of the OwnerData with a CSV import preview
+func (o *OwnerData) WithImport(preview *csvimport.Preview) *OwnerData {
+	o.Import = preview
+	return o
+}
+
+// WithAllowances returns a copy of the OwnerData with how the owner's plan limits stand
+func (o *OwnerData) WithAllowances(allowances ...entitlements.Allowance) *OwnerData {
+	o.Allowances = allowances
+	return o
+}
+
+// WithPaymentNotice returns a copy of the OwnerData warning the owner their subscription payment failed
+func (o *OwnerData) WithPaymentNotice(notice string) *OwnerData {
+	o.PaymentNotice = notice
+	return o
+}
+
+// WithTwoFactor returns a copy of the OwnerData with the owner's two-factor authentication setup
+func (o *OwnerData) WithTwoFactor(setup *TwoFactorSetup) *OwnerData {
+	o.TwoFactor = setup
+	return o
+}
+
+// WithPasskeys returns a copy of the OwnerData with the owner's passkeys
+func (o *OwnerData) WithPasskeys(passkeys []models.Passkey) *OwnerData {
+	o.Passkeys = passkeys
+	return o
+}
+
+// WithSessions returns a copy of the OwnerData with the owner's active sessions
+// and which of them is the one they're using
+func (o *OwnerData) WithSessions(sessions []models.Session, currentID uint) *OwnerData {
+	o.Sessions = sessions
+	o.CurrentSessionID = currentID
+	return o
+}
+
+// WithLinkedAccounts returns a copy of the OwnerData with the provider the
+// owner can sign in with and the accounts there they've linked
+func (o *OwnerData) WithLinkedAccounts(provider string, identities []models.OIDCIdentity) *OwnerData {
+	o.OIDCProvider = provider
+	o.OIDCIdentities = identities
+	return o
+}
+
+// WithDataExport returns a copy of the OwnerData with the owner's latest data
+// export and, while it can be downloaded, a signed link to it
+func (o *OwnerData) WithDataExport(export *models.DataExport, downloadURL string) *OwnerData {
+	o.DataExport = export
+	o.DataExportDownloadURL = downloadURL
+	return o
+}
+
+// WithAPITokens returns a copy of the OwnerData with the owner's personal
+// access tokens and, straight after creating one, the new token itself
+func (o *OwnerData) WithAPITokens(tokens []models.APIToken, newToken string) *OwnerData {
+	o.APITokens = tokens
+	o.NewAPIToken = newToken
+	return o
+}
+
+// WithAttachments returns a copy of the OwnerData with the photos attached to a gun or
+// ammunition lot, and how much of their photo storage the owner is using
+func (o *OwnerData) WithAttachments(attachments []models.Attachment, used int64, quota int64) *OwnerData {
+	o.Attachments = attachments
+	o.StorageUsed = used
+	o.StorageQuota = quota
+	return o
+}
+
+// WithFilterDate returns a copy of the OwnerData with the date used to filter range days
+func (o *OwnerData) WithFilterDate(date string) *OwnerData {
+	o.FilterDate = date
+	return o
+}
+
+// WithRoundCount returns a copy of the OwnerData with a gun's lifetime round count
+func (o *OwnerData) WithRoundCount(roundCount int) *OwnerData {
+	o.RoundCount = roundCount
+	return o
+}
+
+// WithGunRoundCounts returns a copy of the OwnerData with lifetime round counts keyed by gun ID
+func (o *OwnerData) WithGunRoundCounts(roundCounts map[uint]int) *OwnerData {
+	o.GunRoundCounts = roundCounts
+	return o
+}
+
+// WithMaintenanceRecords returns a copy of the OwnerData with maintenance records
+func (o *OwnerData) WithMaintenanceRecords(records []models.MaintenanceRecord) *OwnerData {
+	o.MaintenanceRecords = records
+	return o
+}
+
+// WithServiceIntervals returns a copy of the OwnerData with service intervals
+func (o *OwnerData) WithServiceIntervals(intervals []models.ServiceInterval) *OwnerData {
+	o.ServiceIntervals = intervals
+	return o
+}
+
+// WithServiceStatuses returns a copy of the OwnerData with the status of each service interval
+func (o *OwnerData) WithServiceStatuses(statuses []models.ServiceStatus) *OwnerData {
+	o.ServiceStatuses = statuses
+	return o
+}
+
+// WithDueServices returns a copy of the OwnerData with service intervals that are due
+func (o *OwnerData) WithDueServices(due []models.ServiceStatus) *OwnerData {
+	o.DueServices = due
+	return o
+}
+
 // WithWeaponTypes returns a copy of the OwnerData with weapon types
 func (o *OwnerData) WithWeaponTypes(weaponTypes []models.WeaponType) *OwnerData {
 	o.WeaponTypes = weaponTypes
@@ -183,6 +392,12 @@ func (o *OwnerData) WithStripeSubscriptionInfo(hasStripeManagedSubscription bool
 	return o
 }
 
+// WithPlanChanges returns a copy of OwnerData with the plans the subscription can move to
+func (o *OwnerData) WithPlanChanges(plans []models.Plan) *OwnerData {
+	o.PlanChanges = plans
+	return o
+}
+
 // WithPayments returns a copy of the OwnerData with payment history
 func (o *OwnerData) WithPayments(payments []models.Payment) *OwnerData {
 	o.Payments = payments
@@ -20,7 +20,8 @@ type UserListData struct {
 // UserDetailData contains data for the user detail view
 type UserDetailData struct {
 	AuthData
-	User models.User
+	User           models.User
+	SecurityEvents []models.SecurityEvent // The user's most recent security events, newest first
 }
 
 // UserEditData contains data for the user edit view
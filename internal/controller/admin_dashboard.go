@@ -253,6 +253,11 @@ func (u UserWrapper) IsVerified() bool {
 	return u.User.Verified
 }
 
+// GetLockedUntil implements the User interface
+func (u UserWrapper) GetLockedUntil() time.Time {
+	return u.User.LockedUntil()
+}
+
 // GetSubscriptionStatus implements the User interface
 func (u UserWrapper) GetSubscriptionStatus() string {
 	return u.User.SubscriptionStatus
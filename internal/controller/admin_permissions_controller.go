@@ -112,6 +112,7 @@ func (c *AdminPermissionsController) NewRole(ctx *gin.Context) {
 		"bullet_styles",
 		"grains",
 		"brands",
+		"ranges",
 		"*", // Wildcard for all resources
 	}
 
@@ -149,7 +150,7 @@ func (c *AdminPermissionsController) CreateRole(ctx *gin.Context) {
 			"dashboard", "stripe_security", "manufacturers", "calibers",
 			"weapon_types", "promotions", "permissions", "ammo",
 			"users", "payments", "guns", "feature_flags", "munitions",
-			"casings", "bullet_styles", "grains", "brands",
+			"casings", "bullet_styles", "grains", "brands", "ranges",
 		},
 		Actions: []string{
 			"read", "create", "update", "delete", "manage",
@@ -179,7 +180,7 @@ func (c *AdminPermissionsController) StoreRole(ctx *gin.Context) {
 				"dashboard", "stripe_security", "manufacturers", "calibers",
 				"weapon_types", "promotions", "permissions", "ammo",
 				"users", "payments", "guns", "feature_flags", "munitions",
-				"casings", "bullet_styles", "grains", "brands",
+				"casings", "bullet_styles", "grains", "brands", "ranges",
 			},
 			Actions: []string{
 				"read", "create", "update", "delete", "manage",
@@ -210,7 +211,7 @@ func (c *AdminPermissionsController) StoreRole(ctx *gin.Context) {
 				"dashboard", "stripe_security", "manufacturers", "calibers",
 				"weapon_types", "promotions", "permissions", "ammo",
 				"users", "payments", "guns", "feature_flags", "munitions",
-				"casings", "bullet_styles", "grains", "brands",
+				"casings", "bullet_styles", "grains", "brands", "ranges",
 			},
 			Actions: []string{
 				"read", "create", "update", "delete", "manage",
@@ -285,7 +286,7 @@ func (c *AdminPermissionsController) EditRole(ctx *gin.Context) {
 			"dashboard", "stripe_security", "manufacturers", "calibers",
 			"weapon_types", "promotions", "permissions", "ammo",
 			"users", "payments", "guns", "feature_flags", "munitions",
-			"casings", "bullet_styles", "grains", "brands",
+			"casings", "bullet_styles", "grains", "brands", "ranges",
 		},
 		Actions: []string{
 			"read", "create", "update", "delete", "manage",
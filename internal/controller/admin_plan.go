@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/admin/payment"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+)
+
+// AdminPlanController lets admins manage the subscription plans: their
+// prices, how they're billed and what they entitle subscribers to
+type AdminPlanController struct {
+	db database.Service
+}
+
+// NewAdminPlanController creates a new admin plan controller
+func NewAdminPlanController(db database.Service) *AdminPlanController {
+	return &AdminPlanController{
+		db: db,
+	}
+}
+
+// Index lists every plan, including inactive ones
+func (a *AdminPlanController) Index(c *gin.Context) {
+	adminData := getAdminPaymentDataFromContext(c, "Plans", "/admin/plans")
+	adminData.AuthData = adminData.AuthData.WithSuccess(c.Query("success")).WithError(c.Query("error"))
+
+	plans, err := models.FindPlans(a.db.GetDB())
+	if err != nil {
+		logger.Error("Failed to load plans", err, nil)
+		c.String(http.StatusInternalServerError, "Failed to load plans")
+		return
+	}
+
+	payment.Plans(&payment.PlansData{
+		AdminData: adminData,
+		Plans:     plans,
+	}).Render(c.Request.Context(), c.Writer)
+}
+
+// New shows the form for a new plan
+func (a *AdminPlanController) New(c *gin.Context) {
+	a.renderForm(c, &models.Plan{Currency: "usd", Active: true}, "", http.StatusOK)
+}
+
+// Create stores a new plan
+func (a *AdminPlanController) Create(c *gin.Context) {
+	plan := &models.Plan{Tier: strings.ToLower(strings.TrimSpace(c.PostForm("tier")))}
+	a.save(c, plan)
+}
+
+// Edit shows the form for a plan
+func (a *AdminPlanController) Edit(c *gin.Context) {
+	plan, ok := a.findPlan(c)
+	if !ok {
+		return
+	}
+	a.renderForm(c, plan, "", http.StatusOK)
+}
+
+// Update changes a plan. Its tier can't be changed, as users are on it.
+func (a *AdminPlanController) Update(c *gin.Context) {
+	plan, ok := a.findPlan(c)
+	if !ok {
+		return
+	}
+	a.save(c, plan)
+}
+
+// save fills the plan from the form and stores it, showing the form again if it isn't valid
+func (a *AdminPlanController) save(c *gin.Context, plan *models.Plan) {
+	if err := planFromForm(c, plan); err != nil {
+		a.renderForm(c, plan, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if err := models.SavePlan(a.db.GetDB(), plan); err != nil {
+		a.renderForm(c, plan, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.Info("Plan saved", map[string]interface{}{
+		"tier":   plan.Tier,
+		"price":  plan.Price,
+		"active": plan.Active,
+	})
+	c.Redirect(http.StatusSeeOther, "/admin/plans?success="+url.QueryEscape(fmt.Sprintf("%s plan saved", plan.Name)))
+}
+
+// planFromForm copies the submitted fields into the plan. A Stripe price can't
+// change its amount, so the saved price ID is dropped when the price, currency
+// or interval changes, unless a different one is given with the change.
+func planFromForm(c *gin.Context, plan *models.Plan) error {
+	previous := *plan
+
+	plan.Name = c.PostForm("name")
+	plan.Description = strings.TrimSpace(c.PostForm("description"))
+	plan.Features = strings.ReplaceAll(strings.TrimSpace(c.PostForm("features")), "\r\n", "\n")
+	plan.Interval = c.PostForm("interval")
+	plan.StripePriceID = strings.TrimSpace(c.PostForm("stripe_price_id"))
+	plan.Active = c.PostForm("active") == "on"
+
+	price, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(c.DefaultPostForm("price", "0")), "$"), 64)
+	if err != nil {
+		return fmt.Errorf("price must be an amount in dollars")
+	}
+	plan.Price = int64(math.Round(price * 100))
+
+	fields := []struct {
+		name  string
+		label string
+		value *int
+	}{
+		{"rank", "rank", &plan.Rank},
+		{"max_guns", "max guns", &plan.MaxGuns},
+		{"max_ammo", "max ammunition", &plan.MaxAmmo},
+	}
+	for _, field := range fields {
+		n, err := strconv.Atoi(strings.TrimSpace(c.DefaultPostForm(field.name, "0")))
+		if err != nil {
+			return fmt.Errorf("%s must be a whole number", field.label)
+		}
+		*field.value = n
+	}
+
+	quota, err := strconv.ParseInt(strings.TrimSpace(c.DefaultPostForm("storage_quota_mb", "0")), 10, 64)
+	if err != nil {
+		return fmt.Errorf("photo storage must be a whole number of megabytes")
+	}
+	plan.StorageQuota = quota << 20
+
+	billingChanged := plan.Price != previous.Price || plan.Currency != previous.Currency || plan.Interval != previous.Interval
+	if billingChanged && plan.StripePriceID == previous.StripePriceID {
+		plan.StripePriceID = ""
+	}
+	return nil
+}
+
+// renderForm shows the new or edit form for a plan
+func (a *AdminPlanController) renderForm(c *gin.Context, plan *models.Plan, formError string, status int) {
+	title := "New Plan"
+	if plan.ID != 0 {
+		title = "Edit Plan"
+	}
+	adminData := getAdminPaymentDataFromContext(c, title, "/admin/plans")
+	adminData.AuthData = adminData.AuthData.WithError(formError)
+
+	c.Status(status)
+	payment.PlanForm(&payment.PlanFormData{
+		AdminData: adminData,
+		Plan:      plan,
+	}).Render(c.Request.Context(), c.Writer)
+}
+
+// findPlan loads the plan named in the path, responding if it can't
+func (a *AdminPlanController) findPlan(c *gin.Context) (*models.Plan, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid plan ID")
+		return nil, false
+	}
+	plan, err := models.FindPlanByID(a.db.GetDB(), uint(id))
+	if err != nil {
+		logger.Error("Failed to load plan", err, map[string]interface{}{
+			"id": id,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load plan")
+		return nil, false
+	}
+	if plan == nil {
+		c.String(http.StatusNotFound, "Plan not found")
+		return nil, false
+	}
+	return plan, true
+}
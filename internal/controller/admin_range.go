@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/admin/ranges"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/geocode"
+)
+
+// AdminRangeController handles admin routes for the public range list
+type AdminRangeController struct {
+	db       database.Service
+	geocoder geocode.Geocoder
+}
+
+// NewAdminRangeController creates a new admin range controller
+func NewAdminRangeController(db database.Service) *AdminRangeController {
+	return &AdminRangeController{
+		db:       db,
+		geocoder: newGeocoder(),
+	}
+}
+
+// SetGeocoder sets the geocoder used to place ranges on the map
+func (c *AdminRangeController) SetGeocoder(geocoder geocode.Geocoder) {
+	c.geocoder = geocoder
+}
+
+// getAdminRangeDataFromContext gets admin data from context
+func getAdminRangeDataFromContext(ctx *gin.Context, title string, currentPath string) *data.AdminData {
+	// Get admin data from context
+	adminDataInterface, exists := ctx.Get("admin_data")
+	if exists && adminDataInterface != nil {
+		if adminData, ok := adminDataInterface.(*data.AdminData); ok {
+			// Update the title and current path
+			adminData.AuthData = adminData.AuthData.WithTitle(title).WithCurrentPath(currentPath)
+			return adminData
+		}
+	}
+
+	// Get auth data from context
+	authDataInterface, exists := ctx.Get("authData")
+	if exists && authDataInterface != nil {
+		if authData, ok := authDataInterface.(data.AuthData); ok {
+			// Set the title and current path
+			authData = authData.WithTitle(title).WithCurrentPath(currentPath)
+
+			// Create admin data with auth data
+			adminData := data.NewAdminData()
+			adminData.AuthData = authData
+			return adminData
+		}
+	}
+
+	// If we couldn't get auth data from context, create a new one
+	adminData := data.NewAdminData()
+	adminData.AuthData = adminData.AuthData.WithTitle(title).WithCurrentPath(currentPath)
+	return adminData
+}
+
+// Index lists the public ranges
+func (c *AdminRangeController) Index(ctx *gin.Context) {
+	// Get admin data from context
+	adminData := getAdminRangeDataFromContext(ctx, "Public Ranges", ctx.Request.URL.Path)
+
+	// Get success message from query params
+	if success := ctx.Query("success"); success != "" {
+		adminData = adminData.WithSuccess(success)
+	}
+
+	// Get the public ranges
+	publicRanges, err := models.FindPublicRanges(c.db.GetDB())
+	if err != nil {
+		logger.Error("Failed to fetch public ranges", err, nil)
+		ctx.Writer.WriteHeader(http.StatusInternalServerError)
+		ranges.Index(adminData.WithError("Failed to retrieve ranges")).Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+
+	// Render the template with the ranges
+	ranges.Index(adminData.WithRanges(publicRanges)).Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// New shows the form to add a public range
+func (c *AdminRangeController) New(ctx *gin.Context) {
+	// Get admin data from context
+	adminData := getAdminRangeDataFromContext(ctx, "New Range", ctx.Request.URL.Path)
+
+	ranges.Form(adminData.WithRange(&models.Range{})).Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Create adds a public range
+func (c *AdminRangeController) Create(ctx *gin.Context) {
+	// Get admin data from context
+	adminData := getAdminRangeDataFromContext(ctx, "Create Range", ctx.Request.URL.Path)
+
+	// Build the public range from the form
+	r := &models.Range{}
+	if formErrors := parseRangeForm(ctx, r); len(formErrors) > 0 {
+		c.renderFormError(ctx, adminData, r, firstRangeFormError(formErrors))
+		return
+	}
+
+	// Place the range on the map unless coordinates were entered, then validate and save it
+	geocodeRange(ctx.Request.Context(), c.geocoder, r)
+	if err := models.CreateRangeWithValidation(c.db.GetDB(), r); err != nil {
+		c.renderFormError(ctx, adminData, r, adminRangeErrorMessage(err))
+		return
+	}
+
+	// Redirect to the index page with a success message
+	ctx.Redirect(http.StatusSeeOther, "/admin/ranges?success=Range created successfully")
+}
+
+// Edit shows the form to edit a public range
+func (c *AdminRangeController) Edit(ctx *gin.Context) {
+	// Get admin data from context
+	adminData := getAdminRangeDataFromContext(ctx, "Edit Range", ctx.Request.URL.Path)
+
+	// Get the public range
+	r, err := c.findPublicRange(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.templ", adminData.WithError("Range not found"))
+		return
+	}
+
+	ranges.Form(adminData.WithRange(r)).Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// Update updates a public range
+func (c *AdminRangeController) Update(ctx *gin.Context) {
+	// Get admin data from context
+	adminData := getAdminRangeDataFromContext(ctx, "Update Range", ctx.Request.URL.Path)
+
+	// Get the public range
+	r, err := c.findPublicRange(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.templ", adminData.WithError("Range not found"))
+		return
+	}
+	previous := *r
+
+	// Apply the form to the range
+	if formErrors := parseRangeForm(ctx, r); len(formErrors) > 0 {
+		c.renderFormError(ctx, adminData, r, firstRangeFormError(formErrors))
+		return
+	}
+
+	// A moved range whose coordinates weren't touched is placed on the map again
+	if rangeAddressChanged(&previous, r) && sameCoordinates(&previous, r) {
+		r.Latitude, r.Longitude = nil, nil
+	}
+	geocodeRange(ctx.Request.Context(), c.geocoder, r)
+
+	// Validate and save the range
+	if err := models.UpdateRangeWithValidation(c.db.GetDB(), r); err != nil {
+		c.renderFormError(ctx, adminData, r, adminRangeErrorMessage(err))
+		return
+	}
+
+	// Redirect to the index page with a success message
+	ctx.Redirect(http.StatusSeeOther, "/admin/ranges?success=Range updated successfully")
+}
+
+// Delete deletes a public range that has no range days logged at it
+func (c *AdminRangeController) Delete(ctx *gin.Context) {
+	// Get admin data from context
+	adminData := getAdminRangeDataFromContext(ctx, "Delete Range", ctx.Request.URL.Path)
+
+	// Get the public range
+	r, err := c.findPublicRange(ctx)
+	if err != nil {
+		ctx.HTML(http.StatusNotFound, "error.templ", adminData.WithError("Range not found"))
+		return
+	}
+
+	// Keep ranges that owners have logged range days at so their history isn't lost
+	db := c.db.GetDB()
+	inUse, err := models.RangeInUse(db, r.ID)
+	if err == nil && inUse {
+		publicRanges, _ := models.FindPublicRanges(db)
+		ctx.Writer.WriteHeader(http.StatusConflict)
+		ranges.Index(adminData.WithRanges(publicRanges).WithError("Range has range days logged at it and can't be deleted")).Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+	if err != nil || models.DeleteRange(db, r.ID) != nil {
+		ctx.HTML(http.StatusInternalServerError, "error.templ", adminData.WithError("Failed to delete range"))
+		return
+	}
+
+	// Redirect to the index page with a success message
+	ctx.Redirect(http.StatusSeeOther, "/admin/ranges?success=Range deleted successfully")
+}
+
+// findPublicRange loads the public range named by the :id param
+func (c *AdminRangeController) findPublicRange(ctx *gin.Context) (*models.Range, error) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := models.FindRangeByID(c.db.GetDB(), uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if !r.IsPublic() {
+		return nil, models.ErrRangeNotOwned
+	}
+	return r, nil
+}
+
+// renderFormError re-renders the range form with the admin's input and an error message
+func (c *AdminRangeController) renderFormError(ctx *gin.Context, adminData *data.AdminData, r *models.Range, errMsg string) {
+	ctx.Writer.WriteHeader(http.StatusUnprocessableEntity)
+	ranges.Form(adminData.WithRange(r).WithError(errMsg)).Render(ctx.Request.Context(), ctx.Writer)
+}
+
+// firstRangeFormError returns one of the range form's field errors to show above the form
+func firstRangeFormError(formErrors map[string]string) string {
+	for _, field := range []string{"latitude", "longitude"} {
+		if errMsg, ok := formErrors[field]; ok {
+			return errMsg
+		}
+	}
+	return "Please check the range details"
+}
+
+// adminRangeErrorMessage returns the message to show for a failed range save
+func adminRangeErrorMessage(err error) string {
+	if _, ok := rangeErrorField(err); ok {
+		return rangeErrorMessage(err)
+	}
+	logger.Error("Failed to save range", err, nil)
+	return "Failed to save range"
+}
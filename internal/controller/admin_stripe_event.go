@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/admin/payment"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/stripe"
+)
+
+// stripeEventsPageSize is how many events the admin list shows
+const stripeEventsPageSize = 100
+
+// AdminStripeEventController lets admins inspect received Stripe webhook
+// events and replay the ones that failed
+type AdminStripeEventController struct {
+	db            database.Service
+	stripeService stripe.Service
+}
+
+// NewAdminStripeEventController creates a new admin Stripe event controller
+func NewAdminStripeEventController(db database.Service, stripeService stripe.Service) *AdminStripeEventController {
+	return &AdminStripeEventController{
+		db:            db,
+		stripeService: stripeService,
+	}
+}
+
+// Index lists received events, the failed ones unless another status is asked for
+func (a *AdminStripeEventController) Index(c *gin.Context) {
+	adminData := getAdminPaymentDataFromContext(c, "Stripe Events", "/admin/stripe-events")
+	adminData.AuthData = adminData.AuthData.WithSuccess(c.Query("success")).WithError(c.Query("error"))
+
+	status := c.DefaultQuery("status", models.StripeEventFailed)
+	filter := status
+	if status == "all" {
+		filter = ""
+	}
+
+	db := a.db.GetDB()
+	events, err := models.FindStripeEvents(db, filter, stripeEventsPageSize)
+	if err != nil {
+		logger.Error("Failed to load Stripe events", err, nil)
+		c.String(http.StatusInternalServerError, "Failed to load Stripe events")
+		return
+	}
+	counts, err := models.CountStripeEventsByStatus(db)
+	if err != nil {
+		logger.Error("Failed to count Stripe events", err, nil)
+		c.String(http.StatusInternalServerError, "Failed to load Stripe events")
+		return
+	}
+
+	payment.StripeEvents(&payment.StripeEventsData{
+		AdminData: adminData,
+		Events:    events,
+		Status:    status,
+		Counts:    counts,
+	}).Render(c.Request.Context(), c.Writer)
+}
+
+// Show shows one event with its payload and the error from its last attempt
+func (a *AdminStripeEventController) Show(c *gin.Context) {
+	event, ok := a.findEvent(c)
+	if !ok {
+		return
+	}
+
+	adminData := getAdminPaymentDataFromContext(c, "Stripe Event", "/admin/stripe-events")
+	adminData.AuthData = adminData.AuthData.WithSuccess(c.Query("success")).WithError(c.Query("error"))
+
+	// Indent the payload to make it readable, leaving it as it is if it isn't JSON
+	payload := event.Payload
+	var indented bytes.Buffer
+	if json.Indent(&indented, []byte(event.Payload), "", "  ") == nil {
+		payload = indented.String()
+	}
+
+	payment.StripeEvent(&payment.StripeEventData{
+		AdminData: adminData,
+		Event:     event,
+		Payload:   payload,
+	}).Render(c.Request.Context(), c.Writer)
+}
+
+// Replay processes a failed event again
+func (a *AdminStripeEventController) Replay(c *gin.Context) {
+	event, ok := a.findEvent(c)
+	if !ok {
+		return
+	}
+	eventPath := fmt.Sprintf("/admin/stripe-events/%d", event.ID)
+
+	err := a.stripeService.ReplayEvent(event.ID)
+	switch {
+	case errors.Is(err, stripe.ErrEventAlreadyProcessed):
+		c.Redirect(http.StatusSeeOther, eventPath+"?error="+url.QueryEscape("This event has already been processed"))
+	case errors.Is(err, stripe.ErrEventInProgress):
+		c.Redirect(http.StatusSeeOther, eventPath+"?error="+url.QueryEscape("This event is being processed, try again shortly"))
+	case err != nil:
+		c.Redirect(http.StatusSeeOther, eventPath+"?error="+url.QueryEscape("Replay failed: "+err.Error()))
+	default:
+		logger.Info("Stripe event replayed", map[string]interface{}{
+			"event_id": event.EventID,
+			"type":     event.Type,
+		})
+		c.Redirect(http.StatusSeeOther, eventPath+"?success="+url.QueryEscape("Event replayed successfully"))
+	}
+}
+
+// findEvent loads the event named in the path, responding if it can't
+func (a *AdminStripeEventController) findEvent(c *gin.Context) (*models.StripeEvent, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid event ID")
+		return nil, false
+	}
+	event, err := models.FindStripeEventByID(a.db.GetDB(), uint(id))
+	if err != nil {
+		logger.Error("Failed to load Stripe event", err, map[string]interface{}{
+			"id": id,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load Stripe event")
+		return nil, false
+	}
+	if event == nil {
+		c.String(http.StatusNotFound, "Stripe event not found")
+		return nil, false
+	}
+	return event, true
+}
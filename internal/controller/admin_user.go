@@ -11,6 +11,7 @@ import (
 	"github.com/hail2skins/armory/cmd/web/views/admin"
 	"github.com/hail2skins/armory/cmd/web/views/data"
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
 	"gorm.io/gorm"
 )
@@ -127,6 +128,11 @@ func (c *AdminUserController) Show(ctx *gin.Context) {
 	authData := getAuthData(ctx)
 	authData = authData.WithTitle("User Details").WithCurrentPath(ctx.Request.URL.Path)
 
+	// Check for success message in query params
+	if success := ctx.Query("success"); success != "" {
+		authData = authData.WithSuccess(success)
+	}
+
 	// Get user ID from URL
 	userID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
@@ -145,10 +151,17 @@ func (c *AdminUserController) Show(ctx *gin.Context) {
 		return
 	}
 
+	// Get the user's recent security events, such as their account locking
+	events, err := c.DB.FindSecurityEvents(user.ID, 20)
+	if err != nil {
+		authData = authData.WithError(fmt.Sprintf("Error loading security events: %v", err))
+	}
+
 	// Create data for the template
 	userData := &data.UserDetailData{
-		AuthData: authData,
-		User:     UserWrapper{User: *user},
+		AuthData:       authData,
+		User:           UserWrapper{User: *user},
+		SecurityEvents: events,
 	}
 
 	// Render the user detail page
@@ -257,6 +270,13 @@ func (c *AdminUserController) Delete(ctx *gin.Context) {
 		return
 	}
 
+	// Sign the user out everywhere
+	if _, err := models.RevokeUserSessions(c.DB.GetDB(), user.ID, 0); err != nil {
+		logger.Error("Failed to revoke sessions for deleted user", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+	}
+
 	// Redirect to user list with success message
 	ctx.Redirect(http.StatusSeeOther, "/admin/users?success=User+deleted+successfully")
 }
@@ -281,6 +301,48 @@ func (c *AdminUserController) Restore(ctx *gin.Context) {
 	ctx.Redirect(http.StatusSeeOther, "/admin/users?success=User+restored+successfully")
 }
 
+// Unlock unlocks a user's account that was locked after too many failed sign ins
+func (c *AdminUserController) Unlock(ctx *gin.Context) {
+	// Get user ID from URL
+	userID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/admin/users?error=Invalid+user+ID")
+		return
+	}
+
+	// Get user from database
+	user, err := c.DB.GetUserByID(uint(userID))
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			ctx.Redirect(http.StatusSeeOther, "/admin/users?error=User+not+found")
+		} else {
+			ctx.Redirect(http.StatusSeeOther, "/admin/users?error="+fmt.Sprintf("Error loading user: %v", err))
+		}
+		return
+	}
+
+	if !user.IsLocked() {
+		ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/users/%d?success=User+account+is+not+locked", userID))
+		return
+	}
+
+	// Record which admin unlocked the account
+	var adminID uint
+	if authData := getAuthData(ctx); authData.Email != "" {
+		if adminUser, err := c.DB.GetUserByEmail(ctx.Request.Context(), authData.Email); err == nil && adminUser != nil {
+			adminID = adminUser.ID
+		}
+	}
+
+	if err := c.DB.UnlockUser(ctx.Request.Context(), user, adminID); err != nil {
+		ctx.Redirect(http.StatusSeeOther, "/admin/users?error="+fmt.Sprintf("Error unlocking user: %v", err))
+		return
+	}
+
+	// Redirect to user detail page with success message
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/admin/users/%d?success=User+account+unlocked", userID))
+}
+
 // ShowGrantSubscription renders the form to grant a subscription to a user
 func (c *AdminUserController) ShowGrantSubscription(ctx *gin.Context) {
 	// Get auth data from context
@@ -360,6 +422,22 @@ func (c *AdminUserController) GrantSubscription(ctx *gin.Context) {
 		return
 	}
 
+	// Anything but an admin grant has to be one of the plans
+	if _, ok := models.LookupPlan(subscriptionType); !ok && subscriptionType != "admin_grant" {
+		authData := getAuthData(ctx)
+		authData = authData.WithTitle("Grant Subscription").WithCurrentPath(ctx.Request.URL.Path)
+		authData = authData.WithError("Unknown subscription type")
+
+		userData := &data.UserGrantSubscriptionData{
+			AuthData: authData,
+			User:     UserWrapper{User: *user},
+		}
+
+		ctx.Status(http.StatusBadRequest)
+		admin.UserGrantSubscription(userData).Render(ctx.Request.Context(), ctx.Writer)
+		return
+	}
+
 	// Update user subscription based on subscription type
 	user.SubscriptionStatus = "active"
 	user.IsAdminGranted = true
@@ -417,13 +495,14 @@ func (c *AdminUserController) GrantSubscription(ctx *gin.Context) {
 		// For existing subscription types, set the tier accordingly
 		user.SubscriptionTier = subscriptionType
 
-		// Set end date based on tier (using a standard duration)
-		switch subscriptionType {
-		case "monthly":
+		// Set end date based on the plan's interval
+		plan, _ := models.LookupPlan(subscriptionType)
+		switch plan.Interval {
+		case models.PlanIntervalMonth:
 			user.SubscriptionEndDate = time.Now().AddDate(0, 1, 0) // 1 month
-		case "yearly":
+		case models.PlanIntervalYear:
 			user.SubscriptionEndDate = time.Now().AddDate(1, 0, 0) // 1 year
-		case "lifetime", "premium_lifetime":
+		case models.PlanIntervalLifetime:
 			user.IsLifetime = true
 			user.SubscriptionEndDate = time.Time{} // Zero time for lifetime
 		}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	apierrors "github.com/hail2skins/armory/internal/errors"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/attachments"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+	"github.com/hail2skins/armory/internal/services/storage"
+	"gorm.io/gorm"
+)
+
+// Context keys the API's token authentication sets
+const (
+	apiUserKey  = "apiUser"
+	apiTokenKey = "apiToken"
+)
+
+// Pagination limits for API lists
+const (
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 100
+)
+
+// APIController serves the versioned JSON API. Requests are signed with a
+// personal access token instead of a session, so it's safe to call from
+// scripts and other apps.
+type APIController struct {
+	db      database.Service
+	storage storage.Storage
+}
+
+// NewAPIController creates a new API controller
+func NewAPIController(db database.Service) *APIController {
+	return &APIController{
+		db:      db,
+		storage: newStorage(),
+	}
+}
+
+// SetStorage sets where attached photos are stored, so they can be removed
+// along with the gun or ammunition they belong to
+func (a *APIController) SetStorage(store storage.Storage) {
+	a.storage = store
+}
+
+// apiList is the body of a response with a page of items
+type apiList struct {
+	Data interface{} `json:"data"`
+	Meta apiListMeta `json:"meta"`
+}
+
+// apiListMeta describes which page of items a list response holds
+type apiListMeta struct {
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+	Total   int64 `json:"total"`
+}
+
+// apiItem is the body of a response with a single item
+type apiItem struct {
+	Data interface{} `json:"data"`
+}
+
+// Authenticate requires a personal access token in the Authorization header
+// and makes its owner the request's user
+func (a *APIController) Authenticate(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		c.Header("WWW-Authenticate", `Bearer realm="armory"`)
+		apierrors.AbortWithAPIError(c, http.StatusUnauthorized, apierrors.APICodeUnauthorized, "An API token is required")
+		return
+	}
+
+	db := a.db.GetDB()
+	record, err := models.FindAPITokenByToken(db, strings.TrimSpace(token))
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	var user *database.User
+	if record != nil {
+		user, err = a.db.GetUserByID(record.UserID)
+	}
+	if record == nil || err != nil || user == nil {
+		c.Header("WWW-Authenticate", `Bearer realm="armory", error="invalid_token"`)
+		apierrors.AbortWithAPIError(c, http.StatusUnauthorized, apierrors.APICodeUnauthorized, "The API token is invalid, expired, or revoked")
+		return
+	}
+
+	if err := models.RecordAPITokenUse(db, record); err != nil {
+		logger.Error("Failed to record API token use", err, map[string]interface{}{
+			"token_id": record.ID,
+		})
+	}
+
+	c.Set(apiUserKey, user)
+	c.Set(apiTokenKey, record)
+	c.Next()
+}
+
+// RequireScope returns middleware that refuses tokens without scope
+func (a *APIController) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := c.Get(apiTokenKey)
+		if !ok || !token.(*models.APIToken).HasScope(scope) {
+			c.Header("WWW-Authenticate", `Bearer realm="armory", error="insufficient_scope", scope="`+scope+`"`)
+			apierrors.AbortWithAPIError(c, http.StatusForbidden, apierrors.APICodeInsufficientScope, "This token needs the "+scope+" scope")
+			return
+		}
+		c.Next()
+	}
+}
+
+// apiUser returns the user whose token signed the request
+func apiUser(c *gin.Context) *database.User {
+	return c.MustGet(apiUserKey).(*database.User)
+}
+
+// apiID reads the id path parameter, responding with not found when it isn't one
+func apiID(c *gin.Context, resource string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		apierrors.AbortWithAPIError(c, http.StatusNotFound, apierrors.APICodeNotFound, resource+" not found")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// apiPage reads the page and per_page query parameters, responding with an
+// error when they aren't positive numbers
+func apiPage(c *gin.Context) (page, perPage int, ok bool) {
+	page, perPage = 1, apiDefaultPerPage
+	fields := map[string]string{}
+	if value := c.Query("page"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 {
+			fields["page"] = "Page must be a positive number"
+		} else {
+			page = n
+		}
+	}
+	if value := c.Query("per_page"); value != "" {
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > apiMaxPerPage {
+			fields["per_page"] = "Per page must be between 1 and " + strconv.Itoa(apiMaxPerPage)
+		} else {
+			perPage = n
+		}
+	}
+	if len(fields) > 0 {
+		apierrors.AbortWithAPIValidationError(c, fields)
+		return 0, 0, false
+	}
+	return page, perPage, true
+}
+
+// bindAPIRequest decodes the JSON request body into request, responding with
+// an error when it can't be
+func bindAPIRequest(c *gin.Context, request interface{}) bool {
+	if err := c.ShouldBindJSON(request); err != nil {
+		apierrors.AbortWithAPIError(c, http.StatusBadRequest, apierrors.APICodeBadRequest, "The request body must be valid JSON")
+		return false
+	}
+	return true
+}
+
+// apiLookupError responds to an error finding one of the user's records
+func apiLookupError(c *gin.Context, err error, resource string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		apierrors.AbortWithAPIError(c, http.StatusNotFound, apierrors.APICodeNotFound, resource+" not found")
+		return
+	}
+	apierrors.AbortWithAPIInternalError(c, err)
+}
+
+// apiAllowance refuses to add another of a user's records once their
+// entitlements don't allow it, as the owner pages do
+func (a *APIController) apiAllowance(c *gin.Context, user *database.User, resource entitlements.Resource) bool {
+	allowance, err := entitlements.NewService(a.db.GetDB()).Check(user, resource)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return false
+	}
+	if !allowance.CanAdd() {
+		apierrors.AbortWithAPIError(c, http.StatusForbidden, apierrors.APICodeSubscriptionRequired, allowance.DeniedMessage())
+		return false
+	}
+	return true
+}
+
+// attachmentService returns the service that stores attached photos
+func (a *APIController) attachmentService() *attachments.Service {
+	return attachments.NewService(a.db.GetDB(), a.storage)
+}
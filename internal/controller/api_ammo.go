@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	apierrors "github.com/hail2skins/armory/internal/errors"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+)
+
+// ListAmmo returns a page of the owner's ammunition, oldest first
+func (a *APIController) ListAmmo(c *gin.Context) {
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+
+	db := a.db.GetDB()
+	var total int64
+	if err := db.Model(&models.Ammo{}).Where("owner_id = ?", user.ID).Count(&total).Error; err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	var ammo []models.Ammo
+	err := db.Preload("Brand").Preload("BulletStyle").Preload("Grain").
+		Preload("Caliber").Preload("Casing").
+		Where("owner_id = ?", user.ID).Order("id ASC").
+		Offset((page - 1) * perPage).Limit(perPage).
+		Find(&ammo).Error
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+
+	views := make([]apiAmmo, 0, len(ammo))
+	for _, lot := range ammo {
+		views = append(views, newAPIAmmo(lot))
+	}
+	c.JSON(http.StatusOK, apiList{Data: views, Meta: apiListMeta{Page: page, PerPage: perPage, Total: total}})
+}
+
+// GetAmmo returns one of the owner's ammunition lots
+func (a *APIController) GetAmmo(c *gin.Context) {
+	id, ok := apiID(c, "Ammunition")
+	if !ok {
+		return
+	}
+	ammo, err := models.FindAmmoByID(a.db.GetDB(), id, apiUser(c).ID)
+	if err != nil {
+		apiLookupError(c, err, "Ammunition")
+		return
+	}
+	c.JSON(http.StatusOK, apiItem{Data: newAPIAmmo(*ammo)})
+}
+
+// CreateAmmo adds an ammunition lot to the owner's munitions depot
+func (a *APIController) CreateAmmo(c *gin.Context) {
+	user := apiUser(c)
+	var request apiAmmoRequest
+	if !bindAPIRequest(c, &request) {
+		return
+	}
+	if !a.apiAllowance(c, user, entitlements.Ammo) {
+		return
+	}
+
+	ammo, ok := ammoFromAPIRequest(c, request, user)
+	if !ok {
+		return
+	}
+	db := a.db.GetDB()
+	if err := models.CreateAmmoWithValidation(db, ammo); err != nil {
+		apiAmmoSaveError(c, err)
+		return
+	}
+
+	created, err := models.FindAmmoByID(db, ammo.ID, user.ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	logger.Info("Ammunition created through the API", map[string]interface{}{
+		"user_id": user.ID,
+		"ammo_id": ammo.ID,
+	})
+	c.Header("Location", apierrors.APIPrefix+"/ammo/"+strconv.FormatUint(uint64(ammo.ID), 10))
+	c.JSON(http.StatusCreated, apiItem{Data: newAPIAmmo(*created)})
+}
+
+// UpdateAmmo replaces one of the owner's ammunition lots with the request's fields
+func (a *APIController) UpdateAmmo(c *gin.Context) {
+	id, ok := apiID(c, "Ammunition")
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	db := a.db.GetDB()
+	if _, err := models.FindAmmoByID(db, id, user.ID); err != nil {
+		apiLookupError(c, err, "Ammunition")
+		return
+	}
+
+	var request apiAmmoRequest
+	if !bindAPIRequest(c, &request) {
+		return
+	}
+	ammo, ok := ammoFromAPIRequest(c, request, user)
+	if !ok {
+		return
+	}
+	ammo.ID = id
+	if err := models.UpdateAmmoWithValidation(db, ammo); err != nil {
+		apiAmmoSaveError(c, err)
+		return
+	}
+
+	updated, err := models.FindAmmoByID(db, id, user.ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiItem{Data: newAPIAmmo(*updated)})
+}
+
+// DeleteAmmo removes one of the owner's ammunition lots along with its photos
+func (a *APIController) DeleteAmmo(c *gin.Context) {
+	id, ok := apiID(c, "Ammunition")
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	db := a.db.GetDB()
+	if _, err := models.FindAmmoByID(db, id, user.ID); err != nil {
+		apiLookupError(c, err, "Ammunition")
+		return
+	}
+	if err := models.DeleteAmmo(db, id, user.ID); err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	if err := a.attachmentService().DeleteForAmmo(c.Request.Context(), id, user.ID); err != nil {
+		logger.Error("Failed to delete attachments", err, map[string]interface{}{
+			"user_id": user.ID,
+			"ammo_id": id,
+		})
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ammoFromAPIRequest checks an ammunition request's fields and returns the lot
+// it describes, responding with the problems when there are any
+func ammoFromAPIRequest(c *gin.Context, request apiAmmoRequest, user *database.User) (*models.Ammo, bool) {
+	fields := map[string]string{}
+	if request.Name == "" {
+		fields["name"] = "Name is required"
+	}
+	if request.BrandID == 0 {
+		fields["brand_id"] = "Brand is required"
+	}
+	if request.CaliberID == 0 {
+		fields["caliber_id"] = "Caliber is required"
+	}
+	if request.Expended < 0 {
+		fields["expended"] = "Expended cannot be negative"
+	} else if request.Expended > request.Count {
+		fields["expended"] = "Expended cannot be more than the count"
+	}
+	acquired, ok := parseAPIDate(request.Acquired)
+	if !ok {
+		fields["acquired"] = "Acquired must be a date like 2024-01-31"
+	}
+	if len(fields) > 0 {
+		apierrors.AbortWithAPIValidationError(c, fields)
+		return nil, false
+	}
+
+	return &models.Ammo{
+		Name:          request.Name,
+		Acquired:      acquired,
+		Paid:          request.Paid,
+		Count:         request.Count,
+		Expended:      request.Expended,
+		BrandID:       request.BrandID,
+		CaliberID:     request.CaliberID,
+		BulletStyleID: request.BulletStyleID,
+		GrainID:       request.GrainID,
+		CasingID:      request.CasingID,
+		OwnerID:       user.ID,
+	}, true
+}
+
+// apiAmmoSaveError responds to an error saving an ammunition lot, naming the
+// field a validation error is about
+func apiAmmoSaveError(c *gin.Context, err error) {
+	field, message := "", ""
+	switch err {
+	case models.ErrAmmoNameTooLong:
+		field, message = "name", "Name cannot exceed 100 characters"
+	case models.ErrAmmoNegativePrice:
+		field, message = "paid", "Price cannot be negative"
+	case models.ErrAmmoNegativeCount:
+		field, message = "count", "Count cannot be negative"
+	case models.ErrAmmoFutureDate:
+		field, message = "acquired", "Acquisition date cannot be in the future"
+	case models.ErrInvalidBrand:
+		field, message = "brand_id", "Brand doesn't exist"
+	case models.ErrInvalidCaliber:
+		field, message = "caliber_id", "Caliber doesn't exist"
+	case models.ErrInvalidBulletStyle:
+		field, message = "bullet_style_id", "Bullet style doesn't exist"
+	case models.ErrInvalidGrain:
+		field, message = "grain_id", "Grain doesn't exist"
+	case models.ErrInvalidCasing:
+		field, message = "casing_id", "Casing doesn't exist"
+	default:
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	apierrors.AbortWithAPIValidationError(c, map[string]string{field: message})
+}
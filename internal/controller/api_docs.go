@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	apierrors "github.com/hail2skins/armory/internal/errors"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/openapi"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+)
+
+// Security schemes the API's routes are signed with
+const (
+	apiDocsTokenScheme = "token"
+	apiDocsAdminScheme = "admin"
+)
+
+// apiDocsPaging are the query string parameters of the API's list routes
+var apiDocsPaging = []openapi.Parameter{
+	{Name: "page", In: "query", Description: "Page to return, starting at 1", Schema: &openapi.Schema{Type: "integer"}},
+	{Name: "per_page", In: "query", Description: "Items per page, up to 100", Schema: &openapi.Schema{Type: "integer"}},
+}
+
+// describeAPIRoutes documents every route under /api. The test for the
+// OpenAPI document fails when a route is registered without being described here.
+func describeAPIRoutes(g *openapi.Generator) {
+	g.Describe(http.MethodGet, OpenAPISpecPath, openapi.Route{
+		Summary:  "This OpenAPI document",
+		Tag:      "Documentation",
+		Response: map[string]interface{}{},
+	})
+	g.Describe(http.MethodGet, "/api/docs", openapi.Route{
+		Summary:     "Browse this OpenAPI document",
+		Tag:         "Documentation",
+		Response:    "",
+		ContentType: "text/html",
+	})
+	g.Describe(http.MethodGet, "/api/health", openapi.Route{
+		Summary:  "Database health",
+		Tag:      "Status",
+		Response: map[string]string{},
+	})
+	g.Describe(http.MethodGet, "/api/calibers/search", openapi.Route{
+		Summary:     "Search calibers for the gun forms' dropdown",
+		Description: "Returns the matching calibers as HTML dropdown items, most popular first.",
+		Tag:         "Site",
+		Query: []openapi.Parameter{
+			{Name: "q", In: "query", Description: "Text to find in the caliber or its nickname", Schema: &openapi.Schema{Type: "string"}},
+		},
+		Response:    "",
+		ContentType: "text/html",
+	})
+
+	describeAdminAPIRoutes(g)
+	describeAPIV1Routes(g)
+}
+
+// describeAdminAPIRoutes documents the administrators' monitoring routes
+func describeAdminAPIRoutes(g *openapi.Generator) {
+	admin := func(method, path, summary string, query ...openapi.Parameter) {
+		g.Describe(method, "/api/admin"+path, openapi.Route{
+			Summary:  summary,
+			Tag:      "Admin",
+			Security: apiDocsAdminScheme,
+			Query:    query,
+			Response: map[string]interface{}{},
+			Error:    apierrors.ErrorResponse{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusInternalServerError},
+		})
+	}
+	admin(http.MethodGet, "/rate-limits", "Rate limiting statistics")
+	admin(http.MethodPost, "/rate-limits/reset", "Reset the rate limiting statistics")
+	admin(http.MethodGet, "/webhook/health", "Stripe webhook health")
+	admin(http.MethodPost, "/webhook/reset", "Reset the Stripe webhook statistics")
+	admin(http.MethodGet, "/stripe/ips", "Status of Stripe's webhook IP ranges")
+	admin(http.MethodPost, "/stripe/ips/refresh", "Fetch Stripe's webhook IP ranges again")
+	admin(http.MethodPost, "/stripe/ips/toggle", "Turn Stripe webhook IP filtering on or off until restart")
+	admin(http.MethodGet, "/stripe/ip-check", "Check whether an IP address is one of Stripe's",
+		openapi.Parameter{Name: "ip", In: "query", Required: true, Description: "IP address to check", Schema: &openapi.Schema{Type: "string"}})
+}
+
+// describeAPIV1Routes documents the versioned API for owners' data
+func describeAPIV1Routes(g *openapi.Generator) {
+	v1 := func(method, path, scope string, route openapi.Route) {
+		route.Security = apiDocsTokenScheme
+		route.Error = apierrors.APIError{}
+		route.Errors = append([]int{http.StatusUnauthorized, http.StatusForbidden}, route.Errors...)
+		needs := "Needs a token with the " + scope + " scope."
+		if route.Description != "" {
+			needs = route.Description + " " + needs
+		}
+		route.Description = needs
+		g.Describe(method, apierrors.APIPrefix+path, route)
+	}
+	list := func(item interface{}) openapi.Envelope {
+		return openapi.Envelope{"data": item, "meta": apiListMeta{}}
+	}
+	one := func(item interface{}) openapi.Envelope {
+		return openapi.Envelope{"data": item}
+	}
+	changed := []int{http.StatusNotFound, http.StatusUnprocessableEntity}
+
+	gr := models.APIScopeGunsRead
+	gw := models.APIScopeGunsWrite
+	v1(http.MethodGet, "/guns", gr, openapi.Route{Summary: "List your guns", Tag: "Guns", Query: apiDocsPaging, Response: list([]apiGun{}), Errors: []int{http.StatusUnprocessableEntity}})
+	v1(http.MethodPost, "/guns", gw, openapi.Route{Summary: "Add a gun", Description: apiDocsLimits(entitlements.Guns, "guns"), Tag: "Guns", Request: apiGunRequest{}, Response: one(apiGun{}), Status: http.StatusCreated, Errors: []int{http.StatusUnprocessableEntity}})
+	v1(http.MethodGet, "/guns/:id", gr, openapi.Route{Summary: "Get a gun", Tag: "Guns", Response: one(apiGun{}), Errors: []int{http.StatusNotFound}})
+	v1(http.MethodPut, "/guns/:id", gw, openapi.Route{Summary: "Replace a gun", Tag: "Guns", Request: apiGunRequest{}, Response: one(apiGun{}), Errors: changed})
+	v1(http.MethodDelete, "/guns/:id", gw, openapi.Route{Summary: "Delete a gun and its photos", Tag: "Guns", Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}})
+
+	ar := models.APIScopeAmmoRead
+	aw := models.APIScopeAmmoWrite
+	v1(http.MethodGet, "/ammo", ar, openapi.Route{Summary: "List your ammunition", Tag: "Ammunition", Query: apiDocsPaging, Response: list([]apiAmmo{}), Errors: []int{http.StatusUnprocessableEntity}})
+	v1(http.MethodPost, "/ammo", aw, openapi.Route{Summary: "Add an ammunition lot", Description: apiDocsLimits(entitlements.Ammo, "lots"), Tag: "Ammunition", Request: apiAmmoRequest{}, Response: one(apiAmmo{}), Status: http.StatusCreated, Errors: []int{http.StatusUnprocessableEntity}})
+	v1(http.MethodGet, "/ammo/:id", ar, openapi.Route{Summary: "Get an ammunition lot", Tag: "Ammunition", Response: one(apiAmmo{}), Errors: []int{http.StatusNotFound}})
+	v1(http.MethodPut, "/ammo/:id", aw, openapi.Route{Summary: "Replace an ammunition lot", Tag: "Ammunition", Request: apiAmmoRequest{}, Response: one(apiAmmo{}), Errors: changed})
+	v1(http.MethodDelete, "/ammo/:id", aw, openapi.Route{Summary: "Delete an ammunition lot and its photos", Tag: "Ammunition", Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}})
+
+	rr := models.APIScopeRangeDaysRead
+	rw := models.APIScopeRangeDaysWrite
+	v1(http.MethodGet, "/range-days", rr, openapi.Route{Summary: "List your range days, most recent first", Tag: "Range Days", Query: apiDocsPaging, Response: list([]apiRangeDay{}), Errors: []int{http.StatusUnprocessableEntity}})
+	v1(http.MethodPost, "/range-days", rw, openapi.Route{Summary: "Log a range day", Description: "Each item's shots are taken from its ammunition.", Tag: "Range Days", Request: apiRangeDayRequest{}, Response: one(apiRangeDay{}), Status: http.StatusCreated, Errors: []int{http.StatusUnprocessableEntity}})
+	v1(http.MethodGet, "/range-days/:id", rr, openapi.Route{Summary: "Get a range day", Tag: "Range Days", Response: one(apiRangeDay{}), Errors: []int{http.StatusNotFound}})
+	v1(http.MethodPut, "/range-days/:id", rw, openapi.Route{Summary: "Replace a range day", Description: "The difference in each item's shots is moved onto its ammunition.", Tag: "Range Days", Request: apiRangeDayRequest{}, Response: one(apiRangeDay{}), Errors: changed})
+	v1(http.MethodDelete, "/range-days/:id", rw, openapi.Route{Summary: "Delete a range day", Description: "Its shots are given back to the ammunition.", Tag: "Range Days", Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}})
+
+	ref := models.APIScopeReferenceRead
+	v1(http.MethodGet, "/manufacturers", ref, openapi.Route{Summary: "List gun manufacturers", Tag: "Reference", Response: one([]apiReference{})})
+	v1(http.MethodGet, "/calibers", ref, openapi.Route{Summary: "List calibers", Tag: "Reference", Response: one([]apiReference{})})
+	v1(http.MethodGet, "/weapon-types", ref, openapi.Route{Summary: "List weapon types", Tag: "Reference", Response: one([]apiReference{})})
+	v1(http.MethodGet, "/brands", ref, openapi.Route{Summary: "List ammunition brands", Tag: "Reference", Response: one([]apiReference{})})
+	v1(http.MethodGet, "/grains", ref, openapi.Route{Summary: "List bullet weights", Tag: "Reference", Response: one([]apiGrain{})})
+	v1(http.MethodGet, "/bullet-styles", ref, openapi.Route{Summary: "List bullet styles", Tag: "Reference", Response: one([]apiReference{})})
+	v1(http.MethodGet, "/casings", ref, openapi.Route{Summary: "List casing types", Tag: "Reference", Response: one([]apiReference{})})
+	v1(http.MethodGet, "/ranges", ref, openapi.Route{Summary: "List public ranges and your private ones", Tag: "Reference", Response: one([]apiRange{})})
+}
+
+// apiDocsLimits describes how many of a resource each plan in the catalog
+// allows, for the routes that add one
+func apiDocsLimits(resource entitlements.Resource, noun string) string {
+	var limits []string
+	for _, plan := range models.CatalogPlans() {
+		if limit := (entitlements.Entitlements{Plan: plan}).Limit(resource); limit > 0 {
+			limits = append(limits, fmt.Sprintf("the %s plan allows %d %s", plan.Name, limit, noun))
+		}
+	}
+	if len(limits) == 0 {
+		return ""
+	}
+	return "Adding more than your plan allows is forbidden: " + strings.Join(limits, ", ") + "."
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	apierrors "github.com/hail2skins/armory/internal/errors"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+)
+
+// ListGuns returns a page of the owner's guns, oldest first
+func (a *APIController) ListGuns(c *gin.Context) {
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+
+	db := a.db.GetDB()
+	var total int64
+	if err := db.Model(&models.Gun{}).Where("owner_id = ?", user.ID).Count(&total).Error; err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	var guns []models.Gun
+	err := db.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").
+		Where("owner_id = ?", user.ID).Order("id ASC").
+		Offset((page - 1) * perPage).Limit(perPage).
+		Find(&guns).Error
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+
+	views := make([]apiGun, 0, len(guns))
+	for _, gun := range guns {
+		views = append(views, newAPIGun(gun))
+	}
+	c.JSON(http.StatusOK, apiList{Data: views, Meta: apiListMeta{Page: page, PerPage: perPage, Total: total}})
+}
+
+// GetGun returns one of the owner's guns
+func (a *APIController) GetGun(c *gin.Context) {
+	id, ok := apiID(c, "Gun")
+	if !ok {
+		return
+	}
+	gun, err := models.FindGunByID(a.db.GetDB(), id, apiUser(c).ID)
+	if err != nil {
+		apiLookupError(c, err, "Gun")
+		return
+	}
+	c.JSON(http.StatusOK, apiItem{Data: newAPIGun(*gun)})
+}
+
+// CreateGun adds a gun to the owner's arsenal
+func (a *APIController) CreateGun(c *gin.Context) {
+	user := apiUser(c)
+	var request apiGunRequest
+	if !bindAPIRequest(c, &request) {
+		return
+	}
+	if !a.apiAllowance(c, user, entitlements.Guns) {
+		return
+	}
+
+	gun, ok := gunFromAPIRequest(c, request, user)
+	if !ok {
+		return
+	}
+	db := a.db.GetDB()
+	if err := models.CreateGunWithValidation(db, gun); err != nil {
+		apiGunSaveError(c, err)
+		return
+	}
+
+	created, err := models.FindGunByID(db, gun.ID, user.ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	logger.Info("Gun created through the API", map[string]interface{}{
+		"user_id": user.ID,
+		"gun_id":  gun.ID,
+	})
+	c.Header("Location", apierrors.APIPrefix+"/guns/"+strconv.FormatUint(uint64(gun.ID), 10))
+	c.JSON(http.StatusCreated, apiItem{Data: newAPIGun(*created)})
+}
+
+// UpdateGun replaces one of the owner's guns with the request's fields
+func (a *APIController) UpdateGun(c *gin.Context) {
+	id, ok := apiID(c, "Gun")
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	db := a.db.GetDB()
+	if _, err := models.FindGunByID(db, id, user.ID); err != nil {
+		apiLookupError(c, err, "Gun")
+		return
+	}
+
+	var request apiGunRequest
+	if !bindAPIRequest(c, &request) {
+		return
+	}
+	gun, ok := gunFromAPIRequest(c, request, user)
+	if !ok {
+		return
+	}
+	gun.ID = id
+	if err := models.UpdateGunWithValidation(db, gun); err != nil {
+		apiGunSaveError(c, err)
+		return
+	}
+
+	updated, err := models.FindGunByID(db, id, user.ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiItem{Data: newAPIGun(*updated)})
+}
+
+// DeleteGun removes one of the owner's guns along with its photos
+func (a *APIController) DeleteGun(c *gin.Context) {
+	id, ok := apiID(c, "Gun")
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	db := a.db.GetDB()
+	if _, err := models.FindGunByID(db, id, user.ID); err != nil {
+		apiLookupError(c, err, "Gun")
+		return
+	}
+	if err := models.DeleteGun(db, id, user.ID); err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	if err := a.attachmentService().DeleteForGun(c.Request.Context(), id, user.ID); err != nil {
+		logger.Error("Failed to delete attachments", err, map[string]interface{}{
+			"user_id": user.ID,
+			"gun_id":  id,
+		})
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// gunFromAPIRequest checks a gun request's fields and returns the gun it
+// describes, responding with the problems when there are any
+func gunFromAPIRequest(c *gin.Context, request apiGunRequest, user *database.User) (*models.Gun, bool) {
+	fields := map[string]string{}
+	if request.Name == "" {
+		fields["name"] = "Name is required"
+	}
+	if request.WeaponTypeID == 0 {
+		fields["weapon_type_id"] = "Weapon type is required"
+	}
+	if request.CaliberID == 0 {
+		fields["caliber_id"] = "Caliber is required"
+	}
+	if request.ManufacturerID == 0 {
+		fields["manufacturer_id"] = "Manufacturer is required"
+	}
+	acquired, ok := parseAPIDate(request.Acquired)
+	if !ok {
+		fields["acquired"] = "Acquired must be a date like 2024-01-31"
+	}
+	if len(fields) > 0 {
+		apierrors.AbortWithAPIValidationError(c, fields)
+		return nil, false
+	}
+
+	return &models.Gun{
+		Name:           request.Name,
+		SerialNumber:   request.SerialNumber,
+		Purpose:        request.Purpose,
+		Finish:         request.Finish,
+		Acquired:       acquired,
+		Paid:           request.Paid,
+		WeaponTypeID:   request.WeaponTypeID,
+		CaliberID:      request.CaliberID,
+		ManufacturerID: request.ManufacturerID,
+		OwnerID:        user.ID,
+	}, true
+}
+
+// apiGunSaveError responds to an error saving a gun, naming the field a
+// validation error is about
+func apiGunSaveError(c *gin.Context, err error) {
+	field, message := "", ""
+	switch err {
+	case models.ErrGunNameTooLong:
+		field, message = "name", "Name cannot exceed 100 characters"
+	case models.ErrGunPurposeTooLong:
+		field, message = "purpose", "Purpose cannot exceed 100 characters"
+	case models.ErrGunFinishTooLong:
+		field, message = "finish", "Finish cannot exceed 100 characters"
+	case models.ErrNegativePrice:
+		field, message = "paid", "Price cannot be negative"
+	case models.ErrFutureDate:
+		field, message = "acquired", "Acquisition date cannot be in the future"
+	case models.ErrInvalidWeaponType:
+		field, message = "weapon_type_id", "Weapon type doesn't exist"
+	case models.ErrInvalidCaliber:
+		field, message = "caliber_id", "Caliber doesn't exist"
+	case models.ErrInvalidManufacturer:
+		field, message = "manufacturer_id", "Manufacturer doesn't exist"
+	default:
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	apierrors.AbortWithAPIValidationError(c, map[string]string{field: message})
+}
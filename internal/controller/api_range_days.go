@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	apierrors "github.com/hail2skins/armory/internal/errors"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// ListRangeDays returns a page of the owner's range days, most recent first
+func (a *APIController) ListRangeDays(c *gin.Context) {
+	page, perPage, ok := apiPage(c)
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+
+	db := a.db.GetDB()
+	var total int64
+	if err := db.Model(&models.RangeDay{}).Where("user_id = ?", user.ID).Count(&total).Error; err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	var rangeDays []models.RangeDay
+	err := db.Preload("Range").
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Preload("Items.Gun").
+		Preload("Items.Ammo").
+		Where("user_id = ?", user.ID).Order("date DESC, id DESC").
+		Offset((page - 1) * perPage).Limit(perPage).
+		Find(&rangeDays).Error
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+
+	views := make([]apiRangeDay, 0, len(rangeDays))
+	for _, rangeDay := range rangeDays {
+		views = append(views, newAPIRangeDay(rangeDay))
+	}
+	c.JSON(http.StatusOK, apiList{Data: views, Meta: apiListMeta{Page: page, PerPage: perPage, Total: total}})
+}
+
+// GetRangeDay returns one of the owner's range days
+func (a *APIController) GetRangeDay(c *gin.Context) {
+	id, ok := apiID(c, "Range day")
+	if !ok {
+		return
+	}
+	rangeDay, err := models.FindRangeDayByID(a.db.GetDB(), id, apiUser(c).ID)
+	if err != nil {
+		apiLookupError(c, err, "Range day")
+		return
+	}
+	c.JSON(http.StatusOK, apiItem{Data: newAPIRangeDay(*rangeDay)})
+}
+
+// CreateRangeDay logs a range day, spending each line item's shots from its ammunition
+func (a *APIController) CreateRangeDay(c *gin.Context) {
+	user := apiUser(c)
+	var request apiRangeDayRequest
+	if !bindAPIRequest(c, &request) {
+		return
+	}
+	rangeDay, ok := rangeDayFromAPIRequest(c, request, user.ID)
+	if !ok {
+		return
+	}
+
+	db := a.db.GetDB()
+	if err := models.CreateRangeDayWithValidation(db, rangeDay); err != nil {
+		apiRangeDaySaveError(c, err)
+		return
+	}
+
+	created, err := models.FindRangeDayByID(db, rangeDay.ID, user.ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	logger.Info("Range day created through the API", map[string]interface{}{
+		"user_id":      user.ID,
+		"range_day_id": rangeDay.ID,
+	})
+	c.Header("Location", apierrors.APIPrefix+"/range-days/"+strconv.FormatUint(uint64(rangeDay.ID), 10))
+	c.JSON(http.StatusCreated, apiItem{Data: newAPIRangeDay(*created)})
+}
+
+// UpdateRangeDay replaces one of the owner's range days, moving the
+// difference in shots fired onto the ammunition
+func (a *APIController) UpdateRangeDay(c *gin.Context) {
+	id, ok := apiID(c, "Range day")
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	db := a.db.GetDB()
+	if _, err := models.FindRangeDayByID(db, id, user.ID); err != nil {
+		apiLookupError(c, err, "Range day")
+		return
+	}
+
+	var request apiRangeDayRequest
+	if !bindAPIRequest(c, &request) {
+		return
+	}
+	rangeDay, ok := rangeDayFromAPIRequest(c, request, user.ID)
+	if !ok {
+		return
+	}
+	rangeDay.ID = id
+	if err := models.UpdateRangeDayWithValidation(db, rangeDay); err != nil {
+		apiRangeDaySaveError(c, err)
+		return
+	}
+
+	updated, err := models.FindRangeDayByID(db, id, user.ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiItem{Data: newAPIRangeDay(*updated)})
+}
+
+// rangeDayFromAPIRequest checks a range day request's fields and returns the
+// range day it describes, responding with the problems when there are any
+func rangeDayFromAPIRequest(c *gin.Context, request apiRangeDayRequest, userID uint) (*models.RangeDay, bool) {
+	fields := map[string]string{}
+	rangeDay := &models.RangeDay{
+		UserID:   userID,
+		RangeID:  request.RangeID,
+		Comments: request.Comments,
+	}
+	if request.Date == "" {
+		fields["date"] = "Date is required"
+	} else if date, err := time.Parse(apiDateLayout, request.Date); err != nil {
+		fields["date"] = "Date must be a date like 2024-01-31"
+	} else {
+		rangeDay.Date = date
+	}
+	if request.RangeID == 0 {
+		fields["range_id"] = "Range is required"
+	}
+	if len(request.Items) == 0 {
+		fields["items"] = "At least one gun is required"
+	}
+	for i, item := range request.Items {
+		key := "items." + strconv.Itoa(i)
+		switch {
+		case item.GunID == 0:
+			fields[key+".gun_id"] = "Gun is required"
+		case item.AmmoID == 0:
+			fields[key+".ammo_id"] = "Ammunition is required"
+		case item.ShotsFired < 0:
+			fields[key+".shots_fired"] = "Shots fired cannot be negative"
+		}
+		rangeDay.Items = append(rangeDay.Items, models.RangeDayItem{
+			GunID:      item.GunID,
+			AmmoID:     item.AmmoID,
+			ShotsFired: item.ShotsFired,
+			Notes:      item.Notes,
+		})
+	}
+	if len(fields) > 0 {
+		apierrors.AbortWithAPIValidationError(c, fields)
+		return nil, false
+	}
+	return rangeDay, true
+}
+
+// DeleteRangeDay removes one of the owner's range days, giving its shots back to the ammunition
+func (a *APIController) DeleteRangeDay(c *gin.Context) {
+	id, ok := apiID(c, "Range day")
+	if !ok {
+		return
+	}
+	user := apiUser(c)
+	db := a.db.GetDB()
+	if _, err := models.FindRangeDayByID(db, id, user.ID); err != nil {
+		apiLookupError(c, err, "Range day")
+		return
+	}
+	if err := models.DeleteRangeDay(db, id, user.ID); err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// apiRangeDaySaveError responds to an error saving a range day, naming the
+// field a validation error is about
+func apiRangeDaySaveError(c *gin.Context, err error) {
+	field, message := "", ""
+	switch err {
+	case models.ErrRangeDayFutureDate:
+		field, message = "date", "Date cannot be in the future"
+	case models.ErrRangeDayCommentsTooLong:
+		field, message = "comments", "Comments cannot exceed 1000 characters"
+	case models.ErrInvalidRange:
+		field, message = "range_id", "Range doesn't exist"
+	case models.ErrInvalidRangeDayGun:
+		field, message = "items", "A gun doesn't exist"
+	case models.ErrInvalidRangeDayAmmo:
+		field, message = "items", "An ammunition lot doesn't exist"
+	case models.ErrRangeDayExceedsAmmo:
+		field, message = "items", "Shots fired exceed the rounds remaining in the ammunition"
+	case models.ErrRangeDayItemNotesTooLong:
+		field, message = "items", "Notes cannot exceed 500 characters"
+	default:
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	apierrors.AbortWithAPIValidationError(c, map[string]string{field: message})
+}
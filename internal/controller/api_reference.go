@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	apierrors "github.com/hail2skins/armory/internal/errors"
+	"github.com/hail2skins/armory/internal/models"
+)
+
+// ListManufacturers returns every gun manufacturer
+func (a *APIController) ListManufacturers(c *gin.Context) {
+	manufacturers, err := a.db.FindAllManufacturers()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiReference, 0, len(manufacturers))
+	for _, m := range manufacturers {
+		views = append(views, apiReference{ID: m.ID, Name: m.Name, Nickname: m.Nickname, Country: m.Country})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListCalibers returns every caliber
+func (a *APIController) ListCalibers(c *gin.Context) {
+	calibers, err := a.db.FindAllCalibers()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiReference, 0, len(calibers))
+	for _, caliber := range calibers {
+		views = append(views, apiReference{ID: caliber.ID, Name: caliber.Caliber, Nickname: caliber.Nickname})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListWeaponTypes returns every weapon type
+func (a *APIController) ListWeaponTypes(c *gin.Context) {
+	weaponTypes, err := a.db.FindAllWeaponTypes()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiReference, 0, len(weaponTypes))
+	for _, weaponType := range weaponTypes {
+		views = append(views, apiReference{ID: weaponType.ID, Name: weaponType.Type, Nickname: weaponType.Nickname})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListBrands returns every ammunition brand
+func (a *APIController) ListBrands(c *gin.Context) {
+	brands, err := a.db.FindAllBrands()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiReference, 0, len(brands))
+	for _, brand := range brands {
+		views = append(views, apiReference{ID: brand.ID, Name: brand.Name, Nickname: brand.Nickname})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListGrains returns every bullet weight
+func (a *APIController) ListGrains(c *gin.Context) {
+	grains, err := a.db.FindAllGrains()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiGrain, 0, len(grains))
+	for _, grain := range grains {
+		views = append(views, apiGrain{ID: grain.ID, Weight: grain.Weight})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListBulletStyles returns every bullet style
+func (a *APIController) ListBulletStyles(c *gin.Context) {
+	bulletStyles, err := a.db.FindAllBulletStyles()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiReference, 0, len(bulletStyles))
+	for _, bulletStyle := range bulletStyles {
+		views = append(views, apiReference{ID: bulletStyle.ID, Name: bulletStyle.Type, Nickname: bulletStyle.Nickname})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListCasings returns every casing type
+func (a *APIController) ListCasings(c *gin.Context) {
+	casings, err := a.db.FindAllCasings()
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiReference, 0, len(casings))
+	for _, casing := range casings {
+		views = append(views, apiReference{ID: casing.ID, Name: casing.Type})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
+
+// ListRanges returns the public ranges and the owner's private ones, for logging range days
+func (a *APIController) ListRanges(c *gin.Context) {
+	ranges, err := models.FindRangesForUser(a.db.GetDB(), apiUser(c).ID)
+	if err != nil {
+		apierrors.AbortWithAPIInternalError(c, err)
+		return
+	}
+	views := make([]apiRange, 0, len(ranges))
+	for _, r := range ranges {
+		views = append(views, apiRange{ID: r.ID, Name: r.RangeName, City: r.City, State: r.State, Private: !r.IsPublic()})
+	}
+	c.JSON(http.StatusOK, apiItem{Data: views})
+}
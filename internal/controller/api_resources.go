@@ -0,0 +1,228 @@
+package controller
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hail2skins/armory/internal/models"
+)
+
+// apiDateLayout is how the API writes and reads calendar dates
+const apiDateLayout = "2006-01-02"
+
+// apiRef names a related record
+type apiRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// apiGun is a gun as the API shows it
+type apiGun struct {
+	ID           uint      `json:"id"`
+	Name         string    `json:"name"`
+	SerialNumber string    `json:"serial_number"`
+	Purpose      string    `json:"purpose"`
+	Finish       string    `json:"finish"`
+	Acquired     *string   `json:"acquired"`
+	Paid         *float64  `json:"paid"`
+	Rental       bool      `json:"rental"`
+	WeaponType   apiRef    `json:"weapon_type"`
+	Caliber      apiRef    `json:"caliber"`
+	Manufacturer apiRef    `json:"manufacturer"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// apiAmmo is an ammunition lot as the API shows it
+type apiAmmo struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Acquired    *string   `json:"acquired"`
+	Paid        *float64  `json:"paid"`
+	Count       int       `json:"count"`
+	Expended    int       `json:"expended"`
+	Remaining   int       `json:"remaining"`
+	Brand       apiRef    `json:"brand"`
+	Caliber     apiRef    `json:"caliber"`
+	BulletStyle *apiRef   `json:"bullet_style"`
+	Grain       *apiRef   `json:"grain"`
+	Casing      *apiRef   `json:"casing"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// apiRangeDay is a range day as the API shows it
+type apiRangeDay struct {
+	ID         uint              `json:"id"`
+	Date       string            `json:"date"`
+	Range      apiRef            `json:"range"`
+	Comments   string            `json:"comments"`
+	TotalShots int               `json:"total_shots"`
+	Items      []apiRangeDayItem `json:"items"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// apiRangeDayItem is one gun and ammunition lot fired during a range day
+type apiRangeDayItem struct {
+	Gun        apiRef `json:"gun"`
+	Ammo       apiRef `json:"ammo"`
+	ShotsFired int    `json:"shots_fired"`
+	Notes      string `json:"notes"`
+}
+
+// apiReference is a manufacturer, caliber, or other reference record
+type apiReference struct {
+	ID       uint   `json:"id"`
+	Name     string `json:"name"`
+	Nickname string `json:"nickname,omitempty"`
+	Country  string `json:"country,omitempty"`
+}
+
+// apiGrain is a bullet weight
+type apiGrain struct {
+	ID     uint `json:"id"`
+	Weight int  `json:"weight"`
+}
+
+// apiRange is a shooting range
+type apiRange struct {
+	ID      uint   `json:"id"`
+	Name    string `json:"name"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Private bool   `json:"private"`
+}
+
+// apiGunRequest is the body for adding or replacing a gun
+type apiGunRequest struct {
+	Name           string   `json:"name"`
+	SerialNumber   string   `json:"serial_number"`
+	Purpose        string   `json:"purpose"`
+	Finish         string   `json:"finish"`
+	Acquired       string   `json:"acquired"`
+	Paid           *float64 `json:"paid"`
+	WeaponTypeID   uint     `json:"weapon_type_id"`
+	CaliberID      uint     `json:"caliber_id"`
+	ManufacturerID uint     `json:"manufacturer_id"`
+}
+
+// apiAmmoRequest is the body for adding or replacing an ammunition lot
+type apiAmmoRequest struct {
+	Name          string   `json:"name"`
+	Acquired      string   `json:"acquired"`
+	Paid          *float64 `json:"paid"`
+	Count         int      `json:"count"`
+	Expended      int      `json:"expended"`
+	BrandID       uint     `json:"brand_id"`
+	CaliberID     uint     `json:"caliber_id"`
+	BulletStyleID uint     `json:"bullet_style_id"`
+	GrainID       uint     `json:"grain_id"`
+	CasingID      uint     `json:"casing_id"`
+}
+
+// apiRangeDayRequest is the body for logging a range day
+type apiRangeDayRequest struct {
+	Date     string                   `json:"date"`
+	RangeID  uint                     `json:"range_id"`
+	Comments string                   `json:"comments"`
+	Items    []apiRangeDayItemRequest `json:"items"`
+}
+
+// apiRangeDayItemRequest is one gun and ammunition lot in a range day request
+type apiRangeDayItemRequest struct {
+	GunID      uint   `json:"gun_id"`
+	AmmoID     uint   `json:"ammo_id"`
+	ShotsFired int    `json:"shots_fired"`
+	Notes      string `json:"notes"`
+}
+
+// apiDate formats an optional calendar date
+func apiDate(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	date := t.Format(apiDateLayout)
+	return &date
+}
+
+// parseAPIDate reads an optional calendar date, returning nil when it's blank
+func parseAPIDate(value string) (*time.Time, bool) {
+	if value == "" {
+		return nil, true
+	}
+	date, err := time.Parse(apiDateLayout, value)
+	if err != nil {
+		return nil, false
+	}
+	return &date, true
+}
+
+// newAPIGun returns the API's view of a gun with its relations loaded
+func newAPIGun(gun models.Gun) apiGun {
+	return apiGun{
+		ID:           gun.ID,
+		Name:         gun.Name,
+		SerialNumber: gun.SerialNumber,
+		Purpose:      gun.Purpose,
+		Finish:       gun.Finish,
+		Acquired:     apiDate(gun.Acquired),
+		Paid:         gun.Paid,
+		Rental:       gun.Rental,
+		WeaponType:   apiRef{ID: gun.WeaponTypeID, Name: gun.WeaponType.Type},
+		Caliber:      apiRef{ID: gun.CaliberID, Name: gun.Caliber.Caliber},
+		Manufacturer: apiRef{ID: gun.ManufacturerID, Name: gun.Manufacturer.Name},
+		CreatedAt:    gun.CreatedAt,
+		UpdatedAt:    gun.UpdatedAt,
+	}
+}
+
+// newAPIAmmo returns the API's view of an ammunition lot with its relations loaded
+func newAPIAmmo(ammo models.Ammo) apiAmmo {
+	view := apiAmmo{
+		ID:        ammo.ID,
+		Name:      ammo.Name,
+		Acquired:  apiDate(ammo.Acquired),
+		Paid:      ammo.Paid,
+		Count:     ammo.Count,
+		Expended:  ammo.Expended,
+		Remaining: ammo.Count - ammo.Expended,
+		Brand:     apiRef{ID: ammo.BrandID, Name: ammo.Brand.Name},
+		Caliber:   apiRef{ID: ammo.CaliberID, Name: ammo.Caliber.Caliber},
+		CreatedAt: ammo.CreatedAt,
+		UpdatedAt: ammo.UpdatedAt,
+	}
+	if ammo.BulletStyleID != 0 {
+		view.BulletStyle = &apiRef{ID: ammo.BulletStyleID, Name: ammo.BulletStyle.Type}
+	}
+	if ammo.GrainID != 0 {
+		view.Grain = &apiRef{ID: ammo.GrainID, Name: strconv.Itoa(ammo.Grain.Weight) + " gr"}
+	}
+	if ammo.CasingID != 0 {
+		view.Casing = &apiRef{ID: ammo.CasingID, Name: ammo.Casing.Type}
+	}
+	return view
+}
+
+// newAPIRangeDay returns the API's view of a range day with its range and line items loaded
+func newAPIRangeDay(rangeDay models.RangeDay) apiRangeDay {
+	items := make([]apiRangeDayItem, 0, len(rangeDay.Items))
+	for _, item := range rangeDay.Items {
+		items = append(items, apiRangeDayItem{
+			Gun:        apiRef{ID: item.GunID, Name: item.Gun.Name},
+			Ammo:       apiRef{ID: item.AmmoID, Name: item.Ammo.Name},
+			ShotsFired: item.ShotsFired,
+			Notes:      item.Notes,
+		})
+	}
+	return apiRangeDay{
+		ID:         rangeDay.ID,
+		Date:       rangeDay.Date.Format(apiDateLayout),
+		Range:      apiRef{ID: rangeDay.RangeID, Name: rangeDay.Range.RangeName},
+		Comments:   rangeDay.Comments,
+		TotalShots: rangeDay.TotalShots(),
+		Items:      items,
+		CreatedAt:  rangeDay.CreatedAt,
+		UpdatedAt:  rangeDay.UpdatedAt,
+	}
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -13,7 +14,9 @@ import (
 	"github.com/hail2skins/armory/internal/database"
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/oidc"
 	"github.com/hail2skins/armory/internal/services/email"
+	"github.com/hail2skins/armory/internal/services/entitlements"
 	"github.com/hail2skins/armory/internal/validation"
 	"github.com/shaj13/go-guardian/v2/auth"
 	"github.com/shaj13/go-guardian/v2/auth/strategies/basic"
@@ -21,6 +24,9 @@ import (
 	_ "github.com/shaj13/libcache/lru"
 )
 
+// accountLockedMessage is shown when a sign in is refused because the account is locked
+const accountLockedMessage = "This account has been locked after too many failed sign in attempts. Check your email for a link to unlock it, or try again later."
+
 // RenderFunc is a function that renders a template
 type RenderFunc func(c *gin.Context, data interface{})
 
@@ -29,11 +35,14 @@ type AuthController struct {
 	db               database.Service
 	strategy         auth.Strategy
 	cache            libcache.Cache
+	oidc             *oidc.Provider // Signs users in with an OpenID Connect provider, nil when one isn't set up
+	oidcName         string
 	emailService     email.EmailService
 	promotionService interface {
 		GetBestActivePromotion() (*models.Promotion, error)
 	}
 	RenderLogin            RenderFunc
+	RenderTwoFactor        RenderFunc
 	RenderRegister         RenderFunc
 	RenderLogout           RenderFunc
 	RenderVerifyEmail      RenderFunc
@@ -91,12 +100,14 @@ func NewAuthController(db database.Service) *AuthController {
 
 	// Create email service
 	var emailService email.EmailService
-	emailService, err := email.NewMailjetService()
+	mailjetService, err := email.NewMailjetService()
 	if err != nil {
 		// Log the error but continue - email functionality will be disabled
 		logger.Warn("Email service initialization failed", map[string]interface{}{
 			"error": err.Error(),
 		})
+	} else {
+		emailService = mailjetService
 	}
 
 	logger.Info("Auth controller initialized", nil)
@@ -111,8 +122,11 @@ func NewAuthController(db database.Service) *AuthController {
 		db:                     db,
 		strategy:               strategy,
 		cache:                  cache,
+		oidc:                   oidcProviderFromEnv(),
+		oidcName:               oidcProviderName(),
 		emailService:           emailService,
 		RenderLogin:            defaultRender,
+		RenderTwoFactor:        defaultRender,
 		RenderRegister:         defaultRender,
 		RenderLogout:           defaultRender,
 		RenderVerifyEmail:      defaultRender,
@@ -163,6 +177,20 @@ func (a *AuthController) LoginHandler(c *gin.Context) {
 
 		// Authenticate user - this call validates credentials AND updates login attempts/last login
 		user, err := a.db.AuthenticateUser(c.Request.Context(), email, password)
+		if errors.Is(err, database.ErrUserLocked) {
+			// The user is only returned on the attempt that locked the account
+			if user != nil {
+				a.sendAccountLockedEmail(c, user)
+			}
+
+			logger.Warn("Login attempt on locked account", map[string]interface{}{
+				"email": email,
+			})
+
+			authData = authData.WithError(accountLockedMessage)
+			a.RenderLogin(c, authData)
+			return
+		}
 		if err != nil || user == nil {
 			// Authentication failed, log the error
 			logger.Warn("Authentication failed", map[string]interface{}{
@@ -192,47 +220,13 @@ func (a *AuthController) LoginHandler(c *gin.Context) {
 			return
 		}
 
-		// Authentication successful
-
-		// Set last login time and reset login attempts
-		// Note: This would normally use a db.SaveUser method, but we'll use the existing methods
-		// in the controller instead
-
-		// Check for active promotions that apply to existing users and apply them
-		if a.promotionService != nil {
-			if promotion, err := a.promotionService.GetBestActivePromotion(); err == nil && promotion != nil && promotion.ApplyToExistingUsers {
-				// Apply promotion benefit to the existing user
-				a.ApplyPromotionToUser(user, promotion)
-
-				// Log application of promotion
-				logger.Info("Applied promotion to existing user during login", map[string]interface{}{
-					"user_id":        user.ID,
-					"email":          email,
-					"promotion_id":   promotion.ID,
-					"promotion_name": promotion.Name,
-				})
-			}
+		// With two-factor authentication on, the password is only the first step
+		if user.TOTPEnabled {
+			a.startTwoFactor(c, user)
+			return
 		}
 
-		// Store user info in cache and session
-		userInfo := auth.NewUserInfo(email, strconv.FormatUint(uint64(user.ID), 10), nil, nil)
-		a.cache.Store(strconv.FormatUint(uint64(user.ID), 10), userInfo)
-
-		// Store user info in session
-		session := sessions.Default(c)
-		session.Set("user_id", strconv.FormatUint(uint64(user.ID), 10))
-		session.Set("user_email", email)
-		session.AddFlash("Enjoy adding to your armory!")
-		session.Save()
-
-		// Log successful login
-		logger.Info("User logged in", map[string]interface{}{
-			"user_id": user.ID,
-			"email":   email,
-		})
-
-		// Redirect to the owner page
-		c.Redirect(http.StatusSeeOther, "/owner")
+		a.completeLogin(c, user)
 		return
 	}
 
@@ -456,7 +450,7 @@ func (a *AuthController) RegisterHandler(c *gin.Context) {
 // ApplyPromotionToUser applies a promotion's benefits to a user
 func (a *AuthController) ApplyPromotionToUser(user *database.User, promotion *models.Promotion) {
 	// Set subscription details based on promotion
-	user.SubscriptionTier = "promotion"
+	user.SubscriptionTier = entitlements.PromotionTier
 	user.SubscriptionStatus = "active"
 	user.SubscriptionEndDate = time.Now().AddDate(0, 0, promotion.BenefitDays)
 	user.PromotionID = promotion.ID
@@ -594,6 +588,56 @@ func (a *AuthController) GetCurrentUser(c *gin.Context) (auth.Info, bool) {
 	return info, true
 }
 
+// sendAccountLockedEmail emails a user whose account just locked a link to unlock it
+func (a *AuthController) sendAccountLockedEmail(c *gin.Context, user *database.User) {
+	if a.emailService == nil {
+		logger.Warn("Email service not available to send unlock link", map[string]interface{}{
+			"email": user.Email,
+		})
+		return
+	}
+
+	// Get the scheme and host from the request
+	scheme := "http"
+	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+
+	if err := a.emailService.SendAccountLockedEmail(user.Email, user.UnlockToken, baseURL); err != nil {
+		logger.Error("Failed to send account locked email", err, map[string]interface{}{
+			"email": user.Email,
+		})
+	}
+}
+
+// UnlockAccountHandler unlocks an account with the one-time link emailed when it locked
+func (a *AuthController) UnlockAccountHandler(c *gin.Context) {
+	user, err := a.db.UnlockUserByToken(c.Request.Context(), c.Query("token"))
+	if err != nil {
+		if !errors.Is(err, database.ErrInvalidToken) && !errors.Is(err, database.ErrTokenExpired) {
+			logger.Error("Failed to unlock account", err, map[string]interface{}{
+				"path": c.Request.URL.Path,
+			})
+		}
+
+		authData := data.NewAuthData().WithTitle("Login").WithError("This unlock link is invalid, expired or has already been used")
+		c.Status(http.StatusBadRequest)
+		a.RenderLogin(c, authData)
+		return
+	}
+
+	logger.Info("Account unlocked with emailed link", map[string]interface{}{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+
+	session := sessions.Default(c)
+	session.AddFlash("Your account has been unlocked. You can log in now.")
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/login")
+}
+
 // VerifyEmailHandler handles email verification
 func (a *AuthController) VerifyEmailHandler(c *gin.Context) {
 	token := c.Query("token")
@@ -822,6 +866,14 @@ func (a *AuthController) ResetPasswordHandler(c *gin.Context) {
 		return
 	}
 
+	// Whoever knew the old password may still be signed in, so sign the user out everywhere
+	if _, err := models.RevokeUserSessions(a.db.GetDB(), user.ID, 0); err != nil {
+		logger.Error("Failed to revoke sessions after password reset", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+	}
+	a.cache.Delete(strconv.FormatUint(uint64(user.ID), 10))
+
 	// In test mode, redirect to a specific URL
 	if c.Request.Header.Get("X-Test") == "true" {
 		c.Redirect(http.StatusSeeOther, "/login?reset=success")
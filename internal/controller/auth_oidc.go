@@ -0,0 +1,366 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/oidc"
+	"gorm.io/gorm"
+)
+
+// OIDCCallbackPath is where the provider sends users back to after they sign in
+const OIDCCallbackPath = "/auth/oidc/callback"
+
+// oidcTimeout is how long a user has to sign in with the provider and come back
+const oidcTimeout = 10 * time.Minute
+
+// Session keys for a sign in the user has gone to the provider for
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+	oidcStartedKey  = "oidc_started"
+	oidcLinkUserKey = "oidc_link_user_id" // Set when a signed in user is linking an account rather than signing in
+)
+
+// oidcProviderFromEnv returns the OpenID Connect provider set by OIDC_ISSUER,
+// OIDC_CLIENT_ID and OIDC_CLIENT_SECRET, or nil when signing in with one isn't set up
+func oidcProviderFromEnv() *oidc.Provider {
+	issuer, clientID := os.Getenv("OIDC_ISSUER"), os.Getenv("OIDC_CLIENT_ID")
+	if issuer == "" || clientID == "" {
+		return nil
+	}
+	return oidc.NewProvider(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+	}, nil)
+}
+
+// oidcProviderName is what the provider is called on the sign in button and
+// profile page, or empty when signing in with one isn't set up
+func oidcProviderName() string {
+	if os.Getenv("OIDC_ISSUER") == "" || os.Getenv("OIDC_CLIENT_ID") == "" {
+		return ""
+	}
+	if name := os.Getenv("OIDC_PROVIDER_NAME"); name != "" {
+		return name
+	}
+	return "Single Sign-On"
+}
+
+// oidcRedirectURL is where the provider sends users back to. OIDC_REDIRECT_URL
+// sets it when the site is behind a proxy; otherwise it's taken from the request.
+// It must match a redirect URL registered with the provider.
+func oidcRedirectURL(c *gin.Context) string {
+	if redirectURL := os.Getenv("OIDC_REDIRECT_URL"); redirectURL != "" {
+		return redirectURL
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, OIDCCallbackPath)
+}
+
+// OIDCProviderName returns what the OpenID Connect provider is called, or
+// empty when signing in with one isn't set up
+func (a *AuthController) OIDCProviderName() string {
+	return a.oidcName
+}
+
+// startOIDC saves a new flow in the session and sends the user to the provider.
+// linkUserID is the signed in user linking their account, or 0 to sign in.
+func (a *AuthController) startOIDC(c *gin.Context, linkUserID uint) error {
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		return err
+	}
+	authURL, err := a.oidc.AuthCodeURL(c.Request.Context(), oidcRedirectURL(c), flow)
+	if err != nil {
+		return err
+	}
+
+	session := sessions.Default(c)
+	session.Set(oidcStateKey, flow.State)
+	session.Set(oidcNonceKey, flow.Nonce)
+	session.Set(oidcVerifierKey, flow.Verifier)
+	session.Set(oidcStartedKey, time.Now().Unix())
+	if linkUserID != 0 {
+		session.Set(oidcLinkUserKey, strconv.FormatUint(uint64(linkUserID), 10))
+	} else {
+		session.Delete(oidcLinkUserKey)
+	}
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, authURL)
+	return nil
+}
+
+// finishOIDC returns the flow saved in the session, if it started recently
+// enough, and forgets it so the user can only come back once
+func finishOIDC(c *gin.Context) (flow oidc.Flow, linkUserID uint, ok bool) {
+	session := sessions.Default(c)
+	flow.State, _ = session.Get(oidcStateKey).(string)
+	flow.Nonce, _ = session.Get(oidcNonceKey).(string)
+	flow.Verifier, _ = session.Get(oidcVerifierKey).(string)
+	started, _ := session.Get(oidcStartedKey).(int64)
+	linkUser, _ := session.Get(oidcLinkUserKey).(string)
+	for _, key := range []string{oidcStateKey, oidcNonceKey, oidcVerifierKey, oidcStartedKey, oidcLinkUserKey} {
+		session.Delete(key)
+	}
+	session.Save()
+
+	if flow.State == "" || time.Since(time.Unix(started, 0)) > oidcTimeout {
+		return flow, 0, false
+	}
+	if linkUser != "" {
+		id, err := strconv.ParseUint(linkUser, 10, 64)
+		if err != nil {
+			return flow, 0, false
+		}
+		linkUserID = uint(id)
+	}
+	return flow, linkUserID, true
+}
+
+// OIDCLoginHandler sends the user to the provider to sign in
+func (a *AuthController) OIDCLoginHandler(c *gin.Context) {
+	if a.oidc == nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	if _, authenticated := a.GetCurrentUser(c); authenticated {
+		c.Redirect(http.StatusSeeOther, "/owner")
+		return
+	}
+
+	if err := a.startOIDC(c, 0); err != nil {
+		logger.Error("Failed to start OIDC sign in", err, nil)
+		a.RenderLogin(c, data.NewAuthData().WithTitle("Login").
+			WithError(fmt.Sprintf("Signing in with %s isn't available right now, please try again later", a.oidcName)))
+	}
+}
+
+// OIDCLinkHandler sends the signed in user to the provider to link their account there to this one
+func (a *AuthController) OIDCLinkHandler(c *gin.Context) {
+	user, ok := a.currentOIDCUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	if a.oidc == nil {
+		c.Redirect(http.StatusSeeOther, "/owner/profile")
+		return
+	}
+
+	if err := a.startOIDC(c, user.ID); err != nil {
+		logger.Error("Failed to start OIDC account linking", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		session := sessions.Default(c)
+		session.AddFlash(fmt.Sprintf("Linking your %s account isn't available right now, please try again later", a.oidcName))
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/profile")
+	}
+}
+
+// OIDCCallbackHandler handles the user coming back from the provider. The
+// state must match the one the sign in started with, and the ID token must be
+// for this site and the sign in's nonce, before the account is linked or the
+// user signed in.
+func (a *AuthController) OIDCCallbackHandler(c *gin.Context) {
+	if a.oidc == nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	flow, linkUserID, ok := finishOIDC(c)
+
+	failed := func(message string, fields map[string]interface{}) {
+		logger.Warn("OIDC sign in failed", fields)
+		if linkUserID != 0 {
+			session := sessions.Default(c)
+			session.AddFlash(message)
+			session.Save()
+			c.Redirect(http.StatusSeeOther, "/owner/profile")
+			return
+		}
+		a.RenderLogin(c, data.NewAuthData().WithTitle("Login").WithError(message))
+	}
+	tryAgain := fmt.Sprintf("Signing in with %s didn't work, please try again", a.oidcName)
+
+	if providerError := c.Query("error"); providerError != "" {
+		failed(fmt.Sprintf("Signing in with %s was cancelled", a.oidcName), map[string]interface{}{
+			"error": providerError,
+		})
+		return
+	}
+	state := c.Query("state")
+	if !ok || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(flow.State)) != 1 {
+		failed(tryAgain, map[string]interface{}{"reason": "state does not match"})
+		return
+	}
+
+	claims, err := a.oidc.Exchange(c.Request.Context(), oidcRedirectURL(c), c.Query("code"), flow)
+	if err != nil {
+		failed(tryAgain, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	if linkUserID != 0 {
+		a.linkOIDCAccount(c, linkUserID, claims)
+		return
+	}
+	a.oidcSignIn(c, claims, failed)
+}
+
+// oidcSignIn signs in the user whose provider account is linked to theirs. An
+// account that isn't linked yet is linked to the user with the same email, as
+// long as both the provider and this site have verified it, so an account
+// someone registered here with another person's email can't be taken over.
+func (a *AuthController) oidcSignIn(c *gin.Context, claims *oidc.Claims, failed func(string, map[string]interface{})) {
+	db := a.db.GetDB()
+	issuer := a.oidc.Issuer()
+	fields := map[string]interface{}{"issuer": issuer, "subject": claims.Subject}
+
+	identity, err := models.FindOIDCIdentity(db, issuer, claims.Subject)
+	if err != nil {
+		logger.Error("Failed to look up OIDC identity", err, fields)
+		failed("Something went wrong, please try again", fields)
+		return
+	}
+
+	var user *database.User
+	if identity != nil {
+		user, err = a.db.GetUserByID(identity.UserID)
+		if err != nil || user == nil {
+			failed("Invalid email or password", fields)
+			return
+		}
+	} else {
+		if claims.Email == "" || !bool(claims.EmailVerified) {
+			fields["reason"] = "email not verified by the provider"
+			failed(fmt.Sprintf("Your %s account's email address hasn't been verified, so it can't be matched to an account here. Sign in with your password and link it from your profile.", a.oidcName), fields)
+			return
+		}
+		user, err = a.db.GetUserByEmail(c.Request.Context(), claims.Email)
+		if err != nil || user == nil {
+			fields["reason"] = "no account with the email"
+			failed(fmt.Sprintf("There's no account here for %s. Register first, then link your %s account from your profile.", claims.Email, a.oidcName), fields)
+			return
+		}
+		if !user.Verified {
+			fields["reason"] = "account email not verified"
+			failed("Please verify your email before logging in", fields)
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if identity, err = models.LinkOIDCIdentity(tx, user.ID, issuer, claims.Subject, claims.Email); err != nil {
+				return err
+			}
+			return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventOIDCLinked, fmt.Sprintf("Linked %s account %s on sign in", a.oidcName, claims.Email), 0)
+		})
+		if err != nil {
+			logger.Error("Failed to link OIDC identity", err, fields)
+			failed("Something went wrong, please try again", fields)
+			return
+		}
+	}
+
+	if user.IsLocked() {
+		fields["user_id"] = user.ID
+		failed(accountLockedMessage, fields)
+		return
+	}
+	if !user.Verified {
+		failed("Please verify your email before logging in", fields)
+		return
+	}
+
+	if err := models.RecordOIDCIdentityUse(db, identity, claims.Email); err != nil {
+		logger.Error("Failed to update OIDC identity", err, fields)
+	}
+
+	// The provider stands in for the password, not the second step
+	if user.TOTPEnabled {
+		a.startTwoFactor(c, user)
+		return
+	}
+
+	if err := database.RecordSuccessfulLogin(db, user); err != nil {
+		logger.Error("Failed to record login", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+	}
+	session := sessions.Default(c)
+	clearTwoFactor(session)
+
+	a.completeLogin(c, user)
+}
+
+// linkOIDCAccount links the provider account the user came back with to the
+// signed in user who started linking it
+func (a *AuthController) linkOIDCAccount(c *gin.Context, userID uint, claims *oidc.Claims) {
+	session := sessions.Default(c)
+	done := func(message string) {
+		session.AddFlash(message)
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/profile")
+	}
+
+	user, ok := a.currentOIDCUser(c)
+	if !ok || user.ID != userID {
+		logger.Warn("OIDC account linking finished by another user", map[string]interface{}{
+			"user_id": userID,
+		})
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	err := a.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		if _, err := models.LinkOIDCIdentity(tx, user.ID, a.oidc.Issuer(), claims.Subject, claims.Email); err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventOIDCLinked, fmt.Sprintf("Linked %s account %s", a.oidcName, claims.Email), 0)
+	})
+	switch {
+	case errors.Is(err, models.ErrOIDCIdentityLinked):
+		done(fmt.Sprintf("That %s account is already linked to another user", a.oidcName))
+	case err != nil:
+		logger.Error("Failed to link OIDC identity", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		done(fmt.Sprintf("Failed to link your %s account, please try again", a.oidcName))
+	default:
+		logger.Info("OIDC account linked", map[string]interface{}{
+			"user_id": user.ID,
+			"subject": claims.Subject,
+		})
+		done(fmt.Sprintf("Your %s account is linked. You can now sign in with it.", a.oidcName))
+	}
+}
+
+// currentOIDCUser returns the signed in user
+func (a *AuthController) currentOIDCUser(c *gin.Context) (*database.User, bool) {
+	info, authenticated := a.GetCurrentUser(c)
+	if !authenticated {
+		return nil, false
+	}
+	user, err := a.db.GetUserByEmail(c.Request.Context(), info.GetUserName())
+	if err != nil || user == nil {
+		return nil, false
+	}
+	return user, true
+}
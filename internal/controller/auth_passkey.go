@@ -0,0 +1,367 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/webauthn"
+	"gorm.io/gorm"
+)
+
+// passkeyRelyingPartyName names the site when the browser asks the user to create a passkey
+const passkeyRelyingPartyName = "Virtual Armory"
+
+// Session keys for a passkey ceremony the browser is part way through
+const (
+	passkeyRegistrationKey = "passkey_registration_challenge"
+	passkeyLoginKey        = "passkey_login_challenge"
+	passkeyUserHandleKey   = "passkey_user_handle"
+	passkeyStartedKey      = "passkey_started"
+)
+
+// PasskeyRegistrationRequest is the new credential the browser posts at the end of registration
+type PasskeyRegistrationRequest struct {
+	Name              string `json:"name"`
+	ID                string `json:"id" binding:"required"`
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AttestationObject string `json:"attestationObject" binding:"required"`
+}
+
+// PasskeyLoginRequest is the signed assertion the browser posts to sign in with a passkey
+type PasskeyLoginRequest struct {
+	ID                string `json:"id" binding:"required"`
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// relyingParty returns the site as authenticators see it. WEBAUTHN_ORIGIN and
+// WEBAUTHN_RP_ID set it, and the relying party ID defaults to the origin's host
+// name. Outside production the origin can be left unset and is taken from the
+// request; in production the Host header can't be trusted to name the site, so
+// passkeys are turned off until it's configured.
+func relyingParty(c *gin.Context) (webauthn.RelyingParty, bool) {
+	origin := os.Getenv("WEBAUTHN_ORIGIN")
+	if origin == "" {
+		switch strings.ToLower(os.Getenv("APP_ENV")) {
+		case "production", "staging":
+			logger.Error("Passkeys need WEBAUTHN_ORIGIN to be set", nil, nil)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Passkeys aren't available right now"})
+			return webauthn.RelyingParty{}, false
+		}
+		scheme := "http"
+		if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		origin = fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+	}
+
+	id := os.Getenv("WEBAUTHN_RP_ID")
+	if id == "" {
+		if parsed, err := url.Parse(origin); err == nil {
+			id = parsed.Hostname()
+		}
+	}
+
+	return webauthn.RelyingParty{ID: id, Name: passkeyRelyingPartyName, Origin: origin}, true
+}
+
+// legacyUserHandle is the handle passkeys added before handles were random
+// were stored under: the user's ID
+func legacyUserHandle(userID uint) string {
+	return webauthn.EncodeID([]byte(strconv.FormatUint(uint64(userID), 10)))
+}
+
+// startCeremony saves a new challenge in the session under key and returns it
+func startCeremony(c *gin.Context, key string) (string, bool) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		logger.Error("Failed to create passkey challenge", err, nil)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again"})
+		return "", false
+	}
+
+	session := sessions.Default(c)
+	session.Set(key, challenge)
+	session.Set(passkeyStartedKey, time.Now().Unix())
+	session.Save()
+	return challenge, true
+}
+
+// finishCeremony returns the challenge saved under key, if the ceremony started
+// recently enough, and forgets it so it can only be answered once
+func finishCeremony(c *gin.Context, key string) string {
+	session := sessions.Default(c)
+	challenge, _ := session.Get(key).(string)
+	started, _ := session.Get(passkeyStartedKey).(int64)
+	session.Delete(key)
+	session.Delete(passkeyStartedKey)
+	session.Save()
+
+	if time.Since(time.Unix(started, 0)) > webauthn.Timeout*time.Millisecond {
+		return ""
+	}
+	return challenge
+}
+
+// currentPasskeyUser returns the signed in user registering a passkey
+func (a *AuthController) currentPasskeyUser(c *gin.Context) (*database.User, bool) {
+	info, authenticated := a.GetCurrentUser(c)
+	if !authenticated {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "You must be logged in to add a passkey"})
+		return nil, false
+	}
+	user, err := a.db.GetUserByEmail(c.Request.Context(), info.GetUserName())
+	if err != nil || user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "You must be logged in to add a passkey"})
+		return nil, false
+	}
+	return user, true
+}
+
+// PasskeyRegistrationOptionsHandler starts registering a passkey for the signed
+// in user, returning the options for navigator.credentials.create
+func (a *AuthController) PasskeyRegistrationOptionsHandler(c *gin.Context) {
+	user, ok := a.currentPasskeyUser(c)
+	if !ok {
+		return
+	}
+
+	passkeys, err := models.FindPasskeysByUser(a.db.GetDB(), user.ID)
+	if err != nil {
+		logger.Error("Failed to load passkeys", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again"})
+		return
+	}
+	existing := make([][]byte, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		if id, err := webauthn.DecodeID(passkey.CredentialID); err == nil {
+			existing = append(existing, id)
+		}
+	}
+
+	handle, err := models.PasskeyUserHandle(a.db.GetDB(), user.ID)
+	if err != nil {
+		logger.Error("Failed to load passkey user handle", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again"})
+		return
+	}
+	handleID, err := webauthn.DecodeID(handle)
+	if err != nil {
+		logger.Error("Failed to decode passkey user handle", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again"})
+		return
+	}
+
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+	challenge, ok := startCeremony(c, passkeyRegistrationKey)
+	if !ok {
+		return
+	}
+	// The new passkey is saved with the handle the authenticator was given
+	session := sessions.Default(c)
+	session.Set(passkeyUserHandleKey, handle)
+	session.Save()
+	c.JSON(http.StatusOK, rp.CreationOptions(challenge, handleID, user.Email, existing))
+}
+
+// PasskeyRegisterHandler checks the new credential from the browser and saves it as a passkey
+func (a *AuthController) PasskeyRegisterHandler(c *gin.Context) {
+	user, ok := a.currentPasskeyUser(c)
+	if !ok {
+		return
+	}
+	session := sessions.Default(c)
+	handle, _ := session.Get(passkeyUserHandleKey).(string)
+	session.Delete(passkeyUserHandleKey)
+	challenge := finishCeremony(c, passkeyRegistrationKey)
+
+	var req PasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The passkey couldn't be read"})
+		return
+	}
+	clientDataJSON, err1 := webauthn.DecodeID(req.ClientDataJSON)
+	attestation, err2 := webauthn.DecodeID(req.AttestationObject)
+	if err1 != nil || err2 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The passkey couldn't be read"})
+		return
+	}
+
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestation)
+	if err != nil {
+		logger.Warn("Passkey registration failed", map[string]interface{}{
+			"user_id": user.ID,
+			"error":   err.Error(),
+		})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The passkey couldn't be added, please try again"})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	passkey := &models.Passkey{
+		UserID:       user.ID,
+		UserHandle:   handle,
+		CredentialID: webauthn.EncodeID(credential.ID),
+		PublicKey:    credential.PublicKey,
+		SignCount:    credential.SignCount,
+		Name:         name,
+	}
+
+	db := a.db.GetDB()
+	if existing, err := models.FindPasskeyByCredentialID(db, passkey.CredentialID); err != nil || existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "That passkey has already been added"})
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(passkey).Error; err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventPasskeyAdded, "Added passkey "+name, 0)
+	})
+	if err != nil {
+		logger.Error("Failed to save passkey", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again"})
+		return
+	}
+
+	logger.Info("Passkey registered", map[string]interface{}{
+		"user_id":    user.ID,
+		"passkey_id": passkey.ID,
+	})
+	c.JSON(http.StatusCreated, gin.H{"id": passkey.ID, "name": passkey.Name})
+}
+
+// PasskeyLoginOptionsHandler starts signing in with a passkey, returning the
+// options for navigator.credentials.get
+func (a *AuthController) PasskeyLoginOptionsHandler(c *gin.Context) {
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+	challenge, ok := startCeremony(c, passkeyLoginKey)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, rp.RequestOptions(challenge, [][]byte{}))
+}
+
+// PasskeyLoginHandler signs in the owner of the passkey that signed the
+// challenge. The authenticator verified the user, so the passkey stands in for
+// both the password and the two-factor code.
+func (a *AuthController) PasskeyLoginHandler(c *gin.Context) {
+	challenge := finishCeremony(c, passkeyLoginKey)
+
+	var req PasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The passkey couldn't be read"})
+		return
+	}
+	clientDataJSON, err1 := webauthn.DecodeID(req.ClientDataJSON)
+	authData, err2 := webauthn.DecodeID(req.AuthenticatorData)
+	signature, err3 := webauthn.DecodeID(req.Signature)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The passkey couldn't be read"})
+		return
+	}
+
+	refused := func(reason string) {
+		logger.Warn("Passkey sign in failed", map[string]interface{}{
+			"credential_id": req.ID,
+			"reason":        reason,
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "That passkey isn't registered with this site"})
+	}
+
+	db := a.db.GetDB()
+	passkey, err := models.FindPasskeyByCredentialID(db, req.ID)
+	if err != nil || passkey == nil {
+		refused("unknown credential")
+		return
+	}
+	handle := passkey.UserHandle
+	if handle == "" {
+		handle = legacyUserHandle(passkey.UserID)
+	}
+	if req.UserHandle != "" && req.UserHandle != handle {
+		refused("user handle does not match")
+		return
+	}
+
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+	signCount, err := rp.VerifyAssertion(challenge, passkey.PublicKey, passkey.SignCount, clientDataJSON, authData, signature)
+	if err != nil {
+		refused(err.Error())
+		return
+	}
+
+	user, err := a.db.GetUserByID(passkey.UserID)
+	if err != nil || user == nil {
+		refused("user not found")
+		return
+	}
+	if user.IsLocked() {
+		logger.Warn("Passkey sign in on locked account", map[string]interface{}{
+			"user_id": user.ID,
+		})
+		c.JSON(http.StatusForbidden, gin.H{"error": accountLockedMessage})
+		return
+	}
+	if !user.Verified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email before logging in"})
+		return
+	}
+
+	if err := models.RecordPasskeyUse(db, passkey, signCount); err != nil {
+		logger.Error("Failed to update passkey", err, map[string]interface{}{
+			"passkey_id": passkey.ID,
+		})
+	}
+	if err := database.RecordSuccessfulLogin(db, user); err != nil {
+		logger.Error("Failed to record login", err, map[string]interface{}{
+			"user_id": user.ID,
+		})
+	}
+
+	// A sign in part way through the two-factor step is replaced by this one
+	session := sessions.Default(c)
+	clearTwoFactor(session)
+
+	a.signIn(c, user)
+	c.JSON(http.StatusOK, gin.H{"redirect": "/owner"})
+}
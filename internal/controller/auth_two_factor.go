@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/shaj13/go-guardian/v2/auth"
+)
+
+// twoFactorTimeout is how long a user has to enter their code after their password
+const twoFactorTimeout = 5 * time.Minute
+
+// Session keys for a sign in that is waiting on the second step
+const (
+	twoFactorUserKey    = "two_factor_user_id"
+	twoFactorStartedKey = "two_factor_started"
+)
+
+// completeLogin signs in a user whose credentials have all checked out and
+// sends them to their armory
+func (a *AuthController) completeLogin(c *gin.Context, user *database.User) {
+	a.signIn(c, user)
+
+	// Redirect to the owner page
+	c.Redirect(http.StatusSeeOther, "/owner")
+}
+
+// signIn starts a session for a user whose credentials have all checked out
+func (a *AuthController) signIn(c *gin.Context, user *database.User) {
+	// Check for active promotions that apply to existing users and apply them
+	if a.promotionService != nil {
+		if promotion, err := a.promotionService.GetBestActivePromotion(); err == nil && promotion != nil && promotion.ApplyToExistingUsers {
+			// Apply promotion benefit to the existing user
+			a.ApplyPromotionToUser(user, promotion)
+
+			// Log application of promotion
+			logger.Info("Applied promotion to existing user during login", map[string]interface{}{
+				"user_id":        user.ID,
+				"email":          user.Email,
+				"promotion_id":   promotion.ID,
+				"promotion_name": promotion.Name,
+			})
+		}
+	}
+
+	// Store user info in cache and session
+	userInfo := auth.NewUserInfo(user.Email, strconv.FormatUint(uint64(user.ID), 10), nil, nil)
+	a.cache.Store(strconv.FormatUint(uint64(user.ID), 10), userInfo)
+
+	// Store user info in session
+	session := sessions.Default(c)
+	session.Set("user_id", strconv.FormatUint(uint64(user.ID), 10))
+	session.Set("user_email", user.Email)
+	session.AddFlash("Enjoy adding to your armory!")
+	session.Save()
+
+	// Log successful login
+	logger.Info("User logged in", map[string]interface{}{
+		"user_id": user.ID,
+		"email":   user.Email,
+	})
+}
+
+// startTwoFactor remembers a user whose password checked out and sends them
+// on to enter the code from their authenticator
+func (a *AuthController) startTwoFactor(c *gin.Context, user *database.User) {
+	session := sessions.Default(c)
+	session.Set(twoFactorUserKey, strconv.FormatUint(uint64(user.ID), 10))
+	session.Set(twoFactorStartedKey, time.Now().Unix())
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/login/two-factor")
+}
+
+// pendingTwoFactor returns the user waiting on the second step of signing in,
+// if they started recently enough
+func pendingTwoFactor(session sessions.Session) (uint, bool) {
+	userID, _ := session.Get(twoFactorUserKey).(string)
+	started, _ := session.Get(twoFactorStartedKey).(int64)
+	if userID == "" || time.Since(time.Unix(started, 0)) > twoFactorTimeout {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(userID, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// clearTwoFactor forgets the sign in waiting on the second step
+func clearTwoFactor(session sessions.Session) {
+	session.Delete(twoFactorUserKey)
+	session.Delete(twoFactorStartedKey)
+}
+
+// TwoFactorHandler handles the second step of signing in, where a user with
+// two-factor authentication enters a code from their authenticator or a recovery code
+func (a *AuthController) TwoFactorHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	userID, ok := pendingTwoFactor(session)
+	if !ok {
+		clearTwoFactor(session)
+		session.AddFlash("Your sign in has expired. Please log in again.")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	authData := data.NewAuthData().WithTitle("Two-Factor Authentication")
+
+	if c.Request.Method == http.MethodPost {
+		user, err := a.db.VerifyTwoFactor(c.Request.Context(), userID, c.PostForm("code"))
+		if errors.Is(err, database.ErrUserLocked) {
+			// The user is only returned on the attempt that locked the account
+			if user != nil {
+				a.sendAccountLockedEmail(c, user)
+			}
+
+			logger.Warn("Two-factor attempt on locked account", map[string]interface{}{
+				"user_id": userID,
+			})
+
+			clearTwoFactor(session)
+			session.Save()
+			a.RenderLogin(c, data.NewAuthData().WithTitle("Login").WithError(accountLockedMessage))
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, database.ErrInvalidTwoFactorCode) {
+				logger.Error("Failed to verify two-factor code", err, map[string]interface{}{
+					"user_id": userID,
+				})
+			}
+			logger.Warn("Two-factor authentication failed", map[string]interface{}{
+				"user_id": userID,
+			})
+
+			a.RenderTwoFactor(c, authData.WithError("That code didn't work. Check your authenticator and try again."))
+			return
+		}
+
+		clearTwoFactor(session)
+		a.completeLogin(c, user)
+		return
+	}
+
+	a.RenderTwoFactor(c, authData)
+}
+
+// TwoFactorPolicyMiddleware sends users who hold a Casbin role to set up
+// two-factor authentication before they can use the admin pages, when the
+// require_admin_two_factor feature flag is on. If roles are added to the flag,
+// only users with one of them need it. It expects the user's roles in the authData.
+// When the policy can't be checked the request is refused rather than let through.
+func (a *AuthController) TwoFactorPolicyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, _ := c.Get("authData")
+		authData, _ := value.(data.AuthData)
+		if len(authData.Roles) == 0 {
+			c.Next()
+			return
+		}
+		failed := func(message string, err error) {
+			logger.Error(message, err, map[string]interface{}{
+				"email": authData.Email,
+			})
+			c.String(http.StatusInternalServerError, "Failed to check your two-factor authentication, please try again")
+			c.Abort()
+		}
+
+		// The flag's roles decide who it covers, the same way they decide who can use a feature
+		required, err := a.db.CanUserAccessFeature(authData.Email, models.FeatureRequireAdminTwoFactor)
+		if err != nil {
+			failed("Failed to check the two-factor policy", err)
+			return
+		}
+		if !required {
+			c.Next()
+			return
+		}
+
+		user, err := a.db.GetUserByEmail(c.Request.Context(), authData.Email)
+		if err == nil && user == nil {
+			err = errors.New("user not found")
+		}
+		if err != nil {
+			failed("Failed to load user for the two-factor policy", err)
+			return
+		}
+		if user.TOTPEnabled {
+			c.Next()
+			return
+		}
+
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("Set up two-factor authentication to use the admin pages")
+		}
+		c.Redirect(http.StatusSeeOther, "/owner/profile/two-factor")
+		c.Abort()
+	}
+}
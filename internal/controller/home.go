@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -11,6 +13,7 @@ import (
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
 	"github.com/hail2skins/armory/internal/services/email"
+	"gorm.io/gorm"
 )
 
 // HomeController handles home page related routes
@@ -371,3 +374,29 @@ func (h *HomeController) ContactHandler(c *gin.Context) {
 func (h *HomeController) SetEmailService(emailService email.EmailService) {
 	h.emailService = emailService
 }
+
+// InventoryVerifyHandler checks a content hash against the inventory reports that were issued.
+// It only confirms when the report was generated and its totals, never who it belongs to.
+func (h *HomeController) InventoryVerifyHandler(c *gin.Context) {
+	var verifyData home.InventoryVerifyData
+	verifyData.AuthData = data.NewAuthData()
+	if authDataInterface, exists := c.Get("authData"); exists {
+		if authData, ok := authDataInterface.(data.AuthData); ok {
+			verifyData.AuthData = authData
+		}
+	}
+	verifyData.AuthData = verifyData.AuthData.WithTitle("Verify Inventory Report")
+
+	verifyData.Hash = strings.TrimSpace(c.Query("hash"))
+	if verifyData.Hash != "" {
+		verifyData.Checked = true
+		report, err := models.FindInventoryReportByHash(h.db.GetDB(), verifyData.Hash)
+		if err == nil {
+			verifyData.Report = report
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.Error("Failed to look up inventory report", err, nil)
+		}
+	}
+
+	home.InventoryVerify(verifyData).Render(c.Request.Context(), c.Writer)
+}
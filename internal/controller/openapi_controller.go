@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/api"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/openapi"
+)
+
+// OpenAPISpecPath is where the OpenAPI document for the API is served
+const OpenAPISpecPath = "/api/openapi.json"
+
+// OpenAPIController serves the OpenAPI document for the routes under /api and a viewer for it
+type OpenAPIController struct {
+	generator   *openapi.Generator
+	mu          sync.Mutex
+	generatedAt time.Time
+	spec        []byte
+	err         error
+}
+
+// NewOpenAPIController creates a controller documenting the /api routes registered on router
+func NewOpenAPIController(router *gin.Engine) *OpenAPIController {
+	generator := openapi.NewGenerator(router, "/api", openapi.Info{
+		Title:       "Virtual Armory API",
+		Description: "Read and manage your guns, ammunition and range days. Create a personal access token from your profile's API Tokens page and send it in an Authorization: Bearer header.",
+		Version:     "1.0.0",
+	})
+	generator.AddSecurityScheme(apiDocsTokenScheme, openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "A personal access token, which only reaches what its scopes allow",
+	})
+	generator.AddSecurityScheme(apiDocsAdminScheme, openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "cookie",
+		Name:        "armory-session",
+		Description: "A signed in administrator's session",
+	})
+
+	return &OpenAPIController{generator: generator}
+}
+
+// Spec serves the OpenAPI document. It's generated on the first request, once
+// every route has been registered, and again when it's older than the plan
+// catalog's TTL so the limits it describes follow changes to the plans.
+func (o *OpenAPIController) Spec(c *gin.Context) {
+	o.mu.Lock()
+	if o.generatedAt.IsZero() || time.Since(o.generatedAt) > models.PlanCatalogTTL {
+		o.generate()
+	}
+	spec, err := o.spec, o.err
+	o.mu.Unlock()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to generate the API specification")
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+}
+
+// Viewer renders a page for browsing the OpenAPI document
+func (o *OpenAPIController) Viewer(c *gin.Context) {
+	c.Status(http.StatusOK)
+	api.Docs(OpenAPISpecPath).Render(c.Request.Context(), c.Writer)
+}
+
+// generate describes the routes, then builds and encodes the document, warning
+// about any routes missing from it
+func (o *OpenAPIController) generate() {
+	describeAPIRoutes(o.generator)
+	o.generatedAt = time.Now()
+	doc, undocumented := o.generator.Generate()
+	if len(undocumented) > 0 {
+		logger.Warn("API routes missing from the OpenAPI document", map[string]interface{}{
+			"routes": strings.Join(undocumented, ", "),
+		})
+	}
+	o.spec, o.err = json.Marshal(doc)
+	if o.err != nil {
+		logger.Error("Failed to encode the OpenAPI document", o.err, nil)
+	}
+}
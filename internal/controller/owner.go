@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"time"
@@ -22,7 +23,12 @@ import (
 	"github.com/hail2skins/armory/internal/database"
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/dunning"
 	"github.com/hail2skins/armory/internal/services/email"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+	"github.com/hail2skins/armory/internal/services/geocode"
+	"github.com/hail2skins/armory/internal/services/storage"
+	"github.com/hail2skins/armory/internal/services/stripe"
 	"github.com/shaj13/go-guardian/v2/auth"
 	"gorm.io/gorm"
 )
@@ -34,16 +40,52 @@ type AuthControllerInterface interface {
 
 // OwnerController handles owner-related routes
 type OwnerController struct {
-	db database.Service
+	db       database.Service
+	geocoder geocode.Geocoder
+	storage  storage.Storage
+	runJob   func(func())
 }
 
 // NewOwnerController creates a new owner controller
 func NewOwnerController(db database.Service) *OwnerController {
 	return &OwnerController{
-		db: db,
+		db:       db,
+		geocoder: newGeocoder(),
+		storage:  newStorage(),
+		runJob:   runInBackground,
 	}
 }
 
+// runInBackground runs a job on its own goroutine, logging rather than
+// crashing the server if it panics
+func runInBackground(job func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("Background job panicked", fmt.Errorf("%v", r), map[string]interface{}{
+					"stack": string(debug.Stack()),
+				})
+			}
+		}()
+		job()
+	}()
+}
+
+// SetGeocoder sets the geocoder used to place ranges on the map
+func (o *OwnerController) SetGeocoder(geocoder geocode.Geocoder) {
+	o.geocoder = geocoder
+}
+
+// SetStorage sets where attached photos are stored
+func (o *OwnerController) SetStorage(store storage.Storage) {
+	o.storage = store
+}
+
+// SetJobRunner sets how background jobs, such as data exports, are run
+func (o *OwnerController) SetJobRunner(run func(func())) {
+	o.runJob = run
+}
+
 // LandingPage handles the owner landing page route
 func (o *OwnerController) LandingPage(c *gin.Context) {
 	// Get the current user's authentication status and email
@@ -140,7 +182,7 @@ func (o *OwnerController) LandingPage(c *gin.Context) {
 
 	// Add search functionality if search term is provided
 	if searchTerm != "" {
-		query = query.Where("name LIKE ?", "%"+searchTerm+"%")
+		query = models.SearchGuns(query, searchTerm)
 	}
 
 	// Count total entries for pagination
@@ -152,7 +194,7 @@ func (o *OwnerController) LandingPage(c *gin.Context) {
 
 	// Add search if provided
 	if searchTerm != "" {
-		gunQuery = gunQuery.Where("name LIKE ?", "%"+searchTerm+"%")
+		gunQuery = models.SearchGuns(gunQuery, searchTerm)
 	}
 
 	// Add sorting logic
@@ -180,17 +222,11 @@ func (o *OwnerController) LandingPage(c *gin.Context) {
 	// Calculate total pages
 	totalPages := int((totalGuns + int64(perPage) - 1) / int64(perPage))
 
-	// Check if free tier limit applies (only for display, not actual limit)
-	var showingFreeLimit bool
-	var totalUserGuns int
-	if dbUser.SubscriptionTier == "free" && totalGuns > 2 {
-		showingFreeLimit = true
-		totalUserGuns = int(totalGuns)
-
-		// Limit the guns for free tier users to only 2 guns
-		if len(guns) > 2 {
-			guns = guns[:2]
-		}
+	// Check if the plan's gun limit applies (only for display, not actual limit)
+	gunAllowance := entitlements.For(dbUser).Allowance(entitlements.Guns, totalGuns)
+	if gunAllowance.Over() && len(guns) > gunAllowance.Limit {
+		// Limit the guns for users on the plan to the ones it allows
+		guns = guns[:gunAllowance.Limit]
 	}
 
 	// Get the ammunition count for this user
@@ -241,6 +277,16 @@ func (o *OwnerController) LandingPage(c *gin.Context) {
 		}
 	}
 
+	// Get the service that is due across the user's guns
+	dueServices, err := models.FindDueServices(db, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to fetch due services", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		dueServices = []models.ServiceStatus{}
+	}
+
 	// Format subscription end date if available
 	var subscriptionEndsAt string
 	if !dbUser.SubscriptionEndDate.IsZero() {
@@ -271,7 +317,8 @@ func (o *OwnerController) LandingPage(c *gin.Context) {
 		WithAmmoCount(ammoCount).
 		WithTotalAmmoQuantity(totalAmmoQuantity).
 		WithTotalAmmoPaid(totalAmmoPaid).
-		WithTotalAmmoExpended(totalAmmoExpended)
+		WithTotalAmmoExpended(totalAmmoExpended).
+		WithDueServices(dueServices)
 
 	// Get authData from context to preserve roles
 	if authDataInterface, exists := c.Get("authData"); exists {
@@ -293,9 +340,18 @@ func (o *OwnerController) LandingPage(c *gin.Context) {
 		}
 	}
 
+	// Show how the owner's plan limits stand, counting every gun whatever the search
+	if gunCount, err := entitlements.NewService(db).Count(dbUser.ID, entitlements.Guns); err == nil {
+		ents := entitlements.For(dbUser)
+		ownerData.WithAllowances(ents.Allowance(entitlements.Guns, gunCount), ents.Allowance(entitlements.Ammo, ammoCount))
+	}
+
+	// Warn the owner if their subscription payment has failed
+	ownerData.WithPaymentNotice(dunning.Notice(dbUser))
+
 	// If the user has more guns than shown, add a message
-	if showingFreeLimit {
-		ownerData.WithError(fmt.Sprintf("Free tier only allows 2 guns. You have %d in your arsenal. Subscribe to see more.", totalUserGuns))
+	if gunAllowance.Over() {
+		ownerData.WithError(gunAllowance.OverMessage())
 		// Add a note that will display below the table
 		ownerData.WithNote("To see your remaining firearms please subscribe.")
 	}
@@ -547,19 +603,9 @@ func (o *OwnerController) Create(c *gin.Context) {
 		return
 	}
 
-	// Check if user is on free tier and already has 2 guns
-	if dbUser.SubscriptionTier == "free" {
-		var count int64
-		db := o.db.GetDB()
-		db.Model(&models.Gun{}).Where("owner_id = ?", dbUser.ID).Count(&count)
-
-		if count >= 2 {
-			if setFlash, exists := c.Get("setFlash"); exists {
-				setFlash.(func(string))("You must be subscribed to add more to your arsenal")
-			}
-			c.Redirect(http.StatusSeeOther, "/pricing")
-			return
-		}
+	// Check the user's entitlements let them add another gun
+	if !o.requireAllowance(c, dbUser, entitlements.Guns) {
+		return
 	}
 
 	// Initialize error map for form validation
@@ -821,6 +867,13 @@ func (o *OwnerController) Show(c *gin.Context) {
 				subscriptionEndsAt,
 			)
 
+		// Add the round count and service interval status
+		o.addGunMaintenance(ownerData, &gun)
+
+		// Add the gun's photos
+		photos, err := models.FindAttachmentsForGun(db, gun.ID, dbUser.ID)
+		o.addAttachments(ownerData, dbUser, photos, err)
+
 		// Get authData from context to preserve roles
 		if authDataInterface, exists := c.Get("authData"); exists {
 			if authData, ok := authDataInterface.(data.AuthData); ok {
@@ -906,6 +959,13 @@ func (o *OwnerController) Show(c *gin.Context) {
 			subscriptionEndsAt,
 		)
 
+	// Add the round count and service interval status
+	o.addGunMaintenance(ownerData, &gun)
+
+	// Add the gun's photos
+	photos, err := models.FindAttachmentsForGun(db, gun.ID, dbUser.ID)
+	o.addAttachments(ownerData, dbUser, photos, err)
+
 	// Get authData from context to preserve roles
 	if authDataInterface, exists := c.Get("authData"); exists {
 		if authData, ok := authDataInterface.(data.AuthData); ok {
@@ -1769,6 +1829,9 @@ func (o *OwnerController) Delete(c *gin.Context) {
 			return
 		}
 
+		// Remove the gun's photos along with it
+		o.deleteAttachments(c, dbUser, uint(gunIDUint), 0)
+
 		// Set flash message
 		if setFlash, exists := c.Get("setFlash"); exists {
 			setFlash.(func(string))("Your gun has been deleted.")
@@ -1821,6 +1884,9 @@ func (o *OwnerController) Delete(c *gin.Context) {
 		return
 	}
 
+	// Remove the gun's photos along with it
+	o.deleteAttachments(c, dbUser, uint(gunIDUint), 0)
+
 	// Set flash message
 	if setFlash, exists := c.Get("setFlash"); exists {
 		setFlash.(func(string))("Your gun has been deleted.")
@@ -2026,7 +2092,7 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 
 		// Add search if provided
 		if searchTerm != "" {
-			query = query.Where("name LIKE ?", "%"+searchTerm+"%")
+			query = models.SearchGuns(query, searchTerm)
 		}
 
 		// Count total guns
@@ -2039,7 +2105,7 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 
 		// Add search if provided
 		if searchTerm != "" {
-			gunQuery = gunQuery.Where("name LIKE ?", "%"+searchTerm+"%")
+			gunQuery = models.SearchGuns(gunQuery, searchTerm)
 		}
 
 		// Add sorting logic
@@ -2067,17 +2133,11 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 		// Calculate total pages
 		totalPages := int((totalGuns + int64(perPage) - 1) / int64(perPage))
 
-		// Apply free tier limit if needed - this now applies to the display only
-		var showFreeLimit bool
-		var totalUserGuns int
-		if dbUser.SubscriptionTier == "free" && totalGuns > 2 {
-			showFreeLimit = true
-			totalUserGuns = int(totalGuns)
-
-			// Limit the guns for free tier users to only 2 guns
-			if len(guns) > 2 {
-				guns = guns[:2]
-			}
+		// Apply the plan's gun limit if needed - this now applies to the display only
+		gunAllowance := entitlements.For(dbUser).Allowance(entitlements.Guns, totalGuns)
+		if gunAllowance.Over() && len(guns) > gunAllowance.Limit {
+			// Limit the guns for users on the plan to the ones it allows
+			guns = guns[:gunAllowance.Limit]
 		}
 
 		// Format subscription end date if available
@@ -2128,8 +2188,8 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 		}
 
 		// If the user is on free tier, add a message
-		if showFreeLimit {
-			ownerData.WithError(fmt.Sprintf("Free tier only allows 2 guns. You have %d in your arsenal. Subscribe to see more.", totalUserGuns))
+		if gunAllowance.Over() {
+			ownerData.WithError(gunAllowance.OverMessage())
 			// Add a note that will display below the table
 			ownerData.WithNote("To see your remaining firearms please subscribe.")
 		}
@@ -2207,7 +2267,7 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 
 	// Add search if provided
 	if searchTerm != "" {
-		query = query.Where("name LIKE ?", "%"+searchTerm+"%")
+		query = models.SearchGuns(query, searchTerm)
 	}
 
 	// Count total guns
@@ -2220,7 +2280,7 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 
 	// Add search if provided
 	if searchTerm != "" {
-		gunQuery = gunQuery.Where("name LIKE ?", "%"+searchTerm+"%")
+		gunQuery = models.SearchGuns(gunQuery, searchTerm)
 	}
 
 	// Add sorting logic
@@ -2248,17 +2308,11 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 	// Calculate total pages
 	totalPages := int((totalGuns + int64(perPage) - 1) / int64(perPage))
 
-	// Apply free tier limit if needed - this now applies to the display only
-	var showFreeLimit bool
-	var totalUserGuns int
-	if dbUser.SubscriptionTier == "free" && totalGuns > 2 {
-		showFreeLimit = true
-		totalUserGuns = int(totalGuns)
-
-		// Limit the guns for free tier users to only 2 guns
-		if len(guns) > 2 {
-			guns = guns[:2]
-		}
+	// Apply the plan's gun limit if needed - this now applies to the display only
+	gunAllowance := entitlements.For(dbUser).Allowance(entitlements.Guns, totalGuns)
+	if gunAllowance.Over() && len(guns) > gunAllowance.Limit {
+		// Limit the guns for users on the plan to the ones it allows
+		guns = guns[:gunAllowance.Limit]
 	}
 
 	// Format subscription end date if available
@@ -2305,8 +2359,8 @@ func (o *OwnerController) Arsenal(c *gin.Context) {
 	}
 
 	// If the user is on free tier, add a message
-	if showFreeLimit {
-		ownerData.WithError(fmt.Sprintf("Free tier only allows 2 guns. You have %d in your arsenal. Subscribe to see more.", totalUserGuns))
+	if gunAllowance.Over() {
+		ownerData.WithError(gunAllowance.OverMessage())
 		// Add a note that will display below the table
 		ownerData.WithNote("To see your remaining firearms please subscribe.")
 	}
@@ -2408,6 +2462,7 @@ func (o *OwnerController) Profile(c *gin.Context) {
 		}
 
 		// Render the profile page with the data
+		o.withLinkedAccounts(c, ownerData, dbUser)
 		owner.Profile(ownerData).Render(c.Request.Context(), c.Writer)
 		return
 	}
@@ -2481,6 +2536,7 @@ func (o *OwnerController) Profile(c *gin.Context) {
 	}
 
 	// Render the profile page with the data
+	o.withLinkedAccounts(c, ownerData, dbUser)
 	owner.Profile(ownerData).Render(c.Request.Context(), c.Writer)
 }
 
@@ -2946,6 +3002,8 @@ func (o *OwnerController) Subscription(c *gin.Context) {
 				subscriptionEndsAt,
 			).
 			WithStripeSubscriptionInfo(dbUser.HasStripeManagedSubscription()).
+			WithPlanChanges(stripe.PlanChanges(dbUser)).
+			WithPaymentNotice(dunning.Notice(dbUser)).
 			WithPayments(payments)
 
 		// Get authData from context to preserve roles
@@ -3027,6 +3085,8 @@ func (o *OwnerController) Subscription(c *gin.Context) {
 			subscriptionEndsAt,
 		).
 		WithStripeSubscriptionInfo(dbUser.HasStripeManagedSubscription()).
+		WithPlanChanges(stripe.PlanChanges(dbUser)).
+		WithPaymentNotice(dunning.Notice(dbUser)).
 		WithPayments(payments)
 
 	// Get authData from context to preserve roles
@@ -3217,6 +3277,16 @@ func (o *OwnerController) DeleteAccountHandler(c *gin.Context) {
 			return
 		}
 
+		// Sign the account out everywhere else
+		if _, err := models.RevokeUserSessions(o.db.GetDB(), dbUser.ID, 0); err != nil {
+			logger.Error("Failed to revoke sessions for deleted account", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+		}
+
+		// Their data exports hold a copy of everything they stored
+		o.deleteDataExports(ctx, dbUser.ID, 0)
+
 		// Clear the session
 		session := sessions.Default(c)
 		session.Clear()
@@ -3267,6 +3337,9 @@ func (o *OwnerController) DeleteAccountHandler(c *gin.Context) {
 		return
 	}
 
+	// Their data exports hold a copy of everything they stored
+	o.deleteDataExports(ctx, dbUser.ID, 0)
+
 	// Clear the session
 	session := sessions.Default(c)
 	session.Clear()
@@ -3432,19 +3505,9 @@ func (o *OwnerController) AmmoCreate(c *gin.Context) {
 		return
 	}
 
-	// Check if user is on free tier and already has 4 ammunition items
-	if dbUser.SubscriptionTier == "free" {
-		var count int64
-		db := o.db.GetDB()
-		db.Model(&models.Ammo{}).Where("owner_id = ?", dbUser.ID).Count(&count)
-
-		if count >= 4 {
-			if setFlash, exists := c.Get("setFlash"); exists {
-				setFlash.(func(string))("You must be subscribed to add more to your munitions depot")
-			}
-			c.Redirect(http.StatusSeeOther, "/pricing")
-			return
-		}
+	// Check the user's entitlements let them add another ammunition lot
+	if !o.requireAllowance(c, dbUser, entitlements.Ammo) {
+		return
 	}
 
 	// Parse form values
@@ -3847,28 +3910,26 @@ func (o *OwnerController) AmmoIndex(c *gin.Context) {
 		}
 	}
 
-	// Check if free tier limit applies (only for display, not actual limit)
-	var showingFreeLimit bool
-	if dbUser.SubscriptionTier == "free" && ammoCount > 4 {
-		showingFreeLimit = true
-
-		// For free tier users in the test, we need to show items 1-4, not the newest ones
-		// Get the first 4 items ordered by creation time ascending
-		var firstFourItems []models.Ammo
+	// Check if the plan's ammunition limit applies (only for display, not actual limit)
+	ammoAllowance := entitlements.For(dbUser).Allowance(entitlements.Ammo, ammoCount)
+	if ammoAllowance.Over() {
+		// Show the first items the plan allows, not the newest ones
+		// Get them ordered by creation time ascending
+		var allowedItems []models.Ammo
 		query := db.Preload("Brand").Preload("Caliber").Preload("BulletStyle").
 			Preload("Grain").Preload("Casing").
 			Where("owner_id = ?", dbUser.ID).
 			Order("created_at asc").
-			Limit(4)
+			Limit(ammoAllowance.Limit)
 
-		if err := query.Find(&firstFourItems).Error; err != nil {
-			logger.Error("Failed to fetch first four ammunition items", err, map[string]interface{}{
+		if err := query.Find(&allowedItems).Error; err != nil {
+			logger.Error("Failed to fetch the ammunition items the plan allows", err, map[string]interface{}{
 				"user_id": dbUser.ID,
 				"email":   dbUser.Email,
 			})
 		} else {
-			// Replace the items with the first 4
-			ammoItems = firstFourItems
+			// Replace the items with the ones the plan allows
+			ammoItems = allowedItems
 		}
 	}
 
@@ -3888,8 +3949,8 @@ func (o *OwnerController) AmmoIndex(c *gin.Context) {
 		WithTotalAmmoExpended(totalAmmoExpended)
 
 	// If the user has more ammunition than shown due to free tier, add a message
-	if showingFreeLimit {
-		ownerData.WithError(fmt.Sprintf("Free tier only allows 4 ammunition items. You have %d in your depot. Subscribe to see more.", ammoCount))
+	if ammoAllowance.Over() {
+		ownerData.WithError(ammoAllowance.OverMessage())
 		// Add a note that will display below the table
 		ownerData.WithNote("To see your remaining ammunition, please <a href='/pricing' class='text-brass-800 hover:text-brass-600 underline font-bold'>subscribe</a>.")
 	}
@@ -4003,6 +4064,10 @@ func (o *OwnerController) AmmoShow(c *gin.Context) {
 		WithUser(dbUser).
 		WithAmmo([]models.Ammo{*ammo}) // Put in slice for consistency with other views
 
+	// Add the ammunition's photos, such as receipts
+	photos, err := models.FindAttachmentsForAmmo(db, ammo.ID, dbUser.ID)
+	o.addAttachments(ownerData, dbUser, photos, err)
+
 	// Set authentication data
 	if csrfToken, exists := c.Get("csrf_token"); exists {
 		if token, ok := csrfToken.(string); ok {
@@ -4560,6 +4625,9 @@ func (o *OwnerController) AmmoDelete(c *gin.Context) {
 		return
 	}
 
+	// Remove the ammunition's photos along with it
+	o.deleteAttachments(c, dbUser, 0, ammo.ID)
+
 	// Set a success flash message
 	session := sessions.Default(c)
 	session.AddFlash("Ammunition deleted successfully")
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// apiTokenLifetimes are the number of days a new token can last, as offered on the token form
+var apiTokenLifetimes = map[int]bool{7: true, 30: true, 90: true, 365: true}
+
+// APITokens lists the owner's personal access tokens, with a form to create one
+func (o *OwnerController) APITokens(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	o.renderAPITokens(c, dbUser, "", "", http.StatusOK)
+}
+
+// APITokenCreate creates a personal access token and shows it, the only time it can be seen
+func (o *OwnerController) APITokenCreate(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	days, _ := strconv.Atoi(c.PostForm("expires_in"))
+	if !apiTokenLifetimes[days] {
+		o.renderAPITokens(c, dbUser, "", "Choose when the token expires", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var token string
+	err := o.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var record *models.APIToken
+		var err error
+		token, record, err = models.CreateAPIToken(tx, dbUser.ID, c.PostForm("name"), c.PostFormArray("scopes"), time.Now().AddDate(0, 0, days))
+		if err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, dbUser.ID, models.SecurityEventAPITokenCreated, "Created API token "+record.Name, 0)
+	})
+	if err != nil {
+		message := "Failed to create the token, please try again"
+		switch {
+		case errors.Is(err, models.ErrAPITokenNameRequired):
+			message = "Give the token a name"
+		case errors.Is(err, models.ErrAPITokenNameTooLong):
+			message = "Token names can't be longer than 100 characters"
+		case errors.Is(err, models.ErrAPITokenScopesRequired):
+			message = "Choose at least one thing the token can do"
+		case errors.Is(err, models.ErrAPITokenInvalidScope):
+			message = "Choose from the scopes listed"
+		default:
+			logger.Error("Failed to create API token", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+		}
+		o.renderAPITokens(c, dbUser, "", message, http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.Info("API token created", map[string]interface{}{
+		"user_id": dbUser.ID,
+	})
+	o.renderAPITokens(c, dbUser, token, "", http.StatusOK)
+}
+
+// APITokenDelete revokes one of the owner's personal access tokens
+func (o *OwnerController) APITokenDelete(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	tokenID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	var deleted *models.APIToken
+	err := o.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if deleted, err = models.DeleteAPIToken(tx, dbUser.ID, uint(tokenID)); err != nil || deleted == nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, dbUser.ID, models.SecurityEventAPITokenRevoked, "Revoked API token "+deleted.Name, 0)
+	})
+
+	session := sessions.Default(c)
+	switch {
+	case err != nil:
+		logger.Error("Failed to revoke API token", err, map[string]interface{}{
+			"user_id":  dbUser.ID,
+			"token_id": tokenID,
+		})
+		session.AddFlash("Failed to revoke the token, please try again")
+	case deleted == nil:
+		session.AddFlash("Token not found")
+	default:
+		logger.Info("API token revoked", map[string]interface{}{
+			"user_id":  dbUser.ID,
+			"token_id": deleted.ID,
+		})
+		session.AddFlash("Token revoked")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile/api-tokens")
+}
+
+// renderAPITokens renders the owner's tokens page, showing a token just created
+func (o *OwnerController) renderAPITokens(c *gin.Context, dbUser *database.User, newToken string, errorMessage string, status int) {
+	tokens, err := models.FindAPITokensByUser(o.db.GetDB(), dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load API tokens", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load API tokens")
+		return
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle("API Tokens").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithAPITokens(tokens, newToken).
+		WithError(errorMessage)
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "API Tokens")
+
+	if newToken != "" {
+		// The token is only shown this once, so keep it out of caches
+		c.Header("Cache-Control", "no-store")
+	}
+	c.Status(status)
+	owner.APITokens(ownerData).Render(c.Request.Context(), c.Writer)
+}
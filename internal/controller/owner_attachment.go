@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/attachments"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+	"github.com/hail2skins/armory/internal/services/storage"
+)
+
+// GunAttachmentCreate attaches an uploaded photo to one of the owner's guns
+func (o *OwnerController) GunAttachmentCreate(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	gunID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	gun, err := models.FindGunByID(o.db.GetDB(), uint(gunID), dbUser.ID)
+	if err != nil {
+		session := sessions.Default(c)
+		session.AddFlash("That's not your gun!")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner")
+		return
+	}
+
+	o.createAttachment(c, dbUser, attachments.Upload{GunID: &gun.ID}, fmt.Sprintf("/owner/guns/%d", gun.ID))
+}
+
+// AmmoAttachmentCreate attaches an uploaded photo, such as a receipt, to one of the owner's ammunition lots
+func (o *OwnerController) AmmoAttachmentCreate(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	ammoID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ammo, err := models.FindAmmoByID(o.db.GetDB(), uint(ammoID), dbUser.ID)
+	if err != nil {
+		session := sessions.Default(c)
+		session.AddFlash("Ammunition not found")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/munitions")
+		return
+	}
+
+	o.createAttachment(c, dbUser, attachments.Upload{AmmoID: &ammo.ID}, fmt.Sprintf("/owner/munitions/%d", ammo.ID))
+}
+
+// AttachmentShow sends an attached photo
+func (o *OwnerController) AttachmentShow(c *gin.Context) {
+	o.serveAttachment(c, false)
+}
+
+// AttachmentThumbnail sends the thumbnail of an attached photo
+func (o *OwnerController) AttachmentThumbnail(c *gin.Context) {
+	o.serveAttachment(c, true)
+}
+
+// AttachmentDelete removes an attached photo
+func (o *OwnerController) AttachmentDelete(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	attachmentID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	attachment, err := models.FindAttachmentByID(o.db.GetDB(), uint(attachmentID), dbUser.ID)
+	if err != nil {
+		session := sessions.Default(c)
+		session.AddFlash("Photo not found")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner")
+		return
+	}
+
+	session := sessions.Default(c)
+	if err := o.attachmentService().Delete(c.Request.Context(), attachment); err != nil {
+		logger.Error("Failed to delete attachment", err, map[string]interface{}{
+			"user_id":       dbUser.ID,
+			"attachment_id": attachment.ID,
+		})
+		session.AddFlash("Failed to delete the photo, please try again")
+	} else {
+		session.AddFlash("Photo deleted")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, attachment.ParentPath())
+}
+
+// createAttachment stores the photo uploaded in the "photo" field and goes back to the page it was attached from
+func (o *OwnerController) createAttachment(c *gin.Context, dbUser *database.User, upload attachments.Upload, redirect string) {
+	// Leave room for the rest of the form around the photo
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, attachments.MaxUploadSize+1<<20)
+
+	session := sessions.Default(c)
+	defer func() {
+		session.Save()
+		c.Redirect(http.StatusSeeOther, redirect)
+	}()
+
+	file, err := c.FormFile("photo")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			session.AddFlash("Couldn't attach the photo: " + attachments.ErrTooLarge.Error())
+		} else {
+			session.AddFlash("Choose a photo to upload")
+		}
+		return
+	}
+	if file.Size > attachments.MaxUploadSize {
+		session.AddFlash("Couldn't attach the photo: " + attachments.ErrTooLarge.Error())
+		return
+	}
+	f, err := file.Open()
+	if err != nil {
+		session.AddFlash("Couldn't attach the photo, please try again")
+		return
+	}
+	defer f.Close()
+
+	upload.OwnerID = dbUser.ID
+	upload.Tier = entitlements.For(dbUser).Plan.Tier // Lapsed subscriptions get the free tier's quota
+	upload.Filename = file.Filename
+	upload.Caption = c.PostForm("caption")
+	upload.File = f
+
+	if _, err := o.attachmentService().Create(c.Request.Context(), upload); err != nil {
+		switch {
+		case errors.Is(err, attachments.ErrUnsupportedType),
+			errors.Is(err, attachments.ErrTooLarge),
+			errors.Is(err, attachments.ErrTooManyPixels),
+			errors.Is(err, attachments.ErrCaptionTooLong),
+			errors.Is(err, attachments.ErrQuotaExceeded),
+			errors.Is(err, attachments.ErrNoStorage):
+			session.AddFlash("Couldn't attach the photo: " + err.Error())
+		default:
+			logger.Error("Failed to store attachment", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+			session.AddFlash("Couldn't attach the photo, please try again")
+		}
+		return
+	}
+	session.AddFlash("Photo attached")
+}
+
+// serveAttachment sends an attachment's photo or thumbnail to its owner
+func (o *OwnerController) serveAttachment(c *gin.Context, thumbnail bool) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	attachmentID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	attachment, err := models.FindAttachmentByID(o.db.GetDB(), uint(attachmentID), dbUser.ID)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	file, err := o.attachmentService().Open(c.Request.Context(), attachment, thumbnail)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			logger.Error("Failed to read attachment", err, map[string]interface{}{
+				"user_id":       dbUser.ID,
+				"attachment_id": attachment.ID,
+			})
+		}
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	contentType, size := attachment.ContentType, attachment.Size
+	if thumbnail {
+		contentType, size = "image/jpeg", attachment.ThumbnailSize
+	}
+	c.DataFromReader(http.StatusOK, size, contentType, file, map[string]string{
+		"Cache-Control":          "private, max-age=86400",
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// addAttachments adds the photos attached to a gun or ammunition lot, and the owner's storage use, to the owner data
+func (o *OwnerController) addAttachments(ownerData *data.OwnerData, dbUser *database.User, photos []models.Attachment, err error) {
+	if err != nil {
+		logger.Error("Failed to fetch attachments", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		photos = []models.Attachment{}
+	}
+
+	used, err := o.attachmentService().Usage(dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to total attachment storage", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+	}
+	ownerData.WithAttachments(photos, used, entitlements.For(dbUser).StorageQuota())
+}
+
+// deleteAttachments removes the photos attached to a deleted gun or ammunition lot.
+// Failing to remove them is logged rather than failing the delete.
+func (o *OwnerController) deleteAttachments(c *gin.Context, dbUser *database.User, gunID uint, ammoID uint) {
+	var err error
+	if gunID != 0 {
+		err = o.attachmentService().DeleteForGun(c.Request.Context(), gunID, dbUser.ID)
+	} else {
+		err = o.attachmentService().DeleteForAmmo(c.Request.Context(), ammoID, dbUser.ID)
+	}
+	if err != nil {
+		logger.Error("Failed to delete attachments", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"gun_id":  gunID,
+			"ammo_id": ammoID,
+		})
+	}
+}
+
+// attachmentService returns the service that stores attached photos
+func (o *OwnerController) attachmentService() *attachments.Service {
+	return attachments.NewService(o.db.GetDB(), o.storage)
+}
+
+// newStorage returns the file storage configured by the environment. A bad
+// configuration turns photo uploads off rather than stopping the server.
+func newStorage() storage.Storage {
+	store, err := storage.NewStorage()
+	if err != nil {
+		logger.Error("Failed to set up file storage, photos can't be attached", err, nil)
+		return nil
+	}
+	return store
+}
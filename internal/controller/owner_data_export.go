@@ -0,0 +1,630 @@
+package controller
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/email"
+	"gorm.io/gorm"
+)
+
+// dataExportLifetime is how long a finished export can be downloaded before it's deleted
+const dataExportLifetime = 24 * time.Hour
+
+// dataExportTimeout is how long building an export may take
+const dataExportTimeout = 5 * time.Minute
+
+// dataExportStaleAfter is how long an export can be pending before it's
+// taken to have failed, as the server building it stopped part way
+const dataExportStaleAfter = 2 * dataExportTimeout
+
+var (
+	dataExportKeyOnce sync.Once
+	dataExportKey     []byte
+)
+
+// dataExportSigningKey returns the key download links are signed with, from
+// DATA_EXPORT_SIGNING_KEY. Without it a random key is used, so links stop
+// working when the server restarts and only work on the server that made them.
+func dataExportSigningKey() []byte {
+	dataExportKeyOnce.Do(func() {
+		if key := os.Getenv("DATA_EXPORT_SIGNING_KEY"); key != "" {
+			dataExportKey = []byte(key)
+			return
+		}
+		logger.Warn("DATA_EXPORT_SIGNING_KEY is not set, data export links will stop working on restart", nil)
+		dataExportKey = make([]byte, 32)
+		if _, err := rand.Read(dataExportKey); err != nil {
+			panic(err)
+		}
+	})
+	return dataExportKey
+}
+
+// dataExportSignature signs a download link for one of a user's exports until expires
+func dataExportSignature(export *models.DataExport, expires int64) string {
+	mac := hmac.New(sha256.New, dataExportSigningKey())
+	fmt.Fprintf(mac, "data-export:%d:%d:%d", export.ID, export.UserID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// dataExportDownloadPath returns a signed link to download an export, which
+// works until the export expires
+func dataExportDownloadPath(export *models.DataExport) string {
+	expires := time.Now().Add(dataExportLifetime).Unix()
+	if export.ExpiresAt != nil {
+		expires = export.ExpiresAt.Unix()
+	}
+	return fmt.Sprintf("/owner/profile/export/%d/download?expires=%d&signature=%s",
+		export.ID, expires, dataExportSignature(export, expires))
+}
+
+// DataExport shows the owner's latest export and lets them ask for another
+func (o *OwnerController) DataExport(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	export, err := o.latestDataExport(dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load data export", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load your data export")
+		return
+	}
+
+	downloadURL := ""
+	if export != nil && export.Downloadable(time.Now()) {
+		downloadURL = dataExportDownloadPath(export)
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle("Export Your Data").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithDataExport(export, downloadURL)
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Export Your Data")
+
+	owner.DataExport(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// DataExportCreate starts building an export of everything the owner has
+// stored. It's built in the background and the owner is emailed a link to it.
+func (o *OwnerController) DataExportCreate(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+	session := sessions.Default(c)
+	done := func(message string) {
+		session.AddFlash(message)
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/profile/export")
+	}
+
+	if o.storage == nil {
+		done("Data exports aren't available right now, please try again later")
+		return
+	}
+
+	db := o.db.GetDB()
+	latest, err := o.latestDataExport(dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load data export", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		done("Failed to start your export, please try again")
+		return
+	}
+	if latest != nil && latest.Status == models.DataExportPending {
+		done("Your export is already being prepared. We'll email you when it's ready.")
+		return
+	}
+
+	export := &models.DataExport{UserID: dbUser.ID, Status: models.DataExportPending}
+	if err := db.Create(export).Error; err != nil {
+		logger.Error("Failed to create data export", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		done("Failed to start your export, please try again")
+		return
+	}
+
+	var emailService email.EmailService
+	if service, exists := c.Get("emailService"); exists {
+		emailService, _ = service.(email.EmailService)
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+
+	logger.Info("Data export requested", map[string]interface{}{
+		"user_id":   dbUser.ID,
+		"export_id": export.ID,
+	})
+	o.runJob(func() {
+		o.buildDataExport(export, baseURL, emailService)
+	})
+	done("We're preparing your export. We'll email you a link to download it when it's ready.")
+}
+
+// DataExportDownload serves an export's archive to the owner through the
+// signed link they were emailed
+func (o *OwnerController) DataExportDownload(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	exportID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	export, err := models.FindDataExport(o.db.GetDB(), dbUser.ID, uint(exportID))
+	if err != nil {
+		logger.Error("Failed to load data export", err, map[string]interface{}{
+			"user_id":   dbUser.ID,
+			"export_id": exportID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load your data export")
+		return
+	}
+	if export == nil {
+		c.String(http.StatusNotFound, "Export not found")
+		return
+	}
+
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	signature := c.Query("signature")
+	if err != nil || !hmac.Equal([]byte(signature), []byte(dataExportSignature(export, expires))) {
+		logger.Warn("Data export download with an invalid signature", map[string]interface{}{
+			"user_id":   dbUser.ID,
+			"export_id": export.ID,
+		})
+		c.String(http.StatusForbidden, "This download link isn't valid")
+		return
+	}
+	now := time.Now()
+	if now.Unix() >= expires || !export.Downloadable(now) {
+		c.String(http.StatusGone, "This download link has expired. You can ask for a new export from your profile.")
+		return
+	}
+
+	file, err := o.storage.Open(c.Request.Context(), export.StorageKey)
+	if err != nil {
+		logger.Error("Failed to open data export", err, map[string]interface{}{
+			"user_id":   dbUser.ID,
+			"export_id": export.ID,
+		})
+		c.String(http.StatusGone, "This export is no longer available. You can ask for a new export from your profile.")
+		return
+	}
+	defer file.Close()
+
+	filename := fmt.Sprintf("armory-export-%s.zip", export.CreatedAt.Format("2006-01-02"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.DataFromReader(http.StatusOK, export.Size, "application/zip", file, nil)
+}
+
+// latestDataExport returns the owner's most recent export, recording that it
+// failed if it has been pending for too long
+func (o *OwnerController) latestDataExport(userID uint) (*models.DataExport, error) {
+	db := o.db.GetDB()
+	export, err := models.FindLatestDataExport(db, userID)
+	if err != nil || export == nil {
+		return export, err
+	}
+	if export.Status == models.DataExportPending && time.Since(export.CreatedAt) > dataExportStaleAfter {
+		logger.Warn("Data export was never finished", map[string]interface{}{
+			"user_id":   userID,
+			"export_id": export.ID,
+		})
+		if err := models.FailDataExport(db, export); err != nil {
+			return nil, err
+		}
+	}
+	return export, nil
+}
+
+// buildDataExport builds an export's archive, stores it and emails the owner a
+// link to it. Older exports are deleted once the new one is ready.
+func (o *OwnerController) buildDataExport(export *models.DataExport, baseURL string, emailService email.EmailService) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataExportTimeout)
+	defer cancel()
+	db := o.db.GetDB()
+	fields := map[string]interface{}{
+		"user_id":   export.UserID,
+		"export_id": export.ID,
+	}
+
+	fail := func(message string, err error) {
+		logger.Error(message, err, fields)
+		if err := models.FailDataExport(db, export); err != nil {
+			logger.Error("Failed to record data export failure", err, fields)
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			fail("Data export panicked", fmt.Errorf("%v", r))
+		}
+	}()
+
+	user, err := o.db.GetUserByID(export.UserID)
+	if err != nil {
+		fail("Failed to load user for data export", err)
+		return
+	}
+
+	var archive bytes.Buffer
+	if err := writeDataExport(db.WithContext(ctx), user, &archive); err != nil {
+		fail("Failed to build data export", err)
+		return
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		fail("Failed to name data export", err)
+		return
+	}
+	key := fmt.Sprintf("exports/%d/%s.zip", user.ID, hex.EncodeToString(random))
+	size := int64(archive.Len())
+	if err := o.storage.Put(ctx, key, &archive, size, "application/zip"); err != nil {
+		fail("Failed to store data export", err)
+		return
+	}
+	if err := models.CompleteDataExport(db, export, key, size, time.Now().Add(dataExportLifetime)); err != nil {
+		o.storage.Delete(ctx, key)
+		fail("Failed to record data export", err)
+		return
+	}
+	o.deleteDataExports(ctx, export.UserID, export.ID)
+
+	logger.Info("Data export ready", fields)
+	if emailService == nil {
+		logger.Warn("Email service not available to send data export link", fields)
+		return
+	}
+	if err := emailService.SendDataExportEmail(user.Email, baseURL+dataExportDownloadPath(export), *export.ExpiresAt); err != nil {
+		logger.Error("Failed to send data export email", err, fields)
+	}
+}
+
+// deleteDataExports removes the owner's exports from before the one with ID
+// before, or all of them when before is 0, with their archives
+func (o *OwnerController) deleteDataExports(ctx context.Context, userID, before uint) {
+	db := o.db.GetDB()
+	exports, err := models.FindDataExportsByUser(db, userID)
+	if err != nil {
+		logger.Error("Failed to load old data exports", err, map[string]interface{}{
+			"user_id": userID,
+		})
+		return
+	}
+	for _, export := range exports {
+		if before != 0 && export.ID >= before {
+			continue
+		}
+		if export.StorageKey != "" && o.storage != nil {
+			if err := o.storage.Delete(ctx, export.StorageKey); err != nil {
+				logger.Error("Failed to delete old data export", err, map[string]interface{}{
+					"export_id": export.ID,
+				})
+				continue
+			}
+		}
+		db.Unscoped().Delete(&export)
+	}
+}
+
+// SweepDataExports records that exports which were never finished failed,
+// and deletes the archives of exports that have expired
+func (o *OwnerController) SweepDataExports(ctx context.Context, now time.Time) error {
+	db := o.db.GetDB()
+	failed, err := models.FailStaleDataExports(db, now.Add(-dataExportStaleAfter))
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		logger.Warn("Data exports were never finished", map[string]interface{}{
+			"count": failed,
+		})
+	}
+
+	if o.storage == nil {
+		return nil
+	}
+	expired, err := models.FindExpiredDataExports(db, now)
+	if err != nil {
+		return err
+	}
+	for _, export := range expired {
+		if err := o.storage.Delete(ctx, export.StorageKey); err != nil {
+			logger.Error("Failed to delete expired data export", err, map[string]interface{}{
+				"export_id": export.ID,
+			})
+			continue
+		}
+		if err := models.ClearDataExportArchive(db, &export); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartDataExportSweep sweeps data exports every interval until stop is closed
+func (o *OwnerController) StartDataExportSweep(stop chan struct{}, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := o.SweepDataExports(context.Background(), time.Now()); err != nil {
+				logger.Error("Failed to sweep data exports", err, nil)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				logger.Info("Stopping data export sweeps", nil)
+				return
+			}
+		}
+	}()
+}
+
+// dataExportUser is the owner's account as the export shows it, without
+// passwords, tokens or other secrets
+type dataExportUser struct {
+	ID                  uint      `json:"id"`
+	Email               string    `json:"email"`
+	Verified            bool      `json:"verified"`
+	TwoFactorEnabled    bool      `json:"two_factor_enabled"`
+	SubscriptionTier    string    `json:"subscription_tier"`
+	SubscriptionStatus  string    `json:"subscription_status"`
+	SubscriptionEndDate *string   `json:"subscription_end_date"`
+	LifetimeMember      bool      `json:"lifetime_member"`
+	LastLogin           *string   `json:"last_login"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// dataExportPayment is a payment as the export shows it
+type dataExportPayment struct {
+	ID          uint      `json:"id"`
+	Date        time.Time `json:"date"`
+	Description string    `json:"description"`
+	Type        string    `json:"type"`
+	Status      string    `json:"status"`
+	Amount      string    `json:"amount"`
+	Currency    string    `json:"currency"`
+	Reference   string    `json:"reference"`
+}
+
+// dataExportPromotion is a promotion the owner received
+type dataExportPromotion struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	BenefitDays int    `json:"benefit_days"`
+	Description string `json:"description"`
+}
+
+// writeDataExport writes a zip of everything a user has stored to w, each
+// kind of record as both JSON and CSV
+func writeDataExport(db *gorm.DB, user *database.User, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	// The account
+	account := dataExportUser{
+		ID:                 user.ID,
+		Email:              user.Email,
+		Verified:           user.Verified,
+		TwoFactorEnabled:   user.TOTPEnabled,
+		SubscriptionTier:   user.SubscriptionTier,
+		SubscriptionStatus: user.SubscriptionStatus,
+		LifetimeMember:     user.IsLifetime,
+		CreatedAt:          user.CreatedAt,
+	}
+	if !user.SubscriptionEndDate.IsZero() {
+		account.SubscriptionEndDate = apiDate(&user.SubscriptionEndDate)
+	}
+	if !user.LastLogin.IsZero() {
+		lastLogin := user.LastLogin.Format(time.RFC3339)
+		account.LastLogin = &lastLogin
+	}
+	accountRows := [][]string{{
+		strconv.FormatUint(uint64(account.ID), 10),
+		csvText(account.Email),
+		strconv.FormatBool(account.Verified),
+		strconv.FormatBool(account.TwoFactorEnabled),
+		csvText(account.SubscriptionTier),
+		csvText(account.SubscriptionStatus),
+		csvDate(nonZeroTime(user.SubscriptionEndDate)),
+		strconv.FormatBool(account.LifetimeMember),
+		account.CreatedAt.Format("2006-01-02"),
+	}}
+	err := writeDataExportFiles(archive, "account", account,
+		[]string{"ID", "Email", "Verified", "Two-Factor Enabled", "Subscription", "Subscription Status", "Subscription Ends", "Lifetime Member", "Joined"}, accountRows)
+	if err != nil {
+		return err
+	}
+
+	// Guns
+	var guns []models.Gun
+	err = db.Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").
+		Where("owner_id = ?", user.ID).Order("id ASC").Find(&guns).Error
+	if err != nil {
+		return err
+	}
+	gunViews := make([]apiGun, 0, len(guns))
+	gunRows := make([][]string, 0, len(guns))
+	for _, gun := range guns {
+		gunViews = append(gunViews, newAPIGun(gun))
+		gunRows = append(gunRows, gunExportRow(gun))
+	}
+	if err := writeDataExportFiles(archive, "guns", gunViews, gunExportHeader, gunRows); err != nil {
+		return err
+	}
+
+	// Ammunition
+	var ammo []models.Ammo
+	err = db.Preload("Brand").Preload("BulletStyle").Preload("Grain").Preload("Caliber").Preload("Casing").
+		Where("owner_id = ?", user.ID).Order("id ASC").Find(&ammo).Error
+	if err != nil {
+		return err
+	}
+	ammoViews := make([]apiAmmo, 0, len(ammo))
+	ammoRows := make([][]string, 0, len(ammo))
+	for _, lot := range ammo {
+		ammoViews = append(ammoViews, newAPIAmmo(lot))
+		ammoRows = append(ammoRows, ammoExportRow(lot))
+	}
+	if err := writeDataExportFiles(archive, "ammunition", ammoViews, ammoExportHeader, ammoRows); err != nil {
+		return err
+	}
+
+	// Range days, a CSV row for each gun and ammunition lot fired
+	var rangeDays []models.RangeDay
+	err = db.Preload("Range").
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Preload("Items.Gun").Preload("Items.Ammo").
+		Where("user_id = ?", user.ID).Order("date ASC, id ASC").Find(&rangeDays).Error
+	if err != nil {
+		return err
+	}
+	rangeDayViews := make([]apiRangeDay, 0, len(rangeDays))
+	var rangeDayRows [][]string
+	for _, rangeDay := range rangeDays {
+		view := newAPIRangeDay(rangeDay)
+		rangeDayViews = append(rangeDayViews, view)
+		day := []string{view.Date, csvText(view.Range.Name), csvText(view.Comments)}
+		if len(view.Items) == 0 {
+			rangeDayRows = append(rangeDayRows, append(day, "", "", "", ""))
+		}
+		for _, item := range view.Items {
+			rangeDayRows = append(rangeDayRows, append(append([]string{}, day...),
+				csvText(item.Gun.Name), csvText(item.Ammo.Name), strconv.Itoa(item.ShotsFired), csvText(item.Notes)))
+		}
+	}
+	err = writeDataExportFiles(archive, "range-days", rangeDayViews,
+		[]string{"Date", "Range", "Comments", "Gun", "Ammunition", "Shots Fired", "Notes"}, rangeDayRows)
+	if err != nil {
+		return err
+	}
+
+	// Payments
+	var payments []models.Payment
+	if err := db.Where("user_id = ?", user.ID).Order("created_at ASC, id ASC").Find(&payments).Error; err != nil {
+		return err
+	}
+	paymentViews := make([]dataExportPayment, 0, len(payments))
+	paymentRows := make([][]string, 0, len(payments))
+	for _, payment := range payments {
+		view := dataExportPayment{
+			ID:          payment.ID,
+			Date:        payment.CreatedAt,
+			Description: payment.Description,
+			Type:        payment.PaymentType,
+			Status:      payment.Status,
+			Amount:      strconv.FormatFloat(float64(payment.Amount)/100, 'f', 2, 64),
+			Currency:    payment.Currency,
+			Reference:   payment.StripeID,
+		}
+		paymentViews = append(paymentViews, view)
+		paymentRows = append(paymentRows, []string{
+			view.Date.Format("2006-01-02"), csvText(view.Description), csvText(view.Type), csvText(view.Status),
+			view.Amount, csvText(view.Currency), csvText(view.Reference),
+		})
+	}
+	err = writeDataExportFiles(archive, "payments", paymentViews,
+		[]string{"Date", "Description", "Type", "Status", "Amount", "Currency", "Reference"}, paymentRows)
+	if err != nil {
+		return err
+	}
+
+	// Promotions received
+	promotionViews := []dataExportPromotion{}
+	var promotionRows [][]string
+	if user.PromotionID != 0 {
+		var promotion models.Promotion
+		err := db.Unscoped().First(&promotion, user.PromotionID).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		if err == nil {
+			promotionViews = append(promotionViews, dataExportPromotion{
+				ID:          promotion.ID,
+				Name:        promotion.Name,
+				Type:        promotion.Type,
+				BenefitDays: promotion.BenefitDays,
+				Description: promotion.Description,
+			})
+			promotionRows = append(promotionRows, []string{
+				csvText(promotion.Name), csvText(promotion.Type), strconv.Itoa(promotion.BenefitDays), csvText(promotion.Description),
+			})
+		}
+	}
+	err = writeDataExportFiles(archive, "promotions", promotionViews,
+		[]string{"Name", "Type", "Benefit Days", "Description"}, promotionRows)
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeDataExportFiles adds name.json and name.csv to the archive
+func writeDataExportFiles(archive *zip.Writer, name string, records interface{}, header []string, rows [][]string) error {
+	file, err := archive.Create(name + ".json")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(records); err != nil {
+		return err
+	}
+
+	file, err = archive.Create(name + ".csv")
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// nonZeroTime returns a pointer to t, or nil when it's the zero time
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// exportBatchSize is how many rows are read from the database per CSV flush
+const exportBatchSize = 100
+
+// gunExportHeader is the header row of the arsenal CSV export
+var gunExportHeader = []string{"Name", "Manufacturer", "Caliber", "Weapon Type", "Serial Number", "Finish", "Purpose", "Paid", "Acquired"}
+
+// ammoExportHeader is the header row of the ammunition CSV export
+var ammoExportHeader = []string{"Name", "Brand", "Caliber", "Grain", "Bullet Style", "Casing", "Count", "Expended", "Paid", "Acquired"}
+
+// GunExport streams every gun the owner has as a CSV file
+func (o *OwnerController) GunExport(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	query := o.db.GetDB().Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").
+		Where("owner_id = ?", dbUser.ID)
+
+	writer := startCSVExport(c, "guns")
+	if err := writer.Write(gunExportHeader); err != nil {
+		return
+	}
+
+	var guns []models.Gun
+	result := query.FindInBatches(&guns, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, gun := range guns {
+			if err := writer.Write(gunExportRow(gun)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	finishCSVExport(writer, result.Error, dbUser, "guns")
+}
+
+// AmmoExport streams every ammunition lot the owner has as a CSV file
+func (o *OwnerController) AmmoExport(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	query := o.db.GetDB().Preload("Brand").Preload("BulletStyle").Preload("Grain").
+		Preload("Caliber").Preload("Casing").
+		Where("owner_id = ?", dbUser.ID)
+
+	writer := startCSVExport(c, "ammunition")
+	if err := writer.Write(ammoExportHeader); err != nil {
+		return
+	}
+
+	var ammo []models.Ammo
+	result := query.FindInBatches(&ammo, exportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, lot := range ammo {
+			if err := writer.Write(ammoExportRow(lot)); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	})
+	finishCSVExport(writer, result.Error, dbUser, "ammunition")
+}
+
+// currentOwner gets the logged in owner, redirecting to the login page when there isn't one
+func (o *OwnerController) currentOwner(c *gin.Context) (*database.User, bool) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return nil, false
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return nil, false
+	}
+
+	// Get the user from the database
+	dbUser, err := o.db.GetUserByEmail(context.Background(), userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return nil, false
+	}
+	return dbUser, true
+}
+
+// startCSVExport sets the download headers and returns a CSV writer on the response
+func startCSVExport(c *gin.Context, name string) *csv.Writer {
+	filename := fmt.Sprintf("armory-%s-%s.csv", name, time.Now().Format("2006-01-02"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	return csv.NewWriter(c.Writer)
+}
+
+// finishCSVExport flushes the export and logs a failure part way through it.
+// The status has already been sent by then, so the download is just cut short.
+func finishCSVExport(writer *csv.Writer, err error, dbUser *database.User, name string) {
+	writer.Flush()
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		logger.Error("Failed to export "+name, err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+	}
+}
+
+// gunExportRow formats a gun as a row of the arsenal export
+func gunExportRow(gun models.Gun) []string {
+	return []string{
+		csvText(gun.Name),
+		csvText(gun.Manufacturer.Name),
+		csvText(gun.Caliber.Caliber),
+		csvText(gun.WeaponType.Type),
+		csvText(gun.SerialNumber),
+		csvText(gun.Finish),
+		csvText(gun.Purpose),
+		csvMoney(gun.Paid),
+		csvDate(gun.Acquired),
+	}
+}
+
+// ammoExportRow formats an ammunition lot as a row of the ammunition export
+func ammoExportRow(ammo models.Ammo) []string {
+	grain := ""
+	if ammo.Grain.ID > 0 {
+		grain = "Other/Custom"
+		if ammo.Grain.Weight > 0 {
+			grain = strconv.Itoa(ammo.Grain.Weight)
+		}
+	}
+
+	return []string{
+		csvText(ammo.Name),
+		csvText(ammo.Brand.Name),
+		csvText(ammo.Caliber.Caliber),
+		grain,
+		csvText(ammo.BulletStyle.Type),
+		csvText(ammo.Casing.Type),
+		strconv.Itoa(ammo.Count),
+		strconv.Itoa(ammo.Expended),
+		csvMoney(ammo.Paid),
+		csvDate(ammo.Acquired),
+	}
+}
+
+// csvText guards free text against being run as a formula when the export is opened in a spreadsheet
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// csvMoney formats an optional price, leaving it blank when unset
+func csvMoney(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 2, 64)
+}
+
+// csvDate formats an optional date, leaving it blank when unset
+func csvDate(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.Format("2006-01-02")
+}
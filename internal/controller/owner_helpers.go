@@ -1,7 +1,15 @@
 package controller
 
 import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/entitlements"
 )
 
 // calculateTotalPaid calculates the total amount paid for all guns
@@ -14,3 +22,69 @@ func calculateTotalPaid(guns []models.Gun) float64 {
 	}
 	return total
 }
+
+// requireAllowance sends the owner to the pricing page, explaining why, when
+// their entitlements don't let them add another of a resource
+func (o *OwnerController) requireAllowance(c *gin.Context, dbUser *database.User, resource entitlements.Resource) bool {
+	allowance, err := entitlements.NewService(o.db.GetDB()).Check(dbUser, resource)
+	if err != nil {
+		logger.Error("Failed to check entitlements", err, map[string]interface{}{
+			"user_id":  dbUser.ID,
+			"resource": resource,
+		})
+		c.String(http.StatusInternalServerError, "Failed to check your plan")
+		return false
+	}
+	if !allowance.CanAdd() {
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))(allowance.DeniedMessage())
+		}
+		c.Redirect(http.StatusSeeOther, "/pricing")
+		return false
+	}
+	return true
+}
+
+// prepareOwnerPage copies the CSRF token, auth data, Casbin roles and
+// pending flash messages from the request into the owner data
+func (o *OwnerController) prepareOwnerPage(c *gin.Context, ownerData *data.OwnerData, email string, title string) {
+	// Set authentication data
+	if csrfToken, exists := c.Get("csrf_token"); exists {
+		if token, ok := csrfToken.(string); ok {
+			ownerData.Auth.CSRFToken = token
+		}
+	}
+
+	// Get authData from context to preserve roles
+	if authDataInterface, exists := c.Get("authData"); exists {
+		if authData, ok := authDataInterface.(data.AuthData); ok {
+			// Use the auth data that already has roles, maintaining our title and other changes
+			ownerData.Auth = authData.WithTitle(title).WithError(ownerData.Auth.Error)
+
+			// Re-fetch roles from Casbin to ensure they're up to date
+			if casbinAuth, exists := c.Get("casbinAuth"); exists && casbinAuth != nil {
+				if ca, ok := casbinAuth.(interface{ GetUserRoles(string) []string }); ok {
+					roles := ca.GetUserRoles(email)
+					logger.Info("Casbin roles for user in owner page", map[string]interface{}{
+						"email": email,
+						"page":  title,
+						"roles": roles,
+					})
+					ownerData.Auth = ownerData.Auth.WithRoles(roles)
+				}
+			}
+		}
+	}
+
+	// Check for flash messages from session
+	session := sessions.Default(c)
+	flashes := session.Flashes()
+	if len(flashes) > 0 {
+		session.Save()
+		for _, flash := range flashes {
+			if flashMsg, ok := flash.(string); ok {
+				ownerData.WithSuccess(flashMsg)
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner/imports"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/services/csvimport"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+)
+
+// ImportNew shows the form to upload a CSV file of guns or ammunition
+func (o *OwnerController) ImportNew(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	kind, err := csvimport.ParseKind(c.DefaultQuery("kind", string(csvimport.KindGuns)))
+	if err != nil {
+		kind = csvimport.KindGuns
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle("Import from CSV").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithImport(&csvimport.Preview{Kind: kind})
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Import from CSV")
+
+	imports.New(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// ImportPreview maps an uploaded CSV file's columns and checks every row without saving anything
+func (o *OwnerController) ImportPreview(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	kind, sheet, mapping, err := readImportForm(c)
+	if err != nil {
+		o.renderImportUploadError(c, dbUser, kind, err)
+		return
+	}
+
+	preview, err := csvimport.Build(o.db.GetDB(), kind, dbUser.ID, sheet, mapping, o.importLimit(dbUser, kind))
+	if err != nil {
+		logger.Error("Failed to preview import", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"kind":    kind,
+		})
+		o.renderImportUploadError(c, dbUser, kind, errors.New("we couldn't check the file, please try again"))
+		return
+	}
+
+	o.renderImportPreview(c, dbUser, preview, http.StatusOK)
+}
+
+// ImportCreate imports every row of a previewed CSV file in one transaction
+func (o *OwnerController) ImportCreate(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	kind, sheet, mapping, err := readImportForm(c)
+	if err != nil {
+		o.renderImportUploadError(c, dbUser, kind, err)
+		return
+	}
+
+	// Check the file again, since the owner's data may have changed since the preview
+	db := o.db.GetDB()
+	preview, err := csvimport.Build(db, kind, dbUser.ID, sheet, mapping, o.importLimit(dbUser, kind))
+	if err == nil {
+		var imported int
+		imported, err = preview.Commit(db)
+		if err == nil {
+			session := sessions.Default(c)
+			session.AddFlash(fmt.Sprintf("Imported %d %s", imported, importNoun(kind, imported)))
+			session.Save()
+			c.Redirect(http.StatusSeeOther, importDestination(kind))
+			return
+		}
+	}
+
+	if errors.Is(err, csvimport.ErrNotReady) {
+		o.renderImportPreview(c, dbUser, preview, http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.Error("Failed to import", err, map[string]interface{}{
+		"user_id": dbUser.ID,
+		"kind":    kind,
+	})
+	o.renderImportUploadError(c, dbUser, kind, errors.New("nothing was imported because the import failed, please try again"))
+}
+
+// readImportForm reads the import kind, the CSV file and the column mapping from the request.
+// The first preview reads an uploaded file and guesses the mapping; later steps
+// carry the file in the csv_data field and post the owner's mapping.
+func readImportForm(c *gin.Context) (csvimport.Kind, *csvimport.Sheet, csvimport.Mapping, error) {
+	kind, err := csvimport.ParseKind(c.PostForm("kind"))
+	if err != nil {
+		return csvimport.KindGuns, nil, nil, errors.New("choose whether you're importing guns or ammunition")
+	}
+
+	if file, err := c.FormFile("file"); err == nil {
+		if file.Size > csvimport.MaxFileSize {
+			return kind, nil, nil, csvimport.ErrFileTooLarge
+		}
+		f, err := file.Open()
+		if err != nil {
+			return kind, nil, nil, err
+		}
+		defer f.Close()
+
+		sheet, err := csvimport.ReadCSV(f)
+		if err != nil {
+			return kind, nil, nil, err
+		}
+		return kind, sheet, csvimport.GuessMapping(kind, sheet.Header), nil
+	}
+
+	encoded := c.PostForm("csv_data")
+	if encoded == "" {
+		return kind, nil, nil, errors.New("choose a CSV file to import")
+	}
+	sheet, err := csvimport.DecodeSheet(encoded)
+	if err != nil {
+		return kind, nil, nil, err
+	}
+
+	mapping := make(csvimport.Mapping, len(sheet.Header))
+	for i := range mapping {
+		mapping[i] = c.PostForm("map_" + strconv.Itoa(i))
+	}
+	return kind, sheet, mapping, nil
+}
+
+// importLimit returns how many more guns or ammunition lots the owner may add
+func (o *OwnerController) importLimit(dbUser *database.User, kind csvimport.Kind) int {
+	allowance, err := entitlements.NewService(o.db.GetDB()).Check(dbUser, importResource(kind))
+	if err != nil {
+		logger.Error("Failed to check entitlements for an import", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"kind":    kind,
+		})
+		return 0
+	}
+	if allowance.Unlimited() {
+		return csvimport.NoLimit
+	}
+	return allowance.Remaining()
+}
+
+// importResource is the resource an import of this kind creates
+func importResource(kind csvimport.Kind) entitlements.Resource {
+	if kind == csvimport.KindAmmo {
+		return entitlements.Ammo
+	}
+	return entitlements.Guns
+}
+
+// renderImportPreview renders the column mapping and row checks, explaining a plan limit
+func (o *OwnerController) renderImportPreview(c *gin.Context, dbUser *database.User, preview *csvimport.Preview, status int) {
+	ownerData := data.NewOwnerData().
+		WithTitle("Preview Import").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithImport(preview)
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Preview Import")
+
+	if preview.OverLimit() {
+		ents := entitlements.For(dbUser)
+		limit := ents.Limit(importResource(preview.Kind))
+		ownerData.WithError(fmt.Sprintf("%s tier only allows %d %s, so you can import %d more. Subscribe to import the whole file.", ents.Plan.Name, limit, importNoun(preview.Kind, limit), preview.Limit))
+	}
+
+	c.Status(status)
+	imports.Preview(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// renderImportUploadError shows the upload form again with what was wrong with the file
+func (o *OwnerController) renderImportUploadError(c *gin.Context, dbUser *database.User, kind csvimport.Kind, err error) {
+	ownerData := data.NewOwnerData().
+		WithTitle("Import from CSV").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithImport(&csvimport.Preview{Kind: kind})
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Import from CSV")
+
+	ownerData.WithError("Couldn't import the file: " + err.Error())
+
+	c.Status(http.StatusUnprocessableEntity)
+	imports.New(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// importNoun names what an import of this kind creates
+func importNoun(kind csvimport.Kind, count int) string {
+	switch {
+	case kind == csvimport.KindAmmo && count == 1:
+		return "ammunition lot"
+	case kind == csvimport.KindAmmo:
+		return "ammunition lots"
+	case count == 1:
+		return "gun"
+	}
+	return "guns"
+}
+
+// importDestination is where the owner sees what they imported
+func importDestination(kind csvimport.Kind) string {
+	if kind == csvimport.KindAmmo {
+		return "/owner/munitions"
+	}
+	return "/owner/guns/arsenal"
+}
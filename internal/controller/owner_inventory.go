@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/pdf"
+)
+
+// Layout of the inventory report, in points
+const (
+	inventoryMargin    = 50.0
+	inventoryRowHeight = 16.0
+	inventoryTableEnd  = 700.0 // Rows stop here to leave room for the footer
+)
+
+// inventoryColumn is a column of the inventory report's gun table
+type inventoryColumn struct {
+	title string
+	x     float64
+	width float64
+}
+
+// inventoryColumns are the columns of the gun table. Paid is right aligned to the page margin.
+var inventoryColumns = []inventoryColumn{
+	{"Make", 50, 100},
+	{"Model", 155, 115},
+	{"Caliber", 275, 80},
+	{"Serial Number", 360, 110},
+	{"Acquired", 475, 60},
+	{"Paid", 562, 0},
+}
+
+// GunInventoryPDF generates a printable insurance inventory of the owner's guns.
+// Each report is recorded by its content hash so a copy can be verified later.
+func (o *OwnerController) GunInventoryPDF(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	db := o.db.GetDB()
+	guns, err := models.FindGunsByOwner(db, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load guns for inventory report", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to build the inventory report")
+		return
+	}
+
+	report := models.NewInventoryReport(dbUser.ID, dbUser.Email, guns, time.Now())
+	if err := models.CreateInventoryReport(db, report); err != nil {
+		logger.Error("Failed to record inventory report", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to build the inventory report")
+		return
+	}
+
+	doc := renderInventoryPDF(report, dbUser, requestBaseURL(c))
+
+	filename := fmt.Sprintf("armory-inventory-%s.pdf", report.GeneratedAt.Format("2006-01-02"))
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/pdf", doc.Bytes())
+}
+
+// renderInventoryPDF lays out an inventory report, repeating the table header
+// on each page and putting the content hash in every page's footer
+func renderInventoryPDF(report *models.InventoryReport, dbUser *database.User, baseURL string) *pdf.Document {
+	doc := pdf.New("Firearms Insurance Inventory")
+	doc.Author = dbUser.Email
+	doc.Created = report.GeneratedAt
+
+	doc.AddPage()
+	doc.Text(inventoryMargin, 70, pdf.HelveticaBold, 18, "Firearms Insurance Inventory")
+	doc.Text(inventoryMargin, 86, pdf.Helvetica, 10, "The Virtual Armory")
+
+	details := [][2]string{
+		{"Owner", dbUser.Email},
+		{"Member since", dbUser.CreatedAt.Format("January 2, 2006")},
+		{"Generated", report.GeneratedAt.Format("January 2, 2006 15:04 MST")},
+	}
+	y := 116.0
+	for _, detail := range details {
+		doc.Text(inventoryMargin, y, pdf.HelveticaBold, 10, detail[0]+":")
+		doc.Text(inventoryMargin+80, y, pdf.Helvetica, 10, detail[1])
+		y += 14
+	}
+
+	y = inventoryTableHeader(doc, y+16)
+	if len(report.Items) == 0 {
+		doc.Text(inventoryMargin, y, pdf.Helvetica, 9, "No guns are recorded in this collection.")
+		y += inventoryRowHeight
+	}
+	for _, item := range report.Items {
+		if y > inventoryTableEnd {
+			doc.AddPage()
+			y = inventoryTableHeader(doc, 60)
+		}
+		acquired := ""
+		if item.Acquired != nil {
+			acquired = item.Acquired.Format("01/02/2006")
+		}
+		paid := "Not recorded"
+		if item.Paid != nil {
+			paid = models.FormatDollars(*item.Paid)
+		}
+		cells := []string{item.Make, item.Model, item.Caliber, item.SerialNumber, acquired}
+		for i, cell := range cells {
+			column := inventoryColumns[i]
+			doc.Text(column.x, y, pdf.Helvetica, 9, pdf.Truncate(pdf.Helvetica, 9, cell, column.width))
+		}
+		doc.TextRight(inventoryColumns[5].x, y, pdf.Helvetica, 9, paid)
+		doc.Line(inventoryMargin, y+5, pdf.PageWidth-inventoryMargin, y+5, 0.25)
+		y += inventoryRowHeight
+	}
+
+	if y+40 > inventoryTableEnd {
+		doc.AddPage()
+		y = 60
+	}
+	y += 10
+	doc.Text(inventoryMargin, y, pdf.HelveticaBold, 11, "Total declared value")
+	doc.TextRight(pdf.PageWidth-inventoryMargin, y, pdf.HelveticaBold, 11, models.FormatDollars(report.TotalValue))
+	summary := fmt.Sprintf("%d %s listed", report.GunCount, inventoryPlural(report.GunCount, "gun", "guns"))
+	if unpriced := report.UnpricedCount(); unpriced > 0 {
+		summary += fmt.Sprintf(", %d without a purchase price", unpriced)
+	}
+	doc.Text(inventoryMargin, y+14, pdf.Helvetica, 9, summary)
+
+	verifyURL := baseURL + "/inventory/verify?hash=" + url.QueryEscape(report.Hash)
+	for page := 1; page <= doc.PageCount(); page++ {
+		doc.SetPage(page)
+		doc.Line(inventoryMargin, 730, pdf.PageWidth-inventoryMargin, 730, 0.5)
+		doc.Text(inventoryMargin, 744, pdf.Helvetica, 7, "Content hash (SHA-256): "+report.Hash)
+		doc.Text(inventoryMargin, 754, pdf.Helvetica, 7, "Verify this report at "+verifyURL)
+		doc.TextRight(pdf.PageWidth-inventoryMargin, 744, pdf.Helvetica, 7, fmt.Sprintf("Page %d of %d", page, doc.PageCount()))
+	}
+
+	return doc
+}
+
+// inventoryTableHeader draws the gun table's shaded header row and returns where the first row goes
+func inventoryTableHeader(doc *pdf.Document, y float64) float64 {
+	doc.FillRect(inventoryMargin, y-12, pdf.PageWidth-2*inventoryMargin, 17, 0.9)
+	for _, column := range inventoryColumns {
+		if column.width == 0 {
+			doc.TextRight(column.x, y, pdf.HelveticaBold, 9, column.title)
+			continue
+		}
+		doc.Text(column.x, y, pdf.HelveticaBold, 9, column.title)
+	}
+	return y + inventoryRowHeight + 2
+}
+
+// inventoryPlural picks the singular or plural form of a word for a count
+func inventoryPlural(count int, singular, plural string) string {
+	if count == 1 {
+		return singular
+	}
+	return plural
+}
+
+// requestBaseURL returns the scheme and host the request was made to
+func requestBaseURL(c *gin.Context) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
@@ -0,0 +1,473 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner/maintenance"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+)
+
+// MaintenanceOverview displays round counts and due service for all of the owner's guns
+func (o *OwnerController) MaintenanceOverview(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user's guns
+	db := o.db.GetDB()
+	var guns []models.Gun
+	if err := db.Preload("Manufacturer").Preload("Caliber").Where("owner_id = ?", dbUser.ID).Order("name ASC").Find(&guns).Error; err != nil {
+		logger.Error("Failed to fetch guns for maintenance", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		guns = []models.Gun{}
+	}
+
+	// Get the lifetime round count of each gun
+	roundCounts := make(map[uint]int, len(guns))
+	for _, gun := range guns {
+		roundCount, err := models.GunRoundCount(db, gun.ID)
+		if err != nil {
+			logger.Error("Failed to count gun rounds", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+				"gun_id":  gun.ID,
+			})
+		}
+		roundCounts[gun.ID] = roundCount
+	}
+
+	// Get the service that is due across all guns
+	dueServices, err := models.FindDueServices(db, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to fetch due services", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		dueServices = []models.ServiceStatus{}
+	}
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle("Maintenance").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithGuns(guns).
+		WithGunRoundCounts(roundCounts).
+		WithDueServices(dueServices)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Maintenance")
+
+	// Render the maintenance overview
+	maintenance.Overview(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// MaintenanceIndex displays a gun's round count, service intervals and maintenance log
+func (o *OwnerController) MaintenanceIndex(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the gun, ensuring it belongs to the user
+	gun, ok := o.findOwnedGun(c, dbUser)
+	if !ok {
+		return
+	}
+
+	// Get the maintenance log
+	db := o.db.GetDB()
+	records, err := models.FindMaintenanceRecordsByGun(db, gun.ID, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to fetch maintenance records", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"gun_id":  gun.ID,
+		})
+		records = []models.MaintenanceRecord{}
+	}
+
+	// Create owner data for the view
+	title := fmt.Sprintf("Maintenance: %s", gun.Name)
+	ownerData := data.NewOwnerData().
+		WithTitle(title).
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithGun(gun).
+		WithMaintenanceRecords(records)
+
+	// Add the round count and service interval status
+	o.addGunMaintenance(ownerData, gun)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), title)
+
+	// Render the gun maintenance view
+	maintenance.Show(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// MaintenanceCreate logs a cleaning or part replacement for a gun
+func (o *OwnerController) MaintenanceCreate(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the gun, ensuring it belongs to the user
+	gun, ok := o.findOwnedGun(c, dbUser)
+	if !ok {
+		return
+	}
+	maintenancePath := fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID)
+	session := sessions.Default(c)
+
+	// Build the maintenance record from the form
+	record := &models.MaintenanceRecord{
+		GunID:    gun.ID,
+		OwnerID:  dbUser.ID,
+		Type:     c.PostForm("type"),
+		PartName: strings.TrimSpace(c.PostForm("part_name")),
+		Notes:    c.PostForm("notes"),
+	}
+
+	// Parse the date the work was performed (required)
+	if performedAtStr := c.PostForm("performed_at"); performedAtStr != "" {
+		performedAt, err := time.Parse("2006-01-02", performedAtStr)
+		if err != nil {
+			session.AddFlash("Invalid maintenance date")
+			session.Save()
+			c.Redirect(http.StatusSeeOther, maintenancePath)
+			return
+		}
+		record.PerformedAt = performedAt
+	}
+
+	// Validate and create the record
+	if err := models.CreateMaintenanceRecordWithValidation(o.db.GetDB(), record); err != nil {
+		session.AddFlash("Failed to log maintenance: " + err.Error())
+		session.Save()
+		c.Redirect(http.StatusSeeOther, maintenancePath)
+		return
+	}
+
+	// Set success message
+	session.AddFlash(models.MaintenanceTypeLabel(record.Type) + " logged successfully")
+	session.Save()
+
+	// Redirect to the gun maintenance page
+	c.Redirect(http.StatusSeeOther, maintenancePath)
+}
+
+// MaintenanceDelete deletes a maintenance record
+func (o *OwnerController) MaintenanceDelete(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the gun, ensuring it belongs to the user
+	gun, ok := o.findOwnedGun(c, dbUser)
+	if !ok {
+		return
+	}
+	maintenancePath := fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID)
+	session := sessions.Default(c)
+
+	// Parse the record ID and delete it; the model checks ownership
+	recordID, err := strconv.ParseUint(c.Param("record_id"), 10, 64)
+	if err != nil || models.DeleteMaintenanceRecord(o.db.GetDB(), uint(recordID), dbUser.ID) != nil {
+		session.AddFlash("Maintenance record not found or you don't have permission to delete it")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, maintenancePath)
+		return
+	}
+
+	// Set success message
+	session.AddFlash("Maintenance record deleted successfully")
+	session.Save()
+
+	// Redirect to the gun maintenance page
+	c.Redirect(http.StatusSeeOther, maintenancePath)
+}
+
+// ServiceIntervalCreate adds a service interval to a gun
+func (o *OwnerController) ServiceIntervalCreate(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the gun, ensuring it belongs to the user
+	gun, ok := o.findOwnedGun(c, dbUser)
+	if !ok {
+		return
+	}
+	maintenancePath := fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID)
+	session := sessions.Default(c)
+
+	// Build the service interval from the form
+	interval := &models.ServiceInterval{
+		GunID:           gun.ID,
+		OwnerID:         dbUser.ID,
+		Description:     strings.TrimSpace(c.PostForm("description")),
+		MaintenanceType: c.PostForm("maintenance_type"),
+		PartName:        strings.TrimSpace(c.PostForm("part_name")),
+	}
+
+	// Parse rounds and days (optional, but at least one is required by validation)
+	var parseErr bool
+	if everyRounds := c.PostForm("every_rounds"); everyRounds != "" {
+		interval.EveryRounds, err = strconv.Atoi(everyRounds)
+		parseErr = parseErr || err != nil
+	}
+	if everyDays := c.PostForm("every_days"); everyDays != "" {
+		interval.EveryDays, err = strconv.Atoi(everyDays)
+		parseErr = parseErr || err != nil
+	}
+	if parseErr {
+		session.AddFlash("Rounds and days must be whole numbers")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, maintenancePath)
+		return
+	}
+
+	// Validate and create the interval
+	if err := models.CreateServiceIntervalWithValidation(o.db.GetDB(), interval); err != nil {
+		session.AddFlash("Failed to add service interval: " + err.Error())
+		session.Save()
+		c.Redirect(http.StatusSeeOther, maintenancePath)
+		return
+	}
+
+	// Set success message
+	session.AddFlash("Service interval added successfully")
+	session.Save()
+
+	// Redirect to the gun maintenance page
+	c.Redirect(http.StatusSeeOther, maintenancePath)
+}
+
+// ServiceIntervalDelete removes a service interval from a gun
+func (o *OwnerController) ServiceIntervalDelete(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the gun, ensuring it belongs to the user
+	gun, ok := o.findOwnedGun(c, dbUser)
+	if !ok {
+		return
+	}
+	maintenancePath := fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID)
+	session := sessions.Default(c)
+
+	// Parse the interval ID and delete it; the model checks ownership
+	intervalID, err := strconv.ParseUint(c.Param("interval_id"), 10, 64)
+	if err != nil || models.DeleteServiceInterval(o.db.GetDB(), uint(intervalID), dbUser.ID) != nil {
+		session.AddFlash("Service interval not found or you don't have permission to delete it")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, maintenancePath)
+		return
+	}
+
+	// Set success message
+	session.AddFlash("Service interval removed successfully")
+	session.Save()
+
+	// Redirect to the gun maintenance page
+	c.Redirect(http.StatusSeeOther, maintenancePath)
+}
+
+// findOwnedGun loads the gun named by the :id param for the user,
+// redirecting to the owner page with a flash message when it can't be found
+func (o *OwnerController) findOwnedGun(c *gin.Context, dbUser *database.User) (*models.Gun, bool) {
+	var gun models.Gun
+	if err := o.db.GetDB().Preload("WeaponType").Preload("Caliber").Preload("Manufacturer").
+		Where("id = ? AND owner_id = ?", c.Param("id"), dbUser.ID).First(&gun).Error; err != nil {
+		session := sessions.Default(c)
+		session.AddFlash("That's not your gun!")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner")
+		return nil, false
+	}
+	return &gun, true
+}
+
+// addGunMaintenance adds a gun's lifetime round count, service intervals and their status to the owner data
+func (o *OwnerController) addGunMaintenance(ownerData *data.OwnerData, gun *models.Gun) {
+	db := o.db.GetDB()
+
+	roundCount, err := models.GunRoundCount(db, gun.ID)
+	if err != nil {
+		logger.Error("Failed to count gun rounds", err, map[string]interface{}{
+			"gun_id": gun.ID,
+		})
+	}
+
+	statuses, err := models.ServiceStatusesForGun(db, gun)
+	if err != nil {
+		logger.Error("Failed to fetch service intervals", err, map[string]interface{}{
+			"gun_id": gun.ID,
+		})
+		statuses = []models.ServiceStatus{}
+	}
+
+	// Pull out the intervals that are due
+	due := []models.ServiceStatus{}
+	intervals := make([]models.ServiceInterval, 0, len(statuses))
+	for _, status := range statuses {
+		intervals = append(intervals, status.Interval)
+		if status.Due {
+			due = append(due, status)
+		}
+	}
+
+	ownerData.WithRoundCount(roundCount).
+		WithServiceIntervals(intervals).
+		WithServiceStatuses(statuses).
+		WithDueServices(due)
+}
@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// withLinkedAccounts adds the owner's linked provider accounts to the profile
+// page, with the CSRF token its link and unlink forms need
+func (o *OwnerController) withLinkedAccounts(c *gin.Context, ownerData *data.OwnerData, dbUser *database.User) {
+	identities, err := models.FindOIDCIdentitiesByUser(o.db.GetDB(), dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load linked accounts", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+	}
+	ownerData.WithLinkedAccounts(oidcProviderName(), identities)
+
+	if ownerData.Auth.CSRFToken == "" {
+		if token, ok := c.Get("csrf_token"); ok {
+			if csrfToken, ok := token.(string); ok {
+				ownerData.Auth = ownerData.Auth.WithCSRFToken(csrfToken)
+			}
+		}
+	}
+}
+
+// OIDCUnlink unlinks one of the owner's provider accounts, so it can no longer sign them in
+func (o *OwnerController) OIDCUnlink(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	identityID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	var deleted *models.OIDCIdentity
+	err := o.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if deleted, err = models.DeleteOIDCIdentity(tx, dbUser.ID, uint(identityID)); err != nil || deleted == nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, dbUser.ID, models.SecurityEventOIDCUnlinked, "Unlinked account "+deleted.Email, 0)
+	})
+
+	session := sessions.Default(c)
+	switch {
+	case err != nil:
+		logger.Error("Failed to unlink account", err, map[string]interface{}{
+			"user_id":     dbUser.ID,
+			"identity_id": identityID,
+		})
+		session.AddFlash("Failed to unlink the account, please try again")
+	case deleted == nil:
+		session.AddFlash("Linked account not found")
+	default:
+		logger.Info("OIDC account unlinked", map[string]interface{}{
+			"user_id":     dbUser.ID,
+			"identity_id": deleted.ID,
+		})
+		session.AddFlash("Account unlinked")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile")
+}
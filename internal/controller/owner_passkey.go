@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// Passkeys lists the owner's passkeys and lets them add another. Adding one
+// happens in the browser, against the AuthController's registration handlers.
+func (o *OwnerController) Passkeys(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	passkeys, err := models.FindPasskeysByUser(o.db.GetDB(), dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load passkeys", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load passkeys")
+		return
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle("Passkeys").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithPasskeys(passkeys)
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Passkeys")
+
+	owner.Passkeys(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// PasskeyDelete removes one of the owner's passkeys, so it can no longer sign them in
+func (o *OwnerController) PasskeyDelete(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	passkeyID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	var deleted *models.Passkey
+	err := o.db.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		if deleted, err = models.DeletePasskey(tx, dbUser.ID, uint(passkeyID)); err != nil || deleted == nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, dbUser.ID, models.SecurityEventPasskeyRemoved, "Removed passkey "+deleted.Name, 0)
+	})
+
+	session := sessions.Default(c)
+	switch {
+	case err != nil:
+		logger.Error("Failed to delete passkey", err, map[string]interface{}{
+			"user_id":    dbUser.ID,
+			"passkey_id": passkeyID,
+		})
+		session.AddFlash("Failed to remove the passkey, please try again")
+	case deleted == nil:
+		session.AddFlash("Passkey not found")
+	default:
+		logger.Info("Passkey removed", map[string]interface{}{
+			"user_id":    dbUser.ID,
+			"passkey_id": deleted.ID,
+		})
+		session.AddFlash("Passkey removed")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile/passkeys")
+}
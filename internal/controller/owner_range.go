@@ -0,0 +1,602 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner/ranges"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/geocode"
+)
+
+// RangeIndex displays the owner's range history by location, their private ranges and the public ranges
+func (o *OwnerController) RangeIndex(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the public ranges and the user's private ranges
+	db := o.db.GetDB()
+	rangeList, err := models.FindRangesForUser(db, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to fetch ranges", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		rangeList = []models.Range{}
+	}
+
+	// Get the user's range history grouped by range
+	history, err := models.FindRangeHistoryByUser(db, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to fetch range history", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		history = []models.RangeHistory{}
+	}
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle("Ranges").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRanges(rangeList).
+		WithRangeHistory(history)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Ranges")
+
+	// Render the range index view
+	ranges.Index(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeNew displays the form to add a private range
+func (o *OwnerController) RangeNew(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle("Add Range").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRange(&models.Range{}).
+		WithFormErrors(map[string]string{})
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Add Range")
+
+	// Render the range form
+	ranges.Form(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeCreate adds a private range for the owner
+func (o *OwnerController) RangeCreate(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Build the private range from the form
+	r := &models.Range{OwnerID: dbUser.ID}
+	formErrors := parseRangeForm(c, r)
+	if len(formErrors) > 0 {
+		o.renderRangeFormError(c, dbUser, userInfo.GetUserName(), r, formErrors)
+		return
+	}
+
+	// Place the range on the map unless the owner entered coordinates, then validate and save it
+	geocodeRange(c.Request.Context(), o.geocoder, r)
+	if err := models.CreateRangeWithValidation(o.db.GetDB(), r); err != nil {
+		if field, ok := rangeErrorField(err); ok {
+			o.renderRangeFormError(c, dbUser, userInfo.GetUserName(), r, map[string]string{field: rangeErrorMessage(err)})
+			return
+		}
+		logger.Error("Failed to create range", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		o.renderRangeFormError(c, dbUser, userInfo.GetUserName(), r, map[string]string{"range_name": "Failed to save range"})
+		return
+	}
+
+	// Set success message
+	session := sessions.Default(c)
+	session.AddFlash("Range added successfully")
+	session.Save()
+
+	// Redirect to the range page
+	c.Redirect(http.StatusSeeOther, "/owner/ranges/"+strconv.FormatUint(uint64(r.ID), 10))
+}
+
+// RangeShow displays a range and the owner's range days there
+func (o *OwnerController) RangeShow(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the range, ensuring it's public or the user's own
+	r, ok := o.findVisibleRange(c, dbUser)
+	if !ok {
+		return
+	}
+
+	// Get the user's range days at this range
+	rangeDays, err := models.FindRangeDaysByUserAtRange(o.db.GetDB(), dbUser.ID, r.ID)
+	if err != nil {
+		logger.Error("Failed to fetch range days for range", err, map[string]interface{}{
+			"user_id":  dbUser.ID,
+			"range_id": r.ID,
+		})
+		rangeDays = []models.RangeDay{}
+	}
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle(r.RangeName).
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRange(r).
+		WithRangeDays(rangeDays)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), r.RangeName)
+
+	// Render the range view
+	ranges.Show(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeEdit displays the form to edit one of the owner's private ranges
+func (o *OwnerController) RangeEdit(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the range, ensuring it's one of the user's private ranges
+	r, ok := o.findPrivateRange(c, dbUser)
+	if !ok {
+		return
+	}
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle("Edit Range").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRange(r).
+		WithFormErrors(map[string]string{})
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Edit Range")
+
+	// Render the range form
+	ranges.Form(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeUpdate updates one of the owner's private ranges
+func (o *OwnerController) RangeUpdate(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the range, ensuring it's one of the user's private ranges
+	r, ok := o.findPrivateRange(c, dbUser)
+	if !ok {
+		return
+	}
+	previous := *r
+
+	// Apply the form to the range
+	formErrors := parseRangeForm(c, r)
+	if len(formErrors) > 0 {
+		o.renderRangeFormError(c, dbUser, userInfo.GetUserName(), r, formErrors)
+		return
+	}
+
+	// A moved range whose coordinates weren't touched is placed on the map again
+	if rangeAddressChanged(&previous, r) && sameCoordinates(&previous, r) {
+		r.Latitude, r.Longitude = nil, nil
+	}
+	geocodeRange(c.Request.Context(), o.geocoder, r)
+
+	// Validate and save the range
+	if err := models.UpdateRangeWithValidation(o.db.GetDB(), r); err != nil {
+		if field, ok := rangeErrorField(err); ok {
+			o.renderRangeFormError(c, dbUser, userInfo.GetUserName(), r, map[string]string{field: rangeErrorMessage(err)})
+			return
+		}
+		logger.Error("Failed to update range", err, map[string]interface{}{
+			"user_id":  dbUser.ID,
+			"range_id": r.ID,
+		})
+		o.renderRangeFormError(c, dbUser, userInfo.GetUserName(), r, map[string]string{"range_name": "Failed to save range"})
+		return
+	}
+
+	// Set success message
+	session := sessions.Default(c)
+	session.AddFlash("Range updated successfully")
+	session.Save()
+
+	// Redirect to the range page
+	c.Redirect(http.StatusSeeOther, "/owner/ranges/"+strconv.FormatUint(uint64(r.ID), 10))
+}
+
+// RangeDelete deletes one of the owner's private ranges that has no range days logged at it
+func (o *OwnerController) RangeDelete(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	session := sessions.Default(c)
+
+	// Parse the range ID and delete it; the model checks ownership and use
+	rangeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		session.AddFlash("Range not found")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/ranges")
+		return
+	}
+	if err := models.DeletePrivateRange(o.db.GetDB(), uint(rangeID), dbUser.ID); err != nil {
+		if errors.Is(err, models.ErrRangeInUse) {
+			session.AddFlash("This range has range days logged at it and can't be deleted")
+			session.Save()
+			c.Redirect(http.StatusSeeOther, "/owner/ranges/"+strconv.FormatUint(rangeID, 10))
+			return
+		}
+		session.AddFlash("Range not found or you don't have permission to delete it")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/ranges")
+		return
+	}
+
+	// Set success message
+	session.AddFlash("Range deleted successfully")
+	session.Save()
+
+	// Redirect to the range index
+	c.Redirect(http.StatusSeeOther, "/owner/ranges")
+}
+
+// findVisibleRange loads the public or private range named by the :id param for the user,
+// redirecting to the range index with a flash message when it can't be found
+func (o *OwnerController) findVisibleRange(c *gin.Context, dbUser *database.User) (*models.Range, bool) {
+	rangeID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err == nil {
+		if r, err := models.FindRangeForUser(o.db.GetDB(), uint(rangeID), dbUser.ID); err == nil {
+			return r, true
+		}
+	}
+
+	session := sessions.Default(c)
+	session.AddFlash("Range not found")
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/ranges")
+	return nil, false
+}
+
+// findPrivateRange loads the range named by the :id param if it's one of the user's
+// private ranges. Public ranges are curated by admins and can't be changed here.
+func (o *OwnerController) findPrivateRange(c *gin.Context, dbUser *database.User) (*models.Range, bool) {
+	r, ok := o.findVisibleRange(c, dbUser)
+	if !ok {
+		return nil, false
+	}
+
+	if r.OwnerID != dbUser.ID {
+		session := sessions.Default(c)
+		session.AddFlash("Public ranges can only be changed by an administrator")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/ranges/"+strconv.FormatUint(uint64(r.ID), 10))
+		return nil, false
+	}
+
+	return r, true
+}
+
+// renderRangeFormError re-renders the range form with the owner's input and field errors
+func (o *OwnerController) renderRangeFormError(c *gin.Context, dbUser *database.User, email string, r *models.Range, formErrors map[string]string) {
+	title := "Add Range"
+	if r.ID != 0 {
+		title = "Edit Range"
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle(title).
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRange(r).
+		WithFormErrors(formErrors)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, email, title)
+
+	c.Status(http.StatusUnprocessableEntity)
+	ranges.Form(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// newGeocoder builds the geocoder configured by the environment, leaving
+// geocoding off when the configuration can't be used
+func newGeocoder() geocode.Geocoder {
+	geocoder, err := geocode.NewGeocoder()
+	if err != nil {
+		logger.Error("Failed to set up geocoder, ranges won't be placed on the map", err, nil)
+		return nil
+	}
+	if geocoder == nil {
+		logger.Info("No geocoder configured, ranges won't be placed on the map", nil)
+	}
+	return geocoder
+}
+
+// geocodeRange fills in a range's coordinates from its address when it has none.
+// Geocoding is optional, so a missing geocoder or failed lookup leaves them empty.
+func geocodeRange(ctx context.Context, geocoder geocode.Geocoder, r *models.Range) {
+	if geocoder == nil || r.Latitude != nil || r.Longitude != nil {
+		return
+	}
+
+	location, err := geocoder.Geocode(ctx, geocode.Address{
+		Street: r.Street(),
+		City:   r.City,
+		State:  r.State,
+		Zip:    r.Zip,
+	})
+	if err != nil {
+		if !errors.Is(err, geocode.ErrNotFound) {
+			logger.Error("Failed to geocode range", err, map[string]interface{}{
+				"range_name": r.RangeName,
+			})
+		}
+		return
+	}
+
+	r.Latitude = &location.Latitude
+	r.Longitude = &location.Longitude
+}
+
+// rangeAddressChanged reports whether any part of the range's address differs
+func rangeAddressChanged(previous, current *models.Range) bool {
+	return previous.StreetNumber != current.StreetNumber ||
+		previous.StreetName != current.StreetName ||
+		previous.City != current.City ||
+		previous.State != current.State ||
+		previous.Zip != current.Zip
+}
+
+// sameCoordinates reports whether both ranges have the same coordinates, or both have none
+func sameCoordinates(previous, current *models.Range) bool {
+	sameValue := func(a, b *float64) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return *a == *b
+	}
+	return sameValue(previous.Latitude, current.Latitude) && sameValue(previous.Longitude, current.Longitude)
+}
+
+// parseRangeForm reads the posted range form into r and returns any field errors
+func parseRangeForm(c *gin.Context, r *models.Range) map[string]string {
+	formErrors := make(map[string]string)
+
+	r.RangeName = strings.TrimSpace(c.PostForm("range_name"))
+	r.StreetNumber = strings.TrimSpace(c.PostForm("street_number"))
+	r.StreetName = strings.TrimSpace(c.PostForm("street_name"))
+	r.AddressLine2 = strings.TrimSpace(c.PostForm("address_line2"))
+	r.City = strings.TrimSpace(c.PostForm("city"))
+	r.State = strings.ToUpper(strings.TrimSpace(c.PostForm("state")))
+	r.Zip = strings.TrimSpace(c.PostForm("zip"))
+
+	// Coordinates are optional; blank ones are filled in by the geocoder
+	r.Latitude, r.Longitude = nil, nil
+	if latitudeStr := strings.TrimSpace(c.PostForm("latitude")); latitudeStr != "" {
+		if latitude, err := strconv.ParseFloat(latitudeStr, 64); err != nil {
+			formErrors["latitude"] = "Latitude must be a number"
+		} else {
+			r.Latitude = &latitude
+		}
+	}
+	if longitudeStr := strings.TrimSpace(c.PostForm("longitude")); longitudeStr != "" {
+		if longitude, err := strconv.ParseFloat(longitudeStr, 64); err != nil {
+			formErrors["longitude"] = "Longitude must be a number"
+		} else {
+			r.Longitude = &longitude
+		}
+	}
+
+	return formErrors
+}
+
+// rangeErrorField returns the form field a range validation error belongs to
+func rangeErrorField(err error) (string, bool) {
+	switch {
+	case errors.Is(err, models.ErrRangeNameRequired),
+		errors.Is(err, models.ErrRangeNameTooLong),
+		errors.Is(err, models.ErrDuplicateRange):
+		return "range_name", true
+	case errors.Is(err, models.ErrInvalidRangeState):
+		return "state", true
+	case errors.Is(err, models.ErrInvalidRangeZip):
+		return "zip", true
+	case errors.Is(err, models.ErrInvalidRangeCoordinates):
+		return "latitude", true
+	}
+	return "", false
+}
+
+// rangeErrorMessage returns a range validation error as a message for the form
+func rangeErrorMessage(err error) string {
+	message := err.Error()
+	return strings.ToUpper(message[:1]) + message[1:]
+}
@@ -0,0 +1,725 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner/rangeday"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// rangeDayDateLayout is the layout used by the range day date inputs and filter
+const rangeDayDateLayout = "2006-01-02"
+
+// RangeDayIndex displays the owner's range days, most recent first, optionally filtered by date
+func (o *OwnerController) RangeDayIndex(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Parse pagination parameters
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "10"))
+	if perPage < 1 {
+		perPage = 10
+	}
+
+	// Parse the optional date filter; an invalid date is ignored
+	filterDate := c.Query("date")
+	var filterDay time.Time
+	if filterDate != "" {
+		filterDay, err = time.Parse(rangeDayDateLayout, filterDate)
+		if err != nil {
+			filterDate = ""
+		}
+	}
+
+	// Base query for the user's range days
+	db := o.db.GetDB()
+	query := db.Model(&models.RangeDay{}).Where("user_id = ?", dbUser.ID)
+	if filterDate != "" {
+		query = query.Where("date >= ? AND date < ?", filterDay, filterDay.AddDate(0, 0, 1))
+	}
+
+	// Count total matching entries for pagination
+	var totalItems int64
+	if err := query.Count(&totalItems).Error; err != nil {
+		logger.Error("Failed to count range days", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		totalItems = 0
+	}
+
+	// Fetch the page of range days with their relationships
+	var rangeDays []models.RangeDay
+	offset := (page - 1) * perPage
+	if err := query.Preload("Range").Preload("Items.Gun").Preload("Items.Ammo").
+		Order("date DESC, id DESC").
+		Offset(offset).Limit(perPage).
+		Find(&rangeDays).Error; err != nil {
+		logger.Error("Failed to fetch range days", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"email":   dbUser.Email,
+		})
+		rangeDays = []models.RangeDay{}
+	}
+
+	// Calculate total pages
+	totalPages := int((totalItems + int64(perPage) - 1) / int64(perPage))
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle("Range Days").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRangeDays(rangeDays).
+		WithFilterDate(filterDate).
+		WithPagination(page, totalPages, perPage, int(totalItems))
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Range Days")
+
+	// Render the range day index view
+	rangeday.Index(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeDayNew displays the form to log a new range day
+func (o *OwnerController) RangeDayNew(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Create owner data for the view with the picker options
+	ownerData := data.NewOwnerData().
+		WithTitle("Log Range Day").
+		WithAuthenticated(true).
+		WithUser(dbUser)
+	loadRangeDayFormOptions(o.db.GetDB(), ownerData, dbUser.ID)
+
+	// Default the date to today
+	ownerData.FormErrors["value_date"] = time.Now().Format(rangeDayDateLayout)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Log Range Day")
+
+	// Render the range day new view
+	rangeday.New(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeDayCreate handles logging a new range day
+func (o *OwnerController) RangeDayCreate(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Parse form values
+	db := o.db.GetDB()
+	if err := c.Request.ParseForm(); err != nil {
+		handleRangeDayFormError(c, dbUser, "Failed to parse form", nil, http.StatusUnprocessableEntity, db, nil)
+		return
+	}
+
+	// Build the range day from the form
+	rangeDay := &models.RangeDay{UserID: dbUser.ID}
+	formErrors := parseRangeDayForm(c, rangeDay)
+	if len(formErrors) > 0 {
+		handleRangeDayFormError(c, dbUser, "Please fix the errors below", formErrors, http.StatusUnprocessableEntity, db, nil)
+		return
+	}
+
+	// Create a new range inline if the owner named one instead of picking from the list
+	if err := o.resolveRangeDayRange(c, db, rangeDay); err != nil {
+		handleRangeDayFormError(c, dbUser, "Failed to create range: "+err.Error(), nil, http.StatusUnprocessableEntity, db, nil)
+		return
+	}
+
+	// Validate and create the range day
+	if err := models.CreateRangeDayWithValidation(db, rangeDay); err != nil {
+		handleRangeDayFormError(c, dbUser, "Failed to log range day: "+err.Error(), nil, http.StatusUnprocessableEntity, db, nil)
+		return
+	}
+
+	// Set success message
+	session := sessions.Default(c)
+	session.AddFlash("Range day logged successfully")
+	session.Save()
+
+	// Redirect to range day index page
+	c.Redirect(http.StatusSeeOther, "/owner/range-days")
+}
+
+// RangeDayShow displays a single range day
+func (o *OwnerController) RangeDayShow(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Load the range day, ensuring it belongs to the user
+	rangeDay, ok := o.findOwnedRangeDay(c, dbUser)
+	if !ok {
+		return
+	}
+
+	// Create owner data for the view
+	ownerData := data.NewOwnerData().
+		WithTitle("Range Day Details").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRangeDay(rangeDay)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Range Day Details")
+
+	// Render the range day details view
+	rangeday.Show(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeDayEdit displays the form to edit a range day
+func (o *OwnerController) RangeDayEdit(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Load the range day, ensuring it belongs to the user
+	rangeDay, ok := o.findOwnedRangeDay(c, dbUser)
+	if !ok {
+		return
+	}
+
+	// Create owner data for the view with the picker options
+	ownerData := data.NewOwnerData().
+		WithTitle("Edit Range Day").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithRangeDay(rangeDay)
+	loadRangeDayFormOptions(o.db.GetDB(), ownerData, dbUser.ID)
+
+	// Set CSRF token, roles and flashes
+	o.prepareOwnerPage(c, ownerData, userInfo.GetUserName(), "Edit Range Day")
+
+	// Render the range day edit view
+	rangeday.Edit(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// RangeDayUpdate handles updating a range day
+func (o *OwnerController) RangeDayUpdate(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Load the range day, ensuring it belongs to the user
+	rangeDay, ok := o.findOwnedRangeDay(c, dbUser)
+	if !ok {
+		return
+	}
+
+	// Parse form values
+	db := o.db.GetDB()
+	if err := c.Request.ParseForm(); err != nil {
+		handleRangeDayFormError(c, dbUser, "Failed to parse form", nil, http.StatusUnprocessableEntity, db, rangeDay)
+		return
+	}
+
+	// Apply the form to the range day
+	formErrors := parseRangeDayForm(c, rangeDay)
+	if len(formErrors) > 0 {
+		handleRangeDayFormError(c, dbUser, "Please fix the errors below", formErrors, http.StatusUnprocessableEntity, db, rangeDay)
+		return
+	}
+
+	// Create a new range inline if the owner named one instead of picking from the list
+	if err := o.resolveRangeDayRange(c, db, rangeDay); err != nil {
+		handleRangeDayFormError(c, dbUser, "Failed to create range: "+err.Error(), nil, http.StatusUnprocessableEntity, db, rangeDay)
+		return
+	}
+
+	// Validate and update the range day
+	if err := models.UpdateRangeDayWithValidation(db, rangeDay); err != nil {
+		logger.Error("Failed to update range day", err, map[string]interface{}{
+			"user_id":      dbUser.ID,
+			"email":        dbUser.Email,
+			"range_day_id": rangeDay.ID,
+		})
+		handleRangeDayFormError(c, dbUser, "Failed to update range day: "+err.Error(), nil, http.StatusUnprocessableEntity, db, rangeDay)
+		return
+	}
+
+	// Update was successful
+	session := sessions.Default(c)
+	session.AddFlash("Range day updated successfully")
+	session.Save()
+
+	// Redirect to the range day index page
+	c.Redirect(http.StatusSeeOther, "/owner/range-days")
+}
+
+// RangeDayDelete handles deleting a range day
+func (o *OwnerController) RangeDayDelete(c *gin.Context) {
+	// Get the current user's authentication status and email
+	authController, exists := c.Get("authController")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get current user information
+	authInterface := authController.(AuthControllerInterface)
+	userInfo, authenticated := authInterface.GetCurrentUser(c)
+	if !authenticated {
+		// Set flash message
+		if setFlash, exists := c.Get("setFlash"); exists {
+			setFlash.(func(string))("You must be logged in to access this page")
+		}
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Get the user from the database
+	ctx := context.Background()
+	dbUser, err := o.db.GetUserByEmail(ctx, userInfo.GetUserName())
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	// Parse the range day ID from the URL
+	session := sessions.Default(c)
+	rangeDayID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		session.AddFlash("Invalid range day ID")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/range-days")
+		return
+	}
+
+	// Delete the range day; the model checks ownership
+	if err := models.DeleteRangeDay(o.db.GetDB(), uint(rangeDayID), dbUser.ID); err != nil {
+		session.AddFlash("Range day not found or you don't have permission to delete it")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/range-days")
+		return
+	}
+
+	// Set a success flash message
+	session.AddFlash("Range day deleted successfully")
+	session.Save()
+
+	// Redirect to the range day index page
+	c.Redirect(http.StatusSeeOther, "/owner/range-days")
+}
+
+// findOwnedRangeDay loads the range day named by the :id param for the user,
+// redirecting to the index with a flash message when it can't be found
+func (o *OwnerController) findOwnedRangeDay(c *gin.Context, dbUser *database.User) (*models.RangeDay, bool) {
+	session := sessions.Default(c)
+
+	// Parse the range day ID from the URL
+	rangeDayID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		session.AddFlash("Invalid range day ID")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/range-days")
+		return nil, false
+	}
+
+	// Get the range day, scoped to the user
+	rangeDay, err := models.FindRangeDayByID(o.db.GetDB(), uint(rangeDayID), dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to fetch range day", err, map[string]interface{}{
+			"user_id":      dbUser.ID,
+			"email":        dbUser.Email,
+			"range_day_id": rangeDayID,
+		})
+		session.AddFlash("Range day not found")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/range-days")
+		return nil, false
+	}
+
+	return rangeDay, true
+}
+
+// loadRangeDayFormOptions fills the range, gun and ammunition pickers for the range day form
+func loadRangeDayFormOptions(db *gorm.DB, ownerData *data.OwnerData, userID uint) {
+	// Fetch the public ranges and the owner's private ranges ordered by name
+	ranges, err := models.FindRangesForUser(db, userID)
+	if err != nil {
+		logger.Error("Failed to fetch ranges", err, nil)
+		ranges = []models.Range{}
+	}
+
+	// Fetch the owner's guns ordered by name
+	var guns []models.Gun
+	if err := db.Where("owner_id = ?", userID).Order("name ASC").Find(&guns).Error; err != nil {
+		logger.Error("Failed to fetch guns", err, nil)
+		guns = []models.Gun{}
+	}
+
+	// Fetch the owner's ammunition ordered by name
+	var ammo []models.Ammo
+	if err := db.Where("owner_id = ?", userID).Order("name ASC").Find(&ammo).Error; err != nil {
+		logger.Error("Failed to fetch ammunition", err, nil)
+		ammo = []models.Ammo{}
+	}
+
+	ownerData.WithRanges(ranges).WithGuns(guns).WithAmmo(ammo)
+}
+
+// parseRangeDayForm reads the posted range day form into rangeDay and returns any field errors
+func parseRangeDayForm(c *gin.Context, rangeDay *models.RangeDay) map[string]string {
+	formErrors := make(map[string]string)
+
+	// Parse date (required)
+	dateStr := c.Request.PostForm.Get("date")
+	if dateStr == "" {
+		formErrors["date"] = "Date is required"
+	} else if date, err := time.Parse(rangeDayDateLayout, dateStr); err != nil {
+		formErrors["date"] = "Invalid date"
+	} else {
+		rangeDay.Date = date
+	}
+
+	// Parse range ID (required unless a new range is named)
+	rangeIDStr := c.Request.PostForm.Get("range_id")
+	newRangeName := strings.TrimSpace(c.Request.PostForm.Get("new_range_name"))
+	if rangeIDStr == "" && newRangeName == "" {
+		formErrors["range_id"] = "Range is required"
+	} else if rangeIDStr != "" {
+		if rangeID, err := strconv.ParseUint(rangeIDStr, 10, 64); err != nil {
+			formErrors["range_id"] = "Invalid range"
+		} else {
+			rangeDay.RangeID = uint(rangeID)
+		}
+	}
+
+	// Parse the line items; the gun, ammo, shots and notes fields repeat once per row
+	rangeDay.Items = parseRangeDayItems(c, formErrors)
+
+	// Comments (optional, max 1000 chars)
+	rangeDay.Comments = c.Request.PostForm.Get("comments")
+	if len(rangeDay.Comments) > 1000 {
+		formErrors["comments"] = "Comments are too long (maximum 1000 characters)"
+	}
+
+	return formErrors
+}
+
+// parseRangeDayItems reads the repeated line item fields of the range day form,
+// skipping blank rows and recording field errors against the row they came from
+func parseRangeDayItems(c *gin.Context, formErrors map[string]string) []models.RangeDayItem {
+	gunIDs := c.Request.PostForm["gun_id"]
+	ammoIDs := c.Request.PostForm["ammo_id"]
+	shots := c.Request.PostForm["shots_fired"]
+	notes := c.Request.PostForm["item_notes"]
+
+	field := func(values []string, i int) string {
+		if i < len(values) {
+			return strings.TrimSpace(values[i])
+		}
+		return ""
+	}
+
+	items := []models.RangeDayItem{}
+	for i := 0; i < len(gunIDs) || i < len(ammoIDs) || i < len(shots) || i < len(notes); i++ {
+		gunIDStr, ammoIDStr, shotsStr, notesStr := field(gunIDs, i), field(ammoIDs, i), field(shots, i), field(notes, i)
+		if gunIDStr == "" && ammoIDStr == "" && shotsStr == "" && notesStr == "" {
+			continue
+		}
+
+		rowKey := "item_" + strconv.Itoa(i)
+		item := models.RangeDayItem{Notes: notesStr}
+
+		// Parse gun ID (required)
+		if gunID, err := strconv.ParseUint(gunIDStr, 10, 64); err != nil {
+			formErrors[rowKey] = "Firearm is required"
+		} else {
+			item.GunID = uint(gunID)
+		}
+
+		// Parse ammo ID (required)
+		if ammoID, err := strconv.ParseUint(ammoIDStr, 10, 64); err != nil {
+			if _, exists := formErrors[rowKey]; !exists {
+				formErrors[rowKey] = "Ammunition is required"
+			}
+		} else {
+			item.AmmoID = uint(ammoID)
+		}
+
+		// Parse shots fired (optional, defaulting to 0)
+		if shotsStr != "" {
+			if shotsFired, err := strconv.Atoi(shotsStr); err != nil || shotsFired < 0 {
+				if _, exists := formErrors[rowKey]; !exists {
+					formErrors[rowKey] = "Shots fired must be a non-negative number"
+				}
+			} else {
+				item.ShotsFired = shotsFired
+			}
+		}
+
+		// Notes (optional, max 500 chars)
+		if len(item.Notes) > 500 {
+			if _, exists := formErrors[rowKey]; !exists {
+				formErrors[rowKey] = "Notes are too long (maximum 500 characters)"
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		formErrors["items"] = "Add at least one firearm"
+	}
+
+	return items
+}
+
+// resolveRangeDayRange points the range day at the range named in new_range_name, adding it
+// as one of the owner's private ranges unless they can already see a range by that name.
+// It does nothing when the owner picked an existing range.
+func (o *OwnerController) resolveRangeDayRange(c *gin.Context, db *gorm.DB, rangeDay *models.RangeDay) error {
+	newRangeName := strings.TrimSpace(c.Request.PostForm.Get("new_range_name"))
+	if c.Request.PostForm.Get("range_id") != "" || newRangeName == "" {
+		return nil
+	}
+
+	newRange := &models.Range{
+		OwnerID:   rangeDay.UserID,
+		RangeName: newRangeName,
+		City:      strings.TrimSpace(c.Request.PostForm.Get("new_range_city")),
+		State:     strings.ToUpper(strings.TrimSpace(c.Request.PostForm.Get("new_range_state"))),
+	}
+	if len(newRange.State) > 2 {
+		newRange.State = newRange.State[:2]
+	}
+
+	if existing, err := models.FindRangeByNameForUser(db, newRange.RangeName, newRange.City, newRange.State, rangeDay.UserID); err == nil {
+		rangeDay.RangeID = existing.ID
+		return nil
+	}
+
+	geocodeRange(c.Request.Context(), o.geocoder, newRange)
+	if err := models.CreateRange(db, newRange); err != nil {
+		return err
+	}
+
+	rangeDay.RangeID = newRange.ID
+	return nil
+}
+
+// Helper function to handle range day create and update errors.
+// A nil rangeDay renders the new form; otherwise the edit form is rendered.
+func handleRangeDayFormError(c *gin.Context, dbUser *database.User, errMsg string, formErrors map[string]string, statusCode int, db *gorm.DB, rangeDay *models.RangeDay) {
+	title := "Log Range Day"
+	if rangeDay != nil {
+		title = "Edit Range Day"
+	}
+
+	// Create owner data for the view with the picker options
+	ownerData := data.NewOwnerData().
+		WithTitle(title).
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithError(errMsg).
+		WithRangeDay(rangeDay)
+	loadRangeDayFormOptions(db, ownerData, dbUser.ID)
+
+	// Initialize form errors if not provided
+	if formErrors == nil {
+		formErrors = make(map[string]string)
+	}
+
+	// Preserve user input data by storing in form errors with a special prefix
+	for _, field := range []string{"date", "range_id", "new_range_name", "new_range_city", "new_range_state", "comments"} {
+		formErrors["value_"+field] = c.Request.PostForm.Get(field)
+	}
+
+	// Line item fields repeat, so each row's values are stored with its index
+	itemCount := 0
+	for _, field := range []string{"gun_id", "ammo_id", "shots_fired", "item_notes"} {
+		for i, value := range c.Request.PostForm[field] {
+			formErrors["value_"+field+"_"+strconv.Itoa(i)] = value
+			if i+1 > itemCount {
+				itemCount = i + 1
+			}
+		}
+	}
+	if itemCount > 0 {
+		formErrors["value_item_count"] = strconv.Itoa(itemCount)
+	}
+	ownerData = ownerData.WithFormErrors(formErrors)
+
+	// Set authentication data from context
+	if csrfToken, exists := c.Get("csrf_token"); exists {
+		if token, ok := csrfToken.(string); ok {
+			ownerData.Auth.CSRFToken = token
+		}
+	}
+
+	// Get authData from context to preserve roles
+	if authDataInterface, exists := c.Get("authData"); exists {
+		if authData, ok := authDataInterface.(data.AuthData); ok {
+			ownerData.Auth = authData.WithTitle(title).WithError(errMsg)
+		}
+	}
+
+	// Set appropriate HTTP status code
+	c.Status(statusCode)
+
+	// Render the form with errors
+	if rangeDay != nil {
+		rangeday.Edit(ownerData).Render(c.Request.Context(), c.Writer)
+		return
+	}
+	rangeday.New(ownerData).Render(c.Request.Context(), c.Writer)
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// currentSessionID returns the ID of the session the request belongs to, or 0
+// if it hasn't been stored
+func currentSessionID(c *gin.Context, db *gorm.DB) uint {
+	id, err := models.FindSessionIDByToken(db, sessions.Default(c).ID())
+	if err != nil {
+		logger.Error("Failed to find the current session", err, nil)
+	}
+	return id
+}
+
+// Sessions lists the devices the owner is signed in on
+func (o *OwnerController) Sessions(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	db := o.db.GetDB()
+	active, err := models.FindActiveSessions(db, dbUser.ID)
+	if err != nil {
+		logger.Error("Failed to load sessions", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		c.String(http.StatusInternalServerError, "Failed to load sessions")
+		return
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle("Signed In Devices").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithSessions(active, currentSessionID(c, db))
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Signed In Devices")
+
+	owner.Sessions(ownerData).Render(c.Request.Context(), c.Writer)
+}
+
+// SessionRevoke signs the owner out of one of their other sessions
+func (o *OwnerController) SessionRevoke(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	db := o.db.GetDB()
+	sessionID, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	session := sessions.Default(c)
+	if uint(sessionID) == currentSessionID(c, db) {
+		session.AddFlash("Log out to end the session you're using")
+		session.Save()
+		c.Redirect(http.StatusSeeOther, "/owner/profile/sessions")
+		return
+	}
+
+	revoked, err := models.RevokeSession(db, dbUser.ID, uint(sessionID))
+	switch {
+	case err != nil:
+		logger.Error("Failed to revoke session", err, map[string]interface{}{
+			"user_id":    dbUser.ID,
+			"session_id": sessionID,
+		})
+		session.AddFlash("Failed to sign out that device, please try again")
+	case !revoked:
+		session.AddFlash("Session not found")
+	default:
+		logger.Info("Session revoked", map[string]interface{}{
+			"user_id":    dbUser.ID,
+			"session_id": sessionID,
+		})
+		session.AddFlash("That device has been signed out")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile/sessions")
+}
+
+// SessionsRevokeOthers signs the owner out everywhere but the session they're using
+func (o *OwnerController) SessionsRevokeOthers(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	db := o.db.GetDB()
+	session := sessions.Default(c)
+	currentID := currentSessionID(c, db)
+	if currentID == 0 {
+		// Without a stored session to keep, this would sign the owner out too
+		c.Redirect(http.StatusSeeOther, "/owner/profile/sessions")
+		return
+	}
+
+	revoked, err := models.RevokeUserSessions(db, dbUser.ID, currentID)
+	if err != nil {
+		logger.Error("Failed to revoke sessions", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		session.AddFlash("Failed to sign out your other devices, please try again")
+	} else {
+		logger.Info("Other sessions revoked", map[string]interface{}{
+			"user_id": dbUser.ID,
+			"count":   revoked,
+		})
+		session.AddFlash("Your other devices have been signed out")
+	}
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile/sessions")
+}
@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/cmd/web/views/owner"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/totp"
+)
+
+// twoFactorIssuer names the site in authenticator apps
+const twoFactorIssuer = "Virtual Armory"
+
+// twoFactorSecretKey is the session key for a secret being set up, until the owner confirms it
+const twoFactorSecretKey = "two_factor_secret"
+
+// TwoFactor shows the owner's two-factor authentication settings. Without it
+// on, it starts setting it up with a new secret to add to an authenticator app.
+func (o *OwnerController) TwoFactor(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	o.renderTwoFactor(c, dbUser, nil, "", http.StatusOK)
+}
+
+// TwoFactorEnable turns on two-factor authentication once the owner enters a
+// code for the new secret, and shows their recovery codes
+func (o *OwnerController) TwoFactorEnable(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	session := sessions.Default(c)
+	secret, _ := session.Get(twoFactorSecretKey).(string)
+	if dbUser.TOTPEnabled || secret == "" {
+		c.Redirect(http.StatusSeeOther, "/owner/profile/two-factor")
+		return
+	}
+
+	codes, err := o.db.EnableTwoFactor(c.Request.Context(), dbUser, secret, c.PostForm("code"))
+	if err != nil {
+		if !errors.Is(err, database.ErrInvalidTwoFactorCode) {
+			logger.Error("Failed to enable two-factor authentication", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+		}
+		o.renderTwoFactor(c, dbUser, nil, "That code didn't match. Check the time on your device and try again.", http.StatusUnprocessableEntity)
+		return
+	}
+
+	session.Delete(twoFactorSecretKey)
+	session.Save()
+
+	logger.Info("Two-factor authentication enabled", map[string]interface{}{
+		"user_id": dbUser.ID,
+	})
+	o.renderTwoFactor(c, dbUser, codes, "", http.StatusOK)
+}
+
+// TwoFactorDisable turns off two-factor authentication once the owner enters a
+// code from their authenticator or a recovery code
+func (o *OwnerController) TwoFactorDisable(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	if err := o.db.DisableTwoFactor(c.Request.Context(), dbUser, c.PostForm("code")); err != nil {
+		if !errors.Is(err, database.ErrInvalidTwoFactorCode) && !errors.Is(err, database.ErrTwoFactorNotEnabled) {
+			logger.Error("Failed to disable two-factor authentication", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+		}
+		o.renderTwoFactor(c, dbUser, nil, "That code didn't work, so two-factor authentication is still on.", http.StatusUnprocessableEntity)
+		return
+	}
+
+	logger.Info("Two-factor authentication disabled", map[string]interface{}{
+		"user_id": dbUser.ID,
+	})
+
+	session := sessions.Default(c)
+	session.AddFlash("Two-factor authentication is off")
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile/two-factor")
+}
+
+// TwoFactorRecoveryCodes replaces the owner's recovery codes with a new set and shows them
+func (o *OwnerController) TwoFactorRecoveryCodes(c *gin.Context) {
+	dbUser, ok := o.currentOwner(c)
+	if !ok {
+		return
+	}
+
+	codes, err := o.db.RegenerateRecoveryCodes(c.Request.Context(), dbUser)
+	if err != nil {
+		if !errors.Is(err, database.ErrTwoFactorNotEnabled) {
+			logger.Error("Failed to regenerate recovery codes", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+		}
+		o.renderTwoFactor(c, dbUser, nil, "We couldn't make new recovery codes, please try again.", http.StatusUnprocessableEntity)
+		return
+	}
+
+	o.renderTwoFactor(c, dbUser, codes, "", http.StatusOK)
+}
+
+// renderTwoFactor renders the two-factor authentication page. Without two-factor
+// authentication on, it shows the secret being set up, making one if needed.
+func (o *OwnerController) renderTwoFactor(c *gin.Context, dbUser *database.User, recoveryCodes []string, errorMessage string, status int) {
+	setup := &data.TwoFactorSetup{
+		Enabled:       dbUser.TOTPEnabled,
+		RecoveryCodes: recoveryCodes,
+	}
+
+	if dbUser.TOTPEnabled {
+		remaining, err := o.db.CountRecoveryCodes(dbUser.ID)
+		if err != nil {
+			logger.Error("Failed to count recovery codes", err, map[string]interface{}{
+				"user_id": dbUser.ID,
+			})
+		}
+		setup.RemainingCodes = remaining
+	} else {
+		// Keep the same secret until it's confirmed, so a reload doesn't invalidate
+		// one already added to an authenticator app
+		session := sessions.Default(c)
+		secret, _ := session.Get(twoFactorSecretKey).(string)
+		if secret == "" {
+			var err error
+			if secret, err = totp.GenerateSecret(); err != nil {
+				logger.Error("Failed to generate two-factor secret", err, map[string]interface{}{
+					"user_id": dbUser.ID,
+				})
+				c.String(http.StatusInternalServerError, "Failed to start two-factor authentication setup")
+				return
+			}
+			session.Set(twoFactorSecretKey, secret)
+			session.Save()
+		}
+		setup.Secret = totp.FormatSecret(secret)
+		setup.ProvisioningURI = totp.ProvisioningURI(twoFactorIssuer, dbUser.Email, secret)
+	}
+
+	ownerData := data.NewOwnerData().
+		WithTitle("Two-Factor Authentication").
+		WithAuthenticated(true).
+		WithUser(dbUser).
+		WithTwoFactor(setup).
+		WithError(errorMessage)
+	o.prepareOwnerPage(c, ownerData, dbUser.Email, "Two-Factor Authentication")
+
+	c.Status(status)
+	owner.TwoFactor(ownerData).Render(c.Request.Context(), c.Writer)
+}
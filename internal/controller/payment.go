@@ -1,9 +1,11 @@
 package controller
 
 import (
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
 	"github.com/hail2skins/armory/internal/services/stripe"
+	"github.com/shaj13/go-guardian/v2/auth"
 )
 
 // AuthProvider defines an interface for authentication providers
@@ -22,6 +25,13 @@ type AuthProvider interface {
 	GetCurrentUser(c *gin.Context) (models.User, bool)
 }
 
+const (
+	// planChangeSessionKey holds the tier and proration date of the owner's last plan change preview
+	planChangeSessionKey = "plan_change_preview"
+	// planChangePreviewTTL is how long a plan change preview's price can be confirmed for
+	planChangePreviewTTL = 15 * time.Minute
+)
+
 // PaymentController handles payment-related routes
 type PaymentController struct {
 	db            database.Service
@@ -36,29 +46,6 @@ func NewPaymentController(db database.Service) *PaymentController {
 	}
 }
 
-// canSubscribeToTier checks if a user can subscribe to a specific tier based on their current subscription
-func (p *PaymentController) canSubscribeToTier(currentTier string, targetTier string) bool {
-	// Users can always upgrade to a higher tier
-	switch currentTier {
-	case "free":
-		return true // Free users can subscribe to any tier
-	case "monthly":
-		// Monthly users can upgrade to yearly, lifetime, or premium_lifetime
-		return targetTier != "monthly"
-	case "yearly":
-		// Yearly users can upgrade to lifetime or premium_lifetime
-		return targetTier != "monthly" && targetTier != "yearly"
-	case "lifetime":
-		// Lifetime users can only upgrade to premium_lifetime
-		return targetTier == "premium_lifetime"
-	case "premium_lifetime":
-		// Premium lifetime users cannot subscribe to any other tier
-		return false
-	default:
-		return true
-	}
-}
-
 // PricingHandler handles the GET request to /pricing
 func (p *PaymentController) PricingHandler(c *gin.Context) {
 	// Get the current user's authentication status and email
@@ -142,10 +129,9 @@ func (p *PaymentController) PricingHandler(c *gin.Context) {
 
 	// Create PricingData with the AuthData
 	pricingData := payment.PricingData{
-		AuthData: authData,
-		// For now, we'll just use a default value for CurrentPlan
-		// In the future, this will come from the user's subscription data
-		CurrentPlan: "free",
+		AuthData:    authData,
+		CurrentPlan: models.FreeTier,
+		Plans:       models.CatalogPlans(),
 	}
 
 	// Get the CSRF token from the context and set it in PricingData
@@ -206,6 +192,12 @@ func (p *PaymentController) CreateCheckoutSession(c *gin.Context) {
 		return
 	}
 
+	// Only plans that are on sale can be bought
+	if plan, ok := models.LookupPlan(tier); !ok || !plan.Purchasable() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This subscription tier isn't available"})
+		return
+	}
+
 	// Get the user from the database
 	dbUser, err := p.db.GetUserByEmail(c.Request.Context(), userInfo.GetUserName())
 	if err != nil {
@@ -214,7 +206,7 @@ func (p *PaymentController) CreateCheckoutSession(c *gin.Context) {
 	}
 
 	// Check if the user can subscribe to the tier
-	if !p.canSubscribeToTier(dbUser.SubscriptionTier, tier) {
+	if !dbUser.CanSubscribeToTier(tier) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot subscribe to this tier"})
 		return
 	}
@@ -307,6 +299,11 @@ func (p *PaymentController) HandleWebhook(c *gin.Context) {
 
 	// Handle the webhook event
 	err = p.stripeService.HandleWebhook(payload, signature)
+	if errors.Is(err, stripe.ErrEventInProgress) {
+		// Stripe retries deliveries that don't succeed
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -552,3 +549,189 @@ func (p *PaymentController) CancelSubscription(c *gin.Context) {
 	// Redirect to the owner dashboard
 	c.Redirect(http.StatusSeeOther, "/owner")
 }
+
+// ShowPlanChange handles GET /subscription/change, showing what moving the
+// subscription to another plan would cost before the owner confirms it
+func (p *PaymentController) ShowPlanChange(c *gin.Context) {
+	userInfo, dbUser, ok := p.billingUser(c)
+	if !ok {
+		return
+	}
+
+	tier := c.Query("tier")
+	preview, err := p.stripeService.PreviewPlanChange(dbUser, tier)
+	if err != nil {
+		planChangeFailed(c, dbUser, tier, err)
+		return
+	}
+
+	currentPlanName := dbUser.SubscriptionTier
+	if current, ok := models.LookupPlan(dbUser.SubscriptionTier); ok {
+		currentPlanName = current.Name
+	}
+
+	authData := data.NewAuthData()
+	authData.Authenticated = true
+	if authDataInterface, exists := c.Get("authData"); exists {
+		if contextAuthData, ok := authDataInterface.(data.AuthData); ok {
+			authData = contextAuthData
+		}
+	}
+	authData = authData.WithTitle("Change Plan")
+	authData.Email = userInfo.GetUserName()
+
+	// Keep the preview's proration date so confirming charges what was shown
+	session := sessions.Default(c)
+	session.Set(planChangeSessionKey, tier+":"+strconv.FormatInt(preview.ProrationDate, 10))
+	session.Save()
+
+	payment.PlanChange(payment.PlanChangeData{
+		AuthData:        authData,
+		CurrentPlanName: currentPlanName,
+		Plan:            preview.Plan,
+		AmountDue:       preview.AmountLabel(),
+	}).Render(c.Request.Context(), c.Writer)
+}
+
+// ChangePlan handles POST /subscription/change, moving the subscription to
+// another plan with the proration the owner was shown
+func (p *PaymentController) ChangePlan(c *gin.Context) {
+	_, dbUser, ok := p.billingUser(c)
+	if !ok {
+		return
+	}
+
+	tier := c.PostForm("tier")
+	prorationDate := previewedProrationDate(c, tier)
+	if err := p.stripeService.ChangePlan(dbUser, tier, prorationDate); err != nil {
+		planChangeFailed(c, dbUser, tier, err)
+		return
+	}
+
+	logger.Info("Subscription plan changed", map[string]interface{}{
+		"user_id": dbUser.ID,
+		"tier":    dbUser.SubscriptionTier,
+	})
+
+	planName := dbUser.SubscriptionTier
+	if plan, ok := models.LookupPlan(dbUser.SubscriptionTier); ok {
+		planName = plan.Name
+	}
+	subscriptionFlash(c, "Your subscription is now on the "+planName+" plan.")
+}
+
+// UpdatePaymentMethod handles POST /subscription/payment-method, sending the
+// owner to Stripe to enter the card their subscription is charged to
+func (p *PaymentController) UpdatePaymentMethod(c *gin.Context) {
+	_, dbUser, ok := p.billingUser(c)
+	if !ok {
+		return
+	}
+
+	session, err := p.stripeService.CreatePaymentMethodSession(dbUser)
+	if err != nil {
+		logger.Error("Failed to create payment method session", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		subscriptionFlash(c, "We couldn't open the payment method form. Please try again.")
+		return
+	}
+
+	redirectToStripe(c, session.URL)
+}
+
+// BillingPortal handles POST /subscription/portal, sending the owner to
+// Stripe's billing portal to manage their subscription, cards and invoices
+func (p *PaymentController) BillingPortal(c *gin.Context) {
+	_, dbUser, ok := p.billingUser(c)
+	if !ok {
+		return
+	}
+
+	portal, err := p.stripeService.CreatePortalSession(dbUser)
+	if err != nil {
+		logger.Error("Failed to create billing portal session", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+		})
+		subscriptionFlash(c, "We couldn't open the billing portal. Please try again.")
+		return
+	}
+
+	redirectToStripe(c, portal.URL)
+}
+
+// billingUser returns the signed in user for the subscription pages,
+// responding for the handler when there isn't one
+func (p *PaymentController) billingUser(c *gin.Context) (auth.Info, *database.User, bool) {
+	authController, ok := c.MustGet("authController").(AuthControllerInterface)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return nil, nil, false
+	}
+	userInfo, authenticated := authController.GetCurrentUser(c)
+	if !authenticated {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return nil, nil, false
+	}
+
+	dbUser, err := p.db.GetUserByEmail(c.Request.Context(), userInfo.GetUserName())
+	if err != nil || dbUser == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return nil, nil, false
+	}
+	return userInfo, dbUser, true
+}
+
+// planChangeFailed explains why a plan change couldn't be previewed or made
+func planChangeFailed(c *gin.Context, dbUser *database.User, tier string, err error) {
+	switch {
+	case errors.Is(err, stripe.ErrNoStripeSubscription):
+		c.Redirect(http.StatusSeeOther, "/pricing")
+	case errors.Is(err, stripe.ErrPlanChangeInvalid):
+		subscriptionFlash(c, "Your subscription can't be changed to that plan.")
+	default:
+		logger.Error("Failed to change subscription plan", err, map[string]interface{}{
+			"user_id": dbUser.ID,
+			"tier":    tier,
+		})
+		subscriptionFlash(c, "We couldn't change your plan, and you haven't been charged. Please try again.")
+	}
+}
+
+// previewedProrationDate returns the proration date of the owner's last
+// preview of a move to tier, and forgets it. Without a recent preview of that
+// plan it's 0, and Stripe prorates from now.
+func previewedProrationDate(c *gin.Context, tier string) int64 {
+	session := sessions.Default(c)
+	previewed, _ := session.Get(planChangeSessionKey).(string)
+	session.Delete(planChangeSessionKey)
+	session.Save()
+
+	previewedTier, date, ok := strings.Cut(previewed, ":")
+	if !ok || previewedTier != tier {
+		return 0
+	}
+	prorationDate, err := strconv.ParseInt(date, 10, 64)
+	if err != nil || time.Since(time.Unix(prorationDate, 0)) > planChangePreviewTTL {
+		return 0
+	}
+	return prorationDate
+}
+
+// subscriptionFlash shows a message on the subscription management page
+func subscriptionFlash(c *gin.Context, message string) {
+	session := sessions.Default(c)
+	session.AddFlash(message)
+	session.Save()
+	c.Redirect(http.StatusSeeOther, "/owner/profile/subscription")
+}
+
+// redirectToStripe sends the owner to a Stripe hosted page, or returns its URL
+// in tests as the checkout does
+func redirectToStripe(c *gin.Context, url string) {
+	if os.Getenv("APP_ENV") == "test" {
+		c.JSON(http.StatusOK, gin.H{"url": url})
+	} else {
+		c.Redirect(http.StatusSeeOther, url)
+	}
+}
@@ -322,6 +322,69 @@ func (m *MockDB) DeleteGun(db *gorm.DB, id uint, ownerID uint) error {
 	return args.Error(0)
 }
 
+// UnlockUserByToken is a mock method to satisfy the database.Service interface
+func (m *MockDB) UnlockUserByToken(ctx context.Context, token string) (*database.User, error) {
+	args := m.Called(ctx, token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.User), args.Error(1)
+}
+
+// UnlockUser is a mock method to satisfy the database.Service interface
+func (m *MockDB) UnlockUser(ctx context.Context, user *database.User, adminID uint) error {
+	args := m.Called(ctx, user, adminID)
+	return args.Error(0)
+}
+
+// FindSecurityEvents is a mock method to satisfy the database.Service interface
+func (m *MockDB) FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error) {
+	args := m.Called(userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SecurityEvent), args.Error(1)
+}
+
+// EnableTwoFactor is a mock method to satisfy the database.Service interface
+func (m *MockDB) EnableTwoFactor(ctx context.Context, user *database.User, secret, code string) ([]string, error) {
+	args := m.Called(user, secret, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// VerifyTwoFactor is a mock method to satisfy the database.Service interface
+func (m *MockDB) VerifyTwoFactor(ctx context.Context, userID uint, code string) (*database.User, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.User), args.Error(1)
+}
+
+// DisableTwoFactor is a mock method to satisfy the database.Service interface
+func (m *MockDB) DisableTwoFactor(ctx context.Context, user *database.User, code string) error {
+	args := m.Called(user, code)
+	return args.Error(0)
+}
+
+// RegenerateRecoveryCodes is a mock method to satisfy the database.Service interface
+func (m *MockDB) RegenerateRecoveryCodes(ctx context.Context, user *database.User) ([]string, error) {
+	args := m.Called(user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// CountRecoveryCodes is a mock method to satisfy the database.Service interface
+func (m *MockDB) CountRecoveryCodes(userID uint) (int64, error) {
+	args := m.Called(userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // IsRecoveryExpired is a mock method to satisfy the database.Service interface
 func (m *MockDB) IsRecoveryExpired(ctx context.Context, token string) (bool, error) {
 	args := m.Called(ctx, token)
@@ -519,6 +582,12 @@ func (m *MockStripeService) HandleWebhook(payload []byte, signature string) erro
 	return args.Error(0)
 }
 
+// ReplayEvent mocks processing a stored webhook event again
+func (m *MockStripeService) ReplayEvent(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
 // GetSubscriptionDetails mocks getting details about a subscription
 func (m *MockStripeService) GetSubscriptionDetails(subscriptionID string) (*stripe.Subscription, error) {
 	args := m.Called(subscriptionID)
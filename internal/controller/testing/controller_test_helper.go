@@ -149,6 +149,69 @@ func (m *MockDB) ResetPassword(ctx context.Context, token, newPassword string) e
 	return args.Error(0)
 }
 
+// UnlockUserByToken mocks the UnlockUserByToken method
+func (m *MockDB) UnlockUserByToken(ctx context.Context, token string) (*database.User, error) {
+	args := m.Called(ctx, token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.User), args.Error(1)
+}
+
+// UnlockUser mocks the UnlockUser method
+func (m *MockDB) UnlockUser(ctx context.Context, user *database.User, adminID uint) error {
+	args := m.Called(ctx, user, adminID)
+	return args.Error(0)
+}
+
+// FindSecurityEvents mocks the FindSecurityEvents method
+func (m *MockDB) FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error) {
+	args := m.Called(userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SecurityEvent), args.Error(1)
+}
+
+// EnableTwoFactor mocks the EnableTwoFactor method
+func (m *MockDB) EnableTwoFactor(ctx context.Context, user *database.User, secret, code string) ([]string, error) {
+	args := m.Called(user, secret, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// VerifyTwoFactor mocks the VerifyTwoFactor method
+func (m *MockDB) VerifyTwoFactor(ctx context.Context, userID uint, code string) (*database.User, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.User), args.Error(1)
+}
+
+// DisableTwoFactor mocks the DisableTwoFactor method
+func (m *MockDB) DisableTwoFactor(ctx context.Context, user *database.User, code string) error {
+	args := m.Called(user, code)
+	return args.Error(0)
+}
+
+// RegenerateRecoveryCodes mocks the RegenerateRecoveryCodes method
+func (m *MockDB) RegenerateRecoveryCodes(ctx context.Context, user *database.User) ([]string, error) {
+	args := m.Called(user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// CountRecoveryCodes mocks the CountRecoveryCodes method
+func (m *MockDB) CountRecoveryCodes(userID uint) (int64, error) {
+	args := m.Called(userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // IsRecoveryExpired mocks the IsRecoveryExpired method
 func (m *MockDB) IsRecoveryExpired(ctx context.Context, token string) (bool, error) {
 	args := m.Called(ctx, token)
@@ -18,6 +18,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/fieldcrypt/fieldcrypttest"
 	"github.com/hail2skins/armory/internal/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,6 +27,8 @@ import (
 )
 
 func setupTestDB(t *testing.T, testName string) *gorm.DB {
+	fieldcrypttest.Use()
+
 	// Create a separate in-memory SQLite database for each test to avoid conflicts
 	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", testName)
 	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
@@ -132,6 +135,38 @@ func (s *MockDBService) ResetPassword(ctx context.Context, token, newPassword st
 	return nil
 }
 
+func (s *MockDBService) UnlockUserByToken(ctx context.Context, token string) (*database.User, error) {
+	return nil, nil
+}
+
+func (s *MockDBService) UnlockUser(ctx context.Context, user *database.User, adminID uint) error {
+	return nil
+}
+
+func (s *MockDBService) FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error) {
+	return nil, nil
+}
+
+func (s *MockDBService) EnableTwoFactor(ctx context.Context, user *database.User, secret, code string) ([]string, error) {
+	return nil, nil
+}
+
+func (s *MockDBService) VerifyTwoFactor(ctx context.Context, userID uint, code string) (*database.User, error) {
+	return nil, nil
+}
+
+func (s *MockDBService) DisableTwoFactor(ctx context.Context, user *database.User, code string) error {
+	return nil
+}
+
+func (s *MockDBService) RegenerateRecoveryCodes(ctx context.Context, user *database.User) ([]string, error) {
+	return nil, nil
+}
+
+func (s *MockDBService) CountRecoveryCodes(userID uint) (int64, error) {
+	return 0, nil
+}
+
 func (s *MockDBService) IsRecoveryExpired(ctx context.Context, token string) (bool, error) {
 	return false, nil
 }
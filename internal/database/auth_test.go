@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hail2skins/armory/internal/fieldcrypt/fieldcrypttest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -17,6 +18,8 @@ import (
 
 // setupTestDB creates a test database
 func setupTestDB(t *testing.T) (*gorm.DB, string) {
+	fieldcrypttest.Use()
+
 	// Create a temporary directory for the SQLite database
 	tempDir, err := os.MkdirTemp("", "auth-test-*")
 	require.NoError(t, err)
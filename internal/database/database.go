@@ -201,12 +201,17 @@ func New() Service {
 	// Seed the database with initial data
 	seed.RunSeeds(dbInstance.db)
 
+	// Load the subscription plans every tier check uses
+	if err := models.LoadPlanCatalog(dbInstance.db); err != nil {
+		log.Printf("Error loading subscription plans: %v", err)
+	}
+
 	return dbInstance
 }
 
 // AutoMigrate automatically migrates the schema
 func (s *service) AutoMigrate() error {
-	return s.db.AutoMigrate(
+	if err := s.db.AutoMigrate(
 		&User{},
 		&models.Payment{},
 		&models.Manufacturer{},
@@ -224,7 +229,30 @@ func (s *service) AutoMigrate() error {
 		&models.Ammo{},
 		&models.Range{},
 		&models.RangeDay{},
-	)
+		&models.RangeDayItem{},
+		&models.MaintenanceRecord{},
+		&models.ServiceInterval{},
+		&models.InventoryReport{},
+		&models.Attachment{},
+		&models.RateLimitCounter{},
+		&models.RateLimitEndpointStat{},
+		&models.RateLimitOffender{},
+		&models.RateLimitBlockEvent{},
+		&models.SecurityEvent{},
+		&models.RecoveryCode{},
+		&models.Passkey{},
+		&models.Session{},
+		&models.APIToken{},
+		&models.OIDCIdentity{},
+		&models.DataExport{},
+		&models.StripeEvent{},
+		&models.Plan{},
+	); err != nil {
+		return err
+	}
+
+	// Move range days recorded with a single gun into line items
+	return models.MigrateRangeDayItems(s.db)
 }
 
 // Health checks the health of the database connection by pinging the database.
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hail2skins/armory/internal/fieldcrypt/fieldcrypttest"
 	"github.com/hail2skins/armory/internal/models"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -178,6 +179,7 @@ func prepareTestDB(t *testing.T) {
 	if testDB != nil {
 		return
 	}
+	fieldcrypttest.Use()
 
 	// Create a temporary file for the test database
 	tempDir := os.TempDir()
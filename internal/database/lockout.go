@@ -0,0 +1,194 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// LockoutPolicy decides when failed sign ins lock an account and for how long.
+// An account locks after every Threshold failures in a row, first for
+// BaseDuration and then twice as long each time it locks again, up to MaxDuration.
+type LockoutPolicy struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+}
+
+// DefaultLockoutPolicy locks an account for 15 minutes after 5 failed sign ins
+var DefaultLockoutPolicy = LockoutPolicy{
+	Threshold:    5,
+	BaseDuration: 15 * time.Minute,
+	MaxDuration:  24 * time.Hour,
+}
+
+var lockout struct {
+	mu     sync.Mutex
+	policy *LockoutPolicy
+}
+
+// LockoutPolicyFromEnv reads the lockout policy from LOCKOUT_THRESHOLD,
+// LOCKOUT_DURATION and LOCKOUT_MAX_DURATION, using the default for any that
+// aren't set or aren't valid
+func LockoutPolicyFromEnv() LockoutPolicy {
+	policy := DefaultLockoutPolicy
+	if value := os.Getenv("LOCKOUT_THRESHOLD"); value != "" {
+		if threshold, err := strconv.Atoi(value); err == nil && threshold > 0 {
+			policy.Threshold = threshold
+		} else {
+			log.Printf("WARNING: Ignoring invalid LOCKOUT_THRESHOLD %q", value)
+		}
+	}
+	for name, duration := range map[string]*time.Duration{
+		"LOCKOUT_DURATION":     &policy.BaseDuration,
+		"LOCKOUT_MAX_DURATION": &policy.MaxDuration,
+	} {
+		if value := os.Getenv(name); value != "" {
+			if d, err := time.ParseDuration(value); err == nil && d > 0 {
+				*duration = d
+			} else {
+				log.Printf("WARNING: Ignoring invalid %s %q", name, value)
+			}
+		}
+	}
+	policy.MaxDuration = max(policy.MaxDuration, policy.BaseDuration)
+	return policy
+}
+
+// CurrentLockoutPolicy returns the lockout policy in use, reading it from the
+// environment the first time it's needed
+func CurrentLockoutPolicy() LockoutPolicy {
+	lockout.mu.Lock()
+	defer lockout.mu.Unlock()
+	if lockout.policy == nil {
+		policy := LockoutPolicyFromEnv()
+		lockout.policy = &policy
+	}
+	return *lockout.policy
+}
+
+// SetLockoutPolicy replaces the lockout policy in use. Passing nil reads it
+// from the environment again.
+func SetLockoutPolicy(policy *LockoutPolicy) {
+	lockout.mu.Lock()
+	defer lockout.mu.Unlock()
+	lockout.policy = policy
+}
+
+// Duration returns how long an account stays locked after a number of failed
+// sign ins in a row, or 0 when that many doesn't lock it
+func (p LockoutPolicy) Duration(attempts int) time.Duration {
+	if p.Threshold < 1 || attempts < p.Threshold || attempts%p.Threshold != 0 {
+		return 0
+	}
+	duration := p.BaseDuration
+	for locks := attempts / p.Threshold; locks > 1 && duration < p.MaxDuration; locks-- {
+		duration *= 2
+	}
+	return min(duration, p.MaxDuration)
+}
+
+// LockedUntil returns when the user's account unlocks, or the zero time when it isn't locked
+func (u *User) LockedUntil() time.Time {
+	duration := CurrentLockoutPolicy().Duration(u.LoginAttempts)
+	if duration == 0 {
+		return time.Time{}
+	}
+	until := u.LastLoginAttempt.Add(duration)
+	if !time.Now().Before(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// GenerateUnlockToken generates a one-time token for unlocking the account and sets its expiry
+func (u *User) GenerateUnlockToken() string {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return ""
+	}
+	u.UnlockToken = base64.URLEncoding.EncodeToString(token)
+	u.UnlockTokenExpiry = time.Now().Add(24 * time.Hour)
+	return u.UnlockToken
+}
+
+// IsUnlockExpired returns whether the unlock token has expired
+func (u *User) IsUnlockExpired() bool {
+	return time.Now().After(u.UnlockTokenExpiry)
+}
+
+// RecordFailedLogin counts a failed sign in against the user, locking their
+// account when it reaches the lockout threshold. It reports whether this
+// failure locked the account; if so the user has a new unlock token.
+func RecordFailedLogin(db *gorm.DB, user *User) (bool, error) {
+	user.IncrementLoginAttempts()
+	updates := map[string]interface{}{
+		"login_attempts":     user.LoginAttempts,
+		"last_login_attempt": user.LastLoginAttempt,
+	}
+
+	lockedUntil := user.LockedUntil()
+	if lockedUntil.IsZero() {
+		// Only update the fields related to login attempts, so that LastLogin isn't reset
+		return false, db.Model(user).Updates(updates).Error
+	}
+
+	user.GenerateUnlockToken()
+	updates["unlock_token"] = user.UnlockToken
+	updates["unlock_token_expiry"] = user.UnlockTokenExpiry
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		detail := fmt.Sprintf("Locked until %s after %d failed sign in attempts", lockedUntil.UTC().Format(time.RFC1123), user.LoginAttempts)
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventAccountLocked, detail, 0)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecordSuccessfulLogin clears the user's failed sign ins and unlock token and
+// sets their last login, once every step of signing in has checked out
+func RecordSuccessfulLogin(db *gorm.DB, user *User) error {
+	user.ResetLoginAttempts()
+	user.UnlockToken = ""
+	user.UnlockTokenExpiry = time.Time{}
+	user.LastLogin = time.Now()
+	return db.Model(user).Updates(map[string]interface{}{
+		"login_attempts":      user.LoginAttempts,
+		"last_login_attempt":  user.LastLoginAttempt,
+		"unlock_token":        user.UnlockToken,
+		"unlock_token_expiry": user.UnlockTokenExpiry,
+		"last_login":          user.LastLogin,
+	}).Error
+}
+
+// UnlockAccount clears the user's failed sign ins and unlock token and records
+// who unlocked the account. actorID is the admin unlocking it, or 0 when the
+// user unlocked it themselves.
+func UnlockAccount(db *gorm.DB, user *User, detail string, actorID uint) error {
+	user.ResetLoginAttempts()
+	user.UnlockToken = ""
+	user.UnlockTokenExpiry = time.Time{}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"login_attempts":      user.LoginAttempts,
+			"last_login_attempt":  user.LastLoginAttempt,
+			"unlock_token":        user.UnlockToken,
+			"unlock_token_expiry": user.UnlockTokenExpiry,
+		}).Error; err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventAccountUnlocked, detail, actorID)
+	})
+}
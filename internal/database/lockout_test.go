@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockoutPolicyDuration(t *testing.T) {
+	policy := LockoutPolicy{Threshold: 5, BaseDuration: 15 * time.Minute, MaxDuration: 2 * time.Hour}
+
+	for attempts, expected := range map[int]time.Duration{
+		0:  0,
+		4:  0,
+		5:  15 * time.Minute,
+		6:  0, // A fresh set of attempts after the lock ends
+		10: 30 * time.Minute,
+		15: time.Hour,
+		20: 2 * time.Hour,
+		50: 2 * time.Hour,
+	} {
+		assert.Equal(t, expected, policy.Duration(attempts), "%d attempts", attempts)
+	}
+}
+
+func TestLockoutPolicyFromEnv(t *testing.T) {
+	t.Setenv("LOCKOUT_THRESHOLD", "3")
+	t.Setenv("LOCKOUT_DURATION", "1m")
+	t.Setenv("LOCKOUT_MAX_DURATION", "")
+	assert.Equal(t, LockoutPolicy{Threshold: 3, BaseDuration: time.Minute, MaxDuration: 24 * time.Hour}, LockoutPolicyFromEnv())
+
+	// Invalid values fall back to the defaults
+	t.Setenv("LOCKOUT_THRESHOLD", "0")
+	t.Setenv("LOCKOUT_DURATION", "soon")
+	assert.Equal(t, DefaultLockoutPolicy, LockoutPolicyFromEnv())
+}
+
+func TestAuthenticateUserLockout(t *testing.T) {
+	SetLockoutPolicy(&LockoutPolicy{Threshold: 3, BaseDuration: 15 * time.Minute, MaxDuration: time.Hour})
+	t.Cleanup(func() { SetLockoutPolicy(nil) })
+
+	db, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	require.NoError(t, db.AutoMigrate(&models.SecurityEvent{}))
+	s := &service{db: db}
+	ctx := context.Background()
+
+	user := &User{Email: "locked@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.Create(user).Error)
+
+	// Fail up to the threshold
+	for i := 0; i < 2; i++ {
+		authenticated, err := s.AuthenticateUser(ctx, user.Email, "wrong")
+		require.NoError(t, err)
+		assert.Nil(t, authenticated)
+	}
+	locked, err := s.AuthenticateUser(ctx, user.Email, "wrong")
+	require.ErrorIs(t, err, ErrUserLocked)
+	require.NotNil(t, locked, "The attempt that locks the account returns the user to email")
+	assert.NotEmpty(t, locked.UnlockToken)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), locked.LockedUntil(), time.Minute)
+
+	// The right password doesn't get into a locked account, and doesn't count as a failure
+	authenticated, err := s.AuthenticateUser(ctx, user.Email, "Password123!")
+	require.ErrorIs(t, err, ErrUserLocked)
+	assert.Nil(t, authenticated)
+	var stored User
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	assert.Equal(t, 3, stored.LoginAttempts)
+
+	// The emailed link unlocks the account once
+	unlocked, err := s.UnlockUserByToken(ctx, locked.UnlockToken)
+	require.NoError(t, err)
+	assert.False(t, unlocked.IsLocked())
+	_, err = s.UnlockUserByToken(ctx, locked.UnlockToken)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	authenticated, err = s.AuthenticateUser(ctx, user.Email, "Password123!")
+	require.NoError(t, err)
+	require.NotNil(t, authenticated)
+
+	// Locking again after the first lock ends lasts twice as long
+	for i := 0; i < 3; i++ {
+		s.AuthenticateUser(ctx, user.Email, "wrong")
+	}
+	require.NoError(t, db.Model(&User{}).Where("id = ?", user.ID).Update("last_login_attempt", time.Now().Add(-20*time.Minute)).Error)
+	for i := 0; i < 2; i++ {
+		_, err := s.AuthenticateUser(ctx, user.Email, "wrong")
+		require.NoError(t, err, "The first lock is over")
+	}
+	locked, err = s.AuthenticateUser(ctx, user.Email, "wrong")
+	require.ErrorIs(t, err, ErrUserLocked)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), locked.LockedUntil(), time.Minute)
+
+	// Admins can unlock it too
+	require.NoError(t, s.UnlockUser(ctx, locked, 42))
+	require.NoError(t, db.First(&stored, user.ID).Error)
+	assert.False(t, stored.IsLocked())
+	assert.Empty(t, stored.UnlockToken)
+
+	events, err := models.FindSecurityEvents(db, user.ID, 10)
+	require.NoError(t, err)
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{
+		models.SecurityEventAccountUnlocked,
+		models.SecurityEventAccountLocked,
+		models.SecurityEventAccountLocked,
+		models.SecurityEventAccountUnlocked,
+		models.SecurityEventAccountLocked,
+	}, types, "Newest first")
+	assert.Equal(t, uint(42), events[0].ActorID)
+	assert.Contains(t, events[1].Detail, "after 6 failed sign in attempts")
+	assert.Equal(t, uint(0), events[3].ActorID)
+}
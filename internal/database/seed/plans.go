@@ -0,0 +1,26 @@
+package seed
+
+import (
+	"log"
+
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// SeedPlans seeds the database with the default subscription plans
+func SeedPlans(db *gorm.DB) {
+	for _, plan := range models.DefaultPlans() {
+		var count int64
+		// Check if the record exists (by Tier)
+		if err := db.Model(&models.Plan{}).Where("tier = ?", plan.Tier).Count(&count).Error; err != nil {
+			log.Printf("Error checking plan %s: %v", plan.Tier, err)
+			continue
+		}
+
+		if count == 0 {
+			if err := db.Create(&plan).Error; err != nil {
+				log.Printf("Error seeding plan %s: %v", plan.Tier, err)
+			}
+		}
+	}
+}
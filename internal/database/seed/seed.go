@@ -88,6 +88,17 @@ func RunSeeds(db *gorm.DB) {
 		log.Printf("Brands table already seeded (count: %d), skipping.", brandCount)
 	}
 
+	// Seed Plans if the table is empty
+	var planCount int64
+	if err := db.Model(&models.Plan{}).Count(&planCount).Error; err != nil {
+		log.Printf("Error checking plans count: %v", err)
+	} else if planCount == 0 {
+		log.Println("Seeding plans...")
+		SeedPlans(db)
+	} else {
+		log.Printf("Plans table already seeded (count: %d), skipping.", planCount)
+	}
+
 	// Add more seed functions here following the same pattern
 
 	log.Println("Individual table seeding checks completed.")
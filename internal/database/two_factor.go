@@ -0,0 +1,169 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/totp"
+	"gorm.io/gorm"
+)
+
+// RecoveryCodeCount is how many recovery codes a user is given at a time
+const RecoveryCodeCount = 10
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+)
+
+// recoveryCodeEncoding writes recovery codes in lowercase letters and digits
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns a new set of random recovery codes, written
+// like "k3m9q-x7d2a"
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		random := make([]byte, 7)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := recoveryCodeEncoding.EncodeToString(random)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// EnableTwoFactor turns on two-factor authentication for the user with a new
+// secret, once they've shown they can generate a code for it. It returns the
+// user's recovery codes, which can't be shown again.
+func EnableTwoFactor(db *gorm.DB, user *User, secret, code string) ([]string, error) {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Updating from the struct, rather than a map, so the secret is encrypted
+		if err := tx.Model(user).Select("TOTPSecret", "TOTPEnabled", "TOTPLastStep").Updates(user).Error; err != nil {
+			return err
+		}
+		if err := models.ReplaceRecoveryCodes(tx, user.ID, codes); err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventTwoFactorEnabled, "Two-factor authentication turned on", 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns off two-factor authentication for the user and removes
+// their secret and recovery codes
+func DisableTwoFactor(db *gorm.DB, user *User) error {
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Select("TOTPSecret", "TOTPEnabled", "TOTPLastStep").Updates(user).Error; err != nil {
+			return err
+		}
+		if err := models.DeleteRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventTwoFactorDisabled, "Two-factor authentication turned off", 0)
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with a new set,
+// so any they have written down stop working
+func RegenerateRecoveryCodes(db *gorm.DB, user *User) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	codes, err := GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := models.ReplaceRecoveryCodes(tx, user.ID, codes); err != nil {
+			return err
+		}
+		return models.RecordSecurityEvent(tx, user.ID, models.SecurityEventRecoveryCodesReset, "New recovery codes generated", 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CheckTwoFactorCode reports whether a code from the user's authenticator, or
+// one of their unused recovery codes, is valid. Each is only accepted once.
+func CheckTwoFactorCode(db *gorm.DB, user *User, code string) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, ErrTwoFactorNotEnabled
+	}
+
+	// Try the code from the authenticator first, then as a recovery code
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		// Check and record the step in one statement, so requests sent together
+		// can't both use the code
+		result := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		if result.RowsAffected != 1 {
+			return false, nil
+		}
+		user.TOTPLastStep = step
+		return true, nil
+	}
+
+	used, err := models.UseRecoveryCode(db, user.ID, code)
+	if err != nil || !used {
+		return false, err
+	}
+	remaining, err := models.CountUnusedRecoveryCodes(db, user.ID)
+	if err != nil {
+		return false, err
+	}
+	detail := fmt.Sprintf("Used a recovery code, %d left", remaining)
+	return true, models.RecordSecurityEvent(db, user.ID, models.SecurityEventRecoveryCodeUsed, detail, 0)
+}
+
+// CompleteTwoFactor finishes a sign in that is waiting on the user's second
+// factor. A valid code signs them in, resetting their failed sign ins; an
+// invalid one counts as a failed sign in, and returns ErrUserLocked if it
+// locked the account.
+func CompleteTwoFactor(db *gorm.DB, user *User, code string) error {
+	ok, err := CheckTwoFactorCode(db, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		locked, err := RecordFailedLogin(db, user)
+		if err != nil {
+			return err
+		}
+		if locked {
+			return ErrUserLocked
+		}
+		return ErrInvalidTwoFactorCode
+	}
+
+	return RecordSuccessfulLogin(db, user)
+}
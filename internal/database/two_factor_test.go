@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/fieldcrypt"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactor(t *testing.T) {
+	SetLockoutPolicy(&LockoutPolicy{Threshold: 3, BaseDuration: 15 * time.Minute, MaxDuration: time.Hour})
+	t.Cleanup(func() { SetLockoutPolicy(nil) })
+
+	db, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	require.NoError(t, db.AutoMigrate(&models.SecurityEvent{}, &models.RecoveryCode{}))
+	s := &service{db: db}
+	ctx := context.Background()
+
+	user := &User{Email: "twofactor@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.Create(user).Error)
+
+	// Turning it on takes a code for the new secret
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	_, err = s.EnableTwoFactor(ctx, user, secret, "000000")
+	require.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	// Use the previous step, so the code for the current one is still unused afterwards
+	step := totp.Step(time.Now())
+	code, _ := totp.Code(secret, step-1)
+	recoveryCodes, err := s.EnableTwoFactor(ctx, user, secret, code)
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, RecoveryCodeCount)
+	count, err := s.CountRecoveryCodes(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(RecoveryCodeCount), count)
+
+	// The secret is encrypted at rest
+	var stored string
+	require.NoError(t, db.Raw("SELECT totp_secret FROM users WHERE id = ?", user.ID).Scan(&stored).Error)
+	assert.True(t, fieldcrypt.IsEncrypted(stored))
+	reloaded, err := s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, secret, reloaded.TOTPSecret)
+	assert.True(t, reloaded.TOTPEnabled)
+
+	// The password alone doesn't finish signing in, so failures keep counting
+	_, err = s.AuthenticateUser(ctx, user.Email, "wrong")
+	require.NoError(t, err)
+	authenticated, err := s.AuthenticateUser(ctx, user.Email, "Password123!")
+	require.NoError(t, err)
+	require.NotNil(t, authenticated)
+	assert.Equal(t, 1, authenticated.LoginAttempts)
+
+	// A code that was already used, here to turn it on, is refused
+	_, err = s.VerifyTwoFactor(ctx, user.ID, code)
+	require.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	code, _ = totp.Code(secret, step)
+	signedIn, err := s.VerifyTwoFactor(ctx, user.ID, code)
+	require.NoError(t, err)
+	assert.Equal(t, 0, signedIn.LoginAttempts)
+	assert.False(t, signedIn.LastLogin.IsZero())
+
+	// Recovery codes work once each, however they're typed
+	signedIn, err = s.VerifyTwoFactor(ctx, user.ID, " "+recoveryCodes[0]+" ")
+	require.NoError(t, err)
+	require.NotNil(t, signedIn)
+	_, err = s.VerifyTwoFactor(ctx, user.ID, recoveryCodes[0])
+	require.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	count, _ = s.CountRecoveryCodes(user.ID)
+	assert.Equal(t, int64(RecoveryCodeCount-1), count)
+
+	// Wrong codes count towards the lockout like wrong passwords
+	_, err = s.VerifyTwoFactor(ctx, user.ID, "nope")
+	require.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	locked, err := s.VerifyTwoFactor(ctx, user.ID, "nope")
+	require.ErrorIs(t, err, ErrUserLocked)
+	require.NotNil(t, locked, "The attempt that locks the account returns the user to email")
+	assert.NotEmpty(t, locked.UnlockToken)
+	signedIn, err = s.VerifyTwoFactor(ctx, user.ID, recoveryCodes[1])
+	require.ErrorIs(t, err, ErrUserLocked)
+	assert.Nil(t, signedIn)
+	require.NoError(t, s.UnlockUser(ctx, locked, 0))
+
+	// New recovery codes replace the old ones
+	newCodes, err := s.RegenerateRecoveryCodes(ctx, reloaded)
+	require.NoError(t, err)
+	_, err = s.VerifyTwoFactor(ctx, user.ID, recoveryCodes[1])
+	require.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+
+	// Turning it off takes a code too
+	require.ErrorIs(t, s.DisableTwoFactor(ctx, reloaded, "000000"), ErrInvalidTwoFactorCode)
+	require.NoError(t, s.DisableTwoFactor(ctx, reloaded, newCodes[0]))
+	reloaded, err = s.GetUserByID(user.ID)
+	require.NoError(t, err)
+	assert.False(t, reloaded.TOTPEnabled)
+	assert.Empty(t, reloaded.TOTPSecret)
+	count, _ = s.CountRecoveryCodes(user.ID)
+	assert.Zero(t, count)
+	_, err = s.RegenerateRecoveryCodes(ctx, reloaded)
+	assert.ErrorIs(t, err, ErrTwoFactorNotEnabled)
+
+	events, err := models.FindSecurityEvents(db, user.ID, 10)
+	require.NoError(t, err)
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{
+		models.SecurityEventTwoFactorDisabled,
+		models.SecurityEventRecoveryCodeUsed,
+		models.SecurityEventRecoveryCodesReset,
+		models.SecurityEventAccountUnlocked,
+		models.SecurityEventAccountLocked,
+		models.SecurityEventRecoveryCodeUsed,
+		models.SecurityEventTwoFactorEnabled,
+	}, types, "Newest first")
+}
+
+func TestCheckTwoFactorCodeOnce(t *testing.T) {
+	db, tempDir := setupTestDB(t)
+	defer os.RemoveAll(tempDir)
+	require.NoError(t, db.AutoMigrate(&models.SecurityEvent{}, &models.RecoveryCode{}))
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	user := &User{Email: "replay@example.com", Password: "Password123!", Verified: true, TOTPSecret: secret, TOTPEnabled: true}
+	require.NoError(t, db.Create(user).Error)
+
+	// Two requests load the user before either checks the same code
+	var first, second User
+	require.NoError(t, db.First(&first, user.ID).Error)
+	require.NoError(t, db.First(&second, user.ID).Error)
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+
+	ok, err := CheckTwoFactorCode(db, &first, code)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = CheckTwoFactorCode(db, &second, code)
+	require.NoError(t, err)
+	assert.False(t, ok, "The code is only accepted once, even by a request that loaded the user before it was used")
+	assert.Zero(t, second.TOTPLastStep)
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, code)
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+	assert.Equal(t, models.HashRecoveryCode(codes[0]), models.HashRecoveryCode(" "+strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
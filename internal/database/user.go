@@ -10,6 +10,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
+	"github.com/hail2skins/armory/internal/models"
 	"github.com/hail2skins/armory/internal/validation"
 )
 
@@ -38,6 +39,12 @@ type User struct {
 	LoginAttempts           int `gorm:"default:0"`
 	LastLoginAttempt        time.Time
 	LastLogin               time.Time // Tracks the last successful login
+	UnlockToken             string    `gorm:"index"` // Emailed when the account locks, to unlock it early
+	UnlockTokenExpiry       time.Time
+	// Two-factor authentication
+	TOTPSecret   string `gorm:"serializer:encrypted"` // Encrypted at rest, see the fieldcrypt package
+	TOTPEnabled  bool   `gorm:"default:false"`
+	TOTPLastStep int64  // The time step of the last code accepted, so a code can't be used twice
 	// Stripe-related fields
 	StripeCustomerID     string
 	StripeSubscriptionID string
@@ -45,6 +52,9 @@ type User struct {
 	SubscriptionStatus   string
 	SubscriptionEndDate  time.Time
 	PromotionID          uint
+	// Failed payment fields, see the dunning package
+	PaymentFailedAt     time.Time // When a failed renewal started the grace period, zero while payments are up to date
+	PaymentReminderSent string    // The grace period status the user was last emailed about
 	// Admin-granted subscription fields
 	GrantedByID    uint   // ID of the admin who granted the subscription
 	GrantReason    string // Reason for granting the subscription
@@ -187,20 +197,18 @@ func (u *User) CheckPassword(password string) error {
 
 // IsLocked returns true if the user's account is locked due to too many failed login attempts
 func (u *User) IsLocked() bool {
-	if u.LoginAttempts >= 5 && time.Since(u.LastLoginAttempt) < 15*time.Minute {
-		return true
-	}
-	return false
+	return !u.LockedUntil().IsZero()
 }
 
 // HasActiveSubscription returns true if the user has an active subscription
 func (u *User) HasActiveSubscription() bool {
-	if u.SubscriptionTier == "free" {
+	plan, isPlan := models.LookupPlan(u.SubscriptionTier)
+	if isPlan && plan.Free() {
 		return false
 	}
 
 	// Lifetime subscriptions are always active
-	if u.SubscriptionTier == "lifetime" || u.SubscriptionTier == "premium_lifetime" {
+	if isPlan && plan.Lifetime() {
 		return true
 	}
 
@@ -212,16 +220,28 @@ func (u *User) HasActiveSubscription() bool {
 	return false
 }
 
+// InPaymentGracePeriod returns true while a failed renewal payment is being
+// retried, before the grace period lapses. The dunning package moves users
+// through these statuses.
+func (u *User) InPaymentGracePeriod() bool {
+	return u.SubscriptionStatus == "past_due" || u.SubscriptionStatus == "unpaid"
+}
+
 // HasStripeManagedSubscription returns true when a user is on a Stripe-backed recurring plan.
 func (u *User) HasStripeManagedSubscription() bool {
 	if u.StripeSubscriptionID == "" {
 		return false
 	}
 
-	if u.SubscriptionTier == "free" || u.SubscriptionTier == "lifetime" || u.SubscriptionTier == "premium_lifetime" {
+	if plan, ok := models.LookupPlan(u.SubscriptionTier); ok && !plan.Recurring() {
 		return false
 	}
 
+	// The card can still be updated while a failed payment is retried
+	if u.InPaymentGracePeriod() {
+		return true
+	}
+
 	if u.SubscriptionStatus != "active" && u.SubscriptionStatus != "pending_cancellation" {
 		return false
 	}
@@ -234,25 +254,20 @@ func (u *User) CanCancelStripeSubscription() bool {
 	return u.HasStripeManagedSubscription() && u.SubscriptionStatus == "active"
 }
 
-// CanSubscribeToTier checks if a user can subscribe to a specific tier based on their current subscription
+// CanSubscribeToTier checks if a user can subscribe to a specific tier based on their current subscription.
+// Users can move up to any plan ranked higher than theirs, and a recurring plan can be left for the free tier.
 func (u *User) CanSubscribeToTier(tier string) bool {
-	// Users can always upgrade to a higher tier
-	switch u.SubscriptionTier {
-	case "free":
-		return true // Free users can subscribe to any tier
-	case "monthly":
-		// Monthly users can upgrade to yearly, lifetime, or premium_lifetime
-		return tier != "monthly"
-	case "yearly":
-		// Yearly users can upgrade to lifetime or premium_lifetime
-		return tier != "monthly" && tier != "yearly"
-	case "lifetime":
-		// Lifetime users can only upgrade to premium_lifetime
-		return tier == "premium_lifetime"
-	case "premium_lifetime":
-		// Premium lifetime users cannot subscribe to any other tier
+	current, ok := models.LookupPlan(u.SubscriptionTier)
+	if !ok || current.Free() {
+		return true // Free users, and those on promotions or grants, can subscribe to any tier
+	}
+
+	target, ok := models.LookupPlan(tier)
+	if !ok {
 		return false
-	default:
-		return true
 	}
+	if target.Free() {
+		return !current.Lifetime()
+	}
+	return target.Rank > current.Rank
 }
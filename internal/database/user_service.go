@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/hail2skins/armory/internal/models"
 	"gorm.io/gorm"
 )
 
@@ -16,9 +17,38 @@ type UserService interface {
 	// GetUserByEmail retrieves a user by their email
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 
-	// AuthenticateUser authenticates a user with the given email and password
+	// AuthenticateUser authenticates a user with the given email and password.
+	// It returns ErrUserLocked for a locked account; when this attempt is the one
+	// that locked it, the user is returned too so their unlock link can be emailed.
 	AuthenticateUser(ctx context.Context, email, password string) (*User, error)
 
+	// UnlockUserByToken unlocks the account with the given emailed unlock token
+	UnlockUserByToken(ctx context.Context, token string) (*User, error)
+
+	// UnlockUser unlocks a user's account on behalf of an admin
+	UnlockUser(ctx context.Context, user *User, adminID uint) error
+
+	// FindSecurityEvents returns a user's most recent security events, newest first
+	FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error)
+
+	// EnableTwoFactor turns on two-factor authentication with a new secret once the
+	// code for it checks out, returning the user's recovery codes
+	EnableTwoFactor(ctx context.Context, user *User, secret, code string) ([]string, error)
+
+	// VerifyTwoFactor finishes signing in a user whose password checked out with a
+	// code from their authenticator or a recovery code. Like AuthenticateUser, it
+	// returns ErrUserLocked with the user when this attempt locked the account.
+	VerifyTwoFactor(ctx context.Context, userID uint, code string) (*User, error)
+
+	// DisableTwoFactor turns off two-factor authentication once the code checks out
+	DisableTwoFactor(ctx context.Context, user *User, code string) error
+
+	// RegenerateRecoveryCodes replaces a user's recovery codes with a new set
+	RegenerateRecoveryCodes(ctx context.Context, user *User) ([]string, error)
+
+	// CountRecoveryCodes returns how many unused recovery codes a user has left
+	CountRecoveryCodes(userID uint) (int64, error)
+
 	// VerifyUserEmail verifies a user's email with the given token
 	VerifyUserEmail(ctx context.Context, token string) (*User, error)
 
@@ -122,26 +152,35 @@ func (s *service) AuthenticateUser(ctx context.Context, email, password string)
 		return nil, nil
 	}
 
+	// A locked account can't sign in, even with the right password
+	if user.IsLocked() {
+		return nil, ErrUserLocked
+	}
+
 	// Check the password
 	if !CheckPassword(password, user.Password) {
-		// On failed login attempts, increment the counter
-		user.IncrementLoginAttempts()
-
-		// Only update the specific fields related to login attempts
-		// This avoids resetting the LastLogin field
-		if err := s.db.WithContext(ctx).Model(user).
-			Updates(map[string]interface{}{
-				"login_attempts":     user.LoginAttempts,
-				"last_login_attempt": user.LastLoginAttempt,
-			}).Error; err != nil {
+		// On failed login attempts, increment the counter, locking the account at the threshold
+		locked, err := RecordFailedLogin(s.db.WithContext(ctx), user)
+		if err != nil {
 			return nil, err
 		}
+		if locked {
+			return user, ErrUserLocked
+		}
 
 		return nil, nil // Password doesn't match
 	}
 
+	// With two-factor authentication the sign in isn't finished until the second
+	// step, so failed attempts keep counting until VerifyTwoFactor
+	if user.TOTPEnabled {
+		return user, nil
+	}
+
 	// On successful login, reset the login attempts counter and update last login time
 	user.ResetLoginAttempts()
+	user.UnlockToken = ""
+	user.UnlockTokenExpiry = time.Time{}
 	user.LastLogin = time.Now()
 	if err := s.UpdateUser(ctx, user); err != nil {
 		return nil, err
@@ -150,6 +189,91 @@ func (s *service) AuthenticateUser(ctx context.Context, email, password string)
 	return user, nil
 }
 
+// UnlockUserByToken unlocks the account with the given emailed unlock token. The
+// token works once; it returns ErrInvalidToken for an unknown or used token.
+func (s *service) UnlockUserByToken(ctx context.Context, token string) (*User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	var user User
+	if err := s.db.WithContext(ctx).Where("unlock_token = ?", token).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if user.IsUnlockExpired() {
+		return nil, ErrTokenExpired
+	}
+
+	if err := UnlockAccount(s.db.WithContext(ctx), &user, "Unlocked with the emailed unlock link", 0); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UnlockUser unlocks a user's account on behalf of an admin
+func (s *service) UnlockUser(ctx context.Context, user *User, adminID uint) error {
+	return UnlockAccount(s.db.WithContext(ctx), user, "Unlocked by an administrator", adminID)
+}
+
+// FindSecurityEvents returns a user's most recent security events, newest first
+func (s *service) FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error) {
+	return models.FindSecurityEvents(s.db, userID, limit)
+}
+
+// EnableTwoFactor turns on two-factor authentication with a new secret once the
+// code for it checks out, returning the user's recovery codes
+func (s *service) EnableTwoFactor(ctx context.Context, user *User, secret, code string) ([]string, error) {
+	return EnableTwoFactor(s.db.WithContext(ctx), user, secret, code)
+}
+
+// VerifyTwoFactor finishes signing in a user whose password checked out
+func (s *service) VerifyTwoFactor(ctx context.Context, userID uint, code string) (*User, error) {
+	var user User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	// A locked account can't sign in, even with the right code
+	if user.IsLocked() {
+		return nil, ErrUserLocked
+	}
+
+	if err := CompleteTwoFactor(s.db.WithContext(ctx), &user, code); err != nil {
+		if errors.Is(err, ErrUserLocked) {
+			return &user, err
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DisableTwoFactor turns off two-factor authentication once the code checks out
+func (s *service) DisableTwoFactor(ctx context.Context, user *User, code string) error {
+	ok, err := CheckTwoFactorCode(s.db.WithContext(ctx), user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	return DisableTwoFactor(s.db.WithContext(ctx), user)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes with a new set
+func (s *service) RegenerateRecoveryCodes(ctx context.Context, user *User) ([]string, error) {
+	return RegenerateRecoveryCodes(s.db.WithContext(ctx), user)
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (s *service) CountRecoveryCodes(userID uint) (int64, error) {
+	return models.CountUnusedRecoveryCodes(s.db, userID)
+}
+
 // GetUserByVerificationToken retrieves a user by their verification token
 func (s *service) GetUserByVerificationToken(ctx context.Context, token string) (*User, error) {
 	var user User
@@ -301,7 +425,7 @@ func (s *service) CountActiveSubscribers() (int64, error) {
 	// This counts only paid subscribers
 	err := s.db.Model(&User{}).
 		Where("subscription_status = ? AND is_admin_granted = ? AND subscription_tier != ?",
-			"active", false, "free").
+			"active", false, models.FreeTier).
 		Count(&count).Error
 
 	return count, err
@@ -373,7 +497,7 @@ func (s *service) CountNewSubscribersThisMonth() (int64, error) {
 	// Count users who got a subscription this month (not admin granted)
 	err := s.db.Model(&User{}).
 		Where("subscription_status = ? AND is_admin_granted = ? AND updated_at >= ? AND subscription_tier != ?",
-			"active", false, firstDay, "free").
+			"active", false, firstDay, models.FreeTier).
 		Count(&count).Error
 
 	return count, err
@@ -392,7 +516,7 @@ func (s *service) CountNewSubscribersLastMonth() (int64, error) {
 	// Count users who got a subscription last month (not admin granted)
 	err := s.db.Model(&User{}).
 		Where("subscription_status = ? AND is_admin_granted = ? AND updated_at >= ? AND updated_at < ? AND subscription_tier != ?",
-			"active", false, firstDayLastMonth, firstDayThisMonth, "free").
+			"active", false, firstDayLastMonth, firstDayThisMonth, models.FreeTier).
 		Count(&count).Error
 
 	return count, err
@@ -404,7 +528,7 @@ func (s *service) CountNewSubscribersLastMonth() (int64, error) {
 // Returns true if the subscription status was updated, false otherwise.
 func (s *service) CheckExpiredPromotionSubscription(user *User) (bool, error) {
 	// If no subscription tier, already expired or free tier, nothing to do
-	if user.SubscriptionTier == "" || user.SubscriptionStatus == "expired" || user.SubscriptionTier == "free" {
+	if user.SubscriptionTier == "" || user.SubscriptionStatus == "expired" || user.SubscriptionTier == models.FreeTier {
 		return false, nil
 	}
 
@@ -412,7 +536,7 @@ func (s *service) CheckExpiredPromotionSubscription(user *User) (bool, error) {
 	if !user.SubscriptionEndDate.IsZero() && time.Now().After(user.SubscriptionEndDate) {
 		// Update subscription status to expired, reset tier to free, and clear end date
 		user.SubscriptionStatus = "expired"
-		user.SubscriptionTier = "free"
+		user.SubscriptionTier = models.FreeTier
 		user.SubscriptionEndDate = time.Time{} // zero time
 
 		// Save the updated user - use Updates to only update changed fields
@@ -442,6 +442,16 @@ func TestHasStripeManagedSubscription(t *testing.T) {
 			},
 			expectedResult: false,
 		},
+		{
+			name: "Past due subscription in its grace period",
+			user: User{
+				SubscriptionTier:     "monthly",
+				SubscriptionStatus:   "past_due",
+				SubscriptionEndDate:  pastDate,
+				StripeSubscriptionID: "sub_123",
+			},
+			expectedResult: true,
+		},
 	}
 
 	for _, tt := range tests {
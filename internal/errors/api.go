@@ -0,0 +1,104 @@
+package errors
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/logger"
+)
+
+// APIPrefix is where the versioned JSON API is served. Its errors always use
+// the APIError envelope, whatever the request's Accept header says.
+const APIPrefix = "/api/v1"
+
+// API error codes. Clients can rely on these; the messages may change.
+const (
+	APICodeBadRequest           = "bad_request"
+	APICodeValidation           = "validation_failed"
+	APICodeUnauthorized         = "unauthorized"
+	APICodeInsufficientScope    = "insufficient_scope"
+	APICodeForbidden            = "forbidden"
+	APICodeNotFound             = "not_found"
+	APICodeMethodNotAllowed     = "method_not_allowed"
+	APICodeSubscriptionRequired = "subscription_required"
+	APICodeRateLimited          = "rate_limited"
+	APICodeInternal             = "internal_error"
+)
+
+// APIError is the envelope every error from the versioned API comes in:
+//
+//	{"error": {"status": 404, "code": "not_found", "message": "Gun not found"}}
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+// APIErrorBody describes what went wrong with an API request
+type APIErrorBody struct {
+	Status  int               `json:"status"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"` // Problems with the request body, by field
+	ID      string            `json:"id,omitempty"`     // For tracking internal errors in the logs
+}
+
+// IsAPIRequest reports whether the request is for the versioned API
+func IsAPIRequest(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	return path == APIPrefix || strings.HasPrefix(path, APIPrefix+"/")
+}
+
+// AbortWithAPIError stops the request with an error in the API's envelope
+func AbortWithAPIError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, APIError{Error: APIErrorBody{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}})
+}
+
+// AbortWithAPIValidationError stops the request with the problems found in its body
+func AbortWithAPIValidationError(c *gin.Context, fields map[string]string) {
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, APIError{Error: APIErrorBody{
+		Status:  http.StatusUnprocessableEntity,
+		Code:    APICodeValidation,
+		Message: "The request has invalid fields",
+		Fields:  fields,
+	}})
+}
+
+// AbortWithAPIInternalError logs an unexpected error and stops the request,
+// giving the client an ID to quote that finds it in the logs
+func AbortWithAPIInternalError(c *gin.Context, err error) {
+	id := generateErrorID()
+	logger.Error("Internal API error", err, map[string]interface{}{
+		"error_id": id,
+		"path":     c.Request.URL.Path,
+	})
+	c.AbortWithStatusJSON(http.StatusInternalServerError, APIError{Error: APIErrorBody{
+		Status:  http.StatusInternalServerError,
+		Code:    APICodeInternal,
+		Message: "An internal error occurred",
+		ID:      id,
+	}})
+}
+
+// handleAPIError responds to an error from an API request with the API's envelope
+func handleAPIError(c *gin.Context, err error) {
+	switch e := err.(type) {
+	case *ValidationError:
+		AbortWithAPIError(c, http.StatusBadRequest, APICodeBadRequest, e.Error())
+	case *AuthError:
+		AbortWithAPIError(c, http.StatusUnauthorized, APICodeUnauthorized, e.Error())
+	case *ForbiddenError:
+		AbortWithAPIError(c, http.StatusForbidden, APICodeForbidden, e.Error())
+	case *NotFoundError:
+		AbortWithAPIError(c, http.StatusNotFound, APICodeNotFound, e.Error())
+	case *PaymentError:
+		AbortWithAPIError(c, http.StatusBadRequest, APICodeBadRequest, e.Error())
+	case *RateLimitError:
+		AbortWithAPIError(c, http.StatusTooManyRequests, APICodeRateLimited, e.Error())
+	default:
+		AbortWithAPIInternalError(c, err)
+	}
+}
@@ -17,6 +17,11 @@ type ErrorResponse struct {
 }
 
 func HandleError(c *gin.Context, err error) {
+	if IsAPIRequest(c) {
+		handleAPIError(c, err)
+		return
+	}
+
 	var response ErrorResponse
 
 	switch e := err.(type) {
@@ -139,6 +144,11 @@ func NoRouteHandler() gin.HandlerFunc {
 			"method": c.Request.Method,
 		})
 
+		if IsAPIRequest(c) {
+			AbortWithAPIError(c, http.StatusNotFound, APICodeNotFound, "Not found")
+			return
+		}
+
 		// In test mode or if JSON requested, return JSON
 		if gin.Mode() == gin.TestMode || strings.Contains(c.GetHeader("Accept"), "application/json") {
 			c.JSON(http.StatusNotFound, ErrorResponse{
@@ -172,6 +182,11 @@ func NoMethodHandler() gin.HandlerFunc {
 			"method": c.Request.Method,
 		})
 
+		if IsAPIRequest(c) {
+			AbortWithAPIError(c, http.StatusMethodNotAllowed, APICodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
 		// In test mode or if JSON requested, return JSON
 		if gin.Mode() == gin.TestMode || strings.Contains(c.GetHeader("Accept"), "application/json") {
 			c.JSON(http.StatusMethodNotAllowed, ErrorResponse{
@@ -210,6 +225,16 @@ func RecoveryHandler() gin.HandlerFunc {
 			"recovered": recovered,
 		})
 
+		if IsAPIRequest(c) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, APIError{Error: APIErrorBody{
+				Status:  http.StatusInternalServerError,
+				Code:    APICodeInternal,
+				Message: "An internal server error occurred",
+				ID:      errorID,
+			}})
+			return
+		}
+
 		// In test mode or if JSON requested, return JSON
 		if gin.Mode() == gin.TestMode || strings.Contains(c.GetHeader("Accept"), "application/json") {
 			c.JSON(http.StatusInternalServerError, ErrorResponse{
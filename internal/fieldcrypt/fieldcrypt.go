@@ -0,0 +1,292 @@
+// Package fieldcrypt encrypts sensitive database columns, such as gun serial
+// numbers, at rest.
+//
+// Each value is sealed with its own random data key, and the data key is
+// sealed with a key encryption key from the environment (envelope
+// encryption). Values record which key encryption key sealed them, so keys
+// can be rotated: new values use the primary key while values sealed with
+// older keys still open until they're re-encrypted.
+//
+// Encrypted values can't be searched, so a keyed hash of the normalized
+// plaintext (a blind index) is stored alongside them for exact matches.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrUnknownKey is returned when a value was sealed with a key that isn't in the keyring
+	ErrUnknownKey = errors.New("encrypted with an unknown key")
+	// ErrMalformed is returned for values that look encrypted but can't be parsed or opened
+	ErrMalformed = errors.New("malformed encrypted value")
+	// ErrKeysNotConfigured is returned when neither FIELD_ENCRYPTION_KEYS nor FIELD_INDEX_KEY is set
+	ErrKeysNotConfigured = errors.New("FIELD_ENCRYPTION_KEYS and FIELD_INDEX_KEY are not set")
+)
+
+const (
+	// prefix marks encrypted values, telling them apart from plaintext written before encryption was turned on
+	prefix = "enc:v1:"
+	// keySize is the length of key encryption keys, data keys and the index key (AES-256)
+	keySize = 32
+)
+
+// Keyring holds the key encryption keys and the blind index key
+type Keyring struct {
+	primary  string
+	keys     map[string][]byte
+	indexKey []byte
+}
+
+// NewKeyring returns a keyring that seals new values with the key named primary.
+// Every key, including indexKey, must be 32 bytes.
+func NewKeyring(primary string, keys map[string][]byte, indexKey []byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyring", primary)
+	}
+	for id, key := range keys {
+		if id == "" || strings.ContainsAny(id, ":,") {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key %q must be %d bytes, got %d", id, keySize, len(key))
+		}
+	}
+	if len(indexKey) != keySize {
+		return nil, fmt.Errorf("index key must be %d bytes, got %d", keySize, len(indexKey))
+	}
+	return &Keyring{primary: primary, keys: keys, indexKey: indexKey}, nil
+}
+
+// ParseKeys parses a comma separated list of "id:base64key" pairs. The first
+// key listed is the primary key.
+func ParseKeys(s string) (string, map[string][]byte, error) {
+	var primary string
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return "", nil, fmt.Errorf("key %q should look like id:base64key", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return "", nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+		if _, dup := keys[id]; dup {
+			return "", nil, fmt.Errorf("key %q is listed twice", id)
+		}
+		keys[id] = key
+		if primary == "" {
+			primary = id
+		}
+	}
+	if primary == "" {
+		return "", nil, errors.New("no keys listed")
+	}
+	return primary, keys, nil
+}
+
+// FromEnv builds a keyring from FIELD_ENCRYPTION_KEYS and FIELD_INDEX_KEY.
+// Both must be set; ErrKeysNotConfigured is returned when neither is.
+func FromEnv() (*Keyring, error) {
+	keyList := os.Getenv("FIELD_ENCRYPTION_KEYS")
+	indexKey := os.Getenv("FIELD_INDEX_KEY")
+
+	if keyList == "" && indexKey == "" {
+		return nil, ErrKeysNotConfigured
+	}
+	if keyList == "" || indexKey == "" {
+		return nil, errors.New("FIELD_ENCRYPTION_KEYS and FIELD_INDEX_KEY must be set together")
+	}
+
+	primary, keys, err := ParseKeys(keyList)
+	if err != nil {
+		return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS: %w", err)
+	}
+	index, err := base64.StdEncoding.DecodeString(indexKey)
+	if err != nil {
+		return nil, fmt.Errorf("FIELD_INDEX_KEY is not valid base64: %w", err)
+	}
+	return NewKeyring(primary, keys, index)
+}
+
+var (
+	defaultMu      sync.Mutex
+	defaultKeyring *Keyring
+)
+
+// Default returns the keyring configured by the environment, loading it on
+// first use. The server loads it at startup so missing keys stop it there.
+func Default() (*Keyring, error) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultKeyring == nil {
+		keyring, err := FromEnv()
+		if err != nil {
+			return nil, err
+		}
+		defaultKeyring = keyring
+	}
+	return defaultKeyring, nil
+}
+
+// SetDefault replaces the keyring returned by Default. A nil keyring is
+// reloaded from the environment on next use.
+func SetDefault(keyring *Keyring) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultKeyring = keyring
+}
+
+// Primary returns the id of the key new values are sealed with
+func (k *Keyring) Primary() string {
+	return k.primary
+}
+
+// Encrypt seals plaintext under a new data key. Empty values stay empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.primary + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedKey) + ":" +
+		base64.RawStdEncoding.EncodeToString(sealedValue), nil
+}
+
+// Decrypt opens a value sealed by Encrypt. Values without the encrypted
+// prefix are plaintext stored before encryption and are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+	sealedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	sealedValue, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(kek, sealedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, sealedValue)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether a stored value should be re-encrypted, because
+// it's plaintext or was sealed with a key other than the primary key
+func (k *Keyring) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return id != k.primary
+}
+
+// BlindIndex returns a keyed hash of a value for exact match lookups. Values
+// are compared ignoring case and surrounding space; empty values have no index.
+func (k *Keyring) BlindIndex(value string) string {
+	value = Normalize(value)
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted reports whether a stored value was sealed by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Normalize returns the form of a value its blind index is computed from
+func Normalize(value string) string {
+	return strings.ToUpper(strings.TrimSpace(value))
+}
+
+// seal encrypts plaintext with AES-256-GCM, prefixing the random nonce
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts a nonce prefixed value from seal
+func open(key []byte, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return plaintext, nil
+}
+
+// newGCM returns an AES-GCM cipher for a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,153 @@
+package fieldcrypt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKeyring(t *testing.T, primary string, ids ...string) *Keyring {
+	keys := map[string][]byte{}
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[:1]), keySize)
+	}
+	keyring, err := NewKeyring(primary, keys, bytes.Repeat([]byte("i"), keySize))
+	require.NoError(t, err)
+	return keyring
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring := testKeyring(t, "a", "a")
+
+	sealed, err := keyring.Encrypt("SN-12345")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+	assert.NotContains(t, sealed, "SN-12345")
+
+	again, err := keyring.Encrypt("SN-12345")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again, "Each value gets its own data key and nonce")
+
+	plaintext, err := keyring.Decrypt(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "SN-12345", plaintext)
+
+	// Plaintext from before encryption reads back as it is
+	plaintext, err = keyring.Decrypt("SN-LEGACY")
+	require.NoError(t, err)
+	assert.Equal(t, "SN-LEGACY", plaintext)
+
+	empty, err := keyring.Encrypt("")
+	require.NoError(t, err)
+	assert.Equal(t, "", empty)
+
+	// Tampering is caught
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+	_, err = keyring.Decrypt(tampered)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := testKeyring(t, "a", "a")
+	sealed, err := old.Encrypt("SN-1")
+	require.NoError(t, err)
+
+	rotated := testKeyring(t, "b", "a", "b")
+	plaintext, err := rotated.Decrypt(sealed)
+	require.NoError(t, err, "Values sealed with an older key still open")
+	assert.Equal(t, "SN-1", plaintext)
+	assert.True(t, rotated.NeedsRotation(sealed))
+	assert.True(t, rotated.NeedsRotation("SN-PLAINTEXT"))
+	assert.False(t, rotated.NeedsRotation(""))
+
+	resealed, err := rotated.Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(resealed, prefix+"b:"))
+	assert.False(t, rotated.NeedsRotation(resealed))
+
+	// Once the old key is dropped its values can't be opened
+	_, err = testKeyring(t, "b", "b").Decrypt(sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestBlindIndex(t *testing.T) {
+	keyring := testKeyring(t, "a", "a")
+
+	index := keyring.BlindIndex("sn-123")
+	assert.Len(t, index, 64)
+	assert.Equal(t, index, keyring.BlindIndex("  SN-123 "), "Case and surrounding space are ignored")
+	assert.NotEqual(t, index, keyring.BlindIndex("SN-124"))
+	assert.Equal(t, "", keyring.BlindIndex("  "))
+
+	// The index depends on the index key, not the encryption keys
+	assert.Equal(t, index, testKeyring(t, "b", "b").BlindIndex("SN-123"))
+	other, err := NewKeyring("a", map[string][]byte{"a": bytes.Repeat([]byte("a"), keySize)}, bytes.Repeat([]byte("j"), keySize))
+	require.NoError(t, err)
+	assert.NotEqual(t, index, other.BlindIndex("SN-123"))
+}
+
+func TestParseKeys(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("1"), keySize))
+	k2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("2"), keySize))
+
+	primary, keys, err := ParseKeys("2:" + k2 + ", 1:" + k1)
+	require.NoError(t, err)
+	assert.Equal(t, "2", primary)
+	assert.Len(t, keys, 2)
+
+	for _, bad := range []string{"", "nokey", "1:not base64!", "1:" + k1 + ",1:" + k2} {
+		_, _, err := ParseKeys(bad)
+		assert.Error(t, err, bad)
+	}
+
+	_, err = NewKeyring("1", map[string][]byte{"1": []byte("short")}, bytes.Repeat([]byte("i"), keySize))
+	assert.Error(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	k1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("1"), keySize))
+
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "")
+	t.Setenv("FIELD_INDEX_KEY", "")
+	_, err := FromEnv()
+	assert.ErrorIs(t, err, ErrKeysNotConfigured, "There are no development keys to fall back to")
+
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "1:"+k1)
+	_, err = FromEnv()
+	assert.Error(t, err, "Both variables must be set")
+
+	t.Setenv("FIELD_INDEX_KEY", k1)
+	keyring, err := FromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "1", keyring.Primary())
+}
+
+func TestDefault(t *testing.T) {
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "")
+	t.Setenv("FIELD_INDEX_KEY", "")
+	SetDefault(nil)
+	t.Cleanup(func() { SetDefault(nil) })
+
+	_, err := Default()
+	require.ErrorIs(t, err, ErrKeysNotConfigured, "Test binaries need keys like any other")
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("1"), keySize))
+	t.Setenv("FIELD_ENCRYPTION_KEYS", "1:"+key)
+	t.Setenv("FIELD_INDEX_KEY", key)
+	keyring, err := Default()
+	require.NoError(t, err)
+	assert.Equal(t, "1", keyring.Primary())
+
+	set := testKeyring(t, "a", "a")
+	SetDefault(set)
+	keyring, err = Default()
+	require.NoError(t, err)
+	assert.Same(t, set, keyring)
+}
@@ -0,0 +1,61 @@
+package fieldcrypt
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+func init() {
+	schema.RegisterSerializer("encrypted", Serializer{})
+}
+
+// Serializer encrypts string columns tagged `gorm:"serializer:encrypted"` with
+// the default keyring as they're written, and decrypts them as they're read.
+// Values written through a map, e.g. db.Updates(map[string]interface{}{...}),
+// skip serializers and must be encrypted by the caller.
+type Serializer struct{}
+
+// Scan decrypts a column into its field
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("fieldcrypt: can't decrypt %T into %s", dbValue, field.Name)
+	}
+
+	keyring, err := Default()
+	if err != nil {
+		return err
+	}
+	plaintext, err := keyring.Decrypt(stored)
+	if err != nil {
+		return fmt.Errorf("fieldcrypt: %s: %w", field.Name, err)
+	}
+	return field.Set(ctx, dst, plaintext)
+}
+
+// Value encrypts a field for its column. Values that are already encrypted are
+// written unchanged.
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("fieldcrypt: %s must be a string to be encrypted", field.Name)
+	}
+	if IsEncrypted(plaintext) {
+		return plaintext, nil
+	}
+
+	keyring, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Encrypt(plaintext)
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+	"github.com/shaj13/go-guardian/v2/auth"
+)
+
+// currentUserProvider is the part of the auth controller RequireAllowance needs
+type currentUserProvider interface {
+	GetCurrentUser(c *gin.Context) (auth.Info, bool)
+}
+
+// RequireAllowance stops signed in owners reaching a page that adds a
+// resource once their entitlements don't allow another, sending them to the
+// pricing page with the same explanation the owner pages give. Their
+// allowance is put in the context under "allowance" for the handler.
+//
+// Requests without a signed in user are passed on, so the handler's own
+// authentication check still applies.
+func RequireAllowance(authService currentUserProvider, db database.Service, resource entitlements.Resource) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userInfo, authenticated := authService.GetCurrentUser(c)
+		if !authenticated || userInfo == nil {
+			c.Next()
+			return
+		}
+
+		user, err := db.GetUserByEmail(c.Request.Context(), userInfo.GetUserName())
+		if err != nil || user == nil {
+			c.Next()
+			return
+		}
+
+		allowance, err := entitlements.NewService(db.GetDB()).Check(user, resource)
+		if err != nil {
+			logger.Error("Failed to check entitlements", err, map[string]interface{}{
+				"user_id":  user.ID,
+				"resource": resource,
+				"path":     c.Request.URL.Path,
+			})
+			c.Next()
+			return
+		}
+		c.Set("allowance", allowance)
+
+		if !allowance.CanAdd() {
+			logger.Info("Entitlements don't allow another", map[string]interface{}{
+				"user_id":  user.ID,
+				"resource": resource,
+				"limit":    allowance.Limit,
+				"used":     allowance.Used,
+			})
+			if setFlash, exists := c.Get("setFlash"); exists {
+				setFlash.(func(string))(allowance.DeniedMessage())
+			}
+			c.Redirect(http.StatusSeeOther, "/pricing")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/errors"
 	"github.com/hail2skins/armory/internal/logger"
 )
 
@@ -20,6 +21,12 @@ func SetupErrorHandlers(router *gin.Engine) {
 			"method": c.Request.Method,
 		})
 
+		// The versioned API answers in its own error envelope
+		if errors.IsAPIRequest(c) {
+			errors.AbortWithAPIError(c, http.StatusNotFound, errors.APICodeNotFound, "Not found")
+			return
+		}
+
 		// Check if this is an API or JSON request
 		if c.GetHeader("Accept") == "application/json" {
 			c.JSON(http.StatusNotFound, gin.H{
@@ -40,6 +47,11 @@ func SetupErrorHandlers(router *gin.Engine) {
 			"method": c.Request.Method,
 		})
 
+		if errors.IsAPIRequest(c) {
+			errors.AbortWithAPIError(c, http.StatusMethodNotAllowed, errors.APICodeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
 		// Check if this is an API or JSON request
 		if c.GetHeader("Accept") == "application/json" {
 			c.JSON(http.StatusMethodNotAllowed, gin.H{
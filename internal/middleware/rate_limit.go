@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,7 +19,6 @@ type RateLimitStats struct {
 	PersistentAbusers map[string]time.Time        // Track IPs that consistently abuse limits
 	RecentBlocks      []RateLimitBlock            // Recent blocks for monitoring
 	EndpointStats     map[string]EndpointRateInfo // Stats per endpoint
-	mu                sync.Mutex
 }
 
 // RateLimitBlock represents a single rate limit block event
@@ -38,118 +36,95 @@ type EndpointRateInfo struct {
 	LastBlock       time.Time
 }
 
-// Global stats tracker
-var rateLimitStats = RateLimitStats{
-	OffenderAttempts:  make(map[string]int),
-	PersistentAbusers: make(map[string]time.Time),
-	RecentBlocks:      make([]RateLimitBlock, 0, 100), // Keep last 100 blocks
-	EndpointStats:     make(map[string]EndpointRateInfo),
-}
-
-// GetRateLimitStats returns a copy of the current rate limit statistics
-func GetRateLimitStats() RateLimitStats {
-	rateLimitStats.mu.Lock()
-	defer rateLimitStats.mu.Unlock()
-
-	// Return a copy to prevent concurrent modification
-	stats := RateLimitStats{
-		TotalAttempts:     rateLimitStats.TotalAttempts,
-		BlockedAttempts:   rateLimitStats.BlockedAttempts,
-		OffenderAttempts:  make(map[string]int, len(rateLimitStats.OffenderAttempts)),
-		PersistentAbusers: make(map[string]time.Time, len(rateLimitStats.PersistentAbusers)),
-		RecentBlocks:      make([]RateLimitBlock, len(rateLimitStats.RecentBlocks)),
-		EndpointStats:     make(map[string]EndpointRateInfo, len(rateLimitStats.EndpointStats)),
-	}
+// persistentAbuserBlocks is how many times an IP is blocked before it's considered a persistent abuser
+const persistentAbuserBlocks = 10
 
-	// Copy maps and slices to prevent concurrent modification
-	for k, v := range rateLimitStats.OffenderAttempts {
-		stats.OffenderAttempts[k] = v
-	}
+// recentBlocksKept is how many of the most recent blocks the statistics keep
+const recentBlocksKept = 100
 
-	for k, v := range rateLimitStats.PersistentAbusers {
-		stats.PersistentAbusers[k] = v
+// newRateLimitStats returns empty statistics
+func newRateLimitStats() RateLimitStats {
+	return RateLimitStats{
+		OffenderAttempts:  make(map[string]int),
+		PersistentAbusers: make(map[string]time.Time),
+		RecentBlocks:      make([]RateLimitBlock, 0, recentBlocksKept),
+		EndpointStats:     make(map[string]EndpointRateInfo),
 	}
-
-	copy(stats.RecentBlocks, rateLimitStats.RecentBlocks)
-
-	for k, v := range rateLimitStats.EndpointStats {
-		stats.EndpointStats[k] = v
-	}
-
-	return stats
 }
 
-// ResetRateLimitStats resets all rate limiting statistics
-func ResetRateLimitStats() {
-	rateLimitStats.mu.Lock()
-	defer rateLimitStats.mu.Unlock()
-
-	rateLimitStats.TotalAttempts = 0
-	rateLimitStats.BlockedAttempts = 0
-	rateLimitStats.OffenderAttempts = make(map[string]int)
-	rateLimitStats.PersistentAbusers = make(map[string]time.Time)
-	rateLimitStats.RecentBlocks = make([]RateLimitBlock, 0, 100)
-	rateLimitStats.EndpointStats = make(map[string]EndpointRateInfo)
-}
-
-// track records an attempt in the statistics
-func track(clientIP, path string, blocked bool, userAgent string) {
-	rateLimitStats.mu.Lock()
-	defer rateLimitStats.mu.Unlock()
-
+// record adds an attempt to the statistics
+func (s *RateLimitStats) record(attempt RateLimitBlock, blocked bool) {
 	// Track total attempts
-	rateLimitStats.TotalAttempts++
+	s.TotalAttempts++
 
 	// Update endpoint stats
-	info, exists := rateLimitStats.EndpointStats[path]
-	if !exists {
-		info = EndpointRateInfo{}
-	}
+	info := s.EndpointStats[attempt.Path]
 	info.TotalAttempts++
 
 	// If blocked, record more detailed information
 	if blocked {
 		// Track blocked attempts
-		rateLimitStats.BlockedAttempts++
+		s.BlockedAttempts++
 		info.BlockedAttempts++
-		info.LastBlock = time.Now()
+		info.LastBlock = attempt.Timestamp
 
 		// Track offender
-		rateLimitStats.OffenderAttempts[clientIP]++
+		s.OffenderAttempts[attempt.IP]++
 
-		// If this IP has been blocked more than 10 times, consider it a persistent abuser
-		if rateLimitStats.OffenderAttempts[clientIP] > 10 {
-			rateLimitStats.PersistentAbusers[clientIP] = time.Now()
+		// If this IP has been blocked too often, consider it a persistent abuser
+		if s.OffenderAttempts[attempt.IP] > persistentAbuserBlocks {
+			s.PersistentAbusers[attempt.IP] = attempt.Timestamp
 		}
 
-		// Record this block in recent blocks
-		block := RateLimitBlock{
-			IP:        clientIP,
-			Path:      path,
-			Timestamp: time.Now(),
-			UserAgent: userAgent,
-		}
-
-		// Keep last 100 blocks
-		if len(rateLimitStats.RecentBlocks) >= 100 {
-			rateLimitStats.RecentBlocks = append(rateLimitStats.RecentBlocks[1:], block)
+		// Keep the most recent blocks
+		if len(s.RecentBlocks) >= recentBlocksKept {
+			s.RecentBlocks = append(s.RecentBlocks[1:], attempt)
 		} else {
-			rateLimitStats.RecentBlocks = append(rateLimitStats.RecentBlocks, block)
+			s.RecentBlocks = append(s.RecentBlocks, attempt)
 		}
 	}
 
-	// Update endpoint stats
-	rateLimitStats.EndpointStats[path] = info
+	s.EndpointStats[attempt.Path] = info
+}
+
+// clone returns a copy of the statistics that shares no maps or slices with them
+func (s *RateLimitStats) clone() RateLimitStats {
+	stats := RateLimitStats{
+		TotalAttempts:     s.TotalAttempts,
+		BlockedAttempts:   s.BlockedAttempts,
+		OffenderAttempts:  make(map[string]int, len(s.OffenderAttempts)),
+		PersistentAbusers: make(map[string]time.Time, len(s.PersistentAbusers)),
+		RecentBlocks:      make([]RateLimitBlock, len(s.RecentBlocks)),
+		EndpointStats:     make(map[string]EndpointRateInfo, len(s.EndpointStats)),
+	}
+	for k, v := range s.OffenderAttempts {
+		stats.OffenderAttempts[k] = v
+	}
+	for k, v := range s.PersistentAbusers {
+		stats.PersistentAbusers[k] = v
+	}
+	copy(stats.RecentBlocks, s.RecentBlocks)
+	for k, v := range s.EndpointStats {
+		stats.EndpointStats[k] = v
+	}
+	return stats
 }
 
+// RateLimiter limits how often each client may request an endpoint. Counts
+// and statistics are kept in its RateLimitStore.
 type RateLimiter struct {
-	requests map[string][]time.Time
-	mu       sync.Mutex
+	store RateLimitStore
 }
 
+// NewRateLimiter returns a rate limiter keeping its counts in memory
 func NewRateLimiter() *RateLimiter {
+	return NewRateLimiterWithStore(NewMemoryRateLimitStore())
+}
+
+// NewRateLimiterWithStore returns a rate limiter keeping its counts in store
+func NewRateLimiterWithStore(store RateLimitStore) *RateLimiter {
 	return &RateLimiter{
-		requests: make(map[string][]time.Time),
+		store: store,
 	}
 }
 
@@ -186,100 +161,141 @@ func getFlashFunction(c *gin.Context) (func(string), bool) {
 	return nil, false
 }
 
-// RateLimit creates middleware that limits requests per client
+// RateLimit creates middleware that limits requests per client IP to each path
 // limit: number of requests allowed
 // duration: time window for the limit (e.g., 1 minute)
 func (rl *RateLimiter) RateLimit(limit int, duration time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Get the request path (NOT using c.FullPath() as it may be empty or different)
-		path := c.Request.URL.Path
+		// and name the policy after it for separate limits per endpoint
+		policy := RateLimitPolicy{Name: c.Request.URL.Path, Key: RateLimitKeyIP, Limit: limit, Window: duration}
+		rl.apply(c, policy, nil)
+	}
+}
 
-		// Skip rate limiting for Stripe webhooks
-		if path == "/webhook" && isStripeWebhook(c) {
-			c.Next()
-			return
+// Policies creates middleware applying the first policy matching each request's
+// path; requests matching none aren't limited. users finds the signed in user
+// for policies keyed by user or with tier limits. Without it, every request is
+// limited as a visitor's.
+func (rl *RateLimiter) Policies(policies []RateLimitPolicy, users RateLimitUserFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, policy := range policies {
+			if policy.Matches(c.Request.URL.Path) {
+				rl.apply(c, policy, users)
+				return
+			}
 		}
+		c.Next()
+	}
+}
 
-		// Use IP address as client identifier
-		clientIP := c.ClientIP()
-
-		// Add path to identifier for separate limits per endpoint
-		identifier := fmt.Sprintf("%s:%s", clientIP, path)
+// rateLimitSubject returns who a policy's limit is counted against for a request
+func rateLimitSubject(key string, clientIP string, user RateLimitUser, signedIn bool) string {
+	switch {
+	case key == RateLimitKeyUser && signedIn:
+		return fmt.Sprintf("user:%d", user.ID)
+	case key == RateLimitKeyIPUser && signedIn:
+		return fmt.Sprintf("ip:%s|user:%d", clientIP, user.ID)
+	default:
+		return "ip:" + clientIP
+	}
+}
 
-		rl.mu.Lock()
-		defer rl.mu.Unlock()
+// apply counts a request against a policy, blocking it once the limit is reached
+func (rl *RateLimiter) apply(c *gin.Context, policy RateLimitPolicy, users RateLimitUserFunc) {
+	path := c.Request.URL.Path
 
-		// Clean old requests
-		now := time.Now()
-		windowStart := now.Add(-duration)
+	// Skip rate limiting for Stripe webhooks
+	if path == "/webhook" && isStripeWebhook(c) {
+		c.Next()
+		return
+	}
 
-		// Get existing requests for this client
-		times := rl.requests[identifier]
-		valid := make([]time.Time, 0)
+	// Find who the request is from, and so the limit that applies to them
+	clientIP := c.ClientIP()
+	var user RateLimitUser
+	signedIn := false
+	if users != nil && policy.needsUser() {
+		user, signedIn = users(c)
+	}
+	limit := policy.Limit
+	if signedIn {
+		limit = policy.LimitFor(user.Tier)
+	}
+	identifier := policy.Name + ":" + rateLimitSubject(policy.Key, clientIP, user, signedIn)
+
+	result, err := rl.store.Take(c.Request.Context(), identifier, limit, policy.Window, time.Now())
+	if err != nil {
+		// Let the request through rather than lock everyone out while the store is unavailable
+		logger.Error("Failed to check rate limit", err, map[string]interface{}{
+			"ip":     clientIP,
+			"path":   path,
+			"policy": policy.Name,
+		})
+		c.Next()
+		return
+	}
 
-		// Keep only requests within our time window
-		for _, t := range times {
-			if t.After(windowStart) {
-				valid = append(valid, t)
-			}
-		}
+	// Track this attempt in stats
+	attempt := RateLimitBlock{IP: clientIP, Path: path, Timestamp: time.Now(), UserAgent: c.GetHeader("User-Agent")}
+	if err := rl.store.Track(c.Request.Context(), attempt, !result.Allowed); err != nil {
+		logger.Error("Failed to track rate limit statistics", err, map[string]interface{}{
+			"ip":   clientIP,
+			"path": path,
+		})
+	}
 
-		// Update requests for this client
-		rl.requests[identifier] = valid
-
-		// Track this attempt in stats
-		track(clientIP, path, len(valid) >= limit, c.GetHeader("User-Agent"))
-
-		// Check if limit exceeded
-		if len(valid) >= limit {
-			errorMessage := fmt.Sprintf("Rate limit exceeded. Try again in %v", duration)
-			err := errors.NewRateLimitError(errorMessage)
-
-			// Log the rate limit error with detailed information
-			logger.Error("Rate limit exceeded", err, map[string]interface{}{
-				"ip":         clientIP,
-				"path":       path,
-				"limit":      limit,
-				"duration":   duration.String(),
-				"user_agent": c.GetHeader("User-Agent"),
-				"attempts":   len(valid) + 1,
-			})
-
-			// First check if this is a user-facing route
-			isUserRoute := isUserFacingRoute(path)
-
-			// For user-facing routes, use a flash message and redirect
-			if isUserRoute {
-				// Try to set a flash message if the function is available
-				if setFlash, exists := getFlashFunction(c); exists {
-					setFlash(errorMessage)
-					c.Redirect(http.StatusSeeOther, "/")
-					c.Abort()
-					c.Error(err) // Record the error
-					return
-				}
-
-				// If no flash function, use a plain HTML message
-				c.Header("Content-Type", "text/html; charset=utf-8")
-				c.String(http.StatusTooManyRequests, "<html><body><h1>Rate Limit Exceeded</h1><p>%s</p><p><a href=\"/\">Return to home page</a></p></body></html>", errorMessage)
+	setRateLimitHeaders(c, result, policy.Window)
+
+	// Check if limit exceeded
+	if !result.Allowed {
+		retryAfter := time.Duration(max(ceilSeconds(result.RetryAfter), 1)) * time.Second
+		errorMessage := fmt.Sprintf("Rate limit exceeded. Try again in %v", retryAfter)
+		err := errors.NewRateLimitError(errorMessage)
+
+		// Log the rate limit error with detailed information
+		logger.Error("Rate limit exceeded", err, map[string]interface{}{
+			"ip":          clientIP,
+			"path":        path,
+			"policy":      policy.Name,
+			"user_id":     user.ID,
+			"limit":       limit,
+			"duration":    policy.Window.String(),
+			"user_agent":  c.GetHeader("User-Agent"),
+			"retry_after": result.RetryAfter.String(),
+		})
+
+		// First check if this is a user-facing route
+		isUserRoute := isUserFacingRoute(path)
+
+		// For user-facing routes, use a flash message and redirect
+		if isUserRoute {
+			// Try to set a flash message if the function is available
+			if setFlash, exists := getFlashFunction(c); exists {
+				setFlash(errorMessage)
+				c.Redirect(http.StatusSeeOther, "/")
 				c.Abort()
-				c.Error(err)
+				c.Error(err) // Record the error
 				return
 			}
 
-			// For API routes, return a JSON response
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error": errorMessage,
-			})
+			// If no flash function, use a plain HTML message
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.String(http.StatusTooManyRequests, "<html><body><h1>Rate Limit Exceeded</h1><p>%s</p><p><a href=\"/\">Return to home page</a></p></body></html>", errorMessage)
+			c.Abort()
 			c.Error(err)
 			return
 		}
 
-		// Add current request
-		rl.requests[identifier] = append(rl.requests[identifier], now)
-
-		c.Next()
+		// For API routes, return a JSON response
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error": errorMessage,
+		})
+		c.Error(err)
+		return
 	}
+
+	c.Next()
 }
 
 // LoginRateLimit creates middleware specifically for login attempts
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/models"
+)
+
+// Rate limit keys, choosing who a policy's limit applies to
+const (
+	RateLimitKeyIP     = "ip"      // Each client IP address
+	RateLimitKeyUser   = "user"    // Each signed in user, or each IP address for visitors
+	RateLimitKeyIPUser = "ip+user" // Each signed in user at each IP address
+)
+
+// Classes of plan in the catalog that a policy's tier limits can name, so the
+// limits follow the plans rather than their tier names
+const (
+	RateLimitTierPaid = "paid" // Any plan in the catalog that isn't free
+	RateLimitTierTop  = "top"  // Paid plans with nothing on sale ranked above them
+)
+
+//go:embed configs/rate_limits.json
+var defaultRateLimitPolicies []byte
+
+// RateLimitPolicy limits requests to a set of paths. Paths are exact, end in
+// "/*" to match everything below them, or are path.Match patterns.
+type RateLimitPolicy struct {
+	Name   string         `json:"name"`
+	Paths  []string       `json:"paths"`
+	Key    string         `json:"key"`             // One of the RateLimitKey constants, "ip" if empty
+	Limit  int            `json:"limit"`           // Requests allowed per window
+	Window time.Duration  `json:"-"`               // Read from "window", e.g. "1m"
+	Tiers  map[string]int `json:"tiers,omitempty"` // Limits for signed in users by subscription tier or RateLimitTier class
+}
+
+// UnmarshalJSON reads a policy, parsing its window as a duration like "30s" or "1h"
+func (p *RateLimitPolicy) UnmarshalJSON(b []byte) error {
+	type plain RateLimitPolicy
+	var raw struct {
+		plain
+		Window string `json:"window"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	window, err := time.ParseDuration(raw.Window)
+	if err != nil {
+		return fmt.Errorf("policy %q: window: %w", raw.Name, err)
+	}
+	*p = RateLimitPolicy(raw.plain)
+	p.Window = window
+	return nil
+}
+
+// Validate checks a policy can be applied
+func (p RateLimitPolicy) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("a rate limit policy needs a name")
+	}
+	if len(p.Paths) == 0 {
+		return fmt.Errorf("policy %q has no paths", p.Name)
+	}
+	for _, pattern := range p.Paths {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return fmt.Errorf("policy %q: bad path %q: %w", p.Name, pattern, err)
+		}
+	}
+	switch p.Key {
+	case "", RateLimitKeyIP, RateLimitKeyUser, RateLimitKeyIPUser:
+	default:
+		return fmt.Errorf("policy %q: unknown key %q", p.Name, p.Key)
+	}
+	if p.Limit < 1 {
+		return fmt.Errorf("policy %q: limit must be at least 1", p.Name)
+	}
+	if p.Window <= 0 {
+		return fmt.Errorf("policy %q: window must be positive", p.Name)
+	}
+	for tier, limit := range p.Tiers {
+		if limit < 1 {
+			return fmt.Errorf("policy %q: limit for %s must be at least 1", p.Name, tier)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the policy applies to a request path
+func (p RateLimitPolicy) Matches(requestPath string) bool {
+	for _, pattern := range p.Paths {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, requestPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitFor returns the limit for a subscription tier. A limit for the tier
+// itself comes first, then one for the class of its plan in the catalog, then
+// the policy's limit.
+func (p RateLimitPolicy) LimitFor(tier string) int {
+	if tier == "" {
+		return p.Limit
+	}
+	if limit, ok := p.Tiers[tier]; ok {
+		return limit
+	}
+	plan, ok := models.LookupPlan(tier)
+	if !ok || plan.Free() {
+		return p.Limit
+	}
+	if limit, ok := p.Tiers[RateLimitTierTop]; ok && !models.HasUpgrade(tier) {
+		return limit
+	}
+	if limit, ok := p.Tiers[RateLimitTierPaid]; ok {
+		return limit
+	}
+	return p.Limit
+}
+
+// needsUser reports whether applying the policy depends on who is signed in
+func (p RateLimitPolicy) needsUser() bool {
+	return p.Key == RateLimitKeyUser || p.Key == RateLimitKeyIPUser || len(p.Tiers) > 0
+}
+
+// ParseRateLimitPolicies reads policies from JSON like {"policies": [...]}
+func ParseRateLimitPolicies(b []byte) ([]RateLimitPolicy, error) {
+	var config struct {
+		Policies []RateLimitPolicy `json:"policies"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, policy := range config.Policies {
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("policy %q is defined twice", policy.Name)
+		}
+		names[policy.Name] = true
+	}
+	return config.Policies, nil
+}
+
+// LoadRateLimitPolicies reads the policies in the file named by the
+// RATE_LIMIT_POLICIES environment variable, or the built in policies from
+// configs/rate_limits.json when it isn't set
+func LoadRateLimitPolicies() ([]RateLimitPolicy, error) {
+	file := os.Getenv("RATE_LIMIT_POLICIES")
+	if file == "" {
+		return ParseRateLimitPolicies(defaultRateLimitPolicies)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := ParseRateLimitPolicies(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return policies, nil
+}
+
+// RateLimitUser is the signed in user a request is from
+type RateLimitUser struct {
+	ID   uint
+	Tier string
+}
+
+// RateLimitUserFunc returns the signed in user making a request, if there is one
+type RateLimitUserFunc func(c *gin.Context) (RateLimitUser, bool)
+
+// setRateLimitHeaders describes the limit a request was counted against, and
+// when blocked how long to wait, in the standard RateLimit headers
+func setRateLimitHeaders(c *gin.Context, result RateLimitResult, window time.Duration) {
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit, ceilSeconds(window)))
+	if !result.Allowed {
+		c.Header("Retry-After", strconv.Itoa(max(ceilSeconds(result.RetryAfter), 1)))
+	}
+}
+
+// ceilSeconds rounds a duration up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
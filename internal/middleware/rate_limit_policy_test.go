@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRateLimitPolicies(t *testing.T) {
+	t.Setenv("RATE_LIMIT_POLICIES", "")
+	policies, err := LoadRateLimitPolicies()
+	require.NoError(t, err)
+
+	byName := map[string]RateLimitPolicy{}
+	for _, policy := range policies {
+		byName[policy.Name] = policy
+	}
+	assert.Equal(t, 5, byName["login"].Limit)
+	assert.Equal(t, time.Minute, byName["login"].Window)
+	assert.Equal(t, time.Hour, byName["password-reset"].Window)
+	assert.True(t, byName["password-reset"].Matches("/reset-password/new"))
+	assert.Equal(t, RateLimitKeyUser, byName["owner"].Key)
+	assert.Greater(t, byName["owner"].LimitFor("premium_lifetime"), byName["owner"].LimitFor("free"))
+}
+
+func TestRateLimitPolicyTierClasses(t *testing.T) {
+	policy := RateLimitPolicy{Limit: 2, Tiers: map[string]int{RateLimitTierPaid: 4, RateLimitTierTop: 8, "yearly": 5}}
+
+	assert.Equal(t, 2, policy.LimitFor(models.FreeTier))
+	assert.Equal(t, 4, policy.LimitFor("monthly"))
+	assert.Equal(t, 5, policy.LimitFor("yearly"), "A limit for the tier beats its class")
+	assert.Equal(t, 8, policy.LimitFor("premium_lifetime"))
+	assert.Equal(t, 2, policy.LimitFor("promotion"), "Tiers that aren't plans get the policy's limit")
+}
+
+func TestRateLimitPolicyMatches(t *testing.T) {
+	policy := RateLimitPolicy{Paths: []string{"/owner/*", "/api/*/export", "/login"}}
+
+	for _, path := range []string{"/owner", "/owner/guns", "/owner/guns/1/edit", "/api/guns/export", "/login"} {
+		assert.True(t, policy.Matches(path), path)
+	}
+	for _, path := range []string{"/ownership", "/api/guns", "/api/guns/1/export", "/login/extra", "/"} {
+		assert.False(t, policy.Matches(path), path)
+	}
+}
+
+func TestParseRateLimitPolicies(t *testing.T) {
+	policies, err := ParseRateLimitPolicies([]byte(`{"policies": [
+		{"name": "owner", "paths": ["/owner/*"], "key": "user", "limit": 2, "window": "30s", "tiers": {"monthly": 4}}
+	]}`))
+	require.NoError(t, err)
+	require.Len(t, policies, 1)
+	assert.Equal(t, 30*time.Second, policies[0].Window)
+	assert.Equal(t, 4, policies[0].LimitFor("monthly"))
+	assert.Equal(t, 2, policies[0].LimitFor("free"))
+	assert.Equal(t, 2, policies[0].LimitFor(""))
+
+	for name, config := range map[string]string{
+		"bad window":   `{"policies": [{"name": "a", "paths": ["/a"], "limit": 1, "window": "soon"}]}`,
+		"no window":    `{"policies": [{"name": "a", "paths": ["/a"], "limit": 1}]}`,
+		"no paths":     `{"policies": [{"name": "a", "limit": 1, "window": "1m"}]}`,
+		"bad key":      `{"policies": [{"name": "a", "paths": ["/a"], "key": "email", "limit": 1, "window": "1m"}]}`,
+		"zero limit":   `{"policies": [{"name": "a", "paths": ["/a"], "limit": 0, "window": "1m"}]}`,
+		"bad pattern":  `{"policies": [{"name": "a", "paths": ["/a/["], "limit": 1, "window": "1m"}]}`,
+		"duplicate":    `{"policies": [{"name": "a", "paths": ["/a"], "limit": 1, "window": "1m"}, {"name": "a", "paths": ["/b"], "limit": 1, "window": "1m"}]}`,
+		"invalid json": `{"policies": [`,
+	} {
+		_, err := ParseRateLimitPolicies([]byte(config))
+		assert.Error(t, err, name)
+	}
+
+	// Policies can come from a file instead
+	file := filepath.Join(t.TempDir(), "rate_limits.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"policies": [{"name": "a", "paths": ["/a"], "limit": 1, "window": "1m"}]}`), 0o600))
+	t.Setenv("RATE_LIMIT_POLICIES", file)
+	policies, err = LoadRateLimitPolicies()
+	require.NoError(t, err)
+	assert.Equal(t, "a", policies[0].Name)
+}
+
+// policyRouter returns a router limiting requests by policies, where the X-User
+// header names the signed in user and X-Tier their subscription tier
+func policyRouter(policies []RateLimitPolicy) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	users := func(c *gin.Context) (RateLimitUser, bool) {
+		switch c.GetHeader("X-User") {
+		case "1":
+			return RateLimitUser{ID: 1, Tier: c.GetHeader("X-Tier")}, true
+		case "2":
+			return RateLimitUser{ID: 2, Tier: c.GetHeader("X-Tier")}, true
+		}
+		return RateLimitUser{}, false
+	}
+
+	router := gin.New()
+	router.Use(NewRateLimiter().Policies(policies, users))
+	router.GET("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, "success")
+	})
+	return router
+}
+
+// policyRequest makes a request from an IP address, as a signed in user if user isn't empty
+func policyRequest(router *gin.Engine, path string, ip string, user string, tier string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.RemoteAddr = ip + ":12345"
+	req.Header.Set("X-User", user)
+	req.Header.Set("X-Tier", tier)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitPolicies(t *testing.T) {
+	router := policyRouter([]RateLimitPolicy{
+		{Name: "owner", Paths: []string{"/owner/*"}, Key: RateLimitKeyUser, Limit: 2, Window: time.Minute, Tiers: map[string]int{"monthly": 3}},
+		{Name: "api", Paths: []string{"/api/*"}, Key: RateLimitKeyIPUser, Limit: 1, Window: time.Minute},
+	})
+
+	t.Run("Headers describe the limit", func(t *testing.T) {
+		w := policyRequest(router, "/owner/guns", "10.0.0.1", "1", "free")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+		assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+		assert.Empty(t, w.Header().Get("Retry-After"))
+
+		// Paths under the pattern share the user's limit
+		policyRequest(router, "/owner/munitions", "10.0.0.1", "1", "free")
+		w = policyRequest(router, "/owner", "10.0.0.1", "1", "free")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("User keys follow the user, not the IP", func(t *testing.T) {
+		w := policyRequest(router, "/owner/guns", "10.0.0.2", "1", "free")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, "User 1 is limited from any address")
+		w = policyRequest(router, "/owner/guns", "10.0.0.1", "2", "free")
+		assert.Equal(t, http.StatusOK, w.Code, "User 2 has their own limit at the same address")
+	})
+
+	t.Run("Limits vary by tier", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			w := policyRequest(router, "/owner/guns", "10.0.0.3", "2", "monthly")
+			if i < 2 {
+				assert.Equal(t, http.StatusOK, w.Code)
+			} else {
+				assert.Equal(t, http.StatusTooManyRequests, w.Code, "User 2 already made one request")
+			}
+			assert.Equal(t, "3", w.Header().Get("RateLimit-Limit"))
+		}
+	})
+
+	t.Run("Visitors are keyed by IP", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, policyRequest(router, "/owner/guns", "10.0.0.4", "", "").Code)
+		assert.Equal(t, http.StatusOK, policyRequest(router, "/owner/guns", "10.0.0.4", "", "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, "/owner/guns", "10.0.0.4", "", "").Code)
+	})
+
+	t.Run("IP and user keys need both to match", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, policyRequest(router, "/api/guns", "10.0.0.5", "1", "").Code)
+		assert.Equal(t, http.StatusTooManyRequests, policyRequest(router, "/api/guns", "10.0.0.5", "1", "").Code)
+		assert.Equal(t, http.StatusOK, policyRequest(router, "/api/guns", "10.0.0.6", "1", "").Code)
+		assert.Equal(t, http.StatusOK, policyRequest(router, "/api/guns", "10.0.0.5", "2", "").Code)
+	})
+
+	t.Run("Unmatched paths aren't limited", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			w := policyRequest(router, "/about", "10.0.0.1", "1", "free")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+	})
+}
@@ -0,0 +1,367 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimitResult is the outcome of counting a request against a rate limit
+type RateLimitResult struct {
+	Allowed    bool          // Whether the request is within the limit
+	Limit      int           // Requests allowed per window
+	Remaining  int           // Requests left before the limit is reached
+	Reset      time.Duration // Time until the current window ends
+	RetryAfter time.Duration // When blocked, how long until a request would be allowed
+}
+
+// RateLimitStore counts requests against rate limits and keeps the statistics
+// on them. Stores shared between instances of the app, like SQLRateLimitStore,
+// keep limits from multiplying with the number of replicas, and the statistics
+// cover every replica.
+type RateLimitStore interface {
+	// Take counts a request for key at now if it's within limit requests per window
+	Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (RateLimitResult, error)
+	// Track records a request that was counted in the statistics, and whether it was blocked
+	Track(ctx context.Context, attempt RateLimitBlock, blocked bool) error
+	// Stats returns the statistics
+	Stats(ctx context.Context) (RateLimitStats, error)
+	// ResetStats clears the statistics
+	ResetStats(ctx context.Context) error
+}
+
+// NewRateLimitStore returns the store named by the RATE_LIMIT_STORE environment
+// variable: "memory" (the default) keeps counts in this process and "sql" keeps
+// them in db so they're shared between instances
+func NewRateLimitStore(db *gorm.DB) (RateLimitStore, error) {
+	switch driver := strings.ToLower(strings.TrimSpace(os.Getenv("RATE_LIMIT_STORE"))); driver {
+	case "", "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "sql":
+		if db == nil {
+			return nil, errors.New("the sql rate limit store needs a database")
+		}
+		return NewSQLRateLimitStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", driver)
+	}
+}
+
+// slidingWindow approximates a sliding window with two fixed windows: requests
+// in the previous window count in proportion to how much of it the sliding
+// window still covers. Only two counters are kept, however many requests a key makes.
+type slidingWindow struct {
+	Start    time.Time // When the current window began
+	Current  int       // Requests allowed in the current window
+	Previous int       // Requests allowed in the window before it
+}
+
+// take counts a request at now if it's within limit requests per window
+func (w *slidingWindow) take(limit int, window time.Duration, now time.Time) RateLimitResult {
+	if w.Start.IsZero() {
+		w.Start = now
+	}
+
+	// Move forward to the window now falls in
+	elapsed := now.Sub(w.Start)
+	if elapsed >= window {
+		passed := elapsed / window
+		if passed == 1 {
+			w.Previous = w.Current
+		} else {
+			w.Previous = 0
+		}
+		w.Current = 0
+		w.Start = w.Start.Add(passed * window)
+		elapsed -= passed * window
+	}
+	// Another instance's clock may be slightly ahead of ours
+	elapsed = max(elapsed, 0)
+
+	weight := float64(window-elapsed) / float64(window)
+	estimate := float64(w.Previous)*weight + float64(w.Current)
+
+	result := RateLimitResult{Limit: limit, Reset: window - elapsed}
+	if estimate < float64(limit) {
+		w.Current++
+		result.Allowed = true
+		result.Remaining = max(int(float64(limit)-estimate-1), 0)
+		return result
+	}
+
+	if w.Current < limit {
+		// Blocked by the previous window, whose requests count for less as the window slides
+		result.RetryAfter = window - elapsed - time.Duration(float64(limit-w.Current)*float64(window)/float64(w.Previous))
+	} else {
+		// Blocked by this window, so wait for the next and for these requests to slide under the limit
+		result.RetryAfter = window - elapsed + time.Duration(float64(window)*(1-float64(limit)/float64(w.Current)))
+	}
+	result.RetryAfter = max(result.RetryAfter, 0)
+	return result
+}
+
+// expired reports whether both of the window's counts are over at now
+func (w *slidingWindow) expired(window time.Duration, now time.Time) bool {
+	return now.Sub(w.Start) >= 2*window
+}
+
+// memorySweepInterval is how often the memory store forgets keys whose windows are over
+const memorySweepInterval = time.Minute
+
+// memoryWindow is a key's window in the memory store
+type memoryWindow struct {
+	slidingWindow
+	length time.Duration
+}
+
+// MemoryRateLimitStore keeps rate limit counts and statistics in this process
+type MemoryRateLimitStore struct {
+	windows   map[string]*memoryWindow
+	stats     RateLimitStats
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// NewMemoryRateLimitStore returns an empty in-memory rate limit store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		windows: make(map[string]*memoryWindow),
+		stats:   newRateLimitStats(),
+	}
+}
+
+// Take counts a request for key at now if it's within limit requests per window
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= memorySweepInterval {
+		for k, w := range s.windows {
+			if w.expired(w.length, now) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok {
+		w = &memoryWindow{}
+		s.windows[key] = w
+	}
+	w.length = window
+	return w.take(limit, window, now), nil
+}
+
+// Track records a request that was counted in the statistics, and whether it was blocked
+func (s *MemoryRateLimitStore) Track(ctx context.Context, attempt RateLimitBlock, blocked bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.record(attempt, blocked)
+	return nil
+}
+
+// Stats returns a copy of the statistics
+func (s *MemoryRateLimitStore) Stats(ctx context.Context) (RateLimitStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stats.clone(), nil
+}
+
+// ResetStats clears the statistics
+func (s *MemoryRateLimitStore) ResetStats(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats = newRateLimitStats()
+	return nil
+}
+
+// sqlPruneInterval is how often the SQL store removes counters whose windows are over
+const sqlPruneInterval = 10 * time.Minute
+
+// SQLRateLimitStore keeps rate limit counts and statistics in the database so
+// every instance of the app shares them. Each key is one row, locked while it's updated.
+type SQLRateLimitStore struct {
+	db        *gorm.DB
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+// NewSQLRateLimitStore returns a rate limit store keeping counts in db's rate_limit_counters table
+func NewSQLRateLimitStore(db *gorm.DB) *SQLRateLimitStore {
+	return &SQLRateLimitStore{db: db}
+}
+
+// Take counts a request for key at now if it's within limit requests per window
+func (s *SQLRateLimitStore) Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (RateLimitResult, error) {
+	if err := s.prune(ctx, now); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	var result RateLimitResult
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists, then lock it so instances take turns updating it
+		counter := models.RateLimitCounter{LimitKey: key, WindowStart: now, ExpiresAt: now.Add(2 * window)}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&counter).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("limit_key = ?", key).First(&counter).Error; err != nil {
+			return err
+		}
+
+		w := slidingWindow{Start: counter.WindowStart, Current: counter.CurrentCount, Previous: counter.PreviousCount}
+		if w.expired(window, now) {
+			w = slidingWindow{}
+		}
+		result = w.take(limit, window, now)
+
+		return tx.Model(&counter).Updates(map[string]interface{}{
+			"window_start":   w.Start,
+			"current_count":  w.Current,
+			"previous_count": w.Previous,
+			"expires_at":     w.Start.Add(2 * window),
+		}).Error
+	})
+	return result, err
+}
+
+// prune removes counters whose windows are over, and blocks older than the
+// most recent recentBlocksKept, at most once every sqlPruneInterval
+func (s *SQLRateLimitStore) prune(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < sqlPruneInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastPrune = now
+	s.mu.Unlock()
+
+	db := s.db.WithContext(ctx)
+	if err := db.Where("expires_at < ?", now).Delete(&models.RateLimitCounter{}).Error; err != nil {
+		return err
+	}
+
+	// Find the oldest block kept, then remove those before it
+	var oldest []uint
+	err := db.Model(&models.RateLimitBlockEvent{}).Order("id DESC").Offset(recentBlocksKept-1).Limit(1).Pluck("id", &oldest).Error
+	if err != nil || len(oldest) == 0 {
+		return err
+	}
+	return db.Where("id < ?", oldest[0]).Delete(&models.RateLimitBlockEvent{}).Error
+}
+
+// Track records a request that was counted in the statistics, and whether it
+// was blocked. Counts are incremented in place so replicas don't overwrite
+// each other's.
+func (s *SQLRateLimitStore) Track(ctx context.Context, attempt RateLimitBlock, blocked bool) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		endpoint := models.RateLimitEndpointStat{Path: attempt.Path, TotalAttempts: 1}
+		updates := map[string]interface{}{
+			"total_attempts": gorm.Expr("rate_limit_endpoint_stats.total_attempts + 1"),
+		}
+		if blocked {
+			endpoint.BlockedAttempts = 1
+			endpoint.LastBlock = &attempt.Timestamp
+			updates["blocked_attempts"] = gorm.Expr("rate_limit_endpoint_stats.blocked_attempts + 1")
+			updates["last_block"] = attempt.Timestamp
+		}
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "path"}},
+			DoUpdates: clause.Assignments(updates),
+		}).Create(&endpoint).Error
+		if err != nil || !blocked {
+			return err
+		}
+
+		offender := models.RateLimitOffender{IP: attempt.IP, BlockedAttempts: 1}
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "ip"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"blocked_attempts": gorm.Expr("rate_limit_offenders.blocked_attempts + 1"),
+			}),
+		}).Create(&offender).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.RateLimitOffender{}).
+			Where("ip = ? AND blocked_attempts > ?", attempt.IP, persistentAbuserBlocks).
+			Update("persistent_since", attempt.Timestamp).Error
+		if err != nil {
+			return err
+		}
+
+		// Older blocks are trimmed when the store prunes
+		event := models.RateLimitBlockEvent{IP: attempt.IP, Path: attempt.Path, UserAgent: attempt.UserAgent, CreatedAt: attempt.Timestamp}
+		return tx.Create(&event).Error
+	})
+}
+
+// Stats returns the statistics every instance has recorded
+func (s *SQLRateLimitStore) Stats(ctx context.Context) (RateLimitStats, error) {
+	db := s.db.WithContext(ctx)
+	stats := newRateLimitStats()
+
+	var endpoints []models.RateLimitEndpointStat
+	if err := db.Find(&endpoints).Error; err != nil {
+		return RateLimitStats{}, err
+	}
+	for _, endpoint := range endpoints {
+		info := EndpointRateInfo{TotalAttempts: endpoint.TotalAttempts, BlockedAttempts: endpoint.BlockedAttempts}
+		if endpoint.LastBlock != nil {
+			info.LastBlock = *endpoint.LastBlock
+		}
+		stats.EndpointStats[endpoint.Path] = info
+		stats.TotalAttempts += endpoint.TotalAttempts
+		stats.BlockedAttempts += endpoint.BlockedAttempts
+	}
+
+	var offenders []models.RateLimitOffender
+	if err := db.Find(&offenders).Error; err != nil {
+		return RateLimitStats{}, err
+	}
+	for _, offender := range offenders {
+		stats.OffenderAttempts[offender.IP] = offender.BlockedAttempts
+		if offender.PersistentSince != nil {
+			stats.PersistentAbusers[offender.IP] = *offender.PersistentSince
+		}
+	}
+
+	var events []models.RateLimitBlockEvent
+	if err := db.Order("id DESC").Limit(recentBlocksKept).Find(&events).Error; err != nil {
+		return RateLimitStats{}, err
+	}
+	// Oldest first, as the memory store keeps them
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		stats.RecentBlocks = append(stats.RecentBlocks, RateLimitBlock{
+			IP:        event.IP,
+			Path:      event.Path,
+			Timestamp: event.CreatedAt,
+			UserAgent: event.UserAgent,
+		})
+	}
+	return stats, nil
+}
+
+// ResetStats clears the statistics for every instance
+func (s *SQLRateLimitStore) ResetStats(ctx context.Context) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&models.RateLimitEndpointStat{}, &models.RateLimitOffender{}, &models.RateLimitBlockEvent{}} {
+			if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
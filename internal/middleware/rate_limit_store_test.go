@@ -0,0 +1,226 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var w slidingWindow
+
+	for i := 0; i < 10; i++ {
+		result := w.take(10, time.Minute, start.Add(time.Duration(i)*time.Second))
+		require.True(t, result.Allowed, "Request %d is within the limit", i+1)
+		assert.Equal(t, 9-i, result.Remaining)
+	}
+	result := w.take(10, time.Minute, start.Add(10*time.Second))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 50*time.Second, result.RetryAfter, "Wait for the next window")
+	assert.Equal(t, 50*time.Second, result.Reset)
+
+	// Early in the next window the last window's requests still count for most of the limit
+	later := start.Add(66 * time.Second)
+	require.True(t, w.take(10, time.Minute, later).Allowed, "9 of the last window's 10 requests count")
+	later = later.Add(time.Second)
+	require.True(t, w.take(10, time.Minute, later).Allowed)
+	result = w.take(10, time.Minute, later)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 5*time.Second, result.RetryAfter, "The last window's requests slide out")
+	assert.False(t, w.take(10, time.Minute, later.Add(result.RetryAfter-time.Second)).Allowed)
+	assert.True(t, w.take(10, time.Minute, later.Add(result.RetryAfter+time.Millisecond)).Allowed)
+
+	// After two quiet windows everything is forgotten
+	w.take(10, time.Minute, start.Add(10*time.Minute))
+	assert.Equal(t, 0, w.Previous)
+	assert.Equal(t, 1, w.Current)
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRateLimitStore()
+	now := time.Now()
+
+	for i := 0; i < 1000; i++ {
+		store.Take(ctx, "203.0.113.7:/login", 5, time.Minute, now)
+	}
+	assert.Len(t, store.windows, 1, "A key keeps one window however many requests it makes")
+
+	result, err := store.Take(ctx, "203.0.113.8:/login", 5, time.Minute, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "Keys are limited separately")
+
+	// Keys whose windows are over are swept away
+	store.Take(ctx, "203.0.113.9:/login", 5, time.Minute, now.Add(3*time.Minute))
+	assert.Len(t, store.windows, 1)
+}
+
+// openRateLimitDB opens a SQLite database file for the SQL store, as a separate app instance would
+func openRateLimitDB(t *testing.T, path string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(path+"?_busy_timeout=5000"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.RateLimitCounter{}, &models.RateLimitEndpointStat{}, &models.RateLimitOffender{}, &models.RateLimitBlockEvent{}))
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
+
+func TestSQLRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rate_limits.db")
+	first := NewSQLRateLimitStore(openRateLimitDB(t, path))
+	second := NewSQLRateLimitStore(openRateLimitDB(t, path))
+	now := time.Now()
+
+	// Two instances share one limit
+	for i := 0; i < 5; i++ {
+		store := first
+		if i%2 == 1 {
+			store = second
+		}
+		result, err := store.Take(ctx, "203.0.113.7:/login", 5, time.Minute, now.Add(time.Duration(i)*time.Second))
+		require.NoError(t, err)
+		require.True(t, result.Allowed, "Request %d is within the limit", i+1)
+	}
+	result, err := second.Take(ctx, "203.0.113.7:/login", 5, time.Minute, now.Add(5*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 55*time.Second, result.RetryAfter)
+
+	// Two windows later the key starts over, and its old counter is pruned
+	result, err = first.Take(ctx, "203.0.113.7:/login", 5, time.Minute, now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 4, result.Remaining)
+
+	_, err = first.Take(ctx, "203.0.113.8:/login", 5, time.Minute, now.Add(3*time.Hour))
+	require.NoError(t, err)
+	var count int64
+	first.db.Model(&models.RateLimitCounter{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestRateLimitStoreStats(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "rate_limits.db")
+	first := NewSQLRateLimitStore(openRateLimitDB(t, path))
+	second := NewSQLRateLimitStore(openRateLimitDB(t, path))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	stores := map[string][2]RateLimitStore{
+		"memory": {NewMemoryRateLimitStore(), nil},
+		"sql":    {first, second},
+	}
+	for name, replicas := range stores {
+		t.Run(name, func(t *testing.T) {
+			// Each replica tracks its own requests, and the SQL store's statistics cover both
+			track := func(i int, attempt RateLimitBlock, blocked bool) {
+				store := replicas[0]
+				if replicas[1] != nil && i%2 == 1 {
+					store = replicas[1]
+				}
+				require.NoError(t, store.Track(ctx, attempt, blocked))
+			}
+			for i := 0; i < 3; i++ {
+				track(i, RateLimitBlock{IP: "203.0.113.7", Path: "/login", Timestamp: now}, false)
+			}
+			for i := 0; i < persistentAbuserBlocks+1; i++ {
+				track(i, RateLimitBlock{IP: "203.0.113.7", Path: "/login", Timestamp: now.Add(time.Duration(i) * time.Second), UserAgent: "curl"}, true)
+			}
+			track(0, RateLimitBlock{IP: "203.0.113.8", Path: "/register", Timestamp: now}, true)
+
+			stats, err := replicas[0].Stats(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, int64(15), stats.TotalAttempts)
+			assert.Equal(t, int64(12), stats.BlockedAttempts)
+			assert.Equal(t, map[string]int{"203.0.113.7": 11, "203.0.113.8": 1}, stats.OffenderAttempts)
+			require.Contains(t, stats.PersistentAbusers, "203.0.113.7")
+			assert.True(t, now.Add(10*time.Second).Equal(stats.PersistentAbusers["203.0.113.7"]))
+			assert.NotContains(t, stats.PersistentAbusers, "203.0.113.8")
+			assert.Equal(t, int64(14), stats.EndpointStats["/login"].TotalAttempts)
+			assert.Equal(t, int64(11), stats.EndpointStats["/login"].BlockedAttempts)
+			require.Len(t, stats.RecentBlocks, 12)
+			assert.Equal(t, "curl", stats.RecentBlocks[0].UserAgent)
+			assert.Equal(t, "/register", stats.RecentBlocks[11].Path, "Recent blocks are oldest first")
+
+			// Only the most recent blocks are kept
+			for i := 0; i < recentBlocksKept; i++ {
+				track(i, RateLimitBlock{IP: "198.51.100.1", Path: "/login", Timestamp: now}, true)
+			}
+			stats, err = replicas[0].Stats(ctx)
+			require.NoError(t, err)
+			assert.Len(t, stats.RecentBlocks, recentBlocksKept)
+			assert.Equal(t, "198.51.100.1", stats.RecentBlocks[0].IP)
+
+			require.NoError(t, replicas[0].ResetStats(ctx))
+			stats, err = replicas[0].Stats(ctx)
+			require.NoError(t, err)
+			assert.Zero(t, stats.TotalAttempts)
+			assert.Empty(t, stats.OffenderAttempts)
+			assert.Empty(t, stats.RecentBlocks)
+			assert.Empty(t, stats.EndpointStats)
+		})
+	}
+}
+
+func TestSQLRateLimitStorePrunesBlocks(t *testing.T) {
+	ctx := context.Background()
+	store := NewSQLRateLimitStore(openRateLimitDB(t, filepath.Join(t.TempDir(), "rate_limits.db")))
+	now := time.Now()
+
+	for i := 0; i < recentBlocksKept+5; i++ {
+		require.NoError(t, store.Track(ctx, RateLimitBlock{IP: fmt.Sprintf("198.51.100.%d", i), Path: "/login", Timestamp: now}, true))
+	}
+	var count int64
+	store.db.Model(&models.RateLimitBlockEvent{}).Count(&count)
+	assert.Equal(t, int64(recentBlocksKept+5), count, "Tracking a block doesn't trim the table")
+
+	// Pruning keeps the most recent
+	_, err := store.Take(ctx, "203.0.113.7:/login", 5, time.Minute, now)
+	require.NoError(t, err)
+	store.db.Model(&models.RateLimitBlockEvent{}).Count(&count)
+	assert.Equal(t, int64(recentBlocksKept), count)
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.5", stats.RecentBlocks[0].IP)
+}
+
+func TestRateLimiterWithSQLStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := openRateLimitDB(t, filepath.Join(t.TempDir(), "rate_limits.db"))
+
+	// Each replica has its own router and limiter, but they count in the same table
+	var replicas []*gin.Engine
+	for i := 0; i < 2; i++ {
+		router := gin.New()
+		router.Use(NewRateLimiterWithStore(NewSQLRateLimitStore(db)).RateLimit(2, time.Minute))
+		router.POST("/test", func(c *gin.Context) {
+			c.String(http.StatusOK, "success")
+		})
+		replicas = append(replicas, router)
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req, _ := http.NewRequest("POST", "/test", nil)
+		req.RemoteAddr = "192.168.1.20:12345"
+		w := httptest.NewRecorder()
+		replicas[i%2].ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Code, fmt.Sprintf("Request %d", i+1))
+	}
+}
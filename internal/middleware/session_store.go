@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	gsessions "github.com/gorilla/sessions"
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// SessionLifetime is how long a session lasts without being used
+const SessionLifetime = 30 * 24 * time.Hour
+
+// sessionTouchInterval is how often a session's last seen time and address are updated
+const sessionTouchInterval = time.Minute
+
+// sessionPruneInterval is how often expired sessions are removed
+const sessionPruneInterval = time.Hour
+
+// sessionClientIPKey is the request context key the client's IP address is kept under
+type sessionClientIPKey struct{}
+
+// SessionStore keeps sessions in the database, so they can be listed and
+// revoked. The cookie only holds a random token for finding the session.
+type SessionStore struct {
+	db        *gorm.DB
+	options   *gsessions.Options
+	lastPrune time.Time
+	mu        sync.Mutex
+}
+
+// NewSessionStore returns a session store keeping sessions in db's sessions table
+func NewSessionStore(db *gorm.DB) *SessionStore {
+	return &SessionStore{
+		db: db,
+		options: &gsessions.Options{
+			Path:     "/",
+			MaxAge:   int(SessionLifetime / time.Second),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+// Sessions returns the middleware that gives each request its session from the
+// store, noting the client's address for the session list
+func (s *SessionStore) Sessions(name string) gin.HandlerFunc {
+	handler := sessions.Sessions(name, s)
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), sessionClientIPKey{}, c.ClientIP()))
+		handler(c)
+	}
+}
+
+// Options sets the options for the session cookie
+func (s *SessionStore) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+}
+
+// Get returns the request's session, loading it the first time it's asked for
+func (s *SessionStore) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session named by the request's cookie, or starts an empty one
+// if there isn't one or it has expired or been revoked
+func (s *SessionStore) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return session, nil
+	}
+
+	now := time.Now()
+	var record models.Session
+	err = s.db.WithContext(r.Context()).
+		Where("token_hash = ? AND expires_at > ?", models.HashSessionToken(cookie.Value), now).
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := gob.NewDecoder(bytes.NewReader(record.Data)).Decode(&session.Values); err != nil {
+		return session, err
+	}
+	session.ID = cookie.Value
+	session.IsNew = false
+
+	// Keep the session alive while it's used, without writing on every request
+	if now.Sub(record.LastSeenAt) >= sessionTouchInterval {
+		err = s.db.WithContext(r.Context()).Model(&record).Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"ip_address":   requestIP(r),
+			"expires_at":   now.Add(lifetime(session.Options)),
+		}).Error
+	}
+	return session, err
+}
+
+// Save stores the session and sets its cookie. A session changing hands, by
+// someone signing in or out, gets a new token, so one learned before can't be
+// used after. An empty session isn't stored.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	ctx := r.Context()
+	now := time.Now()
+	if err := s.prune(ctx, now); err != nil {
+		return err
+	}
+
+	var record models.Session
+	found := false
+	if session.ID != "" {
+		err := s.db.WithContext(ctx).Where("token_hash = ?", models.HashSessionToken(session.ID)).First(&record).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		found = err == nil
+	}
+
+	userID := sessionUserID(session.Values)
+	if found && (record.UserID != userID || session.Options.MaxAge < 0 || len(session.Values) == 0) {
+		if err := s.db.WithContext(ctx).Delete(&record).Error; err != nil {
+			return err
+		}
+		found = false
+	}
+
+	if session.Options.MaxAge < 0 || len(session.Values) == 0 {
+		if session.ID != "" {
+			expired := *session.Options
+			expired.MaxAge = -1
+			http.SetCookie(w, gsessions.NewCookie(session.Name(), "", &expired))
+			session.ID = ""
+		}
+		return nil
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(session.Values); err != nil {
+		return err
+	}
+
+	if found {
+		err := s.db.WithContext(ctx).Model(&record).Updates(map[string]interface{}{
+			"data":         data.Bytes(),
+			"last_seen_at": now,
+			"ip_address":   requestIP(r),
+			"expires_at":   now.Add(lifetime(session.Options)),
+		}).Error
+		if err != nil {
+			return err
+		}
+	} else {
+		token, err := newSessionToken()
+		if err != nil {
+			return err
+		}
+		userAgent := r.UserAgent()
+		if len(userAgent) > 255 {
+			userAgent = userAgent[:255]
+		}
+		record = models.Session{
+			TokenHash:  models.HashSessionToken(token),
+			UserID:     userID,
+			Data:       data.Bytes(),
+			UserAgent:  userAgent,
+			IPAddress:  requestIP(r),
+			CreatedAt:  now,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(lifetime(session.Options)),
+		}
+		if err := s.db.WithContext(ctx).Create(&record).Error; err != nil {
+			return err
+		}
+		session.ID = token
+	}
+
+	options := *session.Options
+	options.Secure = options.Secure || r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), session.ID, &options))
+	return nil
+}
+
+// prune removes expired sessions, at most once every sessionPruneInterval
+func (s *SessionStore) prune(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < sessionPruneInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastPrune = now
+	s.mu.Unlock()
+
+	return s.db.WithContext(ctx).Where("expires_at < ?", now).Delete(&models.Session{}).Error
+}
+
+// sessionUserID returns the signed in user's ID from a session's values, or 0
+func sessionUserID(values map[interface{}]interface{}) uint {
+	value, _ := values["user_id"].(string)
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(id)
+}
+
+// lifetime returns how long a session lasts without being used: as long as
+// its cookie, or SessionLifetime for a cookie that ends with the browser
+func lifetime(options *gsessions.Options) time.Duration {
+	if options.MaxAge > 0 {
+		return time.Duration(options.MaxAge) * time.Second
+	}
+	return SessionLifetime
+}
+
+// requestIP returns the client's address, as noted by SessionStore.Sessions
+func requestIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(sessionClientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// newSessionToken returns a random session token
+func newSessionToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
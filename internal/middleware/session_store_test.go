@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sessionTestRouter returns a router using a session store, with pages that
+// sign in, sign out, and show who's signed in
+func sessionTestRouter(t *testing.T) (*gorm.DB, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sessions.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Session{}))
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+
+	router := gin.New()
+	router.Use(NewSessionStore(db).Sessions("armory-session"))
+	router.GET("/", func(c *gin.Context) {
+		userID, _ := sessions.Default(c).Get("user_id").(string)
+		c.String(http.StatusOK, userID)
+	})
+	router.GET("/flash", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Flashes()
+		session.Save()
+	})
+	router.POST("/login", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Set("user_id", "7")
+		session.Save()
+	})
+	router.POST("/logout", func(c *gin.Context) {
+		session := sessions.Default(c)
+		session.Clear()
+		session.AddFlash("Come back soon!")
+		session.Save()
+	})
+	return db, router
+}
+
+// sessionRequest makes a request with the session cookie, returning the
+// response and the cookie to use next
+func sessionRequest(router *gin.Engine, method, path string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		cookie = c
+	}
+	return w, cookie
+}
+
+func TestSessionStore(t *testing.T) {
+	db, router := sessionTestRouter(t)
+
+	// Nothing is stored for a visitor whose session is empty
+	w, cookie := sessionRequest(router, "GET", "/flash", nil)
+	assert.Nil(t, cookie)
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+	var count int64
+	db.Model(&models.Session{}).Count(&count)
+	assert.Zero(t, count)
+
+	// Signing in stores the session; the cookie only has a token for finding it
+	_, cookie = sessionRequest(router, "POST", "/login", nil)
+	require.NotNil(t, cookie)
+	assert.True(t, cookie.HttpOnly)
+	var stored models.Session
+	require.NoError(t, db.First(&stored).Error)
+	assert.Equal(t, uint(7), stored.UserID)
+	assert.Equal(t, models.HashSessionToken(cookie.Value), stored.TokenHash)
+	assert.Equal(t, "Chrome on macOS", stored.Device())
+	assert.NotContains(t, string(stored.Data), cookie.Value)
+
+	w, _ = sessionRequest(router, "GET", "/", cookie)
+	assert.Equal(t, "7", w.Body.String())
+
+	// Using the session moves its last seen time along
+	require.NoError(t, db.Model(&stored).Update("last_seen_at", time.Now().Add(-time.Hour)).Error)
+	sessionRequest(router, "GET", "/", cookie)
+	require.NoError(t, db.First(&stored, stored.ID).Error)
+	assert.WithinDuration(t, time.Now(), stored.LastSeenAt, time.Minute)
+
+	// A revoked session is signed out
+	revoked, err := models.RevokeSession(db, 7, stored.ID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	w, _ = sessionRequest(router, "GET", "/", cookie)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestSessionStoreRotatesTokens(t *testing.T) {
+	db, router := sessionTestRouter(t)
+
+	// A session started before signing in gets a new token when someone signs in
+	_, anonymous := sessionRequest(router, "POST", "/logout", nil)
+	require.NotNil(t, anonymous)
+	_, signedIn := sessionRequest(router, "POST", "/login", anonymous)
+	assert.NotEqual(t, anonymous.Value, signedIn.Value)
+	w, _ := sessionRequest(router, "GET", "/", anonymous)
+	assert.Empty(t, w.Body.String(), "The token from before signing in doesn't sign anyone in")
+
+	// Signing out ends the stored session, so its token can't be used again
+	_, signedOut := sessionRequest(router, "POST", "/logout", signedIn)
+	assert.NotEqual(t, signedIn.Value, signedOut.Value)
+	w, _ = sessionRequest(router, "GET", "/", signedIn)
+	assert.Empty(t, w.Body.String())
+
+	var sessions []models.Session
+	require.NoError(t, db.Find(&sessions).Error)
+	require.Len(t, sessions, 1, "Only the signed out session with its flash message is left")
+	assert.Zero(t, sessions[0].UserID)
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/logger"
@@ -28,34 +27,22 @@ func NewRateLimiterMiddleware() *RateLimiter {
 	return NewRateLimiter()
 }
 
-// SetupRateLimiting configures rate limiting for critical endpoints
+// SetupRateLimiting configures rate limiting for critical endpoints, counting requests in memory
 func SetupRateLimiting(router *gin.Engine) {
-	rateLimiter := NewRateLimiter()
+	SetupRateLimitingWithStore(router, NewMemoryRateLimitStore(), nil)
+}
 
-	// Create a single middleware for all routes
-	router.Use(func(c *gin.Context) {
-		// Get the actual request path
-		path := c.Request.URL.Path
-
-		// Apply the appropriate rate limiter based on the path
-		switch {
-		case path == "/login":
-			// Login rate limit (5 requests per minute)
-			rateLimiter.RateLimit(5, time.Minute)(c)
-		case path == "/register":
-			// Register rate limit (5 requests per minute)
-			rateLimiter.RateLimit(5, time.Minute)(c)
-		case path == "/reset-password" || path == "/reset-password/new":
-			// Password reset rate limit (3 requests per hour)
-			rateLimiter.RateLimit(3, time.Hour)(c)
-		case path == "/webhook":
-			// Webhook rate limit (10 requests per minute)
-			rateLimiter.RateLimit(10, time.Minute)(c)
-		default:
-			// No rate limiting for other routes
-			c.Next()
-		}
-	})
+// SetupRateLimitingWithStore configures rate limiting with the policies from
+// LoadRateLimitPolicies, counting requests in store. users finds the signed in
+// user for policies keyed by user or with tier limits, and may be nil.
+func SetupRateLimitingWithStore(router *gin.Engine, store RateLimitStore, users RateLimitUserFunc) {
+	policies, err := LoadRateLimitPolicies()
+	if err != nil {
+		logger.Error("Failed to load rate limit policies, using the built in policies", err, nil)
+		policies, _ = ParseRateLimitPolicies(defaultRateLimitPolicies)
+	}
+
+	router.Use(NewRateLimiterWithStore(store).Policies(policies, users))
 }
 
 // SetupCasbin initializes Casbin RBAC middleware
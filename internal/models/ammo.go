@@ -21,14 +21,15 @@ type Ammo struct {
 	CaliberID     uint    `gorm:"not null"`
 	Caliber       Caliber `gorm:"foreignKey:CaliberID"`
 	CasingID      uint
-	Casing        Casing      `gorm:"foreignKey:CasingID"`
-	OwnerID       uint        `gorm:"not null"`
-	Owner         interface{} `gorm:"-"` // This will be populated by the application, not stored in DB
-	Paid          *float64    // Optional field for the price paid (in USD)
-	Count         int         // Number of ammunition rounds
-	Expended      int         // Number of rounds that have been used
-	HasMoreAmmo   bool        `gorm:"-"` // Indicates if there are more ammo not being shown (not stored in DB)
-	TotalAmmo     int         `gorm:"-"` // Total number of ammo the user has (not stored in DB)
+	Casing        Casing       `gorm:"foreignKey:CasingID"`
+	OwnerID       uint         `gorm:"not null"`
+	Owner         interface{}  `gorm:"-"` // This will be populated by the application, not stored in DB
+	Paid          *float64     // Optional field for the price paid (in USD)
+	Count         int          // Number of ammunition rounds
+	Expended      int          // Number of rounds that have been used
+	HasMoreAmmo   bool         `gorm:"-"`                 // Indicates if there are more ammo not being shown (not stored in DB)
+	TotalAmmo     int          `gorm:"-"`                 // Total number of ammo the user has (not stored in DB)
+	Attachments   []Attachment `gorm:"foreignKey:AmmoID"` // Photos of the ammo, such as receipts
 }
 
 // TableName specifies the table name for the Ammo model
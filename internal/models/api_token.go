@@ -0,0 +1,214 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix starts every personal access token, so a leaked one is easy to recognize
+const APITokenPrefix = "armory_"
+
+// apiTokenTouchInterval is how often a token's last used time is updated
+const apiTokenTouchInterval = time.Minute
+
+// API token scopes. A write scope also allows reading the same data.
+const (
+	APIScopeGunsRead       = "guns:read"
+	APIScopeGunsWrite      = "guns:write"
+	APIScopeAmmoRead       = "ammo:read"
+	APIScopeAmmoWrite      = "ammo:write"
+	APIScopeRangeDaysRead  = "range_days:read"
+	APIScopeRangeDaysWrite = "range_days:write"
+	APIScopeReferenceRead  = "reference:read"
+)
+
+// APIScope describes a scope an API token can be given
+type APIScope struct {
+	Name        string
+	Description string
+}
+
+// APIScopes lists every scope, in the order the token form shows them
+var APIScopes = []APIScope{
+	{APIScopeGunsRead, "Read your guns"},
+	{APIScopeGunsWrite, "Add, change, and delete your guns"},
+	{APIScopeAmmoRead, "Read your ammunition"},
+	{APIScopeAmmoWrite, "Add, change, and delete your ammunition"},
+	{APIScopeRangeDaysRead, "Read your range days"},
+	{APIScopeRangeDaysWrite, "Log and delete range days"},
+	{APIScopeReferenceRead, "Read manufacturers, calibers, ranges, and other reference data"},
+}
+
+var (
+	// ErrAPITokenNameRequired is returned when a token has no name
+	ErrAPITokenNameRequired = errors.New("token name is required")
+
+	// ErrAPITokenNameTooLong is returned when a token's name exceeds the maximum allowed length
+	ErrAPITokenNameTooLong = errors.New("token name exceeds maximum length of 100 characters")
+
+	// ErrAPITokenScopesRequired is returned when a token has no scopes
+	ErrAPITokenScopesRequired = errors.New("token needs at least one scope")
+
+	// ErrAPITokenInvalidScope is returned when a token is given a scope that doesn't exist
+	ErrAPITokenInvalidScope = errors.New("invalid token scope")
+
+	// ErrAPITokenExpiryInvalid is returned when a token would expire in the past
+	ErrAPITokenExpiryInvalid = errors.New("token expiry must be in the future")
+)
+
+// APIToken is a personal access token an owner created to use the JSON API.
+// Only a hash of the token is stored; the owner sees the token once, when
+// it's created.
+type APIToken struct {
+	gorm.Model
+	UserID     uint      `gorm:"index;not null"`
+	Name       string    `gorm:"size:100;not null"`
+	TokenHash  string    `gorm:"size:64;uniqueIndex;not null"`
+	Prefix     string    `gorm:"size:16"`  // The start of the token, to tell tokens apart
+	Scopes     string    `gorm:"size:255"` // Space separated
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	LastUsedAt *time.Time
+}
+
+// TableName specifies the table name for the APIToken model
+func (APIToken) TableName() string {
+	return "api_tokens"
+}
+
+// ScopeList returns the token's scopes
+func (t APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// HasScope reports whether the token allows scope. A token with a write scope
+// can also read the same data.
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+		if resource, ok := strings.CutSuffix(scope, ":read"); ok && s == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired reports whether the token can no longer be used
+func (t APIToken) Expired() bool {
+	return !time.Now().Before(t.ExpiresAt)
+}
+
+// HashAPIToken hashes a personal access token for storing or looking up
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidAPIScope reports whether scope is one of APIScopes
+func ValidAPIScope(scope string) bool {
+	for _, s := range APIScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken creates a token for a user, returning the token itself, which
+// isn't stored and can't be shown again, along with its record
+func CreateAPIToken(db *gorm.DB, userID uint, name string, scopes []string, expiresAt time.Time) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrAPITokenNameRequired
+	}
+	if len(name) > 100 {
+		return "", nil, ErrAPITokenNameTooLong
+	}
+	if len(scopes) == 0 {
+		return "", nil, ErrAPITokenScopesRequired
+	}
+	for _, scope := range scopes {
+		if !ValidAPIScope(scope) {
+			return "", nil, ErrAPITokenInvalidScope
+		}
+	}
+	if !expiresAt.After(time.Now()) {
+		return "", nil, ErrAPITokenExpiryInvalid
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(random)
+
+	record := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: HashAPIToken(token),
+		Prefix:    token[:len(APITokenPrefix)+4],
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(record).Error; err != nil {
+		return "", nil, err
+	}
+	return token, record, nil
+}
+
+// FindAPITokensByUser returns a user's tokens, newest first
+func FindAPITokensByUser(db *gorm.DB, userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// FindAPITokenByToken returns the unexpired token record for a token, or nil if there isn't one
+func FindAPITokenByToken(db *gorm.DB, token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		return nil, nil
+	}
+	var record APIToken
+	err := db.Where("token_hash = ? AND expires_at > ?", HashAPIToken(token), time.Now()).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// RecordAPITokenUse notes that a token was just used, without writing on every request
+func RecordAPITokenUse(db *gorm.DB, token *APIToken) error {
+	now := time.Now()
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < apiTokenTouchInterval {
+		return nil
+	}
+	token.LastUsedAt = &now
+	return db.Model(token).UpdateColumn("last_used_at", now).Error
+}
+
+// DeleteAPIToken revokes one of a user's tokens and returns it, or nil if they don't have it
+func DeleteAPIToken(db *gorm.DB, userID, id uint) (*APIToken, error) {
+	var token APIToken
+	err := db.Where("id = ? AND user_id = ?", id, userID).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Unscoped().Delete(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
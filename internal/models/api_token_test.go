@@ -0,0 +1,95 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupAPITokenTestDB creates a private in-memory database with the api_tokens table
+func setupAPITokenTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&APIToken{}))
+	return db
+}
+
+func TestAPITokenHasScope(t *testing.T) {
+	token := APIToken{Scopes: "guns:write reference:read"}
+
+	assert.True(t, token.HasScope(APIScopeGunsWrite))
+	assert.True(t, token.HasScope(APIScopeGunsRead), "write should imply read")
+	assert.True(t, token.HasScope(APIScopeReferenceRead))
+	assert.False(t, token.HasScope(APIScopeAmmoRead))
+	assert.False(t, APIToken{Scopes: "guns:read"}.HasScope(APIScopeGunsWrite))
+}
+
+func TestCreateAPITokenValidation(t *testing.T) {
+	db := setupAPITokenTestDB(t)
+	expires := time.Now().Add(24 * time.Hour)
+
+	_, _, err := CreateAPIToken(db, 1, " ", []string{APIScopeGunsRead}, expires)
+	assert.ErrorIs(t, err, ErrAPITokenNameRequired)
+	_, _, err = CreateAPIToken(db, 1, strings.Repeat("a", 101), []string{APIScopeGunsRead}, expires)
+	assert.ErrorIs(t, err, ErrAPITokenNameTooLong)
+	_, _, err = CreateAPIToken(db, 1, "Script", nil, expires)
+	assert.ErrorIs(t, err, ErrAPITokenScopesRequired)
+	_, _, err = CreateAPIToken(db, 1, "Script", []string{"admin"}, expires)
+	assert.ErrorIs(t, err, ErrAPITokenInvalidScope)
+	_, _, err = CreateAPIToken(db, 1, "Script", []string{APIScopeGunsRead}, time.Now().Add(-time.Hour))
+	assert.ErrorIs(t, err, ErrAPITokenExpiryInvalid)
+}
+
+func TestFindAPITokenByToken(t *testing.T) {
+	db := setupAPITokenTestDB(t)
+
+	token, created, err := CreateAPIToken(db, 1, "Script", []string{APIScopeGunsRead}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, APITokenPrefix))
+	assert.True(t, strings.HasPrefix(token, created.Prefix))
+	assert.Equal(t, HashAPIToken(token), created.TokenHash, "only the hash should be stored")
+
+	found, err := FindAPITokenByToken(db, token)
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, created.ID, found.ID)
+
+	found, err = FindAPITokenByToken(db, token+"x")
+	require.NoError(t, err)
+	assert.Nil(t, found, "an unknown token should not be found")
+	found, err = FindAPITokenByToken(db, "not-a-token")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	// An expired token no longer works
+	require.NoError(t, db.Model(created).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	found, err = FindAPITokenByToken(db, token)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestDeleteAPIToken(t *testing.T) {
+	db := setupAPITokenTestDB(t)
+
+	token, created, err := CreateAPIToken(db, 1, "Script", []string{APIScopeGunsRead}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	// Another owner can't revoke the token
+	deleted, err := DeleteAPIToken(db, 2, created.ID)
+	require.NoError(t, err)
+	assert.Nil(t, deleted)
+
+	deleted, err = DeleteAPIToken(db, 1, created.ID)
+	require.NoError(t, err)
+	require.NotNil(t, deleted)
+	assert.Equal(t, "Script", deleted.Name)
+
+	found, err := FindAPITokenByToken(db, token)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Attachment is a photo attached to a gun or an ammunition lot. The photo and its
+// thumbnail are kept in file storage under StorageKey and ThumbnailKey.
+type Attachment struct {
+	gorm.Model
+	OwnerID       uint   `gorm:"index;not null"`
+	GunID         *uint  `gorm:"index"`
+	AmmoID        *uint  `gorm:"index"`
+	Filename      string `gorm:"size:255"` // The name of the file the owner uploaded
+	Caption       string `gorm:"size:200"`
+	ContentType   string `gorm:"size:50;not null"`
+	StorageKey    string `gorm:"size:255;not null"`
+	ThumbnailKey  string `gorm:"size:255;not null"`
+	Size          int64  // Bytes stored for the photo
+	ThumbnailSize int64  // Bytes stored for the thumbnail
+	Width         int
+	Height        int
+}
+
+// TableName specifies the table name for the Attachment model
+func (Attachment) TableName() string {
+	return "attachments"
+}
+
+// Validate checks the attachment belongs to exactly one gun or ammunition lot
+func (a *Attachment) Validate() error {
+	if (a.GunID == nil) == (a.AmmoID == nil) {
+		return errors.New("a photo must be attached to either a gun or ammunition")
+	}
+	return nil
+}
+
+// ParentPath returns the page of the gun or ammunition lot the photo is attached to
+func (a *Attachment) ParentPath() string {
+	if a.GunID != nil {
+		return fmt.Sprintf("/owner/guns/%d", *a.GunID)
+	}
+	if a.AmmoID != nil {
+		return fmt.Sprintf("/owner/munitions/%d", *a.AmmoID)
+	}
+	return "/owner"
+}
+
+// FindAttachmentsForGun retrieves the photos attached to a gun, oldest first
+func FindAttachmentsForGun(db *gorm.DB, gunID uint, ownerID uint) ([]Attachment, error) {
+	var attachments []Attachment
+	err := db.Where("gun_id = ? AND owner_id = ?", gunID, ownerID).Order("created_at ASC, id ASC").Find(&attachments).Error
+	return attachments, err
+}
+
+// FindAttachmentsForAmmo retrieves the photos attached to an ammunition lot, oldest first
+func FindAttachmentsForAmmo(db *gorm.DB, ammoID uint, ownerID uint) ([]Attachment, error) {
+	var attachments []Attachment
+	err := db.Where("ammo_id = ? AND owner_id = ?", ammoID, ownerID).Order("created_at ASC, id ASC").Find(&attachments).Error
+	return attachments, err
+}
+
+// FindAttachmentByID retrieves an attachment, ensuring it belongs to the specified owner
+func FindAttachmentByID(db *gorm.DB, id uint, ownerID uint) (*Attachment, error) {
+	var attachment Attachment
+	if err := db.Where("id = ? AND owner_id = ?", id, ownerID).First(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
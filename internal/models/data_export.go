@@ -0,0 +1,131 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Data export statuses
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything an owner has stored, built in the
+// background when they ask for it. The archive is kept in file storage until
+// it expires.
+type DataExport struct {
+	gorm.Model
+	UserID      uint   `gorm:"index;not null"`
+	Status      string `gorm:"size:20;not null;default:'pending'"`
+	StorageKey  string `gorm:"size:255"`
+	Size        int64
+	CompletedAt *time.Time
+	ExpiresAt   *time.Time
+}
+
+// TableName specifies the table name for the DataExport model
+func (DataExport) TableName() string {
+	return "data_exports"
+}
+
+// Expired reports whether a finished export's archive is past its expiry
+func (e DataExport) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// Downloadable reports whether the export's archive is ready and hasn't expired
+func (e DataExport) Downloadable(now time.Time) bool {
+	return e.Status == DataExportReady && e.StorageKey != "" && !e.Expired(now)
+}
+
+// FindLatestDataExport returns the user's most recent export, or nil if they haven't asked for one
+func FindLatestDataExport(db *gorm.DB, userID uint) (*DataExport, error) {
+	var export DataExport
+	err := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FindDataExport returns one of a user's exports, or nil if they don't have it
+func FindDataExport(db *gorm.DB, userID, id uint) (*DataExport, error) {
+	var export DataExport
+	err := db.Where("id = ? AND user_id = ?", id, userID).First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FindDataExportsByUser returns all of a user's exports, oldest first
+func FindDataExportsByUser(db *gorm.DB, userID uint) ([]DataExport, error) {
+	var exports []DataExport
+	err := db.Where("user_id = ?", userID).Order("created_at ASC, id ASC").Find(&exports).Error
+	return exports, err
+}
+
+// CompleteDataExport records that an export's archive is stored and when it expires
+func CompleteDataExport(db *gorm.DB, export *DataExport, key string, size int64, expiresAt time.Time) error {
+	now := time.Now()
+	export.Status = DataExportReady
+	export.StorageKey = key
+	export.Size = size
+	export.CompletedAt = &now
+	export.ExpiresAt = &expiresAt
+	return db.Model(export).Updates(map[string]interface{}{
+		"status":       DataExportReady,
+		"storage_key":  key,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   expiresAt,
+	}).Error
+}
+
+// FailDataExport records that an export couldn't be built
+func FailDataExport(db *gorm.DB, export *DataExport) error {
+	now := time.Now()
+	export.Status = DataExportFailed
+	export.CompletedAt = &now
+	return db.Model(export).Updates(map[string]interface{}{
+		"status":       DataExportFailed,
+		"completed_at": now,
+	}).Error
+}
+
+// FailStaleDataExports records that exports still pending from before
+// cutoff failed, as the job building them has died, and returns how many
+// there were
+func FailStaleDataExports(db *gorm.DB, cutoff time.Time) (int64, error) {
+	result := db.Model(&DataExport{}).
+		Where("status = ? AND created_at < ?", DataExportPending, cutoff).
+		Updates(map[string]interface{}{
+			"status":       DataExportFailed,
+			"completed_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// FindExpiredDataExports returns the exports whose archives are stored but past their expiry
+func FindExpiredDataExports(db *gorm.DB, now time.Time) ([]DataExport, error) {
+	var exports []DataExport
+	err := db.Where("storage_key <> '' AND expires_at <= ?", now).Order("id ASC").Find(&exports).Error
+	return exports, err
+}
+
+// ClearDataExportArchive records that an export's archive has been deleted.
+// The export is kept so the owner can see it has expired.
+func ClearDataExportArchive(db *gorm.DB, export *DataExport) error {
+	export.StorageKey = ""
+	return db.Model(export).Update("storage_key", "").Error
+}
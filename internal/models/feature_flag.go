@@ -7,6 +7,11 @@ import (
 	"gorm.io/gorm"
 )
 
+// FeatureRequireAdminTwoFactor is the feature flag that makes users who hold a
+// Casbin role, or one of the roles listed on the flag, set up two-factor
+// authentication before using the admin pages
+const FeatureRequireAdminTwoFactor = "require_admin_two_factor"
+
 // FeatureFlag represents a feature flag in the system
 type FeatureFlag struct {
 	ID           uint      `gorm:"primaryKey;autoIncrement"`
@@ -12,7 +12,8 @@ import (
 type Gun struct {
 	gorm.Model
 	Name           string
-	SerialNumber   string
+	SerialNumber   string `gorm:"serializer:encrypted"`  // Encrypted at rest, see the fieldcrypt package
+	SerialIndex    string `gorm:"size:64;index" json:"-"` // Blind index of SerialNumber for exact match searches
 	Purpose        string // Purpose of the gun (e.g., "Carry", "Plinking", "Home Defense")
 	Finish         string // Finish of the gun (e.g., "Bluing", "Cerakote", "Stainless", "Nickel Plating")
 	Acquired       *time.Time
@@ -28,6 +29,7 @@ type Gun struct {
 	TotalGuns      int         `gorm:"-"` // Total number of guns the user has (not stored in DB)
 	Paid           *float64    // Optional field for the price paid (in USD)
 	Rental bool `gorm:"default:false;not null"` // Whether this gun is a rental
+	Attachments    []Attachment `gorm:"foreignKey:GunID"` // Photos of the gun, such as its serial number area
 }
 
 // TableName specifies the table name for the Gun model
@@ -74,10 +76,17 @@ func UpdateGun(db *gorm.DB, gun *Gun) error {
 		return err
 	}
 
+	// Map updates skip the serializer, so the serial number is encrypted here
+	serial, err := serialColumns(gun.SerialNumber)
+	if err != nil {
+		return err
+	}
+
 	// Update the gun with all fields
 	result := db.Model(&existingGun).Updates(map[string]interface{}{
 		"name":            gun.Name,
-		"serial_number":   gun.SerialNumber,
+		"serial_number":   serial["serial_number"],
+		"serial_index":    serial["serial_index"],
 		"purpose":         gun.Purpose,
 		"finish":          gun.Finish,
 		"acquired":        gun.Acquired,
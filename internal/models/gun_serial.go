@@ -0,0 +1,110 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/hail2skins/armory/internal/fieldcrypt"
+	"gorm.io/gorm"
+)
+
+// BeforeSave keeps the gun's serial number blind index in step with its serial
+// number. The serial number itself is encrypted by its column's serializer.
+func (g *Gun) BeforeSave(tx *gorm.DB) error {
+	keyring, err := fieldcrypt.Default()
+	if err != nil {
+		return err
+	}
+	serial, err := keyring.Decrypt(g.SerialNumber)
+	if err != nil {
+		return err
+	}
+	g.SerialIndex = keyring.BlindIndex(serial)
+	return nil
+}
+
+// serialColumns returns the stored serial number and blind index columns for a
+// serial number, for updates made with a map, which skip the encrypting serializer
+func serialColumns(serial string) (map[string]interface{}, error) {
+	keyring, err := fieldcrypt.Default()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := keyring.Encrypt(serial)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"serial_number": encrypted,
+		"serial_index":  keyring.BlindIndex(serial),
+	}, nil
+}
+
+// SearchGuns narrows a gun query to guns whose name contains term, or whose
+// serial number is exactly term, ignoring case
+func SearchGuns(query *gorm.DB, term string) *gorm.DB {
+	keyring, err := fieldcrypt.Default()
+	if err != nil {
+		query.AddError(err)
+		return query
+	}
+	return query.Where("name LIKE ? OR serial_index = ?", "%"+term+"%", keyring.BlindIndex(term))
+}
+
+// EncryptGunSerials encrypts serial numbers stored as plaintext, re-encrypts
+// those sealed with a key other than the primary key, and refreshes their blind
+// indexes, batchSize guns at a time. Soft deleted guns are included. It returns
+// how many guns were updated and can safely be run again.
+func EncryptGunSerials(db *gorm.DB, batchSize int) (int, error) {
+	keyring, err := fieldcrypt.Default()
+	if err != nil {
+		return 0, err
+	}
+
+	type serialRow struct {
+		ID           uint
+		SerialNumber sql.NullString
+		SerialIndex  sql.NullString
+	}
+
+	updated := 0
+	var lastID uint
+	for {
+		// Reading the table directly skips the serializer, so rows come back as stored
+		var rows []serialRow
+		if err := db.Table("guns").Select("id, serial_number, serial_index").
+			Where("id > ?", lastID).Order("id").Limit(batchSize).Scan(&rows).Error; err != nil {
+			return updated, err
+		}
+		if len(rows) == 0 {
+			return updated, nil
+		}
+
+		for _, row := range rows {
+			lastID = row.ID
+
+			serial, err := keyring.Decrypt(row.SerialNumber.String)
+			if err != nil {
+				return updated, fmt.Errorf("gun %d: %w", row.ID, err)
+			}
+			stored := row.SerialNumber.String
+			if keyring.NeedsRotation(stored) {
+				if stored, err = keyring.Encrypt(serial); err != nil {
+					return updated, fmt.Errorf("gun %d: %w", row.ID, err)
+				}
+			}
+			index := keyring.BlindIndex(serial)
+			if stored == row.SerialNumber.String && index == row.SerialIndex.String {
+				continue
+			}
+
+			if err := db.Table("guns").Where("id = ?", row.ID).UpdateColumns(map[string]interface{}{
+				"serial_number": stored,
+				"serial_index":  index,
+			}).Error; err != nil {
+				return updated, fmt.Errorf("gun %d: %w", row.ID, err)
+			}
+			updated++
+		}
+	}
+}
@@ -128,10 +128,17 @@ func UpdateGunWithValidation(db *gorm.DB, gun *Gun) error {
 		return err
 	}
 
+	// Map updates skip the serializer, so the serial number is encrypted here
+	serial, err := serialColumns(gun.SerialNumber)
+	if err != nil {
+		return err
+	}
+
 	// Update the gun with all fields
 	result := db.Model(&existingGun).Updates(map[string]interface{}{
 		"name":            gun.Name,
-		"serial_number":   gun.SerialNumber,
+		"serial_number":   serial["serial_number"],
+		"serial_index":    serial["serial_index"],
 		"purpose":         gun.Purpose,
 		"finish":          gun.Finish,
 		"acquired":        gun.Acquired,
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// InventoryReport records an insurance inventory issued to an owner. Only the
+// content hash and totals are stored, so anyone holding a copy of the report
+// can check it against the hash without the owner's collection being exposed.
+type InventoryReport struct {
+	gorm.Model
+	UserID      uint   `gorm:"index;not null"`
+	Hash        string `gorm:"size:64;uniqueIndex;not null"`
+	GunCount    int
+	TotalValue  float64
+	GeneratedAt time.Time
+
+	OwnerEmail string          `gorm:"-"` // The owner the report was issued to (not stored in DB)
+	Items      []InventoryItem `gorm:"-"` // The guns listed on the report (not stored in DB)
+}
+
+// TableName specifies the table name for the InventoryReport model
+func (InventoryReport) TableName() string {
+	return "inventory_reports"
+}
+
+// InventoryItem is one gun as it's listed on an inventory report
+type InventoryItem struct {
+	Make         string
+	Model        string
+	Caliber      string
+	WeaponType   string
+	SerialNumber string
+	Acquired     *time.Time
+	Paid         *float64
+}
+
+// NewInventoryReport builds the report of an owner's guns, sorted by make and model
+func NewInventoryReport(userID uint, email string, guns []Gun, generatedAt time.Time) *InventoryReport {
+	report := &InventoryReport{
+		UserID:      userID,
+		OwnerEmail:  email,
+		GunCount:    len(guns),
+		GeneratedAt: generatedAt.UTC().Truncate(time.Second),
+	}
+
+	for _, gun := range guns {
+		report.Items = append(report.Items, InventoryItem{
+			Make:         gun.Manufacturer.Name,
+			Model:        gun.Name,
+			Caliber:      gun.Caliber.Caliber,
+			WeaponType:   gun.WeaponType.Type,
+			SerialNumber: gun.SerialNumber,
+			Acquired:     gun.Acquired,
+			Paid:         gun.Paid,
+		})
+		if gun.Paid != nil {
+			report.TotalValue += *gun.Paid
+		}
+	}
+
+	sort.SliceStable(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if !strings.EqualFold(a.Make, b.Make) {
+			return strings.ToLower(a.Make) < strings.ToLower(b.Make)
+		}
+		return strings.ToLower(a.Model) < strings.ToLower(b.Model)
+	})
+
+	report.Hash = report.ContentHash()
+	return report
+}
+
+// ContentHash returns the SHA-256 of the report's contents in a fixed text form.
+// The same owner, guns and timestamp always give the same hash.
+func (r *InventoryReport) ContentHash() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "owner:%s\n", r.OwnerEmail)
+	fmt.Fprintf(&b, "generated:%s\n", r.GeneratedAt.UTC().Format(time.RFC3339))
+	for _, item := range r.Items {
+		acquired := ""
+		if item.Acquired != nil {
+			acquired = item.Acquired.Format("2006-01-02")
+		}
+		paid := ""
+		if item.Paid != nil {
+			paid = strconv.FormatFloat(*item.Paid, 'f', 2, 64)
+		}
+		fmt.Fprintf(&b, "gun:%s|%s|%s|%s|%s|%s\n", item.Make, item.Model, item.Caliber, item.SerialNumber, acquired, paid)
+	}
+	fmt.Fprintf(&b, "total:%s\n", strconv.FormatFloat(r.TotalValue, 'f', 2, 64))
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// UnpricedCount returns how many guns on the report have no purchase price
+func (r *InventoryReport) UnpricedCount() int {
+	count := 0
+	for _, item := range r.Items {
+		if item.Paid == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// CreateInventoryReport records that a report was issued
+func CreateInventoryReport(db *gorm.DB, report *InventoryReport) error {
+	return db.Create(report).Error
+}
+
+// FindInventoryReportByHash finds an issued report by its content hash
+func FindInventoryReportByHash(db *gorm.DB, hash string) (*InventoryReport, error) {
+	var report InventoryReport
+	if err := db.Where("hash = ?", strings.ToLower(strings.TrimSpace(hash))).First(&report).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// FormatDollars formats a dollar amount with thousands separators, e.g. $1,299.99
+func FormatDollars(value float64) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	digits := strconv.FormatFloat(value, 'f', 2, 64)
+	whole, cents := digits[:len(digits)-3], digits[len(digits)-3:]
+
+	var b strings.Builder
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + "$" + b.String() + cents
+}
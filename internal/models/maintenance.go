@@ -0,0 +1,293 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Maintenance record types
+const (
+	MaintenanceTypeCleaning        = "cleaning"
+	MaintenanceTypePartReplacement = "part_replacement"
+)
+
+// MaintenanceTypes lists the maintenance record types in display order
+var MaintenanceTypes = []string{MaintenanceTypeCleaning, MaintenanceTypePartReplacement}
+
+// MaintenanceTypeLabel returns the display label for a maintenance type
+func MaintenanceTypeLabel(maintenanceType string) string {
+	switch maintenanceType {
+	case MaintenanceTypeCleaning:
+		return "Cleaning"
+	case MaintenanceTypePartReplacement:
+		return "Part Replacement"
+	}
+	return maintenanceType
+}
+
+// MaintenanceRecord represents a cleaning or part replacement performed on a gun
+type MaintenanceRecord struct {
+	gorm.Model
+	GunID       uint   `gorm:"index;not null"`
+	Gun         Gun    `gorm:"foreignKey:GunID"`
+	OwnerID     uint   `gorm:"index;not null"`
+	Type        string `gorm:"size:30;not null"`
+	PerformedAt time.Time
+	RoundCount  int    // Lifetime round count of the gun when the work was performed
+	PartName    string `gorm:"size:100"`
+	Notes       string `gorm:"size:1000"`
+}
+
+// TableName specifies the table name for the MaintenanceRecord model
+func (MaintenanceRecord) TableName() string {
+	return "maintenance_records"
+}
+
+// ServiceInterval is an owner-defined schedule for a gun, e.g. "clean every 500 rounds".
+// An interval is satisfied by maintenance records of the same type, and for part
+// replacements, the same part name.
+type ServiceInterval struct {
+	gorm.Model
+	GunID           uint   `gorm:"index;not null"`
+	Gun             Gun    `gorm:"foreignKey:GunID"`
+	OwnerID         uint   `gorm:"index;not null"`
+	Description     string `gorm:"size:100;not null"`
+	MaintenanceType string `gorm:"size:30;not null"`
+	PartName        string `gorm:"size:100"`
+	EveryRounds     int    // Zero means the interval is not round based
+	EveryDays       int    // Zero means the interval is not time based
+}
+
+// TableName specifies the table name for the ServiceInterval model
+func (ServiceInterval) TableName() string {
+	return "service_intervals"
+}
+
+// ServiceStatus reports how far a gun is into one of its service intervals
+type ServiceStatus struct {
+	Interval    ServiceInterval
+	Gun         Gun
+	RoundsSince int
+	DaysSince   int
+	Due         bool
+}
+
+// RoundsRemaining returns the rounds left before a round based interval is due
+func (s ServiceStatus) RoundsRemaining() int {
+	if s.Interval.EveryRounds == 0 {
+		return 0
+	}
+	return s.Interval.EveryRounds - s.RoundsSince
+}
+
+// gunRangeDayItems scopes a query to a gun's line items on range days that haven't been deleted
+func gunRangeDayItems(db *gorm.DB, gunID uint) *gorm.DB {
+	return db.Model(&RangeDayItem{}).
+		Joins("JOIN range_days ON range_days.id = range_day_items.range_day_id AND range_days.deleted_at IS NULL").
+		Where("range_day_items.gun_id = ?", gunID)
+}
+
+// GunRoundCount returns the lifetime round count of a gun from its range days
+func GunRoundCount(db *gorm.DB, gunID uint) (int, error) {
+	var total int64
+	if err := gunRangeDayItems(db, gunID).
+		Select("COALESCE(SUM(range_day_items.shots_fired), 0)").Scan(&total).Error; err != nil {
+		return 0, err
+	}
+	return int(total), nil
+}
+
+// GunRoundCountAsOf returns the round count of a gun from range days on or before the given time
+func GunRoundCountAsOf(db *gorm.DB, gunID uint, asOf time.Time) (int, error) {
+	var total int64
+	if err := gunRangeDayItems(db, gunID).Where("range_days.date <= ?", asOf).
+		Select("COALESCE(SUM(range_day_items.shots_fired), 0)").Scan(&total).Error; err != nil {
+		return 0, err
+	}
+	return int(total), nil
+}
+
+// FindMaintenanceRecordsByGun retrieves a gun's maintenance records, most recent first
+func FindMaintenanceRecordsByGun(db *gorm.DB, gunID uint, ownerID uint) ([]MaintenanceRecord, error) {
+	var records []MaintenanceRecord
+	if err := db.Where("gun_id = ? AND owner_id = ?", gunID, ownerID).
+		Order("performed_at DESC, id DESC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// CreateMaintenanceRecord creates a maintenance record, stamping it with the gun's
+// round count as of the day the work was performed
+func CreateMaintenanceRecord(db *gorm.DB, record *MaintenanceRecord) error {
+	endOfDay := time.Date(record.PerformedAt.Year(), record.PerformedAt.Month(), record.PerformedAt.Day(), 23, 59, 59, 0, record.PerformedAt.Location())
+	roundCount, err := GunRoundCountAsOf(db, record.GunID, endOfDay)
+	if err != nil {
+		return err
+	}
+	record.RoundCount = roundCount
+
+	return db.Create(record).Error
+}
+
+// DeleteMaintenanceRecord deletes a maintenance record
+func DeleteMaintenanceRecord(db *gorm.DB, id uint, ownerID uint) error {
+	var record MaintenanceRecord
+	if err := db.Where("id = ?", id).First(&record).Error; err != nil {
+		return err
+	}
+
+	if record.OwnerID != ownerID {
+		return errors.New("not authorized: maintenance record does not belong to this user")
+	}
+
+	return db.Delete(&record).Error
+}
+
+// FindServiceIntervalsByGun retrieves a gun's service intervals
+func FindServiceIntervalsByGun(db *gorm.DB, gunID uint, ownerID uint) ([]ServiceInterval, error) {
+	var intervals []ServiceInterval
+	if err := db.Where("gun_id = ? AND owner_id = ?", gunID, ownerID).
+		Order("description ASC").Find(&intervals).Error; err != nil {
+		return nil, err
+	}
+	return intervals, nil
+}
+
+// CreateServiceInterval creates a service interval
+func CreateServiceInterval(db *gorm.DB, interval *ServiceInterval) error {
+	return db.Create(interval).Error
+}
+
+// DeleteServiceInterval deletes a service interval
+func DeleteServiceInterval(db *gorm.DB, id uint, ownerID uint) error {
+	var interval ServiceInterval
+	if err := db.Where("id = ?", id).First(&interval).Error; err != nil {
+		return err
+	}
+
+	if interval.OwnerID != ownerID {
+		return errors.New("not authorized: service interval does not belong to this user")
+	}
+
+	return db.Delete(&interval).Error
+}
+
+// Satisfies reports whether a maintenance record resets the interval
+func (i ServiceInterval) Satisfies(record MaintenanceRecord) bool {
+	if record.Type != i.MaintenanceType {
+		return false
+	}
+	if i.PartName == "" {
+		return true
+	}
+	return strings.EqualFold(strings.TrimSpace(record.PartName), strings.TrimSpace(i.PartName))
+}
+
+// ServiceStatusesForGun reports the status of each of a gun's service intervals.
+// Rounds and days are counted from the last matching maintenance record; without
+// one, every round on the gun counts and days are counted from when the interval
+// was set up.
+func ServiceStatusesForGun(db *gorm.DB, gun *Gun) ([]ServiceStatus, error) {
+	intervals, err := FindServiceIntervalsByGun(db, gun.ID, gun.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(intervals) == 0 {
+		return []ServiceStatus{}, nil
+	}
+
+	roundCount, err := GunRoundCount(db, gun.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := FindMaintenanceRecordsByGun(db, gun.ID, gun.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildServiceStatuses(*gun, intervals, records, roundCount, time.Now()), nil
+}
+
+// FindDueServices returns the service intervals that are due across all of an owner's guns
+func FindDueServices(db *gorm.DB, ownerID uint) ([]ServiceStatus, error) {
+	var intervals []ServiceInterval
+	if err := db.Preload("Gun").Where("owner_id = ?", ownerID).
+		Order("gun_id ASC, description ASC").Find(&intervals).Error; err != nil {
+		return nil, err
+	}
+
+	// Group intervals by gun so each gun's round count and records are loaded once
+	byGun := make(map[uint][]ServiceInterval)
+	var gunIDs []uint
+	for _, interval := range intervals {
+		// Skip intervals whose gun has been deleted
+		if interval.Gun.ID == 0 {
+			continue
+		}
+		if _, seen := byGun[interval.GunID]; !seen {
+			gunIDs = append(gunIDs, interval.GunID)
+		}
+		byGun[interval.GunID] = append(byGun[interval.GunID], interval)
+	}
+
+	due := []ServiceStatus{}
+	now := time.Now()
+	for _, gunID := range gunIDs {
+		gunIntervals := byGun[gunID]
+
+		roundCount, err := GunRoundCount(db, gunID)
+		if err != nil {
+			return nil, err
+		}
+
+		records, err := FindMaintenanceRecordsByGun(db, gunID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, status := range buildServiceStatuses(gunIntervals[0].Gun, gunIntervals, records, roundCount, now) {
+			if status.Due {
+				due = append(due, status)
+			}
+		}
+	}
+
+	return due, nil
+}
+
+// buildServiceStatuses computes interval statuses from a gun's records, which must be sorted most recent first
+func buildServiceStatuses(gun Gun, intervals []ServiceInterval, records []MaintenanceRecord, roundCount int, now time.Time) []ServiceStatus {
+	statuses := make([]ServiceStatus, 0, len(intervals))
+	for _, interval := range intervals {
+		interval.Gun = gun
+		status := ServiceStatus{Interval: interval, Gun: gun, RoundsSince: roundCount}
+		since := interval.CreatedAt
+
+		for _, record := range records {
+			if interval.Satisfies(record) {
+				status.RoundsSince = roundCount - record.RoundCount
+				since = record.PerformedAt
+				break
+			}
+		}
+
+		if status.RoundsSince < 0 {
+			status.RoundsSince = 0
+		}
+		status.DaysSince = int(now.Sub(since).Hours() / 24)
+		if status.DaysSince < 0 {
+			status.DaysSince = 0
+		}
+
+		status.Due = (interval.EveryRounds > 0 && status.RoundsSince >= interval.EveryRounds) ||
+			(interval.EveryDays > 0 && status.DaysSince >= interval.EveryDays)
+
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
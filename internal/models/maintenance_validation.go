@@ -0,0 +1,139 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidMaintenanceType is returned when the maintenance type isn't recognised
+	ErrInvalidMaintenanceType = errors.New("invalid maintenance type")
+
+	// ErrMaintenanceDateRequired is returned when a maintenance record has no date
+	ErrMaintenanceDateRequired = errors.New("maintenance date is required")
+
+	// ErrMaintenanceFutureDate is returned when the maintenance date is in the future
+	ErrMaintenanceFutureDate = errors.New("maintenance date cannot be in the future")
+
+	// ErrMaintenancePartRequired is returned when a part replacement doesn't name the part
+	ErrMaintenancePartRequired = errors.New("part name is required for a part replacement")
+
+	// ErrMaintenanceNotesTooLong is returned when notes exceed the maximum allowed length
+	ErrMaintenanceNotesTooLong = errors.New("maintenance notes exceed maximum length of 1000 characters")
+
+	// ErrInvalidMaintenanceGun is returned when the gun doesn't exist or doesn't belong to the owner
+	ErrInvalidMaintenanceGun = errors.New("invalid gun ID")
+
+	// ErrServiceIntervalDescriptionRequired is returned when a service interval has no description
+	ErrServiceIntervalDescriptionRequired = errors.New("service interval description is required")
+
+	// ErrServiceIntervalEmpty is returned when a service interval sets neither rounds nor days
+	ErrServiceIntervalEmpty = errors.New("service interval needs a round count or a number of days")
+
+	// ErrServiceIntervalNegative is returned when a service interval has a negative rounds or days value
+	ErrServiceIntervalNegative = errors.New("service interval rounds and days cannot be negative")
+)
+
+// isMaintenanceType reports whether t is a known maintenance type
+func isMaintenanceType(t string) bool {
+	for _, known := range MaintenanceTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMaintenanceGun checks that the gun exists and belongs to the owner
+func validateMaintenanceGun(db *gorm.DB, gunID uint, ownerID uint) error {
+	var count int64
+	if err := db.Model(&Gun{}).Where("id = ? AND owner_id = ?", gunID, ownerID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrInvalidMaintenanceGun
+	}
+	return nil
+}
+
+// Validate validates the MaintenanceRecord model
+func (m *MaintenanceRecord) Validate(db *gorm.DB) error {
+	// Validate type
+	if !isMaintenanceType(m.Type) {
+		return ErrInvalidMaintenanceType
+	}
+
+	// Validate date is present and not in the future
+	if m.PerformedAt.IsZero() {
+		return ErrMaintenanceDateRequired
+	}
+	if m.PerformedAt.After(time.Now()) {
+		return ErrMaintenanceFutureDate
+	}
+
+	// Part replacements must name the part
+	if m.Type == MaintenanceTypePartReplacement && strings.TrimSpace(m.PartName) == "" {
+		return ErrMaintenancePartRequired
+	}
+
+	// Validate notes length (max 1000 characters)
+	if len(m.Notes) > 1000 {
+		return ErrMaintenanceNotesTooLong
+	}
+
+	// Validate the gun if db is provided
+	if db != nil {
+		return validateMaintenanceGun(db, m.GunID, m.OwnerID)
+	}
+
+	return nil
+}
+
+// CreateMaintenanceRecordWithValidation creates a maintenance record with validation
+func CreateMaintenanceRecordWithValidation(db *gorm.DB, record *MaintenanceRecord) error {
+	if err := record.Validate(db); err != nil {
+		return err
+	}
+
+	return CreateMaintenanceRecord(db, record)
+}
+
+// Validate validates the ServiceInterval model
+func (i *ServiceInterval) Validate(db *gorm.DB) error {
+	// Validate description
+	if strings.TrimSpace(i.Description) == "" {
+		return ErrServiceIntervalDescriptionRequired
+	}
+
+	// Validate type
+	if !isMaintenanceType(i.MaintenanceType) {
+		return ErrInvalidMaintenanceType
+	}
+
+	// Validate rounds and days
+	if i.EveryRounds < 0 || i.EveryDays < 0 {
+		return ErrServiceIntervalNegative
+	}
+	if i.EveryRounds == 0 && i.EveryDays == 0 {
+		return ErrServiceIntervalEmpty
+	}
+
+	// Validate the gun if db is provided
+	if db != nil {
+		return validateMaintenanceGun(db, i.GunID, i.OwnerID)
+	}
+
+	return nil
+}
+
+// CreateServiceIntervalWithValidation creates a service interval with validation
+func CreateServiceIntervalWithValidation(db *gorm.DB, interval *ServiceInterval) error {
+	if err := interval.Validate(db); err != nil {
+		return err
+	}
+
+	return CreateServiceInterval(db, interval)
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrOIDCIdentityLinked is returned when linking an identity that's already linked to another user
+var ErrOIDCIdentityLinked = errors.New("this account is already linked to another user")
+
+// OIDCIdentity links a user to their account with an OpenID Connect provider,
+// so they can sign in with it. The provider's issuer and subject identify the
+// account; the email is kept only to show the user which account is linked.
+type OIDCIdentity struct {
+	gorm.Model
+	UserID     uint   `gorm:"index;not null"`
+	Issuer     string `gorm:"size:255;uniqueIndex:idx_oidc_identity_subject;not null"`
+	Subject    string `gorm:"size:255;uniqueIndex:idx_oidc_identity_subject;not null"`
+	Email      string `gorm:"size:255"`
+	LastUsedAt *time.Time
+}
+
+// TableName specifies the table name for the OIDCIdentity model
+func (OIDCIdentity) TableName() string {
+	return "oidc_identities"
+}
+
+// FindOIDCIdentity returns the identity for a provider account, or nil if it isn't linked
+func FindOIDCIdentity(db *gorm.DB, issuer, subject string) (*OIDCIdentity, error) {
+	var identity OIDCIdentity
+	err := db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// FindOIDCIdentitiesByUser returns the provider accounts linked to a user, oldest first
+func FindOIDCIdentitiesByUser(db *gorm.DB, userID uint) ([]OIDCIdentity, error) {
+	var identities []OIDCIdentity
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error
+	return identities, err
+}
+
+// LinkOIDCIdentity links a provider account to a user. Linking an account the
+// user already has is a no-op, and linking one another user has is refused.
+func LinkOIDCIdentity(db *gorm.DB, userID uint, issuer, subject, email string) (*OIDCIdentity, error) {
+	existing, err := FindOIDCIdentity(db, issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.UserID != userID {
+			return nil, ErrOIDCIdentityLinked
+		}
+		return existing, nil
+	}
+
+	identity := &OIDCIdentity{UserID: userID, Issuer: issuer, Subject: subject, Email: email}
+	if err := db.Create(identity).Error; err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// RecordOIDCIdentityUse notes when an identity was last used to sign in
+func RecordOIDCIdentityUse(db *gorm.DB, identity *OIDCIdentity, email string) error {
+	now := time.Now()
+	identity.LastUsedAt = &now
+	updates := map[string]interface{}{"last_used_at": now}
+	if email != "" {
+		identity.Email = email
+		updates["email"] = email
+	}
+	return db.Model(identity).Updates(updates).Error
+}
+
+// DeleteOIDCIdentity unlinks one of a user's provider accounts and returns it, or nil if they don't have it
+func DeleteOIDCIdentity(db *gorm.DB, userID, id uint) (*OIDCIdentity, error) {
+	var identity OIDCIdentity
+	err := db.Where("id = ? AND user_id = ?", id, userID).First(&identity).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Hard delete so the account can be linked again
+	if err := db.Unscoped().Delete(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestLinkOIDCIdentity(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&OIDCIdentity{}))
+
+	identity, err := LinkOIDCIdentity(db, 1, "https://id.example", "abc", "one@example.com")
+	require.NoError(t, err)
+
+	// Linking again is a no-op, but another user can't take the account
+	again, err := LinkOIDCIdentity(db, 1, "https://id.example", "abc", "one@example.com")
+	require.NoError(t, err)
+	assert.Equal(t, identity.ID, again.ID)
+	_, err = LinkOIDCIdentity(db, 2, "https://id.example", "abc", "two@example.com")
+	assert.ErrorIs(t, err, ErrOIDCIdentityLinked)
+
+	// The same subject at another provider is another account
+	_, err = LinkOIDCIdentity(db, 2, "https://other.example", "abc", "two@example.com")
+	require.NoError(t, err)
+
+	found, err := FindOIDCIdentity(db, "https://id.example", "abc")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, uint(1), found.UserID)
+	require.NoError(t, RecordOIDCIdentityUse(db, found, "new@example.com"))
+	found, _ = FindOIDCIdentity(db, "https://id.example", "abc")
+	assert.NotNil(t, found.LastUsedAt)
+	assert.Equal(t, "new@example.com", found.Email)
+
+	// Only the owner can unlink it, after which it can be linked again
+	deleted, err := DeleteOIDCIdentity(db, 2, identity.ID)
+	require.NoError(t, err)
+	assert.Nil(t, deleted)
+	deleted, err = DeleteOIDCIdentity(db, 1, identity.ID)
+	require.NoError(t, err)
+	require.NotNil(t, deleted)
+	identities, _ := FindOIDCIdentitiesByUser(db, 1)
+	assert.Empty(t, identities)
+	_, err = LinkOIDCIdentity(db, 2, "https://id.example", "abc", "two@example.com")
+	assert.NoError(t, err)
+}
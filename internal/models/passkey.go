@@ -0,0 +1,96 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Passkey is a WebAuthn credential a user has registered to sign in with
+// instead of their password. Only the public key is stored; the private key
+// never leaves the user's authenticator.
+type Passkey struct {
+	gorm.Model
+	UserID       uint   `gorm:"index;not null"`
+	UserHandle   string `gorm:"size:100;index"`                 // base64url, shared by all of a user's passkeys
+	CredentialID string `gorm:"size:1400;uniqueIndex;not null"` // base64url, as the browser sends it
+	PublicKey    []byte `gorm:"not null"`                       // COSE encoded
+	SignCount    uint32
+	Name         string `gorm:"size:100"`
+	LastUsedAt   *time.Time
+}
+
+// TableName specifies the table name for the Passkey model
+func (Passkey) TableName() string {
+	return "passkeys"
+}
+
+// FindPasskeysByUser returns a user's passkeys, oldest first
+func FindPasskeysByUser(db *gorm.DB, userID uint) ([]Passkey, error) {
+	var passkeys []Passkey
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&passkeys).Error
+	return passkeys, err
+}
+
+// PasskeyUserHandle returns the handle authenticators store a user's passkeys
+// under. It's random rather than the user's ID so a passkey doesn't give away
+// which account it's for, and every passkey a user adds shares the same one.
+func PasskeyUserHandle(db *gorm.DB, userID uint) (string, error) {
+	var passkey Passkey
+	err := db.Where("user_id = ? AND user_handle <> ''", userID).Order("created_at ASC").First(&passkey).Error
+	if err == nil {
+		return passkey.UserHandle, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	handle := make([]byte, 32)
+	if _, err := rand.Read(handle); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(handle), nil
+}
+
+// FindPasskeyByCredentialID returns the passkey with a credential ID, or nil if there isn't one
+func FindPasskeyByCredentialID(db *gorm.DB, credentialID string) (*Passkey, error) {
+	var passkey Passkey
+	err := db.Where("credential_id = ?", credentialID).First(&passkey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+// RecordPasskeyUse saves a passkey's new signature counter after it's used to sign in
+func RecordPasskeyUse(db *gorm.DB, passkey *Passkey, signCount uint32) error {
+	now := time.Now()
+	passkey.SignCount = signCount
+	passkey.LastUsedAt = &now
+	return db.Model(passkey).Updates(map[string]interface{}{
+		"sign_count":   signCount,
+		"last_used_at": now,
+	}).Error
+}
+
+// DeletePasskey removes one of a user's passkeys and returns it, or nil if they don't have it
+func DeletePasskey(db *gorm.DB, userID, id uint) (*Passkey, error) {
+	var passkey Passkey
+	err := db.Where("id = ? AND user_id = ?", id, userID).First(&passkey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Unscoped().Delete(&passkey).Error; err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
@@ -51,6 +51,20 @@ func GetPaymentsByUserID(db *gorm.DB, userID uint) ([]Payment, error) {
 	return payments, nil
 }
 
+// FindPaymentByStripeID returns the payment recorded for a Stripe object, such
+// as an invoice, or nil if there isn't one
+func FindPaymentByStripeID(db *gorm.DB, stripeID string) (*Payment, error) {
+	var payment Payment
+	err := db.Where("stripe_id = ?", stripeID).First(&payment).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
 // CreatePayment creates a new payment record
 func CreatePayment(db *gorm.DB, payment *Payment) error {
 	return db.Create(payment).Error
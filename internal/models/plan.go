@@ -0,0 +1,428 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// FreeTier is the tier every user is on until they subscribe, and goes back
+// to when a subscription ends
+const FreeTier = "free"
+
+// Plan billing intervals
+const (
+	PlanIntervalNone     = ""         // Not sold, e.g. the free tier
+	PlanIntervalMonth    = "month"    // Billed every month
+	PlanIntervalYear     = "year"     // Billed every year
+	PlanIntervalLifetime = "lifetime" // Paid once
+)
+
+var (
+	// ErrPlanTierInvalid is returned when a plan's tier isn't a lowercase slug
+	ErrPlanTierInvalid = errors.New("plan tier must be lowercase letters, numbers and underscores")
+
+	// ErrPlanTierTaken is returned when another plan already has the tier
+	ErrPlanTierTaken = errors.New("another plan already has this tier")
+
+	// ErrPlanNameRequired is returned when a plan has no name
+	ErrPlanNameRequired = errors.New("plan name is required")
+
+	// ErrPlanIntervalInvalid is returned when a plan's interval isn't one we bill
+	ErrPlanIntervalInvalid = errors.New("plan interval must be month, year or lifetime")
+
+	// ErrPlanPriceInvalid is returned when a plan's price doesn't suit its interval
+	ErrPlanPriceInvalid = errors.New("plans with an interval need a price, and plans without one must be free")
+
+	// ErrPlanFreeTierPaid is returned when the free tier is given a price
+	ErrPlanFreeTierPaid = errors.New("the free tier can't have a price")
+
+	// ErrPlanLimitInvalid is returned when a limit or quota is negative
+	ErrPlanLimitInvalid = errors.New("plan limits can't be negative")
+)
+
+var planTierPattern = regexp.MustCompile(`^[a-z0-9_]{1,50}$`)
+
+// Plan is a subscription tier: what it costs, how it's billed and what it
+// entitles its subscribers to. Users reference their plan by its tier.
+type Plan struct {
+	gorm.Model
+	Tier          string `gorm:"size:50;uniqueIndex;not null"` // Stored in users.subscription_tier
+	Name          string `gorm:"size:100;not null"`
+	Description   string `gorm:"size:255"`
+	Features      string `gorm:"type:text"`          // Shown on the pricing page, one per line
+	Price         int64  `gorm:"not null;default:0"` // In cents
+	Currency      string `gorm:"size:3;not null;default:'usd'"`
+	Interval      string `gorm:"size:20"`
+	StripePriceID string `gorm:"size:255"`           // Found by lookup key or created, then saved here, when empty
+	Rank          int    `gorm:"not null;default:0"` // Users can move to a plan with a higher rank
+	MaxGuns       int    `gorm:"not null;default:0"` // 0 means no limit
+	MaxAmmo       int    `gorm:"not null;default:0"` // Ammunition lots, 0 means no limit
+	StorageQuota  int64  `gorm:"not null;default:0"` // Bytes of photos
+	Active        bool   // Inactive plans can't be bought, but their subscribers keep them
+}
+
+// TableName specifies the table name for the Plan model
+func (Plan) TableName() string {
+	return "plans"
+}
+
+// Free reports whether the plan is the free tier
+func (p Plan) Free() bool {
+	return p.Tier == FreeTier
+}
+
+// Recurring reports whether the plan is billed every month or year
+func (p Plan) Recurring() bool {
+	return p.Interval == PlanIntervalMonth || p.Interval == PlanIntervalYear
+}
+
+// Lifetime reports whether the plan is paid for once and never ends
+func (p Plan) Lifetime() bool {
+	return p.Interval == PlanIntervalLifetime
+}
+
+// Purchasable reports whether users can check out the plan
+func (p Plan) Purchasable() bool {
+	return p.Active && p.Price > 0 && p.Interval != PlanIntervalNone
+}
+
+// Duration is how long one payment for the plan lasts
+func (p Plan) Duration() time.Duration {
+	switch p.Interval {
+	case PlanIntervalMonth:
+		return 30 * 24 * time.Hour
+	case PlanIntervalYear:
+		return 365 * 24 * time.Hour
+	case PlanIntervalLifetime:
+		return 20 * 365 * 24 * time.Hour
+	}
+	return 0
+}
+
+// PriceLabel formats the plan's price, e.g. "$5" or "$4.99"
+func (p Plan) PriceLabel() string {
+	if p.Price%100 == 0 {
+		return fmt.Sprintf("$%d", p.Price/100)
+	}
+	return fmt.Sprintf("$%d.%02d", p.Price/100, p.Price%100)
+}
+
+// IntervalLabel is shown after the price, e.g. "/mo"
+func (p Plan) IntervalLabel() string {
+	switch p.Interval {
+	case PlanIntervalMonth:
+		return "/mo"
+	case PlanIntervalYear:
+		return "/yr"
+	case PlanIntervalLifetime:
+		return "/lifetime"
+	}
+	return "/forever"
+}
+
+// FeatureList returns the plan's features, skipping blank lines
+func (p Plan) FeatureList() []string {
+	var features []string
+	for _, line := range strings.Split(p.Features, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			features = append(features, line)
+		}
+	}
+	return features
+}
+
+// Validate checks the plan's fields, and that no other plan has its tier
+func (p *Plan) Validate(db *gorm.DB) error {
+	p.Tier = strings.TrimSpace(p.Tier)
+	p.Name = strings.TrimSpace(p.Name)
+	if !planTierPattern.MatchString(p.Tier) {
+		return ErrPlanTierInvalid
+	}
+	if p.Name == "" {
+		return ErrPlanNameRequired
+	}
+	switch p.Interval {
+	case PlanIntervalNone, PlanIntervalMonth, PlanIntervalYear, PlanIntervalLifetime:
+	default:
+		return ErrPlanIntervalInvalid
+	}
+	if p.Free() && (p.Price != 0 || p.Interval != PlanIntervalNone) {
+		return ErrPlanFreeTierPaid
+	}
+	if p.Price < 0 || (p.Price > 0) != (p.Interval != PlanIntervalNone) {
+		return ErrPlanPriceInvalid
+	}
+	if p.MaxGuns < 0 || p.MaxAmmo < 0 || p.StorageQuota < 0 {
+		return ErrPlanLimitInvalid
+	}
+	if p.Currency == "" {
+		p.Currency = "usd"
+	}
+
+	var count int64
+	if err := db.Model(&Plan{}).Where("tier = ? AND id <> ?", p.Tier, p.ID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrPlanTierTaken
+	}
+	return nil
+}
+
+// DefaultPlans are the plans a new database is seeded with, and the catalog
+// until plans are loaded from the database
+func DefaultPlans() []Plan {
+	unlimited := "Unlimited guns/ammo\nUnlimited range days*\nUnlimited maintenance records*\n"
+	return []Plan{
+		{
+			Tier:         FreeTier,
+			Name:         "Free",
+			Description:  "Basic access",
+			Features:     "Store up to 2 guns\nStore up to 4 ammunition\nLimited range days*\nNo maintenance records*",
+			Currency:     "usd",
+			MaxGuns:      2,
+			MaxAmmo:      4,
+			StorageQuota: 25 << 20,
+			Active:       true,
+		},
+		{
+			Tier:         "monthly",
+			Name:         "Liking It",
+			Description:  "Flexible option",
+			Features:     unlimited + "Cancel anytime",
+			Price:        500,
+			Currency:     "usd",
+			Interval:     PlanIntervalMonth,
+			Rank:         10,
+			StorageQuota: 1 << 30,
+			Active:       true,
+		},
+		{
+			Tier:         "yearly",
+			Name:         "Loving It",
+			Description:  "Best value",
+			Features:     unlimited + "Cancel anytime",
+			Price:        3000,
+			Currency:     "usd",
+			Interval:     PlanIntervalYear,
+			Rank:         20,
+			StorageQuota: 1 << 30,
+			Active:       true,
+		},
+		{
+			Tier:         "lifetime",
+			Name:         "Supporter",
+			Description:  "Forever access",
+			Features:     unlimited + "First to access new features*",
+			Price:        10000,
+			Currency:     "usd",
+			Interval:     PlanIntervalLifetime,
+			Rank:         30,
+			StorageQuota: 2 << 30,
+			Active:       true,
+		},
+		{
+			Tier:        "premium_lifetime",
+			Name:        "Big Baller",
+			Description: "You shouldn't have, but thanks.",
+			Features: "Everything the site has.\n" +
+				"Christmas cards. Seriously, send your address and they are yours.\n" +
+				"If it grows and makers provide goodies, they go to you first. if we ever get spiff, you get spiff.\n" +
+				"We do not recommend anyone buy this package. But, this investment would help us grow and you get any benefit we can provide.",
+			Price:        100000,
+			Currency:     "usd",
+			Interval:     PlanIntervalLifetime,
+			Rank:         40,
+			StorageQuota: 5 << 30,
+			Active:       true,
+		},
+	}
+}
+
+// PlanCatalogTTL is how long the in-memory catalog is used before it's read
+// from the database again, so every instance sees plans another one saved
+const PlanCatalogTTL = time.Minute
+
+// The plan catalog is kept in memory, as the tier is checked on most pages
+var (
+	planCatalogMu       sync.RWMutex
+	planCatalog         = sortPlans(DefaultPlans())
+	planCatalogDB       *gorm.DB  // Where the catalog is loaded from, nil for the defaults
+	planCatalogLoadedAt time.Time // When it was last loaded
+	planCatalogReload   sync.Mutex
+)
+
+// LoadPlanCatalog replaces the in-memory catalog with the plans in the
+// database, and reloads it from there once it's older than PlanCatalogTTL.
+// Default plans that aren't stored are kept, and db can be nil to go back to
+// the defaults.
+func LoadPlanCatalog(db *gorm.DB) error {
+	plans := DefaultPlans()
+	if db != nil {
+		stored, err := FindPlans(db)
+		if err != nil {
+			return err
+		}
+		for _, plan := range stored {
+			replaced := false
+			for i := range plans {
+				if plans[i].Tier == plan.Tier {
+					plans[i], replaced = plan, true
+				}
+			}
+			if !replaced {
+				plans = append(plans, plan)
+			}
+		}
+	}
+
+	planCatalogMu.Lock()
+	planCatalog = sortPlans(plans)
+	planCatalogDB = db
+	planCatalogLoadedAt = time.Now()
+	planCatalogMu.Unlock()
+	return nil
+}
+
+// currentPlanCatalog returns the catalog, reloading it first if it's stale.
+// Only one caller reloads at a time, and the others use the stale catalog
+// meanwhile, as they do if the reload fails.
+func currentPlanCatalog() []Plan {
+	planCatalogMu.RLock()
+	db, stale := planCatalogDB, time.Since(planCatalogLoadedAt) > PlanCatalogTTL
+	planCatalogMu.RUnlock()
+
+	if db != nil && stale && planCatalogReload.TryLock() {
+		if err := LoadPlanCatalog(db); err != nil {
+			// Try again after another TTL rather than on every lookup
+			planCatalogMu.Lock()
+			planCatalogLoadedAt = time.Now()
+			planCatalogMu.Unlock()
+		}
+		planCatalogReload.Unlock()
+	}
+
+	planCatalogMu.RLock()
+	defer planCatalogMu.RUnlock()
+	return planCatalog
+}
+
+// CatalogPlans returns every plan in the catalog, lowest rank first
+func CatalogPlans() []Plan {
+	return append([]Plan(nil), currentPlanCatalog()...)
+}
+
+// LookupPlan returns the catalog's plan for a tier. Tiers that aren't plans,
+// such as promotions and admin grants, aren't found.
+func LookupPlan(tier string) (Plan, bool) {
+	for _, plan := range currentPlanCatalog() {
+		if plan.Tier == tier {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
+
+// FreePlan returns the catalog's free tier
+func FreePlan() Plan {
+	if plan, ok := LookupPlan(FreeTier); ok {
+		return plan
+	}
+	return DefaultPlans()[0]
+}
+
+// PlanName returns the name of the catalog's plan for a tier. Tiers that
+// aren't plans, such as promotions and admin grants, are named after the tier.
+func PlanName(tier string) string {
+	if plan, ok := LookupPlan(tier); ok {
+		return plan.Name
+	}
+	name := strings.ReplaceAll(tier, "_", " ")
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// HasUpgrade reports whether a plan ranked above the tier's plan is on sale.
+// Tiers that aren't plans can move to any plan on sale.
+func HasUpgrade(tier string) bool {
+	current, isPlan := LookupPlan(tier)
+	for _, plan := range CatalogPlans() {
+		if plan.Purchasable() && (!isPlan || plan.Rank > current.Rank) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortPlans orders plans by rank, then price
+func sortPlans(plans []Plan) []Plan {
+	sort.SliceStable(plans, func(i, j int) bool {
+		if plans[i].Rank != plans[j].Rank {
+			return plans[i].Rank < plans[j].Rank
+		}
+		return plans[i].Price < plans[j].Price
+	})
+	return plans
+}
+
+// FindPlans returns every stored plan, lowest rank first
+func FindPlans(db *gorm.DB) ([]Plan, error) {
+	var plans []Plan
+	err := db.Order("rank ASC, price ASC").Find(&plans).Error
+	return plans, err
+}
+
+// FindPlanByID returns a stored plan, or nil if there isn't one
+func FindPlanByID(db *gorm.DB, id uint) (*Plan, error) {
+	var plan Plan
+	err := db.First(&plan, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// SavePlan validates and stores a plan, then reloads the catalog so every
+// tier check sees the change
+func SavePlan(db *gorm.DB, plan *Plan) error {
+	if err := plan.Validate(db); err != nil {
+		return err
+	}
+	if err := db.Save(plan).Error; err != nil {
+		return err
+	}
+	return LoadPlanCatalog(db)
+}
+
+// SetPlanStripePrice stores the Stripe price created for a plan as it's priced
+// now, then reloads the catalog. Only the price ID is written, and only while
+// the stored plan has none and is still priced the same, so neither an ID set
+// meanwhile nor an admin's edit is overwritten. Default plans that were never
+// stored are left as they are.
+func SetPlanStripePrice(db *gorm.DB, plan Plan, priceID string) error {
+	err := db.Model(&Plan{}).
+		Where(map[string]interface{}{
+			"tier":     plan.Tier,
+			"price":    plan.Price,
+			"currency": plan.Currency,
+			"interval": plan.Interval,
+		}).
+		Where("stripe_price_id = '' OR stripe_price_id IS NULL").
+		Update("stripe_price_id", priceID).Error
+	if err != nil {
+		return err
+	}
+	return LoadPlanCatalog(db)
+}
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupPlanTestDB creates a private in-memory database with the plans table,
+// going back to the default catalog when the test ends
+func setupPlanTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Plan{}))
+	t.Cleanup(func() { LoadPlanCatalog(nil) })
+	return db
+}
+
+func TestDefaultPlanCatalog(t *testing.T) {
+	require.NoError(t, LoadPlanCatalog(nil))
+
+	free := FreePlan()
+	assert.True(t, free.Free())
+	assert.False(t, free.Purchasable())
+	assert.Equal(t, 2, free.MaxGuns)
+	assert.Equal(t, 4, free.MaxAmmo)
+	assert.Equal(t, "$0", free.PriceLabel())
+	assert.Equal(t, "/forever", free.IntervalLabel())
+
+	monthly, ok := LookupPlan("monthly")
+	require.True(t, ok)
+	assert.True(t, monthly.Recurring())
+	assert.Equal(t, "$5", monthly.PriceLabel())
+	assert.Equal(t, 30*24*time.Hour, monthly.Duration())
+	assert.Zero(t, monthly.MaxGuns)
+
+	lifetime, ok := LookupPlan("premium_lifetime")
+	require.True(t, ok)
+	assert.True(t, lifetime.Lifetime())
+	assert.Equal(t, "$1000", lifetime.PriceLabel())
+	assert.Len(t, lifetime.FeatureList(), 4)
+
+	_, ok = LookupPlan("promotion")
+	assert.False(t, ok, "Promotions aren't plans")
+
+	var tiers []string
+	for _, plan := range CatalogPlans() {
+		tiers = append(tiers, plan.Tier)
+	}
+	assert.Equal(t, []string{"free", "monthly", "yearly", "lifetime", "premium_lifetime"}, tiers)
+
+	assert.Equal(t, "Liking It", PlanName("monthly"))
+	assert.Equal(t, "Admin grant", PlanName("admin_grant"), "Tiers that aren't plans are named after the tier")
+	assert.True(t, HasUpgrade("lifetime"))
+	assert.False(t, HasUpgrade("premium_lifetime"), "Nothing is ranked above the top plan")
+	assert.True(t, HasUpgrade("promotion"))
+}
+
+func TestPlanValidate(t *testing.T) {
+	db := setupPlanTestDB(t)
+	require.NoError(t, db.Create(&Plan{Tier: "monthly", Name: "Monthly", Price: 500, Interval: PlanIntervalMonth}).Error)
+
+	tests := []struct {
+		name string
+		plan Plan
+		err  error
+	}{
+		{"valid", Plan{Tier: "quarterly", Name: "Quarterly", Price: 1200, Interval: PlanIntervalYear}, nil},
+		{"tier with spaces", Plan{Tier: "two words", Name: "Two", Price: 100, Interval: PlanIntervalMonth}, ErrPlanTierInvalid},
+		{"tier taken", Plan{Tier: "monthly", Name: "Another", Price: 100, Interval: PlanIntervalMonth}, ErrPlanTierTaken},
+		{"no name", Plan{Tier: "nameless", Price: 100, Interval: PlanIntervalMonth}, ErrPlanNameRequired},
+		{"unknown interval", Plan{Tier: "weekly", Name: "Weekly", Price: 100, Interval: "week"}, ErrPlanIntervalInvalid},
+		{"interval without a price", Plan{Tier: "gratis", Name: "Gratis", Interval: PlanIntervalMonth}, ErrPlanPriceInvalid},
+		{"price without an interval", Plan{Tier: "tip", Name: "Tip", Price: 100}, ErrPlanPriceInvalid},
+		{"paid free tier", Plan{Tier: FreeTier, Name: "Free", Price: 100, Interval: PlanIntervalMonth}, ErrPlanFreeTierPaid},
+		{"negative limit", Plan{Tier: "odd", Name: "Odd", Price: 100, Interval: PlanIntervalMonth, MaxGuns: -1}, ErrPlanLimitInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.Validate(db)
+			if tt.err == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, "usd", tt.plan.Currency)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSavePlanReloadsCatalog(t *testing.T) {
+	db := setupPlanTestDB(t)
+
+	// Stored plans replace the defaults with their tier, and the rest are kept
+	free := DefaultPlans()[0]
+	free.MaxGuns = 5
+	require.NoError(t, SavePlan(db, &free))
+	plan, ok := LookupPlan(FreeTier)
+	require.True(t, ok)
+	assert.Equal(t, 5, plan.MaxGuns)
+	_, ok = LookupPlan("yearly")
+	assert.True(t, ok)
+
+	// A new plan is ranked among them
+	family := &Plan{Tier: "family", Name: "Family", Price: 900, Interval: PlanIntervalMonth, Rank: 15, Active: true}
+	require.NoError(t, SavePlan(db, family))
+	plans := CatalogPlans()
+	assert.Equal(t, "family", plans[2].Tier)
+	assert.True(t, plans[2].Purchasable())
+
+	// Taking it off sale keeps it in the catalog for its subscribers
+	family.Active = false
+	require.NoError(t, SavePlan(db, family))
+	plan, ok = LookupPlan("family")
+	require.True(t, ok)
+	assert.False(t, plan.Purchasable())
+
+	require.NoError(t, LoadPlanCatalog(nil))
+	_, ok = LookupPlan("family")
+	assert.False(t, ok)
+}
+
+func TestPlanCatalogReloadsWhenStale(t *testing.T) {
+	db := setupPlanTestDB(t)
+	require.NoError(t, LoadPlanCatalog(db))
+
+	// Another instance saves a plan
+	family := &Plan{Tier: "family", Name: "Family", Price: 900, Interval: PlanIntervalMonth, Rank: 15, Active: true}
+	require.NoError(t, db.Create(family).Error)
+	_, ok := LookupPlan("family")
+	assert.False(t, ok, "The catalog isn't read for every lookup")
+
+	// It's seen once the catalog is older than its TTL
+	planCatalogMu.Lock()
+	planCatalogLoadedAt = time.Now().Add(-PlanCatalogTTL - time.Second)
+	planCatalogMu.Unlock()
+	plan, ok := LookupPlan("family")
+	require.True(t, ok)
+	assert.Equal(t, "Family", plan.Name)
+}
+
+func TestSetPlanStripePrice(t *testing.T) {
+	db := setupPlanTestDB(t)
+	require.NoError(t, LoadPlanCatalog(db))
+	monthly, ok := LookupPlan("monthly")
+	require.True(t, ok)
+
+	// A default plan that was never stored isn't stored with the price
+	require.NoError(t, SetPlanStripePrice(db, monthly, "price_default"))
+	var count int64
+	db.Model(&Plan{}).Count(&count)
+	assert.Zero(t, count)
+
+	require.NoError(t, db.Create(&monthly).Error)
+	require.NoError(t, LoadPlanCatalog(db))
+
+	// An admin changes the price while the catalog still has the old one
+	require.NoError(t, db.Model(&Plan{}).Where("tier = ?", "monthly").Updates(map[string]interface{}{"name": "Renamed", "price": 700}).Error)
+	require.NoError(t, SetPlanStripePrice(db, monthly, "price_stale"))
+	var stored Plan
+	require.NoError(t, db.Where("tier = ?", "monthly").First(&stored).Error)
+	assert.Empty(t, stored.StripePriceID, "A price for the old amount isn't kept")
+	assert.Equal(t, "Renamed", stored.Name, "The admin's edit isn't overwritten")
+	assert.Equal(t, int64(700), stored.Price)
+
+	// A price for the plan as it's priced now is stored and reaches the catalog
+	monthly, _ = LookupPlan("monthly")
+	require.NoError(t, SetPlanStripePrice(db, monthly, "price_first"))
+	monthly, _ = LookupPlan("monthly")
+	assert.Equal(t, "price_first", monthly.StripePriceID)
+
+	// And one set meanwhile is kept
+	monthly.StripePriceID = ""
+	require.NoError(t, SetPlanStripePrice(db, monthly, "price_second"))
+	monthly, _ = LookupPlan("monthly")
+	assert.Equal(t, "price_first", monthly.StripePriceID)
+}
@@ -1,14 +1,18 @@
 package models
 
 import (
+	"sort"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// Range represents a shooting range location
+// Range represents a shooting range location. Ranges with an OwnerID are private
+// to that owner; ranges without one are public and curated by admins.
 type Range struct {
 	gorm.Model
+	OwnerID      uint `gorm:"index"`
 	RangeName    string
 	StreetNumber string
 	StreetName   string
@@ -16,6 +20,8 @@ type Range struct {
 	City         string
 	State        string `gorm:"size:2"`
 	Zip          string `gorm:"size:5"`
+	Latitude     *float64
+	Longitude    *float64
 }
 
 // TableName specifies the table name for the Range model
@@ -23,7 +29,39 @@ func (Range) TableName() string {
 	return "ranges"
 }
 
-// FindAllRanges retrieves all ranges from the database
+// IsPublic reports whether the range is on the shared public list
+func (r Range) IsPublic() bool {
+	return r.OwnerID == 0
+}
+
+// HasLocation reports whether the range has been placed on the map
+func (r Range) HasLocation() bool {
+	return r.Latitude != nil && r.Longitude != nil
+}
+
+// Street returns the range's street number and name, e.g. "123 Main St"
+func (r Range) Street() string {
+	return strings.TrimSpace(r.StreetNumber + " " + r.StreetName)
+}
+
+// Location returns the range's city and state, e.g. "Austin, TX"
+func (r Range) Location() string {
+	switch {
+	case r.City != "" && r.State != "":
+		return r.City + ", " + r.State
+	case r.City != "":
+		return r.City
+	default:
+		return r.State
+	}
+}
+
+// visibleRanges scopes a query to the public ranges and the user's private ones
+func visibleRanges(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Where("ranges.owner_id = 0 OR ranges.owner_id = ?", userID)
+}
+
+// FindAllRanges retrieves all ranges from the database, public and private
 func FindAllRanges(db *gorm.DB) ([]Range, error) {
 	var ranges []Range
 	if err := db.Find(&ranges).Error; err != nil {
@@ -32,6 +70,24 @@ func FindAllRanges(db *gorm.DB) ([]Range, error) {
 	return ranges, nil
 }
 
+// FindPublicRanges retrieves the public ranges ordered by name
+func FindPublicRanges(db *gorm.DB) ([]Range, error) {
+	var ranges []Range
+	if err := db.Where("owner_id = 0").Order("range_name ASC").Find(&ranges).Error; err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// FindRangesForUser retrieves the public ranges and the user's private ranges ordered by name
+func FindRangesForUser(db *gorm.DB, userID uint) ([]Range, error) {
+	var ranges []Range
+	if err := visibleRanges(db, userID).Order("range_name ASC").Find(&ranges).Error; err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
 // FindRangeByID retrieves a range by its ID
 func FindRangeByID(db *gorm.DB, id uint) (*Range, error) {
 	var r Range
@@ -41,6 +97,28 @@ func FindRangeByID(db *gorm.DB, id uint) (*Range, error) {
 	return &r, nil
 }
 
+// FindRangeForUser retrieves a range by its ID if it's public or belongs to the user
+func FindRangeForUser(db *gorm.DB, id uint, userID uint) (*Range, error) {
+	var r Range
+	if err := visibleRanges(db, userID).Where("id = ?", id).First(&r).Error; err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// FindRangeByNameForUser retrieves the public or private range the user can see with the
+// given name, city and state, ignoring case. It's used to avoid adding the same range twice.
+func FindRangeByNameForUser(db *gorm.DB, name, city, state string, userID uint) (*Range, error) {
+	var r Range
+	if err := visibleRanges(db, userID).
+		Where("LOWER(range_name) = ? AND LOWER(city) = ? AND LOWER(state) = ?",
+			strings.ToLower(name), strings.ToLower(city), strings.ToLower(state)).
+		Order("owner_id DESC").First(&r).Error; err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
 // CreateRange creates a new range in the database
 func CreateRange(db *gorm.DB, r *Range) error {
 	return db.Create(r).Error
@@ -63,6 +141,8 @@ func UpdateRange(db *gorm.DB, r *Range) error {
 		"city":          r.City,
 		"state":         r.State,
 		"zip":           r.Zip,
+		"latitude":      r.Latitude,
+		"longitude":     r.Longitude,
 		"updated_at":    r.UpdatedAt,
 	})
 	if result.Error != nil {
@@ -81,3 +161,90 @@ func DeleteRange(db *gorm.DB, id uint) error {
 
 	return db.Delete(&r).Error
 }
+
+// DeletePrivateRange deletes one of an owner's private ranges. Ranges that range
+// days were logged at are kept so the history isn't lost.
+func DeletePrivateRange(db *gorm.DB, id uint, ownerID uint) error {
+	var r Range
+	if err := db.Where("id = ?", id).First(&r).Error; err != nil {
+		return err
+	}
+
+	if r.IsPublic() || r.OwnerID != ownerID {
+		return ErrRangeNotOwned
+	}
+
+	inUse, err := RangeInUse(db, r.ID)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrRangeInUse
+	}
+
+	return db.Delete(&r).Error
+}
+
+// RangeInUse reports whether any range days were logged at the range
+func RangeInUse(db *gorm.DB, id uint) (bool, error) {
+	var count int64
+	if err := db.Model(&RangeDay{}).Where("range_id = ?", id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RangeHistory summarizes a user's range days at one range
+type RangeHistory struct {
+	Range      Range
+	Visits     int
+	Rounds     int
+	FirstVisit time.Time
+	LastVisit  time.Time
+}
+
+// FindRangeHistoryByUser summarizes the user's range days at each range they've
+// visited, ordered by location: state, then city, then range name
+func FindRangeHistoryByUser(db *gorm.DB, userID uint) ([]RangeHistory, error) {
+	rangeDays, err := FindRangeDaysByUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byRange := map[uint]*RangeHistory{}
+	history := []*RangeHistory{}
+	for _, rangeDay := range rangeDays {
+		entry, ok := byRange[rangeDay.RangeID]
+		if !ok {
+			entry = &RangeHistory{Range: rangeDay.Range, FirstVisit: rangeDay.Date, LastVisit: rangeDay.Date}
+			byRange[rangeDay.RangeID] = entry
+			history = append(history, entry)
+		}
+
+		entry.Visits++
+		entry.Rounds += rangeDay.TotalShots()
+		if rangeDay.Date.Before(entry.FirstVisit) {
+			entry.FirstVisit = rangeDay.Date
+		}
+		if rangeDay.Date.After(entry.LastVisit) {
+			entry.LastVisit = rangeDay.Date
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		a, b := history[i].Range, history[j].Range
+		if a.State != b.State {
+			return a.State < b.State
+		}
+		if a.City != b.City {
+			return a.City < b.City
+		}
+		return strings.ToLower(a.RangeName) < strings.ToLower(b.RangeName)
+	})
+
+	result := make([]RangeHistory, 0, len(history))
+	for _, entry := range history {
+		result = append(result, *entry)
+	}
+	return result, nil
+}
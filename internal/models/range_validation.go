@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRangeNameRequired is returned when a range has no name
+	ErrRangeNameRequired = errors.New("range name is required")
+
+	// ErrRangeNameTooLong is returned when a range name exceeds the maximum allowed length
+	ErrRangeNameTooLong = errors.New("range name exceeds maximum length of 100 characters")
+
+	// ErrInvalidRangeState is returned when the state isn't a two letter code
+	ErrInvalidRangeState = errors.New("range state must be a two letter code")
+
+	// ErrInvalidRangeZip is returned when the zip code isn't five digits
+	ErrInvalidRangeZip = errors.New("range zip code must be five digits")
+
+	// ErrInvalidRangeCoordinates is returned when only one coordinate is set or either is off the map
+	ErrInvalidRangeCoordinates = errors.New("range latitude and longitude must both be set and within range")
+
+	// ErrDuplicateRange is returned when the owner can already see a range with the same name and location
+	ErrDuplicateRange = errors.New("a range with this name already exists in this city")
+
+	// ErrRangeNotOwned is returned when an owner changes a public range or another owner's range
+	ErrRangeNotOwned = errors.New("not authorized: range does not belong to this user")
+
+	// ErrRangeInUse is returned when deleting a range that range days were logged at
+	ErrRangeInUse = errors.New("range has range days logged at it")
+)
+
+var (
+	rangeStatePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	rangeZipPattern   = regexp.MustCompile(`^[0-9]{5}$`)
+)
+
+// Validate validates the Range model
+func (r *Range) Validate(db *gorm.DB) error {
+	// Validate name is present (max 100 characters)
+	if strings.TrimSpace(r.RangeName) == "" {
+		return ErrRangeNameRequired
+	}
+	if len(r.RangeName) > 100 {
+		return ErrRangeNameTooLong
+	}
+
+	// Validate state and zip when given
+	if r.State != "" && !rangeStatePattern.MatchString(r.State) {
+		return ErrInvalidRangeState
+	}
+	if r.Zip != "" && !rangeZipPattern.MatchString(r.Zip) {
+		return ErrInvalidRangeZip
+	}
+
+	// Validate coordinates are set together and on the map
+	if (r.Latitude == nil) != (r.Longitude == nil) {
+		return ErrInvalidRangeCoordinates
+	}
+	if r.HasLocation() && (*r.Latitude < -90 || *r.Latitude > 90 || *r.Longitude < -180 || *r.Longitude > 180) {
+		return ErrInvalidRangeCoordinates
+	}
+
+	// Validate the name isn't already used at this location by a range the owner can see
+	if db != nil {
+		var count int64
+		if err := visibleRanges(db, r.OwnerID).Model(&Range{}).
+			Where("id <> ? AND LOWER(range_name) = ? AND LOWER(city) = ? AND LOWER(state) = ?",
+				r.ID, strings.ToLower(r.RangeName), strings.ToLower(r.City), strings.ToLower(r.State)).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDuplicateRange
+		}
+	}
+
+	return nil
+}
+
+// CreateRangeWithValidation creates a new range in the database with validation
+func CreateRangeWithValidation(db *gorm.DB, r *Range) error {
+	// Validate the range
+	if err := r.Validate(db); err != nil {
+		return err
+	}
+
+	return CreateRange(db, r)
+}
+
+// UpdateRangeWithValidation updates an existing range in the database with validation
+func UpdateRangeWithValidation(db *gorm.DB, r *Range) error {
+	// Validate the range
+	if err := r.Validate(db); err != nil {
+		return err
+	}
+
+	return UpdateRange(db, r)
+}
@@ -5,32 +5,88 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// RangeDay represents a user's visit to a range
+// RangeDay represents a user's session at a range. The guns and ammunition
+// used during the session are recorded as line items.
 type RangeDay struct {
 	gorm.Model
-	UserID     uint
-	RangeID    uint
-	Range      Range `gorm:"foreignKey:RangeID"`
-	Date       time.Time
-	Comments   string
-	ShotsFired int
+	UserID   uint
+	RangeID  uint
+	Range    Range `gorm:"foreignKey:RangeID"`
+	Date     time.Time
+	Comments string
+	Items    []RangeDayItem `gorm:"foreignKey:RangeDayID"`
+}
+
+// TableName specifies the table name for the RangeDay model
+func (RangeDay) TableName() string {
+	return "range_days"
+}
+
+// RangeDayItem represents one gun and ammunition lot fired during a range day
+type RangeDayItem struct {
+	gorm.Model
+	RangeDayID uint `gorm:"index"`
 	GunID      uint
 	Gun        Gun `gorm:"foreignKey:GunID"`
 	AmmoID     uint
 	Ammo       Ammo `gorm:"foreignKey:AmmoID"`
+	ShotsFired int
+	Notes      string
 }
 
-// TableName specifies the table name for the RangeDay model
-func (RangeDay) TableName() string {
-	return "range_days"
+// TableName specifies the table name for the RangeDayItem model
+func (RangeDayItem) TableName() string {
+	return "range_day_items"
 }
 
-// FindRangeDaysByUser retrieves all range day records for a user
+// TotalShots returns the shots fired across all of the range day's line items
+func (r RangeDay) TotalShots() int {
+	total := 0
+	for _, item := range r.Items {
+		total += item.ShotsFired
+	}
+	return total
+}
+
+// preloadRangeDay preloads a range day's range and line items with their gun and ammunition
+func preloadRangeDay(db *gorm.DB) *gorm.DB {
+	return db.Preload("Range").
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("id ASC") }).
+		Preload("Items.Gun").
+		Preload("Items.Ammo")
+}
+
+// FindRangeDaysByUser retrieves all range day records for a user, most recent first
 func FindRangeDaysByUser(db *gorm.DB, userID uint) ([]RangeDay, error) {
 	var rangeDays []RangeDay
-	if err := db.Preload("Range").Preload("Gun").Preload("Ammo").Where("user_id = ?", userID).Find(&rangeDays).Error; err != nil {
+	if err := preloadRangeDay(db).Where("user_id = ?", userID).Order("date DESC, id DESC").Find(&rangeDays).Error; err != nil {
+		return nil, err
+	}
+	return rangeDays, nil
+}
+
+// FindRangeDaysByUserOnDate retrieves all range day records for a user on a given calendar day
+func FindRangeDaysByUserOnDate(db *gorm.DB, userID uint, day time.Time) ([]RangeDay, error) {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+
+	var rangeDays []RangeDay
+	if err := preloadRangeDay(db).
+		Where("user_id = ? AND date >= ? AND date < ?", userID, start, end).
+		Order("date DESC, id DESC").Find(&rangeDays).Error; err != nil {
+		return nil, err
+	}
+	return rangeDays, nil
+}
+
+// FindRangeDaysByUserAtRange retrieves a user's range days at one range, most recent first
+func FindRangeDaysByUserAtRange(db *gorm.DB, userID uint, rangeID uint) ([]RangeDay, error) {
+	var rangeDays []RangeDay
+	if err := preloadRangeDay(db).Where("user_id = ? AND range_id = ?", userID, rangeID).
+		Order("date DESC, id DESC").Find(&rangeDays).Error; err != nil {
 		return nil, err
 	}
 	return rangeDays, nil
@@ -39,48 +95,80 @@ func FindRangeDaysByUser(db *gorm.DB, userID uint) ([]RangeDay, error) {
 // FindRangeDayByID retrieves a range day record by ID for a user
 func FindRangeDayByID(db *gorm.DB, id uint, userID uint) (*RangeDay, error) {
 	var rangeDay RangeDay
-	if err := db.Preload("Range").Preload("Gun").Preload("Ammo").Where("id = ? AND user_id = ?", id, userID).First(&rangeDay).Error; err != nil {
+	if err := preloadRangeDay(db).Where("id = ? AND user_id = ?", id, userID).First(&rangeDay).Error; err != nil {
 		return nil, err
 	}
 	return &rangeDay, nil
 }
 
-// CreateRangeDay creates a new range day record
+// CreateRangeDay creates a new range day record with its line items and adds each
+// item's shots fired to its ammunition's expended count in the same transaction
 func CreateRangeDay(db *gorm.DB, rangeDay *RangeDay) error {
-	return db.Create(rangeDay).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(rangeDay).Error; err != nil {
+			return err
+		}
+
+		if err := createRangeDayItems(tx, rangeDay); err != nil {
+			return err
+		}
+
+		return spendRangeDayItems(tx, rangeDay.Items)
+	})
 }
 
-// UpdateRangeDay updates an existing range day record
+// UpdateRangeDay updates an existing range day record, replacing its line items and
+// moving the difference in shots fired onto the ammunition in the same transaction
 func UpdateRangeDay(db *gorm.DB, rangeDay *RangeDay) error {
 	rangeDay.UpdatedAt = time.Now()
 
-	var existingRangeDay RangeDay
-	if err := db.First(&existingRangeDay, rangeDay.ID).Error; err != nil {
-		return err
-	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existingRangeDay RangeDay
+		if err := tx.First(&existingRangeDay, rangeDay.ID).Error; err != nil {
+			return err
+		}
+		var existingItems []RangeDayItem
+		if err := tx.Where("range_day_id = ?", rangeDay.ID).Find(&existingItems).Error; err != nil {
+			return err
+		}
 
-	result := db.Model(&existingRangeDay).Updates(map[string]interface{}{
-		"user_id":     rangeDay.UserID,
-		"range_id":    rangeDay.RangeID,
-		"date":        rangeDay.Date,
-		"comments":    rangeDay.Comments,
-		"shots_fired": rangeDay.ShotsFired,
-		"gun_id":      rangeDay.GunID,
-		"ammo_id":     rangeDay.AmmoID,
-		"updated_at":  rangeDay.UpdatedAt,
-	})
+		// Give the previous rounds back before spending the new ones so that
+		// switching ammunition or lowering shots fired can't trip the count check
+		if err := returnRangeDayItems(tx, existingItems); err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("range_day_id = ?", rangeDay.ID).Delete(&RangeDayItem{}).Error; err != nil {
+			return err
+		}
 
-	if result.Error != nil {
-		return result.Error
+		if err := tx.Model(&existingRangeDay).Updates(map[string]interface{}{
+			"user_id":    rangeDay.UserID,
+			"range_id":   rangeDay.RangeID,
+			"date":       rangeDay.Date,
+			"comments":   rangeDay.Comments,
+			"updated_at": rangeDay.UpdatedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := createRangeDayItems(tx, rangeDay); err != nil {
+			return err
+		}
+
+		return spendRangeDayItems(tx, rangeDay.Items)
+	})
+	if err != nil {
+		return err
 	}
 
-	return db.Preload("Range").Preload("Gun").Preload("Ammo").First(rangeDay, rangeDay.ID).Error
+	return preloadRangeDay(db).First(rangeDay, rangeDay.ID).Error
 }
 
-// DeleteRangeDay deletes a range day record
+// DeleteRangeDay deletes a range day record and its line items, returning their
+// shots fired to the ammunition's remaining count in the same transaction
 func DeleteRangeDay(db *gorm.DB, id uint, userID uint) error {
 	var rangeDay RangeDay
-	if err := db.Where("id = ?", id).First(&rangeDay).Error; err != nil {
+	if err := db.Preload("Items").Where("id = ?", id).First(&rangeDay).Error; err != nil {
 		return err
 	}
 
@@ -88,5 +176,110 @@ func DeleteRangeDay(db *gorm.DB, id uint, userID uint) error {
 		return errors.New("not authorized: range day does not belong to this user")
 	}
 
-	return db.Delete(&rangeDay).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := returnRangeDayItems(tx, rangeDay.Items); err != nil {
+			return err
+		}
+
+		if err := tx.Where("range_day_id = ?", rangeDay.ID).Delete(&RangeDayItem{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(&rangeDay).Error
+	})
+}
+
+// createRangeDayItems inserts the range day's line items as new rows. The
+// preloaded gun and ammunition are left alone so only the IDs are saved.
+func createRangeDayItems(tx *gorm.DB, rangeDay *RangeDay) error {
+	if len(rangeDay.Items) == 0 {
+		return nil
+	}
+
+	for i := range rangeDay.Items {
+		rangeDay.Items[i].ID = 0
+		rangeDay.Items[i].RangeDayID = rangeDay.ID
+	}
+	return tx.Omit(clause.Associations).Create(&rangeDay.Items).Error
+}
+
+// spendRangeDayItems adds each line item's shots fired to its ammunition's expended count
+func spendRangeDayItems(tx *gorm.DB, items []RangeDayItem) error {
+	for _, item := range items {
+		if err := adjustAmmoExpended(tx, item.AmmoID, item.ShotsFired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returnRangeDayItems gives each line item's shots fired back to its ammunition
+func returnRangeDayItems(tx *gorm.DB, items []RangeDayItem) error {
+	for _, item := range items {
+		if err := adjustAmmoExpended(tx, item.AmmoID, -item.ShotsFired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustAmmoExpended adds delta rounds to an ammunition lot's expended count.
+// Spending more rounds than the lot holds returns ErrRangeDayExceedsAmmo, while
+// returning rounds never takes the count below zero since Expended can also be
+// edited by hand.
+func adjustAmmoExpended(tx *gorm.DB, ammoID uint, delta int) error {
+	if delta == 0 || ammoID == 0 {
+		return nil
+	}
+
+	if delta < 0 {
+		return tx.Model(&Ammo{}).Where("id = ?", ammoID).
+			UpdateColumn("expended", gorm.Expr("CASE WHEN expended + ? < 0 THEN 0 ELSE expended + ? END", delta, delta)).Error
+	}
+
+	// Guard the increment in the UPDATE itself so concurrent range days can't overspend the lot
+	result := tx.Model(&Ammo{}).Where("id = ? AND expended + ? <= count", ammoID, delta).
+		UpdateColumn("expended", gorm.Expr("expended + ?", delta))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRangeDayExceedsAmmo
+	}
+
+	return nil
+}
+
+// legacyRangeDayColumns are the single gun columns range days had before line items
+var legacyRangeDayColumns = []string{"gun_id", "ammo_id", "shots_fired"}
+
+// MigrateRangeDayItems moves range days recorded with a single gun and ammunition
+// lot into line items, then drops the old columns. It runs in one transaction and
+// does nothing once the old columns are gone, so it's safe to call on every start.
+func MigrateRangeDayItems(db *gorm.DB) error {
+	if !db.Migrator().HasColumn(&RangeDay{}, "gun_id") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Copy every range day, including soft deleted ones, that doesn't have line items yet
+		if err := tx.Exec(`INSERT INTO range_day_items (created_at, updated_at, deleted_at, range_day_id, gun_id, ammo_id, shots_fired, notes)
+			SELECT created_at, updated_at, deleted_at, id, gun_id, ammo_id, COALESCE(shots_fired, 0), ''
+			FROM range_days
+			WHERE gun_id IS NOT NULL AND gun_id <> 0
+			AND NOT EXISTS (SELECT 1 FROM range_day_items WHERE range_day_items.range_day_id = range_days.id)`).Error; err != nil {
+			return err
+		}
+
+		for _, column := range legacyRangeDayColumns {
+			if !tx.Migrator().HasColumn(&RangeDay{}, column) {
+				continue
+			}
+			if err := tx.Migrator().DropColumn(&RangeDay{}, column); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrRangeDayDateRequired is returned when a range day has no date
+	ErrRangeDayDateRequired = errors.New("range day date is required")
+
+	// ErrRangeDayFutureDate is returned when the range day date is in the future
+	ErrRangeDayFutureDate = errors.New("range day date cannot be in the future")
+
+	// ErrRangeDayNegativeShots is returned when shots fired is negative
+	ErrRangeDayNegativeShots = errors.New("shots fired cannot be negative")
+
+	// ErrRangeDayCommentsTooLong is returned when comments exceed the maximum allowed length
+	ErrRangeDayCommentsTooLong = errors.New("range day comments exceed maximum length of 1000 characters")
+
+	// ErrInvalidRange is returned when the range ID doesn't exist or is another owner's private range
+	ErrInvalidRange = errors.New("invalid range ID")
+
+	// ErrInvalidRangeDayGun is returned when the gun doesn't exist or doesn't belong to the user
+	ErrInvalidRangeDayGun = errors.New("invalid gun ID")
+
+	// ErrInvalidRangeDayAmmo is returned when the ammo doesn't exist or doesn't belong to the user
+	ErrInvalidRangeDayAmmo = errors.New("invalid ammo ID")
+
+	// ErrRangeDayExceedsAmmo is returned when shots fired would spend more rounds than the ammo holds
+	ErrRangeDayExceedsAmmo = errors.New("shots fired exceed the rounds remaining in the selected ammunition")
+
+	// ErrRangeDayItemsRequired is returned when a range day has no guns
+	ErrRangeDayItemsRequired = errors.New("range day needs at least one gun")
+
+	// ErrRangeDayItemNotesTooLong is returned when a line item's notes exceed the maximum allowed length
+	ErrRangeDayItemNotesTooLong = errors.New("gun notes exceed maximum length of 500 characters")
+)
+
+// Validate validates the RangeDay model
+func (r *RangeDay) Validate(db *gorm.DB) error {
+	// Validate date is present
+	if r.Date.IsZero() {
+		return ErrRangeDayDateRequired
+	}
+
+	// Validate date (can't be in the future)
+	if r.Date.After(time.Now()) {
+		return ErrRangeDayFutureDate
+	}
+
+	// Validate comments length (max 1000 characters)
+	if len(r.Comments) > 1000 {
+		return ErrRangeDayCommentsTooLong
+	}
+
+	// Validate line items
+	if len(r.Items) == 0 {
+		return ErrRangeDayItemsRequired
+	}
+	for _, item := range r.Items {
+		// Validate shots fired (can't be negative)
+		if item.ShotsFired < 0 {
+			return ErrRangeDayNegativeShots
+		}
+
+		// Validate notes length (max 500 characters)
+		if len(item.Notes) > 500 {
+			return ErrRangeDayItemNotesTooLong
+		}
+	}
+
+	// Validate foreign keys if db is provided
+	if db != nil {
+		// Check RangeID is a public range or one of the user's own
+		var count int64
+		if err := visibleRanges(db, r.UserID).Model(&Range{}).Where("id = ?", r.RangeID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrInvalidRange
+		}
+
+		for _, item := range r.Items {
+			// Check GunID belongs to the same user
+			if err := db.Model(&Gun{}).Where("id = ? AND owner_id = ?", item.GunID, r.UserID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return ErrInvalidRangeDayGun
+			}
+
+			// Check AmmoID belongs to the same user
+			if err := db.Model(&Ammo{}).Where("id = ? AND owner_id = ?", item.AmmoID, r.UserID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return ErrInvalidRangeDayAmmo
+			}
+		}
+	}
+
+	return nil
+}
+
+// CreateRangeDayWithValidation creates a new range day record in the database with validation
+func CreateRangeDayWithValidation(db *gorm.DB, rangeDay *RangeDay) error {
+	// Validate the range day
+	if err := rangeDay.Validate(db); err != nil {
+		return err
+	}
+
+	// Create the range day, spending its rounds from the linked ammunition
+	return CreateRangeDay(db, rangeDay)
+}
+
+// UpdateRangeDayWithValidation updates an existing range day record in the database with validation
+func UpdateRangeDayWithValidation(db *gorm.DB, rangeDay *RangeDay) error {
+	// Validate the range day
+	if err := rangeDay.Validate(db); err != nil {
+		return err
+	}
+
+	return UpdateRangeDay(db, rangeDay)
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// RateLimitCounter holds the sliding window counts for one rate limited
+// client and endpoint, so every instance of the app shares the same limits
+type RateLimitCounter struct {
+	LimitKey      string    `gorm:"primaryKey;size:255"` // The client and endpoint being limited, e.g. "203.0.113.7:/login"
+	WindowStart   time.Time `gorm:"not null"`            // When the current window began
+	CurrentCount  int       `gorm:"not null;default:0"`  // Requests allowed in the current window
+	PreviousCount int       `gorm:"not null;default:0"`  // Requests allowed in the window before it
+	ExpiresAt     time.Time `gorm:"index;not null"`      // After this both windows are over and the counter can be removed
+}
+
+// TableName specifies the table name for the RateLimitCounter model
+func (RateLimitCounter) TableName() string {
+	return "rate_limit_counters"
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// RateLimitEndpointStat counts the requests to one endpoint that were checked
+// against a rate limit, and how many of them were blocked
+type RateLimitEndpointStat struct {
+	Path            string     `gorm:"primaryKey;size:255"`
+	TotalAttempts   int64      `gorm:"not null;default:0"`
+	BlockedAttempts int64      `gorm:"not null;default:0"`
+	LastBlock       *time.Time // When a request to the endpoint was last blocked
+}
+
+// TableName specifies the table name for the RateLimitEndpointStat model
+func (RateLimitEndpointStat) TableName() string {
+	return "rate_limit_endpoint_stats"
+}
+
+// RateLimitOffender counts how many times a client IP has been rate limited
+type RateLimitOffender struct {
+	IP              string     `gorm:"primaryKey;size:64"`
+	BlockedAttempts int        `gorm:"not null;default:0"`
+	PersistentSince *time.Time // Set, and moved forward, once the IP is blocked often enough to be a persistent abuser
+}
+
+// TableName specifies the table name for the RateLimitOffender model
+func (RateLimitOffender) TableName() string {
+	return "rate_limit_offenders"
+}
+
+// RateLimitBlockEvent is one request that was blocked by a rate limit. Only
+// the most recent are kept.
+type RateLimitBlockEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	IP        string    `gorm:"size:64;not null"`
+	Path      string    `gorm:"size:255;not null"`
+	UserAgent string    `gorm:"size:512"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+// TableName specifies the table name for the RateLimitBlockEvent model
+func (RateLimitBlockEvent) TableName() string {
+	return "rate_limit_blocks"
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a one-time code a user with two-factor authentication can
+// sign in with when they don't have their authenticator. Only a hash of the
+// code is stored; the user is shown the codes once, when they're generated.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index;not null"`
+	CodeHash string `gorm:"size:64;index;not null"`
+	UsedAt   *time.Time
+}
+
+// TableName specifies the table name for the RecoveryCode model
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+// HashRecoveryCode hashes a recovery code for storing or looking up, ignoring
+// case, spaces and dashes so it can be typed in however it was written down
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// ReplaceRecoveryCodes removes a user's recovery codes and saves new ones
+func ReplaceRecoveryCodes(db *gorm.DB, userID uint, codes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := DeleteRecoveryCodes(tx, userID); err != nil {
+			return err
+		}
+		if len(codes) == 0 {
+			return nil
+		}
+		records := make([]RecoveryCode, len(codes))
+		for i, code := range codes {
+			records[i] = RecoveryCode{UserID: userID, CodeHash: HashRecoveryCode(code)}
+		}
+		return tx.Create(&records).Error
+	})
+}
+
+// DeleteRecoveryCodes removes all of a user's recovery codes
+func DeleteRecoveryCodes(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+// UseRecoveryCode marks a user's unused recovery code as used, reporting
+// whether it was one
+func UseRecoveryCode(db *gorm.DB, userID uint, code string) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// CountUnusedRecoveryCodes returns how many of a user's recovery codes are left
+func CountUnusedRecoveryCodes(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Security event types
+const (
+	SecurityEventAccountLocked      = "account_locked"
+	SecurityEventAccountUnlocked    = "account_unlocked"
+	SecurityEventTwoFactorEnabled   = "two_factor_enabled"
+	SecurityEventTwoFactorDisabled  = "two_factor_disabled"
+	SecurityEventRecoveryCodesReset = "recovery_codes_regenerated"
+	SecurityEventRecoveryCodeUsed   = "recovery_code_used"
+	SecurityEventPasskeyAdded       = "passkey_added"
+	SecurityEventPasskeyRemoved     = "passkey_removed"
+	SecurityEventAPITokenCreated    = "api_token_created"
+	SecurityEventAPITokenRevoked    = "api_token_revoked"
+	SecurityEventOIDCLinked         = "oidc_linked"
+	SecurityEventOIDCUnlinked       = "oidc_unlinked"
+)
+
+// SecurityEvent records something that changed the security of a user's
+// account, such as it being locked after failed sign ins or unlocked again
+type SecurityEvent struct {
+	gorm.Model
+	UserID  uint   `gorm:"index;not null"`
+	Type    string `gorm:"size:50;index;not null"`
+	Detail  string `gorm:"size:255"`
+	ActorID uint   // The admin who made the change, or 0 when the user or the system did
+}
+
+// TableName specifies the table name for the SecurityEvent model
+func (SecurityEvent) TableName() string {
+	return "security_events"
+}
+
+// RecordSecurityEvent saves a security event for a user
+func RecordSecurityEvent(db *gorm.DB, userID uint, eventType string, detail string, actorID uint) error {
+	return db.Create(&SecurityEvent{
+		UserID:  userID,
+		Type:    eventType,
+		Detail:  detail,
+		ActorID: actorID,
+	}).Error
+}
+
+// FindSecurityEvents retrieves a user's most recent security events, newest first
+func FindSecurityEvents(db *gorm.DB, userID uint, limit int) ([]SecurityEvent, error) {
+	var events []SecurityEvent
+	err := db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
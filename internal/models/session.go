@@ -0,0 +1,116 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a browser session kept in the database. The browser's cookie
+// holds a random token; only its hash is stored, so the table can't be used
+// to take over a session.
+type Session struct {
+	ID         uint      `gorm:"primaryKey"`
+	TokenHash  string    `gorm:"size:64;uniqueIndex;not null"`
+	UserID     uint      `gorm:"index"` // 0 until someone signs in
+	Data       []byte    // The session's values, gob encoded
+	UserAgent  string    `gorm:"size:255"`
+	IPAddress  string    `gorm:"size:45"`
+	CreatedAt  time.Time `gorm:"not null"`
+	LastSeenAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+}
+
+// TableName specifies the table name for the Session model
+func (Session) TableName() string {
+	return "sessions"
+}
+
+// HashSessionToken hashes a session cookie's token for storing or looking up
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Device describes the browser and operating system the session was started
+// from, such as "Firefox on Windows"
+func (s Session) Device() string {
+	agent := s.UserAgent
+
+	browser := "Unknown browser"
+	// Order matters: Edge and Opera also say Chrome, and Chrome also says Safari
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(agent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	system := ""
+	for _, os := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(agent, os.token) {
+			system = os.name
+			break
+		}
+	}
+
+	if system == "" {
+		return browser
+	}
+	return browser + " on " + system
+}
+
+// FindSessionIDByToken returns the ID of the session a cookie's token belongs to, or 0 if there isn't one
+func FindSessionIDByToken(db *gorm.DB, token string) (uint, error) {
+	if token == "" {
+		return 0, nil
+	}
+	var session Session
+	err := db.Select("id").Where("token_hash = ?", HashSessionToken(token)).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	return session.ID, err
+}
+
+// FindActiveSessions returns a user's sessions that haven't expired, most recently used first
+func FindActiveSessions(db *gorm.DB, userID uint) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC, id DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession ends one of a user's sessions, reporting whether they had it
+func RevokeSession(db *gorm.DB, userID, id uint) (bool, error) {
+	result := db.Where("id = ? AND user_id = ?", id, userID).Delete(&Session{})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeUserSessions ends all of a user's sessions except the one with the
+// given ID, or all of them when it's 0, returning how many were ended
+func RevokeUserSessions(db *gorm.DB, userID, exceptID uint) (int64, error) {
+	result := db.Where("user_id = ? AND id <> ?", userID, exceptID).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Stripe webhook event statuses
+const (
+	StripeEventReceived   = "received"
+	StripeEventProcessing = "processing"
+	StripeEventProcessed  = "processed"
+	StripeEventFailed     = "failed"
+)
+
+// StripeEvent is a webhook event received from Stripe. Every event is kept
+// with its payload so a retried delivery is only processed once and a failed
+// one can be replayed.
+type StripeEvent struct {
+	gorm.Model
+	EventID       string `gorm:"size:255;uniqueIndex;not null"` // Stripe's evt_ ID
+	Type          string `gorm:"size:100;index"`
+	Payload       string `gorm:"type:text"`
+	Status        string `gorm:"size:20;index;not null;default:'received'"`
+	Attempts      int    `gorm:"not null;default:0"`
+	Error         string `gorm:"type:text"`
+	LastAttemptAt *time.Time
+	ProcessedAt   *time.Time
+}
+
+// TableName specifies the table name for the StripeEvent model
+func (StripeEvent) TableName() string {
+	return "stripe_events"
+}
+
+// Replayable reports whether an admin can process the event again
+func (e StripeEvent) Replayable() bool {
+	return e.Status == StripeEventFailed || e.Status == StripeEventReceived
+}
+
+// RecordStripeEvent stores an event the first time it's delivered and returns
+// the stored event on every delivery after that
+func RecordStripeEvent(db *gorm.DB, eventID, eventType string, payload []byte) (*StripeEvent, error) {
+	var event StripeEvent
+	err := db.Where(StripeEvent{EventID: eventID}).
+		Attrs(StripeEvent{Type: eventType, Payload: string(payload), Status: StripeEventReceived}).
+		FirstOrCreate(&event).Error
+	if err != nil {
+		// Another delivery of the same event may have stored it first
+		if findErr := db.Where("event_id = ?", eventID).First(&event).Error; findErr != nil {
+			return nil, err
+		}
+	}
+	return &event, nil
+}
+
+// ClaimStripeEvent marks an event as being processed, returning false if it
+// has already been processed or another delivery is processing it. An event
+// left processing for longer than stale, e.g. by a crash, can be claimed again.
+func ClaimStripeEvent(db *gorm.DB, event *StripeEvent, stale time.Duration) (bool, error) {
+	now := time.Now()
+	result := db.Model(&StripeEvent{}).
+		Where("id = ?", event.ID).
+		Where("status IN ? OR (status = ? AND last_attempt_at < ?)",
+			[]string{StripeEventReceived, StripeEventFailed}, StripeEventProcessing, now.Add(-stale)).
+		Updates(map[string]interface{}{
+			"status":          StripeEventProcessing,
+			"attempts":        gorm.Expr("attempts + 1"),
+			"last_attempt_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, db.First(event, event.ID).Error
+}
+
+// FinishStripeEvent records how processing a claimed event went
+func FinishStripeEvent(db *gorm.DB, event *StripeEvent, processErr error) error {
+	updates := map[string]interface{}{
+		"status": StripeEventProcessed,
+		"error":  "",
+	}
+	if processErr != nil {
+		updates["status"] = StripeEventFailed
+		updates["error"] = processErr.Error()
+	} else {
+		updates["processed_at"] = time.Now()
+	}
+	if err := db.Model(event).Updates(updates).Error; err != nil {
+		return err
+	}
+	return db.First(event, event.ID).Error
+}
+
+// FindStripeEventByID returns a stored event, or nil if there isn't one
+func FindStripeEventByID(db *gorm.DB, id uint) (*StripeEvent, error) {
+	var event StripeEvent
+	err := db.First(&event, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// FindStripeEvents returns the most recent events with a status, or with any
+// status when it's empty, newest first
+func FindStripeEvents(db *gorm.DB, status string, limit int) ([]StripeEvent, error) {
+	var events []StripeEvent
+	query := db.Order("created_at DESC, id DESC").Limit(limit)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	err := query.Find(&events).Error
+	return events, err
+}
+
+// CountStripeEventsByStatus returns how many events there are with each status
+func CountStripeEventsByStatus(db *gorm.DB) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	err := db.Model(&StripeEvent{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int64{}
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
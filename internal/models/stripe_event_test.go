@@ -0,0 +1,88 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupStripeEventTestDB creates a private in-memory database with the stripe_events table
+func setupStripeEventTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&StripeEvent{}))
+	return db
+}
+
+func TestRecordStripeEventOnce(t *testing.T) {
+	db := setupStripeEventTestDB(t)
+
+	first, err := RecordStripeEvent(db, "evt_1", "invoice.payment_succeeded", []byte(`{"id":"evt_1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, StripeEventReceived, first.Status)
+
+	again, err := RecordStripeEvent(db, "evt_1", "invoice.payment_succeeded", []byte(`{"id":"evt_1","retried":true}`))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, `{"id":"evt_1"}`, again.Payload)
+
+	var count int64
+	db.Model(&StripeEvent{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestClaimStripeEvent(t *testing.T) {
+	db := setupStripeEventTestDB(t)
+	event, err := RecordStripeEvent(db, "evt_1", "customer.subscription.deleted", []byte(`{}`))
+	require.NoError(t, err)
+
+	claimed, err := ClaimStripeEvent(db, event, time.Minute)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	assert.Equal(t, StripeEventProcessing, event.Status)
+	assert.Equal(t, 1, event.Attempts)
+
+	// A second delivery while the first is still processing
+	other, _ := RecordStripeEvent(db, "evt_1", "customer.subscription.deleted", []byte(`{}`))
+	claimed, err = ClaimStripeEvent(db, other, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	// A failed event can be claimed again
+	require.NoError(t, FinishStripeEvent(db, event, errors.New("user not found")))
+	assert.Equal(t, StripeEventFailed, event.Status)
+	assert.Equal(t, "user not found", event.Error)
+	assert.True(t, event.Replayable())
+	claimed, err = ClaimStripeEvent(db, event, time.Minute)
+	require.NoError(t, err)
+	require.True(t, claimed)
+	assert.Equal(t, 2, event.Attempts)
+
+	// A processed one can't
+	require.NoError(t, FinishStripeEvent(db, event, nil))
+	assert.Equal(t, StripeEventProcessed, event.Status)
+	assert.Empty(t, event.Error)
+	assert.NotNil(t, event.ProcessedAt)
+	claimed, err = ClaimStripeEvent(db, event, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, claimed)
+
+	// One left processing too long, e.g. by a crash, can be
+	stuck, _ := RecordStripeEvent(db, "evt_2", "customer.subscription.deleted", []byte(`{}`))
+	require.NoError(t, db.Model(stuck).Updates(map[string]interface{}{
+		"status":          StripeEventProcessing,
+		"last_attempt_at": time.Now().Add(-time.Hour),
+	}).Error)
+	claimed, err = ClaimStripeEvent(db, stuck, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, claimed)
+
+	counts, err := CountStripeEventsByStatus(db)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{StripeEventProcessed: 1, StripeEventProcessing: 1}, counts)
+}
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hail2skins/armory/internal/fieldcrypt/fieldcrypttest"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -21,6 +22,8 @@ var (
 // This avoids creating multiple database connections in tests
 func GetTestDB() *gorm.DB {
 	testDBOnce.Do(func() {
+		fieldcrypttest.Use()
+
 		// Create a temporary directory for the SQLite database
 		tempDir, err := os.MkdirTemp("", "armory-models-test-*")
 		if err != nil {
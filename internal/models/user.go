@@ -25,6 +25,9 @@ type User interface {
 	// IsVerified returns whether the user's email is verified
 	IsVerified() bool
 
+	// GetLockedUntil returns when the account unlocks, or the zero time when it isn't locked
+	GetLockedUntil() time.Time
+
 	// GetSubscriptionStatus returns the user's subscription status
 	GetSubscriptionStatus() string
 
@@ -0,0 +1,326 @@
+// Package oidc signs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE. The provider's endpoints and keys are
+// found through discovery, and the ID token it returns is checked against the
+// state, nonce and code verifier the sign in started with.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiscoveryPath is where a provider publishes its metadata, under its issuer URL
+const DiscoveryPath = "/.well-known/openid-configuration"
+
+// clockSkew is how far the provider's clock may be from ours when checking token times
+const clockSkew = time.Minute
+
+var (
+	ErrDiscovery      = errors.New("oidc: provider discovery failed")
+	ErrIssuerMismatch = errors.New("oidc: issuer does not match")
+	ErrNoPKCE         = errors.New("oidc: provider does not support PKCE with S256")
+	ErrTokenExchange  = errors.New("oidc: token exchange failed")
+	ErrInvalidToken   = errors.New("oidc: invalid ID token")
+	ErrUnsupportedAlg = errors.New("oidc: unsupported ID token signing algorithm")
+	ErrUnknownKey     = errors.New("oidc: ID token signed with an unknown key")
+	ErrInvalidSig     = errors.New("oidc: invalid ID token signature")
+	ErrAudience       = errors.New("oidc: ID token is for another client")
+	ErrExpired        = errors.New("oidc: ID token has expired")
+	ErrNonceMismatch  = errors.New("oidc: nonce does not match")
+)
+
+// encoding is the unpadded base64url encoding used for PKCE values and JWTs
+var encoding = base64.RawURLEncoding
+
+// Config identifies the provider and this site's client registration with it
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // Defaults to openid, email and profile
+}
+
+// Metadata is the part of a provider's discovery document the sign in uses
+type Metadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// Flow holds the secrets for one sign in, kept in the user's session between
+// sending them to the provider and their return
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// Claims are what the provider says about the user in the ID token
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	Expiry          float64  `json:"exp"`
+	IssuedAt        float64  `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   boolish  `json:"email_verified"`
+	Name            string   `json:"name"`
+}
+
+// audience is a JWT aud claim, which may be one string or several
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// boolish is a boolean claim, which some providers send as a string
+type boolish bool
+
+func (b *boolish) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = boolish(v)
+	case string:
+		*b = boolish(v == "true")
+	}
+	return nil
+}
+
+// Provider signs users in with one OpenID Connect provider. Its metadata and
+// keys are fetched when first needed and kept.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     map[string]interface{}
+}
+
+// NewProvider creates a provider for config, making requests with client
+func NewProvider(config Config, client *http.Client) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{config: config, client: client}
+}
+
+// Issuer returns the provider's issuer URL, which identifies users' accounts along with their subject
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// NewFlow creates the state, nonce and PKCE code verifier for a sign in
+func NewFlow() (Flow, error) {
+	var values [3]string
+	for i := range values {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return Flow{}, err
+		}
+		values[i] = encoding.EncodeToString(random)
+	}
+	return Flow{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a code verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return encoding.EncodeToString(sum[:])
+}
+
+// Discover returns the provider's metadata, fetching it the first time
+func (p *Provider) Discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata Metadata
+	if err := p.getJSON(ctx, p.config.Issuer+DiscoveryPath, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.config.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: missing endpoints", ErrDiscovery)
+	}
+	if !contains(metadata.CodeChallengeMethodsSupported, "S256") {
+		return nil, ErrNoPKCE
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthCodeURL returns the provider's page to send the user to, which sends
+// them back to redirectURL with a code once they've signed in
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL string, flow Flow) (string, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {CodeChallenge(flow.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades the code the user came back with for an ID token and returns
+// its claims, once the token is checked against the flow's nonce. The state
+// must already have been checked against the one the user came back with.
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code string, flow Flow) (*Claims, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"code_verifier": {flow.Verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenExchange, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTokenExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d: %s", ErrTokenExchange, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in the response", ErrTokenExchange)
+	}
+
+	return p.VerifyIDToken(ctx, token.IDToken, flow.Nonce)
+}
+
+// VerifyIDToken checks an ID token's signature and claims, and that it was
+// issued for the sign in with nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	payload, err := p.verifySignature(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != p.config.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+	if !contains(claims.Audience, p.config.ClientID) {
+		return nil, ErrAudience
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, ErrAudience
+	}
+	now := time.Now()
+	if claims.Expiry == 0 || now.After(time.Unix(int64(claims.Expiry), 0).Add(clockSkew)) {
+		return nil, ErrExpired
+	}
+	if time.Unix(int64(claims.IssuedAt), 0).After(now.Add(clockSkew)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return &claims, nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
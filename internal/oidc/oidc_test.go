@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRedirectURL = "https://armory.example/auth/oidc/callback"
+
+// signIn runs a sign in against the test provider as far as the exchange
+func signIn(t *testing.T, mock *oidctest.Provider, provider *Provider) (*Claims, error) {
+	ctx := context.Background()
+	flow, err := NewFlow()
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, testRedirectURL, flow)
+	require.NoError(t, err)
+
+	back, err := mock.Authorize(authURL)
+	require.NoError(t, err)
+	assert.Equal(t, flow.State, back.Query().Get("state"))
+	return provider.Exchange(ctx, testRedirectURL, back.Query().Get("code"), flow)
+}
+
+func TestSignIn(t *testing.T) {
+	mock := oidctest.New("armory", "secret")
+	defer mock.Close()
+	provider := NewProvider(Config{Issuer: mock.Issuer() + "/", ClientID: "armory", ClientSecret: "secret"}, nil)
+
+	claims, err := signIn(t, mock, provider)
+	require.NoError(t, err)
+	assert.Equal(t, mock.User.Subject, claims.Subject)
+	assert.Equal(t, mock.Issuer(), claims.Issuer)
+	assert.Equal(t, "oidc@example.com", claims.Email)
+	assert.True(t, bool(claims.EmailVerified))
+
+	authURL, err := provider.AuthCodeURL(context.Background(), testRedirectURL, Flow{State: "s", Nonce: "n", Verifier: "v"})
+	require.NoError(t, err)
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, CodeChallenge("v"), query.Get("code_challenge"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+}
+
+func TestRefusedTokens(t *testing.T) {
+	mock := oidctest.New("armory", "secret")
+	defer mock.Close()
+	provider := NewProvider(Config{Issuer: mock.Issuer(), ClientID: "armory", ClientSecret: "secret"}, nil)
+
+	tests := []struct {
+		name   string
+		claims func(map[string]interface{})
+		err    error
+	}{
+		{"wrong nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }, ErrNonceMismatch},
+		{"wrong audience", func(c map[string]interface{}) { c["aud"] = "someone-else" }, ErrAudience},
+		{"another authorized party", func(c map[string]interface{}) {
+			c["aud"] = []string{"armory", "someone-else"}
+			c["azp"] = "someone-else"
+		}, ErrAudience},
+		{"wrong issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example" }, ErrIssuerMismatch},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, ErrExpired},
+		{"no subject", func(c map[string]interface{}) { c["sub"] = "" }, ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.Claims = tt.claims
+			defer func() { mock.Claims = nil }()
+			_, err := signIn(t, mock, provider)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestVerifyIDTokenSignature(t *testing.T) {
+	mock := oidctest.New("armory", "secret")
+	defer mock.Close()
+	provider := NewProvider(Config{Issuer: mock.Issuer(), ClientID: "armory"}, nil)
+	ctx := context.Background()
+
+	claims := map[string]interface{}{
+		"iss": mock.Issuer(), "sub": "user", "aud": "armory", "nonce": "n",
+		"exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix(),
+	}
+	token := mock.Sign(claims)
+	_, err := provider.VerifyIDToken(ctx, token, "n")
+	require.NoError(t, err)
+
+	// A token signed by another key
+	other := oidctest.New("armory", "secret")
+	defer other.Close()
+	_, err = provider.VerifyIDToken(ctx, other.Sign(claims), "n")
+	assert.ErrorIs(t, err, ErrInvalidSig)
+
+	// An unsigned token
+	parts := strings.SplitN(token, ".", 2)
+	_, err = provider.VerifyIDToken(ctx, encoding.EncodeToString([]byte(`{"alg":"none"}`))+"."+parts[1], "n")
+	assert.ErrorIs(t, err, ErrUnsupportedAlg)
+
+	_, err = provider.VerifyIDToken(ctx, "not.a-token", "n")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestExchangeNeedsTheCodeVerifier(t *testing.T) {
+	mock := oidctest.New("armory", "secret")
+	defer mock.Close()
+	provider := NewProvider(Config{Issuer: mock.Issuer(), ClientID: "armory", ClientSecret: "secret"}, nil)
+	ctx := context.Background()
+
+	flow, _ := NewFlow()
+	authURL, _ := provider.AuthCodeURL(ctx, testRedirectURL, flow)
+	back, err := mock.Authorize(authURL)
+	require.NoError(t, err)
+	code := back.Query().Get("code")
+
+	// A stolen code is useless without the verifier
+	stolen := flow
+	stolen.Verifier = "guessed"
+	_, err = provider.Exchange(ctx, testRedirectURL, code, stolen)
+	assert.ErrorIs(t, err, ErrTokenExchange)
+
+	// And a code can only be used once
+	_, err = provider.Exchange(ctx, testRedirectURL, code, flow)
+	assert.ErrorIs(t, err, ErrTokenExchange)
+
+	wrongSecret := NewProvider(Config{Issuer: mock.Issuer(), ClientID: "armory", ClientSecret: "wrong"}, nil)
+	flow, _ = NewFlow()
+	authURL, _ = wrongSecret.AuthCodeURL(ctx, testRedirectURL, flow)
+	back, _ = mock.Authorize(authURL)
+	_, err = wrongSecret.Exchange(ctx, testRedirectURL, back.Query().Get("code"), flow)
+	assert.ErrorIs(t, err, ErrTokenExchange)
+}
+
+func TestDiscoveryRequiresPKCE(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"issuer":"` + server.URL + `","authorization_endpoint":"` + server.URL + `/authorize",` +
+			`"token_endpoint":"` + server.URL + `/token","jwks_uri":"` + server.URL + `/jwks",` +
+			`"code_challenge_methods_supported":["plain"]}`))
+	}))
+	defer server.Close()
+
+	_, err := NewProvider(Config{Issuer: server.URL, ClientID: "armory"}, nil).Discover(context.Background())
+	assert.ErrorIs(t, err, ErrNoPKCE)
+
+	// The document must be for the issuer it was fetched from
+	_, err = NewProvider(Config{Issuer: server.URL + "/tenant", ClientID: "armory"}, nil).Discover(context.Background())
+	assert.ErrorIs(t, err, ErrIssuerMismatch)
+}
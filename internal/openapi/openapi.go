@@ -0,0 +1,412 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Version is the version of the OpenAPI specification documents are written in
+const Version = "3.0.3"
+
+// Document is an OpenAPI document describing an API
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info describes the API as a whole
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations on one path, by lower case HTTP method
+type PathItem map[string]*Operation
+
+// Operation describes one method on one path
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter describes a path or query string parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes what an operation accepts in its request body
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes one of an operation's responses
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType gives the schema of a body in one content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the schemas and security schemes operations refer to
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes a way of signing requests
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema describes a JSON value
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Envelope documents a JSON object that wraps values in named members, such as
+// {"data": [...], "meta": {...}}. Each member's schema comes from its value's type.
+type Envelope map[string]interface{}
+
+// Route documents a route: what it's for, what it takes and what it returns.
+// Request, Response and Error are values of the Go types the bodies are encoded
+// from; their schemas are generated from the types.
+type Route struct {
+	Summary     string
+	Description string
+	Tag         string
+	Security    string      // Name of the security scheme the route needs, if any
+	Query       []Parameter // Query string parameters; path parameters come from the route
+	Request     interface{} // JSON request body
+	Response    interface{} // Successful response body, nil if there isn't one
+	ContentType string      // Of the successful response, when it isn't JSON
+	Status      int         // Of a successful response, 200 if not set
+	Error       interface{} // JSON body of the error responses
+	Errors      []int       // Statuses the route can fail with
+}
+
+// Generator builds an OpenAPI document from the routes registered on a router
+// under a prefix, and the documentation given for each of them
+type Generator struct {
+	router          *gin.Engine
+	prefix          string
+	info            Info
+	routes          map[string]Route
+	securitySchemes map[string]SecurityScheme
+}
+
+// NewGenerator creates a generator for the routes under prefix
+func NewGenerator(router *gin.Engine, prefix string, info Info) *Generator {
+	return &Generator{
+		router:          router,
+		prefix:          prefix,
+		info:            info,
+		routes:          make(map[string]Route),
+		securitySchemes: make(map[string]SecurityScheme),
+	}
+}
+
+// AddSecurityScheme adds a way of signing requests that routes can name
+func (g *Generator) AddSecurityScheme(name string, scheme SecurityScheme) {
+	g.securitySchemes[name] = scheme
+}
+
+// Describe documents the route for method and path, with the path written the
+// way it's registered with Gin
+func (g *Generator) Describe(method, path string, route Route) {
+	g.routes[method+" "+path] = route
+}
+
+// Generate builds the document from the routes registered under the prefix.
+// It also returns the registered routes that haven't been described, which are
+// left out of the document. Descriptions of routes that aren't registered are
+// ignored.
+func (g *Generator) Generate() (*Document, []string) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    g.info,
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas:         make(map[string]*Schema),
+			SecuritySchemes: g.securitySchemes,
+		},
+	}
+	schemas := newSchemaBuilder(doc.Components.Schemas)
+
+	routes := g.router.Routes()
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	var undocumented []string
+	for _, info := range routes {
+		if info.Path != g.prefix && !strings.HasPrefix(info.Path, g.prefix+"/") {
+			continue
+		}
+		route, ok := g.routes[info.Method+" "+info.Path]
+		if !ok {
+			undocumented = append(undocumented, info.Method+" "+info.Path)
+			continue
+		}
+
+		path, parameters := openAPIPath(info.Path)
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(info.Method)] = g.operation(schemas, info.Method, path, parameters, route)
+	}
+	return doc, undocumented
+}
+
+// operation builds the operation for a described route
+func (g *Generator) operation(schemas *schemaBuilder, method, path string, parameters []Parameter, route Route) *Operation {
+	op := &Operation{
+		Summary:     route.Summary,
+		Description: route.Description,
+		OperationID: operationID(method, path),
+		Parameters:  append(parameters, route.Query...),
+		Responses:   make(map[string]*Response),
+	}
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+	if route.Security != "" {
+		op.Security = []map[string][]string{{route.Security: {}}}
+	}
+	if route.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: schemas.forValue(route.Request)}},
+		}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &Response{Description: http.StatusText(status)}
+	if route.Response != nil {
+		contentType := route.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success.Content = map[string]MediaType{contentType: {Schema: schemas.forValue(route.Response)}}
+	}
+	op.Responses[strconv.Itoa(status)] = success
+
+	for _, status := range route.Errors {
+		failure := &Response{Description: http.StatusText(status)}
+		if route.Error != nil {
+			failure.Content = map[string]MediaType{"application/json": {Schema: schemas.forValue(route.Error)}}
+		}
+		op.Responses[strconv.Itoa(status)] = failure
+	}
+	return op
+}
+
+// openAPIPath turns a Gin path like /guns/:id into an OpenAPI path like
+// /guns/{id}, returning the parameters it has
+func openAPIPath(path string) (string, []Parameter) {
+	var parameters []Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+		name := segment[1:]
+		schema := &Schema{Type: "string"}
+		if name == "id" || strings.HasSuffix(name, "_id") {
+			schema = &Schema{Type: "integer"}
+		}
+		parameters = append(parameters, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+		segments[i] = "{" + name + "}"
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// operationID names an operation after its method and path, such as
+// getApiV1GunsId for GET /api/v1/guns/{id}
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// schemaBuilder generates schemas from Go types, adding a component for each
+// named struct type so it's described once and referred to everywhere
+type schemaBuilder struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemaBuilder(components map[string]*Schema) *schemaBuilder {
+	return &schemaBuilder{components: components, names: make(map[reflect.Type]string)}
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+)
+
+// forValue returns the schema of a value's type, or of an Envelope's members
+func (b *schemaBuilder) forValue(value interface{}) *Schema {
+	if envelope, ok := value.(Envelope); ok {
+		schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for name, member := range envelope {
+			schema.Properties[name] = b.forValue(member)
+		}
+		return schema
+	}
+	return b.forType(reflect.TypeOf(value))
+}
+
+// forType returns the schema of values of a type as encoding/json writes them
+func (b *schemaBuilder) forType(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case deletedAtType:
+		return &Schema{Type: "string", Format: "date-time", Nullable: true}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.forType(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.forType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.forType(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + b.component(t)}
+	}
+	// Interfaces can hold anything
+	return &Schema{}
+}
+
+// component adds the schema for a named struct type to the components, if it
+// isn't there yet, and returns its name
+func (b *schemaBuilder) component(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := componentName(t)
+	if _, taken := b.components[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	// Name the type before describing it, so types that refer to themselves work
+	b.names[t] = name
+	b.components[name] = &Schema{}
+	*b.components[name] = *b.structSchema(t)
+	return name
+}
+
+// componentName names a type's schema after the type. Unexported types lose
+// their lower case prefix, so a controller's apiGun is described as Gun.
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if i := strings.IndexFunc(name, unicode.IsUpper); i > 0 {
+		return name[i:]
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// structSchema describes a struct's exported fields by their JSON names,
+// taking in the fields of embedded structs such as gorm.Model
+func (b *schemaBuilder) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for property, s := range b.structSchema(embedded).Properties {
+					schema.Properties[property] = s
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := b.forType(field.Type)
+		if strings.Contains(options, "string") {
+			property = &Schema{Type: "string"}
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
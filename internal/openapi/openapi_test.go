@@ -0,0 +1,99 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type testOwner struct {
+	gorm.Model
+	Email string `json:"email"`
+}
+
+type apiWidget struct {
+	ID       uint       `json:"id"`
+	Name     string     `json:"name"`
+	Notes    *string    `json:"notes"`
+	Tags     []string   `json:"tags,omitempty"`
+	Owner    testOwner  `json:"owner"`
+	Parent   *apiWidget `json:"parent"`
+	Made     time.Time  `json:"made"`
+	internal string
+	Skipped  string `json:"-"`
+}
+
+func TestGenerate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	noop := func(c *gin.Context) {}
+	router.GET("/api/widgets", noop)
+	router.GET("/api/widgets/:id", noop)
+	router.DELETE("/api/widgets/:id", noop)
+	router.GET("/elsewhere", noop)
+
+	g := NewGenerator(router, "/api", Info{Title: "Test", Version: "1"})
+	g.AddSecurityScheme("token", SecurityScheme{Type: "http", Scheme: "bearer"})
+	g.Describe(http.MethodGet, "/api/widgets", Route{
+		Summary:  "List widgets",
+		Tag:      "Widgets",
+		Security: "token",
+		Response: Envelope{"data": []apiWidget{}},
+	})
+	g.Describe(http.MethodGet, "/api/widgets/:id", Route{
+		Summary:  "Get a widget",
+		Response: apiWidget{},
+		Error:    map[string]string{},
+		Errors:   []int{http.StatusNotFound},
+	})
+	g.Describe(http.MethodPost, "/api/unregistered", Route{Summary: "Not registered"})
+
+	doc, undocumented := g.Generate()
+	assert.Equal(t, Version, doc.OpenAPI)
+	assert.Equal(t, []string{"DELETE /api/widgets/:id"}, undocumented)
+	assert.NotContains(t, doc.Paths, "/elsewhere")
+	assert.NotContains(t, doc.Paths, "/api/unregistered")
+
+	// Path parameters come from the route
+	get := doc.Paths["/api/widgets/{id}"]["get"]
+	require.NotNil(t, get)
+	assert.Equal(t, "getApiWidgetsId", get.OperationID)
+	require.Len(t, get.Parameters, 1)
+	assert.Equal(t, Parameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer"}}, get.Parameters[0])
+	assert.Contains(t, get.Responses, "200")
+	assert.Contains(t, get.Responses, "404")
+	assert.Equal(t, "#/components/schemas/Widget", get.Responses["200"].Content["application/json"].Schema.Ref)
+
+	list := doc.Paths["/api/widgets"]["get"]
+	require.NotNil(t, list)
+	assert.Equal(t, []string{"Widgets"}, list.Tags)
+	assert.Equal(t, []map[string][]string{{"token": {}}}, list.Security)
+	data := list.Responses["200"].Content["application/json"].Schema.Properties["data"]
+	assert.Equal(t, "array", data.Type)
+	assert.Equal(t, "#/components/schemas/Widget", data.Items.Ref)
+
+	// Schemas follow the JSON encoding of the types
+	widget := doc.Components.Schemas["Widget"]
+	require.NotNil(t, widget)
+	assert.ElementsMatch(t, []string{"id", "name", "notes", "tags", "owner", "parent", "made"}, keys(widget.Properties))
+	assert.Equal(t, &Schema{Type: "string", Nullable: true}, widget.Properties["notes"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time"}, widget.Properties["made"])
+	assert.Equal(t, "#/components/schemas/Widget", widget.Properties["parent"].Ref)
+
+	owner := doc.Components.Schemas["Owner"]
+	require.NotNil(t, owner)
+	assert.ElementsMatch(t, []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "email"}, keys(owner.Properties))
+}
+
+func keys(m map[string]*Schema) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,81 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentStructure(t *testing.T) {
+	doc := New("Inventory (draft)")
+	doc.Created = time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+	doc.Text(50, 70, HelveticaBold, 18, "Page one")
+	doc.AddPage()
+	doc.Text(50, 70, Helvetica, 10, "Page two")
+	out := doc.Bytes()
+
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), "/Count 2")
+	assert.Contains(t, string(out), `/Title (Inventory \(draft\))`)
+	assert.Contains(t, string(out), "/CreationDate (D:20260314093000Z)")
+
+	// startxref points at the cross reference table, and each entry at its object
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	require.NotNil(t, match)
+	xref, _ := strconv.Atoi(string(match[1]))
+	require.True(t, bytes.HasPrefix(out[xref:], []byte("xref\n0 10\n")))
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	require.Len(t, entries, 9)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		assert.True(t, bytes.HasPrefix(out[offset:], []byte(strconv.Itoa(i+1)+" 0 obj\n")), "object %d", i+1)
+	}
+}
+
+func TestContentStream(t *testing.T) {
+	doc := New("")
+	doc.Text(50, 92, Helvetica, 10, `Serial (A\B)`)
+	doc.FillRect(50, 100, 512, 17, 0.9)
+	doc.AddPage()
+	doc.SetPage(1)
+	doc.Line(50, 730, 562, 730, 0.5)
+
+	match := regexp.MustCompile(`(?s)7 0 obj\n<< /Length \d+ /Filter /FlateDecode >>\nstream\n(.*?)\nendstream`).FindSubmatch(doc.Bytes())
+	require.NotNil(t, match)
+	reader, err := zlib.NewReader(bytes.NewReader(match[1]))
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+
+	assert.Equal(t, "BT /F1 10 Tf 50 700 Td (Serial \\(A\\\\B\\)) Tj ET\n"+
+		"q 0.9 g 50 675 512 17 re f Q\n"+
+		"0.5 w 50 62 m 562 62 l S\n", string(content), "SetPage draws on the earlier page")
+}
+
+func TestTextWidth(t *testing.T) {
+	assert.Equal(t, 5.56, TextWidth(Helvetica, 10, "0"))
+	assert.Equal(t, 6.11, TextWidth(HelveticaBold, 10, "b"))
+	assert.Equal(t, 10.0, TextWidth(Helvetica, 10, "…"))
+	assert.Equal(t, TextWidth(Helvetica, 10, "?"), TextWidth(Helvetica, 10, "世"), "Characters outside WinAnsi print as ?")
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "Glock 19", Truncate(Helvetica, 9, "Glock 19", 100))
+
+	cut := Truncate(Helvetica, 9, "Springfield Armory Hellcat Pro OSP", 60)
+	assert.LessOrEqual(t, TextWidth(Helvetica, 9, cut), 60.0)
+	assert.Regexp(t, `^Springfield\S*…$`, cut)
+}
+
+func TestEncode(t *testing.T) {
+	assert.Equal(t, []byte("Caf\xe9 \x93quoted\x94 \x80 ?"), encode("Café “quoted” € 世"))
+}
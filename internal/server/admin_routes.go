@@ -8,6 +8,7 @@ import (
 	"github.com/hail2skins/armory/internal/controller"
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/services/stripe"
 	"github.com/shaj13/go-guardian/v2/auth"
 )
 
@@ -29,6 +30,11 @@ func (s *Server) RegisterAdminRoutes(r *gin.Engine, authController *controller.A
 	adminGrainController := controller.NewAdminGrainController(s.db)
 	adminBrandController := controller.NewAdminBrandController(s.db)
 	adminMunitionsController := controller.NewAdminMunitionsController(s.db)
+	adminRangeController := controller.NewAdminRangeController(s.db)
+	adminStripeEventController := controller.NewAdminStripeEventController(s.db, stripe.NewService(s.db))
+
+	// Create admin plan controller
+	adminPlanController := controller.NewAdminPlanController(s.db)
 
 	// Create Stripe security controller
 	stripeSecurityController := controller.NewStripeSecurityController(s.ipFilterService)
@@ -120,6 +126,9 @@ func (s *Server) RegisterAdminRoutes(r *gin.Engine, authController *controller.A
 			c.Next()
 		})
 
+		// Users who hold a role may have to set up two-factor authentication first
+		adminGroup.Use(authController.TwoFactorPolicyMiddleware())
+
 		// TODO: Delete this commented code block in a future cleanup
 		// The FlexibleAuthorize approach used on individual routes makes this global admin check unnecessary
 		// If Casbin auth is available, also apply role-based access control for admin
@@ -353,6 +362,27 @@ func (s *Server) RegisterAdminRoutes(r *gin.Engine, authController *controller.A
 			}
 		}
 
+		// Public range routes
+		rangeGroup := adminGroup.Group("/ranges")
+		{
+			if casbinAuth != nil {
+				// Define routes with flexible Casbin authorization
+				rangeGroup.GET("", casbinAuth.FlexibleAuthorize("ranges", "read"), adminRangeController.Index)
+				rangeGroup.GET("/new", casbinAuth.FlexibleAuthorize("ranges", "write"), adminRangeController.New)
+				rangeGroup.POST("", casbinAuth.FlexibleAuthorize("ranges", "write"), adminRangeController.Create)
+				rangeGroup.GET("/:id/edit", casbinAuth.FlexibleAuthorize("ranges", "update"), adminRangeController.Edit)
+				rangeGroup.POST("/:id", casbinAuth.FlexibleAuthorize("ranges", "update"), adminRangeController.Update)
+				rangeGroup.POST("/:id/delete", casbinAuth.FlexibleAuthorize("ranges", "delete"), adminRangeController.Delete)
+			} else {
+				rangeGroup.GET("", adminRangeController.Index)
+				rangeGroup.GET("/new", adminRangeController.New)
+				rangeGroup.POST("", adminRangeController.Create)
+				rangeGroup.GET("/:id/edit", adminRangeController.Edit)
+				rangeGroup.POST("/:id", adminRangeController.Update)
+				rangeGroup.POST("/:id/delete", adminRangeController.Delete)
+			}
+		}
+
 		// ===== Promotion Routes =====
 		promotionGroup := adminGroup.Group("/promotions")
 		{
@@ -390,6 +420,7 @@ func (s *Server) RegisterAdminRoutes(r *gin.Engine, authController *controller.A
 				userGroup.POST("/:id", casbinAuth.FlexibleAuthorize("users", "update"), adminUserController.Update)
 				userGroup.POST("/:id/delete", casbinAuth.FlexibleAuthorize("users", "delete"), adminUserController.Delete)
 				userGroup.POST("/:id/restore", casbinAuth.FlexibleAuthorize("users", "update"), adminUserController.Restore)
+				userGroup.POST("/:id/unlock", casbinAuth.FlexibleAuthorize("users", "update"), adminUserController.Unlock)
 				userGroup.GET("/:id/grant-subscription", casbinAuth.FlexibleAuthorize("users", "update"), adminUserController.ShowGrantSubscription)
 				userGroup.POST("/:id/grant-subscription", casbinAuth.FlexibleAuthorize("users", "update"), adminUserController.GrantSubscription)
 			} else {
@@ -400,6 +431,7 @@ func (s *Server) RegisterAdminRoutes(r *gin.Engine, authController *controller.A
 				userGroup.POST("/:id", adminUserController.Update)
 				userGroup.POST("/:id/delete", adminUserController.Delete)
 				userGroup.POST("/:id/restore", adminUserController.Restore)
+				userGroup.POST("/:id/unlock", adminUserController.Unlock)
 				userGroup.GET("/:id/grant-subscription", adminUserController.ShowGrantSubscription)
 				userGroup.POST("/:id/grant-subscription", adminUserController.GrantSubscription)
 			}
@@ -475,6 +507,35 @@ func (s *Server) RegisterAdminRoutes(r *gin.Engine, authController *controller.A
 			adminGroup.GET("/payments-history", adminPaymentController.ShowPaymentsHistory)
 		}
 
+		// Received Stripe webhook events, with replay for the ones that failed
+		if casbinAuth != nil {
+			adminGroup.GET("/stripe-events", casbinAuth.FlexibleAuthorize("stripe", "read"), adminStripeEventController.Index)
+			adminGroup.GET("/stripe-events/:id", casbinAuth.FlexibleAuthorize("stripe", "read"), adminStripeEventController.Show)
+			adminGroup.POST("/stripe-events/:id/replay", casbinAuth.FlexibleAuthorize("stripe", "write"), adminStripeEventController.Replay)
+		} else {
+			adminGroup.GET("/stripe-events", adminStripeEventController.Index)
+			adminGroup.GET("/stripe-events/:id", adminStripeEventController.Show)
+			adminGroup.POST("/stripe-events/:id/replay", adminStripeEventController.Replay)
+		}
+
+		// Subscription plans
+		planGroup := adminGroup.Group("/plans")
+		{
+			if casbinAuth != nil {
+				planGroup.GET("", casbinAuth.FlexibleAuthorize("payments", "read"), adminPlanController.Index)
+				planGroup.GET("/new", casbinAuth.FlexibleAuthorize("payments", "write"), adminPlanController.New)
+				planGroup.POST("", casbinAuth.FlexibleAuthorize("payments", "write"), adminPlanController.Create)
+				planGroup.GET("/:id/edit", casbinAuth.FlexibleAuthorize("payments", "write"), adminPlanController.Edit)
+				planGroup.POST("/:id", casbinAuth.FlexibleAuthorize("payments", "write"), adminPlanController.Update)
+			} else {
+				planGroup.GET("", adminPlanController.Index)
+				planGroup.GET("/new", adminPlanController.New)
+				planGroup.POST("", adminPlanController.Create)
+				planGroup.GET("/:id/edit", adminPlanController.Edit)
+				planGroup.POST("/:id", adminPlanController.Update)
+			}
+		}
+
 		// ===== Dashboard Routes =====
 		if casbinAuth != nil {
 			adminGroup.GET("", casbinAuth.FlexibleAuthorize("dashboard", "read"), adminDashboardController.Dashboard)
@@ -6,7 +6,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
 	"github.com/hail2skins/armory/internal/services/stripe"
 )
 
@@ -47,7 +49,12 @@ func (s *Server) RegisterAPIRoutes(r *gin.Engine, ipFilterService stripe.IPFilte
 
 	// Add rate limiting statistics endpoint
 	admin.GET("/rate-limits", func(c *gin.Context) {
-		stats := middleware.GetRateLimitStats()
+		stats, err := s.rateLimitStore.Stats(c.Request.Context())
+		if err != nil {
+			logger.Error("Failed to load rate limit statistics", err, nil)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load rate limit statistics"})
+			return
+		}
 
 		// Format timestamps for better readability
 		formattedBlocks := make([]map[string]interface{}, 0, len(stats.RecentBlocks))
@@ -89,7 +96,11 @@ func (s *Server) RegisterAPIRoutes(r *gin.Engine, ipFilterService stripe.IPFilte
 
 	// Add rate limit reset endpoint (admin only)
 	admin.POST("/rate-limits/reset", func(c *gin.Context) {
-		middleware.ResetRateLimitStats()
+		if err := s.rateLimitStore.ResetStats(c.Request.Context()); err != nil {
+			logger.Error("Failed to reset rate limit statistics", err, nil)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset rate limit statistics"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "ok",
 			"message": "Rate limit statistics have been reset",
@@ -121,10 +132,48 @@ func (s *Server) RegisterAPIRoutes(r *gin.Engine, ipFilterService stripe.IPFilte
 		admin.GET("/stripe/ip-check", ipFilterAdmin.IsIPAllowed)
 	}
 
-	// Future API endpoints can be added here
-	// api.GET("/users", userController.ListUsersHandler)
-	// api.GET("/users/:id", userController.GetUserHandler)
-	// etc.
+	// Versioned JSON API for owners' data, signed with personal access tokens
+	RegisterAPIV1Routes(api.Group("/v1"), controller.NewAPIController(s.db))
+}
+
+// RegisterAPIV1Routes registers the versioned JSON API. Every route needs a
+// personal access token with the scope for the data it reads or changes.
+func RegisterAPIV1Routes(v1 *gin.RouterGroup, apiController *controller.APIController) {
+	v1.Use(apiController.Authenticate)
+
+	gunsRead := apiController.RequireScope(models.APIScopeGunsRead)
+	gunsWrite := apiController.RequireScope(models.APIScopeGunsWrite)
+	v1.GET("/guns", gunsRead, apiController.ListGuns)
+	v1.POST("/guns", gunsWrite, apiController.CreateGun)
+	v1.GET("/guns/:id", gunsRead, apiController.GetGun)
+	v1.PUT("/guns/:id", gunsWrite, apiController.UpdateGun)
+	v1.DELETE("/guns/:id", gunsWrite, apiController.DeleteGun)
+
+	ammoRead := apiController.RequireScope(models.APIScopeAmmoRead)
+	ammoWrite := apiController.RequireScope(models.APIScopeAmmoWrite)
+	v1.GET("/ammo", ammoRead, apiController.ListAmmo)
+	v1.POST("/ammo", ammoWrite, apiController.CreateAmmo)
+	v1.GET("/ammo/:id", ammoRead, apiController.GetAmmo)
+	v1.PUT("/ammo/:id", ammoWrite, apiController.UpdateAmmo)
+	v1.DELETE("/ammo/:id", ammoWrite, apiController.DeleteAmmo)
+
+	rangeDaysRead := apiController.RequireScope(models.APIScopeRangeDaysRead)
+	rangeDaysWrite := apiController.RequireScope(models.APIScopeRangeDaysWrite)
+	v1.GET("/range-days", rangeDaysRead, apiController.ListRangeDays)
+	v1.POST("/range-days", rangeDaysWrite, apiController.CreateRangeDay)
+	v1.GET("/range-days/:id", rangeDaysRead, apiController.GetRangeDay)
+	v1.PUT("/range-days/:id", rangeDaysWrite, apiController.UpdateRangeDay)
+	v1.DELETE("/range-days/:id", rangeDaysWrite, apiController.DeleteRangeDay)
+
+	referenceRead := apiController.RequireScope(models.APIScopeReferenceRead)
+	v1.GET("/manufacturers", referenceRead, apiController.ListManufacturers)
+	v1.GET("/calibers", referenceRead, apiController.ListCalibers)
+	v1.GET("/weapon-types", referenceRead, apiController.ListWeaponTypes)
+	v1.GET("/brands", referenceRead, apiController.ListBrands)
+	v1.GET("/grains", referenceRead, apiController.ListGrains)
+	v1.GET("/bullet-styles", referenceRead, apiController.ListBulletStyles)
+	v1.GET("/casings", referenceRead, apiController.ListCasings)
+	v1.GET("/ranges", referenceRead, apiController.ListRanges)
 }
 
 // healthHandler returns the health status of the application
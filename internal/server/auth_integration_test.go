@@ -261,6 +261,69 @@ func (m *MockDBWithContext) DeleteGun(db *gorm.DB, id uint, ownerID uint) error
 	return args.Error(0)
 }
 
+// UnlockUserByToken implements the database.Service interface
+func (m *MockDBWithContext) UnlockUserByToken(ctx context.Context, token string) (*database.User, error) {
+	args := m.Called(ctx, token)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.User), args.Error(1)
+}
+
+// UnlockUser implements the database.Service interface
+func (m *MockDBWithContext) UnlockUser(ctx context.Context, user *database.User, adminID uint) error {
+	args := m.Called(ctx, user, adminID)
+	return args.Error(0)
+}
+
+// FindSecurityEvents implements the database.Service interface
+func (m *MockDBWithContext) FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error) {
+	args := m.Called(userID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SecurityEvent), args.Error(1)
+}
+
+// EnableTwoFactor implements the database.Service interface
+func (m *MockDBWithContext) EnableTwoFactor(ctx context.Context, user *database.User, secret, code string) ([]string, error) {
+	args := m.Called(user, secret, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// VerifyTwoFactor implements the database.Service interface
+func (m *MockDBWithContext) VerifyTwoFactor(ctx context.Context, userID uint, code string) (*database.User, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*database.User), args.Error(1)
+}
+
+// DisableTwoFactor implements the database.Service interface
+func (m *MockDBWithContext) DisableTwoFactor(ctx context.Context, user *database.User, code string) error {
+	args := m.Called(user, code)
+	return args.Error(0)
+}
+
+// RegenerateRecoveryCodes implements the database.Service interface
+func (m *MockDBWithContext) RegenerateRecoveryCodes(ctx context.Context, user *database.User) ([]string, error) {
+	args := m.Called(user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// CountRecoveryCodes implements the database.Service interface
+func (m *MockDBWithContext) CountRecoveryCodes(userID uint) (int64, error) {
+	args := m.Called(userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // IsRecoveryExpired implements the database.Service interface
 func (m *MockDBWithContext) IsRecoveryExpired(ctx context.Context, token string) (bool, error) {
 	args := m.Called(ctx, token)
@@ -19,6 +19,12 @@ func (s *Server) RegisterAuthRoutes(r *gin.Engine, authController *controller.Au
 	// Auth routes
 	r.GET("/login", authController.LoginHandler)
 	r.POST("/login", authController.LoginHandler)
+	r.GET("/login/two-factor", authController.TwoFactorHandler)
+	r.POST("/login/two-factor", authController.TwoFactorHandler)
+	r.POST("/login/passkey/options", authController.PasskeyLoginOptionsHandler)
+	r.POST("/login/passkey", authController.PasskeyLoginHandler)
+	r.GET("/auth/oidc/login", authController.OIDCLoginHandler)
+	r.GET(controller.OIDCCallbackPath, authController.OIDCCallbackHandler)
 	r.GET("/register", authController.RegisterHandler)
 	r.POST("/register", authController.RegisterHandler)
 	r.GET("/logout", authController.LogoutHandler)
@@ -59,6 +65,7 @@ func (s *Server) RegisterAuthRoutes(r *gin.Engine, authController *controller.Au
 	})
 	r.POST("/resend-verification", authController.ResendVerificationHandler)
 	r.GET("/verify-email", authController.VerifyEmailHandler)
+	r.GET("/unlock-account", authController.UnlockAccountHandler)
 	r.GET("/reset-password", authController.ResetPasswordHandler)
 	r.POST("/reset-password", authController.ResetPasswordHandler)
 	r.GET("/reset-password/new", authController.ForgotPasswordHandler)
@@ -87,6 +94,7 @@ func setupAuthRenderFunctions(authController *controller.AuthController) {
 		// Add CSRF token for form protection
 		csrfToken := middleware.GetCSRFToken(c)
 		authData = authData.WithCSRFToken(csrfToken)
+		authData.OIDCProvider = authController.OIDCProviderName()
 
 		// Handle flash messages
 		authData = handleAuthFlashMessage(c, authData)
@@ -94,6 +102,21 @@ func setupAuthRenderFunctions(authController *controller.AuthController) {
 		auth.Login(authData).Render(c.Request.Context(), c.Writer)
 	}
 
+	// Two-factor render function
+	authController.RenderTwoFactor = func(c *gin.Context, d interface{}) {
+		authData := d.(data.AuthData)
+		// Set default title if not set
+		if authData.Title == "" {
+			authData.Title = "Two-Factor Authentication"
+		}
+
+		// Add CSRF token for form protection
+		csrfToken := middleware.GetCSRFToken(c)
+		authData = authData.WithCSRFToken(csrfToken)
+
+		auth.TwoFactor(authData).Render(c.Request.Context(), c.Writer)
+	}
+
 	// Register render function
 	authController.RenderRegister = func(c *gin.Context, d interface{}) {
 		authData := d.(data.AuthData)
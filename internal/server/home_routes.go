@@ -13,6 +13,9 @@ func (s *Server) RegisterHomeRoutes(r *gin.Engine, homeController *controller.Ho
 	r.GET("/contact", homeController.ContactHandler)
 	r.POST("/contact", homeController.ContactHandler)
 
+	// Anyone holding a printed inventory report can check its content hash
+	r.GET("/inventory/verify", homeController.InventoryVerifyHandler)
+
 	// Future routes can be added here:
 	// r.GET("/pricing", homeController.PricingHandler)
 }
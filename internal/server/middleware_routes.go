@@ -1,17 +1,19 @@
 package server
 
 import (
-	"log"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-contrib/cors"
-	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
+	apperrors "github.com/hail2skins/armory/internal/errors"
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
 	"github.com/newrelic/go-agent/v3/integrations/nrgin"
 )
 
@@ -84,18 +86,14 @@ func (s *Server) RegisterMiddleware(r *gin.Engine, authController *controller.Au
 	// Apply security headers
 	r.Use(securityHeaders())
 
-	// Set up sessions middleware with a cookie store
-	store := cookie.NewStore([]byte(os.Getenv("SESSION_SECRET")))
-	if os.Getenv("SESSION_SECRET") == "" {
-		log.Println("Warning: Using default session secret. This is not secure for production.")
-		store = cookie.NewStore([]byte("armory-session-secret"))
-	}
-	r.Use(sessions.Sessions("armory-session", store))
+	// Set up sessions middleware, keeping sessions in the database so owners can
+	// see where they're signed in and revoke sessions
+	r.Use(middleware.NewSessionStore(s.db.GetDB()).Sessions("armory-session"))
 
 	// Set up flash message middleware - moved before rate limiting
 	r.Use(FlashMiddleware())
 
-	// Set up CSRF protection middleware with exclusions for webhooks
+	// Set up CSRF protection middleware with exclusions for webhooks and the versioned API
 	r.Use(func(c *gin.Context) {
 		// Exclude webhook endpoints from CSRF protection
 		if strings.HasPrefix(c.Request.URL.Path, "/webhook") {
@@ -103,12 +101,25 @@ func (s *Server) RegisterMiddleware(r *gin.Engine, authController *controller.Au
 			return
 		}
 
+		// The versioned API is signed with tokens rather than cookies, so it can't be forged across sites
+		if apperrors.IsAPIRequest(c) {
+			c.Next()
+			return
+		}
+
 		// Apply CSRF middleware to all other routes
 		middleware.CSRFMiddleware()(c)
 	})
 
 	// Set up rate limiting middleware - moved after flash middleware
-	middleware.SetupRateLimiting(r)
+	// Counts are kept where RATE_LIMIT_STORE says, so replicas can share them
+	rateLimitStore, err := middleware.NewRateLimitStore(s.db.GetDB())
+	if err != nil {
+		logger.Error("Failed to set up the rate limit store, counting in memory instead", err, nil)
+		rateLimitStore = middleware.NewMemoryRateLimitStore()
+	}
+	s.rateLimitStore = rateLimitStore
+	middleware.SetupRateLimitingWithStore(r, rateLimitStore, s.rateLimitUser(authController))
 
 	// Apply webhook monitoring to webhook endpoints
 	r.Use(func(c *gin.Context) {
@@ -191,6 +202,96 @@ func (s *Server) RegisterMiddleware(r *gin.Engine, authController *controller.Au
 	})
 }
 
+// rateLimitUserTTL is how long the user behind a session or token is
+// remembered for rate limiting, so they aren't looked up on every request.
+// A change of subscription tier reaches their limits within it.
+const rateLimitUserTTL = time.Minute
+
+// rateLimitUserCacheSize caps how many users are remembered at once
+const rateLimitUserCacheSize = 10000
+
+// rateLimitUserCache remembers the users found for rate limiting by session
+// user ID or token
+type rateLimitUserCache struct {
+	mu    sync.Mutex
+	users map[string]cachedRateLimitUser
+}
+
+// cachedRateLimitUser is a user found for rate limiting and when to look them up again
+type cachedRateLimitUser struct {
+	user    middleware.RateLimitUser
+	expires time.Time
+}
+
+// get returns the user remembered under key, or loads and remembers them.
+// Requests that aren't from a user aren't remembered.
+func (r *rateLimitUserCache) get(key string, load func() (middleware.RateLimitUser, bool)) (middleware.RateLimitUser, bool) {
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.users[key]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.user, true
+	}
+
+	user, found := load()
+	if !found {
+		return user, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.users) >= rateLimitUserCacheSize {
+		for key, cached := range r.users {
+			if !now.Before(cached.expires) {
+				delete(r.users, key)
+			}
+		}
+	}
+	if len(r.users) < rateLimitUserCacheSize {
+		r.users[key] = cachedRateLimitUser{user: user, expires: now.Add(rateLimitUserTTL)}
+	}
+	return user, true
+}
+
+// rateLimitUser finds the signed in user making a request, for rate limits
+// keyed by user or varying by subscription tier. API requests are from the
+// owner of their bearer token, as the limits apply before the token is checked.
+func (s *Server) rateLimitUser(authController *controller.AuthController) middleware.RateLimitUserFunc {
+	cache := &rateLimitUserCache{users: map[string]cachedRateLimitUser{}}
+	byID := func(id uint) (middleware.RateLimitUser, bool) {
+		user, err := s.db.GetUserByID(id)
+		if err != nil || user == nil {
+			return middleware.RateLimitUser{}, false
+		}
+		return middleware.RateLimitUser{ID: user.ID, Tier: user.SubscriptionTier}, true
+	}
+
+	return func(c *gin.Context) (middleware.RateLimitUser, bool) {
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			token = strings.TrimSpace(token)
+			return cache.get("token:"+models.HashAPIToken(token), func() (middleware.RateLimitUser, bool) {
+				record, err := models.FindAPITokenByToken(s.db.GetDB(), token)
+				if err != nil || record == nil {
+					return middleware.RateLimitUser{}, false
+				}
+				return byID(record.UserID)
+			})
+		}
+
+		info, ok := authController.GetCurrentUser(c)
+		if !ok || info == nil {
+			return middleware.RateLimitUser{}, false
+		}
+		id, err := strconv.ParseUint(info.GetID(), 10, 64)
+		if err != nil {
+			return middleware.RateLimitUser{}, false
+		}
+		return cache.get("user:"+info.GetID(), func() (middleware.RateLimitUser, bool) {
+			return byID(uint(id))
+		})
+	}
+}
+
 // getAdminEmails returns the list of admin emails from environment variables or configuration
 func getAdminEmails() []string {
 	// Get admin emails from environment variable
@@ -4,13 +4,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
 	"github.com/hail2skins/armory/internal/database"
 	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
 	"github.com/hail2skins/armory/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // mockServer creates a test server with the necessary middleware
@@ -156,3 +159,37 @@ func TestRateLimitingIntegration(t *testing.T) {
 		assert.Equal(t, http.StatusTooManyRequests, w.Code, "Request should be rate limited")
 	})
 }
+
+func TestRateLimitUserFromBearerToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testDB := testutils.NewTestDB()
+	defer testDB.Close()
+	db := testutils.NewTestService(testDB.DB)
+	server := &Server{db: db}
+	rateLimitUser := server.rateLimitUser(controller.NewAuthController(db))
+
+	user := &database.User{Email: "ratelimit@example.com", Password: "Password123!", SubscriptionTier: "yearly"}
+	require.NoError(t, testDB.DB.Create(user).Error)
+	token, _, err := models.CreateAPIToken(testDB.DB, user.ID, "Script", []string{models.APIScopeGunsRead}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	request := func(authorization string) (middleware.RateLimitUser, bool) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/guns", nil)
+		c.Request.Header.Set("Authorization", authorization)
+		return rateLimitUser(c)
+	}
+
+	found, ok := request("Bearer " + token)
+	require.True(t, ok)
+	assert.Equal(t, middleware.RateLimitUser{ID: user.ID, Tier: "yearly"}, found)
+
+	// The user is remembered rather than looked up on every request
+	require.NoError(t, testDB.DB.Model(user).Update("subscription_tier", "monthly").Error)
+	found, ok = request("Bearer " + token)
+	require.True(t, ok)
+	assert.Equal(t, "yearly", found.Tier)
+
+	_, ok = request("Bearer armory_nope")
+	assert.False(t, ok, "Unknown tokens are limited by IP address")
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/openapi"
+	"github.com/hail2skins/armory/internal/services/stripe"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestOpenAPIDocumentsEveryAPIRoute fails when a route is registered under /api
+// without being described in the OpenAPI document
+func TestOpenAPIDocumentsEveryAPIRoute(t *testing.T) {
+	server := &Server{
+		db:              testutils.SharedTestService(),
+		ipFilterService: stripe.NewIPFilterService(nil),
+	}
+	router := server.RegisterRoutes().(*gin.Engine)
+
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &doc))
+	assert.Equal(t, openapi.Version, doc.OpenAPI)
+	assert.Contains(t, doc.Components.Schemas, "APIError")
+
+	documented := 0
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		path := route.Path
+		for _, segment := range strings.Split(path, "/") {
+			if strings.HasPrefix(segment, ":") {
+				path = strings.Replace(path, segment, "{"+segment[1:]+"}", 1)
+			}
+		}
+		item, ok := doc.Paths[path]
+		if assert.True(t, ok, "%s %s is missing from the OpenAPI document", route.Method, route.Path) {
+			assert.Contains(t, item, strings.ToLower(route.Method), "%s %s is missing from the OpenAPI document", route.Method, route.Path)
+			documented++
+		}
+	}
+	assert.Greater(t, documented, 30)
+
+	// The viewer loads the document
+	req, _ = http.NewRequest("GET", "/api/docs", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `data-spec-url="/api/openapi.json"`)
+}
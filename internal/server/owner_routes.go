@@ -4,7 +4,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
 	"github.com/hail2skins/armory/internal/services/email"
+	"github.com/hail2skins/armory/internal/services/entitlements"
 )
 
 // RegisterOwnerRoutes registers all owner-related routes
@@ -65,6 +67,37 @@ func RegisterOwnerRoutes(router *gin.Engine, db database.Service, authController
 		// Owner subscription management
 		ownerGroup.GET("/profile/subscription", ownerController.Subscription)
 
+		// Owner two-factor authentication
+		ownerGroup.GET("/profile/two-factor", ownerController.TwoFactor)
+		ownerGroup.POST("/profile/two-factor/enable", ownerController.TwoFactorEnable)
+		ownerGroup.POST("/profile/two-factor/disable", ownerController.TwoFactorDisable)
+		ownerGroup.POST("/profile/two-factor/recovery-codes", ownerController.TwoFactorRecoveryCodes)
+
+		// Owner passkeys
+		ownerGroup.GET("/profile/passkeys", ownerController.Passkeys)
+		ownerGroup.POST("/profile/passkeys/options", authController.PasskeyRegistrationOptionsHandler)
+		ownerGroup.POST("/profile/passkeys", authController.PasskeyRegisterHandler)
+		ownerGroup.POST("/profile/passkeys/:id/delete", ownerController.PasskeyDelete)
+
+		// Owner sessions
+		ownerGroup.GET("/profile/sessions", ownerController.Sessions)
+		ownerGroup.POST("/profile/sessions/revoke-others", ownerController.SessionsRevokeOthers)
+		ownerGroup.POST("/profile/sessions/:id/revoke", ownerController.SessionRevoke)
+
+		// Owner accounts linked through an OpenID Connect provider
+		ownerGroup.POST("/profile/oidc/link", authController.OIDCLinkHandler)
+		ownerGroup.POST("/profile/oidc/:id/unlink", ownerController.OIDCUnlink)
+
+		// Owner personal access tokens for the JSON API
+		ownerGroup.GET("/profile/api-tokens", ownerController.APITokens)
+		ownerGroup.POST("/profile/api-tokens", ownerController.APITokenCreate)
+		ownerGroup.POST("/profile/api-tokens/:id/delete", ownerController.APITokenDelete)
+
+		// Owner data export, emailed as a signed link once it's built
+		ownerGroup.GET("/profile/export", ownerController.DataExport)
+		ownerGroup.POST("/profile/export", ownerController.DataExportCreate)
+		ownerGroup.GET("/profile/export/:id/download", ownerController.DataExportDownload)
+
 		// Gun routes nested under owner
 		gunGroup := ownerGroup.Group("/guns")
 		{
@@ -74,8 +107,14 @@ func RegisterOwnerRoutes(router *gin.Engine, db database.Service, authController
 			// Arsenal view - shows all guns with sorting and searching
 			gunGroup.GET("/arsenal", ownerController.Arsenal)
 
+			// CSV export of every gun, for insurance paperwork
+			gunGroup.GET("/export.csv", ownerController.GunExport)
+
+			// Printable insurance inventory with a verifiable content hash
+			gunGroup.GET("/inventory.pdf", ownerController.GunInventoryPDF)
+
 			// Create a new gun
-			gunGroup.GET("/new", ownerController.New)
+			gunGroup.GET("/new", middleware.RequireAllowance(authController, db, entitlements.Guns), ownerController.New)
 			gunGroup.POST("", ownerController.Create)
 
 			// Show a specific gun
@@ -87,17 +126,35 @@ func RegisterOwnerRoutes(router *gin.Engine, db database.Service, authController
 
 			// Delete a gun
 			gunGroup.POST("/:id/delete", ownerController.Delete)
+
+			// Gun maintenance log
+			gunGroup.GET("/:id/maintenance", ownerController.MaintenanceIndex)
+			gunGroup.POST("/:id/maintenance", ownerController.MaintenanceCreate)
+			gunGroup.POST("/:id/maintenance/:record_id/delete", ownerController.MaintenanceDelete)
+
+			// Gun service intervals
+			gunGroup.POST("/:id/intervals", ownerController.ServiceIntervalCreate)
+			gunGroup.POST("/:id/intervals/:interval_id/delete", ownerController.ServiceIntervalDelete)
+
+			// Gun photos
+			gunGroup.POST("/:id/attachments", ownerController.GunAttachmentCreate)
 		}
 
+		// Maintenance overview across all guns
+		ownerGroup.GET("/maintenance", ownerController.MaintenanceOverview)
+
 		// Ammunition routes - no longer protected by permission middleware
 		// Making ammunition features available to all authenticated users
 		ammoGroup := ownerGroup.Group("/munitions")
 		{
 			// Index and Create ammunition
 			ammoGroup.GET("", ownerController.AmmoIndex)
-			ammoGroup.GET("/new", ownerController.AmmoNew)
+			ammoGroup.GET("/new", middleware.RequireAllowance(authController, db, entitlements.Ammo), ownerController.AmmoNew)
 			ammoGroup.POST("", ownerController.AmmoCreate)
 
+			// CSV export of every ammunition lot
+			ammoGroup.GET("/export.csv", ownerController.AmmoExport)
+
 			// Show ammunition details
 			ammoGroup.GET("/:id", ownerController.AmmoShow)
 
@@ -108,6 +165,9 @@ func RegisterOwnerRoutes(router *gin.Engine, db database.Service, authController
 			// Delete ammunition
 			ammoGroup.POST("/:id/delete", ownerController.AmmoDelete)
 
+			// Ammunition photos, such as receipts
+			ammoGroup.POST("/:id/attachments", ownerController.AmmoAttachmentCreate)
+
 			// Search routes for HTMX dropdown filters - Removed in favor of client-side filtering with Choices.js
 			// ammoGroup.GET("/search/brands", ownerController.SearchBrands)
 			// ammoGroup.GET("/search/calibers", ownerController.SearchCalibers)
@@ -115,5 +175,59 @@ func RegisterOwnerRoutes(router *gin.Engine, db database.Service, authController
 			// ammoGroup.GET("/search/grains", ownerController.SearchGrains)
 			// ammoGroup.GET("/search/casings", ownerController.SearchCasings)
 		}
+
+		// Photos attached to guns and ammunition, only ever sent to their owner
+		attachmentGroup := ownerGroup.Group("/attachments")
+		{
+			attachmentGroup.GET("/:id", ownerController.AttachmentShow)
+			attachmentGroup.GET("/:id/thumbnail", ownerController.AttachmentThumbnail)
+			attachmentGroup.POST("/:id/delete", ownerController.AttachmentDelete)
+		}
+
+		// CSV import of guns and ammunition, previewed before anything is saved
+		importGroup := ownerGroup.Group("/import")
+		{
+			importGroup.GET("", ownerController.ImportNew)
+			importGroup.POST("/preview", ownerController.ImportPreview)
+			importGroup.POST("", ownerController.ImportCreate)
+		}
+
+		// Range day routes nested under owner
+		rangeDayGroup := ownerGroup.Group("/range-days")
+		{
+			// Index (filterable by date) and Create range days
+			rangeDayGroup.GET("", ownerController.RangeDayIndex)
+			rangeDayGroup.GET("/new", ownerController.RangeDayNew)
+			rangeDayGroup.POST("", ownerController.RangeDayCreate)
+
+			// Show range day details
+			rangeDayGroup.GET("/:id", ownerController.RangeDayShow)
+
+			// Edit and Update range day
+			rangeDayGroup.GET("/:id/edit", ownerController.RangeDayEdit)
+			rangeDayGroup.POST("/:id", ownerController.RangeDayUpdate)
+
+			// Delete range day
+			rangeDayGroup.POST("/:id/delete", ownerController.RangeDayDelete)
+		}
+
+		// Range routes - owners keep private ranges alongside the public list
+		rangeGroup := ownerGroup.Group("/ranges")
+		{
+			// Index (history by location) and Create private ranges
+			rangeGroup.GET("", ownerController.RangeIndex)
+			rangeGroup.GET("/new", ownerController.RangeNew)
+			rangeGroup.POST("", ownerController.RangeCreate)
+
+			// Show a range and the owner's range days there
+			rangeGroup.GET("/:id", ownerController.RangeShow)
+
+			// Edit and Update a private range
+			rangeGroup.GET("/:id/edit", ownerController.RangeEdit)
+			rangeGroup.POST("/:id", ownerController.RangeUpdate)
+
+			// Delete a private range
+			rangeGroup.POST("/:id/delete", ownerController.RangeDelete)
+		}
 	}
 }
@@ -26,4 +26,10 @@ func (s *Server) RegisterPaymentRoutes(r *gin.Engine, paymentController *control
 	// Subscription cancellation routes
 	r.GET("/subscription/cancel/confirm", paymentController.ShowCancelConfirmation)
 	r.POST("/subscription/cancel", paymentController.CancelSubscription)
+
+	// Plan change, payment method and billing portal routes
+	r.GET("/subscription/change", paymentController.ShowPlanChange)
+	r.POST("/subscription/change", paymentController.ChangePlan)
+	r.POST("/subscription/payment-method", paymentController.UpdatePaymentMethod)
+	r.POST("/subscription/portal", paymentController.BillingPortal)
 }
@@ -69,6 +69,11 @@ func (s *Server) RegisterRoutes() http.Handler {
 	sitemapController := controller.NewSitemapController(r)
 	sitemapController.RegisterRoutes(r)
 
+	// Register the OpenAPI document for the /api routes and its viewer
+	openAPIController := controller.NewOpenAPIController(r)
+	r.GET(controller.OpenAPISpecPath, openAPIController.Spec)
+	r.GET("/api/docs", openAPIController.Viewer)
+
 	return r
 }
 
@@ -2,6 +2,7 @@ package server
 
 import (
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -10,10 +11,14 @@ import (
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/newrelic/go-agent/v3/newrelic"
 
+	"github.com/hail2skins/armory/internal/controller"
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/fieldcrypt"
 	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/middleware"
 	"github.com/hail2skins/armory/internal/services"
+	"github.com/hail2skins/armory/internal/services/dunning"
+	"github.com/hail2skins/armory/internal/services/email"
 	"github.com/hail2skins/armory/internal/services/stripe"
 )
 
@@ -22,8 +27,11 @@ type Server struct {
 
 	db              database.Service
 	casbinAuth      *middleware.CasbinAuth
+	rateLimitStore  middleware.RateLimitStore
 	ipFilterService stripe.IPFilterService
 	ipFilterStop    chan struct{} // Channel to stop the IP filter background refresh
+	dunningStop     chan struct{} // Channel to stop the subscription grace period runs
+	dataExportStop  chan struct{} // Channel to stop the data export sweeps
 	newRelicApp     *newrelic.Application
 }
 
@@ -43,6 +51,12 @@ func New() *Server {
 		port = 8080
 	}
 
+	// Load the field encryption keys now, so missing or bad keys stop startup
+	// rather than the first request that reads a serial number
+	if _, err := fieldcrypt.Default(); err != nil {
+		log.Fatalf("Failed to load field encryption keys: %v", err)
+	}
+
 	// Create the database service
 	dbService := database.New()
 
@@ -83,6 +97,8 @@ func New() *Server {
 		db:              dbService,
 		ipFilterService: ipFilterService,
 		ipFilterStop:    ipFilterStop,
+		dunningStop:     make(chan struct{}),
+		dataExportStop:  make(chan struct{}),
 		newRelicApp:     newRelicApp,
 	}
 
@@ -104,6 +120,18 @@ func (s *Server) Start() error {
 		s.ipFilterService.StartBackgroundRefresh(s.ipFilterStop)
 	}
 
+	// Move failed payment grace periods on and send their reminders
+	if s.dunningStop != nil {
+		logger.Info("Starting subscription grace period runs", nil)
+		s.dunningService().StartBackgroundRun(s.dunningStop, time.Hour)
+	}
+
+	// Fail data exports that were never finished and delete expired archives
+	if s.dataExportStop != nil {
+		logger.Info("Starting data export sweeps", nil)
+		controller.NewOwnerController(s.db).StartDataExportSweep(s.dataExportStop, 15*time.Minute)
+	}
+
 	// Set up routes
 	logger.Info("Setting up routes", nil)
 	handler := s.RegisterRoutes()
@@ -129,6 +157,16 @@ func (s *Server) Shutdown() {
 		time.Sleep(100 * time.Millisecond)
 	}
 
+	// Stop the subscription grace period runs
+	if s.dunningStop != nil {
+		close(s.dunningStop)
+	}
+
+	// Stop the data export sweeps
+	if s.dataExportStop != nil {
+		close(s.dataExportStop)
+	}
+
 	// Shutdown New Relic
 	if s.newRelicApp != nil {
 		logger.Info("Shutting down New Relic...", nil)
@@ -142,3 +180,20 @@ func (s *Server) Shutdown() {
 func (s *Server) createPromotionService() *services.PromotionService {
 	return services.NewPromotionService(s.db)
 }
+
+// dunningService creates the service that follows failed subscription
+// payments, sending reminders when email and APP_BASE_URL are configured
+func (s *Server) dunningService() *dunning.Service {
+	var emailService email.EmailService
+	if mailjet, err := email.NewMailjetService(); err == nil {
+		emailService = mailjet
+	}
+
+	// Reminders link to the billing page, so they aren't sent without the site's address
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" && emailService != nil {
+		logger.Error("APP_BASE_URL is not set, payment reminders won't be sent", nil, nil)
+		emailService = nil
+	}
+	return dunning.NewService(s.db.GetDB(), emailService, baseURL)
+}
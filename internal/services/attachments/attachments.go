@@ -0,0 +1,209 @@
+// Package attachments stores photos owners attach to their guns and
+// ammunition, such as the serial number area of a firearm or a receipt.
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/storage"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrQuotaExceeded is returned when a photo won't fit in the owner's storage quota
+	ErrQuotaExceeded = errors.New("not enough storage left on your plan for this photo")
+	// ErrCaptionTooLong is returned for captions over maxCaptionLength
+	ErrCaptionTooLong = fmt.Errorf("captions can be at most %d characters", maxCaptionLength)
+	// ErrNoStorage is returned when file storage isn't configured
+	ErrNoStorage = errors.New("photo storage isn't available right now")
+)
+
+// maxCaptionLength is the longest caption a photo can have
+const maxCaptionLength = 200
+
+// QuotaForTier returns how many bytes of photos a subscription tier's plan may
+// store. Tiers that aren't plans get the free tier's quota.
+func QuotaForTier(tier string) int64 {
+	if plan, ok := models.LookupPlan(tier); ok {
+		return plan.StorageQuota
+	}
+	return models.FreePlan().StorageQuota
+}
+
+// Upload is a photo being attached to one of an owner's guns or ammunition lots
+type Upload struct {
+	OwnerID  uint
+	Tier     string
+	GunID    *uint
+	AmmoID   *uint
+	Filename string
+	Caption  string
+	File     io.Reader
+}
+
+// Service stores attachments' files and records them in the database
+type Service struct {
+	db    *gorm.DB
+	store storage.Storage
+}
+
+// NewService returns an attachment service keeping files in store. A nil store
+// still reports usage, but photos can't be stored, read or deleted.
+func NewService(db *gorm.DB, store storage.Storage) *Service {
+	return &Service{db: db, store: store}
+}
+
+// Usage returns how many bytes of photos an owner is storing
+func (s *Service) Usage(ownerID uint) (int64, error) {
+	var used int64
+	err := s.db.Model(&models.Attachment{}).
+		Where("owner_id = ?", ownerID).
+		Select("COALESCE(SUM(size + thumbnail_size), 0)").
+		Scan(&used).Error
+	return used, err
+}
+
+// Create processes an uploaded photo, checks it fits in the owner's quota, and stores it
+func (s *Service) Create(ctx context.Context, upload Upload) (*models.Attachment, error) {
+	if s.store == nil {
+		return nil, ErrNoStorage
+	}
+	caption := strings.TrimSpace(upload.Caption)
+	if len(caption) > maxCaptionLength {
+		return nil, ErrCaptionTooLong
+	}
+
+	img, err := Process(upload.File)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int64(len(img.Data) + len(img.Thumbnail))
+	used, err := s.Usage(upload.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	if used+size > QuotaForTier(upload.Tier) {
+		return nil, ErrQuotaExceeded
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("attachments/%d/%s", upload.OwnerID, name)
+	attachment := &models.Attachment{
+		OwnerID:       upload.OwnerID,
+		GunID:         upload.GunID,
+		AmmoID:        upload.AmmoID,
+		Filename:      cleanFilename(upload.Filename),
+		Caption:       caption,
+		ContentType:   img.ContentType,
+		StorageKey:    prefix + Extension(img.ContentType),
+		ThumbnailKey:  prefix + "-thumb.jpg",
+		Size:          int64(len(img.Data)),
+		ThumbnailSize: int64(len(img.Thumbnail)),
+		Width:         img.Width,
+		Height:        img.Height,
+	}
+	if err := attachment.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Put(ctx, attachment.StorageKey, bytes.NewReader(img.Data), attachment.Size, attachment.ContentType); err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(ctx, attachment.ThumbnailKey, bytes.NewReader(img.Thumbnail), attachment.ThumbnailSize, "image/jpeg"); err != nil {
+		s.store.Delete(ctx, attachment.StorageKey)
+		return nil, err
+	}
+	if err := s.db.Create(attachment).Error; err != nil {
+		s.removeFiles(ctx, attachment)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Open reads an attachment's photo, or its thumbnail
+func (s *Service) Open(ctx context.Context, attachment *models.Attachment, thumbnail bool) (io.ReadCloser, error) {
+	if s.store == nil {
+		return nil, ErrNoStorage
+	}
+	if thumbnail {
+		return s.store.Open(ctx, attachment.ThumbnailKey)
+	}
+	return s.store.Open(ctx, attachment.StorageKey)
+}
+
+// Delete removes an attachment and its files
+func (s *Service) Delete(ctx context.Context, attachment *models.Attachment) error {
+	if s.store == nil {
+		return ErrNoStorage
+	}
+	if err := s.db.Unscoped().Delete(attachment).Error; err != nil {
+		return err
+	}
+	return s.removeFiles(ctx, attachment)
+}
+
+// DeleteForGun removes every photo an owner attached to a gun
+func (s *Service) DeleteForGun(ctx context.Context, gunID uint, ownerID uint) error {
+	return s.deleteWhere(ctx, "gun_id = ? AND owner_id = ?", gunID, ownerID)
+}
+
+// DeleteForAmmo removes every photo an owner attached to an ammunition lot
+func (s *Service) DeleteForAmmo(ctx context.Context, ammoID uint, ownerID uint) error {
+	return s.deleteWhere(ctx, "ammo_id = ? AND owner_id = ?", ammoID, ownerID)
+}
+
+// deleteWhere removes the attachments matching a condition
+func (s *Service) deleteWhere(ctx context.Context, query string, args ...interface{}) error {
+	var attachments []models.Attachment
+	if err := s.db.Where(query, args...).Find(&attachments).Error; err != nil {
+		return err
+	}
+	for i := range attachments {
+		if err := s.Delete(ctx, &attachments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFiles deletes an attachment's photo and thumbnail from storage
+func (s *Service) removeFiles(ctx context.Context, attachment *models.Attachment) error {
+	return errors.Join(
+		s.store.Delete(ctx, attachment.StorageKey),
+		s.store.Delete(ctx, attachment.ThumbnailKey),
+	)
+}
+
+// randomName returns an unguessable file name, so storage keys don't reveal anything
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// cleanFilename keeps only the base name of an uploaded file, for display
+func cleanFilename(filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" {
+		return ""
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
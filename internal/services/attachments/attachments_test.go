@@ -0,0 +1,146 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testJPEG encodes a w by h JPEG whose left half is red, with an EXIF block
+// holding the orientation and a camera make when orientation isn't zero
+func testJPEG(t *testing.T, w, h int, orientation uint16) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{0, 0, 255, 255}
+			if x < w/2 {
+				c = color.RGBA{255, 0, 0, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}))
+	if orientation == 0 {
+		return buf.Bytes()
+	}
+
+	// A big endian TIFF header with one IFD entry: orientation, then the camera make
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, []byte("SecretCam GPS 32.7767N 96.7970W")...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xff, 0xe1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, buf.Bytes()[:2]...)
+	out = append(out, app1...)
+	return append(out, buf.Bytes()[2:]...)
+}
+
+func TestProcessStripsEXIFAndAppliesOrientation(t *testing.T) {
+	raw := testJPEG(t, 40, 20, 6)
+	require.Equal(t, 6, exifOrientation(raw))
+
+	img, err := Process(bytes.NewReader(raw))
+	require.NoError(t, err)
+	assert.Equal(t, "image/jpeg", img.ContentType)
+	assert.NotContains(t, string(img.Data), "Exif")
+	assert.NotContains(t, string(img.Data), "SecretCam")
+
+	// Turned to portrait, with the red half that was on the left now on top
+	assert.Equal(t, 20, img.Width)
+	assert.Equal(t, 40, img.Height)
+	decoded, err := jpeg.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	r, _, b, _ := decoded.At(10, 5).RGBA()
+	assert.Greater(t, r, b, "The top is red")
+	r, _, b, _ = decoded.At(10, 35).RGBA()
+	assert.Greater(t, b, r, "The bottom is blue")
+}
+
+func TestProcessMakesThumbnail(t *testing.T) {
+	img, err := Process(bytes.NewReader(testJPEG(t, 1000, 500, 0)))
+	require.NoError(t, err)
+
+	thumbnail, err := jpeg.Decode(bytes.NewReader(img.Thumbnail))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, thumbnailDimension, thumbnailDimension/2), thumbnail.Bounds())
+	assert.Equal(t, 1000, img.Width, "Photos under the maximum size aren't scaled")
+}
+
+func TestProcessKeepsPNG(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8))))
+
+	img, err := Process(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", img.ContentType)
+	assert.Equal(t, ".png", Extension(img.ContentType))
+}
+
+func TestProcessValidatesContentType(t *testing.T) {
+	for name, contents := range map[string][]byte{
+		"text":           []byte("just some text, not a photo"),
+		"pdf":            []byte("%PDF-1.4\n"),
+		"html":           []byte("<html><script>alert(1)</script></html>"),
+		"truncated jpeg": testJPEG(t, 40, 20, 0)[:40],
+	} {
+		_, err := Process(bytes.NewReader(contents))
+		assert.ErrorIs(t, err, ErrUnsupportedType, name)
+	}
+
+	_, err := Process(bytes.NewReader(make([]byte, MaxUploadSize+1)))
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestCreateEnforcesQuota(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Attachment{}))
+	store := storage.NewLocalStorage(t.TempDir())
+	service := NewService(db, store)
+	ctx := context.Background()
+	gunID := uint(7)
+
+	attachment, err := service.Create(ctx, Upload{OwnerID: 1, Tier: "free", GunID: &gunID, Filename: `C:\photos\serial.jpg`, File: bytes.NewReader(testJPEG(t, 40, 20, 0))})
+	require.NoError(t, err)
+	assert.Equal(t, "serial.jpg", attachment.Filename)
+	used, err := service.Usage(1)
+	require.NoError(t, err)
+	assert.Equal(t, attachment.Size+attachment.ThumbnailSize, used)
+
+	// Fill the rest of the free quota
+	require.NoError(t, db.Create(&models.Attachment{OwnerID: 1, GunID: &gunID, ContentType: "image/jpeg", StorageKey: "k", ThumbnailKey: "t", Size: QuotaForTier("free") - used}).Error)
+	_, err = service.Create(ctx, Upload{OwnerID: 1, Tier: "free", GunID: &gunID, File: bytes.NewReader(testJPEG(t, 40, 20, 0))})
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	_, err = service.Create(ctx, Upload{OwnerID: 1, Tier: "monthly", GunID: &gunID, File: bytes.NewReader(testJPEG(t, 40, 20, 0))})
+	assert.NoError(t, err, "Paid tiers have more room")
+
+	// Deleting removes the files too
+	require.NoError(t, service.Delete(ctx, attachment))
+	_, err = store.Open(ctx, attachment.StorageKey)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = store.Open(ctx, attachment.ThumbnailKey)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
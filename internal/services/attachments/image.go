@@ -0,0 +1,295 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const (
+	// MaxUploadSize is the largest photo that can be uploaded, in bytes
+	MaxUploadSize = 10 << 20
+
+	// maxPixels is the largest image that will be decoded, so a small file
+	// can't claim huge dimensions and exhaust memory
+	maxPixels = 50_000_000
+
+	// maxDimension is the longest edge a stored photo is scaled down to
+	maxDimension = 2560
+
+	// thumbnailDimension is the longest edge of a thumbnail
+	thumbnailDimension = 320
+)
+
+var (
+	// ErrUnsupportedType is returned for files that aren't JPEG, PNG or GIF images
+	ErrUnsupportedType = errors.New("photos must be JPEG, PNG or GIF images")
+	// ErrTooLarge is returned for files over MaxUploadSize
+	ErrTooLarge = fmt.Errorf("photos can be at most %d MB", MaxUploadSize>>20)
+	// ErrTooManyPixels is returned for images with more than maxPixels pixels
+	ErrTooManyPixels = errors.New("the photo's dimensions are too large")
+)
+
+// allowedTypes are the content types that can be uploaded, checked against the file's contents
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Image is an uploaded photo ready to store: re-encoded without its metadata,
+// turned the right way up, and with a thumbnail
+type Image struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	Thumbnail   []byte // Always a JPEG
+}
+
+// Process checks an uploaded file is an image and prepares it for storage.
+// The content type comes from the file's contents, never from what the browser
+// claimed. Re-encoding drops EXIF and other metadata, such as GPS coordinates,
+// after the EXIF orientation has been applied to the pixels.
+func Process(r io.Reader) (*Image, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+
+	contentType := http.DetectContentType(raw)
+	if !allowedTypes[contentType] {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	rgba := toRGBA(img)
+	if contentType == "image/jpeg" {
+		rgba = orient(rgba, exifOrientation(raw))
+	}
+	rgba = fit(rgba, maxDimension)
+
+	var data bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&data, rgba, &jpeg.Options{Quality: 85})
+	} else {
+		// PNGs keep their transparency; GIFs are stored as a PNG of their first frame
+		contentType = "image/png"
+		err = png.Encode(&data, rgba)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnail, err := makeThumbnail(rgba)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Image{
+		Data:        data.Bytes(),
+		ContentType: contentType,
+		Width:       rgba.Bounds().Dx(),
+		Height:      rgba.Bounds().Dy(),
+		Thumbnail:   thumbnail,
+	}, nil
+}
+
+// Extension returns the file extension for a content type
+func Extension(contentType string) string {
+	if contentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// makeThumbnail scales an image down and encodes it as a JPEG on a white background
+func makeThumbnail(img *image.RGBA) ([]byte, error) {
+	small := fit(img, thumbnailDimension)
+	flat := image.NewRGBA(small.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), small, small.Bounds().Min, draw.Over)
+
+	var thumbnail bytes.Buffer
+	if err := jpeg.Encode(&thumbnail, flat, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return thumbnail.Bytes(), nil
+}
+
+// toRGBA copies an image into an RGBA image with its origin at 0,0
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// fit scales an image down, keeping its aspect ratio, so its longest edge is at most size
+func fit(img *image.RGBA, size int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+	return shrink(img, w, h)
+}
+
+// shrink scales an image down by averaging the source pixels that fall in each destination pixel
+func shrink(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), uint8(a/n)
+		}
+	}
+	return dst
+}
+
+// orient turns an image the right way up for its EXIF orientation (1-8)
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// Orientations 5-8 swap the width and height
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored
+				dx, dy = w-1-x, y
+			case 3: // Upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // Mirrored and turned left
+				dx, dy = y, x
+			case 6: // Turned left, so it's rotated right to fix
+				dx, dy = h-1-y, x
+			case 7: // Mirrored and turned right
+				dx, dy = h-1-y, w-1-x
+			case 8: // Turned right, so it's rotated left to fix
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dy*dst.Stride+dx*4:dy*dst.Stride+dx*4+4], src.Pix[y*src.Stride+x*4:y*src.Stride+x*4+4])
+		}
+	}
+	return dst
+}
+
+// exifOrientation reads the orientation tag from a JPEG's EXIF block, returning 1
+// (the right way up) when there isn't one
+func exifOrientation(jpegData []byte) int {
+	// Walk the JPEG markers looking for the APP1 segment holding EXIF
+	data := jpegData
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xff {
+		marker := data[1]
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if marker == 0xda || length < 2 || len(data) < 2+length {
+			// Start of scan: the metadata segments are over
+			return 1
+		}
+		segment := data[4 : 2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		data = data[2+length:]
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag (0x0112) from the first IFD of a TIFF header
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
@@ -0,0 +1,302 @@
+// Package csvimport reads spreadsheets of guns and ammunition, maps their
+// columns to model fields and previews each row before it is imported.
+package csvimport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxFileSize is the largest CSV file that can be imported, in bytes
+	MaxFileSize = 1 << 20
+
+	// MaxRows is the most rows that can be imported from one file
+	MaxRows = 500
+)
+
+var (
+	// ErrUnknownKind is returned for an import kind other than guns or ammo
+	ErrUnknownKind = errors.New("unknown import kind")
+
+	// ErrFileTooLarge is returned when the CSV file is over MaxFileSize
+	ErrFileTooLarge = fmt.Errorf("file is larger than %d KB", MaxFileSize/1024)
+
+	// ErrNoRows is returned when the CSV file has a header but nothing to import
+	ErrNoRows = errors.New("file has no rows to import")
+
+	// ErrTooManyRows is returned when the CSV file has more than MaxRows rows
+	ErrTooManyRows = fmt.Errorf("file has more than %d rows", MaxRows)
+
+	// ErrNotReady is returned when committing a preview that still has errors
+	ErrNotReady = errors.New("import has errors")
+)
+
+// Kind is what an import creates
+type Kind string
+
+const (
+	// KindGuns imports guns into the owner's arsenal
+	KindGuns Kind = "guns"
+
+	// KindAmmo imports ammunition into the owner's munitions depot
+	KindAmmo Kind = "ammo"
+)
+
+// ParseKind returns the import kind named by s
+func ParseKind(s string) (Kind, error) {
+	switch Kind(s) {
+	case KindGuns, KindAmmo:
+		return Kind(s), nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownKind, s)
+}
+
+// Label returns the kind as shown to owners
+func (k Kind) Label() string {
+	if k == KindAmmo {
+		return "Ammunition"
+	}
+	return "Guns"
+}
+
+// Field is a model field a CSV column can be mapped to
+type Field struct {
+	Key      string
+	Label    string
+	Required bool
+	// Aliases are other column headers that are guessed to mean this field
+	Aliases []string
+}
+
+// gunFields are the fields a gun import can fill in, matching the arsenal export's header
+var gunFields = []Field{
+	{Key: "name", Label: "Name", Required: true, Aliases: []string{"gun", "gun name", "firearm", "model"}},
+	{Key: "manufacturer", Label: "Manufacturer", Required: true, Aliases: []string{"make", "maker", "mfg"}},
+	{Key: "caliber", Label: "Caliber", Required: true, Aliases: []string{"cal", "chambering", "cartridge"}},
+	{Key: "weapon_type", Label: "Weapon Type", Required: true, Aliases: []string{"type", "gun type", "firearm type"}},
+	{Key: "serial_number", Label: "Serial Number", Aliases: []string{"serial", "serial no", "sn"}},
+	{Key: "finish", Label: "Finish"},
+	{Key: "purpose", Label: "Purpose", Aliases: []string{"use"}},
+	{Key: "paid", Label: "Paid", Aliases: []string{"price", "price paid", "cost"}},
+	{Key: "acquired", Label: "Acquired", Aliases: []string{"date", "date acquired", "purchased", "purchase date"}},
+}
+
+// ammoFields are the fields an ammunition import can fill in, matching the ammunition export's header
+var ammoFields = []Field{
+	{Key: "name", Label: "Name", Required: true, Aliases: []string{"ammo", "ammunition", "product", "description"}},
+	{Key: "brand", Label: "Brand", Required: true, Aliases: []string{"manufacturer", "make", "maker"}},
+	{Key: "caliber", Label: "Caliber", Required: true, Aliases: []string{"cal", "cartridge"}},
+	{Key: "grain", Label: "Grain", Aliases: []string{"grains", "gr", "bullet weight", "weight"}},
+	{Key: "bullet_style", Label: "Bullet Style", Aliases: []string{"bullet", "bullet type", "projectile", "style"}},
+	{Key: "casing", Label: "Casing", Aliases: []string{"case", "case material"}},
+	{Key: "count", Label: "Count", Aliases: []string{"rounds", "quantity", "qty", "round count"}},
+	{Key: "expended", Label: "Expended", Aliases: []string{"used", "fired", "rounds fired"}},
+	{Key: "paid", Label: "Paid", Aliases: []string{"price", "price paid", "cost"}},
+	{Key: "acquired", Label: "Acquired", Aliases: []string{"date", "date acquired", "purchased", "purchase date"}},
+}
+
+// Fields returns the fields an import of this kind can fill in
+func (k Kind) Fields() []Field {
+	if k == KindAmmo {
+		return ammoFields
+	}
+	return gunFields
+}
+
+// field returns the field with the given key
+func (k Kind) field(key string) (Field, bool) {
+	for _, f := range k.Fields() {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// Sheet is a parsed CSV file
+type Sheet struct {
+	Header []string
+	Rows   [][]string
+	Lines  []int // The line in the file each row starts on
+}
+
+// encodedLineColumn heads the column Encode adds to carry each row's line in the original file
+const encodedLineColumn = "line"
+
+// ReadCSV parses a CSV file with a header row, skipping blank rows
+func ReadCSV(r io.Reader) (*Sheet, error) {
+	raw, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > MaxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	// Spreadsheet programs often start the file with a byte order mark
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+
+	reader := csv.NewReader(bytes.NewReader(raw))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+
+	sheet := &Sheet{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read CSV: %w", err)
+		}
+		if isBlank(record) {
+			continue
+		}
+		if sheet.Header == nil {
+			sheet.Header = record
+			continue
+		}
+		// Blank rows and quoted line breaks mean rows aren't one to a line
+		line, _ := reader.FieldPos(0)
+		sheet.Rows = append(sheet.Rows, record)
+		sheet.Lines = append(sheet.Lines, line)
+	}
+
+	if len(sheet.Rows) == 0 {
+		return nil, ErrNoRows
+	}
+	if len(sheet.Rows) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+	return sheet, nil
+}
+
+// Line returns the line in the file row i starts on. Sheets that weren't read
+// from a file are taken to have a row on every line after the header.
+func (s *Sheet) Line(i int) int {
+	if i < len(s.Lines) {
+		return s.Lines[i]
+	}
+	return i + 2
+}
+
+// Encode returns the sheet as base64 CSV so it can be carried between the
+// preview and import steps in a hidden form field. Each row's line in the
+// original file goes with it, in an added first column.
+func (s *Sheet) Encode() string {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write(append([]string{encodedLineColumn}, s.Header...))
+	for i, row := range s.Rows {
+		_ = writer.Write(append([]string{strconv.Itoa(s.Line(i))}, row...))
+	}
+	writer.Flush()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// DecodeSheet parses a sheet encoded by Encode
+func DecodeSheet(encoded string) (*Sheet, error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CSV: %w", err)
+	}
+	encodedSheet, err := ReadCSV(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if encodedSheet.Header[0] != encodedLineColumn {
+		return nil, errors.New("could not read CSV: line numbers are missing")
+	}
+
+	sheet := &Sheet{Header: encodedSheet.Header[1:]}
+	for _, row := range encodedSheet.Rows {
+		line, err := strconv.Atoi(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("could not read CSV: %w", err)
+		}
+		sheet.Rows = append(sheet.Rows, row[1:])
+		sheet.Lines = append(sheet.Lines, line)
+	}
+	return sheet, nil
+}
+
+// Mapping holds the field key each column of a sheet is mapped to, by column
+// index. Columns mapped to "" are ignored.
+type Mapping []string
+
+// GuessMapping maps columns to fields by their header. When several columns
+// look like the same field, the first one wins.
+func GuessMapping(kind Kind, header []string) Mapping {
+	mapping := make(Mapping, len(header))
+	used := make(map[string]bool)
+	for i, column := range header {
+		name := normalize(column)
+		for _, f := range kind.Fields() {
+			if used[f.Key] || !f.matchesHeader(name) {
+				continue
+			}
+			mapping[i] = f.Key
+			used[f.Key] = true
+			break
+		}
+	}
+	return mapping
+}
+
+// matchesHeader reports whether a normalized column header names this field
+func (f Field) matchesHeader(name string) bool {
+	if name == normalize(f.Key) || name == normalize(f.Label) {
+		return true
+	}
+	for _, alias := range f.Aliases {
+		if name == normalize(alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// Problems returns what is wrong with the mapping: fields mapped more than
+// once, unknown fields and required fields that aren't mapped
+func (m Mapping) Problems(kind Kind) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for _, key := range m {
+		if key == "" {
+			continue
+		}
+		f, ok := kind.field(key)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("Unknown field %q", key))
+			continue
+		}
+		if seen[key] {
+			problems = append(problems, fmt.Sprintf("%s is mapped to more than one column", f.Label))
+		}
+		seen[key] = true
+	}
+	for _, f := range kind.Fields() {
+		if f.Required && !seen[f.Key] {
+			problems = append(problems, fmt.Sprintf("Choose the column that holds %s", f.Label))
+		}
+	}
+	return problems
+}
+
+// isBlank reports whether every cell of a CSV record is empty
+func isBlank(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package csvimport
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCSV(t *testing.T) {
+	t.Run("skips the byte order mark and blank rows", func(t *testing.T) {
+		sheet, err := ReadCSV(strings.NewReader("\xef\xbb\xbfName,Make\n\nGlock 19,Glock\n,\nP365,Sig\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Name", "Make"}, sheet.Header)
+		assert.Equal(t, [][]string{{"Glock 19", "Glock"}, {"P365", "Sig"}}, sheet.Rows)
+		assert.Equal(t, []int{3, 5}, sheet.Lines, "Skipped rows still count as lines")
+	})
+
+	t.Run("numbers rows by the line they start on", func(t *testing.T) {
+		sheet, err := ReadCSV(strings.NewReader("Name,Notes\nGlock 19,\"carry\ngun\"\nP365,\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []int{2, 4}, sheet.Lines)
+		assert.Equal(t, 4, sheet.Line(1))
+	})
+
+	t.Run("needs rows under the header", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("Name,Make\n"))
+		assert.ErrorIs(t, err, ErrNoRows)
+	})
+
+	t.Run("limits the number of rows", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("Name\n" + strings.Repeat("gun\n", MaxRows+1)))
+		assert.ErrorIs(t, err, ErrTooManyRows)
+	})
+
+	t.Run("limits the file size", func(t *testing.T) {
+		_, err := ReadCSV(strings.NewReader("Name\n" + strings.Repeat("x", MaxFileSize)))
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+	})
+}
+
+func TestSheetEncodeRoundTrip(t *testing.T) {
+	sheet := &Sheet{
+		Header: []string{"Name", "Notes"},
+		Rows:   [][]string{{"Glock 19", "has, a comma"}, {"1911", "\"quoted\"\nover two lines"}},
+		Lines:  []int{2, 7},
+	}
+
+	decoded, err := DecodeSheet(sheet.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, sheet, decoded, "Rows keep their lines in the original file")
+
+	_, err = DecodeSheet("not base64!")
+	assert.Error(t, err)
+
+	// Sheets encoded without their lines aren't accepted
+	_, err = DecodeSheet(base64.StdEncoding.EncodeToString([]byte("Name,Notes\nGlock 19,\n")))
+	assert.Error(t, err)
+}
+
+func TestGuessMapping(t *testing.T) {
+	mapping := GuessMapping(KindGuns, []string{"Make", "Model", "Cal.", "Type", "S/N", "Price Paid", "Notes", "Model"})
+	assert.Equal(t, Mapping{"manufacturer", "name", "caliber", "weapon_type", "serial_number", "paid", "", ""}, mapping,
+		"Unknown columns and the second of two matching columns aren't imported")
+	assert.Empty(t, mapping.Problems(KindGuns))
+
+	// The export's own header maps straight back
+	mapping = GuessMapping(KindAmmo, []string{"Name", "Brand", "Caliber", "Grain", "Bullet Style", "Casing", "Count", "Expended", "Paid", "Acquired"})
+	assert.Equal(t, Mapping{"name", "brand", "caliber", "grain", "bullet_style", "casing", "count", "expended", "paid", "acquired"}, mapping)
+}
+
+func TestMappingProblems(t *testing.T) {
+	problems := Mapping{"name", "name", "bogus", ""}.Problems(KindAmmo)
+	assert.Equal(t, []string{
+		"Name is mapped to more than one column",
+		`Unknown field "bogus"`,
+		"Choose the column that holds Brand",
+		"Choose the column that holds Caliber",
+	}, problems)
+}
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, similarity(normalize(".45 ACP"), normalize("45acp")))
+	assert.GreaterOrEqual(t, similarity("glok", "glock"), minSimilarity)
+	assert.GreaterOrEqual(t, similarity(normalize("Smith and Wesson"), normalize("Smith & Wesson")), minSimilarity)
+	assert.Greater(t, similarity("9mm", "9mmluger"), similarity("9mm", "9mmmakarov"), "The closer containing name wins")
+	assert.Less(t, similarity("glock", "ruger"), minSimilarity)
+	assert.Less(t, similarity("sw", "smithwesson"), minSimilarity, "Very short names must match exactly")
+}
+
+func TestUnguard(t *testing.T) {
+	assert.Equal(t, "=SUM(A1)", unguard("'=SUM(A1)"))
+	assert.Equal(t, "'Tis a gun", unguard("'Tis a gun"))
+}
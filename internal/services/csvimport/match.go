@@ -0,0 +1,216 @@
+package csvimport
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+)
+
+// minSimilarity is how alike a name must be to a reference name to match it
+const minSimilarity = 0.75
+
+// reference is a row of a reference table that free text can match
+type reference struct {
+	id      uint
+	display string
+	names   []string // normalized name and nickname
+}
+
+// Matcher fuzzy-matches free-text names against the reference tables
+type Matcher struct {
+	refs   map[string][]reference
+	grains []models.Grain
+}
+
+// NewMatcher loads the reference tables an import of this kind matches against
+func NewMatcher(db *gorm.DB, kind Kind) (*Matcher, error) {
+	m := &Matcher{refs: make(map[string][]reference)}
+
+	var calibers []models.Caliber
+	if err := db.Order("popularity DESC").Find(&calibers).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range calibers {
+		m.add("caliber", c.ID, c.Caliber, c.Nickname)
+	}
+
+	if kind == KindGuns {
+		var manufacturers []models.Manufacturer
+		if err := db.Order("popularity DESC").Find(&manufacturers).Error; err != nil {
+			return nil, err
+		}
+		for _, mf := range manufacturers {
+			m.add("manufacturer", mf.ID, mf.Name, mf.Nickname)
+		}
+
+		var weaponTypes []models.WeaponType
+		if err := db.Order("popularity DESC").Find(&weaponTypes).Error; err != nil {
+			return nil, err
+		}
+		for _, wt := range weaponTypes {
+			m.add("weapon_type", wt.ID, wt.Type, wt.Nickname)
+		}
+		return m, nil
+	}
+
+	var brands []models.Brand
+	if err := db.Order("popularity DESC").Find(&brands).Error; err != nil {
+		return nil, err
+	}
+	for _, b := range brands {
+		m.add("brand", b.ID, b.Name, b.Nickname)
+	}
+
+	var bulletStyles []models.BulletStyle
+	if err := db.Order("popularity DESC").Find(&bulletStyles).Error; err != nil {
+		return nil, err
+	}
+	for _, bs := range bulletStyles {
+		m.add("bullet_style", bs.ID, bs.Type, bs.Nickname)
+	}
+
+	var casings []models.Casing
+	if err := db.Order("popularity DESC").Find(&casings).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range casings {
+		m.add("casing", c.ID, c.Type)
+	}
+
+	if err := db.Find(&m.grains).Error; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// add adds a reference row that free text in the given field can match
+func (m *Matcher) add(field string, id uint, display string, aliases ...string) {
+	ref := reference{id: id, display: display}
+	for _, name := range append([]string{display}, aliases...) {
+		if n := normalize(name); n != "" {
+			ref.names = append(ref.names, n)
+		}
+	}
+	m.refs[field] = append(m.refs[field], ref)
+}
+
+// Match finds the reference row that best matches the input for a field. It
+// returns the row's ID and name, and whether the input named it exactly.
+func (m *Matcher) Match(field, input string) (id uint, name string, exact bool, ok bool) {
+	if field == "grain" {
+		return m.matchGrain(input)
+	}
+
+	wanted := normalize(input)
+	if wanted == "" {
+		return 0, "", false, false
+	}
+
+	// References are in popularity order, so the more popular of two equal matches wins
+	best, bestScore := reference{}, 0.0
+	for _, ref := range m.refs[field] {
+		for _, candidate := range ref.names {
+			score := similarity(wanted, candidate)
+			if score > bestScore {
+				best, bestScore = ref, score
+			}
+		}
+	}
+	if bestScore < minSimilarity {
+		return 0, "", false, false
+	}
+	return best.id, best.display, bestScore == 1, true
+}
+
+// matchGrain matches a grain weight such as "124", "124gr" or "Other". Weights
+// are matched exactly rather than fuzzily, since 124 and 125 are different loads.
+func (m *Matcher) matchGrain(input string) (uint, string, bool, bool) {
+	wanted := normalize(input)
+	weight := -1
+	switch wanted {
+	case "other", "custom", "othercustom":
+		weight = 0
+	default:
+		unit := strings.TrimLeftFunc(wanted, unicode.IsDigit)
+		switch unit {
+		case "", "gr", "grain", "grains":
+			if w, err := strconv.Atoi(strings.TrimSuffix(wanted, unit)); err == nil {
+				weight = w
+			}
+		}
+	}
+
+	for _, g := range m.grains {
+		if g.Weight == weight {
+			if weight == 0 {
+				return g.ID, "Other/Custom", true, true
+			}
+			return g.ID, strconv.Itoa(g.Weight), true, true
+		}
+	}
+	return 0, "", false, false
+}
+
+// normalize lowercases a name and drops everything but letters and digits,
+// so ".45 ACP" and "45acp" compare equal
+func normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// similarity scores how alike two normalized names are, from 0 to 1. Short
+// forms contained in the longer name ("9mm" in "9mm Luger") score well, more
+// so the more of the name they cover.
+func similarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if a == "" || b == "" {
+		return 0
+	}
+
+	short, long := a, b
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	longRunes := len([]rune(long))
+	score := 1 - float64(levenshtein(a, b))/float64(longRunes)
+
+	if len([]rune(short)) >= 3 && strings.Contains(long, short) {
+		contained := 0.75 + 0.24*float64(len([]rune(short)))/float64(longRunes)
+		if contained > score {
+			score = contained
+		}
+	}
+	return score
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
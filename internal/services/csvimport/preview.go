@@ -0,0 +1,276 @@
+package csvimport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hail2skins/armory/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NoLimit is the limit for owners who can import any number of rows
+const NoLimit = -1
+
+// dateLayouts are the acquired date formats spreadsheets commonly use
+var dateLayouts = []string{"2006-01-02", "1/2/2006", "01/02/2006", "1/2/06", "Jan 2, 2006", "January 2, 2006", "2006/01/02"}
+
+// Match records a free-text name that was matched to a different reference name
+type Match struct {
+	Field string
+	Input string
+	Name  string
+}
+
+// Row is one row of the file as it would be imported
+type Row struct {
+	// Line is the line in the file the row starts on
+	Line    int
+	Values  map[string]string
+	Gun     *models.Gun
+	Ammo    *models.Ammo
+	Matches []Match
+	Errors  []string
+}
+
+// Valid reports whether the row can be imported
+func (r Row) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// Preview is a dry run of an import: every row built and validated, nothing saved
+type Preview struct {
+	Kind          Kind
+	Sheet         *Sheet
+	Mapping       Mapping
+	MappingErrors []string
+	Rows          []Row
+	// Limit is how many more guns or ammunition lots the owner may add, or NoLimit
+	Limit int
+}
+
+// Ready reports whether the whole file can be imported
+func (p *Preview) Ready() bool {
+	return len(p.MappingErrors) == 0 && len(p.Rows) > 0 && p.ErrorCount() == 0
+}
+
+// ErrorCount returns how many rows can't be imported
+func (p *Preview) ErrorCount() int {
+	count := 0
+	for _, row := range p.Rows {
+		if !row.Valid() {
+			count++
+		}
+	}
+	return count
+}
+
+// OverLimit reports whether the file has more rows than the owner may add
+func (p *Preview) OverLimit() bool {
+	return p.Limit != NoLimit && len(p.Rows) > p.Limit
+}
+
+// Build maps and validates every row of the sheet for the owner without saving
+// anything. Rows past the owner's limit are marked as errors.
+func Build(db *gorm.DB, kind Kind, ownerID uint, sheet *Sheet, mapping Mapping, limit int) (*Preview, error) {
+	preview := &Preview{
+		Kind:          kind,
+		Sheet:         sheet,
+		Mapping:       mapping,
+		MappingErrors: mapping.Problems(kind),
+		Limit:         limit,
+	}
+	if len(preview.MappingErrors) > 0 {
+		return preview, nil
+	}
+
+	matcher, err := NewMatcher(db, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, record := range sheet.Rows {
+		row := Row{Line: sheet.Line(i), Values: mapping.values(record)}
+		if kind == KindAmmo {
+			row.Ammo = buildAmmo(&row, matcher, ownerID)
+			if len(row.Errors) == 0 {
+				row.addError(row.Ammo.Validate(db))
+			}
+		} else {
+			row.Gun = buildGun(&row, matcher, ownerID)
+			if len(row.Errors) == 0 {
+				row.addError(row.Gun.Validate(db))
+			}
+		}
+		if limit != NoLimit && i >= limit {
+			row.Errors = append(row.Errors, "Over your plan's limit")
+		}
+		preview.Rows = append(preview.Rows, row)
+	}
+	return preview, nil
+}
+
+// Commit saves every row of a ready preview in one transaction, so either the
+// whole file is imported or none of it is. It returns how many rows were saved.
+func (p *Preview) Commit(db *gorm.DB) (int, error) {
+	if !p.Ready() {
+		return 0, ErrNotReady
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, row := range p.Rows {
+			var record interface{ Validate(*gorm.DB) error } = row.Gun
+			if p.Kind == KindAmmo {
+				record = row.Ammo
+			}
+			if err := record.Validate(tx); err != nil {
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+			if err := tx.Omit(clause.Associations).Create(record).Error; err != nil {
+				return fmt.Errorf("line %d: %w", row.Line, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p.Rows), nil
+}
+
+// values returns a record's cells by the field their column is mapped to
+func (m Mapping) values(record []string) map[string]string {
+	values := make(map[string]string)
+	for i, key := range m {
+		if key == "" || i >= len(record) {
+			continue
+		}
+		values[key] = unguard(strings.TrimSpace(record[i]))
+	}
+	return values
+}
+
+// buildGun builds a gun from a row, recording any values that can't be read
+func buildGun(row *Row, matcher *Matcher, ownerID uint) *models.Gun {
+	gun := &models.Gun{
+		Name:         row.Values["name"],
+		SerialNumber: row.Values["serial_number"],
+		Finish:       row.Values["finish"],
+		Purpose:      row.Values["purpose"],
+		OwnerID:      ownerID,
+	}
+	if gun.Name == "" {
+		row.Errors = append(row.Errors, "Name is required")
+	}
+	gun.ManufacturerID = row.match(matcher, "manufacturer", "Manufacturer", true)
+	gun.CaliberID = row.match(matcher, "caliber", "Caliber", true)
+	gun.WeaponTypeID = row.match(matcher, "weapon_type", "Weapon type", true)
+	gun.Paid = row.money("paid", "Paid")
+	gun.Acquired = row.date("acquired", "Acquired")
+	return gun
+}
+
+// buildAmmo builds an ammunition lot from a row, recording any values that can't be read
+func buildAmmo(row *Row, matcher *Matcher, ownerID uint) *models.Ammo {
+	ammo := &models.Ammo{
+		Name:    row.Values["name"],
+		OwnerID: ownerID,
+	}
+	if ammo.Name == "" {
+		row.Errors = append(row.Errors, "Name is required")
+	}
+	ammo.BrandID = row.match(matcher, "brand", "Brand", true)
+	ammo.CaliberID = row.match(matcher, "caliber", "Caliber", true)
+	ammo.GrainID = row.match(matcher, "grain", "Grain", false)
+	ammo.BulletStyleID = row.match(matcher, "bullet_style", "Bullet style", false)
+	ammo.CasingID = row.match(matcher, "casing", "Casing", false)
+	ammo.Count = row.whole("count", "Count")
+	ammo.Expended = row.whole("expended", "Expended")
+	ammo.Paid = row.money("paid", "Paid")
+	ammo.Acquired = row.date("acquired", "Acquired")
+	return ammo
+}
+
+// match looks up a reference name, noting fuzzy matches so the owner can check them
+func (r *Row) match(matcher *Matcher, field, label string, required bool) uint {
+	input := r.Values[field]
+	if input == "" {
+		if required {
+			r.Errors = append(r.Errors, label+" is required")
+		}
+		return 0
+	}
+
+	id, name, exact, ok := matcher.Match(field, input)
+	if !ok {
+		r.Errors = append(r.Errors, fmt.Sprintf("%s %q doesn't match any we know", label, input))
+		return 0
+	}
+	if !exact {
+		r.Matches = append(r.Matches, Match{Field: label, Input: input, Name: name})
+	}
+	return id
+}
+
+// money reads an optional price such as "$1,299.99"
+func (r *Row) money(field, label string) *float64 {
+	input := strings.NewReplacer("$", "", ",", "").Replace(r.Values[field])
+	if input == "" {
+		return nil
+	}
+	value, err := strconv.ParseFloat(input, 64)
+	if err != nil {
+		r.Errors = append(r.Errors, label+" must be a number")
+		return nil
+	}
+	return &value
+}
+
+// whole reads an optional whole number such as "1,000"
+func (r *Row) whole(field, label string) int {
+	input := strings.ReplaceAll(r.Values[field], ",", "")
+	if input == "" {
+		return 0
+	}
+	value, err := strconv.Atoi(input)
+	if err != nil {
+		r.Errors = append(r.Errors, label+" must be a whole number")
+		return 0
+	}
+	return value
+}
+
+// date reads an optional date in one of the common spreadsheet formats
+func (r *Row) date(field, label string) *time.Time {
+	input := r.Values[field]
+	if input == "" {
+		return nil
+	}
+	for _, layout := range dateLayouts {
+		if value, err := time.Parse(layout, input); err == nil {
+			return &value
+		}
+	}
+	r.Errors = append(r.Errors, label+" must be a date like 2024-01-31")
+	return nil
+}
+
+// addError records a validation error, capitalized for display
+func (r *Row) addError(err error) {
+	if err == nil {
+		return
+	}
+	msg := err.Error()
+	r.Errors = append(r.Errors, strings.ToUpper(msg[:1])+msg[1:])
+}
+
+// unguard removes the apostrophe the CSV export puts in front of text that
+// spreadsheets would otherwise run as a formula
+func unguard(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune("=+-@", rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
@@ -0,0 +1,237 @@
+// Package dunning follows subscriptions whose renewal payment has failed
+// through a grace period. A failed payment makes a subscription past due
+// while Stripe retries it, then unpaid, and once the grace period lapses it
+// expires and the user moves to the free plan. A payment that goes through
+// at any point makes it active again.
+package dunning
+
+import (
+	"time"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/email"
+	"gorm.io/gorm"
+)
+
+// Subscription statuses through the grace period
+const (
+	StatusActive  = "active"
+	StatusPastDue = "past_due" // A renewal payment failed and Stripe is retrying it
+	StatusUnpaid  = "unpaid"   // The retries haven't worked, so it's the last chance to pay
+	StatusExpired = "expired"  // The grace period lapsed and the user is on the free plan
+)
+
+const (
+	// UnpaidAfter is how long a subscription stays past due before it's unpaid
+	UnpaidAfter = 7 * 24 * time.Hour
+
+	// GracePeriod is how long after the first failed payment the plan is kept
+	GracePeriod = 14 * 24 * time.Hour
+)
+
+// InGrace reports whether a user's payment has failed and their grace period is running
+func InGrace(user *database.User) bool {
+	return user.InPaymentGracePeriod()
+}
+
+// GraceEnds returns when a user's grace period ends
+func GraceEnds(user *database.User) time.Time {
+	return user.PaymentFailedAt.Add(GracePeriod)
+}
+
+// Lapsed reports whether a user's grace period has run out, whether or not
+// their status has caught up yet
+func Lapsed(user *database.User, now time.Time) bool {
+	return InGrace(user) && !now.Before(GraceEnds(user))
+}
+
+// Notice explains a running grace period to the user, or is empty when there isn't one
+func Notice(user *database.User) string {
+	ends := GraceEnds(user).Format("January 2, 2006")
+	switch user.SubscriptionStatus {
+	case StatusPastDue:
+		return "We couldn't take your subscription payment. Update your payment method by " + ends + " to keep your plan."
+	case StatusUnpaid:
+		return "Your subscription payment is still overdue. Your account moves to the free plan on " + ends + " unless you update your payment method."
+	}
+	return ""
+}
+
+// PaymentFailed starts a user's grace period, or moves it on if it's
+// running. Returns whether the user changed.
+func PaymentFailed(user *database.User, now time.Time) bool {
+	changed := false
+	if !InGrace(user) {
+		// A subscription that expired for not being paid stays on the free plan
+		if expiredUnpaid(user) {
+			return false
+		}
+		user.SubscriptionStatus = StatusPastDue
+		user.PaymentFailedAt = now
+		user.PaymentReminderSent = ""
+		changed = true
+	}
+	if Advance(user, now) {
+		changed = true
+	}
+	return changed
+}
+
+// PaymentUnpaid records Stripe giving up on retrying a user's payment.
+// Returns whether the user changed.
+func PaymentUnpaid(user *database.User, now time.Time) bool {
+	changed := PaymentFailed(user, now)
+	if user.SubscriptionStatus == StatusPastDue {
+		user.SubscriptionStatus = StatusUnpaid
+		return true
+	}
+	return changed
+}
+
+// PaymentSucceeded ends a user's grace period, making their subscription
+// active again. Their plan is left for the caller to set from the
+// subscription. Returns whether the user changed.
+func PaymentSucceeded(user *database.User) bool {
+	if !InGrace(user) && !expiredUnpaid(user) {
+		return false
+	}
+	user.SubscriptionStatus = StatusActive
+	user.PaymentFailedAt = time.Time{}
+	user.PaymentReminderSent = ""
+	return true
+}
+
+// Advance moves a user's grace period on to where it should be by now.
+// Returns whether the user changed.
+func Advance(user *database.User, now time.Time) bool {
+	switch {
+	case Lapsed(user, now):
+		user.SubscriptionStatus = StatusExpired
+		user.SubscriptionTier = models.FreeTier
+		return true
+	case user.SubscriptionStatus == StatusPastDue && !now.Before(user.PaymentFailedAt.Add(UnpaidAfter)):
+		user.SubscriptionStatus = StatusUnpaid
+		return true
+	}
+	return false
+}
+
+// expiredUnpaid reports whether a user's subscription expired at the end of
+// a grace period, rather than a promotion or grant running out
+func expiredUnpaid(user *database.User) bool {
+	return user.SubscriptionStatus == StatusExpired && !user.PaymentFailedAt.IsZero()
+}
+
+// Service moves grace periods on as time passes and emails users about them
+type Service struct {
+	db      *gorm.DB
+	email   email.EmailService
+	baseURL string
+}
+
+// NewService returns a dunning service. Reminders aren't sent without an
+// email service.
+func NewService(db *gorm.DB, emailService email.EmailService, baseURL string) *Service {
+	return &Service{db: db, email: emailService, baseURL: baseURL}
+}
+
+// Run moves on every grace period that's due to, and sends each user a
+// reminder for the status they're in if they haven't had one. A reminder
+// that fails is sent on the next run. Each change is claimed with a
+// conditional update, so when several servers run at once only one of them
+// moves a user on and emails them.
+func (s *Service) Run(now time.Time) error {
+	var users []database.User
+	err := s.db.Where("subscription_status IN ?", []string{StatusPastDue, StatusUnpaid}).
+		Or("subscription_status = ? AND payment_failed_at > ? AND payment_reminder_sent <> ?", StatusExpired, time.Time{}, StatusExpired).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for i := range users {
+		user := &users[i]
+		if user.PaymentFailedAt.IsZero() {
+			continue
+		}
+
+		status, reminder := user.SubscriptionStatus, user.PaymentReminderSent
+		advanced := Advance(user, now)
+		remind := user.PaymentReminderSent != user.SubscriptionStatus && s.email != nil
+		if remind {
+			user.PaymentReminderSent = user.SubscriptionStatus
+		}
+		if !advanced && !remind {
+			continue
+		}
+
+		claimed, err := s.claim(user, status, reminder)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		if advanced {
+			logger.Info("Subscription grace period moved on", map[string]interface{}{
+				"user_id": user.ID,
+				"status":  user.SubscriptionStatus,
+			})
+		}
+		if !remind {
+			continue
+		}
+
+		err = s.email.SendPaymentReminderEmail(user.Email, user.SubscriptionStatus, GraceEnds(user), s.baseURL+"/owner/profile/subscription")
+		if err != nil {
+			logger.Error("Failed to send payment reminder", err, map[string]interface{}{
+				"user_id": user.ID,
+				"status":  user.SubscriptionStatus,
+			})
+			// Give the reminder up so the next run sends it
+			err = s.db.Model(&database.User{}).
+				Where("id = ? AND payment_reminder_sent = ?", user.ID, user.PaymentReminderSent).
+				Update("payment_reminder_sent", reminder).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claim saves a user's new grace period status and reminder if they're still
+// at status and reminder, returning false when another run got there first
+func (s *Service) claim(user *database.User, status, reminder string) (bool, error) {
+	result := s.db.Model(&database.User{}).
+		Where("id = ? AND subscription_status = ? AND payment_reminder_sent = ?", user.ID, status, reminder).
+		Updates(map[string]interface{}{
+			"subscription_status":   user.SubscriptionStatus,
+			"subscription_tier":     user.SubscriptionTier,
+			"payment_reminder_sent": user.PaymentReminderSent,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+// StartBackgroundRun runs the service every interval until stop is closed
+func (s *Service) StartBackgroundRun(stop chan struct{}, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Run(time.Now()); err != nil {
+				logger.Error("Failed to move subscription grace periods on", err, nil)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				logger.Info("Stopping subscription grace period runs", nil)
+				return
+			}
+		}
+	}()
+}
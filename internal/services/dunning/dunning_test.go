@@ -0,0 +1,72 @@
+package dunning
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGracePeriod(t *testing.T) {
+	failed := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	user := &database.User{SubscriptionTier: "monthly", SubscriptionStatus: StatusActive}
+
+	// The first failed payment starts the grace period
+	assert.True(t, PaymentFailed(user, failed))
+	assert.Equal(t, StatusPastDue, user.SubscriptionStatus)
+	assert.Equal(t, failed, user.PaymentFailedAt)
+	assert.Equal(t, failed.Add(GracePeriod), GraceEnds(user))
+	assert.Contains(t, Notice(user), "June 15, 2025")
+
+	// Stripe's retries failing don't restart it
+	assert.False(t, PaymentFailed(user, failed.Add(2*24*time.Hour)))
+	assert.Equal(t, failed, user.PaymentFailedAt)
+
+	// A week on it's unpaid
+	assert.True(t, Advance(user, failed.Add(UnpaidAfter)))
+	assert.Equal(t, StatusUnpaid, user.SubscriptionStatus)
+	assert.Equal(t, "monthly", user.SubscriptionTier)
+	assert.False(t, Lapsed(user, failed.Add(GracePeriod-time.Minute)))
+
+	// Once the grace period lapses the user is on the free plan
+	assert.True(t, Lapsed(user, failed.Add(GracePeriod)))
+	assert.True(t, Advance(user, failed.Add(GracePeriod)))
+	assert.Equal(t, StatusExpired, user.SubscriptionStatus)
+	assert.Equal(t, models.FreeTier, user.SubscriptionTier)
+	assert.False(t, InGrace(user))
+	assert.Empty(t, Notice(user))
+
+	// Another failed payment leaves them there
+	assert.False(t, PaymentFailed(user, failed.Add(GracePeriod+time.Hour)))
+	assert.Equal(t, StatusExpired, user.SubscriptionStatus)
+
+	// A payment going through makes the subscription active again
+	assert.True(t, PaymentSucceeded(user))
+	assert.Equal(t, StatusActive, user.SubscriptionStatus)
+	assert.True(t, user.PaymentFailedAt.IsZero())
+	assert.False(t, PaymentSucceeded(user))
+}
+
+func TestPaymentUnpaid(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Stripe giving up straight away skips past due
+	user := &database.User{SubscriptionTier: "yearly", SubscriptionStatus: StatusActive}
+	assert.True(t, PaymentUnpaid(user, now))
+	assert.Equal(t, StatusUnpaid, user.SubscriptionStatus)
+	assert.Equal(t, now, user.PaymentFailedAt)
+	assert.Equal(t, "yearly", user.SubscriptionTier)
+
+	// It doesn't move the grace period's end
+	assert.False(t, PaymentUnpaid(user, now.Add(time.Hour)))
+	assert.Equal(t, now, user.PaymentFailedAt)
+}
+
+func TestPaymentSucceededLeavesOtherExpiries(t *testing.T) {
+	// A promotion or grant that ran out isn't brought back by a payment
+	user := &database.User{SubscriptionTier: "free", SubscriptionStatus: StatusExpired}
+	assert.False(t, PaymentSucceeded(user))
+	assert.Equal(t, StatusExpired, user.SubscriptionStatus)
+}
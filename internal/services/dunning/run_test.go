@@ -0,0 +1,74 @@
+package dunning_test
+
+// The service's runs are tested from outside the package, as the test
+// database helpers import it through the controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/dunning"
+	"github.com/hail2skins/armory/internal/services/email"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reminderEmails records the payment reminders sent, running during each send
+// when it's set and failing when err is
+type reminderEmails struct {
+	email.EmailService
+	sent   []string
+	during func()
+	err    error
+}
+
+func (r *reminderEmails) SendPaymentReminderEmail(address, status string, graceEndsAt time.Time, billingURL string) error {
+	if r.during != nil {
+		during := r.during
+		r.during = nil
+		during()
+	}
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, status)
+	return nil
+}
+
+func TestRunSendsEachReminderOnce(t *testing.T) {
+	db := testutils.NewTestDB()
+	defer db.Close()
+	failed := time.Now().Add(-time.Hour)
+	user := &database.User{Email: "dunning@example.com", Password: "Password123!", SubscriptionTier: "monthly",
+		SubscriptionStatus: dunning.StatusPastDue, PaymentFailedAt: failed}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	// A reminder that fails is given up for the next run
+	emails := &reminderEmails{err: errors.New("mail is down")}
+	service := dunning.NewService(db.DB, emails, "https://armory.example.com")
+	require.NoError(t, service.Run(time.Now()))
+	var stored database.User
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	assert.Empty(t, stored.PaymentReminderSent)
+
+	// Another server running while the reminder is sent doesn't send it again
+	emails.err = nil
+	emails.during = func() { require.NoError(t, service.Run(time.Now())) }
+	require.NoError(t, service.Run(time.Now()))
+	assert.Equal(t, []string{dunning.StatusPastDue}, emails.sent)
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	assert.Equal(t, dunning.StatusPastDue, stored.PaymentReminderSent)
+
+	// Once the grace period lapses the user moves to the free plan and hears about it once
+	later := failed.Add(dunning.GracePeriod)
+	emails.during = func() { require.NoError(t, service.Run(later)) }
+	require.NoError(t, service.Run(later))
+	assert.Equal(t, []string{dunning.StatusPastDue, dunning.StatusExpired}, emails.sent)
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	assert.Equal(t, dunning.StatusExpired, stored.SubscriptionStatus)
+	assert.Equal(t, models.FreeTier, stored.SubscriptionTier)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	mailjet "github.com/mailjet/mailjet-apiv3-go/v4"
 )
@@ -20,6 +21,9 @@ type EmailService interface {
 	SendVerificationEmail(email, token, baseURL string) error
 	SendEmailChangeVerification(email, token, baseURL string) error
 	SendPasswordResetEmail(email, token, baseURL string) error
+	SendAccountLockedEmail(email, token, baseURL string) error
+	SendDataExportEmail(email, downloadURL string, expiresAt time.Time) error
+	SendPaymentReminderEmail(email, status string, graceEndsAt time.Time, billingURL string) error
 	SendContactEmail(name, email, subject, message string) error
 }
 
@@ -172,6 +176,139 @@ func (s *MailjetService) SendPasswordResetEmail(email, token, baseURL string) er
 	return nil
 }
 
+// SendAccountLockedEmail tells the user their account was locked after too many
+// failed sign in attempts, with a one-time link to unlock it
+func (s *MailjetService) SendAccountLockedEmail(email, token, baseURL string) error {
+	// Check if the service is properly configured
+	if s.client == nil {
+		return ErrEmailServiceNotConfigured
+	}
+
+	data := &mailjet.InfoMessagesV31{
+		From: &mailjet.RecipientV31{
+			Email: s.senderEmail,
+			Name:  s.senderName,
+		},
+		To: &mailjet.RecipientsV31{
+			mailjet.RecipientV31{
+				Email: email,
+			},
+		},
+		Subject:  "Your Virtual Armory account has been locked",
+		TextPart: fmt.Sprintf("Your account was locked after too many failed sign in attempts. If this was you, unlock it now by clicking this link: %s/unlock-account?token=%s. This link can be used once and will expire in 24 hours. If it wasn't you, we recommend resetting your password.", baseURL, token),
+		HTMLPart: fmt.Sprintf(`
+			<h3>Account Locked</h3>
+			<p>Your account was locked after too many failed sign in attempts.</p>
+			<p>If this was you, you can unlock it now by clicking the link below:</p>
+			<p><a href="%s/unlock-account?token=%s">Unlock Account</a></p>
+			<p><strong>Note:</strong> This link can be used once and will expire in 24 hours. Otherwise your account will unlock on its own later.</p>
+			<p>If you did not try to sign in, we recommend <a href="%s/reset-password/new">resetting your password</a>.</p>
+		`, baseURL, token, baseURL),
+	}
+
+	messages := &mailjet.MessagesV31{Info: []mailjet.InfoMessagesV31{*data}}
+	_, err := s.client.SendMailV31(messages)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEmailSendFailed, err)
+	}
+
+	return nil
+}
+
+// SendDataExportEmail tells the user the export of their data they asked for
+// is ready, with a link to download it that works until expiresAt
+func (s *MailjetService) SendDataExportEmail(email, downloadURL string, expiresAt time.Time) error {
+	// Check if the service is properly configured
+	if s.client == nil {
+		return ErrEmailServiceNotConfigured
+	}
+
+	expires := expiresAt.UTC().Format("January 2, 2006 at 15:04 UTC")
+	data := &mailjet.InfoMessagesV31{
+		From: &mailjet.RecipientV31{
+			Email: s.senderEmail,
+			Name:  s.senderName,
+		},
+		To: &mailjet.RecipientsV31{
+			mailjet.RecipientV31{
+				Email: email,
+			},
+		},
+		Subject:  "Your Virtual Armory data export is ready",
+		TextPart: fmt.Sprintf("The export of your Virtual Armory data you asked for is ready. Download it here: %s. You'll need to be signed in, and the link expires on %s. If you didn't ask for this export, we recommend changing your password.", downloadURL, expires),
+		HTMLPart: fmt.Sprintf(`
+			<h3>Your Data Export Is Ready</h3>
+			<p>The export of your Virtual Armory data you asked for is ready to download:</p>
+			<p><a href="%s">Download Your Data</a></p>
+			<p><strong>Note:</strong> You'll need to be signed in, and the link expires on %s.</p>
+			<p>If you did not ask for this export, we recommend changing your password.</p>
+		`, downloadURL, expires),
+	}
+
+	messages := &mailjet.MessagesV31{Info: []mailjet.InfoMessagesV31{*data}}
+	_, err := s.client.SendMailV31(messages)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEmailSendFailed, err)
+	}
+
+	return nil
+}
+
+// SendPaymentReminderEmail tells the user their subscription payment failed,
+// with what happens next for the grace period status they're in: past_due,
+// unpaid or expired
+func (s *MailjetService) SendPaymentReminderEmail(email, status string, graceEndsAt time.Time, billingURL string) error {
+	// Check if the service is properly configured
+	if s.client == nil {
+		return ErrEmailServiceNotConfigured
+	}
+
+	ends := graceEndsAt.UTC().Format("January 2, 2006")
+	var subject, text, heading, body string
+	switch status {
+	case "past_due":
+		subject = "We couldn't take your Virtual Armory payment"
+		heading = "Your Payment Didn't Go Through"
+		body = fmt.Sprintf("We couldn't take the payment for your Virtual Armory subscription. We'll try again over the next few days, but to keep your plan please update your payment method by %s.", ends)
+	case "unpaid":
+		subject = "Your Virtual Armory payment is overdue"
+		heading = "Your Payment Is Overdue"
+		body = fmt.Sprintf("We still haven't been able to take the payment for your Virtual Armory subscription. Unless you update your payment method, your account moves to the free plan on %s.", ends)
+	default:
+		subject = "Your Virtual Armory subscription has moved to the free plan"
+		heading = "You're on the Free Plan"
+		body = "As we couldn't take the payment for your Virtual Armory subscription, your account has moved to the free plan. Everything you've added is still there. Update your payment method to get your plan back."
+	}
+	text = fmt.Sprintf("%s Update it here: %s", body, billingURL)
+
+	data := &mailjet.InfoMessagesV31{
+		From: &mailjet.RecipientV31{
+			Email: s.senderEmail,
+			Name:  s.senderName,
+		},
+		To: &mailjet.RecipientsV31{
+			mailjet.RecipientV31{
+				Email: email,
+			},
+		},
+		Subject:  subject,
+		TextPart: text,
+		HTMLPart: fmt.Sprintf(`
+			<h3>%s</h3>
+			<p>%s</p>
+			<p><a href="%s">Update Your Payment Method</a></p>
+		`, heading, body, billingURL),
+	}
+
+	messages := &mailjet.MessagesV31{Info: []mailjet.InfoMessagesV31{*data}}
+	_, err := s.client.SendMailV31(messages)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEmailSendFailed, err)
+	}
+
+	return nil
+}
+
 // SendContactEmail sends a contact form submission to the admin
 func (s *MailjetService) SendContactEmail(name, email, subject, message string) error {
 	// Check if the service is properly configured
@@ -3,6 +3,7 @@ package email
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -23,6 +24,21 @@ func (m *MockEmailService) SendPasswordResetEmail(email, token, baseURL string)
 	return args.Error(0)
 }
 
+func (m *MockEmailService) SendAccountLockedEmail(email, token, baseURL string) error {
+	args := m.Called(email, token, baseURL)
+	return args.Error(0)
+}
+
+func (m *MockEmailService) SendDataExportEmail(email, downloadURL string, expiresAt time.Time) error {
+	args := m.Called(email, downloadURL, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockEmailService) SendPaymentReminderEmail(email, status string, graceEndsAt time.Time, billingURL string) error {
+	args := m.Called(email, status, graceEndsAt, billingURL)
+	return args.Error(0)
+}
+
 func (m *MockEmailService) SendContactEmail(name, email, subject, message string) error {
 	args := m.Called(name, email, subject, message)
 	return args.Error(0)
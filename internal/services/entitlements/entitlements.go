@@ -0,0 +1,241 @@
+// Package entitlements answers what a user's subscription lets them do: how
+// many guns and ammunition lots they may keep, and how much photo storage
+// they have. It's the one place the tier's plan, admin grants and
+// promotions are weighed, so every limit is enforced and explained the same way.
+package entitlements
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/dunning"
+	"gorm.io/gorm"
+)
+
+// Resource is something a plan limits how many of an owner may keep
+type Resource string
+
+// Resources plans can limit
+const (
+	Guns Resource = "guns"
+	Ammo Resource = "ammo"
+)
+
+// Where a user's entitlements come from
+const (
+	SourcePlan       = "plan"        // The plan for their subscription tier
+	SourceAdminGrant = "admin_grant" // A subscription an admin granted
+	SourcePromotion  = "promotion"   // A promotion they signed up under
+	SourceLapsed     = "lapsed"      // A subscription, grant or promotion that has ended, so the free plan applies
+)
+
+// PromotionTier is the subscription tier of users on a promotion
+const PromotionTier = "promotion"
+
+// Entitlements are what a user may do right now
+type Entitlements struct {
+	Plan   models.Plan // The plan whose limits apply
+	Source string
+	Until  time.Time // When they lapse, zero if they don't
+}
+
+// For works out a user's entitlements as of now
+func For(user *database.User) Entitlements {
+	return at(user, time.Now())
+}
+
+// at works out a user's entitlements at a given time
+func at(user *database.User, now time.Time) Entitlements {
+	if user == nil {
+		return Entitlements{Plan: models.FreePlan(), Source: SourcePlan}
+	}
+	lapsed := !user.SubscriptionEndDate.IsZero() && now.After(user.SubscriptionEndDate)
+
+	// Admin grants last until their end date, or forever
+	if user.IsAdminGranted {
+		if !user.IsLifetime && lapsed {
+			return Entitlements{Plan: models.FreePlan(), Source: SourceLapsed}
+		}
+		e := Entitlements{Plan: tierPlan(user.SubscriptionTier), Source: SourceAdminGrant}
+		if !user.IsLifetime {
+			e.Until = user.SubscriptionEndDate
+		}
+		return e
+	}
+
+	// Promotions give full access for their benefit days
+	if user.SubscriptionTier == PromotionTier {
+		if lapsed {
+			return Entitlements{Plan: models.FreePlan(), Source: SourceLapsed}
+		}
+		plan := tierPlan(PromotionTier)
+		plan.Name = "Promotion"
+		return Entitlements{Plan: plan, Source: SourcePromotion, Until: user.SubscriptionEndDate}
+	}
+
+	plan := tierPlan(user.SubscriptionTier)
+	if plan.Free() || plan.Lifetime() {
+		return Entitlements{Plan: plan, Source: SourcePlan}
+	}
+
+	// A failed payment keeps the plan until its grace period ends, even
+	// though the subscription's end date has passed
+	if dunning.InGrace(user) {
+		if dunning.Lapsed(user, now) {
+			return Entitlements{Plan: models.FreePlan(), Source: SourceLapsed}
+		}
+		return Entitlements{Plan: plan, Source: SourcePlan, Until: dunning.GraceEnds(user)}
+	}
+
+	// Stripe keeps an active subscription's end date moving, so only one that
+	// has stopped being active and passed its end date has lapsed
+	if lapsed && user.SubscriptionStatus != "active" {
+		return Entitlements{Plan: models.FreePlan(), Source: SourceLapsed}
+	}
+	return Entitlements{Plan: plan, Source: SourcePlan, Until: user.SubscriptionEndDate}
+}
+
+// tierPlan returns the plan for a subscription tier. Tiers that aren't
+// plans, such as promotions and admin grants, have no limits and the free
+// tier's photo storage.
+func tierPlan(tier string) models.Plan {
+	if plan, ok := models.LookupPlan(tier); ok {
+		return plan
+	}
+	return models.Plan{Tier: tier, Name: tier, StorageQuota: models.FreePlan().StorageQuota}
+}
+
+// Limit returns how many of a resource the user may keep, 0 meaning no limit
+func (e Entitlements) Limit(resource Resource) int {
+	switch resource {
+	case Guns:
+		return e.Plan.MaxGuns
+	case Ammo:
+		return e.Plan.MaxAmmo
+	}
+	return 0
+}
+
+// StorageQuota returns how many bytes of photos the user may store
+func (e Entitlements) StorageQuota() int64 {
+	return e.Plan.StorageQuota
+}
+
+// Allowance returns how the user's limit for a resource stands when they
+// already have used of it
+func (e Entitlements) Allowance(resource Resource, used int64) Allowance {
+	return Allowance{
+		Resource: resource,
+		Plan:     e.Plan,
+		Limit:    e.Limit(resource),
+		Used:     used,
+	}
+}
+
+// Allowance is how a user's limit for a resource stands
+type Allowance struct {
+	Resource Resource
+	Plan     models.Plan
+	Limit    int // 0 means no limit
+	Used     int64
+}
+
+// Unlimited reports whether the user may keep as many as they like
+func (a Allowance) Unlimited() bool {
+	return a.Limit == 0
+}
+
+// Remaining returns how many more the user may add, which is meaningless
+// when Unlimited
+func (a Allowance) Remaining() int {
+	if remaining := int64(a.Limit) - a.Used; remaining > 0 {
+		return int(remaining)
+	}
+	return 0
+}
+
+// CanAdd reports whether the user may add another
+func (a Allowance) CanAdd() bool {
+	return a.Unlimited() || a.Used < int64(a.Limit)
+}
+
+// Over reports whether the user has more than their plan lets them see,
+// usually because they kept them from an ended subscription
+func (a Allowance) Over() bool {
+	return !a.Unlimited() && a.Used > int64(a.Limit)
+}
+
+// DeniedMessage explains why the user can't add another
+func (a Allowance) DeniedMessage() string {
+	return fmt.Sprintf("You must be subscribed to add more to your %s", a.place())
+}
+
+// OverMessage explains why the user is only shown some of what they have
+func (a Allowance) OverMessage() string {
+	return fmt.Sprintf("%s tier only allows %d %s. You have %d in your %s. Subscribe to see more.",
+		a.Plan.Name, a.Limit, a.noun(), a.Used, a.shortPlace())
+}
+
+// Summary describes how much of the limit is left, for showing alongside it
+func (a Allowance) Summary() string {
+	if a.Unlimited() {
+		return fmt.Sprintf("Unlimited %s on the %s plan", a.noun(), a.Plan.Name)
+	}
+	return fmt.Sprintf("%d of %d %s left on the %s plan", a.Remaining(), a.Limit, a.noun(), a.Plan.Name)
+}
+
+// noun names the resource in messages
+func (a Allowance) noun() string {
+	if a.Resource == Ammo {
+		return "ammunition items"
+	}
+	return "guns"
+}
+
+// place is where the owner keeps the resource
+func (a Allowance) place() string {
+	if a.Resource == Ammo {
+		return "munitions depot"
+	}
+	return "arsenal"
+}
+
+// shortPlace is place without its qualifier, as the over limit message has used the noun
+func (a Allowance) shortPlace() string {
+	if a.Resource == Ammo {
+		return "depot"
+	}
+	return "arsenal"
+}
+
+// Service counts what users have, to check their entitlements against it
+type Service struct {
+	db *gorm.DB
+}
+
+// NewService returns an entitlements service counting records in db
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Check returns the user's allowance for a resource, counting what they have
+func (s *Service) Check(user *database.User, resource Resource) (Allowance, error) {
+	used, err := s.Count(user.ID, resource)
+	if err != nil {
+		return Allowance{}, err
+	}
+	return For(user).Allowance(resource, used), nil
+}
+
+// Count returns how many of a resource an owner has
+func (s *Service) Count(ownerID uint, resource Resource) (int64, error) {
+	var model interface{} = &models.Gun{}
+	if resource == Ammo {
+		model = &models.Ammo{}
+	}
+	var count int64
+	err := s.db.Model(model).Where("owner_id = ?", ownerID).Count(&count).Error
+	return count, err
+}
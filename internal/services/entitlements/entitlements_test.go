@@ -0,0 +1,84 @@
+package entitlements
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntitlementsAt(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	past := now.AddDate(0, 0, -1)
+	future := now.AddDate(0, 0, 10)
+	failed := now.AddDate(0, 0, -3)
+
+	tests := []struct {
+		name      string
+		user      *database.User
+		source    string
+		plan      string
+		maxGuns   int
+		until     time.Time
+		unlimited bool
+	}{
+		{"no user", nil, SourcePlan, "free", 2, time.Time{}, false},
+		{"free", &database.User{SubscriptionTier: "free"}, SourcePlan, "free", 2, time.Time{}, false},
+		{"monthly", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "active", SubscriptionEndDate: future}, SourcePlan, "monthly", 0, future, true},
+		{"monthly renewal webhook late", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "active", SubscriptionEndDate: past}, SourcePlan, "monthly", 0, past, true},
+		{"monthly canceled before its end", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "canceled", SubscriptionEndDate: future}, SourcePlan, "monthly", 0, future, true},
+		{"monthly canceled and ended", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "canceled", SubscriptionEndDate: past}, SourceLapsed, "free", 2, time.Time{}, false},
+		{"monthly payment failed", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "past_due", SubscriptionEndDate: past, PaymentFailedAt: failed}, SourcePlan, "monthly", 0, failed.AddDate(0, 0, 14), true},
+		{"monthly payment overdue", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "unpaid", SubscriptionEndDate: past, PaymentFailedAt: failed}, SourcePlan, "monthly", 0, failed.AddDate(0, 0, 14), true},
+		{"monthly grace period lapsed", &database.User{SubscriptionTier: "monthly", SubscriptionStatus: "unpaid", SubscriptionEndDate: past, PaymentFailedAt: now.AddDate(0, 0, -20)}, SourceLapsed, "free", 2, time.Time{}, false},
+		{"monthly expired for not paying", &database.User{SubscriptionTier: "free", SubscriptionStatus: "expired", SubscriptionEndDate: past, PaymentFailedAt: now.AddDate(0, 0, -20)}, SourcePlan, "free", 2, time.Time{}, false},
+		{"lifetime", &database.User{SubscriptionTier: "lifetime", SubscriptionEndDate: past}, SourcePlan, "lifetime", 0, time.Time{}, true},
+		{"promotion", &database.User{SubscriptionTier: PromotionTier, SubscriptionStatus: "active", SubscriptionEndDate: future}, SourcePromotion, PromotionTier, 0, future, true},
+		{"promotion ended", &database.User{SubscriptionTier: PromotionTier, SubscriptionStatus: "active", SubscriptionEndDate: past}, SourceLapsed, "free", 2, time.Time{}, false},
+		{"admin grant", &database.User{SubscriptionTier: "admin_grant", IsAdminGranted: true, SubscriptionEndDate: future}, SourceAdminGrant, "admin_grant", 0, future, true},
+		{"admin grant of a plan", &database.User{SubscriptionTier: "yearly", IsAdminGranted: true, SubscriptionEndDate: future}, SourceAdminGrant, "yearly", 0, future, true},
+		{"lifetime admin grant", &database.User{SubscriptionTier: "admin_grant", IsAdminGranted: true, IsLifetime: true, SubscriptionEndDate: past}, SourceAdminGrant, "admin_grant", 0, time.Time{}, true},
+		{"admin grant ended", &database.User{SubscriptionTier: "admin_grant", IsAdminGranted: true, SubscriptionEndDate: past}, SourceLapsed, "free", 2, time.Time{}, false},
+		{"tier that isn't a plan", &database.User{SubscriptionTier: "legacy"}, SourcePlan, "legacy", 0, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := at(tt.user, now)
+			assert.Equal(t, tt.source, e.Source)
+			assert.Equal(t, tt.plan, e.Plan.Tier)
+			assert.Equal(t, tt.maxGuns, e.Limit(Guns))
+			assert.Equal(t, tt.until, e.Until)
+			assert.Equal(t, tt.unlimited, e.Allowance(Ammo, 100).Unlimited())
+			assert.NotZero(t, e.StorageQuota(), "Everyone can store some photos")
+		})
+	}
+}
+
+func TestAllowance(t *testing.T) {
+	free := at(&database.User{SubscriptionTier: "free"}, time.Now())
+
+	under := free.Allowance(Guns, 1)
+	assert.True(t, under.CanAdd())
+	assert.False(t, under.Over())
+	assert.Equal(t, 1, under.Remaining())
+	assert.Equal(t, "1 of 2 guns left on the Free plan", under.Summary())
+
+	full := free.Allowance(Ammo, 4)
+	assert.False(t, full.CanAdd())
+	assert.False(t, full.Over())
+	assert.Equal(t, 0, full.Remaining())
+	assert.Equal(t, "You must be subscribed to add more to your munitions depot", full.DeniedMessage())
+
+	over := free.Allowance(Guns, 5)
+	assert.False(t, over.CanAdd())
+	assert.True(t, over.Over())
+	assert.Equal(t, 0, over.Remaining())
+	assert.Equal(t, "You must be subscribed to add more to your arsenal", over.DeniedMessage())
+	assert.Equal(t, "Free tier only allows 2 guns. You have 5 in your arsenal. Subscribe to see more.", over.OverMessage())
+
+	monthly := at(&database.User{SubscriptionTier: "monthly"}, time.Now()).Allowance(Guns, 500)
+	assert.True(t, monthly.CanAdd())
+	assert.False(t, monthly.Over())
+	assert.Equal(t, "Unlimited guns on the Liking It plan", monthly.Summary())
+}
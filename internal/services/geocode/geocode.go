@@ -0,0 +1,66 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when an address can't be placed on the map
+	ErrNotFound = errors.New("address not found")
+	// ErrUnknownProvider is returned when GEOCODER names a provider that doesn't exist
+	ErrUnknownProvider = errors.New("unknown geocoder provider")
+)
+
+// Address is the part of a postal address the geocoder looks up
+type Address struct {
+	Street string
+	City   string
+	State  string
+	Zip    string
+}
+
+// String joins the non-empty parts of the address, e.g. "123 Main St, Austin, TX 78701"
+func (a Address) String() string {
+	parts := []string{}
+	for _, part := range []string{a.Street, a.City} {
+		if strings.TrimSpace(part) != "" {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	}
+	stateZip := strings.TrimSpace(strings.TrimSpace(a.State) + " " + strings.TrimSpace(a.Zip))
+	if stateZip != "" {
+		parts = append(parts, stateZip)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Location is a point on the map
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Geocoder turns an address into a location
+type Geocoder interface {
+	Geocode(ctx context.Context, address Address) (*Location, error)
+}
+
+// NewGeocoder returns the geocoder named by the GEOCODER environment variable.
+// "stub" uses the local StubGeocoder, which only knows the center of each
+// state. Without a provider, or with "none", geocoding is off and a nil
+// Geocoder is returned.
+func NewGeocoder() (Geocoder, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("GEOCODER")))
+	switch provider {
+	case "", "none":
+		return nil, nil
+	case "stub":
+		return NewStubGeocoder(), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, provider)
+	}
+}
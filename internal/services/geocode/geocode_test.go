@@ -0,0 +1,57 @@
+package geocode
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressString(t *testing.T) {
+	assert.Equal(t, "123 Main St, Austin, TX 78701", Address{Street: "123 Main St", City: "Austin", State: "TX", Zip: "78701"}.String())
+	assert.Equal(t, "Austin, TX", Address{City: " Austin ", State: "TX"}.String())
+	assert.Equal(t, "", Address{}.String())
+}
+
+func TestStubGeocoder(t *testing.T) {
+	g := NewStubGeocoder()
+	g.Known["123 main st, austin, tx 78701"] = Location{Latitude: 30.2672, Longitude: -97.7431}
+
+	// Known addresses resolve exactly, ignoring case
+	location, err := g.Geocode(context.Background(), Address{Street: "123 Main St", City: "Austin", State: "TX", Zip: "78701"})
+	require.NoError(t, err)
+	assert.Equal(t, 30.2672, location.Latitude)
+	assert.Equal(t, -97.7431, location.Longitude)
+
+	// Other addresses fall back to the center of their state
+	location, err = g.Geocode(context.Background(), Address{City: "Denver", State: "co"})
+	require.NoError(t, err)
+	assert.Equal(t, stateCenters["CO"], *location)
+
+	// Addresses without a known state aren't found
+	_, err = g.Geocode(context.Background(), Address{City: "Nowhere"})
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestNewGeocoder(t *testing.T) {
+	t.Setenv("GEOCODER", "")
+	g, err := NewGeocoder()
+	require.NoError(t, err)
+	assert.Nil(t, g, "Geocoding is off when no provider is set, in tests too")
+
+	t.Setenv("GEOCODER", "stub")
+	g, err = NewGeocoder()
+	require.NoError(t, err)
+	assert.IsType(t, &StubGeocoder{}, g)
+
+	t.Setenv("GEOCODER", "none")
+	g, err = NewGeocoder()
+	require.NoError(t, err)
+	assert.Nil(t, g)
+
+	t.Setenv("GEOCODER", "carrier-pigeon")
+	_, err = NewGeocoder()
+	assert.True(t, errors.Is(err, ErrUnknownProvider))
+}
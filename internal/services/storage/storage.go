@@ -0,0 +1,74 @@
+// Package storage keeps uploaded files, such as photo attachments, behind a
+// small interface so they can live on the local disk or in an S3-compatible
+// bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrNotFound is returned when there's no file stored under a key
+	ErrNotFound = errors.New("file not found")
+	// ErrInvalidKey is returned for keys that are empty or could escape the storage root
+	ErrInvalidKey = errors.New("invalid storage key")
+	// ErrUnknownDriver is returned when STORAGE_DRIVER names a driver that doesn't exist
+	ErrUnknownDriver = errors.New("unknown storage driver")
+)
+
+// defaultLocalPath is where the local driver keeps files when STORAGE_LOCAL_PATH isn't set
+const defaultLocalPath = "data/attachments"
+
+// Storage keeps files under slash separated keys, e.g. "attachments/12/3f9a.jpg"
+type Storage interface {
+	// Put stores size bytes from r under key, replacing any file already there
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open reads the file stored under key, returning ErrNotFound if there isn't one
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the file stored under key; deleting a missing file is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStorage returns the storage named by the STORAGE_DRIVER environment variable.
+// "local" (the default) keeps files under STORAGE_LOCAL_PATH and "s3" uses the
+// bucket described by the S3_* variables.
+func NewStorage() (Storage, error) {
+	driver := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_DRIVER")))
+	switch driver {
+	case "", "local":
+		path := os.Getenv("STORAGE_LOCAL_PATH")
+		if path == "" {
+			path = defaultLocalPath
+		}
+		return NewLocalStorage(path), nil
+	case "s3":
+		return NewS3Storage(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       os.Getenv("S3_FORCE_PATH_STYLE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDriver, driver)
+	}
+}
+
+// checkKey makes sure a key is a relative slash separated path without "." or ".." parts
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package stripe
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/dunning"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/checkout/session"
+	"github.com/stripe/stripe-go/v72/customer"
+	"github.com/stripe/stripe-go/v72/price"
+	"github.com/stripe/stripe-go/v72/sub"
+)
+
+var (
+	// ErrNoStripeSubscription is returned when a user has no recurring Stripe subscription to change
+	ErrNoStripeSubscription = errors.New("there's no Stripe subscription to change")
+
+	// ErrNoStripeCustomer is returned when a user has never been a Stripe customer
+	ErrNoStripeCustomer = errors.New("there's no Stripe billing account for this user")
+
+	// ErrPlanChangeInvalid is returned when a subscription can't be moved to a plan
+	ErrPlanChangeInvalid = errors.New("subscriptions can only move to another monthly or yearly plan")
+)
+
+// prorationBehavior charges or credits the difference for the rest of the
+// period straight away, rather than on the next invoice
+const prorationBehavior = "always_invoice"
+
+// PlanChangePreview is what moving a subscription to another plan would cost
+type PlanChangePreview struct {
+	Plan          models.Plan
+	AmountDue     int64 // Cents charged straight away, after credit for the unused time on the current plan
+	Currency      string
+	ProrationDate int64 // Kept by the caller and passed to ChangePlan so the charge matches the preview
+}
+
+// AmountLabel formats the amount due, e.g. "$25.00"
+func (p *PlanChangePreview) AmountLabel() string {
+	amount := p.AmountDue
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, amount/100, amount%100)
+}
+
+// PreviewPlanChange asks Stripe what moving the user's subscription to
+// another plan would cost, prorated from now
+func (s *service) PreviewPlanChange(user *database.User, tier string) (*PlanChangePreview, error) {
+	plan, err := planChange(user, tier)
+	if err != nil {
+		return nil, err
+	}
+	itemID, err := subscriptionItemID(user.StripeSubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	priceID, err := s.priceForPlan(plan)
+	if err != nil {
+		return nil, err
+	}
+
+	prorationDate := time.Now().Unix()
+	params := &stripe.InvoiceParams{
+		Customer:     stripe.String(user.StripeCustomerID),
+		Subscription: stripe.String(user.StripeSubscriptionID),
+		SubscriptionItems: []*stripe.SubscriptionItemsParams{
+			{ID: stripe.String(itemID), Price: stripe.String(priceID)},
+		},
+		SubscriptionProrationBehavior: stripe.String(prorationBehavior),
+		SubscriptionProrationDate:     stripe.Int64(prorationDate),
+	}
+	invoice := &stripe.Invoice{}
+	if err := callStripe(http.MethodGet, "/v1/invoices/upcoming", params, invoice); err != nil {
+		return nil, err
+	}
+
+	return &PlanChangePreview{
+		Plan:          plan,
+		AmountDue:     invoice.AmountDue,
+		Currency:      string(invoice.Currency),
+		ProrationDate: prorationDate,
+	}, nil
+}
+
+// ChangePlan moves the user's subscription to another plan, charging or
+// crediting the prorated difference, and updates the user to match. A
+// proration date from PreviewPlanChange makes the charge match the preview.
+func (s *service) ChangePlan(user *database.User, tier string, prorationDate int64) error {
+	plan, err := planChange(user, tier)
+	if err != nil {
+		return err
+	}
+	itemID, err := subscriptionItemID(user.StripeSubscriptionID)
+	if err != nil {
+		return err
+	}
+	priceID, err := s.priceForPlan(plan)
+	if err != nil {
+		return err
+	}
+
+	params := &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{
+			{ID: stripe.String(itemID), Price: stripe.String(priceID)},
+		},
+		ProrationBehavior: stripe.String(prorationBehavior),
+		// Moving plans keeps the subscription, so it no longer ends
+		CancelAtPeriodEnd: stripe.Bool(false),
+		// Leave the subscription as it was if the card is declined
+		PaymentBehavior: stripe.String("error_if_incomplete"),
+	}
+	if prorationDate > 0 {
+		params.ProrationDate = stripe.Int64(prorationDate)
+	}
+	params.AddMetadata("tier", plan.Tier)
+
+	subscription, err := sub.Update(user.StripeSubscriptionID, params)
+	if err != nil {
+		return err
+	}
+
+	// The customer.subscription.updated event does the same, but the user
+	// shouldn't have to wait for it to see their new plan
+	syncSubscription(user, subscription)
+	return s.db.UpdateUser(nil, user)
+}
+
+// CreatePortalSession creates a Stripe billing portal session, where the
+// user can manage their subscription, payment methods and invoices
+func (s *service) CreatePortalSession(user *database.User) (*stripe.BillingPortalSession, error) {
+	if user.StripeCustomerID == "" {
+		return nil, ErrNoStripeCustomer
+	}
+
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(user.StripeCustomerID),
+		ReturnURL: stripe.String(appBaseURL() + "/owner/profile/subscription"),
+	}
+	// A portal configuration can limit what users may change there
+	if configuration := os.Getenv("STRIPE_PORTAL_CONFIGURATION"); configuration != "" {
+		params.Configuration = stripe.String(configuration)
+	}
+
+	portal := &stripe.BillingPortalSession{}
+	if err := callStripe(http.MethodPost, "/v1/billing_portal/sessions", params, portal); err != nil {
+		return nil, err
+	}
+	return portal, nil
+}
+
+// CreatePaymentMethodSession creates a Stripe checkout session that collects
+// a new card. Once it completes, the webhook makes the card the one the
+// subscription is charged to.
+func (s *service) CreatePaymentMethodSession(user *database.User) (*stripe.CheckoutSession, error) {
+	if user.StripeCustomerID == "" {
+		return nil, ErrNoStripeCustomer
+	}
+
+	params := &stripe.CheckoutSessionParams{
+		Customer:           stripe.String(user.StripeCustomerID),
+		Mode:               stripe.String(string(stripe.CheckoutSessionModeSetup)),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		SuccessURL:         stripe.String(appBaseURL() + "/owner/profile/subscription?payment_method=updated"),
+		CancelURL:          stripe.String(appBaseURL() + "/owner/profile/subscription"),
+	}
+	return session.New(params)
+}
+
+// updatePaymentMethod makes the card collected by a setup checkout session the
+// customer's default, and the one their subscription is charged to
+func (s *service) updatePaymentMethod(checkout *stripe.CheckoutSession) error {
+	if checkout.Customer == nil || checkout.SetupIntent == nil {
+		return fmt.Errorf("setup checkout session %s has no customer or setup intent", checkout.ID)
+	}
+
+	user, err := s.db.GetUserByStripeCustomerID(checkout.Customer.ID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user not found for Stripe customer ID: %s", checkout.Customer.ID)
+	}
+
+	intent := &stripe.SetupIntent{}
+	if err := callStripe(http.MethodGet, "/v1/setup_intents/"+checkout.SetupIntent.ID, nil, intent); err != nil {
+		return err
+	}
+	if intent.PaymentMethod == nil {
+		return fmt.Errorf("setup intent %s has no payment method", intent.ID)
+	}
+
+	_, err = customer.Update(user.StripeCustomerID, &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(intent.PaymentMethod.ID),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if user.StripeSubscriptionID != "" {
+		_, err = sub.Update(user.StripeSubscriptionID, &stripe.SubscriptionParams{
+			DefaultPaymentMethod: stripe.String(intent.PaymentMethod.ID),
+		})
+	}
+	return err
+}
+
+// syncSubscription copies a Stripe subscription's plan, status and period
+// onto the user
+func syncSubscription(user *database.User, subscription *stripe.Subscription) {
+	if subscription.Items != nil && len(subscription.Items.Data) > 0 {
+		if plan, ok := planForPrice(subscription.Items.Data[0].Price); ok {
+			user.SubscriptionTier = plan.Tier
+		}
+	}
+
+	user.StripeSubscriptionID = subscription.ID
+
+	// Failed payments are followed through the grace period rather than
+	// taking Stripe's status as it is
+	switch subscription.Status {
+	case stripe.SubscriptionStatusPastDue:
+		dunning.PaymentFailed(user, time.Now())
+	case stripe.SubscriptionStatusUnpaid:
+		dunning.PaymentUnpaid(user, time.Now())
+	default:
+		dunning.PaymentSucceeded(user)
+		user.SubscriptionStatus = string(subscription.Status)
+		if subscription.Status == stripe.SubscriptionStatusActive && subscription.CancelAtPeriodEnd {
+			user.SubscriptionStatus = "pending_cancellation"
+		}
+	}
+
+	// A subscription whose grace period has lapsed stays on the free plan
+	if user.SubscriptionStatus == dunning.StatusExpired {
+		user.SubscriptionTier = models.FreeTier
+	}
+
+	if subscription.CurrentPeriodEnd > 0 {
+		user.SubscriptionEndDate = time.Unix(subscription.CurrentPeriodEnd, 0)
+	}
+}
+
+// PlanChanges returns the plans a user's subscription can be moved to
+func PlanChanges(user *database.User) []models.Plan {
+	var plans []models.Plan
+	if !user.HasStripeManagedSubscription() {
+		return plans
+	}
+	for _, plan := range models.CatalogPlans() {
+		if canChangeTo(user, plan) {
+			plans = append(plans, plan)
+		}
+	}
+	return plans
+}
+
+// planChange returns the plan a user's subscription can be moved to
+func planChange(user *database.User, tier string) (models.Plan, error) {
+	// A subscription due to cancel can still change plan, which keeps it going
+	if !user.HasStripeManagedSubscription() {
+		return models.Plan{}, ErrNoStripeSubscription
+	}
+	plan, ok := models.LookupPlan(tier)
+	if !ok || !canChangeTo(user, plan) {
+		return models.Plan{}, ErrPlanChangeInvalid
+	}
+	return plan, nil
+}
+
+// canChangeTo reports whether a subscription can move to a plan. Only
+// recurring plans can, as lifetime plans are bought outright at checkout.
+func canChangeTo(user *database.User, plan models.Plan) bool {
+	return plan.Purchasable() && plan.Recurring() && plan.Tier != user.SubscriptionTier
+}
+
+// subscriptionItemID returns the ID of the item that holds a subscription's price
+func subscriptionItemID(subscriptionID string) (string, error) {
+	subscription, err := sub.Get(subscriptionID, nil)
+	if err != nil {
+		return "", err
+	}
+	if subscription.Items == nil || len(subscription.Items.Data) == 0 {
+		return "", fmt.Errorf("subscription %s has no items", subscriptionID)
+	}
+	return subscription.Items.Data[0].ID, nil
+}
+
+// priceForPlan returns the plan's Stripe price. A plan without one gets the
+// price with its lookup key, or a new one, and the ID is saved on the plan so
+// later calls don't go back to Stripe.
+func (s *service) priceForPlan(plan models.Plan) (string, error) {
+	if plan.StripePriceID != "" {
+		return plan.StripePriceID, nil
+	}
+
+	priceID, err := findPrice(planLookupKey(plan))
+	if err != nil {
+		return "", err
+	}
+	if priceID == "" {
+		productID, err := getProductIDForTier(plan.Tier)
+		if err != nil {
+			return "", err
+		}
+		if priceID, err = s.createPrice(productID, plan); err != nil {
+			return "", err
+		}
+	}
+
+	if err := models.SetPlanStripePrice(s.db.GetDB(), plan, priceID); err != nil {
+		// The lookup key finds the price again next time
+		logger.Error("Failed to save Stripe price on plan", err, map[string]interface{}{
+			"tier":     plan.Tier,
+			"price_id": priceID,
+		})
+	}
+	return priceID, nil
+}
+
+// planLookupKey names the Stripe price for a plan as it's priced now, so a
+// price change gets a new Stripe price
+func planLookupKey(plan models.Plan) string {
+	return fmt.Sprintf("armory_%s_%s_%d_%s", plan.Tier, plan.Currency, plan.Price, plan.Interval)
+}
+
+// findPrice returns the ID of the active Stripe price with a lookup key, or
+// an empty string if there isn't one
+func findPrice(lookupKey string) (string, error) {
+	params := &stripe.PriceListParams{
+		Active:     stripe.Bool(true),
+		LookupKeys: []*string{stripe.String(lookupKey)},
+	}
+	iter := price.List(params)
+	if iter.Next() {
+		return iter.Price().ID, nil
+	}
+	return "", iter.Err()
+}
+
+// callStripe calls a Stripe endpoint the vendored client packages don't cover
+func callStripe(method, path string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	return stripe.GetBackend(stripe.APIBackend).Call(method, path, stripe.Key, params, v)
+}
+
+// appBaseURL is where Stripe sends users back to
+func appBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
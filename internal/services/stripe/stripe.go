@@ -10,7 +10,9 @@ import (
 	"time"
 
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/logger"
 	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/dunning"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/checkout/session"
 	"github.com/stripe/stripe-go/v72/customer"
@@ -19,14 +21,31 @@ import (
 	"github.com/stripe/stripe-go/v72/webhook"
 )
 
+var (
+	// ErrEventNotFound is returned when replaying an event that isn't stored
+	ErrEventNotFound = errors.New("stripe event not found")
+	// ErrEventAlreadyProcessed is returned when replaying an event that has been processed
+	ErrEventAlreadyProcessed = errors.New("stripe event has already been processed")
+	// ErrEventInProgress is returned when replaying an event that's being processed
+	ErrEventInProgress = errors.New("stripe event is being processed")
+)
+
+// eventProcessingTimeout is how long an event can be left processing before
+// another delivery or a replay may process it again
+const eventProcessingTimeout = 10 * time.Minute
+
 // Service defines the interface for Stripe operations
 type Service interface {
 	// CreateCheckoutSession creates a Stripe checkout session for a subscription
 	CreateCheckoutSession(user *database.User, tier string) (*stripe.CheckoutSession, error)
 
-	// HandleWebhook handles Stripe webhook events
+	// HandleWebhook handles Stripe webhook events. Every event is stored, and
+	// one that has already been processed is skipped.
 	HandleWebhook(payload []byte, signature string) error
 
+	// ReplayEvent processes a stored webhook event again, e.g. after it failed
+	ReplayEvent(id uint) error
+
 	// GetSubscriptionDetails gets details about a subscription
 	GetSubscriptionDetails(subscriptionID string) (*stripe.Subscription, error)
 
@@ -38,6 +57,21 @@ type Service interface {
 	// CancelSubscriptionImmediately cancels a subscription immediately, stopping it right away
 	// instead of letting it continue until the end of the billing period.
 	CancelSubscriptionImmediately(subscriptionID string) error
+
+	// PreviewPlanChange previews the prorated cost of moving a user's
+	// subscription to another monthly or yearly plan
+	PreviewPlanChange(user *database.User, tier string) (*PlanChangePreview, error)
+
+	// ChangePlan moves a user's subscription to another monthly or yearly
+	// plan, prorated from the preview's proration date
+	ChangePlan(user *database.User, tier string, prorationDate int64) error
+
+	// CreatePortalSession creates a Stripe billing portal session for a user
+	CreatePortalSession(user *database.User) (*stripe.BillingPortalSession, error)
+
+	// CreatePaymentMethodSession creates a Stripe checkout session that
+	// replaces the card a user's subscription is charged to
+	CreatePaymentMethodSession(user *database.User) (*stripe.CheckoutSession, error)
 }
 
 // service implements the Service interface
@@ -57,10 +91,10 @@ func NewService(db database.Service) Service {
 
 // CreateCheckoutSession creates a Stripe checkout session for a subscription
 func (s *service) CreateCheckoutSession(user *database.User, tier string) (*stripe.CheckoutSession, error) {
-	// Get the product ID for the tier
-	productID, err := getProductIDForTier(tier)
-	if err != nil {
-		return nil, err
+	// Look up the plan being bought
+	plan, ok := models.LookupPlan(tier)
+	if !ok || !plan.Purchasable() {
+		return nil, fmt.Errorf("invalid subscription tier: %s", tier)
 	}
 
 	// Create or get a Stripe customer
@@ -70,13 +104,10 @@ func (s *service) CreateCheckoutSession(user *database.User, tier string) (*stri
 	}
 
 	// Get the base URL for success and cancel URLs
-	baseURL := os.Getenv("APP_BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:3000"
-	}
+	baseURL := appBaseURL()
 
-	// Create a price for the product
-	priceID, err := s.createPrice(productID, tier)
+	// Use the plan's Stripe price, creating one for its product if it has none
+	priceID, err := s.priceForPlan(plan)
 	if err != nil {
 		return nil, err
 	}
@@ -110,8 +141,11 @@ func (s *service) CreateCheckoutSession(user *database.User, tier string) (*stri
 		},
 	}
 
+	// Record the tier so the webhook knows what was bought
+	params.AddMetadata("tier", plan.Tier)
+
 	// For one-time payments (lifetime subscriptions)
-	if tier == "lifetime" || tier == "premium_lifetime" {
+	if plan.Lifetime() {
 		params.Mode = stripe.String(string(stripe.CheckoutSessionModePayment))
 	}
 
@@ -138,6 +172,79 @@ func (s *service) HandleWebhook(payload []byte, signature string) error {
 		return err
 	}
 
+	// Store the event, so a retried delivery is only processed once
+	record, err := models.RecordStripeEvent(s.db.GetDB(), event.ID, event.Type, payload)
+	if err != nil {
+		return err
+	}
+
+	// An event another delivery is still processing is refused, so Stripe
+	// delivers it again in case that processing fails
+	err = s.runEvent(record, event)
+	if errors.Is(err, ErrEventAlreadyProcessed) || errors.Is(err, ErrEventInProgress) {
+		logger.Info("Skipping Stripe event that was already delivered", map[string]interface{}{
+			"event_id": event.ID,
+			"type":     event.Type,
+			"status":   record.Status,
+		})
+	}
+	if errors.Is(err, ErrEventAlreadyProcessed) {
+		return nil
+	}
+	return err
+}
+
+// ReplayEvent processes a stored webhook event again, e.g. after it failed
+func (s *service) ReplayEvent(id uint) error {
+	record, err := models.FindStripeEventByID(s.db.GetDB(), id)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return ErrEventNotFound
+	}
+
+	// The payload was checked against its signature when it was received
+	var event stripe.Event
+	if err := json.Unmarshal([]byte(record.Payload), &event); err != nil {
+		return err
+	}
+	return s.runEvent(record, event)
+}
+
+// runEvent processes a stored event unless it's already been processed or is
+// being processed, and records how it went
+func (s *service) runEvent(record *models.StripeEvent, event stripe.Event) error {
+	db := s.db.GetDB()
+	claimed, err := models.ClaimStripeEvent(db, record, eventProcessingTimeout)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		if record.Status == models.StripeEventProcessed {
+			return ErrEventAlreadyProcessed
+		}
+		return ErrEventInProgress
+	}
+
+	processErr := s.processEvent(event)
+	if err := models.FinishStripeEvent(db, record, processErr); err != nil {
+		logger.Error("Failed to record Stripe event result", err, map[string]interface{}{
+			"event_id": event.ID,
+		})
+	}
+	if processErr != nil {
+		logger.Error("Failed to process Stripe event", processErr, map[string]interface{}{
+			"event_id": event.ID,
+			"type":     event.Type,
+			"attempts": record.Attempts,
+		})
+	}
+	return processErr
+}
+
+// processEvent applies a webhook event to the user it's for
+func (s *service) processEvent(event stripe.Event) error {
 	// Handle different event types
 	switch event.Type {
 	case "checkout.session.completed":
@@ -148,6 +255,11 @@ func (s *service) HandleWebhook(payload []byte, signature string) error {
 			return err
 		}
 
+		// Setup sessions collect a new card rather than a payment
+		if session.Mode == stripe.CheckoutSessionModeSetup {
+			return s.updatePaymentMethod(&session)
+		}
+
 		// Process only if the session was successful and has required data
 		if session.PaymentStatus == "paid" && session.ClientReferenceID != "" {
 			// Get the user ID from the client reference ID
@@ -166,74 +278,50 @@ func (s *service) HandleWebhook(payload []byte, signature string) error {
 				return errors.New("user not found")
 			}
 
-			// Get the subscription
-			subscription, err := sub.Get(session.Subscription.ID, nil)
-			if err != nil {
-				return err
-			}
-
 			// Update the user's Stripe customer ID if not already set
-			if user.StripeCustomerID == "" {
+			if user.StripeCustomerID == "" && session.Customer != nil {
 				user.StripeCustomerID = session.Customer.ID
 			}
 
-			// Determine the subscription tier based on the line items
-			var tier string
-			if session.LineItems != nil {
-				items := session.LineItems.Data
-				for _, item := range items {
-					if strings.Contains(strings.ToLower(item.Description), "monthly") {
-						tier = "monthly"
-						break
-					} else if strings.Contains(strings.ToLower(item.Description), "yearly") {
-						tier = "yearly"
-						break
-					} else if strings.Contains(strings.ToLower(item.Description), "lifetime") {
-						tier = "lifetime"
-						break
-					} else if strings.Contains(strings.ToLower(item.Description), "premium") {
-						tier = "premium_lifetime"
+			// Lifetime plans are paid once, so only recurring ones have a subscription
+			var subscription *stripe.Subscription
+			if session.Subscription != nil && session.Subscription.ID != "" {
+				subscription, err = sub.Get(session.Subscription.ID, nil)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Determine the plan from the session, then from what was bought
+			plan, ok := models.LookupPlan(session.Metadata["tier"])
+			if !ok && session.LineItems != nil {
+				for _, item := range session.LineItems.Data {
+					if plan, ok = planForPrice(item.Price); ok {
 						break
 					}
 				}
 			}
-
-			// If tier still not determined, try the subscription items
-			if tier == "" && subscription.Items != nil && len(subscription.Items.Data) > 0 {
+			if !ok && subscription != nil && subscription.Items != nil {
 				for _, item := range subscription.Items.Data {
-					price := item.Price
-					if price != nil && price.Nickname != "" {
-						nickname := strings.ToLower(price.Nickname)
-						if strings.Contains(nickname, "monthly") {
-							tier = "monthly"
-							break
-						} else if strings.Contains(nickname, "yearly") {
-							tier = "yearly"
-							break
-						} else if strings.Contains(nickname, "lifetime") {
-							tier = "lifetime"
-							break
-						} else if strings.Contains(nickname, "premium") {
-							tier = "premium_lifetime"
-							break
-						}
+					if plan, ok = planForPrice(item.Price); ok {
+						break
 					}
 				}
 			}
-
-			// If we still couldn't determine the tier, default to monthly
-			if tier == "" {
-				tier = "monthly"
+			if !ok {
+				return fmt.Errorf("no plan matches checkout session %s", session.ID)
 			}
 
 			// Update the user's subscription information
-			user.SubscriptionTier = tier
+			user.SubscriptionTier = plan.Tier
 			user.SubscriptionStatus = "active"
-			user.StripeSubscriptionID = subscription.ID
 
 			// Set subscription end date based on the tier
-			if subscription.CurrentPeriodEnd > 0 {
-				setSubscriptionEndDate(user, subscription.CurrentPeriodEnd, tier, event.Type)
+			if subscription != nil {
+				user.StripeSubscriptionID = subscription.ID
+				if subscription.CurrentPeriodEnd > 0 {
+					setSubscriptionEndDate(user, subscription.CurrentPeriodEnd, plan.Tier, event.Type)
+				}
 			}
 
 			// Update the user in the database
@@ -268,20 +356,26 @@ func (s *service) HandleWebhook(payload []byte, signature string) error {
 			return fmt.Errorf("user not found for Stripe customer ID: %s", customerID)
 		}
 
-		// Create a payment record
-		payment := &models.Payment{
-			UserID:      user.ID,
-			Amount:      invoice.AmountPaid,
-			Currency:    string(invoice.Currency),
-			PaymentType: "subscription",
-			Status:      "succeeded",
-			Description: "Subscription payment",
-			StripeID:    invoice.ID,
+		// Create a payment record, unless an earlier attempt at this event did
+		existing, err := models.FindPaymentByStripeID(s.db.GetDB(), invoice.ID)
+		if err != nil {
+			return err
 		}
+		if existing == nil {
+			payment := &models.Payment{
+				UserID:      user.ID,
+				Amount:      invoice.AmountPaid,
+				Currency:    string(invoice.Currency),
+				PaymentType: "subscription",
+				Status:      "succeeded",
+				Description: "Subscription payment",
+				StripeID:    invoice.ID,
+			}
 
-		// Save the payment to the database
-		if err := s.db.CreatePayment(payment); err != nil {
-			return err
+			// Save the payment to the database
+			if err := s.db.CreatePayment(payment); err != nil {
+				return err
+			}
 		}
 
 		// Update the user's subscription information
@@ -290,47 +384,29 @@ func (s *service) HandleWebhook(payload []byte, signature string) error {
 			return err
 		}
 
-		// Determine the subscription tier from the product
-		var tier string
+		// Determine the plan from the subscription's price, then its metadata
+		var plan models.Plan
+		var ok bool
 		if subscription.Items != nil && len(subscription.Items.Data) > 0 {
-			priceID := subscription.Items.Data[0].Price.ID
-			// Check for price ID patterns or use metadata
-			if strings.Contains(priceID, "monthly") {
-				tier = "monthly"
-			} else if strings.Contains(priceID, "yearly") {
-				tier = "yearly"
-			} else {
-				// Try to get tier from metadata
-				if subscription.Metadata != nil {
-					if t, ok := subscription.Metadata["tier"]; ok {
-						tier = t
-					}
-				}
-
-				// If still not set, check the amount
-				if tier == "" {
-					amount := subscription.Items.Data[0].Price.UnitAmount
-					if amount == 500 {
-						tier = "monthly"
-					} else if amount == 3000 {
-						tier = "yearly"
-					}
-				}
-			}
+			plan, ok = planForPrice(subscription.Items.Data[0].Price)
 		}
-
-		// If we still couldn't determine the tier, default to monthly
-		if tier == "" {
-			tier = "monthly"
+		if !ok {
+			plan, ok = models.LookupPlan(subscription.Metadata["tier"])
+		}
+		if !ok {
+			return fmt.Errorf("no plan matches subscription %s", subscription.ID)
 		}
 
-		user.SubscriptionTier = tier
+		// A payment going through ends any grace period from a failed one
+		dunning.PaymentSucceeded(user)
+
+		user.SubscriptionTier = plan.Tier
 		user.SubscriptionStatus = "active"
 		user.StripeSubscriptionID = subscription.ID
 
 		// Set subscription end date based on the tier
 		if subscription.CurrentPeriodEnd > 0 {
-			setSubscriptionEndDate(user, subscription.CurrentPeriodEnd, tier, event.Type)
+			setSubscriptionEndDate(user, subscription.CurrentPeriodEnd, plan.Tier, event.Type)
 		}
 
 		// Update the user in the database
@@ -338,63 +414,62 @@ func (s *service) HandleWebhook(payload []byte, signature string) error {
 			return err
 		}
 
-	case "customer.subscription.updated":
-		// Handle subscription updates
-		var subscription stripe.Subscription
-		err := json.Unmarshal(event.Data.Raw, &subscription)
+	case "invoice.payment_failed":
+		// Handle failed subscription payments, which start the grace period
+		// or move it on as Stripe's retries fail
+		var invoice stripe.Invoice
+		err := json.Unmarshal(event.Data.Raw, &invoice)
 		if err != nil {
 			return err
 		}
 
-		// Update the user's subscription information
-		fmt.Printf("Subscription %s updated\n", subscription.ID)
+		// Only process subscription invoices
+		if invoice.Subscription == nil || invoice.Customer == nil {
+			return nil
+		}
 
 		// Find the user by Stripe customer ID
-		user, err := s.db.GetUserByStripeCustomerID(subscription.Customer.ID)
+		user, err := s.db.GetUserByStripeCustomerID(invoice.Customer.ID)
 		if err != nil {
 			return err
 		}
 
 		if user == nil {
-			return fmt.Errorf("user not found for Stripe customer ID: %s", subscription.Customer.ID)
+			return fmt.Errorf("user not found for Stripe customer ID: %s", invoice.Customer.ID)
 		}
 
-		// Check if this is a plan change/upgrade
-		isPlanChange := false
-		var newTier string
-
-		// Determine the subscription tier
-		if subscription.Items != nil && len(subscription.Items.Data) > 0 {
-			amount := subscription.Items.Data[0].Price.UnitAmount
-
-			// Map amount to tier
-			if amount == 500 {
-				newTier = "monthly"
-			} else if amount == 3000 {
-				newTier = "yearly"
+		// Update the user in the database if their grace period changed
+		if dunning.PaymentFailed(user, time.Now()) {
+			if err := s.db.UpdateUser(nil, user); err != nil {
+				return err
 			}
+		}
 
-			// If the tier is changing, it's a plan change
-			if newTier != "" && newTier != user.SubscriptionTier {
-				isPlanChange = true
-				fmt.Printf("Plan change detected: %s to %s\n", user.SubscriptionTier, newTier)
-			}
+	case "customer.subscription.updated":
+		// Handle subscription updates, including plan changes and
+		// cancellations made in the app or the billing portal
+		var subscription stripe.Subscription
+		err := json.Unmarshal(event.Data.Raw, &subscription)
+		if err != nil {
+			return err
 		}
 
 		// Update the user's subscription information
-		user.StripeSubscriptionID = subscription.ID
-		user.SubscriptionStatus = string(subscription.Status)
+		fmt.Printf("Subscription %s updated\n", subscription.ID)
 
-		// If this is a plan change, update the tier
-		if isPlanChange && newTier != "" {
-			user.SubscriptionTier = newTier
+		// Find the user by Stripe customer ID
+		user, err := s.db.GetUserByStripeCustomerID(subscription.Customer.ID)
+		if err != nil {
+			return err
 		}
 
-		// Special handling for upgrades - only process if it's a confirmed plan upgrade
-		if isPlanChange && subscription.CurrentPeriodEnd > 0 {
-			setSubscriptionEndDate(user, subscription.CurrentPeriodEnd, newTier, event.Type)
+		if user == nil {
+			return fmt.Errorf("user not found for Stripe customer ID: %s", subscription.Customer.ID)
 		}
 
+		// Copy the plan, status and current period onto the user
+		syncSubscription(user, &subscription)
+
 		// Update the user in the database
 		if err := s.db.UpdateUser(nil, user); err != nil {
 			return err
@@ -490,49 +565,24 @@ func (s *service) getOrCreateCustomer(user *database.User) (string, error) {
 	return customer.ID, nil
 }
 
-// createPrice creates a new price for a product
-func (s *service) createPrice(productID, tier string) (string, error) {
-	var amount int64
-	var interval string
-	var intervalCount int64
-
-	// Set the price based on the tier
-	switch tier {
-	case "monthly":
-		amount = 500 // $5.00
-		interval = string(stripe.PriceRecurringIntervalMonth)
-		intervalCount = 1
-	case "yearly":
-		amount = 3000 // $30.00
-		interval = string(stripe.PriceRecurringIntervalYear)
-		intervalCount = 1
-	case "lifetime":
-		amount = 10000 // $100.00
-		interval = ""
-		intervalCount = 0
-	case "premium_lifetime":
-		amount = 100000 // $1,000.00
-		interval = ""
-		intervalCount = 0
-	default:
-		return "", fmt.Errorf("invalid subscription tier: %s", tier)
-	}
-
+// createPrice creates a new price for a plan's product
+func (s *service) createPrice(productID string, plan models.Plan) (string, error) {
 	// Create price parameters
 	params := &stripe.PriceParams{
 		Product:    stripe.String(productID),
-		UnitAmount: stripe.Int64(amount),
-		Currency:   stripe.String("usd"),
+		UnitAmount: stripe.Int64(plan.Price),
+		Currency:   stripe.String(plan.Currency),
+		LookupKey:  stripe.String(planLookupKey(plan)),
 	}
 
 	// Add metadata about the tier
-	params.AddMetadata("tier", tier)
+	params.AddMetadata("tier", plan.Tier)
 
 	// Add recurring parameters for subscription tiers
-	if tier == "monthly" || tier == "yearly" {
+	if plan.Recurring() {
 		params.Recurring = &stripe.PriceRecurringParams{
-			Interval:      stripe.String(interval),
-			IntervalCount: stripe.Int64(intervalCount),
+			Interval:      stripe.String(plan.Interval),
+			IntervalCount: stripe.Int64(1),
 		}
 	}
 
@@ -545,24 +595,10 @@ func (s *service) createPrice(productID, tier string) (string, error) {
 	return p.ID, nil
 }
 
-// getProductIDForTier returns the Stripe product ID for a subscription tier
+// getProductIDForTier returns the Stripe product ID for a subscription tier,
+// which is set in STRIPE_PRICE_ and the tier in capitals, e.g. STRIPE_PRICE_MONTHLY
 func getProductIDForTier(tier string) (string, error) {
-	var envVar string
-
-	switch tier {
-	case "monthly":
-		envVar = "STRIPE_PRICE_MONTHLY"
-	case "yearly":
-		envVar = "STRIPE_PRICE_YEARLY"
-	case "lifetime":
-		envVar = "STRIPE_PRICE_LIFETIME"
-	case "premium_lifetime":
-		envVar = "STRIPE_PRICE_PREMIUM_LIFETIME"
-	default:
-		return "", fmt.Errorf("invalid subscription tier: %s", tier)
-	}
-
-	productID := os.Getenv(envVar)
+	productID := os.Getenv("STRIPE_PRICE_" + strings.ToUpper(tier))
 	if productID == "" {
 		return "", fmt.Errorf("product ID for tier %s is not set", tier)
 	}
@@ -570,6 +606,34 @@ func getProductIDForTier(tier string) (string, error) {
 	return productID, nil
 }
 
+// planForPrice finds the plan a Stripe price is for, by the plan's price ID,
+// then the tier in the price's metadata, then the amount and interval
+func planForPrice(p *stripe.Price) (models.Plan, bool) {
+	if p == nil {
+		return models.Plan{}, false
+	}
+
+	plans := models.CatalogPlans()
+	for _, plan := range plans {
+		if plan.StripePriceID != "" && plan.StripePriceID == p.ID {
+			return plan, true
+		}
+	}
+	if plan, ok := models.LookupPlan(p.Metadata["tier"]); ok {
+		return plan, true
+	}
+	for _, plan := range plans {
+		if plan.Price == 0 || plan.Price != p.UnitAmount {
+			continue
+		}
+		if p.Recurring != nil && string(p.Recurring.Interval) != plan.Interval {
+			continue
+		}
+		return plan, true
+	}
+	return models.Plan{}, false
+}
+
 // setSubscriptionEndDate sets the subscription end date for a user,
 // adding to the existing end date if one exists
 func setSubscriptionEndDate(user *database.User, endTimestamp int64, newTier string, eventType string) {
@@ -585,18 +649,9 @@ func setSubscriptionEndDate(user *database.User, endTimestamp int64, newTier str
 	// Simple rule: if there's an existing end date, add the new duration to it
 	if !user.SubscriptionEndDate.IsZero() {
 		// Calculate the proper duration based on tier instead of from now to endTimestamp
-		var duration time.Duration
-
-		switch newTier {
-		case "monthly":
-			duration = 30 * 24 * time.Hour // 30 days
-		case "yearly":
-			duration = 365 * 24 * time.Hour // 365 days
-		case "lifetime", "premium_lifetime":
-			duration = 20 * 365 * 24 * time.Hour // 20 years
-		default:
-			// Default to monthly if tier is unknown
-			duration = 30 * 24 * time.Hour
+		duration := 30 * 24 * time.Hour // Default to monthly if tier is unknown
+		if plan, ok := models.LookupPlan(newTier); ok && plan.Duration() > 0 {
+			duration = plan.Duration()
 		}
 
 		// Add that duration to the existing end date
@@ -101,6 +101,11 @@ func (m *MockStripeService) HandleWebhook(payload []byte, signature string) erro
 	return nil
 }
 
+// ReplayEvent is a mock implementation for testing
+func (m *MockStripeService) ReplayEvent(id uint) error {
+	return nil
+}
+
 // GetSubscriptionDetails is a mock implementation for testing
 func (m *MockStripeService) GetSubscriptionDetails(subscriptionID string) (*stripe.Subscription, error) {
 	if sub, ok := m.subscriptions[subscriptionID]; ok {
@@ -2,6 +2,7 @@ package controller_tests
 
 import (
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/testutils/mocks"
@@ -30,6 +31,21 @@ func (m *MockEmailServiceWithContact) SendPasswordResetEmail(email, token, baseU
 	return args.Error(0)
 }
 
+func (m *MockEmailServiceWithContact) SendAccountLockedEmail(email, token, baseURL string) error {
+	args := m.Called(email, token, baseURL)
+	return args.Error(0)
+}
+
+func (m *MockEmailServiceWithContact) SendDataExportEmail(email, downloadURL string, expiresAt time.Time) error {
+	args := m.Called(email, downloadURL, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockEmailServiceWithContact) SendPaymentReminderEmail(email, status string, graceEndsAt time.Time, billingURL string) error {
+	args := m.Called(email, status, graceEndsAt, billingURL)
+	return args.Error(0)
+}
+
 func (m *MockEmailServiceWithContact) SendContactEmail(name, email, subject, message string) error {
 	args := m.Called(name, email, subject, message)
 	return args.Error(0)
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/fieldcrypt/fieldcrypttest"
 	"github.com/hail2skins/armory/internal/models"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -23,8 +24,11 @@ type TestDB struct {
 	DB *gorm.DB
 }
 
-// NewTestDB creates a new test database
+// NewTestDB creates a new test database, with throwaway field encryption keys
+// unless the environment configures some
 func NewTestDB() *TestDB {
+	fieldcrypttest.Use()
+
 	// Create a temporary directory for the SQLite database
 	tempDir, err := os.MkdirTemp("", "armory-test-*")
 	if err != nil {
@@ -67,6 +71,26 @@ func NewTestDB() *TestDB {
 		&models.Grain{},
 		&models.Brand{},
 		&models.Ammo{},
+		&models.Range{},
+		&models.RangeDay{},
+		&models.RangeDayItem{},
+		&models.MaintenanceRecord{},
+		&models.ServiceInterval{},
+		&models.InventoryReport{},
+		&models.Attachment{},
+		&models.RateLimitCounter{},
+		&models.RateLimitEndpointStat{},
+		&models.RateLimitOffender{},
+		&models.RateLimitBlockEvent{},
+		&models.SecurityEvent{},
+		&models.RecoveryCode{},
+		&models.Passkey{},
+		&models.Session{},
+		&models.APIToken{},
+		&models.OIDCIdentity{},
+		&models.DataExport{},
+		&models.StripeEvent{},
+		&models.Plan{},
 	); err != nil {
 		log.Fatalf("Error auto migrating schema: %v", err)
 	}
@@ -265,15 +289,96 @@ func (s *TestService) AuthenticateUser(ctx context.Context, email, password stri
 		return nil, database.ErrInvalidCredentials
 	}
 
+	if user.IsLocked() {
+		return nil, database.ErrUserLocked
+	}
+
 	// Check the password
 	matches := database.CheckPassword(password, user.Password)
 	if !matches {
+		locked, err := database.RecordFailedLogin(s.db, user)
+		if err != nil {
+			return nil, err
+		}
+		if locked {
+			return user, database.ErrUserLocked
+		}
 		return nil, database.ErrInvalidCredentials
 	}
 
 	return user, nil
 }
 
+// UnlockUserByToken unlocks the account with the given emailed unlock token
+func (s *TestService) UnlockUserByToken(ctx context.Context, token string) (*database.User, error) {
+	var user database.User
+	if token == "" || s.db.Where("unlock_token = ?", token).First(&user).Error != nil {
+		return nil, database.ErrInvalidToken
+	}
+	if user.IsUnlockExpired() {
+		return nil, database.ErrTokenExpired
+	}
+	if err := database.UnlockAccount(s.db, &user, "Unlocked with the emailed unlock link", 0); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UnlockUser unlocks a user's account on behalf of an admin
+func (s *TestService) UnlockUser(ctx context.Context, user *database.User, adminID uint) error {
+	return database.UnlockAccount(s.db, user, "Unlocked by an administrator", adminID)
+}
+
+// FindSecurityEvents returns a user's most recent security events
+func (s *TestService) FindSecurityEvents(userID uint, limit int) ([]models.SecurityEvent, error) {
+	return models.FindSecurityEvents(s.db, userID, limit)
+}
+
+// EnableTwoFactor turns on two-factor authentication once the code checks out
+func (s *TestService) EnableTwoFactor(ctx context.Context, user *database.User, secret, code string) ([]string, error) {
+	return database.EnableTwoFactor(s.db, user, secret, code)
+}
+
+// VerifyTwoFactor finishes signing in a user whose password checked out
+func (s *TestService) VerifyTwoFactor(ctx context.Context, userID uint, code string) (*database.User, error) {
+	var user database.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	if user.IsLocked() {
+		return nil, database.ErrUserLocked
+	}
+	if err := database.CompleteTwoFactor(s.db, &user, code); err != nil {
+		if err == database.ErrUserLocked {
+			return &user, err
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DisableTwoFactor turns off two-factor authentication once the code checks out
+func (s *TestService) DisableTwoFactor(ctx context.Context, user *database.User, code string) error {
+	ok, err := database.CheckTwoFactorCode(s.db, user, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return database.ErrInvalidTwoFactorCode
+	}
+	return database.DisableTwoFactor(s.db, user)
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes with a new set
+func (s *TestService) RegenerateRecoveryCodes(ctx context.Context, user *database.User) ([]string, error) {
+	return database.RegenerateRecoveryCodes(s.db, user)
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (s *TestService) CountRecoveryCodes(userID uint) (int64, error) {
+	return models.CountUnusedRecoveryCodes(s.db, userID)
+}
+
 // GetUserByVerificationToken gets a user by verification token
 func (s *TestService) GetUserByVerificationToken(ctx context.Context, token string) (*database.User, error) {
 	var user database.User
@@ -1,6 +1,8 @@
 package mocks
 
 import (
+	"time"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -20,11 +22,26 @@ type MockEmailService struct {
 	LastResetToken         string
 	LastResetBaseURL       string
 
+	// Track account locked email calls
+	AccountLockedEmailSent bool
+	LastLockedEmail        string
+	LastUnlockToken        string
+
 	// Track email change verification calls
 	EmailChangeVerificationSent bool
 	LastChangeEmail             string
 	LastChangeToken             string
 	LastChangeBaseURL           string
+
+	// Track data export email calls
+	DataExportEmailSent bool
+	LastExportEmail     string
+	LastExportURL       string
+
+	// Track payment reminder email calls
+	PaymentReminderSent bool
+	LastReminderEmail   string
+	LastReminderStatus  string
 }
 
 // SendVerificationEmail implements email.EmailService
@@ -47,6 +64,15 @@ func (m *MockEmailService) SendPasswordResetEmail(email, token, baseURL string)
 	return args.Error(0)
 }
 
+// SendAccountLockedEmail implements email.EmailService
+func (m *MockEmailService) SendAccountLockedEmail(email, token, baseURL string) error {
+	args := m.Called(email, token, baseURL)
+	m.AccountLockedEmailSent = true
+	m.LastLockedEmail = email
+	m.LastUnlockToken = token
+	return args.Error(0)
+}
+
 // SendEmailChangeVerification implements email.EmailService
 func (m *MockEmailService) SendEmailChangeVerification(email, token, baseURL string) error {
 	args := m.Called(email, token, baseURL)
@@ -57,6 +83,24 @@ func (m *MockEmailService) SendEmailChangeVerification(email, token, baseURL str
 	return args.Error(0)
 }
 
+// SendDataExportEmail implements email.EmailService
+func (m *MockEmailService) SendDataExportEmail(email, downloadURL string, expiresAt time.Time) error {
+	args := m.Called(email, downloadURL, expiresAt)
+	m.DataExportEmailSent = true
+	m.LastExportEmail = email
+	m.LastExportURL = downloadURL
+	return args.Error(0)
+}
+
+// SendPaymentReminderEmail implements email.EmailService
+func (m *MockEmailService) SendPaymentReminderEmail(email, status string, graceEndsAt time.Time, billingURL string) error {
+	args := m.Called(email, status, graceEndsAt, billingURL)
+	m.PaymentReminderSent = true
+	m.LastReminderEmail = email
+	m.LastReminderStatus = status
+	return args.Error(0)
+}
+
 // SendContactEmail implements email.EmailService
 func (m *MockEmailService) SendContactEmail(name, email, subject, message string) error {
 	args := m.Called(name, email, subject, message)
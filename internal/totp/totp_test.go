@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 key from the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFCVectors(t *testing.T) {
+	// The RFC lists 8 digit codes; these are their last 6 digits
+	for unix, expected := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, expected, code, "at %d", unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	step, ok := Validate(rfcSecret, "005924", now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// The codes either side of now are accepted for clock drift, but no further
+	previous, _ := Code(rfcSecret, Step(now)-1)
+	step, ok = Validate(rfcSecret, previous, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now)-1, step)
+	stale, _ := Code(rfcSecret, Step(now)-2)
+	_, ok = Validate(rfcSecret, stale, now)
+	assert.False(t, ok)
+
+	// Spaces and a lowercase secret are fine
+	_, ok = Validate(strings.ToLower(FormatSecret(rfcSecret)), " 005 924 ", now)
+	assert.True(t, ok)
+
+	for _, code := range []string{"", "123", "0059240", "abcdef"} {
+		_, ok = Validate(rfcSecret, code, now)
+		assert.False(t, ok, "%q", code)
+	}
+	_, ok = Validate("not base32!", "005924", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	other, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	code, err := Code(secret, Step(time.Now()))
+	require.NoError(t, err)
+	_, ok := Validate(secret, code, time.Now())
+	assert.True(t, ok)
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Virtual Armory", "owner@example.com", rfcSecret)
+	assert.Equal(t, "otpauth://totp/Virtual%20Armory:owner@example.com?algorithm=SHA1&digits=6&issuer=Virtual+Armory&period=30&secret="+rfcSecret, uri)
+}
+
+func TestFormatSecret(t *testing.T) {
+	assert.Equal(t, "GEZD GNBV GY", FormatSecret("GEZDGNBVGY"))
+	assert.Equal(t, "GEZD", FormatSecret("GEZD"))
+}
@@ -0,0 +1,113 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	"github.com/ugorji/go/codec"
+)
+
+// COSE algorithms the site accepts, most preferred first
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the COSE algorithms offered when registering a credential
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1 // For EC2 and OKP keys
+	coseX         = -2
+	coseY         = -3
+	coseModulus   = -1 // For RSA keys
+	coseExponent  = -2
+)
+
+// ErrUnsupportedKey is returned for a public key the site can't verify signatures with
+var ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+
+// publicKey verifies signatures made by an authenticator
+type publicKey interface {
+	verify(message, signature []byte) bool
+}
+
+type ecdsaKey struct{ *ecdsa.PublicKey }
+
+func (k ecdsaKey) verify(message, signature []byte) bool {
+	digest := sha256.Sum256(message)
+	return ecdsa.VerifyASN1(k.PublicKey, digest[:], signature)
+}
+
+type rsaKey struct{ *rsa.PublicKey }
+
+func (k rsaKey) verify(message, signature []byte) bool {
+	digest := sha256.Sum256(message)
+	return rsa.VerifyPKCS1v15(k.PublicKey, crypto.SHA256, digest[:], signature) == nil
+}
+
+type ed25519Key ed25519.PublicKey
+
+func (k ed25519Key) verify(message, signature []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(k), message, signature)
+}
+
+// parsePublicKey decodes a COSE encoded public key
+func parsePublicKey(cose []byte) (publicKey, error) {
+	var key map[int]interface{}
+	if err := codec.NewDecoderBytes(cose, &cbor).Decode(&key); err != nil {
+		return nil, ErrUnsupportedKey
+	}
+
+	switch alg, _ := toInt(key[coseAlgorithm]); alg {
+	case AlgES256:
+		curve, _ := toInt(key[coseCurve])
+		x, _ := key[coseX].([]byte)
+		y, _ := key[coseY].([]byte)
+		if curve != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrUnsupportedKey
+		}
+		// Parsing the point checks it's on the curve
+		point, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil, ErrUnsupportedKey
+		}
+		return ecdsaKey{point}, nil
+	case AlgEdDSA:
+		curve, _ := toInt(key[coseCurve])
+		x, _ := key[coseX].([]byte)
+		if curve != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKey
+		}
+		return ed25519Key(x), nil
+	case AlgRS256:
+		n, _ := key[coseModulus].([]byte)
+		e, _ := key[coseExponent].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrUnsupportedKey
+		}
+		return rsaKey{&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// toInt reads a CBOR integer, which decodes as signed or unsigned
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,103 @@
+package webauthn
+
+// The options below are sent to the browser as JSON, with binary values
+// base64url encoded, for the page to pass to navigator.credentials
+
+// RelyingPartyEntity names the site in a registration ceremony
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity names the account a credential is registered for
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is a key algorithm the site accepts
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor identifies an existing credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// AuthenticatorSelection asks for a passkey the user is verified for
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the options for registering a credential
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions are the options for signing in with a credential
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int                    `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// CreationOptions returns the options for registering a discoverable credential
+// for a user, which can then sign them in without their email address.
+// Credentials they already have are excluded, so an authenticator isn't registered twice.
+func (rp RelyingParty) CreationOptions(challenge string, userHandle []byte, name string, existing [][]byte) CreationOptions {
+	params := make([]CredentialParameter, len(Algorithms))
+	for i, alg := range Algorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+
+	return CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:               UserEntity{ID: EncodeID(userHandle), Name: name, DisplayName: name},
+		PubKeyCredParams:   params,
+		Timeout:            Timeout,
+		Attestation:        "none",
+		ExcludeCredentials: descriptors(existing),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: "required",
+		},
+	}
+}
+
+// RequestOptions returns the options for signing in. With no credentials
+// listed, the browser offers any passkey the user has for the site.
+func (rp RelyingParty) RequestOptions(challenge string, allowed [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout,
+		AllowCredentials: descriptors(allowed),
+		UserVerification: "required",
+	}
+}
+
+// descriptors lists credential IDs for the browser
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		list[i] = CredentialDescriptor{Type: "public-key", ID: EncodeID(id)}
+	}
+	return list
+}
@@ -0,0 +1,222 @@
+// Package webauthn implements the relying party side of the Web Authentication
+// ceremonies used for passkeys: registering a credential and signing in with
+// it. Attestation isn't checked, since the site doesn't restrict which
+// authenticators can be used, so registration asks for none.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Timeout is how long, in milliseconds, the browser gives the user to complete a ceremony
+const Timeout = 300000
+
+// Authenticator data flags
+const (
+	flagUserPresent        = 0x01
+	flagUserVerified       = 0x04
+	flagAttestedCredential = 0x40
+)
+
+var (
+	ErrInvalidClientData   = errors.New("webauthn: invalid client data")
+	ErrChallengeMismatch   = errors.New("webauthn: challenge does not match")
+	ErrOriginMismatch      = errors.New("webauthn: origin does not match")
+	ErrInvalidAuthData     = errors.New("webauthn: invalid authenticator data")
+	ErrRPIDMismatch        = errors.New("webauthn: relying party ID does not match")
+	ErrUserNotVerified     = errors.New("webauthn: user was not verified")
+	ErrInvalidAttestation  = errors.New("webauthn: invalid attestation object")
+	ErrInvalidSignature    = errors.New("webauthn: invalid signature")
+	ErrSignCountRegression = errors.New("webauthn: signature counter went backwards, the credential may have been cloned")
+)
+
+// cbor decodes the CBOR used by attestation objects and COSE keys
+var cbor codec.CborHandle
+
+// encoding is the unpadded base64url encoding WebAuthn uses for binary values in JSON
+var encoding = base64.RawURLEncoding
+
+// RelyingParty identifies the site to authenticators. ID is the site's domain
+// and Origin the scheme, host and port its pages are served from.
+type RelyingParty struct {
+	ID     string
+	Name   string
+	Origin string
+}
+
+// Credential is a newly registered public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE encoded
+	SignCount uint32
+}
+
+// clientData is the JSON the browser signs over for a ceremony
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// authenticatorData is the authenticator's part of a ceremony
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// attestationObject is the CBOR object returned when a credential is created
+type attestationObject struct {
+	Fmt      string                 `codec:"fmt"`
+	AttStmt  map[string]interface{} `codec:"attStmt"`
+	AuthData []byte                 `codec:"authData"`
+}
+
+// NewChallenge returns a random challenge for a ceremony, base64url encoded
+func NewChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(challenge), nil
+}
+
+// EncodeID base64url encodes a credential or user ID
+func EncodeID(id []byte) string {
+	return encoding.EncodeToString(id)
+}
+
+// DecodeID decodes a base64url credential or user ID
+func DecodeID(id string) ([]byte, error) {
+	return encoding.DecodeString(id)
+}
+
+// VerifyRegistration checks the response to a registration ceremony and returns
+// the new credential. The user must have been verified, so the credential can
+// be used to sign in without a password.
+func (rp RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestation []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	var object attestationObject
+	if err := codec.NewDecoderBytes(attestation, &cbor).Decode(&object); err != nil {
+		return nil, ErrInvalidAttestation
+	}
+	authData, err := rp.parseAuthenticatorData(object.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, ErrInvalidAttestation
+	}
+	if _, err := parsePublicKey(authData.publicKey); err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response to a sign in ceremony against a stored
+// credential's public key and signature counter, returning the new counter
+func (rp RelyingParty) VerifyAssertion(challenge string, publicKey []byte, signCount uint32, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !key.verify(append(append([]byte{}, rawAuthData...), clientDataHash[:]...), signature) {
+		return 0, ErrInvalidSignature
+	}
+
+	// Authenticators that keep a counter must increase it each time; those
+	// that don't, such as synced passkeys, always report zero
+	if (authData.signCount != 0 || signCount != 0) && authData.signCount <= signCount {
+		return 0, ErrSignCountRegression
+	}
+	return authData.signCount, nil
+}
+
+// verifyClientData checks the client data is for this ceremony, challenge and origin
+func (rp RelyingParty) verifyClientData(raw []byte, ceremony, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil || data.Type != ceremony {
+		return ErrInvalidClientData
+	}
+	if challenge == "" || data.Challenge != challenge {
+		return ErrChallengeMismatch
+	}
+	if data.Origin != rp.Origin {
+		return ErrOriginMismatch
+	}
+	return nil
+}
+
+// parseAuthenticatorData reads authenticator data, checking it's for this
+// relying party and that the user was present and verified
+func (rp RelyingParty) parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, ErrInvalidAuthData
+	}
+	data := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return nil, ErrRPIDMismatch
+	}
+	if data.flags&flagUserPresent == 0 || data.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+
+	if data.flags&flagAttestedCredential != 0 {
+		// The AAGUID, then the credential ID's length and the credential ID, then
+		// its public key. Any extensions after the key are ignored.
+		rest := raw[37:]
+		if len(rest) < 18 {
+			return nil, ErrInvalidAuthData
+		}
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		if len(rest) < 18+idLength+1 {
+			return nil, ErrInvalidAuthData
+		}
+		data.credentialID = rest[18 : 18+idLength]
+
+		var key map[int]interface{}
+		if err := codec.NewDecoderBytes(rest[18+idLength:], &cbor).Decode(&key); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidAuthData, err)
+		}
+		var encoded []byte
+		if err := codec.NewEncoderBytes(&encoded, &cbor).Encode(key); err != nil {
+			return nil, err
+		}
+		data.publicKey = encoded
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"testing"
+
+	"github.com/hail2skins/armory/internal/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRP = RelyingParty{ID: "armory.example", Name: "Virtual Armory", Origin: "https://armory.example"}
+
+// register creates a passkey with the authenticator and verifies it
+func register(t *testing.T, authenticator *webauthntest.Authenticator) *Credential {
+	challenge, err := NewChallenge()
+	require.NoError(t, err)
+	response, err := authenticator.Register(testRP.ID, challenge, []byte("user-1"))
+	require.NoError(t, err)
+
+	credential, err := testRP.VerifyRegistration(challenge, decode(t, response.ClientDataJSON), decode(t, response.AttestationObject))
+	require.NoError(t, err)
+	assert.Equal(t, response.ID, EncodeID(credential.ID))
+	return credential
+}
+
+func decode(t *testing.T, value string) []byte {
+	decoded, err := DecodeID(value)
+	require.NoError(t, err)
+	return decoded
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	authenticator := webauthntest.New(testRP.Origin)
+	authenticator.SignCount = 1
+	credential := register(t, authenticator)
+	assert.Equal(t, uint32(1), credential.SignCount)
+
+	challenge, _ := NewChallenge()
+	assertion, err := authenticator.Assert(testRP.ID, challenge)
+	require.NoError(t, err)
+	signCount, err := testRP.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount,
+		decode(t, assertion.ClientDataJSON), decode(t, assertion.AuthenticatorData), decode(t, assertion.Signature))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), signCount)
+
+	// A counter that doesn't go up means the credential may have been copied
+	challenge, _ = NewChallenge()
+	assertion, _ = authenticator.Assert(testRP.ID, challenge)
+	_, err = testRP.VerifyAssertion(challenge, credential.PublicKey, 5,
+		decode(t, assertion.ClientDataJSON), decode(t, assertion.AuthenticatorData), decode(t, assertion.Signature))
+	assert.ErrorIs(t, err, ErrSignCountRegression)
+}
+
+func TestAssertionWithoutCounter(t *testing.T) {
+	authenticator := webauthntest.New(testRP.Origin)
+	credential := register(t, authenticator)
+
+	for i := 0; i < 2; i++ {
+		challenge, _ := NewChallenge()
+		assertion, _ := authenticator.Assert(testRP.ID, challenge)
+		signCount, err := testRP.VerifyAssertion(challenge, credential.PublicKey, credential.SignCount,
+			decode(t, assertion.ClientDataJSON), decode(t, assertion.AuthenticatorData), decode(t, assertion.Signature))
+		require.NoError(t, err)
+		assert.Zero(t, signCount)
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	authenticator := webauthntest.New(testRP.Origin)
+	credential := register(t, authenticator)
+	other := register(t, webauthntest.New(testRP.Origin))
+
+	challenge, _ := NewChallenge()
+	assertion, _ := authenticator.Assert(testRP.ID, challenge)
+	clientDataJSON := decode(t, assertion.ClientDataJSON)
+	authData := decode(t, assertion.AuthenticatorData)
+	signature := decode(t, assertion.Signature)
+
+	_, err := testRP.VerifyAssertion("other-challenge", credential.PublicKey, 0, clientDataJSON, authData, signature)
+	assert.ErrorIs(t, err, ErrChallengeMismatch)
+
+	_, err = testRP.VerifyAssertion(challenge, other.PublicKey, 0, clientDataJSON, authData, signature)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	elsewhere := RelyingParty{ID: testRP.ID, Origin: "https://phishing.example"}
+	_, err = elsewhere.VerifyAssertion(challenge, credential.PublicKey, 0, clientDataJSON, authData, signature)
+	assert.ErrorIs(t, err, ErrOriginMismatch)
+
+	elsewhere = RelyingParty{ID: "phishing.example", Origin: testRP.Origin}
+	_, err = elsewhere.VerifyAssertion(challenge, credential.PublicKey, 0, clientDataJSON, authData, signature)
+	assert.ErrorIs(t, err, ErrRPIDMismatch)
+
+	// A registration response can't be used to sign in
+	registration, _ := authenticator.Register(testRP.ID, challenge, nil)
+	_, err = testRP.VerifyAssertion(challenge, credential.PublicKey, 0, decode(t, registration.ClientDataJSON), authData, signature)
+	assert.ErrorIs(t, err, ErrInvalidClientData)
+
+	// The user must have been verified
+	unverified := append([]byte{}, authData...)
+	unverified[32] &^= flagUserVerified
+	_, err = testRP.VerifyAssertion(challenge, credential.PublicKey, 0, clientDataJSON, unverified, signature)
+	assert.ErrorIs(t, err, ErrUserNotVerified)
+}
+
+func TestCreationOptions(t *testing.T) {
+	options := testRP.CreationOptions("challenge", []byte{1, 2}, "owner@example.com", [][]byte{{3, 4}})
+	assert.Equal(t, "AQI", options.User.ID)
+	assert.Equal(t, "armory.example", options.RP.ID)
+	assert.Equal(t, []CredentialDescriptor{{Type: "public-key", ID: "AwQ"}}, options.ExcludeCredentials)
+	assert.Len(t, options.PubKeyCredParams, len(Algorithms))
+	assert.Equal(t, "required", options.AuthenticatorSelection.UserVerification)
+
+	request := testRP.RequestOptions("challenge", nil)
+	assert.Empty(t, request.AllowCredentials)
+	assert.NotNil(t, request.AllowCredentials, "An empty list lets the browser offer any passkey")
+}
@@ -0,0 +1,159 @@
+// Package webauthntest provides a software authenticator, so the WebAuthn
+// ceremonies can be tested without a browser or security key.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Authenticator is a platform authenticator holding ES256 passkeys. It always
+// reports the user as present and verified.
+type Authenticator struct {
+	Origin      string
+	credentials map[string]*credential
+	// SignCount is added to each credential's counter on every signature.
+	// Leave it at zero to behave like a synced passkey, which has no counter.
+	SignCount uint32
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	signCount  uint32
+}
+
+// Registration is the browser's response to a registration ceremony, as the
+// site's JavaScript posts it
+type Registration struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// Assertion is the browser's response to a sign in ceremony, as the site's
+// JavaScript posts it
+type Assertion struct {
+	ID                string `json:"id"`
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle"`
+}
+
+var (
+	cbor     codec.CborHandle
+	encoding = base64.RawURLEncoding
+)
+
+// New returns an authenticator for pages served from origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, credentials: map[string]*credential{}}
+}
+
+// Register creates a passkey for the relying party and user, answering the
+// given base64url challenge
+func (a *Authenticator) Register(rpID, challenge string, userHandle []byte) (*Registration, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	cred := &credential{id: id, rpID: rpID, userHandle: userHandle, key: key}
+	a.credentials[encoding.EncodeToString(id)] = cred
+
+	publicKey, err := encode(map[int]interface{}{
+		1:  2,  // EC2 key type
+		3:  -7, // ES256
+		-1: 1,  // P-256
+		-2: key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attested := make([]byte, 18, 18+len(id)+len(publicKey))
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(id)))
+	attested = append(append(attested, id...), publicKey...)
+	authData := a.authenticatorData(cred, 0x40, attested)
+
+	attestation, err := encode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registration{
+		ID:                encoding.EncodeToString(id),
+		ClientDataJSON:    encoding.EncodeToString(clientData("webauthn.create", challenge, a.Origin)),
+		AttestationObject: encoding.EncodeToString(attestation),
+	}, nil
+}
+
+// Assert signs in with the passkey it holds for the relying party, answering
+// the given base64url challenge. It returns nil if it has no passkey for the site.
+func (a *Authenticator) Assert(rpID, challenge string) (*Assertion, error) {
+	for id, cred := range a.credentials {
+		if cred.rpID != rpID {
+			continue
+		}
+		authData := a.authenticatorData(cred, 0, nil)
+		clientDataJSON := clientData("webauthn.get", challenge, a.Origin)
+		clientDataHash := sha256.Sum256(clientDataJSON)
+		digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+		signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Assertion{
+			ID:                id,
+			ClientDataJSON:    encoding.EncodeToString(clientDataJSON),
+			AuthenticatorData: encoding.EncodeToString(authData),
+			Signature:         encoding.EncodeToString(signature),
+			UserHandle:        encoding.EncodeToString(cred.userHandle),
+		}, nil
+	}
+	return nil, nil
+}
+
+// authenticatorData builds authenticator data with the user present and
+// verified, counting the signature
+func (a *Authenticator) authenticatorData(cred *credential, flags byte, attested []byte) []byte {
+	cred.signCount += a.SignCount
+	rpIDHash := sha256.Sum256([]byte(cred.rpID))
+	data := append(rpIDHash[:], flags|0x01|0x04, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], cred.signCount)
+	return append(data, attested...)
+}
+
+func clientData(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	return data
+}
+
+func encode(value interface{}) ([]byte, error) {
+	var out []byte
+	err := codec.NewEncoderBytes(&out, &cbor).Encode(value)
+	return out, err
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// setupLockoutTest builds a test database with a verified user and a router with the
+// login and unlock routes, whose login page shows just its error message
+func setupLockoutTest(t *testing.T) (*testutils.TestDB, *database.User, *mocks.MockEmailService, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	database.SetLockoutPolicy(&database.LockoutPolicy{Threshold: 3, BaseDuration: 15 * time.Minute, MaxDuration: time.Hour})
+	t.Cleanup(func() { database.SetLockoutPolicy(nil) })
+
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	user := &database.User{Email: "lockout@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	emailService := new(mocks.MockEmailService)
+	authController := controller.NewAuthController(service)
+	authController.SetEmailService(emailService)
+	authController.RenderLogin = func(c *gin.Context, d interface{}) {
+		c.Writer.WriteString(d.(data.AuthData).Error)
+	}
+
+	router := gin.New()
+	router.Use(sessions.Sessions("armory-session", cookie.NewStore([]byte("test-secret-key"))))
+	router.POST("/login", authController.LoginHandler)
+	router.GET("/unlock-account", authController.UnlockAccountHandler)
+
+	return db, user, emailService, router
+}
+
+// postLogin signs in with an email and password
+func postLogin(router *gin.Engine, email, password string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}, "password": {password}}
+	req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAccountLockout(t *testing.T) {
+	db, user, emailService, router := setupLockoutTest(t)
+	emailService.On("SendAccountLockedEmail", user.Email, mock.AnythingOfType("string"), mock.Anything).Return(nil).Once()
+
+	// Failed sign ins up to the threshold lock the account and email the user
+	for i := 0; i < 2; i++ {
+		assert.Contains(t, postLogin(router, user.Email, "wrong").Body.String(), "Invalid email or password")
+	}
+	w := postLogin(router, user.Email, "wrong")
+	assert.Contains(t, w.Body.String(), "locked")
+	emailService.AssertExpectations(t)
+
+	var stored database.User
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	assert.Equal(t, stored.UnlockToken, emailService.LastUnlockToken, "The email links to the unlock token")
+
+	// The right password doesn't sign in, and doesn't send another email
+	w = postLogin(router, user.Email, "Password123!")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "locked")
+	emailService.AssertNumberOfCalls(t, "SendAccountLockedEmail", 1)
+
+	// The emailed link unlocks the account
+	req, _ := http.NewRequest("GET", "/unlock-account?token="+url.QueryEscape(emailService.LastUnlockToken), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	w = postLogin(router, user.Email, "Password123!")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+
+	// The link only works once
+	req, _ = http.NewRequest("GET", "/unlock-account?token="+url.QueryEscape(emailService.LastUnlockToken), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid")
+
+	var events []models.SecurityEvent
+	require.NoError(t, db.DB.Where("user_id = ?", user.ID).Order("id").Find(&events).Error)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.SecurityEventAccountLocked, events[0].Type)
+	assert.Equal(t, models.SecurityEventAccountUnlocked, events[1].Type)
+}
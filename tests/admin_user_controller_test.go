@@ -6,10 +6,12 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hail2skins/armory/internal/controller"
 	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
 	"github.com/hail2skins/armory/internal/testutils/mocks"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
@@ -52,6 +54,7 @@ func (s *AdminUserSuite) SetupTest() {
 	s.Router.POST("/admin/users/:id", s.Controller.Update)
 	s.Router.POST("/admin/users/:id/delete", s.Controller.Delete)
 	s.Router.POST("/admin/users/:id/restore", s.Controller.Restore)
+	s.Router.POST("/admin/users/:id/unlock", s.Controller.Unlock)
 }
 
 // TestAdminUserSuite runs the test suite
@@ -98,6 +101,7 @@ func (s *AdminUserSuite) TestShow() {
 		SubscriptionTier: "monthly",
 		Verified:         true,
 	}, nil)
+	s.MockDB.On("FindSecurityEvents", uint(1), 20).Return(nil, nil)
 
 	// Send request
 	req, _ := http.NewRequest("GET", "/admin/users/1", nil)
@@ -107,7 +111,7 @@ func (s *AdminUserSuite) TestShow() {
 	s.Equal(http.StatusOK, s.Recorder.Code)
 	s.Contains(s.Recorder.Body.String(), "User Details")
 	s.Contains(s.Recorder.Body.String(), "user@example.com")
-	s.Contains(s.Recorder.Body.String(), "Monthly")
+	s.Contains(s.Recorder.Body.String(), models.PlanName("monthly"))
 }
 
 // TestEdit tests the Edit method
@@ -128,7 +132,7 @@ func (s *AdminUserSuite) TestEdit() {
 	s.Equal(http.StatusOK, s.Recorder.Code)
 	s.Contains(s.Recorder.Body.String(), "Edit User")
 	s.Contains(s.Recorder.Body.String(), "user@example.com")
-	s.Contains(s.Recorder.Body.String(), "Monthly")
+	s.Contains(s.Recorder.Body.String(), models.PlanName("monthly"))
 }
 
 // TestUpdate tests the Update method
@@ -194,3 +198,43 @@ func (s *AdminUserSuite) TestRestore() {
 	s.Equal(http.StatusSeeOther, s.Recorder.Code)
 	s.Equal("/admin/users?success=User+restored+successfully", s.Recorder.Header().Get("Location"))
 }
+
+// TestUnlock tests the Unlock method
+func (s *AdminUserSuite) TestUnlock() {
+	// A user locked out by failed sign ins a minute ago
+	user := &database.User{
+		Model:            gorm.Model{ID: 1},
+		Email:            "user@example.com",
+		LoginAttempts:    database.CurrentLockoutPolicy().Threshold,
+		LastLoginAttempt: time.Now().Add(-time.Minute),
+	}
+	s.Require().True(user.IsLocked())
+	s.MockDB.On("GetUserByID", uint(1)).Return(user, nil)
+	s.MockDB.On("UnlockUser", mock.Anything, user, uint(0)).Return(nil)
+
+	// Send request
+	req, _ := http.NewRequest("POST", "/admin/users/1/unlock", nil)
+	s.Router.ServeHTTP(s.Recorder, req)
+
+	// Assert response
+	s.Equal(http.StatusSeeOther, s.Recorder.Code)
+	s.Equal("/admin/users/1?success=User+account+unlocked", s.Recorder.Header().Get("Location"))
+	s.MockDB.AssertExpectations(s.T())
+}
+
+// TestUnlockNotLocked tests the Unlock method for a user who isn't locked
+func (s *AdminUserSuite) TestUnlockNotLocked() {
+	s.MockDB.On("GetUserByID", uint(1)).Return(&database.User{
+		Model: gorm.Model{ID: 1},
+		Email: "user@example.com",
+	}, nil)
+
+	// Send request
+	req, _ := http.NewRequest("POST", "/admin/users/1/unlock", nil)
+	s.Router.ServeHTTP(s.Recorder, req)
+
+	// Assert response
+	s.Equal(http.StatusSeeOther, s.Recorder.Code)
+	s.Equal("/admin/users/1?success=User+account+is+not+locked", s.Recorder.Header().Get("Location"))
+	s.MockDB.AssertNotCalled(s.T(), "UnlockUser", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,296 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/server"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiTestData is the reference data and owner the API tests work with
+type apiTestData struct {
+	db           *testutils.TestDB
+	user         *database.User
+	weaponType   models.WeaponType
+	caliber      models.Caliber
+	manufacturer models.Manufacturer
+	brand        models.Brand
+}
+
+// setupAPITest returns a router serving the versioned API, with an owner and
+// the reference data needed to add guns and ammunition
+func setupAPITest(t *testing.T) (*apiTestData, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	data := &apiTestData{
+		db:           db,
+		user:         &database.User{Email: "api@example.com", Password: "Password123!", Verified: true},
+		weaponType:   models.WeaponType{Type: "API Rifle"},
+		caliber:      models.Caliber{Caliber: "API 5.56"},
+		manufacturer: models.Manufacturer{Name: "API Arms", Country: "USA"},
+		brand:        models.Brand{Name: "API Ammo Co"},
+	}
+	require.NoError(t, db.DB.Create(data.user).Error)
+	require.NoError(t, db.DB.Create(&data.weaponType).Error)
+	require.NoError(t, db.DB.Create(&data.caliber).Error)
+	require.NoError(t, db.DB.Create(&data.manufacturer).Error)
+	require.NoError(t, db.DB.Create(&data.brand).Error)
+
+	router := gin.New()
+	middleware.SetupErrorHandlers(router)
+	server.RegisterAPIV1Routes(router.Group("/api/v1"), controller.NewAPIController(service))
+
+	return data, router
+}
+
+// apiToken creates a token for userID with the given scopes
+func apiToken(t *testing.T, db *testutils.TestDB, userID uint, scopes ...string) string {
+	token, _, err := models.CreateAPIToken(db.DB, userID, "Test script", scopes, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	return token
+}
+
+// callAPI sends an API request signed with token and returns the response
+func callAPI(router *gin.Engine, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// apiResponse decodes an API response body
+func apiResponse(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+	return body
+}
+
+// assertAPIError checks a response is the error envelope with the given status and code
+func assertAPIError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) map[string]interface{} {
+	t.Helper()
+	require.Equal(t, status, w.Code, w.Body.String())
+	envelope, ok := apiResponse(t, w)["error"].(map[string]interface{})
+	require.True(t, ok, w.Body.String())
+	assert.Equal(t, float64(status), envelope["status"])
+	assert.Equal(t, code, envelope["code"])
+	assert.NotEmpty(t, envelope["message"])
+	return envelope
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	data, router := setupAPITest(t)
+
+	w := callAPI(router, "GET", "/api/v1/guns", "", nil)
+	assertAPIError(t, w, http.StatusUnauthorized, "unauthorized")
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+
+	assertAPIError(t, callAPI(router, "GET", "/api/v1/guns", "armory_nope", nil), http.StatusUnauthorized, "unauthorized")
+
+	// An expired token stops working
+	token, record, err := models.CreateAPIToken(data.db.DB, data.user.ID, "Old script", []string{models.APIScopeGunsRead}, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, callAPI(router, "GET", "/api/v1/guns", token, nil).Code)
+	require.NoError(t, data.db.DB.Model(record).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	assertAPIError(t, callAPI(router, "GET", "/api/v1/guns", token, nil), http.StatusUnauthorized, "unauthorized")
+
+	// A token only reaches what its scopes allow
+	readOnly := apiToken(t, data.db, data.user.ID, models.APIScopeGunsRead)
+	assertAPIError(t, callAPI(router, "POST", "/api/v1/guns", readOnly, map[string]interface{}{"name": "Nope"}), http.StatusForbidden, "insufficient_scope")
+	assertAPIError(t, callAPI(router, "GET", "/api/v1/ammo", readOnly, nil), http.StatusForbidden, "insufficient_scope")
+
+	// Unknown API paths answer in the envelope too
+	assertAPIError(t, callAPI(router, "GET", "/api/v1/nothing-here", readOnly, nil), http.StatusNotFound, "not_found")
+
+	// Using a token records when it was last used
+	var used models.APIToken
+	require.NoError(t, data.db.DB.Where("token_hash = ?", models.HashAPIToken(readOnly)).First(&used).Error)
+	assert.NotNil(t, used.LastUsedAt)
+}
+
+func TestAPIGuns(t *testing.T) {
+	data, router := setupAPITest(t)
+	token := apiToken(t, data.db, data.user.ID, models.APIScopeGunsWrite)
+
+	// Missing fields are reported by name
+	envelope := assertAPIError(t, callAPI(router, "POST", "/api/v1/guns", token, map[string]interface{}{}), http.StatusUnprocessableEntity, "validation_failed")
+	fields, _ := envelope["fields"].(map[string]interface{})
+	assert.Contains(t, fields, "name")
+	assert.Contains(t, fields, "caliber_id")
+
+	gun := map[string]interface{}{
+		"name":            "Service Rifle",
+		"serial_number":   "SR-1",
+		"acquired":        "2024-01-31",
+		"weapon_type_id":  data.weaponType.ID,
+		"caliber_id":      data.caliber.ID,
+		"manufacturer_id": data.manufacturer.ID,
+	}
+	w := callAPI(router, "POST", "/api/v1/guns", token, gun)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	created := apiResponse(t, w)["data"].(map[string]interface{})
+	id := uint(created["id"].(float64))
+	assert.Equal(t, fmt.Sprintf("/api/v1/guns/%d", id), w.Header().Get("Location"))
+	assert.Equal(t, "Service Rifle", created["name"])
+	assert.Equal(t, "2024-01-31", created["acquired"])
+	assert.Equal(t, "API Arms", created["manufacturer"].(map[string]interface{})["name"])
+
+	w = callAPI(router, "GET", "/api/v1/guns", token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	list := apiResponse(t, w)
+	assert.Len(t, list["data"], 1)
+	assert.Equal(t, float64(1), list["meta"].(map[string]interface{})["total"])
+
+	gun["name"] = "Match Rifle"
+	w = callAPI(router, "PUT", fmt.Sprintf("/api/v1/guns/%d", id), token, gun)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	updated := apiResponse(t, w)["data"].(map[string]interface{})
+	assert.Equal(t, "Match Rifle", updated["name"])
+	assert.Equal(t, "API 5.56", updated["caliber"].(map[string]interface{})["name"])
+
+	// Another owner's gun doesn't exist as far as the API is concerned
+	other := &database.User{Email: "other-api@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, data.db.DB.Create(other).Error)
+	otherToken := apiToken(t, data.db, other.ID, models.APIScopeGunsWrite)
+	assertAPIError(t, callAPI(router, "GET", fmt.Sprintf("/api/v1/guns/%d", id), otherToken, nil), http.StatusNotFound, "not_found")
+	assertAPIError(t, callAPI(router, "DELETE", fmt.Sprintf("/api/v1/guns/%d", id), otherToken, nil), http.StatusNotFound, "not_found")
+
+	// The free tier's limit applies to the API as it does to the site
+	gun["name"] = "Second Rifle"
+	require.Equal(t, http.StatusCreated, callAPI(router, "POST", "/api/v1/guns", token, gun).Code)
+	gun["name"] = "Third Rifle"
+	assertAPIError(t, callAPI(router, "POST", "/api/v1/guns", token, gun), http.StatusForbidden, "subscription_required")
+
+	w = callAPI(router, "DELETE", fmt.Sprintf("/api/v1/guns/%d", id), token, nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assertAPIError(t, callAPI(router, "GET", fmt.Sprintf("/api/v1/guns/%d", id), token, nil), http.StatusNotFound, "not_found")
+}
+
+func TestAPIRangeDays(t *testing.T) {
+	data, router := setupAPITest(t)
+	token := apiToken(t, data.db, data.user.ID, models.APIScopeRangeDaysWrite, models.APIScopeAmmoRead, models.APIScopeReferenceRead)
+
+	gun := models.Gun{Name: "Range Pistol", WeaponTypeID: data.weaponType.ID, CaliberID: data.caliber.ID, ManufacturerID: data.manufacturer.ID, OwnerID: data.user.ID}
+	require.NoError(t, data.db.DB.Create(&gun).Error)
+	ammo := models.Ammo{Name: "Range Ammo", Count: 100, BrandID: data.brand.ID, CaliberID: data.caliber.ID, OwnerID: data.user.ID}
+	require.NoError(t, data.db.DB.Create(&ammo).Error)
+	rangeRecord := models.Range{RangeName: "API Range", City: "Austin", State: "TX"}
+	require.NoError(t, data.db.DB.Create(&rangeRecord).Error)
+
+	w := callAPI(router, "GET", "/api/v1/ranges", token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "API Range")
+	w = callAPI(router, "GET", "/api/v1/manufacturers", token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "API Arms")
+
+	envelope := assertAPIError(t, callAPI(router, "POST", "/api/v1/range-days", token, map[string]interface{}{
+		"date":     "2024-02-01",
+		"range_id": rangeRecord.ID,
+		"items":    []map[string]interface{}{{"gun_id": gun.ID}},
+	}), http.StatusUnprocessableEntity, "validation_failed")
+	assert.Contains(t, envelope["fields"], "items.0.ammo_id")
+
+	w = callAPI(router, "POST", "/api/v1/range-days", token, map[string]interface{}{
+		"date":     "2024-02-01",
+		"range_id": rangeRecord.ID,
+		"items":    []map[string]interface{}{{"gun_id": gun.ID, "ammo_id": ammo.ID, "shots_fired": 30}},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	rangeDay := apiResponse(t, w)["data"].(map[string]interface{})
+	id := uint(rangeDay["id"].(float64))
+	assert.Equal(t, "API Range", rangeDay["range"].(map[string]interface{})["name"])
+
+	// The shots come out of the ammunition
+	w = callAPI(router, "GET", fmt.Sprintf("/api/v1/ammo/%d", ammo.ID), token, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(70), apiResponse(t, w)["data"].(map[string]interface{})["remaining"])
+
+	// Replacing it moves the difference onto the ammunition
+	w = callAPI(router, "PUT", fmt.Sprintf("/api/v1/range-days/%d", id), token, map[string]interface{}{
+		"date":     "2024-02-02",
+		"range_id": rangeRecord.ID,
+		"comments": "Windy",
+		"items":    []map[string]interface{}{{"gun_id": gun.ID, "ammo_id": ammo.ID, "shots_fired": 50}},
+	})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	rangeDay = apiResponse(t, w)["data"].(map[string]interface{})
+	assert.Equal(t, "2024-02-02", rangeDay["date"])
+	assert.Equal(t, "Windy", rangeDay["comments"])
+	w = callAPI(router, "GET", fmt.Sprintf("/api/v1/ammo/%d", ammo.ID), token, nil)
+	assert.Equal(t, float64(50), apiResponse(t, w)["data"].(map[string]interface{})["remaining"])
+	assertAPIError(t, callAPI(router, "PUT", "/api/v1/range-days/9999", token, map[string]interface{}{}), http.StatusNotFound, "not_found")
+
+	require.Equal(t, http.StatusNoContent, callAPI(router, "DELETE", fmt.Sprintf("/api/v1/range-days/%d", id), token, nil).Code)
+	w = callAPI(router, "GET", fmt.Sprintf("/api/v1/ammo/%d", ammo.ID), token, nil)
+	assert.Equal(t, float64(100), apiResponse(t, w)["data"].(map[string]interface{})["remaining"])
+}
+
+func TestAPITokenManagement(t *testing.T) {
+	db, user, router := setupSessionTest(t)
+	ownerController := controller.NewOwnerController(testutils.NewTestService(db.DB))
+	router.GET("/owner/profile/api-tokens", ownerController.APITokens)
+	router.POST("/owner/profile/api-tokens", ownerController.APITokenCreate)
+	router.POST("/owner/profile/api-tokens/:id/delete", ownerController.APITokenDelete)
+	signedIn := postLogin(router, user.Email, "Password123!")
+
+	w := sendForm(router, "POST", "/owner/profile/api-tokens", url.Values{"name": {"Spreadsheet"}, "expires_in": {"30"}}, signedIn)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "Choose at least one thing the token can do")
+
+	w = sendForm(router, "POST", "/owner/profile/api-tokens", url.Values{
+		"name":       {"Spreadsheet"},
+		"scopes":     {models.APIScopeGunsRead, models.APIScopeAmmoRead},
+		"expires_in": {"30"},
+	}, signedIn)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	token := regexp.MustCompile(models.APITokenPrefix + `[A-Za-z0-9_-]+`).FindString(w.Body.String())
+	require.NotEmpty(t, token, "the new token should be shown once")
+
+	tokens, err := models.FindAPITokensByUser(db.DB, user.ID)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, "guns:read ammo:read", tokens[0].Scopes)
+	assert.NotContains(t, tokens[0].TokenHash, token)
+
+	// The token isn't shown again
+	w = sendForm(router, "GET", "/owner/profile/api-tokens", nil, signedIn)
+	assert.Contains(t, w.Body.String(), "Spreadsheet")
+	assert.NotContains(t, w.Body.String(), token)
+
+	w = sendForm(router, "POST", fmt.Sprintf("/owner/profile/api-tokens/%d/delete", tokens[0].ID), url.Values{}, signedIn)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	found, err := models.FindAPITokenByToken(db.DB, token)
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	var events int64
+	db.DB.Model(&models.SecurityEvent{}).Where("user_id = ? AND type IN ?", user.ID, []string{models.SecurityEventAPITokenCreated, models.SecurityEventAPITokenRevoked}).Count(&events)
+	assert.Equal(t, int64(2), events)
+}
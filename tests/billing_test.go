@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripego "github.com/stripe/stripe-go/v72"
+)
+
+// fakeBillingAPI is a fake Stripe API for a monthly subscriber, recording
+// what it's asked for by path
+type fakeBillingAPI struct {
+	mu       sync.Mutex
+	requests map[string]url.Values
+}
+
+// request returns what was last sent to a path
+func (f *fakeBillingAPI) request(path string) url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+// setupBillingTest signs in a monthly Stripe subscriber with the subscription
+// routes, the owner's subscription page and the webhook
+func setupBillingTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine, *fakeBillingAPI) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_fake")
+	t.Setenv("STRIPE_PRICE_YEARLY", "prod_yearly")
+	t.Setenv("APP_BASE_URL", "https://armory.test")
+	t.Setenv("APP_ENV", "test")
+
+	periodEnd := time.Now().AddDate(0, 0, 20).Unix()
+	fake := &fakeBillingAPI{requests: map[string]url.Values{}}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		values, _ := url.ParseQuery(string(body))
+		if r.Method == http.MethodGet {
+			values = r.URL.Query()
+		}
+		fake.mu.Lock()
+		fake.requests[r.URL.Path] = values
+		fake.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/v1/subscriptions/sub_1" && r.Method == http.MethodGet:
+			fmt.Fprintf(w, `{"id":"sub_1","object":"subscription","status":"active","current_period_end":%d,`+
+				`"items":{"object":"list","data":[{"id":"si_1","object":"subscription_item",`+
+				`"price":{"id":"price_monthly","object":"price","unit_amount":500,"recurring":{"interval":"month"}}}]}}`, periodEnd)
+		case r.URL.Path == "/v1/subscriptions/sub_1":
+			fmt.Fprintf(w, `{"id":"sub_1","object":"subscription","status":"active","current_period_end":%d,`+
+				`"items":{"object":"list","data":[{"id":"si_1","object":"subscription_item",`+
+				`"price":{"id":"price_yearly","object":"price","unit_amount":3000,"recurring":{"interval":"year"}}}]}}`,
+				time.Now().AddDate(1, 0, 0).Unix())
+		case r.URL.Path == "/v1/prices" && r.Method == http.MethodGet:
+			w.Write([]byte(`{"object":"list","data":[],"has_more":false,"url":"/v1/prices"}`))
+		case r.URL.Path == "/v1/prices":
+			w.Write([]byte(`{"id":"price_yearly","object":"price"}`))
+		case r.URL.Path == "/v1/invoices/upcoming":
+			w.Write([]byte(`{"object":"invoice","amount_due":2667,"currency":"usd"}`))
+		case r.URL.Path == "/v1/billing_portal/sessions":
+			w.Write([]byte(`{"id":"bps_1","object":"billing_portal.session","url":"https://billing.stripe.test/bps_1"}`))
+		case r.URL.Path == "/v1/checkout/sessions":
+			w.Write([]byte(`{"id":"cs_setup","object":"checkout.session","url":"https://checkout.stripe.test/cs_setup"}`))
+		case r.URL.Path == "/v1/setup_intents/seti_1":
+			w.Write([]byte(`{"id":"seti_1","object":"setup_intent","payment_method":"pm_new"}`))
+		case r.URL.Path == "/v1/customers/cus_1":
+			w.Write([]byte(`{"id":"cus_1","object":"customer"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"not found"}}`))
+		}
+	}))
+	t.Cleanup(api.Close)
+	stripego.SetBackend(stripego.APIBackend, stripego.GetBackendWithConfig(stripego.APIBackend, &stripego.BackendConfig{
+		URL:               stripego.String(api.URL),
+		MaxNetworkRetries: stripego.Int64(0),
+	}))
+	t.Cleanup(func() { stripego.SetBackend(stripego.APIBackend, nil) })
+
+	db := testutils.NewTestDB()
+	seedTestPlans(t, db)
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	user := helper.CreateTestUser(t)
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"subscription_tier":      "monthly",
+		"subscription_status":    "active",
+		"subscription_end_date":  time.Unix(periodEnd, 0),
+		"stripe_customer_id":     "cus_1",
+		"stripe_subscription_id": "sub_1",
+	}).Error)
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	paymentController := controller.NewPaymentController(service)
+	router := helper.GetAuthenticatedRouter(user.ID, user.Email)
+	router.GET("/owner/profile/subscription", controller.NewOwnerController(service).Subscription)
+	router.GET("/subscription/change", paymentController.ShowPlanChange)
+	router.POST("/subscription/change", paymentController.ChangePlan)
+	router.POST("/subscription/payment-method", paymentController.UpdatePaymentMethod)
+	router.POST("/subscription/portal", paymentController.BillingPortal)
+	router.POST("/webhook", paymentController.HandleWebhook)
+	return db, user, router, fake
+}
+
+// stripeRedirect returns the Stripe page a handler sent the owner to
+func stripeRedirect(t *testing.T, rr *httptest.ResponseRecorder) string {
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var body struct{ URL string }
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	return body.URL
+}
+
+func TestPlanChangeWithProration(t *testing.T) {
+	db, user, router, fake := setupBillingTest(t)
+
+	rr := sendForm(router, "GET", "/owner/profile/subscription", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `href="/subscription/change?tier=yearly"`)
+	assert.Contains(t, rr.Body.String(), "Switch to Loving It ($30/yr)")
+	assert.NotContains(t, rr.Body.String(), "tier=lifetime", "Lifetime plans are bought at checkout")
+
+	// The preview shows what's due for the rest of the period
+	rr = sendForm(router, "GET", "/subscription/change?tier=yearly", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Due today: $26.67")
+	preview := fake.request("/v1/invoices/upcoming")
+	assert.Equal(t, "sub_1", preview.Get("subscription"))
+	assert.Equal(t, "si_1", preview.Get("subscription_items[0][id]"))
+	assert.Equal(t, "price_yearly", preview.Get("subscription_items[0][price]"))
+	assert.Equal(t, "always_invoice", preview.Get("subscription_proration_behavior"))
+	prorationDate := preview.Get("subscription_proration_date")
+	assert.NotContains(t, rr.Body.String(), "proration_date", "The proration date stays on the server")
+	created := fake.request("/v1/prices")
+	assert.Equal(t, "prod_yearly", created.Get("product"))
+	assert.NotEmpty(t, created.Get("lookup_key"))
+	yearly, _ := models.LookupPlan("yearly")
+	assert.Equal(t, "price_yearly", yearly.StripePriceID, "The created price is kept for the next preview")
+
+	// Confirming it charges what was previewed and moves the owner, whatever
+	// proration date the form claims
+	rr = sendForm(router, "POST", "/subscription/change", url.Values{
+		"tier":           {"yearly"},
+		"proration_date": {"1"},
+	}, rr)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/profile/subscription", rr.Header().Get("Location"))
+	update := fake.request("/v1/subscriptions/sub_1")
+	assert.Equal(t, "si_1", update.Get("items[0][id]"))
+	assert.Equal(t, "price_yearly", update.Get("items[0][price]"))
+	assert.Equal(t, prorationDate, update.Get("proration_date"))
+	assert.Equal(t, "false", update.Get("cancel_at_period_end"))
+
+	var updated database.User
+	require.NoError(t, db.DB.First(&updated, user.ID).Error)
+	assert.Equal(t, "yearly", updated.SubscriptionTier)
+	assert.Equal(t, "active", updated.SubscriptionStatus)
+	assert.True(t, updated.SubscriptionEndDate.After(time.Now().AddDate(0, 11, 0)))
+
+	rr = sendForm(router, "GET", "/owner/profile/subscription", nil, rr)
+	assert.Contains(t, rr.Body.String(), "Your subscription is now on the Loving It plan.")
+
+	// Lifetime plans, and the plan they're on, aren't plan changes
+	for _, tier := range []string{"lifetime", "yearly"} {
+		rr = sendForm(router, "GET", "/subscription/change?tier="+tier, nil, nil)
+		assert.Equal(t, http.StatusSeeOther, rr.Code, tier)
+		assert.Equal(t, "/owner/profile/subscription", rr.Header().Get("Location"), tier)
+	}
+}
+
+func TestBillingPortalAndPaymentMethod(t *testing.T) {
+	t.Setenv("STRIPE_PORTAL_CONFIGURATION", "bpc_owners")
+	db, _, router, fake := setupBillingTest(t)
+
+	rr := sendForm(router, "POST", "/subscription/portal", nil, nil)
+	assert.Equal(t, "https://billing.stripe.test/bps_1", stripeRedirect(t, rr))
+	portal := fake.request("/v1/billing_portal/sessions")
+	assert.Equal(t, "cus_1", portal.Get("customer"))
+	assert.Equal(t, "https://armory.test/owner/profile/subscription", portal.Get("return_url"))
+	assert.Equal(t, "bpc_owners", portal.Get("configuration"))
+
+	rr = sendForm(router, "POST", "/subscription/payment-method", nil, nil)
+	assert.Equal(t, "https://checkout.stripe.test/cs_setup", stripeRedirect(t, rr))
+	checkout := fake.request("/v1/checkout/sessions")
+	assert.Equal(t, "setup", checkout.Get("mode"))
+	assert.Equal(t, "cus_1", checkout.Get("customer"))
+
+	// Once the card is entered, it's the one the subscription is charged to
+	payload := `{"id":"evt_setup","object":"event","type":"checkout.session.completed","data":{"object":` +
+		`{"id":"cs_setup","object":"checkout.session","mode":"setup","customer":"cus_1","setup_intent":"seti_1"}}}`
+	require.Equal(t, http.StatusOK, deliverWebhook(router, payload).Code)
+	assert.Equal(t, "pm_new", fake.request("/v1/customers/cus_1").Get("invoice_settings[default_payment_method]"))
+	assert.Equal(t, "pm_new", fake.request("/v1/subscriptions/sub_1").Get("default_payment_method"))
+
+	// Owners who've never paid have no billing to manage
+	require.NoError(t, db.DB.Model(&database.User{}).Where("stripe_customer_id = ?", "cus_1").
+		Update("stripe_customer_id", "").Error)
+	rr = sendForm(router, "POST", "/subscription/portal", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/profile/subscription", rr.Header().Get("Location"))
+}
+
+func TestSubscriptionUpdatedWebhookSyncsUser(t *testing.T) {
+	db, user, router, _ := setupBillingTest(t)
+
+	// A plan change and cancellation made in the billing portal
+	periodEnd := time.Now().AddDate(1, 0, 0).Truncate(time.Second)
+	payload := fmt.Sprintf(`{"id":"evt_updated","object":"event","type":"customer.subscription.updated","data":{"object":`+
+		`{"id":"sub_1","object":"subscription","customer":"cus_1","status":"active","cancel_at_period_end":true,`+
+		`"current_period_end":%d,"items":{"object":"list","data":[{"id":"si_1","object":"subscription_item",`+
+		`"price":{"id":"price_yearly","object":"price","unit_amount":3000,"recurring":{"interval":"year"}}}]}}}}`, periodEnd.Unix())
+	require.Equal(t, http.StatusOK, deliverWebhook(router, payload).Code)
+
+	var updated database.User
+	require.NoError(t, db.DB.First(&updated, user.ID).Error)
+	assert.Equal(t, "yearly", updated.SubscriptionTier)
+	assert.Equal(t, "pending_cancellation", updated.SubscriptionStatus)
+	assert.True(t, periodEnd.Equal(updated.SubscriptionEndDate), "end date %s", updated.SubscriptionEndDate)
+}
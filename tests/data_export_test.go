@@ -0,0 +1,312 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/storage"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// setupDataExportTest returns a router serving the data export pages, with an
+// owner who has a gun, ammunition, a range day, a payment and a promotion.
+// Exports are built straight away rather than in the background.
+func setupDataExportTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine, *mocks.MockEmailService) {
+	t.Setenv("DATA_EXPORT_SIGNING_KEY", "test-signing-key")
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	promotion := models.Promotion{Name: "Launch Week", Type: "free_trial", BenefitDays: 30, Active: true,
+		StartDate: time.Now().Add(-time.Hour), EndDate: time.Now().Add(time.Hour)}
+	require.NoError(t, db.DB.Create(&promotion).Error)
+	user := &database.User{Email: "export@example.com", Password: "Password123!", Verified: true, PromotionID: promotion.ID}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	weaponType := models.WeaponType{Type: "Export Rifle"}
+	caliber := models.Caliber{Caliber: "Export 5.56"}
+	manufacturer := models.Manufacturer{Name: "Export Arms", Country: "USA"}
+	brand := models.Brand{Name: "Export Ammo Co"}
+	require.NoError(t, db.DB.Create(&weaponType).Error)
+	require.NoError(t, db.DB.Create(&caliber).Error)
+	require.NoError(t, db.DB.Create(&manufacturer).Error)
+	require.NoError(t, db.DB.Create(&brand).Error)
+	gun := models.Gun{Name: "Export Carbine", WeaponTypeID: weaponType.ID, CaliberID: caliber.ID, ManufacturerID: manufacturer.ID, OwnerID: user.ID}
+	require.NoError(t, db.DB.Create(&gun).Error)
+	ammo := models.Ammo{Name: "Export Ball", Count: 100, BrandID: brand.ID, CaliberID: caliber.ID, OwnerID: user.ID}
+	require.NoError(t, db.DB.Create(&ammo).Error)
+	shootingRange := models.Range{RangeName: "Export Range", OwnerID: user.ID}
+	require.NoError(t, db.DB.Create(&shootingRange).Error)
+	rangeDay := models.RangeDay{UserID: user.ID, RangeID: shootingRange.ID, Date: time.Now(), Comments: "Windy",
+		Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 40}}}
+	require.NoError(t, db.DB.Create(&rangeDay).Error)
+	require.NoError(t, db.DB.Create(&models.Payment{UserID: user.ID, Amount: 500, Currency: "usd",
+		PaymentType: "subscription", Status: "succeeded", Description: "Monthly Subscription", StripeID: "pi_export"}).Error)
+
+	emailService := new(mocks.MockEmailService)
+	authController := controller.NewAuthController(service)
+	ownerController := controller.NewOwnerController(service)
+	ownerController.SetStorage(storage.NewLocalStorage(t.TempDir()))
+	ownerController.SetJobRunner(func(job func()) { job() })
+
+	router := gin.New()
+	router.Use(middleware.NewSessionStore(db.DB).Sessions("armory-session"))
+	router.Use(func(c *gin.Context) {
+		c.Set("authController", authController)
+		c.Set("emailService", emailService)
+	})
+	router.POST("/login", authController.LoginHandler)
+	router.GET("/owner/profile/export", ownerController.DataExport)
+	router.POST("/owner/profile/export", ownerController.DataExportCreate)
+	router.GET("/owner/profile/export/:id/download", ownerController.DataExportDownload)
+	router.POST("/owner/profile/delete", ownerController.DeleteAccountHandler)
+
+	return db, user, router, emailService
+}
+
+// expectDataExportEmail expects the export to be emailed to user and returns
+// the link it was sent, once it has been
+func expectDataExportEmail(emailService *mocks.MockEmailService, user *database.User) *string {
+	link := new(string)
+	emailService.On("SendDataExportEmail", user.Email, mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { *link = args.String(1) }).
+		Return(nil).Once()
+	return link
+}
+
+// readDataExport opens a downloaded archive and returns its files by name
+func readDataExport(t *testing.T, body []byte) map[string]string {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	files := map[string]string{}
+	for _, file := range archive.File {
+		r, err := file.Open()
+		require.NoError(t, err)
+		contents, err := io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+		files[file.Name] = string(contents)
+	}
+	return files
+}
+
+func TestDataExport(t *testing.T) {
+	db, user, router, emailService := setupDataExportTest(t)
+	link := expectDataExportEmail(emailService, user)
+
+	login := postLogin(router, user.Email, "Password123!")
+	w := sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	emailService.AssertExpectations(t)
+
+	export, err := models.FindLatestDataExport(db.DB, user.ID)
+	require.NoError(t, err)
+	require.NotNil(t, export)
+	assert.Equal(t, models.DataExportReady, export.Status)
+	require.NotNil(t, export.ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), *export.ExpiresAt, time.Minute)
+
+	// The emailed link downloads the archive
+	require.True(t, strings.HasPrefix(*link, "http://"))
+	parsed, err := url.Parse(*link)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("/owner/profile/export/%d/download", export.ID), parsed.Path)
+	w = sendForm(router, "GET", parsed.RequestURI(), nil, login)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+	files := readDataExport(t, w.Body.Bytes())
+	for _, name := range []string{"account", "guns", "ammunition", "range-days", "payments", "promotions"} {
+		assert.Contains(t, files, name+".json")
+		assert.Contains(t, files, name+".csv")
+	}
+	assert.Contains(t, files["account.json"], user.Email)
+	assert.Contains(t, files["guns.json"], "Export Carbine")
+	assert.Contains(t, files["guns.csv"], "Export Carbine")
+	assert.Contains(t, files["ammunition.csv"], "Export Ball")
+	assert.Contains(t, files["range-days.json"], `"shots_fired": 40`)
+	assert.Contains(t, files["range-days.csv"], "Export Range")
+	assert.Contains(t, files["payments.csv"], "5.00")
+	assert.Contains(t, files["promotions.json"], "Launch Week")
+
+	// Secrets stay out of it
+	var stored database.User
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	for name, contents := range files {
+		assert.NotContains(t, contents, stored.Password, name)
+		assert.NotContains(t, strings.ToLower(contents), "password", name)
+	}
+
+	// The page links to it while it lasts
+	page := sendForm(router, "GET", "/owner/profile/export", nil, login)
+	require.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), "Your export is ready")
+	assert.Contains(t, page.Body.String(), "Download Export")
+}
+
+func TestDataExportDownloadChecks(t *testing.T) {
+	db, user, router, emailService := setupDataExportTest(t)
+	link := expectDataExportEmail(emailService, user)
+
+	login := postLogin(router, user.Email, "Password123!")
+	sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	parsed, err := url.Parse(*link)
+	require.NoError(t, err)
+	query := parsed.Query()
+
+	// A tampered signature or expiry
+	tampered := url.Values{"expires": query["expires"], "signature": {strings.Repeat("0", 64)}}
+	w := sendForm(router, "GET", parsed.Path+"?"+tampered.Encode(), nil, login)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	tampered = url.Values{"expires": {fmt.Sprint(time.Now().Add(48 * time.Hour).Unix())}, "signature": query["signature"]}
+	w = sendForm(router, "GET", parsed.Path+"?"+tampered.Encode(), nil, login)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Without signing in
+	w = sendForm(router, "GET", parsed.RequestURI(), nil, nil)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+
+	// Someone else signed in with the link
+	other := &database.User{Email: "other-export@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(other).Error)
+	otherLogin := postLogin(router, other.Email, "Password123!")
+	w = sendForm(router, "GET", parsed.RequestURI(), nil, otherLogin)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Once the export has expired
+	export, _ := models.FindLatestDataExport(db.DB, user.ID)
+	require.NoError(t, db.DB.Model(export).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	w = sendForm(router, "GET", parsed.RequestURI(), nil, login)
+	assert.Equal(t, http.StatusGone, w.Code)
+	page := sendForm(router, "GET", "/owner/profile/export", nil, login)
+	assert.Contains(t, page.Body.String(), "has expired")
+	assert.NotContains(t, page.Body.String(), "Download Export")
+}
+
+func TestDataExportReplacesOlderExports(t *testing.T) {
+	db, user, router, emailService := setupDataExportTest(t)
+	first := expectDataExportEmail(emailService, user)
+	login := postLogin(router, user.Email, "Password123!")
+	sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	firstURL, _ := url.Parse(*first)
+
+	second := expectDataExportEmail(emailService, user)
+	sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	secondURL, _ := url.Parse(*second)
+	emailService.AssertExpectations(t)
+
+	exports, err := models.FindDataExportsByUser(db.DB, user.ID)
+	require.NoError(t, err)
+	require.Len(t, exports, 1)
+	assert.Equal(t, http.StatusNotFound, sendForm(router, "GET", firstURL.RequestURI(), nil, login).Code)
+	assert.Equal(t, http.StatusOK, sendForm(router, "GET", secondURL.RequestURI(), nil, login).Code)
+
+	// Only one export is built at a time
+	require.NoError(t, db.DB.Create(&models.DataExport{UserID: user.ID, Status: models.DataExportPending}).Error)
+	w := sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	page := sendForm(router, "GET", "/owner/profile/export", nil, w)
+	assert.Contains(t, page.Body.String(), "already being prepared")
+	exports, _ = models.FindDataExportsByUser(db.DB, user.ID)
+	assert.Len(t, exports, 2)
+	emailService.AssertExpectations(t)
+}
+
+func TestDataExportNeverFinished(t *testing.T) {
+	db, user, router, emailService := setupDataExportTest(t)
+	login := postLogin(router, user.Email, "Password123!")
+
+	// The server building it stopped part way
+	stuck := &models.DataExport{UserID: user.ID, Status: models.DataExportPending}
+	require.NoError(t, db.DB.Create(stuck).Error)
+	require.NoError(t, db.DB.Model(stuck).Update("created_at", time.Now().Add(-time.Hour)).Error)
+	page := sendForm(router, "GET", "/owner/profile/export", nil, login)
+	assert.Contains(t, page.Body.String(), "couldn&#39;t be prepared")
+
+	// Another can be asked for
+	expectDataExportEmail(emailService, user)
+	sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	emailService.AssertExpectations(t)
+	export, err := models.FindLatestDataExport(db.DB, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataExportReady, export.Status)
+}
+
+func TestSweepDataExports(t *testing.T) {
+	db := testutils.NewTestDB()
+	defer db.Close()
+	store := storage.NewLocalStorage(t.TempDir())
+	ownerController := controller.NewOwnerController(testutils.NewTestService(db.DB))
+	ownerController.SetStorage(store)
+	ctx := context.Background()
+	now := time.Now()
+
+	expiredAt := now.Add(-time.Minute)
+	expired := &models.DataExport{UserID: 1, Status: models.DataExportReady, StorageKey: "exports/1/old.zip", ExpiresAt: &expiredAt}
+	require.NoError(t, store.Put(ctx, expired.StorageKey, strings.NewReader("zip"), 3, "application/zip"))
+	require.NoError(t, db.DB.Create(expired).Error)
+	expiresAt := now.Add(time.Hour)
+	current := &models.DataExport{UserID: 2, Status: models.DataExportReady, StorageKey: "exports/2/new.zip", ExpiresAt: &expiresAt}
+	require.NoError(t, store.Put(ctx, current.StorageKey, strings.NewReader("zip"), 3, "application/zip"))
+	require.NoError(t, db.DB.Create(current).Error)
+	stuck := &models.DataExport{UserID: 3, Status: models.DataExportPending}
+	require.NoError(t, db.DB.Create(stuck).Error)
+	building := &models.DataExport{UserID: 4, Status: models.DataExportPending}
+	require.NoError(t, db.DB.Create(building).Error)
+	require.NoError(t, db.DB.Model(stuck).Update("created_at", now.Add(-time.Hour)).Error)
+
+	require.NoError(t, ownerController.SweepDataExports(ctx, now))
+
+	// The expired archive is deleted and the export kept to show it expired
+	_, err := store.Open(ctx, expired.StorageKey)
+	assert.Error(t, err)
+	require.NoError(t, db.DB.First(expired, expired.ID).Error)
+	assert.Empty(t, expired.StorageKey)
+	file, err := store.Open(ctx, current.StorageKey)
+	require.NoError(t, err)
+	file.Close()
+
+	// Only the export pending for too long failed
+	require.NoError(t, db.DB.First(stuck, stuck.ID).Error)
+	assert.Equal(t, models.DataExportFailed, stuck.Status)
+	require.NoError(t, db.DB.First(building, building.ID).Error)
+	assert.Equal(t, models.DataExportPending, building.Status)
+}
+
+func TestDeleteAccountDeletesDataExports(t *testing.T) {
+	db, user, router, emailService := setupDataExportTest(t)
+	link := expectDataExportEmail(emailService, user)
+	login := postLogin(router, user.Email, "Password123!")
+	sendForm(router, "POST", "/owner/profile/export", url.Values{}, login)
+	parsed, err := url.Parse(*link)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, sendForm(router, "GET", parsed.RequestURI(), nil, login).Code)
+
+	w := sendForm(router, "POST", "/owner/profile/delete", url.Values{"confirm": {"true"}}, login)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/", w.Header().Get("Location"))
+
+	var count int64
+	require.NoError(t, db.DB.Unscoped().Model(&models.DataExport{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/entitlements"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupEntitlementsTest signs in a user on the tier with the owner pages the
+// entitlements apply to
+func setupEntitlementsTest(t *testing.T, tier string) (*testutils.TestDB, *database.User, *gin.Engine) {
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	user := helper.CreateTestUser(t)
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", user.ID).Update("subscription_tier", tier).Error)
+	user.SubscriptionTier = tier
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	ownerController := controller.NewOwnerController(service)
+	router := helper.GetAuthenticatedRouter(user.ID, user.Email)
+	router.GET("/owner", ownerController.LandingPage)
+	router.GET("/owner/guns/new", middleware.RequireAllowance(helper.AuthService, service, entitlements.Guns), func(c *gin.Context) {
+		allowance, _ := c.Get("allowance")
+		c.String(http.StatusOK, allowance.(entitlements.Allowance).Summary())
+	})
+	router.POST("/owner/guns", ownerController.Create)
+	return db, user, router
+}
+
+func TestRequireAllowance(t *testing.T) {
+	db, user, router := setupEntitlementsTest(t, "free")
+	require.NoError(t, db.DB.Create(&models.Gun{Name: "First", OwnerID: user.ID}).Error)
+
+	rr := sendForm(router, "GET", "/owner/guns/new", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1 of 2 guns left on the Free plan", rr.Body.String())
+
+	require.NoError(t, db.DB.Create(&models.Gun{Name: "Second", OwnerID: user.ID}).Error)
+	rr = sendForm(router, "GET", "/owner/guns/new", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/pricing", rr.Header().Get("Location"))
+
+	// The dashboard explains the limit the same way
+	rr = sendForm(router, "GET", "/owner", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "0 of 2 guns left on the Free plan")
+	assert.Contains(t, rr.Body.String(), "4 of 4 ammunition items left on the Free plan")
+}
+
+func TestEndedPromotionGetsFreeLimits(t *testing.T) {
+	db, user, router := setupEntitlementsTest(t, entitlements.PromotionTier)
+	require.NoError(t, db.DB.Create(&models.Gun{Name: "First", OwnerID: user.ID}).Error)
+	require.NoError(t, db.DB.Create(&models.Gun{Name: "Second", OwnerID: user.ID}).Error)
+
+	// While the promotion runs there's no limit
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", user.ID).
+		Update("subscription_end_date", time.Now().AddDate(0, 0, 5)).Error)
+	rr := sendForm(router, "GET", "/owner/guns/new", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "Unlimited guns on the Promotion plan", rr.Body.String())
+
+	// Once it has ended, the free tier's limit applies even before the
+	// promotion's expiry is recorded
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", user.ID).
+		Update("subscription_end_date", time.Now().AddDate(0, 0, -1)).Error)
+	rr = sendForm(router, "GET", "/owner/guns/new", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/pricing", rr.Header().Get("Location"))
+
+	rr = sendForm(router, "POST", "/owner/guns", nil, nil)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/pricing", rr.Header().Get("Location"))
+	var count int64
+	db.DB.Model(&models.Gun{}).Where("owner_id = ?", user.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
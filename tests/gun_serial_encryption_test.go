@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/fieldcrypt"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// storedSerial reads a gun's serial number columns as they are in the database
+func storedSerial(t *testing.T, db *gorm.DB, gunID uint) (string, string) {
+	var row struct {
+		SerialNumber string
+		SerialIndex  string
+	}
+	require.NoError(t, db.Table("guns").Select("serial_number, serial_index").Where("id = ?", gunID).Scan(&row).Error)
+	return row.SerialNumber, row.SerialIndex
+}
+
+// TestGunSerialEncryption tests that serial numbers are encrypted at rest and can still be searched for
+func TestGunSerialEncryption(t *testing.T) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+	testUser := helper.CreateTestUser(t)
+	_, gun, _ := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	// Saving encrypts the serial number, and reading decrypts it
+	gun.SerialNumber = "SN-CRYPT-1"
+	require.NoError(t, db.DB.Save(&gun).Error)
+	stored, index := storedSerial(t, db.DB, gun.ID)
+	assert.True(t, fieldcrypt.IsEncrypted(stored))
+	assert.NotContains(t, stored, "SN-CRYPT-1")
+	assert.Len(t, index, 64)
+
+	found, err := models.FindGunByID(db.DB, gun.ID, testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "SN-CRYPT-1", found.SerialNumber)
+
+	// Updating re-encrypts it and moves the index
+	found.SerialNumber = "SN-CRYPT-2"
+	require.NoError(t, models.UpdateGun(db.DB, found))
+	assert.Equal(t, "SN-CRYPT-2", found.SerialNumber)
+	stored, updatedIndex := storedSerial(t, db.DB, gun.ID)
+	assert.True(t, fieldcrypt.IsEncrypted(stored))
+	assert.NotEqual(t, index, updatedIndex)
+
+	// Owners can find the gun by its exact serial number
+	ownerController := controller.NewOwnerController(service)
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/guns/arsenal", ownerController.Arsenal)
+
+	rr := getPage(router, "/owner/guns/arsenal?search=sn-crypt-2")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Range Pistol")
+
+	rr = getPage(router, "/owner/guns/arsenal?search=SN-CRYPT")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "Range Pistol", "Serial numbers only match exactly")
+}
+
+// TestEncryptGunSerials tests the migration that encrypts existing serial numbers and rotates keys
+func TestEncryptGunSerials(t *testing.T) {
+	key1, key2, indexKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32), bytes.Repeat([]byte{9}, 32)
+	keyring, err := fieldcrypt.NewKeyring("1", map[string][]byte{"1": key1}, indexKey)
+	require.NoError(t, err)
+	fieldcrypt.SetDefault(keyring)
+
+	db := testutils.NewTestDB()
+	t.Cleanup(func() {
+		db.Close()
+		fieldcrypt.SetDefault(nil)
+	})
+
+	// Serial numbers written before encryption are plaintext with no index
+	gun := models.Gun{Name: "Legacy", OwnerID: 1}
+	require.NoError(t, db.DB.Create(&gun).Error)
+	require.NoError(t, db.DB.Table("guns").Where("id = ?", gun.ID).
+		UpdateColumns(map[string]interface{}{"serial_number": "SN-LEGACY", "serial_index": ""}).Error)
+	var legacy models.Gun
+	require.NoError(t, db.DB.First(&legacy, gun.ID).Error)
+	assert.Equal(t, "SN-LEGACY", legacy.SerialNumber, "Plaintext still reads back before the migration")
+
+	updated, err := models.EncryptGunSerials(db.DB, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	stored, index := storedSerial(t, db.DB, gun.ID)
+	assert.True(t, fieldcrypt.IsEncrypted(stored))
+	assert.Equal(t, keyring.BlindIndex("SN-LEGACY"), index)
+
+	updated, err = models.EncryptGunSerials(db.DB, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 0, updated, "Running again changes nothing")
+
+	// Rotating to a new primary key keeps the old one to read existing values
+	rotated, err := fieldcrypt.NewKeyring("2", map[string][]byte{"1": key1, "2": key2}, indexKey)
+	require.NoError(t, err)
+	fieldcrypt.SetDefault(rotated)
+	assert.True(t, rotated.NeedsRotation(stored))
+
+	updated, err = models.EncryptGunSerials(db.DB, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	restored, reindexed := storedSerial(t, db.DB, gun.ID)
+	assert.False(t, rotated.NeedsRotation(restored))
+	assert.Equal(t, index, reindexed, "The index key didn't change, so neither does the index")
+
+	// With the old key gone the gun is still readable
+	current, err := fieldcrypt.NewKeyring("2", map[string][]byte{"2": key2}, indexKey)
+	require.NoError(t, err)
+	fieldcrypt.SetDefault(current)
+	require.NoError(t, db.DB.First(&legacy, gun.ID).Error)
+	assert.Equal(t, "SN-LEGACY", legacy.SerialNumber)
+}
@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hail2skins/armory/internal/fieldcrypt/fieldcrypttest"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/stretchr/testify/suite"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// MaintenanceModelTestSuite tests round counts, maintenance records and service intervals.
+type MaintenanceModelTestSuite struct {
+	suite.Suite
+	DB      *gorm.DB
+	OwnerID uint
+	Gun     models.Gun
+	Range   models.Range
+	Ammo    models.Ammo
+}
+
+func (s *MaintenanceModelTestSuite) SetupTest() {
+	fieldcrypttest.Use()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+
+	err = db.AutoMigrate(
+		&models.Range{},
+		&models.WeaponType{},
+		&models.Caliber{},
+		&models.Manufacturer{},
+		&models.Gun{},
+		&models.Brand{},
+		&models.BulletStyle{},
+		&models.Grain{},
+		&models.Casing{},
+		&models.Ammo{},
+		&models.RangeDay{},
+		&models.RangeDayItem{},
+		&models.MaintenanceRecord{},
+		&models.ServiceInterval{},
+	)
+	s.Require().NoError(err)
+	s.DB = db
+	s.OwnerID = 4004
+
+	s.Range = models.Range{RangeName: "Training Grounds", City: "Austin", State: "TX"}
+	s.Require().NoError(db.Create(&s.Range).Error)
+
+	wt := models.WeaponType{Type: "Pistol"}
+	cal := models.Caliber{Caliber: "9mm"}
+	man := models.Manufacturer{Name: "Glock", Country: "Austria"}
+	s.Require().NoError(db.Create(&wt).Error)
+	s.Require().NoError(db.Create(&cal).Error)
+	s.Require().NoError(db.Create(&man).Error)
+
+	s.Gun = models.Gun{Name: "G19", WeaponTypeID: wt.ID, CaliberID: cal.ID, ManufacturerID: man.ID, OwnerID: s.OwnerID}
+	s.Require().NoError(db.Create(&s.Gun).Error)
+
+	brand := models.Brand{Name: "Federal"}
+	s.Require().NoError(db.Create(&brand).Error)
+	s.Ammo = models.Ammo{Name: "9mm FMJ", BrandID: brand.ID, CaliberID: cal.ID, OwnerID: s.OwnerID, Count: 5000}
+	s.Require().NoError(db.Create(&s.Ammo).Error)
+}
+
+// shoot logs a range day for the suite's gun
+func (s *MaintenanceModelTestSuite) shoot(date time.Time, rounds int) {
+	rd := &models.RangeDay{UserID: s.OwnerID, RangeID: s.Range.ID, Date: date, Items: []models.RangeDayItem{{GunID: s.Gun.ID, AmmoID: s.Ammo.ID, ShotsFired: rounds}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
+}
+
+func (s *MaintenanceModelTestSuite) TestGunRoundCount_SumsRangeDays() {
+	s.shoot(time.Now().AddDate(0, 0, -10), 100)
+	s.shoot(time.Now().AddDate(0, 0, -2), 150)
+
+	count, err := models.GunRoundCount(s.DB, s.Gun.ID)
+	s.Require().NoError(err)
+	s.Equal(250, count)
+
+	count, err = models.GunRoundCountAsOf(s.DB, s.Gun.ID, time.Now().AddDate(0, 0, -5))
+	s.Require().NoError(err)
+	s.Equal(100, count)
+}
+
+func (s *MaintenanceModelTestSuite) TestCreateMaintenanceRecord_StampsRoundCountAsOfDate() {
+	cleanedOn := time.Now().AddDate(0, 0, -5)
+	s.shoot(time.Now().AddDate(0, 0, -10), 100)
+	s.shoot(cleanedOn, 50)
+	s.shoot(time.Now().AddDate(0, 0, -1), 200)
+
+	record := &models.MaintenanceRecord{
+		GunID:       s.Gun.ID,
+		OwnerID:     s.OwnerID,
+		Type:        models.MaintenanceTypeCleaning,
+		PerformedAt: time.Date(cleanedOn.Year(), cleanedOn.Month(), cleanedOn.Day(), 0, 0, 0, 0, cleanedOn.Location()),
+	}
+	s.Require().NoError(models.CreateMaintenanceRecordWithValidation(s.DB, record))
+	s.Equal(150, record.RoundCount, "Range days on the day of the cleaning count towards it")
+}
+
+func (s *MaintenanceModelTestSuite) TestServiceStatuses_DueByRounds() {
+	interval := &models.ServiceInterval{GunID: s.Gun.ID, OwnerID: s.OwnerID, Description: "Clean", MaintenanceType: models.MaintenanceTypeCleaning, EveryRounds: 300}
+	s.Require().NoError(models.CreateServiceIntervalWithValidation(s.DB, interval))
+
+	s.shoot(time.Now().AddDate(0, 0, -3), 200)
+	statuses, err := models.ServiceStatusesForGun(s.DB, &s.Gun)
+	s.Require().NoError(err)
+	s.Require().Len(statuses, 1)
+	s.False(statuses[0].Due)
+	s.Equal(100, statuses[0].RoundsRemaining())
+
+	s.shoot(time.Now().AddDate(0, 0, -1), 100)
+	due, err := models.FindDueServices(s.DB, s.OwnerID)
+	s.Require().NoError(err)
+	s.Require().Len(due, 1)
+	s.Equal("Clean", due[0].Interval.Description)
+	s.Equal(s.Gun.ID, due[0].Gun.ID)
+
+	// Cleaning resets the interval
+	record := &models.MaintenanceRecord{GunID: s.Gun.ID, OwnerID: s.OwnerID, Type: models.MaintenanceTypeCleaning, PerformedAt: time.Now()}
+	s.Require().NoError(models.CreateMaintenanceRecordWithValidation(s.DB, record))
+
+	due, err = models.FindDueServices(s.DB, s.OwnerID)
+	s.Require().NoError(err)
+	s.Empty(due)
+}
+
+func (s *MaintenanceModelTestSuite) TestServiceStatuses_DueByDays() {
+	interval := &models.ServiceInterval{GunID: s.Gun.ID, OwnerID: s.OwnerID, Description: "Clean", MaintenanceType: models.MaintenanceTypeCleaning, EveryDays: 30}
+	s.Require().NoError(models.CreateServiceIntervalWithValidation(s.DB, interval))
+
+	record := &models.MaintenanceRecord{GunID: s.Gun.ID, OwnerID: s.OwnerID, Type: models.MaintenanceTypeCleaning, PerformedAt: time.Now().AddDate(0, 0, -45)}
+	s.Require().NoError(models.CreateMaintenanceRecordWithValidation(s.DB, record))
+
+	statuses, err := models.ServiceStatusesForGun(s.DB, &s.Gun)
+	s.Require().NoError(err)
+	s.Require().Len(statuses, 1)
+	s.True(statuses[0].Due)
+	s.Equal(45, statuses[0].DaysSince)
+}
+
+func (s *MaintenanceModelTestSuite) TestServiceStatuses_PartReplacementMatchesPartName() {
+	interval := &models.ServiceInterval{GunID: s.Gun.ID, OwnerID: s.OwnerID, Description: "Recoil spring", MaintenanceType: models.MaintenanceTypePartReplacement, PartName: "Recoil Spring", EveryRounds: 100}
+	s.Require().NoError(models.CreateServiceIntervalWithValidation(s.DB, interval))
+	s.shoot(time.Now().AddDate(0, 0, -2), 150)
+
+	// Replacing a different part doesn't satisfy the interval
+	other := &models.MaintenanceRecord{GunID: s.Gun.ID, OwnerID: s.OwnerID, Type: models.MaintenanceTypePartReplacement, PartName: "Extractor", PerformedAt: time.Now()}
+	s.Require().NoError(models.CreateMaintenanceRecordWithValidation(s.DB, other))
+	statuses, err := models.ServiceStatusesForGun(s.DB, &s.Gun)
+	s.Require().NoError(err)
+	s.True(statuses[0].Due)
+
+	// Part names match case-insensitively
+	spring := &models.MaintenanceRecord{GunID: s.Gun.ID, OwnerID: s.OwnerID, Type: models.MaintenanceTypePartReplacement, PartName: "recoil spring", PerformedAt: time.Now()}
+	s.Require().NoError(models.CreateMaintenanceRecordWithValidation(s.DB, spring))
+	statuses, err = models.ServiceStatusesForGun(s.DB, &s.Gun)
+	s.Require().NoError(err)
+	s.False(statuses[0].Due)
+}
+
+func (s *MaintenanceModelTestSuite) TestValidate_WithInvalidData_ReturnsErrors() {
+	record := models.MaintenanceRecord{GunID: s.Gun.ID, OwnerID: s.OwnerID, Type: "polishing", PerformedAt: time.Now()}
+	s.Equal(models.ErrInvalidMaintenanceType, record.Validate(s.DB))
+
+	record.Type = models.MaintenanceTypeCleaning
+	record.PerformedAt = time.Now().Add(48 * time.Hour)
+	s.Equal(models.ErrMaintenanceFutureDate, record.Validate(s.DB))
+
+	record.Type = models.MaintenanceTypePartReplacement
+	record.PerformedAt = time.Now()
+	s.Equal(models.ErrMaintenancePartRequired, record.Validate(s.DB))
+
+	record.Type = models.MaintenanceTypeCleaning
+	record.OwnerID = 9999
+	s.Equal(models.ErrInvalidMaintenanceGun, record.Validate(s.DB))
+
+	interval := models.ServiceInterval{GunID: s.Gun.ID, OwnerID: s.OwnerID, Description: "Clean", MaintenanceType: models.MaintenanceTypeCleaning}
+	s.Equal(models.ErrServiceIntervalEmpty, interval.Validate(s.DB))
+
+	interval.EveryRounds = -1
+	s.Equal(models.ErrServiceIntervalNegative, interval.Validate(s.DB))
+}
+
+func (s *MaintenanceModelTestSuite) TestDeleteMaintenanceRecord_WithNonOwner_ReturnsAuthorizationError() {
+	record := &models.MaintenanceRecord{GunID: s.Gun.ID, OwnerID: s.OwnerID, Type: models.MaintenanceTypeCleaning, PerformedAt: time.Now()}
+	s.Require().NoError(models.CreateMaintenanceRecord(s.DB, record))
+
+	err := models.DeleteMaintenanceRecord(s.DB, record.ID, 9999)
+	s.Error(err)
+	s.Contains(err.Error(), "not authorized")
+
+	s.Require().NoError(models.DeleteMaintenanceRecord(s.DB, record.ID, s.OwnerID))
+}
+
+func TestMaintenanceModelSuite(t *testing.T) {
+	suite.Run(t, new(MaintenanceModelTestSuite))
+}
@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/oidc/oidctest"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupOIDCTest starts a mock provider and a router that signs in with it
+func setupOIDCTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine, *oidctest.Provider) {
+	provider := oidctest.New("armory", "client-secret")
+	t.Cleanup(provider.Close)
+	t.Setenv("OIDC_ISSUER", provider.Issuer())
+	t.Setenv("OIDC_CLIENT_ID", "armory")
+	t.Setenv("OIDC_CLIENT_SECRET", "client-secret")
+	t.Setenv("OIDC_PROVIDER_NAME", "Test ID")
+	t.Setenv("OIDC_REDIRECT_URL", "http://armory.test"+controller.OIDCCallbackPath)
+
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	user := &database.User{Email: "oidc@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(user).Error)
+	provider.User = oidctest.User{Subject: "subject-1", Email: user.Email, EmailVerified: true, Name: "OIDC User"}
+
+	authController := controller.NewAuthController(service)
+	authController.RenderLogin = func(c *gin.Context, d interface{}) {
+		c.String(http.StatusOK, "login:"+d.(data.AuthData).Error)
+	}
+	ownerController := controller.NewOwnerController(service)
+
+	router := gin.New()
+	router.Use(middleware.NewSessionStore(db.DB).Sessions("armory-session"))
+	router.Use(func(c *gin.Context) {
+		c.Set("authController", authController)
+	})
+	router.POST("/login", authController.LoginHandler)
+	router.GET("/auth/oidc/login", authController.OIDCLoginHandler)
+	router.GET(controller.OIDCCallbackPath, authController.OIDCCallbackHandler)
+	router.GET("/owner/profile", ownerController.Profile)
+	router.POST("/owner/profile/oidc/link", authController.OIDCLinkHandler)
+	router.POST("/owner/profile/oidc/:id/unlink", ownerController.OIDCUnlink)
+
+	return db, user, router, provider
+}
+
+// oidcCallback follows a redirect to the provider the way a browser would and
+// returns the path and query it sends the user back to
+func oidcCallback(t *testing.T, provider *oidctest.Provider, w *httptest.ResponseRecorder) string {
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	back, err := provider.Authorize(w.Header().Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, controller.OIDCCallbackPath, back.Path)
+	return back.RequestURI()
+}
+
+// signInWithOIDC signs in with the provider from the start, carrying the
+// session from previous, and returns the response to coming back
+func signInWithOIDC(t *testing.T, router *gin.Engine, provider *oidctest.Provider, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	start := sendForm(router, "GET", "/auth/oidc/login", nil, previous)
+	return sendForm(router, "GET", oidcCallback(t, provider, start), nil, start)
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	db, user, router, provider := setupOIDCTest(t)
+
+	// The first sign in links the account with the same verified email
+	w := signInWithOIDC(t, router, provider, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code, w.Body.String())
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+	identity, err := models.FindOIDCIdentity(db.DB, provider.Issuer(), "subject-1")
+	require.NoError(t, err)
+	require.NotNil(t, identity)
+	assert.Equal(t, user.ID, identity.UserID)
+	assert.NotNil(t, identity.LastUsedAt)
+
+	profile := sendForm(router, "GET", "/owner/profile", nil, w)
+	require.Equal(t, http.StatusOK, profile.Code)
+	assert.Contains(t, profile.Body.String(), "Linked Accounts")
+	assert.Contains(t, profile.Body.String(), fmt.Sprintf("/owner/profile/oidc/%d/unlink", identity.ID))
+
+	// After that the subject signs in, even once the email there changes
+	provider.User.Email = "renamed@example.com"
+	w = signInWithOIDC(t, router, provider, nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code, w.Body.String())
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+
+	// Another subject with an email no one here has isn't signed in
+	provider.User = oidctest.User{Subject: "subject-2", Email: "stranger@example.com", EmailVerified: true}
+	w = signInWithOIDC(t, router, provider, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "There's no account here for stranger@example.com")
+
+	var events int64
+	db.DB.Model(&models.SecurityEvent{}).Where("user_id = ? AND type = ?", user.ID, models.SecurityEventOIDCLinked).Count(&events)
+	assert.Equal(t, int64(1), events)
+}
+
+func TestOIDCSignInRefusesUnverifiedEmails(t *testing.T) {
+	db, user, router, provider := setupOIDCTest(t)
+
+	// The provider hasn't verified the email
+	provider.User.EmailVerified = false
+	w := signInWithOIDC(t, router, provider, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "hasn't been verified")
+
+	// Nor has this site, so whoever registered it may not own it
+	provider.User.EmailVerified = true
+	require.NoError(t, db.DB.Model(user).Update("verified", false).Error)
+	w = signInWithOIDC(t, router, provider, nil)
+	assert.Contains(t, w.Body.String(), "Please verify your email")
+
+	var count int64
+	db.DB.Model(&models.OIDCIdentity{}).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestOIDCCallbackChecks(t *testing.T) {
+	_, _, router, provider := setupOIDCTest(t)
+
+	// A state that doesn't match the one in the session
+	start := sendForm(router, "GET", "/auth/oidc/login", nil, nil)
+	callback := oidcCallback(t, provider, start)
+	parsed, _ := url.Parse(callback)
+	query := parsed.Query()
+	query.Set("state", "forged")
+	w := sendForm(router, "GET", controller.OIDCCallbackPath+"?"+query.Encode(), nil, start)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "didn't work")
+
+	// Coming back without the session that started the sign in
+	start = sendForm(router, "GET", "/auth/oidc/login", nil, nil)
+	w = sendForm(router, "GET", oidcCallback(t, provider, start), nil, nil)
+	assert.Contains(t, w.Body.String(), "didn't work")
+
+	// Coming back twice
+	start = sendForm(router, "GET", "/auth/oidc/login", nil, nil)
+	callback = oidcCallback(t, provider, start)
+	first := sendForm(router, "GET", callback, nil, start)
+	require.Equal(t, http.StatusSeeOther, first.Code)
+	w = sendForm(router, "GET", callback, nil, start)
+	assert.Contains(t, w.Body.String(), "didn't work")
+
+	// An ID token issued for another sign in
+	provider.Claims = func(claims map[string]interface{}) { claims["nonce"] = "replayed" }
+	w = signInWithOIDC(t, router, provider, nil)
+	assert.Contains(t, w.Body.String(), "didn't work")
+	provider.Claims = nil
+
+	// The user cancelled at the provider
+	start = sendForm(router, "GET", "/auth/oidc/login", nil, nil)
+	w = sendForm(router, "GET", controller.OIDCCallbackPath+"?error=access_denied", nil, start)
+	assert.Contains(t, w.Body.String(), "was cancelled")
+}
+
+func TestOIDCSignInWithTwoFactor(t *testing.T) {
+	db, user, router, provider := setupOIDCTest(t)
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	_, err = database.EnableTwoFactor(db.DB, user, secret, code)
+	require.NoError(t, err)
+
+	// The provider stands in for the password, so the code is still needed
+	w := signInWithOIDC(t, router, provider, nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login/two-factor", w.Header().Get("Location"))
+	assert.NotEqual(t, http.StatusOK, sendForm(router, "GET", "/owner/profile", nil, w).Code)
+}
+
+func TestOIDCLinkAndUnlink(t *testing.T) {
+	db, user, router, provider := setupOIDCTest(t)
+	provider.User = oidctest.User{Subject: "work-account", Email: "work@example.com"}
+
+	login := postLogin(router, user.Email, "Password123!")
+	profile := sendForm(router, "GET", "/owner/profile", nil, login)
+	require.Equal(t, http.StatusOK, profile.Code)
+	assert.Contains(t, profile.Body.String(), "Link Test ID Account")
+
+	// A signed in user can link an account whatever its email
+	start := sendForm(router, "POST", "/owner/profile/oidc/link", url.Values{}, login)
+	w := sendForm(router, "GET", oidcCallback(t, provider, start), nil, start)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner/profile", w.Header().Get("Location"))
+	profile = sendForm(router, "GET", "/owner/profile", nil, w)
+	assert.Contains(t, profile.Body.String(), "Your Test ID account is linked")
+	assert.Contains(t, profile.Body.String(), "work@example.com")
+
+	identities, err := models.FindOIDCIdentitiesByUser(db.DB, user.ID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+
+	// The account can't be linked to someone else as well
+	other := &database.User{Email: "other@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(other).Error)
+	otherLogin := postLogin(router, other.Email, "Password123!")
+	start = sendForm(router, "POST", "/owner/profile/oidc/link", url.Values{}, otherLogin)
+	w = sendForm(router, "GET", oidcCallback(t, provider, start), nil, start)
+	profile = sendForm(router, "GET", "/owner/profile", nil, w)
+	assert.Contains(t, profile.Body.String(), "already linked to another user")
+
+	// Nor unlinked by them
+	w = sendForm(router, "POST", fmt.Sprintf("/owner/profile/oidc/%d/unlink", identities[0].ID), url.Values{}, otherLogin)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	identities, _ = models.FindOIDCIdentitiesByUser(db.DB, user.ID)
+	assert.Len(t, identities, 1)
+
+	// Once unlinked it no longer signs in
+	w = sendForm(router, "POST", fmt.Sprintf("/owner/profile/oidc/%d/unlink", identities[0].ID), url.Values{}, login)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	identities, _ = models.FindOIDCIdentitiesByUser(db.DB, user.ID)
+	assert.Empty(t, identities)
+	w = signInWithOIDC(t, router, provider, nil)
+	assert.Contains(t, w.Body.String(), "hasn't been verified")
+
+	var events []models.SecurityEvent
+	db.DB.Where("user_id = ?", user.ID).Order("id").Find(&events)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.SecurityEventOIDCLinked, events[0].Type)
+	assert.Equal(t, models.SecurityEventOIDCUnlinked, events[1].Type)
+}
@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/attachments"
+	"github.com/hail2skins/armory/internal/services/storage"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupAttachmentTest builds a test database, an authenticated user on the given tier,
+// local photo storage in a temporary directory, and a router with the attachment routes
+func setupAttachmentTest(t *testing.T, tier string) (*testutils.TestDB, *database.User, storage.Storage, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", testUser.ID).Update("subscription_tier", tier).Error)
+
+	store := storage.NewLocalStorage(t.TempDir())
+	ownerController := controller.NewOwnerController(service)
+	ownerController.SetStorage(store)
+
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/guns/:id", ownerController.Show)
+	router.POST("/owner/guns/:id/delete", ownerController.Delete)
+	router.POST("/owner/guns/:id/attachments", ownerController.GunAttachmentCreate)
+	router.GET("/owner/munitions/:id", ownerController.AmmoShow)
+	router.POST("/owner/munitions/:id/attachments", ownerController.AmmoAttachmentCreate)
+	router.GET("/owner/attachments/:id", ownerController.AttachmentShow)
+	router.GET("/owner/attachments/:id/thumbnail", ownerController.AttachmentThumbnail)
+	router.POST("/owner/attachments/:id/delete", ownerController.AttachmentDelete)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, testUser, store, router
+}
+
+// photoWithEXIF encodes a small JPEG with an EXIF block naming the camera and where it was
+func photoWithEXIF(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil))
+
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00SecretCam GPS 32.7767N 96.7970W")
+	app1 := binary.BigEndian.AppendUint16([]byte{0xff, 0xe1}, uint16(len(exif)+2))
+	photo := append([]byte{0xff, 0xd8}, append(app1, exif...)...)
+	return append(photo, buf.Bytes()[2:]...)
+}
+
+// uploadPhoto posts a photo the way the upload form on a gun or ammunition page does
+func uploadPhoto(t *testing.T, router *gin.Engine, path string, filename string, contents []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("csrf_token", "test_token"))
+	require.NoError(t, writer.WriteField("caption", "Serial number"))
+	part, err := writer.CreateFormFile("photo", filename)
+	require.NoError(t, err)
+	_, err = part.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", path, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-CSRF-TEST-MODE", "1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestGunAttachmentUpload tests that a photo is stored without its EXIF data, with a thumbnail
+func TestGunAttachmentUpload(t *testing.T) {
+	db, testUser, _, router := setupAttachmentTest(t, "free")
+	_, gun, _ := seedRangeDayOptions(t, db.DB, testUser.ID)
+	gunPath := fmt.Sprintf("/owner/guns/%d", gun.ID)
+
+	rr := uploadPhoto(t, router, gunPath+"/attachments", "IMG_0001.JPG", photoWithEXIF(t))
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, gunPath, rr.Header().Get("Location"))
+
+	var attachment models.Attachment
+	require.NoError(t, db.DB.Where("gun_id = ?", gun.ID).First(&attachment).Error)
+	assert.Equal(t, testUser.ID, attachment.OwnerID)
+	assert.Equal(t, "IMG_0001.JPG", attachment.Filename)
+	assert.Equal(t, "Serial number", attachment.Caption)
+	assert.Equal(t, 64, attachment.Width)
+
+	rr = getPage(router, fmt.Sprintf("/owner/attachments/%d", attachment.ID))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/jpeg", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rr.Header().Get("X-Content-Type-Options"))
+	assert.NotContains(t, rr.Body.String(), "SecretCam", "EXIF data is stripped")
+
+	rr = getPage(router, fmt.Sprintf("/owner/attachments/%d/thumbnail", attachment.ID))
+	require.Equal(t, http.StatusOK, rr.Code)
+	_, err := jpeg.Decode(rr.Body)
+	assert.NoError(t, err)
+
+	rr = getPage(router, gunPath)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf(`src="/owner/attachments/%d/thumbnail"`, attachment.ID))
+	assert.Contains(t, rr.Body.String(), "of 25.0 MB photo storage used")
+}
+
+// TestAttachmentValidation tests that uploads which aren't images, or don't fit the quota, are turned away
+func TestAttachmentValidation(t *testing.T) {
+	db, testUser, _, router := setupAttachmentTest(t, "free")
+	_, gun, _ := seedRangeDayOptions(t, db.DB, testUser.ID)
+	uploadPath := fmt.Sprintf("/owner/guns/%d/attachments", gun.ID)
+
+	// The file's contents decide its type, not its name
+	rr := uploadPhoto(t, router, uploadPath, "photo.jpg", []byte("<html><script>alert(1)</script></html>"))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	// A full quota has no room for another photo
+	gunID := gun.ID
+	require.NoError(t, db.DB.Create(&models.Attachment{OwnerID: testUser.ID, GunID: &gunID, ContentType: "image/jpeg", StorageKey: "full", ThumbnailKey: "full-thumb", Size: attachments.QuotaForTier("free")}).Error)
+	rr = uploadPhoto(t, router, uploadPath, "photo.jpg", photoWithEXIF(t))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	var count int64
+	db.DB.Model(&models.Attachment{}).Where("owner_id = ?", testUser.ID).Count(&count)
+	assert.Equal(t, int64(1), count, "Neither upload is stored")
+
+	// A paid tier has room
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", testUser.ID).Update("subscription_tier", "monthly").Error)
+	uploadPhoto(t, router, uploadPath, "photo.jpg", photoWithEXIF(t))
+	db.DB.Model(&models.Attachment{}).Where("owner_id = ?", testUser.ID).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
+
+// TestAttachmentPrivacy tests that owners can only see and change photos of their own guns
+func TestAttachmentPrivacy(t *testing.T) {
+	db, _, store, router := setupAttachmentTest(t, "monthly")
+	theirGun := models.Gun{Name: "Not Mine", OwnerID: 99999}
+	require.NoError(t, db.DB.Create(&theirGun).Error)
+	require.NoError(t, store.Put(t.Context(), "attachments/99999/theirs.jpg", bytes.NewReader(photoWithEXIF(t)), 0, "image/jpeg"))
+	theirs := models.Attachment{OwnerID: 99999, GunID: &theirGun.ID, ContentType: "image/jpeg", StorageKey: "attachments/99999/theirs.jpg", ThumbnailKey: "attachments/99999/theirs.jpg"}
+	require.NoError(t, db.DB.Create(&theirs).Error)
+
+	rr := getPage(router, fmt.Sprintf("/owner/attachments/%d", theirs.ID))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/attachments/%d/delete", theirs.ID), url.Values{})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.NoError(t, db.DB.First(&models.Attachment{}, theirs.ID).Error, "Their photo isn't deleted")
+
+	rr = uploadPhoto(t, router, fmt.Sprintf("/owner/guns/%d/attachments", theirGun.ID), "photo.jpg", photoWithEXIF(t))
+	assert.Equal(t, "/owner", rr.Header().Get("Location"))
+	var count int64
+	db.DB.Model(&models.Attachment{}).Where("gun_id = ?", theirGun.ID).Count(&count)
+	assert.Equal(t, int64(1), count, "Nothing is attached to their gun")
+}
+
+// TestAmmoAttachment tests attaching a receipt to ammunition, and that deleting photos removes their files
+func TestAmmoAttachment(t *testing.T) {
+	db, testUser, store, router := setupAttachmentTest(t, "monthly")
+	_, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	rr := uploadPhoto(t, router, fmt.Sprintf("/owner/munitions/%d/attachments", ammo.ID), "receipt.jpg", photoWithEXIF(t))
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	var receipt models.Attachment
+	require.NoError(t, db.DB.Where("ammo_id = ?", ammo.ID).First(&receipt).Error)
+
+	rr = getPage(router, fmt.Sprintf("/owner/munitions/%d", ammo.ID))
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf(`src="/owner/attachments/%d/thumbnail"`, receipt.ID))
+
+	// Deleting a photo removes it and its files
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/attachments/%d/delete", receipt.ID), url.Values{})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, fmt.Sprintf("/owner/munitions/%d", ammo.ID), rr.Header().Get("Location"))
+	_, err := store.Open(t.Context(), receipt.StorageKey)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Deleting a gun removes its photos
+	uploadPhoto(t, router, fmt.Sprintf("/owner/guns/%d/attachments", gun.ID), "serial.jpg", photoWithEXIF(t))
+	var photo models.Attachment
+	require.NoError(t, db.DB.Where("gun_id = ?", gun.ID).First(&photo).Error)
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/guns/%d/delete", gun.ID), url.Values{})
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Error(t, db.DB.Unscoped().First(&models.Attachment{}, photo.ID).Error)
+	_, err = store.Open(t.Context(), photo.ThumbnailKey)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupExportTest builds a test database, an authenticated user and a router with the export routes
+func setupExportTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+
+	ownerController := controller.NewOwnerController(service)
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/guns/export.csv", ownerController.GunExport)
+	router.GET("/owner/munitions/export.csv", ownerController.AmmoExport)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, testUser, router
+}
+
+// readExport checks the response is a CSV download and parses its rows
+func readExport(t *testing.T, router *gin.Engine, path string) [][]string {
+	rr := getPage(router, path)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment; filename=\"armory-")
+
+	rows, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	require.NoError(t, err)
+	return rows
+}
+
+// TestGunExport tests that every one of the owner's guns is exported, past the free tier limit
+func TestGunExport(t *testing.T) {
+	db, testUser, router := setupExportTest(t)
+	_, gun, _ := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	paid := 649.99
+	acquired := time.Date(2023, 6, 15, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, db.DB.Model(&gun).Updates(map[string]interface{}{
+		"serial_number": "ABC123",
+		"finish":        "Cerakote",
+		"purpose":       "=HYPERLINK(\"http://example.com\")",
+		"paid":          paid,
+		"acquired":      acquired,
+	}).Error)
+
+	// Enough guns to span more than one batch
+	for i := 0; i < 150; i++ {
+		extra := models.Gun{Name: fmt.Sprintf("Extra %03d", i), WeaponTypeID: gun.WeaponTypeID, CaliberID: gun.CaliberID, ManufacturerID: gun.ManufacturerID, OwnerID: testUser.ID}
+		require.NoError(t, db.DB.Create(&extra).Error)
+	}
+	other := models.Gun{Name: "Not Mine", WeaponTypeID: gun.WeaponTypeID, CaliberID: gun.CaliberID, ManufacturerID: gun.ManufacturerID, OwnerID: testUser.ID + 1000}
+	require.NoError(t, db.DB.Create(&other).Error)
+
+	rows := readExport(t, router, "/owner/guns/export.csv")
+	require.Len(t, rows, 152)
+	assert.Equal(t, []string{"Name", "Manufacturer", "Caliber", "Weapon Type", "Serial Number", "Finish", "Purpose", "Paid", "Acquired"}, rows[0])
+	assert.Equal(t, []string{
+		"Range Pistol",
+		fmt.Sprintf("Range Test Maker %d", testUser.ID),
+		fmt.Sprintf("Range Test 9mm %d", testUser.ID),
+		fmt.Sprintf("Range Test Pistol %d", testUser.ID),
+		"ABC123",
+		"Cerakote",
+		"'=HYPERLINK(\"http://example.com\")",
+		"649.99",
+		"2023-06-15",
+	}, rows[1])
+	assert.Equal(t, "Extra 149", rows[151][0])
+	assert.Equal(t, "", rows[151][7], "Unset price is left blank")
+	for _, row := range rows {
+		assert.NotEqual(t, "Not Mine", row[0])
+	}
+}
+
+// TestAmmoExport tests that every one of the owner's ammunition lots is exported
+func TestAmmoExport(t *testing.T) {
+	db, testUser, router := setupExportTest(t)
+	_, _, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	grain := models.Grain{Weight: 124}
+	bulletStyle := models.BulletStyle{Type: fmt.Sprintf("Export FMJ %d", testUser.ID)}
+	casing := models.Casing{Type: fmt.Sprintf("Export Brass %d", testUser.ID)}
+	require.NoError(t, db.DB.FirstOrCreate(&grain, models.Grain{Weight: 124}).Error)
+	require.NoError(t, db.DB.Create(&bulletStyle).Error)
+	require.NoError(t, db.DB.Create(&casing).Error)
+	require.NoError(t, db.DB.Model(&ammo).Updates(map[string]interface{}{
+		"grain_id":        grain.ID,
+		"bullet_style_id": bulletStyle.ID,
+		"casing_id":       casing.ID,
+		"expended":        120,
+		"paid":            189.5,
+	}).Error)
+
+	rows := readExport(t, router, "/owner/munitions/export.csv")
+	require.Len(t, rows, 2)
+	assert.Equal(t, []string{"Name", "Brand", "Caliber", "Grain", "Bullet Style", "Casing", "Count", "Expended", "Paid", "Acquired"}, rows[0])
+	assert.Equal(t, []string{
+		"Range Ammo",
+		fmt.Sprintf("Range Test Brand %d", testUser.ID),
+		fmt.Sprintf("Range Test 9mm %d", testUser.ID),
+		"124",
+		bulletStyle.Type,
+		casing.Type,
+		"500",
+		"120",
+		"189.50",
+		"",
+	}, rows[1])
+}
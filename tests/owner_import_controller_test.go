@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/csvimport"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// importGunsCSV is a spreadsheet of guns with the owner's own headers and spelling
+const importGunsCSV = `Make,Model,Cal.,Type,S/N,Price Paid,Purchased
+Import Tst Arms,Hunter,ITM,import test rifle,SN-1,"$1,299.99",3/14/2021
+Import Test Arms,Plinker,Import Test Magnum,Import Test Rifle,SN-2,,
+`
+
+// setupImportTest builds a test database, an authenticated user on the given tier,
+// the reference data the import CSVs name, and a router with the import routes
+func setupImportTest(t *testing.T, tier string) (*testutils.TestDB, *database.User, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+	require.NoError(t, db.DB.Model(&database.User{}).Where("id = ?", testUser.ID).Update("subscription_tier", tier).Error)
+
+	require.NoError(t, db.DB.Create(&models.Manufacturer{Name: "Import Test Arms", Country: "USA"}).Error)
+	require.NoError(t, db.DB.Create(&models.Caliber{Caliber: "Import Test Magnum", Nickname: "ITM"}).Error)
+	require.NoError(t, db.DB.Create(&models.WeaponType{Type: "Import Test Rifle"}).Error)
+
+	ownerController := controller.NewOwnerController(service)
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/import", ownerController.ImportNew)
+	router.POST("/owner/import/preview", ownerController.ImportPreview)
+	router.POST("/owner/import", ownerController.ImportCreate)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, testUser, router
+}
+
+// uploadImport posts a CSV file to the import preview the way the upload form does
+func uploadImport(t *testing.T, router *gin.Engine, kind string, contents string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	require.NoError(t, writer.WriteField("csrf_token", "test_token"))
+	require.NoError(t, writer.WriteField("kind", kind))
+	part, err := writer.CreateFormFile("file", "inventory.csv")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(contents))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest("POST", "/owner/import/preview", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("X-CSRF-TEST-MODE", "1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// importForm builds the form the preview page posts to import the file
+func importForm(t *testing.T, kind string, contents string, mapping ...string) url.Values {
+	sheet, err := csvimport.ReadCSV(bytes.NewBufferString(contents))
+	require.NoError(t, err)
+
+	form := url.Values{}
+	form.Set("kind", kind)
+	form.Set("csv_data", sheet.Encode())
+	for i, key := range mapping {
+		form.Set("map_"+strconv.Itoa(i), key)
+	}
+	return form
+}
+
+// countGuns counts the guns an owner has
+func countGuns(t *testing.T, db *gorm.DB, ownerID uint) int64 {
+	var count int64
+	require.NoError(t, db.Model(&models.Gun{}).Where("owner_id = ?", ownerID).Count(&count).Error)
+	return count
+}
+
+// TestImportPreview tests that an uploaded file is mapped and checked without saving anything
+func TestImportPreview(t *testing.T) {
+	db, testUser, router := setupImportTest(t, "monthly")
+
+	rr := getPage(router, "/owner/import?kind=ammo")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `value="ammo" class="mr-2" checked`)
+
+	rr = uploadImport(t, router, "guns", importGunsCSV)
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `<option value="manufacturer" selected>`)
+	assert.Contains(t, body, `<option value="serial_number" selected>`)
+	assert.Contains(t, body, "2 rows checked.")
+	assert.Contains(t, body, `Manufacturer: "Import Tst Arms" → Import Test Arms`, "Fuzzy matches are shown for checking")
+	assert.NotContains(t, body, `"ITM" →`, "Nicknames are exact matches")
+	assert.Contains(t, body, "Import 2 guns")
+	assert.Zero(t, countGuns(t, db.DB, testUser.ID), "Previewing saves nothing")
+
+	// A file that can't be read goes back to the upload form
+	rr = uploadImport(t, router, "guns", "Make,Model\n")
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Couldn&#39;t import the file: file has no rows to import")
+}
+
+// TestImportCreate tests that a previewed file is imported in one go
+func TestImportCreate(t *testing.T) {
+	db, testUser, router := setupImportTest(t, "monthly")
+
+	form := importForm(t, "guns", importGunsCSV, "manufacturer", "name", "caliber", "weapon_type", "serial_number", "paid", "acquired")
+	rr := postRangeDayForm(router, "/owner/import", form)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/guns/arsenal", rr.Header().Get("Location"))
+
+	var guns []models.Gun
+	require.NoError(t, db.DB.Preload("Manufacturer").Preload("Caliber").Where("owner_id = ?", testUser.ID).Order("id").Find(&guns).Error)
+	require.Len(t, guns, 2)
+	assert.Equal(t, "Hunter", guns[0].Name)
+	assert.Equal(t, "Import Test Arms", guns[0].Manufacturer.Name)
+	assert.Equal(t, "Import Test Magnum", guns[0].Caliber.Caliber)
+	assert.Equal(t, "SN-1", guns[0].SerialNumber)
+	require.NotNil(t, guns[0].Paid)
+	assert.Equal(t, 1299.99, *guns[0].Paid)
+	require.NotNil(t, guns[0].Acquired)
+	assert.Equal(t, "2021-03-14", guns[0].Acquired.Format("2006-01-02"))
+	assert.Nil(t, guns[1].Paid)
+}
+
+// TestImportCreateWithErrors tests that nothing is imported while any row has errors
+func TestImportCreateWithErrors(t *testing.T) {
+	db, testUser, router := setupImportTest(t, "monthly")
+	contents := importGunsCSV + "Nobody Makes This,Mystery,ITM,Import Test Rifle,SN-3,-5,\n"
+
+	// Leaving the caliber column unmapped is caught before any rows are checked
+	form := importForm(t, "guns", contents, "manufacturer", "name", "", "weapon_type")
+	rr := postRangeDayForm(router, "/owner/import/preview", form)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Choose the column that holds Caliber")
+	assert.NotContains(t, rr.Body.String(), "rows checked")
+
+	form = importForm(t, "guns", contents, "manufacturer", "name", "caliber", "weapon_type", "serial_number", "paid")
+	rr = postRangeDayForm(router, "/owner/import", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "1 need fixing before anything can be imported")
+	assert.Contains(t, rr.Body.String(), "Manufacturer &#34;Nobody Makes This&#34; doesn&#39;t match any we know")
+	assert.NotContains(t, rr.Body.String(), "Import 3 guns")
+	assert.Zero(t, countGuns(t, db.DB, testUser.ID), "Valid rows aren't imported without the rest")
+}
+
+// TestImportRespectsFreeTierLimit tests that free tier owners can't import past their limit
+func TestImportRespectsFreeTierLimit(t *testing.T) {
+	db, testUser, router := setupImportTest(t, "free")
+	existing := models.Gun{Name: "Already Owned", OwnerID: testUser.ID}
+	require.NoError(t, db.DB.Create(&existing).Error)
+
+	rr := uploadImport(t, router, "guns", importGunsCSV)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Free tier only allows 2 guns, so you can import 1 more.")
+	assert.Contains(t, rr.Body.String(), "Over your plan&#39;s limit")
+
+	form := importForm(t, "guns", importGunsCSV, "manufacturer", "name", "caliber", "weapon_type")
+	rr = postRangeDayForm(router, "/owner/import", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, int64(1), countGuns(t, db.DB, testUser.ID))
+
+	// A file within the limit imports
+	form = importForm(t, "guns", "Make,Model,Cal.,Type\nImport Test Arms,Hunter,ITM,Import Test Rifle\n", "manufacturer", "name", "caliber", "weapon_type")
+	rr = postRangeDayForm(router, "/owner/import", form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, int64(2), countGuns(t, db.DB, testUser.ID))
+}
+
+// TestImportAmmo tests importing ammunition with brand and grain matching
+func TestImportAmmo(t *testing.T) {
+	db, testUser, router := setupImportTest(t, "monthly")
+	require.NoError(t, db.DB.Create(&models.Brand{Name: "Import Test Ammo Co"}).Error)
+	grain := models.Grain{Weight: 147}
+	require.NoError(t, db.DB.FirstOrCreate(&grain, models.Grain{Weight: 147}).Error)
+
+	contents := "Name,Brand,Caliber,Grain,Count,Expended\nRange Box,import test ammo,ITM,147gr,\"1,000\",50\nOverfired,Import Test Ammo Co,ITM,147,10,20\n"
+	rr := uploadImport(t, router, "ammo", contents)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Expended count cannot be greater than total count")
+
+	form := importForm(t, "ammo", "Name,Brand,Caliber,Grain,Count,Expended\nRange Box,import test ammo,ITM,147gr,\"1,000\",50\n", "name", "brand", "caliber", "grain", "count", "expended")
+	rr = postRangeDayForm(router, "/owner/import", form)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/munitions", rr.Header().Get("Location"))
+
+	var ammo models.Ammo
+	require.NoError(t, db.DB.Preload("Brand").Where("owner_id = ?", testUser.ID).First(&ammo).Error)
+	assert.Equal(t, "Import Test Ammo Co", ammo.Brand.Name)
+	assert.Equal(t, grain.ID, ammo.GrainID)
+	assert.Equal(t, 1000, ammo.Count)
+	assert.Equal(t, 50, ammo.Expended)
+}
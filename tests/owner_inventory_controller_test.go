@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupInventoryTest builds a test database, an authenticated user with two guns,
+// and a router with the inventory report and verify routes
+func setupInventoryTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+
+	manufacturer := models.Manufacturer{Name: "Inventory Test Arms", Country: "USA"}
+	require.NoError(t, db.DB.Create(&manufacturer).Error)
+	caliber := models.Caliber{Caliber: "Inventory Test Magnum"}
+	require.NoError(t, db.DB.Create(&caliber).Error)
+
+	paid := 1299.99
+	acquired := time.Date(2021, 3, 14, 0, 0, 0, 0, time.UTC)
+	guns := []models.Gun{
+		{Name: "Zephyr (Engraved)", SerialNumber: "SN-ZEPHYR", OwnerID: testUser.ID, ManufacturerID: manufacturer.ID, CaliberID: caliber.ID, Paid: &paid, Acquired: &acquired},
+		{Name: "Anvil", SerialNumber: "SN-ANVIL", OwnerID: testUser.ID, ManufacturerID: manufacturer.ID, CaliberID: caliber.ID},
+	}
+	require.NoError(t, db.DB.Create(&guns).Error)
+
+	ownerController := controller.NewOwnerController(service)
+	homeController := controller.NewHomeController(service)
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/guns/inventory.pdf", ownerController.GunInventoryPDF)
+	router.GET("/inventory/verify", homeController.InventoryVerifyHandler)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, testUser, router
+}
+
+// pdfText returns the uncompressed content streams of a PDF
+func pdfText(t *testing.T, doc []byte) string {
+	var text bytes.Buffer
+	streams := regexp.MustCompile(`(?s)stream\n(.*?)\nendstream`).FindAllSubmatch(doc, -1)
+	require.NotEmpty(t, streams)
+	for _, stream := range streams {
+		reader, err := zlib.NewReader(bytes.NewReader(stream[1]))
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		text.Write(content)
+	}
+	return text.String()
+}
+
+// TestGunInventoryPDF tests that the inventory lists the owner's guns and is recorded by its hash
+func TestGunInventoryPDF(t *testing.T) {
+	db, testUser, router := setupInventoryTest(t)
+
+	rr := getPage(router, "/owner/guns/inventory.pdf")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/pdf", rr.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="armory-inventory-\d{4}-\d{2}-\d{2}\.pdf"$`, rr.Header().Get("Content-Disposition"))
+	assert.True(t, bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")))
+
+	var report models.InventoryReport
+	require.NoError(t, db.DB.Where("user_id = ?", testUser.ID).First(&report).Error)
+	assert.Equal(t, 2, report.GunCount)
+	assert.Equal(t, 1299.99, report.TotalValue)
+	assert.Len(t, report.Hash, 64)
+
+	text := pdfText(t, rr.Body.Bytes())
+	assert.Contains(t, text, "("+testUser.Email+")")
+	assert.Contains(t, text, `(Zephyr \(Engraved\))`)
+	assert.Contains(t, text, "(SN-ANVIL)")
+	assert.Contains(t, text, "($1,299.99)")
+	assert.Contains(t, text, "(Not recorded)")
+	assert.Contains(t, text, "(2 guns listed, 1 without a purchase price)")
+	assert.Contains(t, text, "(Content hash \\(SHA-256\\): "+report.Hash+")")
+	assert.Contains(t, text, "/inventory/verify?hash="+report.Hash)
+	assert.Contains(t, text, "(Page 1 of 1)")
+
+	// Guns are sorted by make then model
+	assert.Less(t, bytes.Index([]byte(text), []byte("(Anvil)")), bytes.Index([]byte(text), []byte("(Zephyr")))
+}
+
+// TestInventoryReportHash tests that the content hash covers the report's contents
+func TestInventoryReportHash(t *testing.T) {
+	paid := 500.0
+	guns := []models.Gun{{Name: "Anvil", SerialNumber: "SN-1", Paid: &paid}}
+	generated := time.Date(2026, 3, 14, 9, 30, 0, 0, time.UTC)
+
+	report := models.NewInventoryReport(1, "owner@example.com", guns, generated)
+	assert.Equal(t, report.Hash, models.NewInventoryReport(1, "owner@example.com", guns, generated).Hash, "The same contents give the same hash")
+	assert.NotEqual(t, report.Hash, models.NewInventoryReport(1, "owner@example.com", guns, generated.Add(time.Second)).Hash)
+
+	guns[0].SerialNumber = "SN-2"
+	assert.NotEqual(t, report.Hash, models.NewInventoryReport(1, "owner@example.com", guns, generated).Hash, "Changing a serial number changes the hash")
+}
+
+// TestInventoryVerify tests that an issued report can be verified by its hash without revealing its owner
+func TestInventoryVerify(t *testing.T) {
+	db, testUser, router := setupInventoryTest(t)
+
+	rr := getPage(router, "/owner/guns/inventory.pdf")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var report models.InventoryReport
+	require.NoError(t, db.DB.Where("user_id = ?", testUser.ID).First(&report).Error)
+
+	rr = getPage(router, "/inventory/verify?hash="+report.Hash)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "This report was issued by The Virtual Armory.")
+	assert.Contains(t, rr.Body.String(), "$1,299.99")
+	assert.NotContains(t, rr.Body.String(), "SN-ANVIL")
+
+	rr = getPage(router, "/inventory/verify?hash="+string(bytes.Repeat([]byte("0"), 64)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "No report with this content hash was issued")
+
+	rr = getPage(router, "/inventory/verify")
+	assert.NotContains(t, rr.Body.String(), "No report with this content hash")
+}
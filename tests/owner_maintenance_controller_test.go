@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupMaintenanceTest builds a test database, an authenticated user and a router with the maintenance routes
+func setupMaintenanceTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+
+	ownerController := controller.NewOwnerController(service)
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner", ownerController.LandingPage)
+	router.GET("/owner/maintenance", ownerController.MaintenanceOverview)
+	router.GET("/owner/guns/:id", ownerController.Show)
+	router.GET("/owner/guns/:id/maintenance", ownerController.MaintenanceIndex)
+	router.POST("/owner/guns/:id/maintenance", ownerController.MaintenanceCreate)
+	router.POST("/owner/guns/:id/maintenance/:record_id/delete", ownerController.MaintenanceDelete)
+	router.POST("/owner/guns/:id/intervals", ownerController.ServiceIntervalCreate)
+	router.POST("/owner/guns/:id/intervals/:interval_id/delete", ownerController.ServiceIntervalDelete)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, testUser, router
+}
+
+// getPage requests a page from the router and returns the recorder
+func getPage(router *gin.Engine, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestMaintenanceCreate tests logging a cleaning stamped with the gun's round count
+func TestMaintenanceCreate(t *testing.T) {
+	db, testUser, router := setupMaintenanceTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+	require.NoError(t, models.CreateRangeDay(db.DB, &models.RangeDay{UserID: testUser.ID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -1), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 120}}}))
+
+	maintenancePath := fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID)
+	form := url.Values{}
+	form.Set("type", models.MaintenanceTypeCleaning)
+	form.Set("performed_at", time.Now().Format("2006-01-02"))
+	form.Set("notes", "Full detail strip")
+
+	rr := postRangeDayForm(router, maintenancePath, form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, maintenancePath, rr.Header().Get("Location"))
+
+	records, err := models.FindMaintenanceRecordsByGun(db.DB, gun.ID, testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, 120, records[0].RoundCount)
+	assert.Equal(t, "Full detail strip", records[0].Notes)
+
+	rr = getPage(router, maintenancePath)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Full detail strip")
+	assert.Contains(t, rr.Body.String(), "Lifetime Round Count")
+
+	rr = postRangeDayForm(router, fmt.Sprintf("%s/%d/delete", maintenancePath, records[0].ID), url.Values{})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	records, err = models.FindMaintenanceRecordsByGun(db.DB, gun.ID, testUser.ID)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+// TestMaintenanceCreateValidation tests that a part replacement must name the part
+func TestMaintenanceCreateValidation(t *testing.T) {
+	db, testUser, router := setupMaintenanceTest(t)
+	_, gun, _ := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	form := url.Values{}
+	form.Set("type", models.MaintenanceTypePartReplacement)
+	form.Set("performed_at", time.Now().Format("2006-01-02"))
+
+	rr := postRangeDayForm(router, fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID), form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	records, err := models.FindMaintenanceRecordsByGun(db.DB, gun.ID, testUser.ID)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+// TestServiceIntervalDueShownOnPages tests that a due interval is flagged on the gun and owner pages
+func TestServiceIntervalDueShownOnPages(t *testing.T) {
+	db, testUser, router := setupMaintenanceTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+	require.NoError(t, models.CreateRangeDay(db.DB, &models.RangeDay{UserID: testUser.ID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -1), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 250}}}))
+
+	form := url.Values{}
+	form.Set("description", "Deep clean")
+	form.Set("maintenance_type", models.MaintenanceTypeCleaning)
+	form.Set("every_rounds", "200")
+
+	rr := postRangeDayForm(router, fmt.Sprintf("/owner/guns/%d/intervals", gun.ID), form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	intervals, err := models.FindServiceIntervalsByGun(db.DB, gun.ID, testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, intervals, 1)
+	assert.Equal(t, 200, intervals[0].EveryRounds)
+
+	for _, path := range []string{fmt.Sprintf("/owner/guns/%d", gun.ID), "/owner", "/owner/maintenance"} {
+		rr = getPage(router, path)
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+		assert.Contains(t, rr.Body.String(), "Due for Service", path)
+		assert.Contains(t, rr.Body.String(), "Deep clean", path)
+	}
+
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/guns/%d/intervals/%d/delete", gun.ID, intervals[0].ID), url.Values{})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	rr = getPage(router, "/owner")
+	assert.NotContains(t, rr.Body.String(), "Due for Service")
+}
+
+// TestMaintenanceIndexOtherUsersGun tests that another user's gun can't be maintained
+func TestMaintenanceIndexOtherUsersGun(t *testing.T) {
+	db, _, router := setupMaintenanceTest(t)
+	_, gun, _ := seedRangeDayOptions(t, db.DB, 99999)
+
+	rr := getPage(router, fmt.Sprintf("/owner/guns/%d/maintenance", gun.ID))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner", rr.Header().Get("Location"))
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/geocode"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRangeTest builds a test database, an authenticated user and a router with the range routes
+func setupRangeTest(t *testing.T) (*testutils.TestDB, *database.User, *geocode.StubGeocoder, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+
+	geocoder := geocode.NewStubGeocoder()
+	ownerController := controller.NewOwnerController(service)
+	ownerController.SetGeocoder(geocoder)
+
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/ranges", ownerController.RangeIndex)
+	router.GET("/owner/ranges/new", ownerController.RangeNew)
+	router.POST("/owner/ranges", ownerController.RangeCreate)
+	router.GET("/owner/ranges/:id", ownerController.RangeShow)
+	router.GET("/owner/ranges/:id/edit", ownerController.RangeEdit)
+	router.POST("/owner/ranges/:id", ownerController.RangeUpdate)
+	router.POST("/owner/ranges/:id/delete", ownerController.RangeDelete)
+	router.POST("/owner/range-days", ownerController.RangeDayCreate)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, testUser, geocoder, router
+}
+
+// TestRangeCreateGeocodesPrivateRange tests adding a private range placed on the map by the geocoder
+func TestRangeCreateGeocodesPrivateRange(t *testing.T) {
+	db, testUser, geocoder, router := setupRangeTest(t)
+	geocoder.Known["12 gun club rd, fredericksburg, tx 78624"] = geocode.Location{Latitude: 30.2752, Longitude: -98.8720}
+
+	form := url.Values{}
+	form.Set("range_name", "Hill Country Gun Club")
+	form.Set("street_number", "12")
+	form.Set("street_name", "Gun Club Rd")
+	form.Set("city", "Fredericksburg")
+	form.Set("state", "tx")
+	form.Set("zip", "78624")
+
+	rr := postRangeDayForm(router, "/owner/ranges", form)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+
+	var r models.Range
+	require.NoError(t, db.DB.Where("range_name = ?", "Hill Country Gun Club").First(&r).Error)
+	assert.Equal(t, testUser.ID, r.OwnerID)
+	assert.Equal(t, "TX", r.State)
+	require.True(t, r.HasLocation())
+	assert.Equal(t, 30.2752, *r.Latitude)
+	assert.Equal(t, -98.8720, *r.Longitude)
+	assert.Equal(t, fmt.Sprintf("/owner/ranges/%d", r.ID), rr.Header().Get("Location"))
+
+	// Coordinates entered by the owner win over the geocoder
+	form.Set("range_name", "Back Forty")
+	form.Set("latitude", "30.1")
+	form.Set("longitude", "-98.5")
+	rr = postRangeDayForm(router, "/owner/ranges", form)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+
+	var manual models.Range
+	require.NoError(t, db.DB.Where("range_name = ?", "Back Forty").First(&manual).Error)
+	assert.Equal(t, 30.1, *manual.Latitude)
+	assert.Equal(t, -98.5, *manual.Longitude)
+
+	// Moving the range places it on the map again
+	form = url.Values{}
+	form.Set("range_name", "Hill Country Gun Club")
+	form.Set("city", "Boise")
+	form.Set("state", "ID")
+	form.Set("latitude", "30.2752")
+	form.Set("longitude", "-98.872")
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/ranges/%d", r.ID), form)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+
+	require.NoError(t, db.DB.First(&r, r.ID).Error)
+	assert.Equal(t, "Boise", r.City)
+	assert.InDelta(t, 44.35, *r.Latitude, 0.01, "Moved range is placed at the center of its new state")
+}
+
+// TestRangeCreateValidation tests that range form errors are shown on the form
+func TestRangeCreateValidation(t *testing.T) {
+	db, testUser, _, router := setupRangeTest(t)
+
+	form := url.Values{}
+	form.Set("range_name", "Bad Zip Range")
+	form.Set("zip", "123")
+	form.Set("latitude", "north")
+
+	rr := postRangeDayForm(router, "/owner/ranges", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Latitude must be a number")
+	assert.Contains(t, rr.Body.String(), `value="Bad Zip Range"`)
+
+	form.Del("latitude")
+	rr = postRangeDayForm(router, "/owner/ranges", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Range zip code must be five digits")
+
+	var count int64
+	require.NoError(t, db.DB.Model(&models.Range{}).Where("owner_id = ?", testUser.ID).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+// TestRangePrivacy tests that owners can't see or use each other's private ranges or change public ones
+func TestRangePrivacy(t *testing.T) {
+	db, testUser, _, router := setupRangeTest(t)
+	public, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+	theirs := models.Range{RangeName: "Secret Spot", City: "Austin", State: "TX", OwnerID: 99999}
+	require.NoError(t, db.DB.Create(&theirs).Error)
+
+	// Another owner's private range isn't listed or shown
+	rr := getPage(router, "/owner/ranges")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Eagle Range")
+	assert.NotContains(t, rr.Body.String(), "Secret Spot")
+
+	rr = getPage(router, fmt.Sprintf("/owner/ranges/%d", theirs.ID))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/ranges", rr.Header().Get("Location"))
+
+	// ...or usable for a range day
+	form := url.Values{}
+	form.Set("date", time.Now().Format("2006-01-02"))
+	form.Set("range_id", fmt.Sprintf("%d", theirs.ID))
+	form.Set("gun_id", fmt.Sprintf("%d", gun.ID))
+	form.Set("ammo_id", fmt.Sprintf("%d", ammo.ID))
+	rr = postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), models.ErrInvalidRange.Error())
+
+	// Public ranges can be viewed but not changed
+	rr = getPage(router, fmt.Sprintf("/owner/ranges/%d", public.ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Public range")
+
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/ranges/%d", public.ID), url.Values{"range_name": {"Renamed"}})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/ranges/%d/delete", public.ID), url.Values{})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	stored, err := models.FindRangeByID(db.DB, public.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Eagle Range", stored.RangeName)
+}
+
+// TestRangeHistoryByLocation tests that the range pages summarize the owner's range days at each range
+func TestRangeHistoryByLocation(t *testing.T) {
+	db, testUser, _, router := setupRangeTest(t)
+	public, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+	mine := models.Range{RangeName: "Back Forty", City: "Waco", State: "TX", OwnerID: testUser.ID}
+	require.NoError(t, db.DB.Create(&mine).Error)
+
+	for _, rangeDay := range []models.RangeDay{
+		{UserID: testUser.ID, RangeID: public.ID, Date: time.Now().AddDate(0, 0, -3), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 40}}},
+		{UserID: testUser.ID, RangeID: public.ID, Date: time.Now().AddDate(0, 0, -1), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 60}}},
+		{UserID: testUser.ID, RangeID: mine.ID, Date: time.Now().AddDate(0, 0, -2), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 25}}},
+	} {
+		rangeDay := rangeDay
+		require.NoError(t, models.CreateRangeDay(db.DB, &rangeDay))
+	}
+
+	rr := getPage(router, "/owner/ranges")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Range History by Location")
+	assert.Contains(t, rr.Body.String(), "Dallas, TX")
+	assert.Contains(t, rr.Body.String(), "Waco, TX")
+	assert.Contains(t, rr.Body.String(), "<td class=\"py-3 px-4\">100</td>")
+
+	rr = getPage(router, fmt.Sprintf("/owner/ranges/%d", public.ID))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Range Pistol")
+	assert.Contains(t, rr.Body.String(), time.Now().AddDate(0, 0, -3).Format("Jan 2, 2006"))
+
+	// A private range with range days logged at it can't be deleted
+	rr = postRangeDayForm(router, fmt.Sprintf("/owner/ranges/%d/delete", mine.ID), url.Values{})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	_, err := models.FindRangeByID(db.DB, mine.ID)
+	assert.NoError(t, err)
+}
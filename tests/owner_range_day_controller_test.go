@@ -0,0 +1,315 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/geocode"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// seedRangeDayOptions creates a range plus a gun and ammunition owned by the user
+func seedRangeDayOptions(t *testing.T, db *gorm.DB, ownerID uint) (models.Range, models.Gun, models.Ammo) {
+	r := models.Range{RangeName: "Eagle Range", City: "Dallas", State: "TX"}
+	require.NoError(t, db.Create(&r).Error)
+
+	suffix := fmt.Sprintf(" %d", ownerID)
+	weaponType := models.WeaponType{Type: "Range Test Pistol" + suffix}
+	caliber := models.Caliber{Caliber: "Range Test 9mm" + suffix}
+	manufacturer := models.Manufacturer{Name: "Range Test Maker" + suffix}
+	require.NoError(t, db.Create(&weaponType).Error)
+	require.NoError(t, db.Create(&caliber).Error)
+	require.NoError(t, db.Create(&manufacturer).Error)
+
+	gun := models.Gun{Name: "Range Pistol", WeaponTypeID: weaponType.ID, CaliberID: caliber.ID, ManufacturerID: manufacturer.ID, OwnerID: ownerID}
+	require.NoError(t, db.Create(&gun).Error)
+
+	brand := models.Brand{Name: "Range Test Brand" + suffix}
+	require.NoError(t, db.Create(&brand).Error)
+	ammo := models.Ammo{Name: "Range Ammo", BrandID: brand.ID, CaliberID: caliber.ID, OwnerID: ownerID, Count: 500}
+	require.NoError(t, db.Create(&ammo).Error)
+
+	return r, gun, ammo
+}
+
+// setupRangeDayTest builds a test database, an authenticated user and a router with the range day routes
+func setupRangeDayTest(t *testing.T) (*testutils.TestDB, *testhelper.ControllerTestHelper, *database.User, *gin.Engine) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	testUser := helper.CreateTestUser(t)
+
+	ownerController := controller.NewOwnerController(service)
+	ownerController.SetGeocoder(geocode.NewStubGeocoder())
+	router := helper.GetAuthenticatedRouter(testUser.ID, testUser.Email)
+	router.GET("/owner/range-days", ownerController.RangeDayIndex)
+	router.GET("/owner/range-days/new", ownerController.RangeDayNew)
+	router.POST("/owner/range-days", ownerController.RangeDayCreate)
+	router.GET("/owner/range-days/:id", ownerController.RangeDayShow)
+	router.GET("/owner/range-days/:id/edit", ownerController.RangeDayEdit)
+	router.POST("/owner/range-days/:id", ownerController.RangeDayUpdate)
+	router.POST("/owner/range-days/:id/delete", ownerController.RangeDayDelete)
+
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	return db, helper, testUser, router
+}
+
+// postRangeDayForm submits a form to the router and returns the recorder
+func postRangeDayForm(router *gin.Engine, path string, form url.Values) *httptest.ResponseRecorder {
+	form.Set("csrf_token", "test_token")
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CSRF-TEST-MODE", "1")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestRangeDayCreate tests logging a range day with the pickers
+func TestRangeDayCreate(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	form := url.Values{}
+	form.Set("date", time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
+	form.Set("range_id", fmt.Sprintf("%d", r.ID))
+	form.Set("gun_id", fmt.Sprintf("%d", gun.ID))
+	form.Set("ammo_id", fmt.Sprintf("%d", ammo.ID))
+	form.Set("shots_fired", "75")
+	form.Set("comments", "Worked on trigger control")
+
+	rr := postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/range-days", rr.Header().Get("Location"))
+
+	rangeDays, err := models.FindRangeDaysByUser(db.DB, testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, rangeDays, 1)
+	require.Len(t, rangeDays[0].Items, 1)
+	assert.Equal(t, 75, rangeDays[0].Items[0].ShotsFired)
+	assert.Equal(t, "Worked on trigger control", rangeDays[0].Comments)
+	assert.Equal(t, r.ID, rangeDays[0].RangeID)
+	assert.Equal(t, 75, rangeDays[0].Items[0].Ammo.Expended, "Shots fired should be spent from the ammunition")
+}
+
+// TestRangeDayCreateWithSeveralGuns tests logging a range day with a line item per gun
+func TestRangeDayCreateWithSeveralGuns(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	rifle := models.Gun{Name: "Range Rifle", WeaponTypeID: gun.WeaponTypeID, CaliberID: gun.CaliberID, ManufacturerID: gun.ManufacturerID, OwnerID: testUser.ID}
+	require.NoError(t, db.DB.Create(&rifle).Error)
+
+	form := url.Values{}
+	form.Set("date", time.Now().Format("2006-01-02"))
+	form.Set("range_id", fmt.Sprintf("%d", r.ID))
+	form["gun_id"] = []string{fmt.Sprintf("%d", gun.ID), fmt.Sprintf("%d", rifle.ID), ""}
+	form["ammo_id"] = []string{fmt.Sprintf("%d", ammo.ID), fmt.Sprintf("%d", ammo.ID), ""}
+	form["shots_fired"] = []string{"50", "30", ""}
+	form["item_notes"] = []string{"", "Zeroed the optic", ""}
+
+	rr := postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	rangeDays, err := models.FindRangeDaysByUser(db.DB, testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, rangeDays, 1)
+	require.Len(t, rangeDays[0].Items, 2, "The blank row should be skipped")
+	assert.Equal(t, gun.ID, rangeDays[0].Items[0].GunID)
+	assert.Equal(t, rifle.ID, rangeDays[0].Items[1].GunID)
+	assert.Equal(t, "Zeroed the optic", rangeDays[0].Items[1].Notes)
+	assert.Equal(t, 80, rangeDays[0].TotalShots())
+
+	var stored models.Ammo
+	require.NoError(t, db.DB.First(&stored, ammo.ID).Error)
+	assert.Equal(t, 80, stored.Expended)
+}
+
+// TestRangeDayCreateExceedingAmmo tests that a range day can't spend more rounds than the ammunition holds
+func TestRangeDayCreateExceedingAmmo(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	form := url.Values{}
+	form.Set("date", time.Now().Format("2006-01-02"))
+	form.Set("range_id", fmt.Sprintf("%d", r.ID))
+	form.Set("gun_id", fmt.Sprintf("%d", gun.ID))
+	form.Set("ammo_id", fmt.Sprintf("%d", ammo.ID))
+	form.Set("shots_fired", fmt.Sprintf("%d", ammo.Count+1))
+
+	rr := postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), models.ErrRangeDayExceedsAmmo.Error())
+
+	var stored models.Ammo
+	require.NoError(t, db.DB.First(&stored, ammo.ID).Error)
+	assert.Equal(t, 0, stored.Expended)
+}
+
+// TestRangeDayCreateWithNewRange tests naming a new range from the form
+func TestRangeDayCreateWithNewRange(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	_, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	form := url.Values{}
+	form.Set("date", time.Now().Format("2006-01-02"))
+	form.Set("new_range_name", "Backyard Berm")
+	form.Set("new_range_city", "Waco")
+	form.Set("new_range_state", "tx")
+	form.Set("gun_id", fmt.Sprintf("%d", gun.ID))
+	form.Set("ammo_id", fmt.Sprintf("%d", ammo.ID))
+
+	rr := postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	rangeDays, err := models.FindRangeDaysByUser(db.DB, testUser.ID)
+	require.NoError(t, err)
+	require.Len(t, rangeDays, 1)
+	assert.Equal(t, "Backyard Berm", rangeDays[0].Range.RangeName)
+	assert.Equal(t, "TX", rangeDays[0].Range.State)
+	assert.Equal(t, testUser.ID, rangeDays[0].Range.OwnerID, "Ranges named on the form are private to the owner")
+	assert.True(t, rangeDays[0].Range.HasLocation(), "New ranges are placed on the map by the geocoder")
+
+	// Naming the same range again reuses it
+	form.Set("date", time.Now().AddDate(0, 0, -1).Format("2006-01-02"))
+	form.Set("new_range_name", "backyard berm")
+	rr = postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	var count int64
+	require.NoError(t, db.DB.Model(&models.Range{}).Where("owner_id = ?", testUser.ID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+// TestRangeDayCreateValidation tests that missing and foreign selections are rejected
+func TestRangeDayCreateValidation(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	r, _, _ := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	// No guns at all
+	form := url.Values{}
+	form.Set("date", time.Now().Format("2006-01-02"))
+	form.Set("range_id", fmt.Sprintf("%d", r.ID))
+	rr := postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Add at least one firearm")
+
+	// A row with rounds but no gun
+	form.Set("shots_fired", "20")
+	rr = postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Firearm is required")
+	assert.Contains(t, rr.Body.String(), `value="20"`, "The row should be preserved")
+
+	// Gun and ammo belonging to someone else
+	_, otherGun, otherAmmo := seedRangeDayOptions(t, db.DB, testUser.ID+1000)
+	form.Set("gun_id", fmt.Sprintf("%d", otherGun.ID))
+	form.Set("ammo_id", fmt.Sprintf("%d", otherAmmo.ID))
+	rr = postRangeDayForm(router, "/owner/range-days", form)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), models.ErrInvalidRangeDayGun.Error())
+
+	var count int64
+	db.DB.Model(&models.RangeDay{}).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+// TestRangeDayIndexFiltersByDate tests listing range days by date
+func TestRangeDayIndexFiltersByDate(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, models.CreateRangeDay(db.DB, &models.RangeDay{UserID: testUser.ID, RangeID: r.ID, Date: day, Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 111}}}))
+	require.NoError(t, models.CreateRangeDay(db.DB, &models.RangeDay{UserID: testUser.ID, RangeID: r.ID, Date: day.AddDate(0, 0, 3), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 222}}}))
+
+	req, _ := http.NewRequest("GET", "/owner/range-days", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Mar 15, 2024")
+	assert.Contains(t, body, "Mar 18, 2024")
+	assert.Less(t, strings.Index(body, "Mar 18, 2024"), strings.Index(body, "Mar 15, 2024"), "Most recent range day should be listed first")
+
+	req, _ = http.NewRequest("GET", "/owner/range-days?date=2024-03-15", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body = rr.Body.String()
+	assert.Contains(t, body, "Mar 15, 2024")
+	assert.NotContains(t, body, "Mar 18, 2024")
+}
+
+// TestRangeDayUpdateAndDelete tests editing and deleting a range day
+func TestRangeDayUpdateAndDelete(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, testUser.ID)
+
+	rangeDay := &models.RangeDay{UserID: testUser.ID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -2), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 50}}}
+	require.NoError(t, models.CreateRangeDay(db.DB, rangeDay))
+	path := fmt.Sprintf("/owner/range-days/%d", rangeDay.ID)
+
+	// The edit form is prefilled with the range day
+	req, _ := http.NewRequest("GET", path+"/edit", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `value="50"`)
+
+	form := url.Values{}
+	form.Set("date", rangeDay.Date.Format("2006-01-02"))
+	form.Set("range_id", fmt.Sprintf("%d", r.ID))
+	form.Set("gun_id", fmt.Sprintf("%d", gun.ID))
+	form.Set("ammo_id", fmt.Sprintf("%d", ammo.ID))
+	form.Set("shots_fired", "150")
+	rr = postRangeDayForm(router, path, form)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	updated, err := models.FindRangeDayByID(db.DB, rangeDay.ID, testUser.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 150, updated.TotalShots())
+
+	rr = postRangeDayForm(router, path+"/delete", url.Values{})
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	_, err = models.FindRangeDayByID(db.DB, rangeDay.ID, testUser.ID)
+	assert.Error(t, err)
+}
+
+// TestRangeDayShowOtherUser tests that another owner's range day is not shown
+func TestRangeDayShowOtherUser(t *testing.T) {
+	db, _, testUser, router := setupRangeDayTest(t)
+	otherUserID := testUser.ID + 1000
+	r, gun, ammo := seedRangeDayOptions(t, db.DB, otherUserID)
+
+	rangeDay := &models.RangeDay{UserID: otherUserID, RangeID: r.ID, Date: time.Now(), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID}}}
+	require.NoError(t, models.CreateRangeDay(db.DB, rangeDay))
+
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/owner/range-days/%d", rangeDay.ID), nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/owner/range-days", rr.Header().Get("Location"))
+}
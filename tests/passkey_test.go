@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/totp"
+	"github.com/hail2skins/armory/internal/webauthn"
+	"github.com/hail2skins/armory/internal/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// passkeyOrigin is where the test router's pages are served from, as httptest requests see it
+const passkeyOrigin = "http://example.com"
+
+// setupPasskeyTest returns a router with the password and passkey sign in routes
+// and the owner's passkey pages, and a verified user
+func setupPasskeyTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	user := &database.User{Email: "passkey@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	authController := controller.NewAuthController(service)
+	ownerController := controller.NewOwnerController(service)
+
+	router := gin.New()
+	router.Use(sessions.Sessions("armory-session", cookie.NewStore([]byte("test-secret-key"))))
+	router.Use(func(c *gin.Context) {
+		c.Set("authController", authController)
+	})
+	router.POST("/login", authController.LoginHandler)
+	router.POST("/login/passkey/options", authController.PasskeyLoginOptionsHandler)
+	router.POST("/login/passkey", authController.PasskeyLoginHandler)
+	router.GET("/owner/profile/passkeys", ownerController.Passkeys)
+	router.POST("/owner/profile/passkeys/options", authController.PasskeyRegistrationOptionsHandler)
+	router.POST("/owner/profile/passkeys", authController.PasskeyRegisterHandler)
+	router.POST("/owner/profile/passkeys/:id/delete", ownerController.PasskeyDelete)
+
+	return db, user, router
+}
+
+// sendJSON posts a JSON body to the page at passkeyOrigin, with the session
+// cookie from an earlier response, if there is one
+func sendJSON(router *gin.Engine, path string, body interface{}, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest("POST", passkeyOrigin+path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	addCookies(req, previous)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// registerPasskey adds a passkey from the authenticator for the signed in user
+func registerPasskey(t *testing.T, router *gin.Engine, authenticator *webauthntest.Authenticator, signedIn *httptest.ResponseRecorder, name string) *httptest.ResponseRecorder {
+	w := sendJSON(router, "/owner/profile/passkeys/options", nil, signedIn)
+	require.Equal(t, http.StatusOK, w.Code)
+	var options webauthn.CreationOptions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+	assert.Equal(t, "example.com", options.RP.ID)
+	assert.Equal(t, "required", options.AuthenticatorSelection.ResidentKey)
+
+	userHandle, err := webauthn.DecodeID(options.User.ID)
+	require.NoError(t, err)
+	registration, err := authenticator.Register(options.RP.ID, options.Challenge, userHandle)
+	require.NoError(t, err)
+
+	return sendJSON(router, "/owner/profile/passkeys", map[string]string{
+		"name":              name,
+		"id":                registration.ID,
+		"clientDataJSON":    registration.ClientDataJSON,
+		"attestationObject": registration.AttestationObject,
+	}, w)
+}
+
+// passkeyLogin signs in with the authenticator's passkey
+func passkeyLogin(t *testing.T, router *gin.Engine, authenticator *webauthntest.Authenticator) *httptest.ResponseRecorder {
+	w := sendJSON(router, "/login/passkey/options", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var options webauthn.RequestOptions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+	assert.Empty(t, options.AllowCredentials)
+
+	assertion, err := authenticator.Assert(options.RPID, options.Challenge)
+	require.NoError(t, err)
+	require.NotNil(t, assertion)
+	return sendJSON(router, "/login/passkey", assertion, w)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	db, user, router := setupPasskeyTest(t)
+	authenticator := webauthntest.New(passkeyOrigin)
+	authenticator.SignCount = 1
+
+	// Adding a passkey needs the user signed in
+	assert.Equal(t, http.StatusUnauthorized, sendJSON(router, "/owner/profile/passkeys/options", nil, nil).Code)
+
+	signedIn := postLogin(router, user.Email, "Password123!")
+	require.Equal(t, http.StatusSeeOther, signedIn.Code)
+	w := registerPasskey(t, router, authenticator, signedIn, "Laptop")
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var passkey models.Passkey
+	require.NoError(t, db.DB.Where("user_id = ?", user.ID).First(&passkey).Error)
+	assert.Equal(t, "Laptop", passkey.Name)
+	assert.Equal(t, uint32(1), passkey.SignCount)
+
+	page := sendForm(router, "GET", "/owner/profile/passkeys", nil, signedIn)
+	assert.Contains(t, page.Body.String(), "Laptop")
+
+	// The passkey signs the user in with no email or password
+	w = passkeyLogin(t, router, authenticator)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"redirect":"/owner"}`, w.Body.String())
+	page = sendForm(router, "GET", "/owner/profile/passkeys", nil, w)
+	assert.Equal(t, http.StatusOK, page.Code)
+	assert.Contains(t, page.Body.String(), "Laptop")
+
+	require.NoError(t, db.DB.First(&passkey, passkey.ID).Error)
+	assert.Equal(t, uint32(2), passkey.SignCount)
+	assert.NotNil(t, passkey.LastUsedAt)
+
+	// A challenge can only be answered once
+	options := sendJSON(router, "/login/passkey/options", nil, nil)
+	var request webauthn.RequestOptions
+	require.NoError(t, json.Unmarshal(options.Body.Bytes(), &request))
+	assertion, _ := authenticator.Assert(request.RPID, request.Challenge)
+	assert.Equal(t, http.StatusOK, sendJSON(router, "/login/passkey", assertion, options).Code)
+	assert.Equal(t, http.StatusUnauthorized, sendJSON(router, "/login/passkey", assertion, options).Code)
+
+	// Once removed, the passkey no longer signs them in
+	w = sendForm(router, "POST", "/owner/profile/passkeys/"+strconv.FormatUint(uint64(passkey.ID), 10)+"/delete", nil, signedIn)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, passkeyLogin(t, router, authenticator).Code)
+
+	var events []models.SecurityEvent
+	require.NoError(t, db.DB.Where("user_id = ?", user.ID).Order("id").Find(&events).Error)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.SecurityEventPasskeyAdded, events[0].Type)
+	assert.Equal(t, models.SecurityEventPasskeyRemoved, events[1].Type)
+}
+
+func TestPasskeyUserHandle(t *testing.T) {
+	db, user, router := setupPasskeyTest(t)
+	signedIn := postLogin(router, user.Email, "Password123!")
+	require.Equal(t, http.StatusCreated, registerPasskey(t, router, webauthntest.New(passkeyOrigin), signedIn, "Laptop").Code)
+	require.Equal(t, http.StatusCreated, registerPasskey(t, router, webauthntest.New(passkeyOrigin), signedIn, "Phone").Code)
+
+	// The handle is random, not the user's ID, and the user's passkeys share it
+	var passkeys []models.Passkey
+	require.NoError(t, db.DB.Where("user_id = ?", user.ID).Order("id").Find(&passkeys).Error)
+	require.Len(t, passkeys, 2)
+	handle, err := webauthn.DecodeID(passkeys[0].UserHandle)
+	require.NoError(t, err)
+	assert.Len(t, handle, 32)
+	assert.NotEqual(t, strconv.FormatUint(uint64(user.ID), 10), string(handle))
+	assert.Equal(t, passkeys[0].UserHandle, passkeys[1].UserHandle)
+
+	// Passkeys added when the handle was the user's ID still sign in
+	legacy := webauthntest.New(passkeyOrigin)
+	w := sendJSON(router, "/owner/profile/passkeys/options", nil, signedIn)
+	var options webauthn.CreationOptions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+	registration, err := legacy.Register(options.RP.ID, options.Challenge, []byte(strconv.FormatUint(uint64(user.ID), 10)))
+	require.NoError(t, err)
+	w = sendJSON(router, "/owner/profile/passkeys", map[string]string{
+		"id":                registration.ID,
+		"clientDataJSON":    registration.ClientDataJSON,
+		"attestationObject": registration.AttestationObject,
+	}, w)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, passkeyLogin(t, router, legacy).Code, "The handle has to match the one stored")
+	require.NoError(t, db.DB.Model(&models.Passkey{}).Where("credential_id = ?", registration.ID).Update("user_handle", "").Error)
+	assert.Equal(t, http.StatusOK, passkeyLogin(t, router, legacy).Code)
+}
+
+func TestPasskeysNeedOriginInProduction(t *testing.T) {
+	_, user, router := setupPasskeyTest(t)
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("WEBAUTHN_ORIGIN", "")
+
+	// The request's Host isn't trusted to name the site
+	assert.Equal(t, http.StatusServiceUnavailable, sendJSON(router, "/login/passkey/options", nil, nil).Code)
+	signedIn := postLogin(router, user.Email, "Password123!")
+	assert.Equal(t, http.StatusServiceUnavailable, sendJSON(router, "/owner/profile/passkeys/options", nil, signedIn).Code)
+
+	t.Setenv("WEBAUTHN_ORIGIN", passkeyOrigin)
+	assert.Equal(t, http.StatusCreated, registerPasskey(t, router, webauthntest.New(passkeyOrigin), signedIn, "Laptop").Code)
+}
+
+func TestPasskeyLoginSkipsTwoFactor(t *testing.T) {
+	db, user, router := setupPasskeyTest(t)
+	authenticator := webauthntest.New(passkeyOrigin)
+
+	signedIn := postLogin(router, user.Email, "Password123!")
+	require.Equal(t, http.StatusCreated, registerPasskey(t, router, authenticator, signedIn, "Phone").Code)
+
+	secret, _ := totp.GenerateSecret()
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	_, err := database.EnableTwoFactor(db.DB, user, secret, code)
+	require.NoError(t, err)
+
+	// The authenticator verified the user, so no code is asked for
+	assert.Equal(t, "/login/two-factor", postLogin(router, user.Email, "Password123!").Header().Get("Location"))
+	w := passkeyLogin(t, router, authenticator)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, sendForm(router, "GET", "/owner/profile/passkeys", nil, w).Code)
+}
+
+func TestPasskeyLoginRefused(t *testing.T) {
+	db, user, router := setupPasskeyTest(t)
+	authenticator := webauthntest.New(passkeyOrigin)
+	signedIn := postLogin(router, user.Email, "Password123!")
+	require.Equal(t, http.StatusCreated, registerPasskey(t, router, authenticator, signedIn, "Phone").Code)
+
+	// A signature made for a page on another site isn't accepted
+	authenticator.Origin = "https://phishing.example"
+	assert.Equal(t, http.StatusUnauthorized, passkeyLogin(t, router, authenticator).Code)
+	authenticator.Origin = passkeyOrigin
+
+	// Nor is a passkey the site doesn't know
+	stranger := webauthntest.New(passkeyOrigin)
+	_, err := stranger.Register("example.com", "challenge", []byte("1"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, passkeyLogin(t, router, stranger).Code)
+
+	// The passkey already added is excluded, so it isn't added twice
+	w := sendJSON(router, "/owner/profile/passkeys/options", nil, signedIn)
+	var options webauthn.CreationOptions
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &options))
+	assert.Len(t, options.ExcludeCredentials, 1)
+
+	// A locked account can't sign in with a passkey either
+	user.LoginAttempts = database.CurrentLockoutPolicy().Threshold
+	user.LastLoginAttempt = time.Now()
+	require.NoError(t, db.DB.Save(user).Error)
+	w = passkeyLogin(t, router, authenticator)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "locked")
+
+	// Nor can one that isn't verified
+	require.NoError(t, db.DB.Model(user).Updates(map[string]interface{}{"login_attempts": 0, "verified": false}).Error)
+	assert.Equal(t, http.StatusForbidden, passkeyLogin(t, router, authenticator).Code)
+
+	// A bad request doesn't get far
+	assert.Equal(t, http.StatusBadRequest, sendJSON(router, "/login/passkey", url.Values{}, nil).Code)
+}
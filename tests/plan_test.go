@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/database/seed"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/attachments"
+	"github.com/hail2skins/armory/internal/services/stripe"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripego "github.com/stripe/stripe-go/v72"
+)
+
+// seedTestPlans stores the default plans in a test database and loads them,
+// going back to the defaults when the test ends
+func seedTestPlans(t *testing.T, db *testutils.TestDB) {
+	seed.SeedPlans(db.DB)
+	require.NoError(t, models.LoadPlanCatalog(db.DB))
+	t.Cleanup(func() { models.LoadPlanCatalog(nil) })
+}
+
+// planForm is the admin form for a plan, with every field filled in from it
+func planForm(plan models.Plan) url.Values {
+	form := url.Values{
+		"tier":             {plan.Tier},
+		"name":             {plan.Name},
+		"description":      {plan.Description},
+		"features":         {plan.Features},
+		"price":            {fmt.Sprintf("%d.%02d", plan.Price/100, plan.Price%100)},
+		"interval":         {plan.Interval},
+		"stripe_price_id":  {plan.StripePriceID},
+		"rank":             {fmt.Sprint(plan.Rank)},
+		"max_guns":         {fmt.Sprint(plan.MaxGuns)},
+		"max_ammo":         {fmt.Sprint(plan.MaxAmmo)},
+		"storage_quota_mb": {fmt.Sprint(plan.StorageQuota >> 20)},
+	}
+	if plan.Active {
+		form.Set("active", "on")
+	}
+	return form
+}
+
+func TestAdminPlanChangesFreeTierLimits(t *testing.T) {
+	db, testUser, router := setupImportTest(t, "free")
+	seedTestPlans(t, db)
+	plans := controller.NewAdminPlanController(testutils.NewTestService(db.DB))
+	router.GET("/admin/plans", plans.Index)
+	router.GET("/admin/plans/:id/edit", plans.Edit)
+	router.POST("/admin/plans/:id", plans.Update)
+	require.NoError(t, db.DB.Create(&models.Gun{Name: "Already Owned", OwnerID: testUser.ID}).Error)
+
+	rr := sendForm(router, "GET", "/admin/plans", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Liking It")
+	assert.Contains(t, rr.Body.String(), "premium_lifetime")
+
+	var free models.Plan
+	require.NoError(t, db.DB.Where("tier = ?", models.FreeTier).First(&free).Error)
+	rr = sendForm(router, "GET", fmt.Sprintf("/admin/plans/%d/edit", free.ID), nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `value="Free"`)
+
+	// The free tier can't be given a price
+	form := planForm(free)
+	form.Set("price", "2.50")
+	form.Set("interval", models.PlanIntervalMonth)
+	rr = sendForm(router, "POST", fmt.Sprintf("/admin/plans/%d", free.ID), form, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "the free tier can&#39;t have a price")
+
+	// Raising the free tier's limit lets owners import more
+	free.Name = "Starter"
+	free.MaxGuns = 3
+	rr = sendForm(router, "POST", fmt.Sprintf("/admin/plans/%d", free.ID), planForm(free), nil)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Contains(t, rr.Header().Get("Location"), "success=")
+
+	rr = uploadImport(t, router, "guns", importGunsCSV+"Import Test Arms,Spare,ITM,Import Test Rifle,SN-3,,\n")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Starter tier only allows 3 guns, so you can import 2 more.")
+}
+
+func TestAdminPlanCheckout(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_fake")
+
+	// A fake Stripe API that records the checkout session it's asked for
+	var checkout url.Values
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/checkout/sessions":
+			body, _ := io.ReadAll(r.Body)
+			checkout, _ = url.ParseQuery(string(body))
+			w.Write([]byte(`{"id":"cs_1","object":"checkout.session","url":"https://checkout.stripe.test/cs_1"}`))
+		case "/v1/subscriptions/sub_family":
+			w.Write([]byte(`{"id":"sub_family","object":"subscription","status":"active","current_period_end":1900000000}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"not found"}}`))
+		}
+	}))
+	t.Cleanup(api.Close)
+	stripego.SetBackend(stripego.APIBackend, stripego.GetBackendWithConfig(stripego.APIBackend, &stripego.BackendConfig{
+		URL:               stripego.String(api.URL),
+		MaxNetworkRetries: stripego.Int64(0),
+	}))
+	t.Cleanup(func() { stripego.SetBackend(stripego.APIBackend, nil) })
+
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	seedTestPlans(t, db)
+	service := testutils.NewTestService(db.DB)
+	user := &database.User{Email: "family@example.com", Password: "Password123!", Verified: true,
+		StripeCustomerID: "cus_family", SubscriptionTier: "monthly", SubscriptionStatus: "active"}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	plans := controller.NewAdminPlanController(service)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/admin/plans/new", plans.New)
+	router.POST("/admin/plans", plans.Create)
+	router.POST("/webhook", controller.NewPaymentController(service).HandleWebhook)
+
+	rr := sendForm(router, "GET", "/admin/plans/new", nil, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	family := models.Plan{Tier: "Family Plan", Name: "Family", Price: 900, Interval: models.PlanIntervalMonth,
+		StripePriceID: "price_family", Rank: 15, StorageQuota: 3 << 30, Active: true}
+	rr = sendForm(router, "POST", "/admin/plans", planForm(family), nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), "plan tier must be lowercase letters")
+
+	family.Tier = "family"
+	rr = sendForm(router, "POST", "/admin/plans", planForm(family), nil)
+	require.Equal(t, http.StatusSeeOther, rr.Code)
+
+	// The new plan ranks above monthly, so a monthly subscriber can move to it
+	assert.True(t, user.CanSubscribeToTier("family"))
+	assert.False(t, user.CanSubscribeToTier("free_trial"))
+
+	// Checkout uses the plan's Stripe price
+	session, err := stripe.NewService(service).CreateCheckoutSession(user, "family")
+	require.NoError(t, err)
+	assert.Equal(t, "cs_1", session.ID)
+	assert.Equal(t, "price_family", checkout.Get("line_items[0][price]"))
+	assert.Equal(t, "subscription", checkout.Get("mode"))
+	assert.Equal(t, "family", checkout.Get("metadata[tier]"))
+
+	// And the completed checkout puts the user on it
+	payload := fmt.Sprintf(`{"id":"evt_family","object":"event","type":"checkout.session.completed","data":{"object":`+
+		`{"id":"cs_1","object":"checkout.session","payment_status":"paid","client_reference_id":"%d",`+
+		`"customer":"cus_family","subscription":"sub_family","metadata":{"tier":"family"}}}}`, user.ID)
+	require.Equal(t, http.StatusOK, deliverWebhook(router, payload).Code)
+	var updated database.User
+	require.NoError(t, db.DB.First(&updated, user.ID).Error)
+	assert.Equal(t, "family", updated.SubscriptionTier)
+	assert.Equal(t, "sub_family", updated.StripeSubscriptionID)
+	assert.Equal(t, int64(3<<30), attachments.QuotaForTier("family"))
+}
+
+func TestAdminPlanPriceChangeClearsStripePrice(t *testing.T) {
+	db, _, router := setupImportTest(t, "free")
+	seedTestPlans(t, db)
+	plans := controller.NewAdminPlanController(testutils.NewTestService(db.DB))
+	router.POST("/admin/plans/:id", plans.Update)
+
+	var monthly models.Plan
+	require.NoError(t, db.DB.Where("tier = ?", "monthly").First(&monthly).Error)
+	require.NoError(t, db.DB.Model(&monthly).Update("stripe_price_id", "price_old").Error)
+	path := fmt.Sprintf("/admin/plans/%d", monthly.ID)
+	saved := func() models.Plan {
+		var plan models.Plan
+		require.NoError(t, db.DB.First(&plan, monthly.ID).Error)
+		return plan
+	}
+
+	// Other changes keep the Stripe price
+	monthly.Name = "Monthly"
+	require.Equal(t, http.StatusSeeOther, sendForm(router, "POST", path, planForm(monthly), nil).Code)
+	assert.Equal(t, "price_old", saved().StripePriceID)
+
+	// A new amount can't be billed at the old price, so it's dropped
+	monthly.Price = 700
+	require.Equal(t, http.StatusSeeOther, sendForm(router, "POST", path, planForm(monthly), nil).Code)
+	plan := saved()
+	assert.Equal(t, int64(700), plan.Price)
+	assert.Empty(t, plan.StripePriceID)
+	plan, ok := models.LookupPlan("monthly")
+	require.True(t, ok)
+	assert.Empty(t, plan.StripePriceID)
+
+	// Unless a new price is given with the change
+	monthly.Price = 800
+	monthly.StripePriceID = "price_new"
+	require.Equal(t, http.StatusSeeOther, sendForm(router, "POST", path, planForm(monthly), nil).Code)
+	assert.Equal(t, "price_new", saved().StripePriceID)
+}
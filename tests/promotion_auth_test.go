@@ -118,6 +118,21 @@ func (m *mockEmailService) SendPasswordResetEmail(email, token, baseURL string)
 	return nil
 }
 
+// SendAccountLockedEmail is a no-op implementation for testing
+func (m *mockEmailService) SendAccountLockedEmail(email, token, baseURL string) error {
+	return nil
+}
+
+// SendDataExportEmail is a no-op implementation for testing
+func (m *mockEmailService) SendDataExportEmail(email, downloadURL string, expiresAt time.Time) error {
+	return nil
+}
+
+// SendPaymentReminderEmail is a no-op implementation for testing
+func (m *mockEmailService) SendPaymentReminderEmail(email, status string, graceEndsAt time.Time, billingURL string) error {
+	return nil
+}
+
 // SendContactFormEmail is a no-op implementation for testing
 func (m *mockEmailService) SendContactFormEmail(name, email, subject, message string) error {
 	return nil
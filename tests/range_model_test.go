@@ -2,6 +2,7 @@ package tests
 
 import (
 	"testing"
+	"time"
 
 	"github.com/hail2skins/armory/internal/models"
 	"github.com/stretchr/testify/suite"
@@ -19,7 +20,7 @@ func (s *RangeModelTestSuite) SetupTest() {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	s.Require().NoError(err)
 
-	err = db.AutoMigrate(&models.Range{})
+	err = db.AutoMigrate(&models.Range{}, &models.RangeDay{}, &models.RangeDayItem{})
 	s.Require().NoError(err)
 
 	s.DB = db
@@ -108,6 +109,109 @@ func (s *RangeModelTestSuite) TestDeleteRange_WithMissingID_ReturnsError() {
 	s.Error(err)
 }
 
+func (s *RangeModelTestSuite) TestFindRangesForUser_ReturnsPublicAndOwnRanges() {
+	public := &models.Range{RangeName: "Public Range", City: "Austin", State: "TX"}
+	mine := &models.Range{RangeName: "My Berm", City: "Waco", State: "TX", OwnerID: 1}
+	theirs := &models.Range{RangeName: "Their Berm", City: "Waco", State: "TX", OwnerID: 2}
+	for _, r := range []*models.Range{public, mine, theirs} {
+		s.Require().NoError(models.CreateRange(s.DB, r))
+	}
+
+	ranges, err := models.FindRangesForUser(s.DB, 1)
+	s.Require().NoError(err)
+	s.Require().Len(ranges, 2)
+	s.Equal("My Berm", ranges[0].RangeName)
+	s.Equal("Public Range", ranges[1].RangeName)
+
+	publicRanges, err := models.FindPublicRanges(s.DB)
+	s.Require().NoError(err)
+	s.Require().Len(publicRanges, 1)
+	s.True(publicRanges[0].IsPublic())
+
+	_, err = models.FindRangeForUser(s.DB, theirs.ID, 1)
+	s.Error(err, "Another owner's private range is hidden")
+	found, err := models.FindRangeForUser(s.DB, mine.ID, 1)
+	s.Require().NoError(err)
+	s.Equal(mine.ID, found.ID)
+}
+
+func (s *RangeModelTestSuite) TestValidate_WithInvalidData_ReturnsErrors() {
+	s.Require().NoError(models.CreateRange(s.DB, &models.Range{RangeName: "Public Range", City: "Austin", State: "TX"}))
+
+	r := models.Range{OwnerID: 1, City: "Austin", State: "TX"}
+	s.Equal(models.ErrRangeNameRequired, r.Validate(s.DB))
+
+	r.RangeName = "public range"
+	s.Equal(models.ErrDuplicateRange, r.Validate(s.DB), "A private range can't shadow a public one")
+
+	r.RangeName = "Private Range"
+	r.State = "Texas"
+	s.Equal(models.ErrInvalidRangeState, r.Validate(s.DB))
+
+	r.State = "TX"
+	r.Zip = "787"
+	s.Equal(models.ErrInvalidRangeZip, r.Validate(s.DB))
+
+	r.Zip = "78701"
+	latitude := 30.27
+	r.Latitude = &latitude
+	s.Equal(models.ErrInvalidRangeCoordinates, r.Validate(s.DB))
+
+	longitude := -197.74
+	r.Longitude = &longitude
+	s.Equal(models.ErrInvalidRangeCoordinates, r.Validate(s.DB))
+
+	longitude = -97.74
+	s.NoError(r.Validate(s.DB))
+}
+
+func (s *RangeModelTestSuite) TestDeletePrivateRange_ChecksOwnerAndUse() {
+	public := &models.Range{RangeName: "Public Range"}
+	mine := &models.Range{RangeName: "My Berm", OwnerID: 1}
+	s.Require().NoError(models.CreateRange(s.DB, public))
+	s.Require().NoError(models.CreateRange(s.DB, mine))
+
+	s.Equal(models.ErrRangeNotOwned, models.DeletePrivateRange(s.DB, public.ID, 1))
+	s.Equal(models.ErrRangeNotOwned, models.DeletePrivateRange(s.DB, mine.ID, 2))
+
+	rangeDay := &models.RangeDay{UserID: 1, RangeID: mine.ID, Date: time.Now(), Items: []models.RangeDayItem{{ShotsFired: 10}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, rangeDay))
+	s.Equal(models.ErrRangeInUse, models.DeletePrivateRange(s.DB, mine.ID, 1))
+
+	s.Require().NoError(models.DeleteRangeDay(s.DB, rangeDay.ID, 1))
+	s.NoError(models.DeletePrivateRange(s.DB, mine.ID, 1))
+}
+
+func (s *RangeModelTestSuite) TestFindRangeHistoryByUser_GroupsRangeDaysByLocation() {
+	austin := &models.Range{RangeName: "Austin Range", City: "Austin", State: "TX"}
+	boise := &models.Range{RangeName: "Boise Range", City: "Boise", State: "ID"}
+	s.Require().NoError(models.CreateRange(s.DB, austin))
+	s.Require().NoError(models.CreateRange(s.DB, boise))
+
+	shoot := func(userID uint, r *models.Range, daysAgo int, rounds int) {
+		rangeDay := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -daysAgo), Items: []models.RangeDayItem{{ShotsFired: rounds}}}
+		s.Require().NoError(models.CreateRangeDay(s.DB, rangeDay))
+	}
+	shoot(1, austin, 10, 100)
+	shoot(1, austin, 2, 50)
+	shoot(1, boise, 5, 75)
+	shoot(2, boise, 1, 500)
+
+	history, err := models.FindRangeHistoryByUser(s.DB, 1)
+	s.Require().NoError(err)
+	s.Require().Len(history, 2)
+
+	// Ordered by state, so Idaho comes before Texas
+	s.Equal("Boise Range", history[0].Range.RangeName)
+	s.Equal(1, history[0].Visits)
+	s.Equal(75, history[0].Rounds)
+
+	s.Equal("Austin Range", history[1].Range.RangeName)
+	s.Equal(2, history[1].Visits)
+	s.Equal(150, history[1].Rounds)
+	s.True(history[1].FirstVisit.Before(history[1].LastVisit))
+}
+
 func TestRangeModelSuite(t *testing.T) {
 	suite.Run(t, new(RangeModelTestSuite))
 }
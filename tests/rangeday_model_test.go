@@ -32,6 +32,7 @@ func (s *RangeDayModelTestSuite) SetupTest() {
 		&models.Casing{},
 		&models.Ammo{},
 		&models.RangeDay{},
+		&models.RangeDayItem{},
 	)
 	s.Require().NoError(err)
 
@@ -87,9 +88,9 @@ func (s *RangeDayModelTestSuite) TestFindRangeDaysByUser_WithExistingRecords_Ret
 	userID := uint(1001)
 	r, gun, ammo := s.seedRangeDayDependencies(userID)
 
-	rd1 := &models.RangeDay{UserID: userID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Session 1", ShotsFired: 100}
-	rd2 := &models.RangeDay{UserID: userID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Session 2", ShotsFired: 120}
-	other := &models.RangeDay{UserID: 2002, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Other user", ShotsFired: 50}
+	rd1 := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Comments: "Session 1", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 100}}}
+	rd2 := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Comments: "Session 2", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 120}}}
+	other := &models.RangeDay{UserID: 2002, RangeID: r.ID, Date: time.Now(), Comments: "Other user", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 50}}}
 
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd1))
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd2))
@@ -101,8 +102,9 @@ func (s *RangeDayModelTestSuite) TestFindRangeDaysByUser_WithExistingRecords_Ret
 	for _, rd := range rangeDays {
 		s.Equal(userID, rd.UserID)
 		s.NotZero(rd.Range.ID)
-		s.NotZero(rd.Gun.ID)
-		s.NotZero(rd.Ammo.ID)
+		s.Require().Len(rd.Items, 1)
+		s.NotZero(rd.Items[0].Gun.ID)
+		s.NotZero(rd.Items[0].Ammo.ID)
 	}
 }
 
@@ -110,7 +112,7 @@ func (s *RangeDayModelTestSuite) TestFindRangeDayByID_WithMatchingUser_ReturnsRe
 	userID := uint(3003)
 	r, gun, ammo := s.seedRangeDayDependencies(userID)
 
-	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Good practice", ShotsFired: 140}
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Comments: "Good practice", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 140}}}
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
 
 	found, err := models.FindRangeDayByID(s.DB, rd.ID, userID)
@@ -118,15 +120,16 @@ func (s *RangeDayModelTestSuite) TestFindRangeDayByID_WithMatchingUser_ReturnsRe
 	s.Equal(rd.ID, found.ID)
 	s.Equal("Good practice", found.Comments)
 	s.Equal(r.ID, found.Range.ID)
-	s.Equal(gun.ID, found.Gun.ID)
-	s.Equal(ammo.ID, found.Ammo.ID)
+	s.Require().Len(found.Items, 1)
+	s.Equal(gun.ID, found.Items[0].Gun.ID)
+	s.Equal(ammo.ID, found.Items[0].Ammo.ID)
 }
 
 func (s *RangeDayModelTestSuite) TestFindRangeDayByID_WithWrongUser_ReturnsError() {
 	ownerID := uint(4004)
 	r, gun, ammo := s.seedRangeDayDependencies(ownerID)
 
-	rd := &models.RangeDay{UserID: ownerID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Private record", ShotsFired: 80}
+	rd := &models.RangeDay{UserID: ownerID, RangeID: r.ID, Date: time.Now(), Comments: "Private record", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 80}}}
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
 
 	found, err := models.FindRangeDayByID(s.DB, rd.ID, 9999)
@@ -138,7 +141,7 @@ func (s *RangeDayModelTestSuite) TestCreateRangeDay_WithValidData_PersistsRecord
 	userID := uint(5005)
 	r, gun, ammo := s.seedRangeDayDependencies(userID)
 
-	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Initial session", ShotsFired: 90}
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Comments: "Initial session", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 90}}}
 	err := models.CreateRangeDay(s.DB, rd)
 	s.Require().NoError(err)
 	s.NotZero(rd.ID)
@@ -146,18 +149,18 @@ func (s *RangeDayModelTestSuite) TestCreateRangeDay_WithValidData_PersistsRecord
 	stored, err := models.FindRangeDayByID(s.DB, rd.ID, userID)
 	s.Require().NoError(err)
 	s.Equal("Initial session", stored.Comments)
-	s.Equal(90, stored.ShotsFired)
+	s.Equal(90, stored.TotalShots())
 }
 
 func (s *RangeDayModelTestSuite) TestUpdateRangeDay_WithExistingRecord_UpdatesFields() {
 	userID := uint(6006)
 	r, gun, ammo := s.seedRangeDayDependencies(userID)
 
-	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Before update", ShotsFired: 60}
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Comments: "Before update", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 60}}}
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
 
 	rd.Comments = "After update"
-	rd.ShotsFired = 200
+	rd.Items[0].ShotsFired = 200
 	rd.Date = time.Now().Add(24 * time.Hour)
 
 	err := models.UpdateRangeDay(s.DB, rd)
@@ -166,14 +169,14 @@ func (s *RangeDayModelTestSuite) TestUpdateRangeDay_WithExistingRecord_UpdatesFi
 	updated, err := models.FindRangeDayByID(s.DB, rd.ID, userID)
 	s.Require().NoError(err)
 	s.Equal("After update", updated.Comments)
-	s.Equal(200, updated.ShotsFired)
+	s.Equal(200, updated.TotalShots())
 }
 
 func (s *RangeDayModelTestSuite) TestDeleteRangeDay_WithOwnerUser_DeletesRecord() {
 	userID := uint(7007)
 	r, gun, ammo := s.seedRangeDayDependencies(userID)
 
-	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "To delete", ShotsFired: 110}
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Comments: "To delete", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 110}}}
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
 
 	err := models.DeleteRangeDay(s.DB, rd.ID, userID)
@@ -187,7 +190,7 @@ func (s *RangeDayModelTestSuite) TestDeleteRangeDay_WithNonOwnerUser_ReturnsAuth
 	ownerID := uint(8008)
 	r, gun, ammo := s.seedRangeDayDependencies(ownerID)
 
-	rd := &models.RangeDay{UserID: ownerID, RangeID: r.ID, GunID: gun.ID, AmmoID: ammo.ID, Date: time.Now(), Comments: "Protected", ShotsFired: 75}
+	rd := &models.RangeDay{UserID: ownerID, RangeID: r.ID, Date: time.Now(), Comments: "Protected", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 75}}}
 	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
 
 	err := models.DeleteRangeDay(s.DB, rd.ID, 1234)
@@ -195,6 +198,208 @@ func (s *RangeDayModelTestSuite) TestDeleteRangeDay_WithNonOwnerUser_ReturnsAuth
 	s.Contains(err.Error(), "not authorized")
 }
 
+func (s *RangeDayModelTestSuite) TestFindRangeDaysByUser_OrdersByDateDescending() {
+	userID := uint(9009)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+
+	older := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -7), Comments: "Older", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID}}}
+	newer := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -1), Comments: "Newer", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, older))
+	s.Require().NoError(models.CreateRangeDay(s.DB, newer))
+
+	rangeDays, err := models.FindRangeDaysByUser(s.DB, userID)
+	s.Require().NoError(err)
+	s.Require().Len(rangeDays, 2)
+	s.Equal("Newer", rangeDays[0].Comments)
+	s.Equal("Older", rangeDays[1].Comments)
+}
+
+func (s *RangeDayModelTestSuite) TestFindRangeDaysByUserOnDate_ReturnsOnlyThatDay() {
+	userID := uint(1010)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+
+	day := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	onDay := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: day.Add(15 * time.Hour), Comments: "On day", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID}}}
+	nextDay := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: day.AddDate(0, 0, 1), Comments: "Next day", Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, onDay))
+	s.Require().NoError(models.CreateRangeDay(s.DB, nextDay))
+
+	rangeDays, err := models.FindRangeDaysByUserOnDate(s.DB, userID, day)
+	s.Require().NoError(err)
+	s.Require().Len(rangeDays, 1)
+	s.Equal("On day", rangeDays[0].Comments)
+}
+
+func (s *RangeDayModelTestSuite) TestValidate_WithInvalidData_ReturnsErrors() {
+	userID := uint(1111)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+	valid := func() *models.RangeDay {
+		return &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now().AddDate(0, 0, -1), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID}}}
+	}
+
+	s.NoError(valid().Validate(s.DB))
+
+	rd := valid()
+	rd.Date = time.Time{}
+	s.ErrorIs(rd.Validate(s.DB), models.ErrRangeDayDateRequired)
+
+	rd = valid()
+	rd.Date = time.Now().AddDate(0, 0, 2)
+	s.ErrorIs(rd.Validate(s.DB), models.ErrRangeDayFutureDate)
+
+	rd = valid()
+	rd.Items[0].ShotsFired = -1
+	s.ErrorIs(rd.Validate(s.DB), models.ErrRangeDayNegativeShots)
+
+	rd = valid()
+	rd.Items = nil
+	s.ErrorIs(rd.Validate(s.DB), models.ErrRangeDayItemsRequired)
+
+	rd = valid()
+	rd.RangeID = 9999
+	s.ErrorIs(rd.Validate(s.DB), models.ErrInvalidRange)
+
+	// Another owner's gun and ammo are rejected
+	rd = valid()
+	rd.UserID = 2222
+	s.ErrorIs(rd.Validate(s.DB), models.ErrInvalidRangeDayGun)
+
+	// Every line item is checked, not just the first
+	rd = valid()
+	rd.Items = append(rd.Items, models.RangeDayItem{GunID: gun.ID, AmmoID: 9999})
+	s.ErrorIs(rd.Validate(s.DB), models.ErrInvalidRangeDayAmmo)
+}
+
+func (s *RangeDayModelTestSuite) expendedFor(ammoID uint) int {
+	var ammo models.Ammo
+	s.Require().NoError(s.DB.First(&ammo, ammoID).Error)
+	return ammo.Expended
+}
+
+func (s *RangeDayModelTestSuite) TestCreateRangeDay_IncrementsAmmoExpended() {
+	userID := uint(1212)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 120}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
+
+	s.Equal(120, s.expendedFor(ammo.ID))
+}
+
+func (s *RangeDayModelTestSuite) TestCreateRangeDay_ExceedingAmmoCount_RollsBack() {
+	userID := uint(1313)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 501}}}
+	err := models.CreateRangeDay(s.DB, rd)
+	s.ErrorIs(err, models.ErrRangeDayExceedsAmmo)
+
+	var count int64
+	s.DB.Model(&models.RangeDay{}).Where("user_id = ?", userID).Count(&count)
+	s.Equal(int64(0), count)
+	s.Equal(0, s.expendedFor(ammo.ID))
+}
+
+func (s *RangeDayModelTestSuite) TestUpdateRangeDay_AdjustsAmmoExpended() {
+	userID := uint(1414)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 100}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
+
+	rd.Items[0].ShotsFired = 40
+	s.Require().NoError(models.UpdateRangeDay(s.DB, rd))
+	s.Equal(40, s.expendedFor(ammo.ID))
+
+	// Moving the range day to another lot returns the rounds to the first one
+	other := models.Ammo{Name: "Second lot", BrandID: ammo.BrandID, CaliberID: ammo.CaliberID, OwnerID: userID, Count: 50}
+	s.Require().NoError(s.DB.Create(&other).Error)
+	rd.Items[0].AmmoID = other.ID
+	s.Require().NoError(models.UpdateRangeDay(s.DB, rd))
+	s.Equal(0, s.expendedFor(ammo.ID))
+	s.Equal(40, s.expendedFor(other.ID))
+
+	// Overspending the lot is rejected and nothing changes
+	rd.Items[0].ShotsFired = 51
+	s.ErrorIs(models.UpdateRangeDay(s.DB, rd), models.ErrRangeDayExceedsAmmo)
+	s.Equal(40, s.expendedFor(other.ID))
+	stored, err := models.FindRangeDayByID(s.DB, rd.ID, userID)
+	s.Require().NoError(err)
+	s.Equal(40, stored.TotalShots())
+}
+
+func (s *RangeDayModelTestSuite) TestDeleteRangeDay_ReturnsAmmoExpended() {
+	userID := uint(1515)
+	r, gun, ammo := s.seedRangeDayDependencies(userID)
+
+	rd := &models.RangeDay{UserID: userID, RangeID: r.ID, Date: time.Now(), Items: []models.RangeDayItem{{GunID: gun.ID, AmmoID: ammo.ID, ShotsFired: 75}}}
+	s.Require().NoError(models.CreateRangeDay(s.DB, rd))
+	s.Require().NoError(models.DeleteRangeDay(s.DB, rd.ID, userID))
+
+	s.Equal(0, s.expendedFor(ammo.ID))
+}
+
+// legacyRangeDay is the range day schema from before line items
+type legacyRangeDay struct {
+	gorm.Model
+	UserID     uint
+	RangeID    uint
+	Date       time.Time
+	Comments   string
+	ShotsFired int
+	GunID      uint
+	AmmoID     uint
+}
+
+func (legacyRangeDay) TableName() string {
+	return "range_days"
+}
+
+func (s *RangeDayModelTestSuite) TestMigrateRangeDayItems_MovesSingleGunRangeDays() {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	s.Require().NoError(err)
+
+	// Range days as they were stored before line items
+	s.Require().NoError(db.AutoMigrate(&legacyRangeDay{}))
+	now := time.Now()
+	s.Require().NoError(db.Create(&[]legacyRangeDay{
+		{UserID: 1, RangeID: 1, Date: now, Comments: "Plinking", ShotsFired: 120, GunID: 7, AmmoID: 9},
+		{UserID: 1, RangeID: 1, Date: now, Comments: "No rounds", GunID: 8, AmmoID: 9},
+		{UserID: 1, RangeID: 1, Date: now, Comments: "Deleted", ShotsFired: 30, GunID: 7, AmmoID: 9},
+	}).Error)
+	s.Require().NoError(db.Delete(&legacyRangeDay{}, 3).Error)
+
+	s.Require().NoError(db.AutoMigrate(&models.RangeDay{}, &models.RangeDayItem{}))
+	s.Require().NoError(models.MigrateRangeDayItems(db))
+
+	var items []models.RangeDayItem
+	s.Require().NoError(db.Unscoped().Order("range_day_id ASC").Find(&items).Error)
+	s.Require().Len(items, 3)
+	s.Equal(uint(7), items[0].GunID)
+	s.Equal(uint(9), items[0].AmmoID)
+	s.Equal(120, items[0].ShotsFired)
+	s.Equal(uint(8), items[1].GunID)
+	s.Equal(0, items[1].ShotsFired)
+	s.True(items[2].DeletedAt.Valid, "Items of deleted range days stay deleted")
+
+	for _, column := range []string{"gun_id", "ammo_id", "shots_fired"} {
+		s.False(db.Migrator().HasColumn(&models.RangeDay{}, column), column)
+	}
+
+	// The range days themselves are untouched
+	var rangeDays []models.RangeDay
+	s.Require().NoError(db.Preload("Items").Order("id ASC").Find(&rangeDays).Error)
+	s.Require().Len(rangeDays, 2)
+	s.Equal("Plinking", rangeDays[0].Comments)
+	s.Equal(120, rangeDays[0].TotalShots())
+
+	// Running it again does nothing
+	s.Require().NoError(models.MigrateRangeDayItems(db))
+	var count int64
+	db.Unscoped().Model(&models.RangeDayItem{}).Count(&count)
+	s.Equal(int64(3), count)
+}
+
 func TestRangeDayModelSuite(t *testing.T) {
 	suite.Run(t, new(RangeDayModelTestSuite))
 }
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSessionTest returns a router keeping sessions in the database, with the
+// sign in, password reset, and signed in devices pages, and a verified user
+func setupSessionTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	user := &database.User{Email: "sessions@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	authController := controller.NewAuthController(service)
+	ownerController := controller.NewOwnerController(service)
+
+	router := gin.New()
+	router.Use(middleware.NewSessionStore(db.DB).Sessions("armory-session"))
+	router.Use(func(c *gin.Context) {
+		c.Set("authController", authController)
+	})
+	router.POST("/login", authController.LoginHandler)
+	router.POST("/reset-password", authController.ResetPasswordHandler)
+	router.GET("/owner/profile/sessions", ownerController.Sessions)
+	router.POST("/owner/profile/sessions/revoke-others", ownerController.SessionsRevokeOthers)
+	router.POST("/owner/profile/sessions/:id/revoke", ownerController.SessionRevoke)
+
+	return db, user, router
+}
+
+// userSessions returns the user's stored sessions, oldest first
+func userSessions(t *testing.T, db *testutils.TestDB, userID uint) []models.Session {
+	var stored []models.Session
+	require.NoError(t, db.DB.Where("user_id = ?", userID).Order("id").Find(&stored).Error)
+	return stored
+}
+
+func TestSignedInDevices(t *testing.T) {
+	db, user, router := setupSessionTest(t)
+
+	phone := postLogin(router, user.Email, "Password123!")
+	tablet := postLogin(router, user.Email, "Password123!")
+	laptop := postLogin(router, user.Email, "Password123!")
+	stored := userSessions(t, db, user.ID)
+	require.Len(t, stored, 3)
+
+	w := sendForm(router, "GET", "/owner/profile/sessions", nil, laptop)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "This device")
+	assert.Contains(t, w.Body.String(), "Sign Out All Other Devices")
+
+	// Signing out another device ends its session
+	w = sendForm(router, "POST", fmt.Sprintf("/owner/profile/sessions/%d/revoke", stored[0].ID), url.Values{}, laptop)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	w = sendForm(router, "GET", "/owner/profile/sessions", nil, phone)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+	assert.Len(t, userSessions(t, db, user.ID), 2)
+
+	// The session in use can't be signed out from the list
+	w = sendForm(router, "POST", fmt.Sprintf("/owner/profile/sessions/%d/revoke", stored[2].ID), url.Values{}, laptop)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	w = sendForm(router, "GET", "/owner/profile/sessions", nil, w)
+	assert.Contains(t, w.Body.String(), "Log out to end the session you&#39;re using")
+
+	// Signing out everywhere else keeps only the session in use
+	w = sendForm(router, "POST", "/owner/profile/sessions/revoke-others", url.Values{}, w)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	remaining := userSessions(t, db, user.ID)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, stored[2].ID, remaining[0].ID)
+	w = sendForm(router, "GET", "/owner/profile/sessions", nil, tablet)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestPasswordResetRevokesSessions(t *testing.T) {
+	db, user, router := setupSessionTest(t)
+
+	postLogin(router, user.Email, "Password123!")
+	postLogin(router, user.Email, "Password123!")
+	require.Len(t, userSessions(t, db, user.ID), 2)
+
+	require.NoError(t, db.DB.Model(user).Updates(map[string]interface{}{
+		"recovery_token":        "reset-token",
+		"recovery_token_expiry": time.Now().Add(time.Hour),
+	}).Error)
+
+	form := url.Values{"token": {"reset-token"}, "password": {"NewPassword123!"}, "confirm_password": {"NewPassword123!"}}
+	w := sendForm(router, "POST", "/reset-password", form, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Empty(t, userSessions(t, db, user.ID))
+}
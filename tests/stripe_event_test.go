@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/services/stripe"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	stripego "github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// stripeAPIDown makes the fake Stripe API fail while it's set
+var stripeAPIDown atomic.Bool
+
+// setupStripeEventTest returns a router serving the webhook and the admin
+// Stripe event pages, with a customer and a fake Stripe API that knows their
+// monthly subscription
+func setupStripeEventTest(t *testing.T) (*testutils.TestDB, *database.User, *gin.Engine) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", testWebhookSecret)
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test_fake")
+	stripeAPIDown.Store(false)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if stripeAPIDown.Load() || r.URL.Path != "/v1/subscriptions/sub_1" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"type":"api_error","message":"Stripe is down"}}`))
+			return
+		}
+		fmt.Fprintf(w, `{"id":"sub_1","object":"subscription","status":"active","current_period_end":%d,`+
+			`"items":{"object":"list","data":[{"id":"si_1","object":"subscription_item",`+
+			`"price":{"id":"price_monthly","object":"price","unit_amount":500}}]}}`, time.Now().Add(30*24*time.Hour).Unix())
+	}))
+	t.Cleanup(api.Close)
+	stripego.SetBackend(stripego.APIBackend, stripego.GetBackendWithConfig(stripego.APIBackend, &stripego.BackendConfig{
+		URL:               stripego.String(api.URL),
+		MaxNetworkRetries: stripego.Int64(0),
+	}))
+	t.Cleanup(func() { stripego.SetBackend(stripego.APIBackend, nil) })
+
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	service := testutils.NewTestService(db.DB)
+
+	user := &database.User{Email: "customer@example.com", Password: "Password123!", Verified: true,
+		StripeCustomerID: "cus_1", SubscriptionTier: "free"}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	paymentController := controller.NewPaymentController(service)
+	adminController := controller.NewAdminStripeEventController(service, stripe.NewService(service))
+
+	router := gin.New()
+	router.POST("/webhook", paymentController.HandleWebhook)
+	router.GET("/admin/stripe-events", adminController.Index)
+	router.GET("/admin/stripe-events/:id", adminController.Show)
+	router.POST("/admin/stripe-events/:id/replay", adminController.Replay)
+
+	return db, user, router
+}
+
+// deliverWebhook sends a signed event to the webhook the way Stripe does
+func deliverWebhook(router *gin.Engine, payload string) *httptest.ResponseRecorder {
+	now := time.Now()
+	signature := hex.EncodeToString(webhook.ComputeSignature(now, []byte(payload), testWebhookSecret))
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewBufferString(payload))
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), signature))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// invoicePaidEvent is an invoice.payment_succeeded event for the test customer
+const invoicePaidEvent = `{"id":"evt_invoice_1","object":"event","type":"invoice.payment_succeeded","data":{"object":` +
+	`{"id":"in_1","object":"invoice","customer":"cus_1","subscription":"sub_1","amount_paid":500,"currency":"usd"}}}`
+
+func TestStripeWebhookRetriesRecordOnePayment(t *testing.T) {
+	db, user, router := setupStripeEventTest(t)
+
+	// The payment is recorded, but fetching the subscription fails
+	stripeAPIDown.Store(true)
+	w := deliverWebhook(router, invoicePaidEvent)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var event models.StripeEvent
+	require.NoError(t, db.DB.Where("event_id = ?", "evt_invoice_1").First(&event).Error)
+	assert.Equal(t, models.StripeEventFailed, event.Status)
+	assert.Equal(t, "invoice.payment_succeeded", event.Type)
+	assert.Equal(t, 1, event.Attempts)
+	assert.NotEmpty(t, event.Error)
+	assert.JSONEq(t, invoicePaidEvent, event.Payload)
+
+	// Stripe retries it, which doesn't record the payment again
+	w = deliverWebhook(router, invoicePaidEvent)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	payments, err := models.GetPaymentsByUserID(db.DB, user.ID)
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, "in_1", payments[0].StripeID)
+
+	// Once Stripe is back an admin replays it
+	stripeAPIDown.Store(false)
+	w = sendForm(router, "POST", fmt.Sprintf("/admin/stripe-events/%d/replay", event.ID), url.Values{}, nil)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Contains(t, w.Header().Get("Location"), "success=")
+	require.NoError(t, db.DB.First(&event, event.ID).Error)
+	assert.Equal(t, models.StripeEventProcessed, event.Status)
+	assert.Equal(t, 3, event.Attempts)
+	assert.Empty(t, event.Error)
+	assert.NotNil(t, event.ProcessedAt)
+
+	var updated database.User
+	require.NoError(t, db.DB.First(&updated, user.ID).Error)
+	assert.Equal(t, "monthly", updated.SubscriptionTier)
+	assert.Equal(t, "sub_1", updated.StripeSubscriptionID)
+
+	// Another delivery of a processed event is skipped
+	w = deliverWebhook(router, invoicePaidEvent)
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, db.DB.First(&event, event.ID).Error)
+	assert.Equal(t, 3, event.Attempts)
+	payments, _ = models.GetPaymentsByUserID(db.DB, user.ID)
+	assert.Len(t, payments, 1)
+
+	// And so is replaying it
+	w = sendForm(router, "POST", fmt.Sprintf("/admin/stripe-events/%d/replay", event.ID), url.Values{}, nil)
+	assert.Contains(t, w.Header().Get("Location"), "error=")
+}
+
+func TestStripeWebhookSkipsProcessedEvents(t *testing.T) {
+	db, user, router := setupStripeEventTest(t)
+	payload := `{"id":"evt_deleted_1","object":"event","type":"customer.subscription.deleted","data":{"object":` +
+		`{"id":"sub_1","object":"subscription","customer":"cus_1","status":"canceled"}}}`
+
+	require.Equal(t, http.StatusOK, deliverWebhook(router, payload).Code)
+	var updated database.User
+	require.NoError(t, db.DB.First(&updated, user.ID).Error)
+	assert.Equal(t, "canceled", updated.SubscriptionStatus)
+
+	// The customer subscribes again before Stripe retries the old event
+	require.NoError(t, db.DB.Model(&updated).Update("subscription_status", "active").Error)
+	require.Equal(t, http.StatusOK, deliverWebhook(router, payload).Code)
+	require.NoError(t, db.DB.First(&updated, user.ID).Error)
+	assert.Equal(t, "active", updated.SubscriptionStatus)
+
+	// A delivery while another is still processing the event is refused, so Stripe retries it
+	require.NoError(t, db.DB.Model(&models.StripeEvent{}).Where("event_id = ?", "evt_deleted_1").
+		Updates(map[string]interface{}{"status": models.StripeEventProcessing, "last_attempt_at": time.Now()}).Error)
+	assert.Equal(t, http.StatusConflict, deliverWebhook(router, payload).Code)
+
+	// Events that aren't signed by Stripe aren't kept
+	req, _ := http.NewRequest("POST", "/webhook", bytes.NewBufferString(`{"id":"evt_forged"}`))
+	req.Header.Set("Stripe-Signature", "t=1,v1=forged")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var count int64
+	db.DB.Model(&models.StripeEvent{}).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestAdminStripeEventPages(t *testing.T) {
+	db, _, router := setupStripeEventTest(t)
+
+	// An event for a customer no one here has fails
+	payload := `{"id":"evt_unknown_1","object":"event","type":"customer.subscription.deleted","data":{"object":` +
+		`{"id":"sub_9","object":"subscription","customer":"cus_<script>","status":"canceled"}}}`
+	assert.Equal(t, http.StatusBadRequest, deliverWebhook(router, payload).Code)
+	event := &models.StripeEvent{}
+	require.NoError(t, db.DB.Where("event_id = ?", "evt_unknown_1").First(event).Error)
+
+	w := sendForm(router, "GET", "/admin/stripe-events", nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "evt_unknown_1")
+	assert.Contains(t, w.Body.String(), fmt.Sprintf("/admin/stripe-events/%d/replay", event.ID))
+	assert.NotContains(t, w.Body.String(), "cus_<script>")
+
+	w = sendForm(router, "GET", "/admin/stripe-events?status=processed", nil, nil)
+	assert.NotContains(t, w.Body.String(), "evt_unknown_1")
+
+	w = sendForm(router, "GET", fmt.Sprintf("/admin/stripe-events/%d", event.ID), nil, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "customer.subscription.deleted")
+	assert.Contains(t, w.Body.String(), "cus_&lt;script&gt;")
+
+	// Replaying it before the cause is fixed fails again
+	w = sendForm(router, "POST", fmt.Sprintf("/admin/stripe-events/%d/replay", event.ID), url.Values{}, nil)
+	assert.Contains(t, w.Header().Get("Location"), "error=")
+	require.NoError(t, db.DB.First(event, event.ID).Error)
+	assert.Equal(t, 2, event.Attempts)
+
+	assert.Equal(t, http.StatusNotFound, sendForm(router, "GET", "/admin/stripe-events/999", nil, nil).Code)
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"github.com/hail2skins/armory/cmd/web/views/data"
+	"github.com/hail2skins/armory/internal/controller"
+	"github.com/hail2skins/armory/internal/database"
+	"github.com/hail2skins/armory/internal/middleware"
+	"github.com/hail2skins/armory/internal/models"
+	"github.com/hail2skins/armory/internal/testutils"
+	"github.com/hail2skins/armory/internal/testutils/testhelper"
+	"github.com/hail2skins/armory/internal/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addCookies adds the cookies set by an earlier response to a request. Like a
+// browser, it keeps the last value when a cookie is set more than once.
+func addCookies(req *http.Request, previous *httptest.ResponseRecorder) {
+	if previous == nil {
+		return
+	}
+	cookies := map[string]*http.Cookie{}
+	for _, c := range previous.Result().Cookies() {
+		cookies[c.Name] = c
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+}
+
+// sendForm posts a form with the session cookie from an earlier response, if there is one
+func sendForm(router *gin.Engine, method, path string, form url.Values, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CSRF-TEST-MODE", "1")
+	addCookies(req, previous)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	db, user, _, router := setupLockoutTest(t)
+	service := testutils.NewTestService(db.DB)
+	authController := controller.NewAuthController(service)
+	authController.RenderTwoFactor = func(c *gin.Context, d interface{}) {
+		c.Writer.WriteString("two-factor:" + d.(data.AuthData).Error)
+	}
+	router.GET("/login/two-factor", authController.TwoFactorHandler)
+	router.POST("/login/two-factor", authController.TwoFactorHandler)
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	step := totp.Step(time.Now())
+	enableCode, _ := totp.Code(secret, step-1)
+	recoveryCodes, err := database.EnableTwoFactor(db.DB, user, secret, enableCode)
+	require.NoError(t, err)
+
+	// The password sends the user on to the second step instead of signing them in
+	w := postLogin(router, user.Email, "Password123!")
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login/two-factor", w.Header().Get("Location"))
+	pending := w
+
+	w = sendForm(router, "GET", "/login/two-factor", nil, pending)
+	assert.Equal(t, "two-factor:", w.Body.String())
+
+	// A wrong code keeps them on the second step
+	w = sendForm(router, "POST", "/login/two-factor", url.Values{"code": {"000000"}}, pending)
+	assert.Contains(t, w.Body.String(), "two-factor:That code didn't work")
+
+	// The code from the authenticator signs them in
+	code, _ := totp.Code(secret, step)
+	w = sendForm(router, "POST", "/login/two-factor", url.Values{"code": {code}}, pending)
+	require.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+
+	// The second step needs the password first
+	w = sendForm(router, "POST", "/login/two-factor", url.Values{"code": {recoveryCodes[0]}}, w)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+	w = sendForm(router, "POST", "/login/two-factor", url.Values{"code": {recoveryCodes[0]}}, nil)
+	assert.Equal(t, "/login", w.Header().Get("Location"))
+
+	// A recovery code works in place of the authenticator
+	pending = postLogin(router, user.Email, "Password123!")
+	w = sendForm(router, "POST", "/login/two-factor", url.Values{"code": {recoveryCodes[0]}}, pending)
+	assert.Equal(t, "/owner", w.Header().Get("Location"))
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	middleware.EnableTestMode()
+	t.Cleanup(middleware.DisableTestMode)
+
+	db := testutils.NewTestDB()
+	service := testutils.NewTestService(db.DB)
+	helper := testhelper.NewControllerTestHelper(db.DB, service)
+	user := helper.CreateTestUser(t)
+	t.Cleanup(func() {
+		helper.CleanupTest()
+		db.Close()
+	})
+
+	ownerController := controller.NewOwnerController(service)
+	router := helper.GetAuthenticatedRouter(user.ID, user.Email)
+	router.GET("/owner/profile/two-factor", ownerController.TwoFactor)
+	router.POST("/owner/profile/two-factor/enable", ownerController.TwoFactorEnable)
+	router.POST("/owner/profile/two-factor/disable", ownerController.TwoFactorDisable)
+	router.POST("/owner/profile/two-factor/recovery-codes", ownerController.TwoFactorRecoveryCodes)
+
+	// The setup page shows a new secret, and keeps it while it's being set up
+	setup := sendForm(router, "GET", "/owner/profile/two-factor", nil, nil)
+	require.Equal(t, http.StatusOK, setup.Code)
+	assert.Contains(t, setup.Body.String(), "otpauth://totp/Virtual%20Armory:")
+	match := regexp.MustCompile(`secret=([A-Z2-7]+)`).FindStringSubmatch(setup.Body.String())
+	require.Len(t, match, 2)
+	secret := match[1]
+	again := sendForm(router, "GET", "/owner/profile/two-factor", nil, setup)
+	assert.Contains(t, again.Body.String(), "secret="+secret)
+
+	// A wrong code doesn't turn it on
+	w := sendForm(router, "POST", "/owner/profile/two-factor/enable", url.Values{"code": {"000000"}}, setup)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "That code didn")
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	w = sendForm(router, "POST", "/owner/profile/two-factor/enable", url.Values{"code": {code}}, setup)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Your Recovery Codes")
+	codes := regexp.MustCompile(`<li>([a-z2-7]{5}-[a-z2-7]{5})</li>`).FindAllStringSubmatch(w.Body.String(), -1)
+	require.Len(t, codes, database.RecoveryCodeCount)
+
+	var stored database.User
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	assert.True(t, stored.TOTPEnabled)
+	assert.Equal(t, secret, stored.TOTPSecret)
+
+	// With it on, the page offers new recovery codes and turning it off
+	w = sendForm(router, "GET", "/owner/profile/two-factor", nil, nil)
+	assert.Contains(t, w.Body.String(), "You have 10 unused recovery codes")
+	assert.NotContains(t, w.Body.String(), "otpauth://")
+
+	w = sendForm(router, "POST", "/owner/profile/two-factor/recovery-codes", nil, nil)
+	assert.Contains(t, w.Body.String(), "Your Recovery Codes")
+	assert.NotContains(t, w.Body.String(), codes[0][1], "The old codes are replaced")
+
+	w = sendForm(router, "POST", "/owner/profile/two-factor/disable", url.Values{"code": {codes[0][1]}}, nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	code, _ = totp.Code(secret, totp.Step(time.Now())+1)
+	w = sendForm(router, "POST", "/owner/profile/two-factor/disable", url.Values{"code": {code}}, nil)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	require.NoError(t, db.DB.First(&stored, user.ID).Error)
+	assert.False(t, stored.TOTPEnabled)
+}
+
+func TestTwoFactorPolicyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := testutils.NewTestDB()
+	t.Cleanup(func() { db.Close() })
+	require.NoError(t, db.DB.AutoMigrate(&models.FeatureFlag{}, &models.FeatureFlagRole{}, &models.CasbinRule{}))
+	service := testutils.NewTestService(db.DB)
+	authController := controller.NewAuthController(service)
+
+	user := &database.User{Email: "policy@example.com", Password: "Password123!", Verified: true}
+	require.NoError(t, db.DB.Create(user).Error)
+
+	// request visits an admin page as the user, holding the given roles
+	request := func(roles ...string) *httptest.ResponseRecorder {
+		require.NoError(t, db.DB.Where("ptype = ? AND v0 = ?", "g", user.Email).Delete(&models.CasbinRule{}).Error)
+		for _, role := range roles {
+			require.NoError(t, db.DB.Create(&models.CasbinRule{Ptype: "g", V0: user.Email, V1: role}).Error)
+		}
+
+		router := gin.New()
+		router.Use(sessions.Sessions("armory-session", cookie.NewStore([]byte("test-secret-key"))))
+		router.Use(func(c *gin.Context) {
+			authData := data.NewAuthData().WithEmail(user.Email)
+			authData.Roles = roles
+			c.Set("authData", authData)
+		})
+		router.Use(authController.TwoFactorPolicyMiddleware())
+		router.GET("/admin/dashboard", func(c *gin.Context) { c.String(http.StatusOK, "dashboard") })
+
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without the policy on, nobody is stopped
+	assert.Equal(t, http.StatusOK, request("admin").Code)
+
+	flag := &models.FeatureFlag{Name: models.FeatureRequireAdminTwoFactor, Enabled: true}
+	require.NoError(t, db.DB.Create(flag).Error)
+	w := request("admin")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/owner/profile/two-factor", w.Header().Get("Location"))
+	assert.Equal(t, http.StatusOK, request().Code, "Users without a role aren't covered")
+
+	// A policy listing roles only covers those roles
+	require.NoError(t, db.DB.Create(&models.FeatureFlagRole{FeatureFlagID: flag.ID, Role: "editor"}).Error)
+	assert.Equal(t, http.StatusOK, request("viewer").Code)
+	assert.Equal(t, http.StatusSeeOther, request("viewer", "editor").Code)
+
+	// Once the user has two-factor authentication they're let through
+	secret, _ := totp.GenerateSecret()
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	_, err := database.EnableTwoFactor(db.DB, user, secret, code)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request("editor").Code)
+
+	// When the policy can't be checked nobody covered by it is let through
+	require.NoError(t, db.DB.Delete(user).Error)
+	assert.Equal(t, http.StatusInternalServerError, request("editor").Code)
+	require.NoError(t, db.DB.Migrator().DropTable(&models.FeatureFlagRole{}))
+	assert.Equal(t, http.StatusInternalServerError, request("editor").Code)
+}